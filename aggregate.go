@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Aggregation is how the samples of a bucket collapse into its min and
+// max: peak takes the largest magnitude both ways, rms the root mean
+// square, and a percentile such as p95 the 5th and 95th percentile
+// samples, so a click doesn't set the envelope of a whole column. Speech
+// tends to read better as rms, music as peak or min/max. A nil
+// aggregation keeps the smallest and largest sample, as always.
+type Aggregation struct {
+	// Name is the aggregation as given, e.g. "p95"
+	Name string
+
+	rms        bool
+	percentile float64 // of a percentile aggregation, in (50, 100]
+}
+
+// parseAggregation parses an -aggregate value: minmax, peak, rms or pNN
+func parseAggregation(s string) (*Aggregation, error) {
+	switch s = strings.ToLower(s); s {
+	case "", "minmax":
+		return nil, nil
+	case "peak":
+		return &Aggregation{Name: s}, nil
+	case "rms":
+		return &Aggregation{Name: s, rms: true}, nil
+	}
+
+	p, err := strconv.ParseFloat(strings.TrimPrefix(s, "p"), 64)
+	if !strings.HasPrefix(s, "p") || err != nil || p <= 50 || p > 100 {
+		return nil, fmt.Errorf("invalid aggregate %q (want minmax, peak, rms or a percentile over 50 such as p95)", s)
+	}
+	return &Aggregation{Name: s, percentile: p}, nil
+}
+
+// cacheKey identifies the aggregation in peak cache keys; min/max adds
+// nothing, so existing entries stay valid
+func (a *Aggregation) cacheKey() string {
+	if a == nil {
+		return ""
+	}
+	return "|aggregate=" + a.Name
+}
+
+// bucketAggregate accumulates the samples of the bucket a PeakBuilder is
+// filling, for aggregations other than min/max
+type bucketAggregate struct {
+	bucket     int
+	count      int
+	sumSquares float64
+	peak       int
+	samples    []int16 // of a percentile aggregation
+}
+
+// reset starts accumulating the given bucket
+func (acc *bucketAggregate) reset(bucket int) {
+	acc.bucket, acc.count, acc.sumSquares, acc.peak = bucket, 0, 0, 0
+	acc.samples = acc.samples[:0]
+}
+
+// add folds one sample into the bucket
+func (acc *bucketAggregate) add(agg *Aggregation, v int16) {
+	acc.count++
+	switch {
+	case agg.percentile > 0:
+		acc.samples = append(acc.samples, v)
+	case agg.rms:
+		acc.sumSquares += float64(v) * float64(v)
+	default:
+		acc.peak = max(acc.peak, abs(int(v)))
+	}
+}
+
+// result returns the min and max of the bucket
+func (acc *bucketAggregate) result(agg *Aggregation) (lo, hi int16) {
+	switch {
+	case agg.percentile > 0:
+		slices.Sort(acc.samples)
+		rank := func(p float64) int16 {
+			i := int(math.Ceil(p/100*float64(len(acc.samples)))) - 1
+			return acc.samples[min(max(i, 0), len(acc.samples)-1)]
+		}
+		return rank(100 - agg.percentile), rank(agg.percentile)
+	case agg.rms:
+		r := int(math.Round(math.Sqrt(acc.sumSquares / float64(acc.count))))
+		return clampInt16(-r), clampInt16(r)
+	default:
+		return clampInt16(-acc.peak), clampInt16(acc.peak)
+	}
+}
+
+// abs returns the magnitude of v
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// albumLabelScale is the size of the track labels of an album image
+	albumLabelScale = 2
+	// albumLabelPad is the space in pixels around a track label
+	albumLabelPad = 6
+)
+
+// albumRuleColor separates the tracks of an album image
+var albumRuleColor = color.RGBA{200, 200, 200, 255}
+
+// albumTrack is one row of an album image
+type albumTrack struct {
+	name     string
+	metadata *AudioMetadata
+	duration time.Duration
+	peaks    ChannelPeaks
+}
+
+// label returns the caption of a track: its tagged artist and title, or
+// its file name when it has no title
+func (t albumTrack) label() string {
+	switch {
+	case t.metadata == nil || t.metadata.Title == "":
+		return strings.TrimSuffix(t.name, filepath.Ext(t.name))
+	case t.metadata.Artist != "":
+		return t.metadata.Artist + " - " + t.metadata.Title
+	}
+	return t.metadata.Title
+}
+
+// albumTracks lists the WAV files directly in dir, in name order
+func albumTracks(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.EqualFold(filepath.Ext(e.Name()), ".wav") {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// drawAlbum draws each track as a row of the given height below a label
+// band naming it and giving its duration
+func drawAlbum(tracks []albumTrack, ro RenderOptions, rowHeight int) (*image.RGBA, error) {
+	labelHeight := textHeight(albumLabelScale) + 2*albumLabelPad
+	trackHeight := labelHeight + rowHeight
+
+	img := image.NewRGBA(image.Rect(0, 0, ro.Width, len(tracks)*trackHeight))
+	draw.Draw(img, img.Bounds(), &image.Uniform{ro.backgroundPixel()}, image.Point{}, draw.Src)
+
+	row := ro
+	row.Height = rowHeight
+	for i, track := range tracks {
+		top := i * trackHeight
+		if i > 0 {
+			draw.Draw(img, image.Rect(0, top, ro.Width, top+1), &image.Uniform{albumRuleColor}, image.Point{}, draw.Src)
+		}
+
+		label := fmt.Sprintf("%d. %s", i+1, track.label())
+		duration := formatDuration(track.duration)
+		drawText(img, albumLabelPad, top+albumLabelPad, label, ro.Foreground, albumLabelScale)
+		drawText(img, ro.Width-albumLabelPad-textWidth(duration, albumLabelScale), top+albumLabelPad, duration, ro.Foreground, albumLabelScale)
+
+		wave, err := drawPeaks(track.peaks, row, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", track.name, err)
+		}
+		draw.Draw(img, image.Rect(0, top+labelHeight, ro.Width, top+trackHeight), wave, image.Point{}, draw.Src)
+		putImage(wave)
+	}
+
+	return img, nil
+}
+
+// runAlbum implements the album subcommand
+func runAlbum(args []string) error {
+	fs := flag.NewFlagSet("album", flag.ExitOnError)
+	output := fs.String("o", "album.png", "album image to write")
+	width := fs.Int("width", 1920, "image width in pixels")
+	rowHeight := fs.Int("row-height", 160, "height in pixels of each track's waveform")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: only_waveform album [flags] directory\n")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("album needs exactly one directory")
+	}
+	if *width <= 0 || *rowHeight <= 0 {
+		return fmt.Errorf("-width and -row-height must be positive")
+	}
+
+	files, err := albumTracks(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no WAV files in %s", fs.Arg(0))
+	}
+
+	opts := Options{Width: *width}
+	tracks := make([]albumTrack, 0, len(files))
+	for _, file := range files {
+		peaks, numSamples, err := decodePeaks(file, opts, nil)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		defer releasePeaks(peaks)
+
+		md, err := readWAVMetadata(file)
+		if err != nil {
+			fmt.Printf("Warning: failed to read tags: %v  %v\n", file, err)
+		}
+		tracks = append(tracks, albumTrack{
+			name:     filepath.Base(file),
+			metadata: md,
+			duration: time.Duration(framesToSeconds(numSamples, peaks.SampleRate) * float64(time.Second)),
+			peaks:    peaks.Channels[0],
+		})
+	}
+
+	ro := DefaultRenderOptions()
+	ro.Width = *width
+	img, err := drawAlbum(tracks, ro, *rowHeight)
+	if err != nil {
+		return err
+	}
+
+	if err := savePNG(img, *output, png.DefaultCompression); err != nil {
+		return err
+	}
+	fmt.Printf("Album of %d tracks: %s\n", len(tracks), *output)
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRunAlbum(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"02 b.wav", "01 a.WAV"} {
+		if err := WriteTestAudioFile(filepath.Join(dir, name), DefaultTestAudio()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files, _ := albumTracks(dir); len(files) != 2 || filepath.Base(files[0]) != "01 a.WAV" {
+		t.Errorf("tracks = %q", files)
+	}
+
+	output := filepath.Join(t.TempDir(), "album.png")
+	if err := runAlbum([]string{"-o", output, "-width", "300", "-row-height", "50", dir}); err != nil {
+		t.Fatal(err)
+	}
+	img, err := readPNG(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two rows, each a label band and a waveform
+	want := 2 * (textHeight(albumLabelScale) + 2*albumLabelPad + 50)
+	if b := img.Bounds(); b.Dx() != 300 || b.Dy() != want {
+		t.Errorf("image is %v, want 300x%d", b, want)
+	}
+
+	if err := runAlbum([]string{"-o", output, t.TempDir()}); err == nil {
+		t.Error("album of an empty directory")
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StreamInfo describes the audio an Analyzer is about to receive
+type StreamInfo struct {
+	SampleRate uint32
+	NumFrames  int // frames declared by the data chunk
+
+	// NumChannels and ChannelMask are the channels of the file and the
+	// speakers they feed, as probing finds them
+	NumChannels int
+	ChannelMask uint32
+
+	// Mono is set when the right samples only repeat the left ones: for
+	// mono files and -channels selections
+	Mono bool
+}
+
+// Analyzer consumes decoded frames alongside peak building and produces one
+// section of the file report
+type Analyzer interface {
+	// Start is called once before the first block
+	Start(info StreamInfo)
+	// Add receives the next block of raw left and right samples
+	Add(left, right []int16)
+	// Result returns the JSON-encodable section for the report
+	Result() any
+}
+
+// AnalysisConfig holds the tunables shared by all analyzers
+type AnalysisConfig struct {
+	SilenceThresholdDB float64
+	SilenceMinDuration time.Duration
+	ClipThresholdDB    float64
+
+	// ImbalanceThresholdDB is the RMS difference between the channels at
+	// which a file is flagged as imbalanced
+	ImbalanceThresholdDB float64
+
+	// NoiseFloorLimitDB is the noise floor in dBFS above which a file is
+	// flagged
+	NoiseFloorLimitDB float64
+
+	// HistogramBins is the number of bins of the amplitude histogram
+	HistogramBins int
+
+	// CorrelationThreshold is the stereo correlation below which windows
+	// are reported as out of phase
+	CorrelationThreshold float64
+}
+
+// DefaultAnalysisConfig returns the defaults used by the CLI
+func DefaultAnalysisConfig() AnalysisConfig {
+	return AnalysisConfig{
+		SilenceThresholdDB: -60,
+		SilenceMinDuration: 500 * time.Millisecond,
+		ClipThresholdDB:    0,
+
+		ImbalanceThresholdDB: 6,
+		NoiseFloorLimitDB:    -60,
+		CorrelationThreshold: -0.5,
+		HistogramBins:        256,
+	}
+}
+
+// analyzerFactories builds analyzers by the name used in -analyze and the report
+var analyzerFactories = map[string]func(cfg AnalysisConfig) Analyzer{
+	"silence":   newSilenceAnalyzer,
+	"clipping":  newClippingAnalyzer,
+	"loudness":  newLoudnessAnalyzer,
+	"true_peak": newTruePeakAnalyzer,
+	"dc_offset": newDCAnalyzer,
+
+	"correlation": newCorrelationAnalyzer,
+	"balance":     newBalanceAnalyzer,
+	"dynamics":    newDynamicsAnalyzer,
+	"noise_floor": newNoiseFloorAnalyzer,
+	"trim":        newTrimAnalyzer,
+
+	"dominant_frequency": newDominantAnalyzer,
+	"fingerprint":        newFingerprintAnalyzer,
+	"segments":           newSegmentAnalyzer,
+	"stats":              newStatsAnalyzer,
+	"histogram":          newHistogramAnalyzer,
+}
+
+// parseAnalyses validates a comma-separated -analyze value; "all" selects every analysis
+func parseAnalyses(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	if s == "all" {
+		names := make([]string, 0, len(analyzerFactories))
+		for name := range analyzerFactories {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names, nil
+	}
+
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := analyzerFactories[name]; !ok {
+			return nil, fmt.Errorf("unknown analysis %q", name)
+		}
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+// namedAnalyzer pairs an analyzer with its report key
+type namedAnalyzer struct {
+	name string
+	Analyzer
+}
+
+// newAnalyzers builds the selected analyzers
+func newAnalyzers(names []string, cfg AnalysisConfig) []namedAnalyzer {
+	analyzers := make([]namedAnalyzer, 0, len(names))
+	for _, name := range names {
+		analyzers = append(analyzers, namedAnalyzer{name: name, Analyzer: analyzerFactories[name](cfg)})
+	}
+	return analyzers
+}
+
+// fileConsumers holds everything fed with decoded blocks of one file
+type fileConsumers struct {
+	report   []namedAnalyzer   // sections of the JSON report
+	rms      *rmsAnalyzer      // series for -rms-window
+	spectrum *spectrumAnalyzer // spectra for -spectrum-size
+	dc       *dcAnalyzer       // offset for -remove-dc
+
+	correlation *correlationAnalyzer // windows for -correlation-strip
+	trim        *trimAnalyzer        // points for -trim-markers
+	dominant    *dominantAnalyzer    // bands for -color-by-frequency
+	segments    *segmentAnalyzer     // classes for -shade-segments
+	histogram   *histogramAnalyzer   // counts for -histogram-panel
+	loudness    *loudnessAnalyzer    // caption for -loudness-caption
+	tiers       *tierAnalyzer        // detail images for -tiers-over
+	scrub       *tierAnalyzer        // mini-waveforms for -scrub-interval
+
+	annotations *annotationMarkers // markers for -annotations
+	transcript  *transcriptStrip   // strip for -transcript
+}
+
+// newFileConsumers builds the consumers the options ask for
+func newFileConsumers(opts Options) *fileConsumers {
+	c := &fileConsumers{report: newAnalyzers(opts.Analyses, opts.Analysis)}
+
+	if opts.RMSWindow > 0 {
+		c.rms = newRMSAnalyzer(opts.RMSWindow)
+	}
+
+	if opts.SpectrumSize > 0 {
+		c.spectrum = newSpectrumAnalyzer(opts.SpectrumSize, opts.SpectrumWindows)
+	}
+
+	if opts.RemoveDC {
+		c.dc = reportedOr(c.report, func() *dcAnalyzer {
+			return newDCAnalyzer(opts.Analysis).(*dcAnalyzer)
+		})
+	}
+
+	if opts.CorrelationStrip {
+		c.correlation = reportedOr(c.report, func() *correlationAnalyzer {
+			return newCorrelationAnalyzer(opts.Analysis).(*correlationAnalyzer)
+		})
+	}
+
+	if opts.TrimMarkers {
+		c.trim = reportedOr(c.report, func() *trimAnalyzer {
+			return newTrimAnalyzer(opts.Analysis).(*trimAnalyzer)
+		})
+	}
+
+	if opts.ColorByFrequency {
+		c.dominant = reportedOr(c.report, func() *dominantAnalyzer {
+			return newDominantAnalyzer(opts.Analysis).(*dominantAnalyzer)
+		})
+		c.dominant.colormap = opts.Colormap
+	}
+
+	if opts.ShadeSegments {
+		c.segments = reportedOr(c.report, func() *segmentAnalyzer {
+			return newSegmentAnalyzer(opts.Analysis).(*segmentAnalyzer)
+		})
+	}
+
+	if opts.HistogramPanel {
+		c.histogram = reportedOr(c.report, func() *histogramAnalyzer {
+			return newHistogramAnalyzer(opts.Analysis).(*histogramAnalyzer)
+		})
+	}
+
+	if opts.LoudnessCaption {
+		c.loudness = reportedOr(c.report, func() *loudnessAnalyzer {
+			return newLoudnessAnalyzer(opts.Analysis).(*loudnessAnalyzer)
+		})
+	}
+
+	if opts.TiersOver > 0 {
+		c.tiers = newTierAnalyzer(opts)
+	}
+
+	if opts.ScrubInterval > 0 {
+		c.scrub = newScrubAnalyzer(opts)
+	}
+
+	return c
+}
+
+// all returns every consumer that must see the decoded blocks
+func (c *fileConsumers) all() []namedAnalyzer {
+	all := append([]namedAnalyzer(nil), c.report...)
+	if c.rms != nil {
+		all = append(all, namedAnalyzer{name: "rms", Analyzer: c.rms})
+	}
+	if c.spectrum != nil {
+		all = append(all, namedAnalyzer{name: "spectrum", Analyzer: c.spectrum})
+	}
+	if c.dc != nil && !containsAnalyzer(c.report, c.dc) {
+		all = append(all, namedAnalyzer{name: "dc_offset", Analyzer: c.dc})
+	}
+	if c.correlation != nil && !containsAnalyzer(c.report, c.correlation) {
+		all = append(all, namedAnalyzer{name: "correlation", Analyzer: c.correlation})
+	}
+	if c.trim != nil && !containsAnalyzer(c.report, c.trim) {
+		all = append(all, namedAnalyzer{name: "trim", Analyzer: c.trim})
+	}
+	if c.dominant != nil && !containsAnalyzer(c.report, c.dominant) {
+		all = append(all, namedAnalyzer{name: "dominant_frequency", Analyzer: c.dominant})
+	}
+	if c.segments != nil && !containsAnalyzer(c.report, c.segments) {
+		all = append(all, namedAnalyzer{name: "segments", Analyzer: c.segments})
+	}
+	if c.histogram != nil && !containsAnalyzer(c.report, c.histogram) {
+		all = append(all, namedAnalyzer{name: "histogram", Analyzer: c.histogram})
+	}
+	if c.loudness != nil && !containsAnalyzer(c.report, c.loudness) {
+		all = append(all, namedAnalyzer{name: "loudness", Analyzer: c.loudness})
+	}
+	if c.tiers != nil {
+		all = append(all, namedAnalyzer{name: "tiers", Analyzer: c.tiers})
+	}
+	if c.scrub != nil {
+		all = append(all, namedAnalyzer{name: "scrub", Analyzer: c.scrub})
+	}
+	return all
+}
+
+// decorations returns what to draw over and around the waveform
+func (c *fileConsumers) decorations() decorations {
+	var d decorations
+
+	if c.segments != nil {
+		d.overlays = append(d.overlays, c.segments)
+	}
+	// Coloring goes before markers so they keep their color
+	if c.dominant != nil {
+		d.overlays = append(d.overlays, c.dominant)
+	}
+	if c.trim != nil {
+		d.overlays = append(d.overlays, c.trim)
+	}
+	if c.annotations != nil {
+		d.overlays = append(d.overlays, c.annotations)
+	}
+	// The caption goes last so nothing is drawn over its text
+	if c.loudness != nil {
+		d.overlays = append(d.overlays, c.loudness)
+	}
+
+	if c.correlation != nil {
+		d.strips = append(d.strips, c.correlation)
+	}
+	if c.transcript != nil {
+		d.strips = append(d.strips, c.transcript)
+	}
+
+	if c.histogram != nil {
+		d.panels = append(d.panels, c.histogram)
+	}
+
+	return d
+}
+
+// reportedOr returns the report analyzer of type T when one was requested,
+// so a measurement is shared rather than run twice, or else a new one
+func reportedOr[T Analyzer](report []namedAnalyzer, create func() T) T {
+	for _, a := range report {
+		if t, ok := a.Analyzer.(T); ok {
+			return t
+		}
+	}
+	return create()
+}
+
+// containsAnalyzer reports whether a is one of analyzers
+func containsAnalyzer(analyzers []namedAnalyzer, a Analyzer) bool {
+	for _, n := range analyzers {
+		if n.Analyzer == a {
+			return true
+		}
+	}
+	return false
+}
+
+// analysisResults collects the result of every analyzer by name
+func analysisResults(analyzers []namedAnalyzer) map[string]any {
+	if len(analyzers) == 0 {
+		return nil
+	}
+
+	results := make(map[string]any, len(analyzers))
+	for _, a := range analyzers {
+		results[a.name] = a.Result()
+	}
+	return results
+}
+
+// FileReport is the JSON metadata written alongside a file's waveform
+type FileReport struct {
+	Input      string          `json:"input"`
+	Output     string          `json:"output,omitempty"`
+	SampleRate uint32          `json:"sample_rate"`
+	Frames     int             `json:"frames"`
+	Duration   float64         `json:"duration_seconds"`
+	Formatted  FormattedFields `json:"formatted"`
+	// SamplesPerPixel is how many frames each column of the image covers
+	SamplesPerPixel float64 `json:"samples_per_pixel"`
+	// InputSHA256 is the checksum of the input, when verified or recorded
+	InputSHA256 string         `json:"input_sha256,omitempty"`
+	Metadata    *AudioMetadata `json:"metadata,omitempty"`
+	Analysis    map[string]any `json:"analysis,omitempty"`
+}
+
+// writeReport writes a report as indented JSON to the named file
+func writeReport(filename string, report *FileReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+
+	if err := atomicWriteFile(filename, append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+
+	return nil
+}
+
+// dbToAmplitude converts dBFS to a 16-bit sample magnitude
+func dbToAmplitude(db float64) float64 {
+	return math.Pow(10, db/20) * 32767.0
+}
+
+// framesToSeconds converts a frame offset to seconds
+func framesToSeconds(frames int, sampleRate uint32) float64 {
+	if sampleRate == 0 {
+		return 0
+	}
+	return float64(frames) / float64(sampleRate)
+}
+
+// abs16 returns the magnitude of a 16-bit sample without overflowing
+func abs16(v int16) int32 {
+	if v < 0 {
+		return -int32(v)
+	}
+	return int32(v)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// analyze runs one named analyzer over a fixture and returns its result
+func analyze(t *testing.T, audio TestAudio, name string) any {
+	t.Helper()
+
+	analyzers := newAnalyzers([]string{name}, DefaultAnalysisConfig())
+	if _, _, err := decodePeaks(writeFixture(t, audio), Options{Width: 100}, analyzers); err != nil {
+		t.Fatalf("decodePeaks: %v", err)
+	}
+	return analyzers[0].Result()
+}
+
+// near reports whether got is within tol of want
+func near(got, want, tol float64) bool {
+	return math.Abs(got-want) <= tol
+}
+
+func TestAnalyzers(t *testing.T) {
+	sine := DefaultTestAudio()
+
+	quiet := DefaultTestAudio()
+	quiet.Amplitude = 0.0001 // -80 dBFS, below the -60 dBFS silence threshold
+
+	fullSquare := DefaultTestAudio()
+	fullSquare.Waveform = "square"
+	fullSquare.Amplitude = 1
+
+	halfSquare := DefaultTestAudio()
+	halfSquare.Waveform = "square"
+	halfSquare.Amplitude = 0.5
+
+	longSine := DefaultTestAudio()
+	longSine.Duration = 7 * time.Second
+
+	tests := []struct {
+		name     string
+		analysis string
+		audio    TestAudio
+		check    func(t *testing.T, result any)
+	}{
+		{"silence in a tone", "silence", sine, func(t *testing.T, result any) {
+			r := result.(SilenceReport)
+			if r.AllSilent || r.Leading != 0 || r.Trailing != 0 || len(r.Gaps) != 0 {
+				t.Errorf("got %+v, want no silence", r)
+			}
+		}},
+		{"silence of a quiet file", "silence", quiet, func(t *testing.T, result any) {
+			if r := result.(SilenceReport); !r.AllSilent {
+				t.Errorf("got %+v, want all silent", r)
+			}
+		}},
+		{"clipping of a full scale square", "clipping", fullSquare, func(t *testing.T, result any) {
+			r := result.(ClippingReport)
+			for _, c := range r.Channels {
+				if !near(c.ClippedPercent, 100, 0.01) {
+					t.Errorf("%s: %.2f%% clipped, want 100%%", c.Channel, c.ClippedPercent)
+				}
+			}
+		}},
+		{"clipping of a tone", "clipping", sine, func(t *testing.T, result any) {
+			r := result.(ClippingReport)
+			if r.RegionCount != 0 {
+				t.Errorf("got %d clipped regions, want none", r.RegionCount)
+			}
+		}},
+		{"true peak of a tone", "true_peak", sine, func(t *testing.T, result any) {
+			for _, c := range result.(TruePeakReport).Channels {
+				want := 20 * math.Log10(0.8)
+				if c.SamplePeakDBFS == nil || !near(*c.SamplePeakDBFS, want, 0.05) {
+					t.Errorf("%s: sample peak %v, want %.2f dBFS", c.Channel, c.SamplePeakDBFS, want)
+				}
+				if c.TruePeakDBTP == nil || *c.TruePeakDBTP < *c.SamplePeakDBFS-0.01 {
+					t.Errorf("%s: true peak %v below the sample peak", c.Channel, c.TruePeakDBTP)
+				}
+			}
+		}},
+		{"dc offset of a tone", "dc_offset", sine, func(t *testing.T, result any) {
+			for _, c := range result.(DCOffsetReport).Channels {
+				if !near(c.Offset, 0, 1e-4) {
+					t.Errorf("%s: offset %v, want 0", c.Channel, c.Offset)
+				}
+			}
+		}},
+		{"stats of a tone", "stats", sine, func(t *testing.T, result any) {
+			for _, c := range result.(StatsReport).Channels {
+				if !near(c.RMS, 0.8/math.Sqrt2, 0.005) {
+					t.Errorf("%s: RMS %.4f, want %.4f", c.Channel, c.RMS, 0.8/math.Sqrt2)
+				}
+				if !near(c.Mean, 0, 1e-4) {
+					t.Errorf("%s: mean %v, want 0", c.Channel, c.Mean)
+				}
+				// A 440 Hz tone crosses zero twice per cycle
+				if !near(c.ZeroCrossingRate, 880, 2) {
+					t.Errorf("%s: zero crossing rate %.1f, want 880", c.Channel, c.ZeroCrossingRate)
+				}
+			}
+		}},
+		{"crest factor of a tone", "dynamics", longSine, func(t *testing.T, result any) {
+			for _, c := range result.(DynamicsReport).Channels {
+				if c.CrestFactor == nil || !near(*c.CrestFactor, 3.01, 0.05) {
+					t.Errorf("%s: crest factor %v, want 3.01 dB", c.Channel, c.CrestFactor)
+				}
+			}
+		}},
+		{"trim of a tone", "trim", sine, func(t *testing.T, result any) {
+			r := result.(TrimReport)
+			if r.TrimIn == nil || r.TrimOut == nil || !near(*r.TrimIn, 0, 0.001) || !near(*r.TrimOut, 1, 0.001) {
+				t.Errorf("got %v..%v, want 0..1", r.TrimIn, r.TrimOut)
+			}
+		}},
+		{"trim of a quiet file", "trim", quiet, func(t *testing.T, result any) {
+			if r := result.(TrimReport); r.TrimIn != nil || r.TrimOut != nil {
+				t.Errorf("got %v..%v, want no trim points", r.TrimIn, r.TrimOut)
+			}
+		}},
+		{"histogram of a square", "histogram", halfSquare, func(t *testing.T, result any) {
+			for _, c := range result.(HistogramReport).Channels {
+				if c.UsedLevels != 2 {
+					t.Errorf("%s: %d levels used, want 2", c.Channel, c.UsedLevels)
+				}
+			}
+		}},
+		{"balance of a tone", "balance", sine, func(t *testing.T, result any) {
+			r := result.(BalanceReport)
+			if r.Imbalanced || r.RMSDifference == nil || !near(*r.RMSDifference, 0, 0.001) {
+				t.Errorf("got %+v, want balanced channels", r)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.check(t, analyze(t, tt.audio, tt.analysis))
+		})
+	}
+}
+
+func TestParseAnalyses(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"silence", 1, false},
+		{"silence, clipping", 2, false},
+		{"all", len(analyzerFactories), false},
+		{"silence,nope", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			names, err := parseAnalyses(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(names) != tt.want {
+				t.Errorf("got %d analyses, want %d", len(names), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Colors of annotation markers: the marker line and label, and the tint
+// of regions
+var (
+	annotationColor       = color.RGBA{255, 170, 0, 255}
+	annotationRegionColor = color.RGBA{255, 170, 0, 48}
+)
+
+// maxAnnotationRows bounds the rows labels are staggered over before the
+// labels that still overlap are left out, keeping their markers
+const maxAnnotationRows = 3
+
+// Annotation is a labelled point or region of a file, in seconds from its
+// start
+type Annotation struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end,omitempty"` // past Start for a region
+	Label string  `json:"label"`
+}
+
+// sidecarFile returns the file of an input that pattern names, with {name}
+// replaced by the input's name without its extension as in hooks
+func sidecarFile(pattern string, input batchInput) string {
+	return strings.ReplaceAll(pattern, "{name}", strings.TrimSuffix(input.Name, filepath.Ext(input.Name)))
+}
+
+// missingSidecar reports whether err says an input has no file of its own
+// for a pattern naming one per input, which isn't worth a warning
+func missingSidecar(err error, pattern, filename string) bool {
+	return errors.Is(err, fs.ErrNotExist) && filename != pattern
+}
+
+// loadInputAnnotations returns the markers of -annotations for an input,
+// or nil when there are none. Files that can't be read are warned about
+// rather than failing the render.
+func loadInputAnnotations(input batchInput, opts Options) *annotationMarkers {
+	filename := sidecarFile(opts.Annotations, input)
+	annotations, err := loadAnnotations(filename)
+	switch {
+	case missingSidecar(err, opts.Annotations, filename):
+		return nil
+	case err != nil:
+		fmt.Printf("Warning: failed to read annotations: %v  %v\n", input.Location, err)
+		return nil
+	}
+	return &annotationMarkers{annotations: annotations, offset: (opts.Start + input.Offset).Seconds()}
+}
+
+// loadAnnotations reads an annotation file by its extension: .json for a
+// list of {"start" or "time", "end", "label"} objects, alone or under
+// "markers", .csv for DAW marker exports with Name and Start columns and
+// anything else as an Audacity label track
+func loadAnnotations(filename string) ([]Annotation, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var annotations []Annotation
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		annotations, err = parseJSONAnnotations(file)
+	case ".csv":
+		annotations, err = parseMarkerCSV(file)
+	default:
+		annotations, err = parseAudacityLabels(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return annotations, nil
+}
+
+// parseJSONAnnotations parses the simple JSON annotation format
+func parseJSONAnnotations(r io.Reader) ([]Annotation, error) {
+	type entry struct {
+		Time  *float64 `json:"time"`
+		Start *float64 `json:"start"`
+		End   float64  `json:"end"`
+		Label string   `json:"label"`
+		Name  string   `json:"name"`
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var entries []entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		var wrapped struct {
+			Markers *[]entry `json:"markers"`
+		}
+		if json.Unmarshal(data, &wrapped) != nil || wrapped.Markers == nil {
+			return nil, fmt.Errorf("want a list of markers: %w", err)
+		}
+		entries = *wrapped.Markers
+	}
+
+	annotations := make([]Annotation, 0, len(entries))
+	for i, e := range entries {
+		a := Annotation{End: e.End, Label: e.Label}
+		switch {
+		case e.Start != nil:
+			a.Start = *e.Start
+		case e.Time != nil:
+			a.Start = *e.Time
+		default:
+			return nil, fmt.Errorf("marker %d has no start or time", i+1)
+		}
+		if a.Label == "" {
+			a.Label = e.Name
+		}
+		annotations = append(annotations, a)
+	}
+	return annotations, nil
+}
+
+// parseAudacityLabels parses an Audacity label track export: a start and
+// end in seconds and a label, tab separated, per line. The lines of
+// spectral selections, starting with \, are skipped.
+func parseAudacityLabels(r io.Reader) ([]Annotation, error) {
+	var annotations []Annotation
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, `\`) {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: want start, end and label separated by tabs", n)
+		}
+		start, err := parseLabelSeconds(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		end, err := parseLabelSeconds(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		a := Annotation{Start: start, End: end}
+		if len(fields) == 3 {
+			a.Label = fields[2]
+		}
+		annotations = append(annotations, a)
+	}
+	return annotations, scanner.Err()
+}
+
+// parseLabelSeconds parses the seconds of an Audacity label, which are
+// written with the decimal comma of some locales
+func parseLabelSeconds(s string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(s), ",", "."), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return v, nil
+}
+
+// parseMarkerCSV parses a marker or region export of a DAW, such as
+// REAPER's region/marker manager: a header row naming the Name (or Label)
+// and Start (or Time or Position) columns and optionally End, then a row
+// per marker
+func parseMarkerCSV(r io.Reader) ([]Annotation, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	name, start, end := -1, -1, -1
+	for i, column := range records[0] {
+		switch strings.ToLower(strings.TrimSpace(column)) {
+		case "name", "label":
+			name = i
+		case "start", "time", "position":
+			start = i
+		case "end":
+			end = i
+		}
+	}
+	if start < 0 {
+		return nil, fmt.Errorf("no Start column in the header row %q", strings.Join(records[0], ","))
+	}
+
+	var annotations []Annotation
+	for n, record := range records[1:] {
+		field := func(i int) string {
+			if i < 0 || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		var a Annotation
+		if a.Start, err = parseTimestamp(field(start)); err != nil {
+			return nil, fmt.Errorf("row %d: %w", n+2, err)
+		}
+		if v := field(end); v != "" {
+			if a.End, err = parseTimestamp(v); err != nil {
+				return nil, fmt.Errorf("row %d: %w", n+2, err)
+			}
+		}
+		a.Label = field(name)
+		annotations = append(annotations, a)
+	}
+	return annotations, nil
+}
+
+// parseTimestamp parses a time as seconds, minutes:seconds or
+// hours:minutes:seconds, with fractions of a second
+func parseTimestamp(s string) (float64, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 || strings.Count(s, ".") > 1 {
+		return 0, fmt.Errorf("invalid time %q (want seconds, m:ss or h:mm:ss; export markers in minutes:seconds rather than bars or timecode)", s)
+	}
+	seconds := 0.0
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 || (i < len(parts)-1 && v != float64(int(v))) {
+			return 0, fmt.Errorf("invalid time %q (want seconds, m:ss or h:mm:ss)", s)
+		}
+		seconds = seconds*60 + v
+	}
+	return seconds, nil
+}
+
+// annotationMarkers draws annotations over the waveform: a marker line at
+// each one, a tint over regions and labels at the top, staggered over a
+// few rows where they would overlap
+type annotationMarkers struct {
+	annotations []Annotation
+
+	// offset and span are the seconds of the file the image starts at and
+	// covers, set once the peaks are loaded
+	offset, span float64
+}
+
+// drawOverlay implements imageOverlay
+func (m *annotationMarkers) drawOverlay(img *image.RGBA) {
+	if m.span <= 0 {
+		return
+	}
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	column := func(t float64) int {
+		return int((t - m.offset) / m.span * float64(width))
+	}
+
+	scale := 1
+	if bounds.Dy() >= 200 {
+		scale = 2
+	}
+	rowEnds := make([]int, maxAnnotationRows)
+	for i := range rowEnds {
+		rowEnds[i] = -1
+	}
+
+	for _, a := range m.annotations {
+		x := column(a.Start)
+		if x >= width || (a.End <= a.Start && x < 0) {
+			continue
+		}
+		if a.End > a.Start {
+			end := min(column(a.End), width)
+			if end < 0 {
+				continue
+			}
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for px := max(x, 0); px < end; px++ {
+					blendPixel(img, bounds.Min.X+px, y, annotationRegionColor)
+				}
+			}
+		}
+		x = max(x, 0)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			img.SetRGBA(bounds.Min.X+x, y, annotationColor)
+		}
+
+		if a.Label == "" {
+			continue
+		}
+		// Labels hang right of their marker, or left of it at the right edge
+		box := captionBox(a.Label, scale)
+		left := x + 1
+		if left+box.Dx() > width {
+			left = max(x-box.Dx(), 0)
+		}
+		for row, rowEnd := range rowEnds {
+			if left > rowEnd {
+				corner := bounds.Min.Add(image.Pt(left, captionMargin+row*box.Dy()))
+				drawCaption(img, corner, a.Label, annotationColor, backgroundColor, scale)
+				rowEnds[row] = left + box.Dx() + scale
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseAnnotations(t *testing.T) {
+	tests := []struct {
+		file, content string
+		want          []Annotation
+	}{
+		{"a.json", `[{"time": 1.5, "label": "verse"}, {"start": 3, "end": 4.25, "name": "solo"}]`,
+			[]Annotation{{Start: 1.5, Label: "verse"}, {Start: 3, End: 4.25, Label: "solo"}}},
+		{"a.json", `{"markers": [{"time": 0, "label": "top"}]}`,
+			[]Annotation{{Label: "top"}}},
+		{"labels.txt", "1.000000\t1.000000\tcue\n\\t862.5\t1200\n2,5\t3,5\tchorus\r\n",
+			[]Annotation{{Start: 1, End: 1, Label: "cue"}, {Start: 2.5, End: 3.5, Label: "chorus"}}},
+		{"markers.csv", "#,Name,Start,End,Length\nM1,Intro,0:00.000,,\nR1,Bridge,1:02.5,1:10,0:07.5\n",
+			[]Annotation{{Start: 0, Label: "Intro"}, {Start: 62.5, End: 70, Label: "Bridge"}}},
+	}
+	for _, tt := range tests {
+		file := filepath.Join(t.TempDir(), tt.file)
+		os.WriteFile(file, []byte(tt.content), 0o644)
+		got, err := loadAnnotations(file)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %q: got %v, %v; want %v", tt.file, tt.content, got, err, tt.want)
+		}
+	}
+
+	for file, content := range map[string]string{
+		"bad.json":  `{"label": "x"}`,
+		"none.json": `[{"label": "no time"}]`,
+		"bad.txt":   "1.0 no tabs\n",
+		"bars.csv":  "Name,Start\nIntro,1.1.00\n",
+		"head.csv":  "Name,Length\nIntro,1\n",
+	} {
+		path := filepath.Join(t.TempDir(), file)
+		os.WriteFile(path, []byte(content), 0o644)
+		if _, err := loadAnnotations(path); err == nil {
+			t.Errorf("%s %q parsed", file, content)
+		}
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	for s, want := range map[string]float64{"12.5": 12.5, "1:02.25": 62.25, "1:00:00": 3600} {
+		if got, err := parseTimestamp(s); err != nil || got != want {
+			t.Errorf("%q: %v, %v; want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "1.2.3", "1:2:3:4", "1.5:00", "-1"} {
+		if _, err := parseTimestamp(s); err == nil {
+			t.Errorf("%q parsed", s)
+		}
+	}
+}
+
+func TestAnnotationMarkers(t *testing.T) {
+	m := &annotationMarkers{
+		annotations: []Annotation{{Start: 11, Label: "a"}, {Start: 12, End: 14}, {Start: 30, Label: "out"}},
+		offset:      10,
+		span:        10,
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 100, 40))
+	m.drawOverlay(img)
+
+	if img.RGBAAt(10, 39) != annotationColor || img.RGBAAt(20, 39) != annotationColor {
+		t.Error("markers not drawn at the columns of their times")
+	}
+	if c := img.RGBAAt(30, 39); c.A == 0 || c == annotationColor {
+		t.Errorf("region not tinted: %v", c)
+	}
+	if c := img.RGBAAt(50, 39); c.A != 0 {
+		t.Errorf("outside the region: %v", c)
+	}
+	// The label hangs right of its marker at the top
+	labelled := false
+	for x := 11; x < 20; x++ {
+		for y := range 12 {
+			labelled = labelled || img.RGBAAt(x, y) == annotationColor
+		}
+	}
+	if !labelled {
+		t.Error("label not drawn")
+	}
+}
+
+func TestAnnotationsRender(t *testing.T) {
+	input := writeFixture(t, DefaultTestAudio())
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "fixture.txt"), []byte("0.5\t0.5\tmid\n"), 0o644)
+
+	opts := Options{Width: 100, Height: 20, Annotations: filepath.Join(dir, "{name}.txt")}
+	in := batchInput{Name: "fixture.wav", Path: input, Location: input}
+	result, err := GenerateStereoWaveforms(in, t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(result.Outputs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	img, err := png.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := color.RGBAModel.Convert(img.At(50, 19)); c != annotationColor {
+		t.Errorf("no marker at the middle: %v", c)
+	}
+
+	// Inputs without a file of their own render unmarked
+	in.Name = "other.wav"
+	if _, err := GenerateStereoWaveforms(in, t.TempDir(), opts); err != nil {
+		t.Error(err)
+	}
+}
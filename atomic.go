@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// atomicWrite writes the named file through write. The data goes to a
+// temporary file in the same directory that is synced and renamed into
+// place once write succeeds, so a crash, timeout or full disk leaves the
+// previous file, or none, but never a truncated one for readers to pick up.
+func atomicWrite(filename string, write func(file *os.File) error) error {
+	file, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	// CreateTemp makes the file private; match what os.Create would give
+	if err := file.Chmod(0644); err != nil {
+		return err
+	}
+
+	if err := write(file); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filename)
+}
+
+// atomicWriteFile writes data to the named file as atomicWrite does
+func atomicWriteFile(filename string, data []byte) error {
+	return atomicWrite(filename, func(file *os.File) error {
+		_, err := file.Write(data)
+		return err
+	})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAtomicWrite(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "take.png")
+	if err := atomicWriteFile(filename, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A write failing halfway leaves the previous file and no temporary one
+	full := errors.New("no space left on device")
+	err := atomicWrite(filename, func(file *os.File) error {
+		file.Write([]byte("trunc"))
+		return full
+	})
+	if !errors.Is(err, full) {
+		t.Fatalf("err = %v, want %v", err, full)
+	}
+	if data, _ := os.ReadFile(filename); string(data) != "first" {
+		t.Errorf("file = %q after a failed write, want the previous contents", data)
+	}
+
+	if err := atomicWriteFile(filename, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filename); string(data) != "second" {
+		t.Errorf("file = %q, want the new contents", data)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d files, want only take.png", len(entries))
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("mode = %v, want 0644", info.Mode())
+	}
+}
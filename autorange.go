@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// defaultHeadroomDB is the space left above the loudest peak of an
+// auto-ranged waveform
+const defaultHeadroomDB = 1.0
+
+// maxHeadroomDB bounds the headroom, past which the waveform would be a line
+const maxHeadroomDB = 60.0
+
+// gain returns the factor amplitudes are scaled by when drawing peaks: 1,
+// Gain when it is set, or what makes the loudest peak fill the height with
+// Normalize, or fill all but HeadroomDB of it with AutoRange
+func (ro RenderOptions) gain(peaks ChannelPeaks) float64 {
+	switch {
+	case ro.Gain > 0:
+		return ro.Gain
+	case ro.AutoRange:
+		return normalizeGain(peaks) * math.Pow(10, -ro.HeadroomDB/20)
+	case ro.Normalize:
+		return normalizeGain(peaks)
+	}
+	return 1
+}
+
+// captionsScale reports whether the level of the image edges is annotated
+func (ro RenderOptions) captionsScale() bool {
+	return ro.AutoRange || ro.Gain > 0
+}
+
+// scaleCaption returns the annotation of an auto-ranged waveform: the level
+// the top and bottom edges of the image stand for
+func scaleCaption(gain float64) string {
+	return fmt.Sprintf("FULL SCALE %.1f dBFS", -20*math.Log10(gain))
+}
+
+// drawScaleCaption annotates the top right corner of an auto-ranged
+// waveform drawn with gain. The text is doubled in size on images tall
+// enough for it, as the loudness caption is.
+func drawScaleCaption(img *image.RGBA, ro RenderOptions, gain float64) {
+	bounds := img.Bounds()
+	scale := 1
+	if bounds.Dy() >= 200 {
+		scale = 2
+	}
+
+	text := scaleCaption(gain)
+	corner := image.Pt(bounds.Max.X-captionMargin-captionBox(text, scale).Dx(), bounds.Min.Y+captionMargin)
+	drawCaption(img, corner, text, ro.Foreground, ro.Background, scale)
+}
+
+// parseHeadroom validates a headroom in dB
+func parseHeadroom(db float64) (float64, error) {
+	if math.IsNaN(db) || db < 0 || db > maxHeadroomDB {
+		return 0, fmt.Errorf("headroom must be between 0 and %g dB", maxHeadroomDB)
+	}
+	return db, nil
+}
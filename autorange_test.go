@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestAutoRangeGain(t *testing.T) {
+	// The loudest peak is at -20 dBFS
+	peaks := ChannelPeaks{Min: []int16{-1000, -3277}, Max: []int16{1000, 2000}}
+	ro := DefaultRenderOptions()
+	if g := ro.gain(peaks); g != 1 {
+		t.Errorf("gain without auto range = %g, want 1", g)
+	}
+
+	ro.AutoRange = true
+	g := ro.gain(peaks)
+	if want := 32767.0 / 3277 * math.Pow(10, -defaultHeadroomDB/20); math.Abs(g-want) > 1e-9 {
+		t.Errorf("gain = %g, want %g", g, want)
+	}
+	if got := scaleCaption(g); got != "FULL SCALE -19.0 dBFS" {
+		t.Errorf("caption = %q", got)
+	}
+
+	for _, bad := range []float64{-1, 61, math.NaN()} {
+		if _, err := parseHeadroom(bad); err == nil {
+			t.Errorf("headroom %g accepted", bad)
+		}
+	}
+}
+
+func TestDrawPeaksAutoRange(t *testing.T) {
+	peaks := ChannelPeaks{Min: []int16{-3277, -3277}, Max: []int16{3277, 3277}}
+	ro := DefaultRenderOptions()
+	ro.Width, ro.Height = 300, 400
+	ro.AutoRange = true
+
+	img, err := drawPeaks(peaks, ro, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer putImage(img)
+
+	// 1 dB of headroom leaves about 22 of the 200 rows above the center
+	if img.RGBAAt(0, 25) != ro.Foreground || img.RGBAAt(0, 19) != ro.Background {
+		t.Error("waveform not scaled to its peak with 1 dB of headroom")
+	}
+	// The caption sits in the top right corner, above the waveform
+	box := captionBox(scaleCaption(ro.gain(peaks)), 2)
+	inked := false
+	for y := captionMargin; y < captionMargin+box.Dy(); y++ {
+		for x := ro.Width - captionMargin - box.Dx(); x < ro.Width-captionMargin; x++ {
+			inked = inked || img.RGBAAt(x, y) == ro.Foreground
+		}
+	}
+	if !inked {
+		t.Error("no scale caption drawn")
+	}
+
+	rowMajor, err := rowMajorBackend{}.Draw(peaks, ro, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer putImage(rowMajor)
+	if !bytes.Equal(rowMajor.Pix, img.Pix) {
+		t.Error("row-major renderer output differs")
+	}
+}
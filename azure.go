@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// azureAPIVersion is the Blob service REST version requests are made with
+const azureAPIVersion = "2021-08-06"
+
+// azureStorage is a prefix in an Azure Blob Storage container
+type azureStorage struct {
+	account   string
+	key       []byte // shared key; nil when a SAS token is used
+	sas       string
+	endpoint  string
+	container string
+	prefix    string
+	http      *http.Client
+}
+
+// newAzureStorage opens an az://container/prefix location in the account
+// named by AZURE_STORAGE_ACCOUNT, authenticating with AZURE_STORAGE_KEY or
+// AZURE_STORAGE_SAS_TOKEN. AZURE_STORAGE_ENDPOINT overrides the account URL,
+// e.g. http://127.0.0.1:10000/devstoreaccount1 for Azurite.
+func newAzureStorage(location string) (*azureStorage, error) {
+	container, prefix, err := splitBucketURL(location, "az://")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &azureStorage{
+		account:   os.Getenv("AZURE_STORAGE_ACCOUNT"),
+		sas:       strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?"),
+		endpoint:  strings.TrimSuffix(os.Getenv("AZURE_STORAGE_ENDPOINT"), "/"),
+		container: container,
+		prefix:    prefix,
+		http:      http.DefaultClient,
+	}
+	if s.account == "" {
+		return nil, fmt.Errorf("Azure Blob Storage needs AZURE_STORAGE_ACCOUNT")
+	}
+	if s.endpoint == "" {
+		s.endpoint = "https://" + s.account + ".blob.core.windows.net"
+	}
+
+	if key := os.Getenv("AZURE_STORAGE_KEY"); key != "" {
+		s.key, err = base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("AZURE_STORAGE_KEY is not base64: %w", err)
+		}
+	} else if s.sas == "" {
+		return nil, fmt.Errorf("Azure Blob Storage needs AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN")
+	}
+	return s, nil
+}
+
+// blobURL returns the URL of a blob in the container, or of the container
+// itself for an empty name, with the given query
+func (s *azureStorage) blobURL(name string, q url.Values) string {
+	u := s.endpoint + "/" + s.container
+	if name != "" {
+		u += "/" + s3EscapePath(joinKey(s.prefix, name))
+	}
+
+	query := q.Encode()
+	if s.key == nil {
+		query = strings.Trim(query+"&"+s.sas, "&")
+	}
+	if query != "" {
+		u += "?" + query
+	}
+	return u
+}
+
+// do authenticates and sends a request, turning error responses into errors
+func (s *azureStorage) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("X-Ms-Version", azureAPIVersion)
+	if s.key != nil {
+		req.Header.Set("Authorization", "SharedKey "+s.account+":"+s.signature(req))
+	}
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// signature computes the Shared Key signature of a request
+func (s *azureStorage) signature(req *http.Request) string {
+	length := ""
+	if req.ContentLength > 0 {
+		length = strconv.FormatInt(req.ContentLength, 10)
+	}
+
+	var b strings.Builder
+	for _, part := range []string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		length,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date; x-ms-date is used instead
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	} {
+		b.WriteString(part + "\n")
+	}
+
+	// Canonicalized x-ms- headers
+	var names []string
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-ms-") {
+			names = append(names, lower)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+
+	// Canonicalized resource: account, path, then the sorted query
+	b.WriteString("/" + s.account + req.URL.EscapedPath())
+	q := req.URL.Query()
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		values := append([]string(nil), q[k]...)
+		sort.Strings(values)
+		b.WriteString("\n" + strings.ToLower(k) + ":" + strings.Join(values, ","))
+	}
+
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(b.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *azureStorage) List(ctx context.Context) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	marker := ""
+
+	for {
+		q := url.Values{"restype": {"container"}, "comp": {"list"}, "delimiter": {"/"}}
+		if s.prefix != "" {
+			q.Set("prefix", listPrefix(s.prefix))
+		}
+		if marker != "" {
+			q.Set("marker", marker)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.blobURL("", q), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Blobs []struct {
+				Name string
+				Size int64  `xml:"Properties>Content-Length"`
+				ETag string `xml:"Properties>Etag"`
+			} `xml:"Blobs>Blob"`
+			NextMarker string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse container listing: %w", err)
+		}
+
+		for _, blob := range result.Blobs {
+			objects = append(objects, ObjectInfo{Name: path.Base(blob.Name), Size: blob.Size, ETag: blob.ETag})
+		}
+		if result.NextMarker == "" {
+			return objects, nil
+		}
+		marker = result.NextMarker
+	}
+}
+
+func (s *azureStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.blobURL(name, nil), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *azureStorage) OpenRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.blobURL(name, nil), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", byteRange(offset, length))
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return rangeBody(resp, offset, length)
+}
+
+func (s *azureStorage) Put(ctx context.Context, name string, r io.Reader, size int64, contentType string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.blobURL(name, nil), r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("X-Ms-Blob-Type", "BlockBlob")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"sort"
+	"strings"
+)
+
+// RenderBackend draws channel peaks into an image. Accelerated backends
+// (GPU, SIMD) register themselves from build-tagged files with registerBackend.
+type RenderBackend interface {
+	Draw(peaks ChannelPeaks, ro RenderOptions, progress *Progress) (*image.RGBA, error)
+}
+
+// renderBackends holds the available backends by name
+var renderBackends = map[string]RenderBackend{}
+
+// registerBackend makes a backend selectable with -renderer
+func registerBackend(name string, b RenderBackend) {
+	renderBackends[name] = b
+}
+
+// lookupBackend returns the named backend
+func lookupBackend(name string) (RenderBackend, error) {
+	if b, ok := renderBackends[name]; ok {
+		return b, nil
+	}
+
+	names := make([]string, 0, len(renderBackends))
+	for n := range renderBackends {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return nil, fmt.Errorf("unknown renderer %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+// cpuBackend is the portable renderer writing straight into the RGBA buffer
+type cpuBackend struct{}
+
+// Draw implements RenderBackend
+func (cpuBackend) Draw(peaks ChannelPeaks, ro RenderOptions, progress *Progress) (*image.RGBA, error) {
+	return drawPeaks(peaks, ro, progress)
+}
+
+func init() {
+	registerBackend("cpu", cpuBackend{})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"time"
+	"unsafe"
+)
+
+// rowMajorBackend draws background and waveform in a single row-major pass.
+// It is plain Go on the CPU like the default renderer, only laid out for
+// the cache: that one fills the background and then walks each column down
+// the image, touching a new cache line for every pixel it draws; this one
+// works out every column's span first and then writes each row once, front
+// to back, with one 32-bit store per pixel and the background/foreground
+// choice made without branching. Output is identical to the CPU renderer.
+type rowMajorBackend struct{}
+
+// Draw implements RenderBackend
+func (rowMajorBackend) Draw(peaks ChannelPeaks, ro RenderOptions, progress *Progress) (*image.RGBA, error) {
+	if len(peaks.Min) == 0 {
+		return nil, fmt.Errorf("no peaks to render")
+	}
+
+	defer renderDuration.since(time.Now())
+
+	if ro.Colormap != nil {
+		// The color changes per column, which the row pass can't select
+		return drawPeaks(peaks, ro, progress)
+	}
+
+	width, height := ro.Width, ro.Height
+	img := getImage(width, height)
+	if uintptr(unsafe.Pointer(&img.Pix[0]))%4 != 0 {
+		// Pixels can't be stored as words; never the case for images
+		// allocated by image.NewRGBA
+		putImage(img)
+		return drawPeaks(peaks, ro, progress)
+	}
+
+	gain := ro.gain(peaks)
+
+	// first[x] is the first row of column x and span[x] the number of
+	// further rows it covers; empty columns get a span no row is within
+	first := make([]uint32, width)
+	span := make([]uint32, width)
+	for x := range width {
+		minY, maxY, ok := columnSpan(peaks, ro, gain, x, width, height)
+		if !ok {
+			// Every row is before this first row
+			first[x], span[x] = math.MaxUint32, 0
+			continue
+		}
+		first[x], span[x] = uint32(minY), uint32(maxY-minY)
+	}
+	progress.render(0.5)
+
+	fgPixel, bgPixel := ro.inkPixel(ro.Foreground), ro.backgroundPixel()
+	fg := pixelWord(fgPixel.R, fgPixel.G, fgPixel.B, fgPixel.A)
+	bg := pixelWord(bgPixel.R, bgPixel.G, bgPixel.B, bgPixel.A)
+
+	fill := func(lo, hi int) {
+		for y := lo; y < hi; y++ {
+			start := y * img.Stride
+			row := unsafe.Slice((*uint32)(unsafe.Pointer(&img.Pix[start])), width)
+			fillRow(row, first, span, uint32(y), fg, bg)
+		}
+	}
+
+	if width*height >= parallelRenderPixels {
+		parallelRanges(height, fill)
+	} else {
+		fill(0, height)
+	}
+	if ro.captionsScale() {
+		drawScaleCaption(img, ro, gain)
+	}
+
+	progress.render(1)
+
+	return img, nil
+}
+
+// pixelWord packs an RGBA pixel into the word with the same memory layout
+func pixelWord(r, g, b, a uint8) uint32 {
+	p := [4]uint8{r, g, b, a}
+	return *(*uint32)(unsafe.Pointer(&p))
+}
+
+// fillRow writes row y: foreground where y is within a column's span,
+// background elsewhere. The loop is unrolled by four and selects with a mask,
+// so there is no data dependent branch.
+func fillRow(row, first, span []uint32, y, fg, bg uint32) {
+	n := len(row)
+	first, span = first[:n], span[:n]
+	diff := fg ^ bg
+
+	x := 0
+	for ; x+4 <= n; x += 4 {
+		row[x] = bg ^ diff&inSpan(y, first[x], span[x])
+		row[x+1] = bg ^ diff&inSpan(y, first[x+1], span[x+1])
+		row[x+2] = bg ^ diff&inSpan(y, first[x+2], span[x+2])
+		row[x+3] = bg ^ diff&inSpan(y, first[x+3], span[x+3])
+	}
+	for ; x < n; x++ {
+		row[x] = bg ^ diff&inSpan(y, first[x], span[x])
+	}
+}
+
+// inSpan returns all ones when first <= y <= first+span and zero otherwise.
+// y-first wraps around to a huge value for rows above the span, so a single
+// comparison covers both ends; it is done as a 64-bit subtraction whose sign
+// becomes the mask.
+func inSpan(y, first, span uint32) uint32 {
+	return ^uint32((int64(span) - int64(y-first)) >> 63)
+}
+
+func init() {
+	registerBackend("rowmajor", rowMajorBackend{})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// testPeaks returns n buckets of a decaying sine with some silence
+func testPeaks(n int) ChannelPeaks {
+	p := newChannelPeaks(n)
+	for i := range n {
+		v := math.Sin(float64(i)/7) * math.Exp(-float64(i)/float64(n)) * 32767
+		if i%97 < 5 {
+			v = 0
+		}
+		p.Min[i] = int16(-math.Abs(v))
+		p.Max[i] = int16(math.Abs(v) * 0.8)
+	}
+	return p
+}
+
+func TestBackendsMatchCPU(t *testing.T) {
+	tests := []struct {
+		buckets       int
+		width, height int
+		style         string
+		normalize     bool
+	}{
+		{1920, 1920, 640, StyleLine, false},
+		{500, 1920, 640, StyleLine, true},
+		{10000, 801, 99, StyleBars, false},
+		{3, 7, 1, StyleLine, false},
+		{4096, 4096, 1024, StyleBars, true}, // parallel path
+	}
+
+	for name, backend := range renderBackends {
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s/%dx%d/%d/%s/%t", name, tt.width, tt.height, tt.buckets, tt.style, tt.normalize), func(t *testing.T) {
+				ro := DefaultRenderOptions()
+				ro.Width, ro.Height, ro.Style, ro.Normalize = tt.width, tt.height, tt.style, tt.normalize
+				ro.Background = color.RGBA{1, 2, 3, 255}
+				peaks := testPeaks(tt.buckets)
+
+				want, err := drawPeaks(peaks, ro, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				wantPix := bytes.Clone(want.Pix)
+				putImage(want)
+
+				got, err := backend.Draw(peaks, ro, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer putImage(got)
+
+				if !bytes.Equal(got.Pix, wantPix) {
+					t.Errorf("output differs from the CPU renderer")
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkBackends(b *testing.B) {
+	peaks := testPeaks(1920)
+
+	for _, name := range []string{"cpu", "rowmajor"} {
+		for _, size := range [][2]int{{1920, 640}, {8192, 2048}} {
+			b.Run(fmt.Sprintf("%s/%dx%d", name, size[0], size[1]), func(b *testing.B) {
+				ro := DefaultRenderOptions()
+				ro.Width, ro.Height = size[0], size[1]
+				backend := renderBackends[name]
+
+				for b.Loop() {
+					img, err := backend.Draw(peaks, ro, nil)
+					if err != nil {
+						b.Fatal(err)
+					}
+					putImage(img)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import "math"
+
+// ChannelLevel holds the average and peak level of one channel
+type ChannelLevel struct {
+	Channel string   `json:"channel"`
+	RMSDB   *float64 `json:"rms_dbfs"`
+	PeakDB  *float64 `json:"peak_dbfs"`
+	Silent  bool     `json:"silent"`
+}
+
+// BalanceReport is the "balance" section of a file report. Differences are
+// left minus right, so a negative value means the left channel is quieter.
+type BalanceReport struct {
+	ThresholdDB    float64        `json:"threshold_db"`
+	Channels       []ChannelLevel `json:"channels"`
+	RMSDifference  *float64       `json:"rms_difference_db"`
+	PeakDifference *float64       `json:"peak_difference_db"`
+	Imbalanced     bool           `json:"imbalanced"`
+	Quieter        string         `json:"quieter_channel,omitempty"`
+}
+
+// balanceAnalyzer compares the levels of the left and right channels
+type balanceAnalyzer struct {
+	cfg AnalysisConfig
+
+	frames int
+	sum    [2]float64
+	peak   [2]int32
+}
+
+func newBalanceAnalyzer(cfg AnalysisConfig) Analyzer {
+	return &balanceAnalyzer{cfg: cfg}
+}
+
+// Start implements Analyzer
+func (a *balanceAnalyzer) Start(info StreamInfo) {}
+
+// Add implements Analyzer
+func (a *balanceAnalyzer) Add(left, right []int16) {
+	for i := range left {
+		l := float64(left[i])
+		a.sum[0] += l * l
+		a.peak[0] = max(a.peak[0], abs16(left[i]))
+
+		if right != nil {
+			r := float64(right[i])
+			a.sum[1] += r * r
+			a.peak[1] = max(a.peak[1], abs16(right[i]))
+		}
+	}
+	a.frames += len(left)
+}
+
+// Result implements Analyzer
+func (a *balanceAnalyzer) Result() any {
+	report := BalanceReport{ThresholdDB: a.cfg.ImbalanceThresholdDB}
+
+	var rmsDB, peakDB [2]float64
+	for ch, name := range channelNames {
+		rms := 0.0
+		if a.frames > 0 {
+			rms = math.Sqrt(a.sum[ch]/float64(a.frames)) / 32768.0
+		}
+		rmsDB[ch] = 20 * math.Log10(rms)
+		peakDB[ch] = 20 * math.Log10(float64(a.peak[ch])/32768.0)
+
+		// A channel never rising above the silence threshold carries nothing
+		report.Channels = append(report.Channels, ChannelLevel{
+			Channel: name,
+			RMSDB:   finiteOrNil(rmsDB[ch]),
+			PeakDB:  finiteOrNil(peakDB[ch]),
+			Silent:  peakDB[ch] < a.cfg.SilenceThresholdDB,
+		})
+	}
+
+	report.RMSDifference = finiteOrNil(rmsDB[0] - rmsDB[1])
+	report.PeakDifference = finiteOrNil(peakDB[0] - peakDB[1])
+
+	leftSilent, rightSilent := report.Channels[0].Silent, report.Channels[1].Silent
+	switch {
+	case leftSilent && rightSilent:
+		// Both channels silent is silence, not imbalance
+	case leftSilent != rightSilent:
+		report.Imbalanced = true
+	default:
+		report.Imbalanced = math.Abs(rmsDB[0]-rmsDB[1]) >= a.cfg.ImbalanceThresholdDB
+	}
+
+	if report.Imbalanced {
+		report.Quieter = channelNames[0]
+		if rmsDB[1] < rmsDB[0] {
+			report.Quieter = channelNames[1]
+		}
+	}
+
+	return report
+}
@@ -0,0 +1,130 @@
+package main
+
+import "math"
+
+// BucketStats describes the left channel of each peak bucket beyond its
+// min and max: RMS as a 16-bit magnitude, whether any sample clipped and
+// whether it stayed below the silence threshold throughout
+type BucketStats struct {
+	RMS     []int16
+	Clipped []bool
+	Silent  []bool
+}
+
+// bucketStatsAnalyzer collects BucketStats over the same buckets the peaks
+// are decoded into
+type bucketStatsAnalyzer struct {
+	opts             Options
+	clipThreshold    int32
+	silenceThreshold int32
+
+	samplesPerPixel int
+	width           int
+	frames          int // frames spread over buckets fitted to the width; 0 for a fixed bucket size
+	pos             int // samples consumed so far
+	count           int // samples in the current bucket
+	sumSquares      float64
+	peak            int32 // loudest sample of the current bucket
+
+	stats BucketStats
+}
+
+func newBucketStatsAnalyzer(opts Options, cfg AnalysisConfig) *bucketStatsAnalyzer {
+	return &bucketStatsAnalyzer{
+		opts:             opts,
+		clipThreshold:    int32(dbToAmplitude(cfg.ClipThresholdDB)),
+		silenceThreshold: int32(dbToAmplitude(cfg.SilenceThresholdDB)),
+	}
+}
+
+// Start implements Analyzer
+func (a *bucketStatsAnalyzer) Start(info StreamInfo) {
+	a.samplesPerPixel, a.width = a.opts.bucketLayout(info.NumFrames)
+	a.frames, a.pos, a.count = 0, 0, 0
+	if a.opts.SamplesPerPixel <= 0 {
+		a.frames = info.NumFrames
+	}
+	a.stats = BucketStats{
+		RMS:     make([]int16, 0, a.width),
+		Clipped: make([]bool, 0, a.width),
+		Silent:  make([]bool, 0, a.width),
+	}
+}
+
+// buckets returns how many buckets samples are collected into: one per
+// frame for clips shorter than the width, which Result stretches across it
+func (a *bucketStatsAnalyzer) buckets() int {
+	if a.frames > 0 && a.frames < a.width {
+		return a.frames
+	}
+	return a.width
+}
+
+// bucketEnds reports whether the sample just added was the last of its
+// bucket, which PeakBuilder lays out the same way
+func (a *bucketStatsAnalyzer) bucketEnds() bool {
+	switch {
+	case a.frames == 0:
+		return a.count == a.samplesPerPixel
+	case a.frames < a.width:
+		return true
+	}
+	return bucketOf(a.pos, a.frames, a.width) != bucketOf(a.pos-1, a.frames, a.width)
+}
+
+// Add implements Analyzer. Like PeakBuilder, it ignores samples past the
+// last bucket.
+func (a *bucketStatsAnalyzer) Add(left, right []int16) {
+	for _, v := range left {
+		if len(a.stats.RMS) == a.buckets() {
+			return
+		}
+		f := float64(v)
+		a.sumSquares += f * f
+		a.peak = max(a.peak, abs16(v))
+
+		a.count++
+		a.pos++
+		if a.bucketEnds() {
+			a.endBucket()
+		}
+	}
+}
+
+// endBucket records the current bucket and starts the next
+func (a *bucketStatsAnalyzer) endBucket() {
+	rms := math.Sqrt(a.sumSquares / float64(a.count))
+	a.stats.RMS = append(a.stats.RMS, clampInt16(int(math.Round(rms))))
+	a.stats.Clipped = append(a.stats.Clipped, a.peak >= a.clipThreshold)
+	a.stats.Silent = append(a.stats.Silent, a.peak < a.silenceThreshold)
+	a.count, a.sumSquares, a.peak = 0, 0, 0
+}
+
+// Result implements Analyzer, returning the BucketStats. The last bucket
+// may be partial and buckets without samples are silent, as for the peaks.
+// Each bucket of a stretched clip has the stats of the frame under its
+// center.
+func (a *bucketStatsAnalyzer) Result() any {
+	if a.count > 0 {
+		a.endBucket()
+	}
+	for len(a.stats.RMS) < a.buckets() {
+		a.stats.RMS = append(a.stats.RMS, 0)
+		a.stats.Clipped = append(a.stats.Clipped, false)
+		a.stats.Silent = append(a.stats.Silent, true)
+	}
+	if a.buckets() == a.width {
+		return a.stats
+	}
+
+	stretched := BucketStats{
+		RMS:     make([]int16, a.width),
+		Clipped: make([]bool, a.width),
+		Silent:  make([]bool, a.width),
+	}
+	for x := range a.width {
+		i := min((2*x+1)*a.frames/(2*a.width), a.frames-1)
+		stretched.RMS[x], stretched.Clipped[x], stretched.Silent[x] = a.stats.RMS[i], a.stats.Clipped[i], a.stats.Silent[i]
+	}
+	return stretched
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestBucketStatsAnalyzer(t *testing.T) {
+	a := newBucketStatsAnalyzer(Options{Width: 4}, DefaultAnalysisConfig())
+	a.Start(StreamInfo{SampleRate: 8000, NumFrames: 12})
+
+	// Full scale, silence, a square wave of 1000 and a partial bucket
+	left := []int16{32767, -32767, 32767, 0, 0, 0, 1000, -1000, 1000, 2000, -2000}
+	a.Add(left[:5], nil)
+	a.Add(left[5:], nil)
+	stats := a.Result().(BucketStats)
+
+	wantRMS := []int16{32767, 0, 1000, 2000}
+	wantClipped := []bool{true, false, false, false}
+	wantSilent := []bool{false, true, false, false}
+	for i := range 4 {
+		if stats.RMS[i] != wantRMS[i] || stats.Clipped[i] != wantClipped[i] || stats.Silent[i] != wantSilent[i] {
+			t.Errorf("bucket %d = RMS %d, clipped %t, silent %t; want %d, %t, %t",
+				i, stats.RMS[i], stats.Clipped[i], stats.Silent[i], wantRMS[i], wantClipped[i], wantSilent[i])
+		}
+	}
+	if len(stats.RMS) != 4 {
+		t.Errorf("%d buckets, want 4", len(stats.RMS))
+	}
+
+	// A clip shorter than the width is stretched across it as the peaks are
+	a.Start(StreamInfo{SampleRate: 8000, NumFrames: 2})
+	a.Add([]int16{32767, 0}, nil)
+	stats = a.Result().(BucketStats)
+	if len(stats.RMS) != 4 || !stats.Clipped[1] || stats.Clipped[2] || !stats.Silent[3] {
+		t.Errorf("2 frames over 4 buckets = %+v", stats)
+	}
+}
+
+func TestPeaksExtended(t *testing.T) {
+	input := writeFixture(t, DefaultTestAudio())
+	s := &Server{Root: filepath.Dir(input), Defaults: DefaultRenderOptions()}
+	ts := httptest.NewServer(s.Handler(false))
+	defer ts.Close()
+
+	get := func(query string) PeaksJSON {
+		t.Helper()
+		resp, err := http.Get(ts.URL + "/peaks?file=fixture.wav&width=100" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var p PeaksJSON
+		if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	if p := get(""); p.RMS != nil || p.Clipped != nil || p.Silent != nil {
+		t.Error("bucket stats exported without extended")
+	}
+
+	p := get("&extended=1&bits=8")
+	if len(p.RMS) != 100 || len(p.Clipped) != 100 || len(p.Silent) != 100 {
+		t.Fatalf("%d RMS values, %d clipped and %d silent flags; want 100 of each", len(p.RMS), len(p.Clipped), len(p.Silent))
+	}
+	// An 0.8 sine has an RMS of about 0.57 of full scale
+	for i, v := range p.RMS {
+		if v < 70 || v > 75 || p.Clipped[i] || p.Silent[i] {
+			t.Fatalf("bucket %d = RMS %d, clipped %t, silent %t; want about 72 and no flags", i, v, p.Clipped[i], p.Silent[i])
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// PeakCache stores computed peaks on disk so unchanged inputs aren't decoded again
+type PeakCache struct {
+	// Dir is the cache directory; an empty Dir disables the cache
+	Dir string
+
+	// Channels is the channel selection the peaks are decoded with
+	Channels *ChannelSelection
+
+	// Aggregation is how the buckets of the peaks are collapsed
+	Aggregation *Aggregation
+}
+
+// cacheLocation returns what identifies an input across runs: the absolute
+// path of a local file or the URL of a remote object
+func cacheLocation(input batchInput) (string, error) {
+	if input.Version != "" {
+		return input.Location, nil
+	}
+
+	absPath, err := filepath.Abs(input.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve input path: %w", err)
+	}
+	return absPath, nil
+}
+
+// cacheVersion returns what changes with the content of an input: the
+// ETag and size of a remote object, or the mtime and size of a local file.
+// A remote input is downloaded afresh each run, so its local copy's mtime
+// says nothing.
+func cacheVersion(input batchInput) (string, error) {
+	if input.Version != "" {
+		return input.Version, nil
+	}
+
+	info, err := os.Stat(input.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to get file info: %w", err)
+	}
+	return fmt.Sprintf("%d|%d", info.ModTime().UnixNano(), info.Size()), nil
+}
+
+// entryPath returns the cache file for an input, keyed by location,
+// version and width
+func (c PeakCache) entryPath(input batchInput, width int) (string, error) {
+	location, err := cacheLocation(input)
+	if err != nil {
+		return "", err
+	}
+	version, err := cacheVersion(input)
+	if err != nil {
+		return "", err
+	}
+
+	key := fmt.Sprintf("%s|%s|%d", location, version, width) + c.Channels.cacheKey() + c.Aggregation.cacheKey()
+	sum := sha256.Sum256([]byte(key))
+
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:])+".peaks"), nil
+}
+
+// Lookup returns the cached peaks for an input if a valid entry exists
+func (c PeakCache) Lookup(input batchInput, width int) (*Peaks, bool) {
+	if c.Dir == "" {
+		return nil, false
+	}
+
+	path, err := c.entryPath(input, width)
+	if err != nil {
+		return nil, false
+	}
+
+	peaks, err := ReadPeaksFile(path)
+	if err != nil || peaks.Len() != width {
+		return nil, false
+	}
+
+	return peaks, true
+}
+
+// Store saves peaks for an input into the cache
+func (c PeakCache) Store(input batchInput, width int, peaks *Peaks) error {
+	if c.Dir == "" {
+		return nil
+	}
+
+	path, err := c.entryPath(input, width)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	return WritePeaksFile(path, peaks)
+}
+
+// incrementalEntryPath returns the cache file for a growing input. It is keyed
+// by location only, since the version changes as the file grows.
+func (c PeakCache) incrementalEntryPath(input batchInput) (string, error) {
+	location, err := cacheLocation(input)
+	if err != nil {
+		return "", err
+	}
+
+	key := fmt.Sprintf("incremental|%s|%d", location, incrementalSamplesPerPixel) + c.Channels.cacheKey() + c.Aggregation.cacheKey()
+	sum := sha256.Sum256([]byte(key))
+
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:])+".peaks"), nil
+}
+
+// LookupIncremental returns the peaks last stored for a growing input
+func (c PeakCache) LookupIncremental(input batchInput) (*Peaks, bool) {
+	if c.Dir == "" {
+		return nil, false
+	}
+
+	path, err := c.incrementalEntryPath(input)
+	if err != nil {
+		return nil, false
+	}
+
+	peaks, err := ReadPeaksFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	return peaks, true
+}
+
+// StoreIncremental saves the peaks of a growing input, replacing earlier ones
+func (c PeakCache) StoreIncremental(input batchInput, peaks *Peaks) error {
+	if c.Dir == "" {
+		return nil
+	}
+
+	path, err := c.incrementalEntryPath(input)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	return WritePeaksFile(path, peaks)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPeakCacheKeys(t *testing.T) {
+	dir := t.TempDir()
+	local := writeFixture(t, DefaultTestAudio())
+	cache := PeakCache{Dir: dir}
+
+	remote := batchInput{Name: "take.wav", Path: local, Location: "s3://media/rec/take.wav", Version: `"etag1"|100`}
+	// The next run downloads the same object to another temp path
+	redownloaded := remote
+	redownloaded.Path = filepath.Join(t.TempDir(), "take.wav")
+
+	changed := remote
+	changed.Version = `"etag2"|100`
+
+	localInput := batchInput{Name: "take.wav", Path: local, Location: local}
+
+	tests := []struct {
+		name      string
+		stored    batchInput
+		lookup    batchInput
+		wantFound bool
+	}{
+		{"same local file", localInput, localInput, true},
+		{"remote object downloaded again", remote, redownloaded, true},
+		{"remote object changed", remote, changed, false},
+		{"remote and local copy are different entries", remote, localInput, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.RemoveAll(dir)
+			peaks := &Peaks{SampleRate: 44100, SamplesPerPixel: 1, Channels: []ChannelPeaks{newChannelPeaks(4)}}
+			if err := cache.Store(tt.stored, 4, peaks); err != nil {
+				t.Fatalf("Store: %v", err)
+			}
+			if _, found := cache.Lookup(tt.lookup, 4); found != tt.wantFound {
+				t.Errorf("Lookup found = %v, want %v", found, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestPeakCacheLocalFileChanged(t *testing.T) {
+	local := writeFixture(t, DefaultTestAudio())
+	input := batchInput{Name: "take.wav", Path: local, Location: local}
+	cache := PeakCache{Dir: t.TempDir()}
+
+	peaks := &Peaks{SampleRate: 44100, SamplesPerPixel: 1, Channels: []ChannelPeaks{newChannelPeaks(4)}}
+	if err := cache.Store(input, 4, peaks); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(local, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := cache.Lookup(input, 4); found {
+		t.Error("Lookup found peaks of a modified file")
+	}
+}
+
+func TestPeakCacheIncrementalRemote(t *testing.T) {
+	cache := PeakCache{Dir: t.TempDir()}
+	first := batchInput{Name: "live.wav", Path: "/tmp/a/live.wav", Location: "gs://media/live.wav", Version: `"1"|100`}
+	// The object has grown and was downloaded somewhere else
+	grown := batchInput{Name: "live.wav", Path: "/tmp/b/live.wav", Location: "gs://media/live.wav", Version: `"2"|200`}
+
+	peaks := &Peaks{SampleRate: 44100, SamplesPerPixel: incrementalSamplesPerPixel, Channels: []ChannelPeaks{newChannelPeaks(4)}}
+	if err := cache.StoreIncremental(first, peaks); err != nil {
+		t.Fatalf("StoreIncremental: %v", err)
+	}
+	if _, found := cache.LookupIncremental(grown); !found {
+		t.Error("LookupIncremental missed the earlier peaks of a grown remote file")
+	}
+}
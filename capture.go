@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaultCaptureCommand records raw PCM from an ALSA device
+const defaultCaptureCommand = "arecord -q -D {device} -f S16_LE -c {channels} -r {rate} -t raw"
+
+// liveBucketsPerSecond is the resolution peaks are captured at; buckets
+// are merged or repeated to fit the image when it is drawn
+const liveBucketsPerSecond = 100
+
+// liveChunkBuckets is how many buckets each PeakBuilder of a capture fills
+// before its buckets are moved to the finished ones
+const liveChunkBuckets = 64
+
+// livePeaks builds peaks of the left channel of a stream of unknown length.
+// With maxBuckets set only the last maxBuckets buckets are kept.
+type livePeaks struct {
+	samplesPerPixel int
+	maxBuckets      int
+
+	done    ChannelPeaks
+	builder *PeakBuilder
+}
+
+func newLivePeaks(sampleRate int, window time.Duration) *livePeaks {
+	spp := max(sampleRate/liveBucketsPerSecond, 1)
+	l := &livePeaks{samplesPerPixel: spp}
+	if window > 0 {
+		l.maxBuckets = max(int(window.Seconds()*float64(sampleRate))/spp, 1)
+	}
+	l.builder = NewPeakBuilder(spp, liveChunkBuckets)
+	return l
+}
+
+// add feeds the next left channel samples
+func (l *livePeaks) add(samples []int16) {
+	full := l.samplesPerPixel * liveChunkBuckets
+	for len(samples) > 0 {
+		before := l.builder.pos
+		l.builder.AddInt16(samples)
+		samples = samples[l.builder.pos-before:]
+
+		if l.builder.pos == full {
+			chunk := l.builder.Peaks()
+			l.done.Min = append(l.done.Min, chunk.Min...)
+			l.done.Max = append(l.done.Max, chunk.Max...)
+			if l.maxBuckets > 0 && len(l.done.Min) > l.maxBuckets {
+				drop := len(l.done.Min) - l.maxBuckets
+				l.done.Min, l.done.Max = l.done.Min[drop:], l.done.Max[drop:]
+			}
+			l.builder = NewPeakBuilder(l.samplesPerPixel, liveChunkBuckets)
+		}
+	}
+}
+
+// current returns the buckets so far, including a partly filled last one.
+// A rolling window that isn't full yet starts with silence, so the
+// waveform scrolls in from the right.
+func (l *livePeaks) current() ChannelPeaks {
+	used := (l.builder.pos + l.samplesPerPixel - 1) / l.samplesPerPixel
+	partial := l.builder.Peaks()
+	n := len(l.done.Min) + used
+
+	size := n
+	if l.maxBuckets > 0 {
+		size = l.maxBuckets
+	}
+	peaks := newChannelPeaks(size)
+
+	// Copy backwards from the newest bucket
+	for i := 1; i <= min(n, size); i++ {
+		j := n - i
+		if j >= len(l.done.Min) {
+			peaks.Min[size-i], peaks.Max[size-i] = partial.Min[j-len(l.done.Min)], partial.Max[j-len(l.done.Min)]
+		} else {
+			peaks.Min[size-i], peaks.Max[size-i] = l.done.Min[j], l.done.Max[j]
+		}
+	}
+	return peaks
+}
+
+// liveCapture renders raw PCM as it arrives, rewriting the image every
+// interval and once more at the end
+type liveCapture struct {
+	output   string
+	ro       RenderOptions
+	channels int
+	// bigEndian is set for audio/L16 streams
+	bigEndian bool
+	interval  time.Duration
+	// maxFrames stops the capture after that many frames; 0 is unlimited
+	maxFrames int
+
+	peaks  *livePeaks
+	frames int
+}
+
+// run reads interleaved signed 16-bit PCM until the stream
+// ends, ctx is done or maxFrames have been read
+func (c *liveCapture) run(ctx context.Context, pcm io.Reader) error {
+	frameSize := 2 * c.channels
+	buf := make([]byte, 4096*frameSize)
+	left := make([]int16, 4096)
+	r := bufio.NewReaderSize(pcm, len(buf))
+	lastWrite := time.Now()
+
+	for ctx.Err() == nil && (c.maxFrames == 0 || c.frames < c.maxFrames) {
+		want := len(buf)
+		if c.maxFrames > 0 {
+			want = min(want, (c.maxFrames-c.frames)*frameSize)
+		}
+		n, err := io.ReadFull(r, buf[:want])
+
+		frames := n / frameSize
+		for i := range frames {
+			if c.bigEndian {
+				left[i] = int16(binary.BigEndian.Uint16(buf[i*frameSize:]))
+			} else {
+				left[i] = int16(binary.LittleEndian.Uint16(buf[i*frameSize:]))
+			}
+		}
+		c.peaks.add(left[:frames])
+		c.frames += frames
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			// A capture command killed on interrupt ends its output this way
+			if ctx.Err() != nil || errors.Is(err, os.ErrClosed) {
+				break
+			}
+			return fmt.Errorf("failed to read audio: %w", err)
+		}
+
+		if time.Since(lastWrite) >= c.interval {
+			if err := c.writeImage(); err != nil {
+				return err
+			}
+			lastWrite = time.Now()
+		}
+	}
+
+	return c.writeImage()
+}
+
+// writeImage redraws the image. It is written next to the output and then
+// renamed, so viewers never see a partly written file.
+func (c *liveCapture) writeImage() error {
+	peaks := c.peaks.current()
+	if len(peaks.Min) == 0 {
+		return nil
+	}
+
+	img, err := drawPeaks(peaks, c.ro, nil)
+	if err != nil {
+		return err
+	}
+	defer putImage(img)
+
+	return savePNG(img, c.output, png.BestSpeed)
+}
+
+// runCapture implements the capture subcommand
+func runCapture(args []string) error {
+	fs := flag.NewFlagSet("capture", flag.ExitOnError)
+	output := fs.String("o", "live.png", "image rewritten as the audio comes in")
+	width := fs.Int("width", 1920, "image width in pixels")
+	height := fs.Int("height", 640, "image height in pixels")
+	window := fs.Duration("window", 0, "only show the last part of the recording, e.g. 30s, scrolling as it goes (everything when 0)")
+	interval := fs.Duration("interval", time.Second, "how often the image is rewritten")
+	duration := fs.Duration("duration", 0, "stop after capturing this much audio (until interrupted when 0)")
+	device := fs.String("device", "default", "capture device of the default command")
+	streamURL := fs.String("url", "", "capture an HTTP stream (Icecast, HLS) instead of a device")
+	rate := fs.Int("rate", 48000, "sample rate in Hz")
+	channels := fs.Int("channels", 2, "number of channels; the left (first) one is drawn")
+	command := fs.String("cmd", defaultCaptureCommand, "command writing raw signed 16-bit little-endian PCM to its output, with {device}, {url}, {rate} and {channels} substituted; - reads the PCM from stdin")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *rate <= 0 || *channels <= 0 {
+		return fmt.Errorf("-rate and -channels must be positive")
+	}
+	if *width <= 0 || *height <= 0 {
+		return fmt.Errorf("-width and -height must be positive")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var pcm io.Reader = os.Stdin
+	var cmd *exec.Cmd
+	bigEndian := false
+	source := *device
+
+	if *streamURL != "" {
+		source = *streamURL
+		if !isFlagSet(fs, "cmd") {
+			// Uncompressed streams are read directly, others go through
+			// ffmpeg
+			stream, err := openPCMStream(ctx, *streamURL)
+			if err != nil {
+				return err
+			}
+			if stream != nil {
+				defer stream.Close()
+				pcm, bigEndian = stream, stream.bigEndian
+				*rate, *channels = stream.rate, stream.channels
+				*command = ""
+			} else {
+				*command = defaultStreamCommand
+			}
+		}
+	}
+
+	if *command != "-" && *command != "" {
+		parts, err := splitCommand(*command)
+		if err != nil {
+			return err
+		}
+		if len(parts) == 0 {
+			return fmt.Errorf("-cmd is empty")
+		}
+		parts = expandHook(parts, map[string]string{
+			"device":   *device,
+			"url":      *streamURL,
+			"rate":     strconv.Itoa(*rate),
+			"channels": strconv.Itoa(*channels),
+		})
+
+		cmd = exec.CommandContext(ctx, parts[0], parts[1:]...)
+		cmd.Stderr = os.Stderr
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("failed to start %q: %w", strings.Join(parts, " "), err)
+		}
+		pcm = stdout
+	}
+
+	ro := DefaultRenderOptions()
+	ro.Width, ro.Height = *width, *height
+	c := &liveCapture{
+		output:    *output,
+		ro:        ro,
+		channels:  *channels,
+		bigEndian: bigEndian,
+		interval:  *interval,
+		maxFrames: int(duration.Seconds() * float64(*rate)),
+		peaks:     newLivePeaks(*rate, *window),
+	}
+
+	fmt.Printf("Capturing %s (%d Hz, %d channels) to %s (Ctrl-C to stop)\n", source, *rate, *channels, *output)
+	err := c.run(ctx, pcm)
+
+	if cmd != nil {
+		cmd.Process.Kill()
+		if waitErr := cmd.Wait(); waitErr != nil && err == nil && ctx.Err() == nil && c.frames == 0 {
+			err = fmt.Errorf("capture command failed: %w", waitErr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Captured %s to %s\n", formatSeconds(float64(c.frames)/float64(*rate)), *output)
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// rawPCM interleaves 16-bit frames whose left sample is left(i)
+func rawPCM(frames, channels int, left func(i int) int16) []byte {
+	var buf bytes.Buffer
+	for i := range frames {
+		binary.Write(&buf, binary.LittleEndian, left(i))
+		for range channels - 1 {
+			binary.Write(&buf, binary.LittleEndian, int16(-1))
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestLivePeaks(t *testing.T) {
+	// 10 samples per bucket
+	l := newLivePeaks(1000, 0)
+	samples := make([]int16, 1005)
+	for i := range samples {
+		samples[i] = int16(i)
+	}
+	// Uneven chunks cross bucket and builder boundaries
+	for _, n := range []int{3, 640, 1, 361} {
+		l.add(samples[:n])
+		samples = samples[n:]
+	}
+
+	peaks := l.current()
+	if len(peaks.Min) != 101 {
+		t.Fatalf("%d buckets, want 101", len(peaks.Min))
+	}
+	for i := range 100 {
+		if peaks.Min[i] != int16(10*i) || peaks.Max[i] != int16(10*i+9) {
+			t.Fatalf("bucket %d = %d..%d, want %d..%d", i, peaks.Min[i], peaks.Max[i], 10*i, 10*i+9)
+		}
+	}
+	if peaks.Min[100] != 1000 || peaks.Max[100] != 1004 {
+		t.Errorf("partial bucket = %d..%d, want 1000..1004", peaks.Min[100], peaks.Max[100])
+	}
+}
+
+func TestLivePeaksWindow(t *testing.T) {
+	// A 1 s window holds 100 buckets of 10 samples
+	l := newLivePeaks(1000, time.Second)
+	steady := func(n int) []int16 {
+		s := make([]int16, n)
+		for i := range s {
+			s[i] = 100
+		}
+		return s
+	}
+
+	l.add(steady(300))
+	peaks := l.current()
+	if len(peaks.Max) != 100 || peaks.Max[0] != 0 || peaks.Max[69] != 0 || peaks.Max[70] != 100 || peaks.Max[99] != 100 {
+		t.Errorf("window not padded at the start: %v", peaks.Max)
+	}
+
+	l.add(steady(5000))
+	peaks = l.current()
+	if len(peaks.Max) != 100 || peaks.Max[0] != 100 {
+		t.Errorf("full window = %d buckets starting at %d", len(peaks.Max), peaks.Max[0])
+	}
+	if len(l.done.Min) > 100 {
+		t.Errorf("%d old buckets kept", len(l.done.Min))
+	}
+}
+
+func TestLiveCapture(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "live.png")
+	ro := DefaultRenderOptions()
+	ro.Width, ro.Height = 200, 50
+	c := &liveCapture{
+		output:    output,
+		ro:        ro,
+		channels:  2,
+		interval:  time.Hour,
+		maxFrames: 4000,
+		peaks:     newLivePeaks(8000, 0),
+	}
+
+	pcm := rawPCM(10000, 2, func(i int) int16 { return int16(i%200*100 - 10000) })
+	if err := c.run(context.Background(), bytes.NewReader(pcm)); err != nil {
+		t.Fatal(err)
+	}
+	if c.frames != 4000 {
+		t.Errorf("captured %d frames, want -duration's 4000", c.frames)
+	}
+
+	file, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	img, err := png.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 200 || b.Dy() != 50 {
+		t.Errorf("image is %v, want 200x50", b)
+	}
+
+	leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(output), ".capture_*"))
+	if len(leftovers) != 0 {
+		t.Errorf("temporary images left: %v", leftovers)
+	}
+}
+
+func TestRunCaptureCommand(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.raw")
+	if err := os.WriteFile(input, rawPCM(48000, 1, func(i int) int16 { return int16(i % 1000) }), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "live.png")
+
+	// The device stands in for the capture source
+	err := runCapture([]string{"-cmd", "cat {device}", "-device", input, "-channels", "1", "-o", output, "-width", "100", "-height", "40"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Errorf("no image written: %v", err)
+	}
+
+	if err := runCapture([]string{"-cmd", "false", "-o", output}); err == nil {
+		t.Error("failing capture command not reported")
+	}
+}
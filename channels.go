@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxWAVChannels bounds the channels of files decoded for -channels
+const maxWAVChannels = 64
+
+// speakers names the speakers of WAVE_FORMAT_EXTENSIBLE channel masks, in
+// the order of their bits, which is also the order of the channels feeding
+// them
+var speakers = []string{
+	"FL", "FR", "C", "LFE", "BL", "BR", "FLC", "FRC", "BC",
+	"SL", "SR", "TC", "TFL", "TFC", "TFR", "TBL", "TBC", "TBR",
+}
+
+// speakerBit returns the channel mask bit of a speaker name, or -1 when it
+// isn't one. FC is accepted for C.
+func speakerBit(name string) int {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if name == "FC" {
+		name = "C"
+	}
+	return slices.Index(speakers, name)
+}
+
+// speakerNames labels the channels of a file by the speakers its channel
+// mask says they feed. Channels the mask doesn't cover are numbered from 1,
+// except that the two of a stereo file without one are L and R.
+func speakerNames(mask uint32, numChannels int) []string {
+	names := make([]string, 0, numChannels)
+	for bit, speaker := range speakers {
+		if mask&(1<<bit) != 0 && len(names) < numChannels {
+			names = append(names, speaker)
+		}
+	}
+	if len(names) == 0 && numChannels == 2 {
+		return []string{"L", "R"}
+	}
+	for ch := len(names); ch < numChannels; ch++ {
+		names = append(names, strconv.Itoa(ch+1))
+	}
+	return names
+}
+
+// ChannelSelection picks the channels of a file that are decoded and mixed
+// into the rendered channel, as soloing them on a mixer would: L or R of a
+// stereo file, channel numbers counted from 1, the speakers channels feed
+// such as C or FL,FR, or mix for every channel. A nil selection renders
+// the left channel.
+type ChannelSelection struct {
+	// Name is the selection as given, e.g. "1,3,5"
+	Name string
+
+	// channels are the selected channels counted from 0; nil selects all
+	// unless speakers are selected
+	channels []int
+
+	// speakers are the channel mask bits of the speakers selected, found
+	// in the mask of each file
+	speakers []int
+}
+
+// parseChannels parses a -channels value: L, R, mix, or comma-separated
+// channel numbers and speaker names such as 1,3,5 or FL,FR,LFE
+func parseChannels(s string) (*ChannelSelection, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return nil, nil
+	case "l", "left":
+		return &ChannelSelection{Name: "L", channels: []int{0}}, nil
+	case "r", "right":
+		return &ChannelSelection{Name: "R", channels: []int{1}}, nil
+	case "mix":
+		return &ChannelSelection{Name: "mix"}, nil
+	}
+
+	sel := &ChannelSelection{}
+	var names []string
+	seen, seenSpeakers := map[int]bool{}, map[int]bool{}
+	for _, part := range strings.Split(s, ",") {
+		if bit := speakerBit(part); bit >= 0 {
+			if !seenSpeakers[bit] {
+				seenSpeakers[bit] = true
+				sel.speakers = append(sel.speakers, bit)
+				names = append(names, speakers[bit])
+			}
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n < 1 || n > maxWAVChannels {
+			return nil, fmt.Errorf("invalid channels %q (want L, R, mix, speakers such as FL,FR,C or channel numbers from 1 to %d, e.g. 1,3,5)", s, maxWAVChannels)
+		}
+		if !seen[n] {
+			seen[n] = true
+			sel.channels = append(sel.channels, n-1)
+			names = append(names, strconv.Itoa(n))
+		}
+	}
+	sel.Name = strings.Join(names, ",")
+	return sel, nil
+}
+
+// singleChannel selects channel ch counted from 0, named as -channels
+// would name it so the two share cached peaks
+func singleChannel(ch int) *ChannelSelection {
+	return &ChannelSelection{Name: strconv.Itoa(ch + 1), channels: []int{ch}}
+}
+
+// resolve returns the channels selected from a file of numChannels feeding
+// the speakers of mask, counted from 0
+func (c *ChannelSelection) resolve(numChannels int, mask uint32) ([]int, error) {
+	if c.channels == nil && c.speakers == nil {
+		all := make([]int, numChannels)
+		for i := range all {
+			all[i] = i
+		}
+		return all, nil
+	}
+
+	for _, ch := range c.channels {
+		if ch >= numChannels {
+			return nil, fmt.Errorf("channel %d selected but the file has %d", ch+1, numChannels)
+		}
+	}
+	if c.speakers == nil {
+		return c.channels, nil
+	}
+
+	// A speaker's channel comes after those of the speakers of lower bits
+	selected := slices.Clone(c.channels)
+	for _, bit := range c.speakers {
+		ch := bits.OnesCount32(mask & (1<<bit - 1))
+		if mask&(1<<bit) == 0 || ch >= numChannels {
+			return nil, fmt.Errorf("%s selected but the file has no such channel (its channels are %s)", speakers[bit], strings.Join(speakerNames(mask, numChannels), ","))
+		}
+		if !slices.Contains(selected, ch) {
+			selected = append(selected, ch)
+		}
+	}
+	return selected, nil
+}
+
+// cacheKey identifies the selection in peak cache keys; the default left
+// channel adds nothing, so existing entries stay valid
+func (c *ChannelSelection) cacheKey() string {
+	if c == nil {
+		return ""
+	}
+	return "|channels=" + c.Name
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseChannels(t *testing.T) {
+	for s, want := range map[string][]int{"L": {0}, "right": {1}, "1, 3,5,3": {0, 2, 4}, "mix": nil} {
+		sel, err := parseChannels(s)
+		if err != nil {
+			t.Fatalf("%q: %v", s, err)
+		}
+		if len(sel.channels) != len(want) {
+			t.Errorf("%q selects %v, want %v", s, sel.channels, want)
+			continue
+		}
+		for i := range want {
+			if sel.channels[i] != want[i] {
+				t.Errorf("%q selects %v, want %v", s, sel.channels, want)
+			}
+		}
+	}
+	if sel, _ := parseChannels("1, 3,5,3"); sel.Name != "1,3,5" {
+		t.Errorf("name = %q", sel.Name)
+	}
+	if sel, _ := parseChannels(""); sel != nil {
+		t.Errorf("empty selection = %+v, want nil", sel)
+	}
+	for _, bad := range []string{"0", "X", "1,,2", "65", "L,FL"} {
+		if _, err := parseChannels(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+// writeChannelsFixture writes a 4 channel file whose channel n holds the
+// constant n*1000
+func writeChannelsFixture(t *testing.T) string {
+	const channels, frames = 4, 10
+	header := WAVHeader{
+		ChunkID:       [4]byte{'R', 'I', 'F', 'F'},
+		ChunkSize:     36 + channels*2*frames,
+		Format:        [4]byte{'W', 'A', 'V', 'E'},
+		SubChunk1ID:   [4]byte{'f', 'm', 't', ' '},
+		SubChunk1Size: 16,
+		AudioFormat:   1,
+		NumChannels:   channels,
+		SampleRate:    8000,
+		ByteRate:      8000 * channels * 2,
+		BlockAlign:    channels * 2,
+		BitsPerSample: 16,
+		SubChunk2ID:   [4]byte{'d', 'a', 't', 'a'},
+		SubChunk2Size: channels * 2 * frames,
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &header)
+	for range frames {
+		for ch := 1; ch <= channels; ch++ {
+			binary.Write(&buf, binary.LittleEndian, int16(ch*1000))
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "stems.wav")
+	if err := os.WriteFile(file, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestOpenWAVChannels(t *testing.T) {
+	file := writeChannelsFixture(t)
+	if _, err := openWAV(file, false, false); err == nil {
+		t.Error("4 channel file opened without a channel selection")
+	}
+
+	for s, want := range map[string]int16{"R": 2000, "3": 3000, "1,3": 2000, "mix": 2500} {
+		sel, _ := parseChannels(s)
+		r, err := openWAVChannels(file, false, false, sel)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		left, right, err := r.readPCM()
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if len(left) != 10 || left[0] != want || right[9] != want {
+			t.Errorf("%s decodes %v / %v, want %d throughout", s, left, right, want)
+		}
+		r.Close()
+	}
+
+	sel, _ := parseChannels("5")
+	if _, err := openWAVChannels(file, false, false, sel); err == nil {
+		t.Error("channel 5 of a 4 channel file accepted")
+	}
+}
+
+// writeExtensibleFixture writes a 4 channel WAVE_FORMAT_EXTENSIBLE file
+// feeding FL, FR, SL and SR, with a LIST chunk before its data, whose
+// channel n holds the constant n*1000
+func writeExtensibleFixture(t *testing.T) string {
+	const channels, frames = 4, 10
+	var fmtChunk bytes.Buffer
+	binary.Write(&fmtChunk, binary.LittleEndian, struct {
+		AudioFormat, NumChannels  uint16
+		SampleRate, ByteRate      uint32
+		BlockAlign, BitsPerSample uint16
+		Size, ValidBits           uint16
+		ChannelMask               uint32
+		SubFormat                 [16]byte
+	}{waveFormatExtensible, channels, 8000, 8000 * channels * 2, channels * 2, 16, 22, 16, 0x603, [16]byte{1}})
+
+	var data bytes.Buffer
+	for range frames {
+		for ch := 1; ch <= channels; ch++ {
+			binary.Write(&data, binary.LittleEndian, int16(ch*1000))
+		}
+	}
+
+	var chunks bytes.Buffer
+	chunk := func(id string, body []byte) {
+		chunks.WriteString(id)
+		binary.Write(&chunks, binary.LittleEndian, uint32(len(body)))
+		chunks.Write(body)
+		if len(body)%2 == 1 {
+			chunks.WriteByte(0)
+		}
+	}
+	chunk("fmt ", fmtChunk.Bytes())
+	chunk("LIST", []byte("INFOISFT\x03\x00\x00\x00ab\x00"))
+	chunk("data", data.Bytes())
+
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(4+chunks.Len()))
+	buf.WriteString("WAVE")
+	buf.Write(chunks.Bytes())
+
+	file := filepath.Join(t.TempDir(), "surround.wav")
+	if err := os.WriteFile(file, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestSpeakerNames(t *testing.T) {
+	for _, tt := range []struct {
+		mask     uint32
+		channels int
+		want     string
+	}{
+		{0x3F, 6, "FL,FR,C,LFE,BL,BR"},
+		{0x60F, 6, "FL,FR,C,LFE,SL,SR"},
+		{0x3, 4, "FL,FR,3,4"},
+		{0x3F, 2, "FL,FR"},
+		{0, 2, "L,R"},
+		{0, 3, "1,2,3"},
+	} {
+		if got := strings.Join(speakerNames(tt.mask, tt.channels), ","); got != tt.want {
+			t.Errorf("speakerNames(%#x, %d) = %s, want %s", tt.mask, tt.channels, got, tt.want)
+		}
+	}
+}
+
+func TestOpenWAVExtensible(t *testing.T) {
+	file := writeExtensibleFixture(t)
+
+	info, err := probeWAV(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.NumFrames != 10 || info.NumChannels != 4 || info.ChannelMask != 0x603 {
+		t.Errorf("probed %+v", info)
+	}
+
+	for s, want := range map[string]int16{"SR": 4000, "fl,sl": 2000, "3": 3000, "FR,2": 2000} {
+		sel, err := parseChannels(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		r, err := openWAVChannels(file, false, false, sel)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		left, _, err := r.readPCM()
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if len(left) != 10 || left[0] != want || left[9] != want {
+			t.Errorf("%s decodes %v, want %d throughout", s, left, want)
+		}
+		r.Close()
+	}
+
+	sel, _ := parseChannels("C")
+	if _, err := openWAVChannels(file, false, false, sel); err == nil || !strings.Contains(err.Error(), "FL,FR,SL,SR") {
+		t.Errorf("C of a file without one: %v", err)
+	}
+}
+
+func TestSplitChannels(t *testing.T) {
+	outputDir := t.TempDir()
+	sel, _ := parseChannels("mix")
+	opts := Options{Width: 100, Height: 20, Channels: sel, SplitChannels: true}
+	file := writeExtensibleFixture(t)
+	result, err := GenerateStereoWaveforms(batchInput{Name: "surround.wav", Path: file, Location: file}, outputDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Outputs) != 5 {
+		t.Errorf("outputs %v, want the mix and 4 channels", result.Outputs)
+	}
+	for _, name := range []string{"FL", "FR", "SL", "SR"} {
+		if _, err := os.Stat(filepath.Join(outputDir, "surround."+name+".png")); err != nil {
+			t.Error(err)
+		}
+	}
+}
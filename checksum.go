@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ChecksumManifest holds the SHA-256 checksums inputs must match before
+// they are processed, for chain-of-custody workflows
+type ChecksumManifest struct {
+	sums map[string]string // hex checksum by cleaned file name
+}
+
+// ReadChecksumManifest reads a manifest as sha256sum writes it ("<hex>
+// <name>" or "<hex> *<name>" lines), or in the BSD "SHA256 (<name>) =
+// <hex>" form. Blank lines and lines starting with # are skipped.
+func ReadChecksumManifest(filename string) (*ChecksumManifest, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checksum manifest: %w", err)
+	}
+	defer file.Close()
+
+	m := &ChecksumManifest{sums: map[string]string{}}
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var sum, name string
+		if rest, ok := strings.CutPrefix(line, "SHA256 ("); ok {
+			var found bool
+			name, sum, found = strings.Cut(rest, ") = ")
+			if !found {
+				return nil, fmt.Errorf("%s:%d: malformed line", filename, n)
+			}
+		} else {
+			var found bool
+			sum, name, found = strings.Cut(line, " ")
+			if !found {
+				return nil, fmt.Errorf("%s:%d: malformed line", filename, n)
+			}
+			name = strings.TrimPrefix(strings.TrimLeft(name, " "), "*")
+		}
+		if err := m.add(name, sum); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", filename, n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read checksum manifest: %w", err)
+	}
+	return m, nil
+}
+
+// singleChecksum returns a manifest of one file, as a job gives it
+func singleChecksum(name, sum string) (*ChecksumManifest, error) {
+	m := &ChecksumManifest{sums: map[string]string{}}
+	if err := m.add(name, sum); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// add records the checksum of a file
+func (m *ChecksumManifest) add(name, sum string) error {
+	sum = strings.ToLower(strings.TrimSpace(sum))
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+		return fmt.Errorf("%q is not a SHA-256 checksum", sum)
+	}
+	m.sums[cleanChecksumName(name)] = sum
+	return nil
+}
+
+// cleanChecksumName returns the name files are matched by: slash
+// separated and without a leading ./
+func cleanChecksumName(name string) string {
+	return path.Clean(strings.ReplaceAll(strings.TrimSpace(name), `\`, "/"))
+}
+
+// Verify checks the checksum of the file of an input name, relative to the
+// input location, against the manifest. A file the manifest doesn't list
+// by its name or, failing that, its base name fails too, since it can't be
+// vouched for.
+func (m *ChecksumManifest) Verify(name, sum string) error {
+	name = cleanChecksumName(name)
+	want, ok := m.sums[name]
+	if !ok {
+		want, ok = m.sums[path.Base(name)]
+	}
+	switch {
+	case !ok:
+		return fmt.Errorf("%s is not in the checksum manifest", name)
+	case want != sum:
+		return fmt.Errorf("checksum mismatch: sha256 is %s, the manifest says %s", sum, want)
+	}
+	return nil
+}
+
+// fileSHA256 returns the SHA-256 of a file in hex
+func fileSHA256(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ManifestEntry is what the -manifest of a batch records about one input
+type ManifestEntry struct {
+	Input       string       `json:"input"`
+	InputSHA256 string       `json:"input_sha256,omitempty"`
+	Outputs     []string     `json:"outputs,omitempty"`
+	Sinks       []SinkResult `json:"sinks,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// outputManifest collects the entries of a batch's -manifest
+type outputManifest struct {
+	mu    sync.Mutex
+	files []ManifestEntry
+}
+
+// add records the outcome of an input
+func (m *outputManifest) add(result FileResult, err error) {
+	entry := ManifestEntry{Input: result.Input, InputSHA256: result.InputSHA256, Outputs: result.Outputs, Sinks: result.Sinks}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	m.mu.Lock()
+	m.files = append(m.files, entry)
+	m.mu.Unlock()
+}
+
+// write writes the manifest as indented JSON, in input order
+func (m *outputManifest) write(filename string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	slices.SortFunc(m.files, func(a, b ManifestEntry) int { return strings.Compare(a.Input, b.Input) })
+	data, err := json.MarshalIndent(map[string][]ManifestEntry{"files": m.files}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicWriteFile(filename, append(data, '\n'))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadChecksumManifest(t *testing.T) {
+	dir := t.TempDir()
+	a := strings.Repeat("ab", 32)
+	b := strings.Repeat("CD", 32)
+	c := strings.Repeat("0f", 32)
+	file := filepath.Join(dir, "SHA256SUMS")
+	os.WriteFile(file, []byte("# takes\n"+a+"  take1.wav\n"+b+" *sub/take2.wav\n\nSHA256 (./take3.wav) = "+c+"\n"), 0o644)
+
+	m, err := ReadChecksumManifest(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name, sum string
+		ok        bool
+	}{
+		{"take1.wav", a, true},
+		{"sub/take2.wav", strings.ToLower(b), true},
+		{"take3.wav", c, true},
+		{"other/take1.wav", a, true}, // by base name
+		{"take1.wav", c, false},
+		{"take4.wav", a, false},
+	} {
+		if err := m.Verify(tc.name, tc.sum); (err == nil) != tc.ok {
+			t.Errorf("Verify(%s, %.8s) = %v", tc.name, tc.sum, err)
+		}
+	}
+
+	os.WriteFile(file, []byte("1234  take1.wav\n"), 0o644)
+	if _, err := ReadChecksumManifest(file); err == nil {
+		t.Error("short checksum accepted")
+	}
+}
+
+func TestGenerateChecksum(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "take.wav")
+	audio := DefaultTestAudio()
+	audio.Duration = 100 * time.Millisecond
+	if err := WriteTestAudioFile(input, audio); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(input)
+	sum := sha256.Sum256(data)
+	want := hex.EncodeToString(sum[:])
+
+	in := batchInput{Name: "take.wav", Path: input, Location: input}
+	opts := Options{Width: 100, Height: 20, HashInputs: true}
+	result, err := GenerateStereoWaveforms(in, t.TempDir(), opts)
+	if err != nil || result.InputSHA256 != want {
+		t.Fatalf("recorded %q, %v; want %s", result.InputSHA256, err, want)
+	}
+
+	opts.Checksums, _ = singleChecksum("take.wav", strings.Repeat("00", 32))
+	result, err = GenerateStereoWaveforms(in, t.TempDir(), opts)
+	if err == nil || len(result.Outputs) > 0 {
+		t.Errorf("mismatching input processed: %v", result.Outputs)
+	}
+
+	opts.Checksums, _ = singleChecksum("take.wav", want)
+	if _, err := GenerateStereoWaveforms(in, t.TempDir(), opts); err != nil {
+		t.Errorf("matching input: %v", err)
+	}
+}
+
+func TestOutputManifest(t *testing.T) {
+	var m outputManifest
+	m.add(FileResult{Input: "b.wav", InputSHA256: "bb", Outputs: []string{"out/b.png"}}, nil)
+	m.add(FileResult{Input: "a.wav"}, os.ErrNotExist)
+
+	file := filepath.Join(t.TempDir(), "manifest.json")
+	if err := m.write(file); err != nil {
+		t.Fatal(err)
+	}
+	var got struct{ Files []ManifestEntry }
+	data, _ := os.ReadFile(file)
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Files) != 2 || got.Files[0].Input != "a.wav" || got.Files[0].Error == "" ||
+		got.Files[1].InputSHA256 != "bb" || got.Files[1].Outputs[0] != "out/b.png" {
+		t.Errorf("manifest %s", data)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+// maxReportedRegions bounds how many clipped regions are listed in a report
+const maxReportedRegions = 1000
+
+// channelNames labels the channels of a stereo file in reports
+var channelNames = []string{"left", "right"}
+
+// ClippedRegion is a run of consecutive clipped frames, in seconds
+type ClippedRegion struct {
+	Start    float64 `json:"start"`
+	End      float64 `json:"end"`
+	Duration float64 `json:"duration"`
+}
+
+// ChannelClipping holds the clipping counts of one channel
+type ChannelClipping struct {
+	Channel        string  `json:"channel"`
+	ClippedSamples int     `json:"clipped_samples"`
+	ClippedPercent float64 `json:"clipped_percent"`
+}
+
+// ClippingReport is the "clipping" section of a file report
+type ClippingReport struct {
+	ThresholdDB      float64           `json:"threshold_db"`
+	Channels         []ChannelClipping `json:"channels"`
+	RegionCount      int               `json:"region_count"`
+	Regions          []ClippedRegion   `json:"regions"`
+	RegionsTruncated bool              `json:"regions_truncated"`
+}
+
+// clippingAnalyzer counts samples at or beyond the clipping threshold
+type clippingAnalyzer struct {
+	cfg       AnalysisConfig
+	threshold int32
+	info      StreamInfo
+
+	pos         int
+	clipped     [2]int
+	regionStart int // first frame of the current clipped run, or -1
+	regionCount int
+	regions     [][2]int
+}
+
+func newClippingAnalyzer(cfg AnalysisConfig) Analyzer {
+	return &clippingAnalyzer{
+		cfg:         cfg,
+		threshold:   int32(dbToAmplitude(cfg.ClipThresholdDB)),
+		regionStart: -1,
+	}
+}
+
+// Start implements Analyzer
+func (a *clippingAnalyzer) Start(info StreamInfo) {
+	a.info = info
+}
+
+// Add implements Analyzer
+func (a *clippingAnalyzer) Add(left, right []int16) {
+	for i := range left {
+		frameClipped := false
+
+		if abs16(left[i]) >= a.threshold {
+			a.clipped[0]++
+			frameClipped = true
+		}
+		if right != nil && abs16(right[i]) >= a.threshold {
+			a.clipped[1]++
+			frameClipped = true
+		}
+
+		if frameClipped {
+			if a.regionStart < 0 {
+				a.regionStart = a.pos
+			}
+		} else if a.regionStart >= 0 {
+			a.endRegion()
+		}
+
+		a.pos++
+	}
+}
+
+// endRegion closes the current clipped run at the current frame
+func (a *clippingAnalyzer) endRegion() {
+	a.regionCount++
+	if len(a.regions) < maxReportedRegions {
+		a.regions = append(a.regions, [2]int{a.regionStart, a.pos})
+	}
+	a.regionStart = -1
+}
+
+// Result implements Analyzer
+func (a *clippingAnalyzer) Result() any {
+	if a.regionStart >= 0 {
+		a.endRegion()
+	}
+
+	rate := a.info.SampleRate
+	report := ClippingReport{
+		ThresholdDB:      a.cfg.ClipThresholdDB,
+		RegionCount:      a.regionCount,
+		Regions:          make([]ClippedRegion, 0, len(a.regions)),
+		RegionsTruncated: a.regionCount > len(a.regions),
+	}
+
+	for ch, name := range channelNames {
+		c := ChannelClipping{Channel: name, ClippedSamples: a.clipped[ch]}
+		if a.pos > 0 {
+			c.ClippedPercent = 100 * float64(a.clipped[ch]) / float64(a.pos)
+		}
+		report.Channels = append(report.Channels, c)
+	}
+
+	for _, r := range a.regions {
+		report.Regions = append(report.Regions, ClippedRegion{
+			Start:    framesToSeconds(r[0], rate),
+			End:      framesToSeconds(r[1], rate),
+			Duration: framesToSeconds(r[1]-r[0], rate),
+		})
+	}
+
+	return report
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Colormap maps a value in [0, 1] to a color, interpolating in linear
+// light between evenly spaced stops. Name identifies it: the name it was looked up
+// by, or the stops of a custom map.
+type Colormap struct {
+	Name  string
+	Stops []color.RGBA
+}
+
+// colormaps are the named maps. viridis and magma are sampled from
+// matplotlib's perceptually uniform maps at tenths of their range.
+var colormaps = map[string]*Colormap{
+	"viridis":   mustColormap("viridis", "440154,482878,3e4989,31688e,26828e,1f9e89,35b779,6ece58,b5de2b,fde725"),
+	"magma":     mustColormap("magma", "000004,180f3d,440f76,721f81,9e2f7f,cd4071,f1605d,fd9668,feca8d,fcfdbf"),
+	"grayscale": mustColormap("grayscale", "000000,ffffff"),
+}
+
+// mustColormap builds a colormap from comma-separated stops, panicking
+// when they are invalid
+func mustColormap(name, stops string) *Colormap {
+	m, err := colormapStops(stops)
+	if err != nil {
+		panic(err)
+	}
+	m.Name = name
+	return m
+}
+
+// colormapStops builds a colormap from comma-separated RRGGBB or RRGGBBAA
+// stops, from the color of 0 to the color of 1
+func colormapStops(stops string) (*Colormap, error) {
+	parts := strings.Split(stops, ",")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("a colormap needs at least two stops, got %q", stops)
+	}
+
+	m := &Colormap{Stops: make([]color.RGBA, len(parts))}
+	names := make([]string, len(parts))
+	for i, part := range parts {
+		c, err := parseHexColor(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		m.Stops[i] = c
+		names[i] = fmt.Sprintf("%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+	}
+	m.Name = strings.Join(names, ",")
+	return m, nil
+}
+
+// parseColormap looks up a colormap by name or builds one from custom
+// stops, e.g. "000080,ffffff,ff0000"
+func parseColormap(s string) (*Colormap, error) {
+	if m, ok := colormaps[s]; ok {
+		return m, nil
+	}
+	if strings.Contains(s, ",") {
+		return colormapStops(s)
+	}
+
+	names := make([]string, 0, len(colormaps))
+	for name := range colormaps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown colormap %q (want %s, or comma-separated RRGGBB stops)", s, strings.Join(names, ", "))
+}
+
+// At returns the color of t, clamped to [0, 1]
+func (m *Colormap) At(t float64) color.RGBA {
+	if math.IsNaN(t) {
+		t = 0
+	}
+	t = min(max(t, 0), 1)
+
+	pos := t * float64(len(m.Stops)-1)
+	i := min(int(pos), len(m.Stops)-2)
+	f := pos - float64(i)
+	return mixLinear(m.Stops[i], m.Stops[i+1], f)
+}
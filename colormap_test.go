@@ -0,0 +1,61 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestColormapAt(t *testing.T) {
+	m, err := parseColormap("000000,ff0000,ffffff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		t    float64
+		want color.RGBA
+	}{
+		{-1, color.RGBA{0, 0, 0, 255}},
+		{0, color.RGBA{0, 0, 0, 255}},
+		{0.25, color.RGBA{188, 0, 0, 255}}, // half the light of red
+		{0.5, color.RGBA{255, 0, 0, 255}},
+		{1, color.RGBA{255, 255, 255, 255}},
+		{2, color.RGBA{255, 255, 255, 255}},
+		{math.NaN(), color.RGBA{0, 0, 0, 255}},
+	} {
+		if got := m.At(tc.t); got != tc.want {
+			t.Errorf("At(%g) = %v, want %v", tc.t, got, tc.want)
+		}
+	}
+	if m.Name != "000000ff,ff0000ff,ffffffff" {
+		t.Errorf("custom colormap named %q", m.Name)
+	}
+
+	if v, _ := parseColormap("viridis"); v.At(0) != (color.RGBA{0x44, 0x01, 0x54, 255}) || v.At(1) != (color.RGBA{0xfd, 0xe7, 0x25, 255}) {
+		t.Errorf("viridis runs from %v to %v", v.At(0), v.At(1))
+	}
+	for _, bad := range []string{"jet", "ff0000", "ff0000,zz"} {
+		if _, err := parseColormap(bad); err == nil {
+			t.Errorf("parseColormap(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestDrawPeaksColormap(t *testing.T) {
+	// A quiet column and a full scale one
+	peaks := ChannelPeaks{Min: []int16{-1000, -32767}, Max: []int16{1000, 32767}}
+	ro := DefaultRenderOptions()
+	ro.Width, ro.Height = 2, 100
+	ro.Colormap, _ = parseColormap("grayscale")
+
+	for name, backend := range renderBackends {
+		img, err := backend.Draw(peaks, ro, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if quiet, loud := img.RGBAAt(0, 50), img.RGBAAt(1, 50); quiet.R > 64 || loud.R < 245 {
+			t.Errorf("%s: quiet column drawn as %v and loud one as %v, want dark and near white", name, quiet, loud)
+		}
+		putImage(img)
+	}
+}
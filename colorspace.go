@@ -0,0 +1,103 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Colors are given and stored in sRGB, whose 8-bit values are gamma
+// encoded: 128 is about a fifth of the light of 255, not half of it.
+// Mixing the values directly darkens the middle of every gradient and
+// every partly covered pixel, so colors are mixed here in linear light and
+// encoded back to sRGB.
+
+// srgbLinear maps each 8-bit sRGB value to its linear light in [0, 1]
+var srgbLinear = func() (table [256]float64) {
+	for i := range table {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			table[i] = v / 12.92
+		} else {
+			table[i] = math.Pow((v+0.055)/1.055, 2.4)
+		}
+	}
+	return table
+}()
+
+// encodeSRGB returns the sRGB value in [0, 1] of linear light l
+func encodeSRGB(l float64) float64 {
+	l = min(max(l, 0), 1)
+	if l <= 0.0031308 {
+		return l * 12.92
+	}
+	return 1.055*math.Pow(l, 1/2.4) - 0.055
+}
+
+// linearToSRGB returns the 8-bit sRGB value of linear light l
+func linearToSRGB(l float64) uint8 {
+	return uint8(math.Round(encodeSRGB(l) * 255))
+}
+
+// mixLinear returns the straight alpha color a fraction f of the way from
+// a to b, mixing their light. Alpha is not gamma encoded and mixes as is.
+func mixLinear(a, b color.RGBA, f float64) color.RGBA {
+	mix := func(x, y uint8) uint8 {
+		return linearToSRGB(srgbLinear[x] + (srgbLinear[y]-srgbLinear[x])*f)
+	}
+	return color.RGBA{
+		mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B),
+		uint8(math.Round(float64(a.A) + (float64(b.A)-float64(a.A))*f)),
+	}
+}
+
+// blendOver composites the straight alpha color src over the premultiplied
+// pixel dst in linear light, returning the premultiplied pixel image.RGBA
+// stores. Over a transparent pixel it premultiplies src.
+func blendOver(dst, src color.RGBA) color.RGBA {
+	switch src.A {
+	case 255:
+		return src
+	case 0:
+		return dst
+	}
+
+	d := unpremultiply(dst)
+	sa, da := float64(src.A)/255, float64(dst.A)/255
+	a := sa + da*(1-sa)
+	channel := func(s, d uint8) uint8 {
+		light := srgbLinear[s]*sa + srgbLinear[d]*da*(1-sa)
+		return uint8(math.Round(encodeSRGB(light/a) * a * 255))
+	}
+	return color.RGBA{channel(src.R, d.R), channel(src.G, d.G), channel(src.B, d.B), uint8(math.Round(a * 255))}
+}
+
+// unpremultiply returns the straight alpha color of a premultiplied pixel
+func unpremultiply(p color.RGBA) color.RGBA {
+	if p.A == 0 || p.A == 255 {
+		return p
+	}
+	straight := func(v uint8) uint8 {
+		return uint8(min(math.Round(float64(v)*255/float64(p.A)), 255))
+	}
+	return color.RGBA{straight(p.R), straight(p.G), straight(p.B), p.A}
+}
+
+// blendPixel draws the straight alpha color c over the pixel at x, y
+func blendPixel(img *image.RGBA, x, y int, c color.RGBA) {
+	if c.A == 255 {
+		img.SetRGBA(x, y, c)
+		return
+	}
+	img.SetRGBA(x, y, blendOver(img.RGBAAt(x, y), c))
+}
+
+// backgroundPixel returns the premultiplied pixel of the background
+func (ro RenderOptions) backgroundPixel() color.RGBA {
+	return blendOver(color.RGBA{}, ro.Background)
+}
+
+// inkPixel returns the pixel of c drawn over the background
+func (ro RenderOptions) inkPixel(c color.RGBA) color.RGBA {
+	return blendOver(ro.backgroundPixel(), c)
+}
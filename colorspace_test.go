@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestBlendOver(t *testing.T) {
+	white, black := color.RGBA{255, 255, 255, 255}, color.RGBA{0, 0, 0, 255}
+
+	// Half black over white lets half the light through, which sRGB
+	// encodes as 187 rather than 128
+	if got := blendOver(white, color.RGBA{0, 0, 0, 128}); got != (color.RGBA{187, 187, 187, 255}) {
+		t.Errorf("half black over white = %v", got)
+	}
+	if got := blendOver(white, black); got != black {
+		t.Errorf("opaque black over white = %v", got)
+	}
+	if got := blendOver(white, color.RGBA{}); got != white {
+		t.Errorf("transparent over white = %v", got)
+	}
+
+	// Over nothing a color is only premultiplied
+	if got := blendOver(color.RGBA{}, color.RGBA{255, 0, 0, 160}); got != (color.RGBA{160, 0, 0, 160}) {
+		t.Errorf("translucent red over nothing = %v", got)
+	}
+	if got := unpremultiply(color.RGBA{160, 0, 0, 160}); got != (color.RGBA{255, 0, 0, 160}) {
+		t.Errorf("unpremultiplied red = %v", got)
+	}
+
+	if got := mixLinear(black, white, 0.5); got != (color.RGBA{188, 188, 188, 255}) {
+		t.Errorf("midpoint of black and white = %v", got)
+	}
+}
+
+func TestDrawPeaksTranslucentForeground(t *testing.T) {
+	peaks := ChannelPeaks{Min: []int16{-32767}, Max: []int16{32767}}
+	ro := DefaultRenderOptions()
+	ro.Width, ro.Height = 1, 10
+	ro.Foreground = color.RGBA{0, 0, 0, 128}
+	ro.Background = color.RGBA{255, 255, 255, 255}
+
+	for name, backend := range renderBackends {
+		img, err := backend.Draw(peaks, ro, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := img.RGBAAt(0, 5); got != (color.RGBA{187, 187, 187, 255}) {
+			t.Errorf("%s: half black waveform on white drawn as %v", name, got)
+		}
+		putImage(img)
+	}
+}
+
+func TestEncodePNGTagsSRGB(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	var buf bytes.Buffer
+	if err := encodePNG(&buf, img, png.DefaultCompression); err != nil {
+		t.Fatal(err)
+	}
+
+	var kinds []string
+	data := buf.Bytes()[8:]
+	for len(data) >= 12 {
+		n := binary.BigEndian.Uint32(data)
+		kinds = append(kinds, string(data[4:8]))
+		data = data[12+n:]
+	}
+	if len(kinds) < 5 || kinds[0] != "IHDR" || kinds[1] != "sRGB" || kinds[2] != "gAMA" || kinds[3] != "cHRM" || kinds[4] != "IDAT" {
+		t.Errorf("chunks = %v, want IHDR, sRGB, gAMA, cHRM and then the image data", kinds)
+	}
+
+	// The chunks and their checksums must not upset a decoder
+	if _, err := png.Decode(&buf); err != nil {
+		t.Errorf("tagged PNG does not decode: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sync"
+)
+
+// fileLevel is what the common scale of a batch is worked out from: the
+// loudest peak of a file as it is drawn, and the gain that brings it to the
+// target loudness
+type fileLevel struct {
+	peak int
+	gain float64
+}
+
+// commonScale returns the gain of every input, by name, that draws them all
+// on one scale: the loudest of them fills all but HeadroomDB of the
+// height. With a targetLUFS each file is first brought to that integrated
+// loudness, so the images compare the files as they would sound loudness
+// normalized. Inputs that can't be measured are warned about and left out,
+// to fail again when they are rendered.
+func (r *batchRun) commonScale(inputs []ObjectInfo, targetLUFS float64) (map[string]float64, float64) {
+	var (
+		mu     sync.Mutex
+		levels = map[string]fileLevel{}
+		wg     sync.WaitGroup
+	)
+	for _, obj := range inputs {
+		if !isInputName(obj.Name) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			level, err := r.measureLevel(obj, targetLUFS)
+			if err != nil {
+				fmt.Printf("Warning: failed to measure for -common-scale: %v  %v\n", r.batch.batchInput(obj).Location, err)
+				return
+			}
+			mu.Lock()
+			levels[obj.Name] = level
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	loudest := 0.0
+	for _, l := range levels {
+		loudest = max(loudest, float64(l.peak)*l.gain)
+	}
+	scale := math.Pow(10, -r.opts.HeadroomDB/20)
+	if loudest > 0 {
+		scale *= 32767 / loudest
+	}
+
+	gains := make(map[string]float64, len(levels))
+	for name, l := range levels {
+		gains[name] = l.gain * scale
+	}
+	return gains, scale
+}
+
+// measureLevel decodes an input to the peaks it is drawn from, and its
+// loudness when there is a target for it
+func (r *batchRun) measureLevel(obj ObjectInfo, targetLUFS float64) (fileLevel, error) {
+	input := r.batch.batchInput(obj)
+	opts := r.opts
+	if r.batch.remoteInput() {
+		local, windowed, err := r.batch.fetchInput(input.Name, opts)
+		if err != nil {
+			return fileLevel{}, err
+		}
+		defer os.Remove(local)
+		input.Path = local
+		if windowed {
+			opts.Start, opts.End = 0, 0
+		}
+	}
+
+	var analyzers []namedAnalyzer
+	var loudness *loudnessAnalyzer
+	if targetLUFS != 0 {
+		loudness = newLoudnessAnalyzer(opts.Analysis).(*loudnessAnalyzer)
+		analyzers = append(analyzers, namedAnalyzer{name: "loudness", Analyzer: loudness})
+	}
+
+	info, _ := probeInput(input.Path, opts)
+	memoryNeeded := estimateMemory(opts, info, analyzers, decorations{})
+	opts.Memory.Acquire(memoryNeeded)
+	defer opts.Memory.Release(memoryNeeded)
+
+	peaks, _, _, err := loadPeaks(input, opts, analyzers)
+	if err != nil {
+		return fileLevel{}, err
+	}
+	defer releasePeaks(peaks)
+
+	level := fileLevel{peak: loudestPeak(peaks.Channels[0]), gain: 1}
+	// Silent files have no loudness to bring to the target
+	if loudness != nil {
+		if lufs := loudness.Result().(LoudnessReport).IntegratedLUFS; lufs != nil {
+			level.gain = math.Pow(10, (targetLUFS-*lufs)/20)
+		}
+	}
+	return level, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestCommonScale(t *testing.T) {
+	dir := t.TempDir()
+	loud, quiet := DefaultTestAudio(), DefaultTestAudio()
+	loud.Amplitude, quiet.Amplitude = 0.8, 0.2
+	WriteTestAudioFile(filepath.Join(dir, "loud.wav"), loud)
+	WriteTestAudioFile(filepath.Join(dir, "quiet.wav"), quiet)
+	inputs := []ObjectInfo{{Name: "loud.wav"}, {Name: "quiet.wav"}, {Name: "missing.wav"}, {Name: "notes.txt"}}
+
+	batch, err := newStorageBatch(dir, t.TempDir(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer batch.Close()
+	run := &batchRun{batch: batch, opts: Options{Width: 100, Height: 20}}
+
+	// Both are drawn as the loud one is fitted, the quiet one at a quarter
+	// of its height
+	gains, scale := run.commonScale(inputs, 0)
+	if len(gains) != 2 || gains["loud.wav"] != scale || gains["quiet.wav"] != scale {
+		t.Fatalf("gains %v, scale %v", gains, scale)
+	}
+	if math.Abs(scale-1/0.8) > 0.01 {
+		t.Errorf("scale %v, want the loud file's peak at full scale", scale)
+	}
+
+	// Loudness normalized, the quiet one is brought up to the loud one
+	gains, _ = run.commonScale(inputs, -23)
+	if ratio := gains["quiet.wav"] / gains["loud.wav"]; math.Abs(ratio-4) > 0.05 {
+		t.Errorf("quiet file gains %v times the loud one, want 4", ratio)
+	}
+}
+
+func TestGainCaption(t *testing.T) {
+	ro := DefaultRenderOptions()
+	ro.Width, ro.Height = 200, 40
+	peaks := ChannelPeaks{Min: make([]int16, 200), Max: make([]int16, 200)}
+	for i := range peaks.Max {
+		peaks.Min[i], peaks.Max[i] = -1000, 1000
+	}
+
+	ro.Gain = 2
+	if g := ro.gain(peaks); g != 2 {
+		t.Errorf("gain %v, want the fixed 2", g)
+	}
+	if !ro.captionsScale() {
+		t.Error("a fixed gain isn't captioned")
+	}
+}
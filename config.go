@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix starts the environment variables naming flags, e.g.
+// WAVEFORM_MAX_MEMORY for -max-memory
+const envPrefix = "WAVEFORM_"
+
+// envName is the environment variable of a flag
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// parseFlags parses the command line of fs and fills in the flags it
+// doesn't set from the -config file, then from WAVEFORM_* environment
+// variables, so flags win over the file and the file over the environment.
+// -config itself may come from WAVEFORM_CONFIG.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	configFile := fs.String("config", os.Getenv(envName("config")), "file of name = value lines setting flags not given on the command line")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	values := map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if v, ok := os.LookupEnv(envName(f.Name)); ok && f.Name != "config" {
+			values[f.Name] = v
+		}
+	})
+
+	var sources map[string]string
+	if *configFile != "" {
+		config, err := readConfig(fs, *configFile)
+		if err != nil {
+			return err
+		}
+		for name, v := range config {
+			values[name] = v
+		}
+		sources = config
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for name, v := range values {
+		if set[name] {
+			continue
+		}
+		if err := fs.Set(name, v); err != nil {
+			source := envName(name)
+			if _, ok := sources[name]; ok {
+				source = *configFile
+			}
+			return fmt.Errorf("%s: invalid value %q for -%s: %w", source, v, name, err)
+		}
+	}
+	return nil
+}
+
+// readConfig reads a config file of name = value lines, naming flags of fs
+// with or without their dash. Blank lines and lines starting with # are
+// skipped.
+func readConfig(fs *flag.FlagSet, path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config: %w", err)
+	}
+	defer file.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		name, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: want name = value", path, line)
+		}
+		name = strings.TrimLeft(strings.TrimSpace(name), "-")
+		if fs.Lookup(name) == nil || name == "config" {
+			return nil, fmt.Errorf("%s:%d: unknown option %q", path, line, name)
+		}
+		values[name] = strings.TrimSpace(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	return values, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "waveform.conf")
+	if err := os.WriteFile(config, []byte("# batch defaults\n\nheight = 200\n-cache-dir = /var/cache/waveform\nmmap=true\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("WAVEFORM_WIDTH", "800")
+	t.Setenv("WAVEFORM_HEIGHT", "100")
+	t.Setenv("WAVEFORM_CACHE_DIR", "/tmp/cache")
+	t.Setenv("WAVEFORM_PNG_COMPRESSION", "fast")
+	t.Setenv("WAVEFORM_NOT_A_FLAG", "ignored")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	buildOptions := optionFlags(fs)
+	if err := parseFlags(fs, []string{"-config", config, "-png-compression", "best"}); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := buildOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Environment < config file < command line
+	if opts.Width != 800 || opts.Height != 200 || opts.CacheDir != "/var/cache/waveform" || !opts.UseMmap {
+		t.Errorf("options = %+v", opts)
+	}
+	if got := fs.Lookup("png-compression").Value.String(); got != "best" {
+		t.Errorf("-png-compression = %s, want the command line's best", got)
+	}
+	// Flags set from the environment count as set, as on the command line
+	if args := strings.Join(optionArgs(fs), " "); !strings.Contains(args, "-width=800") {
+		t.Errorf("optionArgs = %s", args)
+	}
+}
+
+func TestParseFlagsConfigFromEnv(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "waveform.conf")
+	if err := os.WriteFile(config, []byte("width = 640\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("WAVEFORM_CONFIG", config)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	width := fs.Int("width", 1920, "")
+	if err := parseFlags(fs, nil); err != nil {
+		t.Fatal(err)
+	}
+	if *width != 640 {
+		t.Errorf("width = %d, want 640", *width)
+	}
+}
+
+func TestParseFlagsErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    string
+		config string
+		want   string
+	}{
+		{"bad environment value", "WAVEFORM_WIDTH=wide", "", "WAVEFORM_WIDTH"},
+		{"bad config value", "", "width = wide\n", "waveform.conf"},
+		{"unknown config option", "", "widht = 800\n", "waveform.conf:1"},
+		{"config line without a value", "", "# defaults\nwidth\n", "waveform.conf:2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if name, value, ok := strings.Cut(tt.env, "="); ok {
+				t.Setenv(name, value)
+			}
+			var args []string
+			if tt.config != "" {
+				config := filepath.Join(t.TempDir(), "waveform.conf")
+				if err := os.WriteFile(config, []byte(tt.config), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				args = []string{"-config", config}
+			}
+
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.Int("width", 1920, "")
+			err := parseFlags(fs, args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want one naming %s", err, tt.want)
+			}
+		})
+	}
+}
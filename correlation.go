@@ -0,0 +1,215 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// correlationWindow is the length of the windows correlation is measured over
+const correlationWindow = 0.1 // seconds
+
+// correlationStripHeight is the height of the strip drawn by -correlation-strip
+const correlationStripHeight = 40
+
+// correlationNegativeColor draws negative correlation in the strip
+var correlationNegativeColor = color.RGBA{200, 0, 0, 255}
+
+// correlationAxisColor draws the zero line of the strip
+var correlationAxisColor = color.RGBA{192, 192, 192, 255}
+
+// CorrelationRegion is a run of windows below the correlation threshold
+type CorrelationRegion struct {
+	Start    float64 `json:"start"`
+	End      float64 `json:"end"`
+	Duration float64 `json:"duration"`
+	Min      float64 `json:"min_correlation"`
+}
+
+// CorrelationReport is the "correlation" section of a file report
+type CorrelationReport struct {
+	Threshold        float64             `json:"threshold"`
+	WindowSeconds    float64             `json:"window_seconds"`
+	Overall          *float64            `json:"overall"`
+	Min              *float64            `json:"min"`
+	RegionCount      int                 `json:"region_count"`
+	Regions          []CorrelationRegion `json:"regions"`
+	RegionsTruncated bool                `json:"regions_truncated"`
+}
+
+// correlationAnalyzer measures stereo phase correlation per window
+type correlationAnalyzer struct {
+	cfg  AnalysisConfig
+	info StreamInfo
+
+	windowFrames int
+	windowPos    int
+	window       [3]float64 // sums of l*r, l*l and r*r in the current window
+	total        [3]float64
+
+	// values holds the correlation of each window; NaN where a channel is silent
+	values []float64
+}
+
+func newCorrelationAnalyzer(cfg AnalysisConfig) Analyzer {
+	return &correlationAnalyzer{cfg: cfg}
+}
+
+// Start implements Analyzer
+func (a *correlationAnalyzer) Start(info StreamInfo) {
+	a.info = info
+	a.windowFrames = max(1, int(correlationWindow*float64(info.SampleRate)))
+	a.values = make([]float64, 0, info.NumFrames/a.windowFrames+1)
+}
+
+// memoryEstimate implements memoryUser: one value per window
+func (a *correlationAnalyzer) memoryEstimate(info StreamInfo) int64 {
+	return windowCount(info, correlationWindow) * 8
+}
+
+// Add implements Analyzer
+func (a *correlationAnalyzer) Add(left, right []int16) {
+	if right == nil {
+		return
+	}
+
+	for i := range left {
+		l, r := float64(left[i]), float64(right[i])
+		a.window[0] += l * r
+		a.window[1] += l * l
+		a.window[2] += r * r
+
+		a.windowPos++
+		if a.windowPos == a.windowFrames {
+			a.endWindow()
+		}
+	}
+}
+
+// endWindow records the correlation of the window that just finished
+func (a *correlationAnalyzer) endWindow() {
+	a.values = append(a.values, correlation(a.window))
+	for i := range a.total {
+		a.total[i] += a.window[i]
+	}
+	a.window = [3]float64{}
+	a.windowPos = 0
+}
+
+// correlation returns the correlation coefficient of the sums, or NaN when
+// either channel is silent
+func correlation(sums [3]float64) float64 {
+	if sums[1] == 0 || sums[2] == 0 {
+		return math.NaN()
+	}
+	return sums[0] / math.Sqrt(sums[1]*sums[2])
+}
+
+// Result implements Analyzer
+func (a *correlationAnalyzer) Result() any {
+	if a.windowPos > 0 {
+		a.endWindow()
+	}
+
+	report := CorrelationReport{
+		Threshold:     a.cfg.CorrelationThreshold,
+		WindowSeconds: correlationWindow,
+		Overall:       finiteOrNil(correlation(a.total)),
+		Regions:       []CorrelationRegion{},
+	}
+
+	lowest := math.Inf(1)
+	regionStart := -1
+	regionMin := 0.0
+
+	endRegion := func(end int) {
+		report.RegionCount++
+		if len(report.Regions) == maxReportedRegions {
+			report.RegionsTruncated = true
+			return
+		}
+		start := framesToSeconds(regionStart*a.windowFrames, a.info.SampleRate)
+		stop := framesToSeconds(min(end*a.windowFrames, a.info.NumFrames), a.info.SampleRate)
+		report.Regions = append(report.Regions, CorrelationRegion{
+			Start:    start,
+			End:      stop,
+			Duration: stop - start,
+			Min:      regionMin,
+		})
+	}
+
+	for i, v := range a.values {
+		if math.IsNaN(v) {
+			continue
+		}
+		lowest = min(lowest, v)
+
+		if v < a.cfg.CorrelationThreshold {
+			if regionStart < 0 {
+				regionStart, regionMin = i, v
+			}
+			regionMin = min(regionMin, v)
+		} else if regionStart >= 0 {
+			endRegion(i)
+			regionStart = -1
+		}
+	}
+	if regionStart >= 0 {
+		endRegion(len(a.values))
+	}
+
+	report.Min = finiteOrNil(lowest)
+
+	return report
+}
+
+// stripHeight implements imageStrip
+func (a *correlationAnalyzer) stripHeight() int {
+	return correlationStripHeight
+}
+
+// drawStrip implements imageStrip. Each column shows the mean correlation of
+// the windows it covers, upwards when positive and downwards when negative.
+func (a *correlationAnalyzer) drawStrip(img *image.RGBA, band image.Rectangle) {
+	width := band.Dx()
+	centerY := band.Min.Y + band.Dy()/2
+	halfHeight := float64(band.Dy()-1) / 2
+
+	for x := 0; x < width; x++ {
+		img.SetRGBA(band.Min.X+x, centerY, correlationAxisColor)
+	}
+
+	numWindows := len(a.values)
+	if numWindows == 0 {
+		return
+	}
+
+	for x := 0; x < width; x++ {
+		startWindow := x * numWindows / width
+		endWindow := max((x+1)*numWindows/width, startWindow+1)
+
+		sum, n := 0.0, 0
+		for _, v := range a.values[startWindow:endWindow] {
+			if !math.IsNaN(v) {
+				sum += v
+				n++
+			}
+		}
+		if n == 0 {
+			continue
+		}
+
+		mean := sum / float64(n)
+		barHeight := int(math.Round(mean * halfHeight))
+
+		c := waveformColor
+		y0, y1 := centerY-barHeight, centerY
+		if barHeight < 0 {
+			c = correlationNegativeColor
+			y0, y1 = centerY, centerY-barHeight
+		}
+		for y := y0; y <= y1; y++ {
+			img.SetRGBA(band.Min.X+x, y, c)
+		}
+	}
+}
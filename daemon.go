@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultPollInterval is how often the daemon lists its input
+const defaultPollInterval = 30 * time.Second
+
+// daemon keeps a media folder rendered: it polls the input location,
+// renders files that are new or changed once they have stopped changing,
+// and parses its command line and -config file again on SIGHUP
+type daemon struct {
+	args        []string
+	concurrency int
+
+	// seen are the versions of the inputs processed; pending those of new
+	// or changed inputs on the last poll, which are processed when a poll
+	// finds them unchanged, so files still being copied in are left alone
+	seen    map[string]string
+	pending map[string]string
+}
+
+// daemonConfig is what the daemon runs with until the next reload
+type daemonConfig struct {
+	inputPath string
+	interval  time.Duration
+	run       *batchRun
+}
+
+// runDaemon runs the daemon subcommand until interrupted
+func runDaemon(args []string) error {
+	d := &daemon{args: args, seen: map[string]string{}, pending: map[string]string{}}
+
+	// The pool and the metrics listener outlive reloads
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	cfg, err := d.load(fs)
+	if err != nil {
+		return err
+	}
+	d.concurrency = max(intFlag(fs, "concurrency"), 1)
+	if addr := fs.Lookup("metrics-addr").Value.String(); addr != "" {
+		serveMetrics(addr)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	reload := reloadSignals()
+
+	fmt.Printf("Watching %s every %s\n", cfg.inputPath, cfg.interval)
+	sdNotify("READY=1")
+	for {
+		d.poll(ctx, cfg)
+
+		select {
+		case <-ctx.Done():
+			sdNotify("STOPPING=1")
+			cfg.run.batch.Close()
+			return nil
+		case <-reload:
+			sdNotify("RELOADING=1")
+			next, err := d.load(flag.NewFlagSet("daemon", flag.ContinueOnError))
+			if err != nil {
+				fmt.Printf("Reload failed, keeping the previous configuration: %v\n", err)
+			} else {
+				cfg.run.batch.Close()
+				cfg = next
+				configReloads.inc()
+				fmt.Printf("Reloaded configuration, watching %s every %s\n", cfg.inputPath, cfg.interval)
+			}
+			sdNotify("READY=1")
+		case <-time.After(cfg.interval):
+		}
+	}
+}
+
+// load parses the daemon's command line and -config file into fs and
+// returns the configuration they describe
+func (d *daemon) load(fs *flag.FlagSet) (*daemonConfig, error) {
+	inputPath := fs.String("input", "./audios", "directory or s3://, gs:// or az:// bucket/prefix to watch for WAV files")
+	outputDir := fs.String("output", "./waveforms", "directory or s3://, gs:// or az:// bucket/prefix to write waveform images to")
+	interval := fs.Duration("interval", defaultPollInterval, "how often the input is listed; a new or changed file is rendered on the first listing that finds it unchanged")
+	fs.Int("concurrency", runtime.NumCPU(), "files processed at once (not reloaded)")
+	storageConcurrency := fs.Int("storage-concurrency", 4, "concurrent downloads and uploads for remote storage")
+	retries, retryDelay := retryFlags(fs)
+	maxMemory := fs.String("max-memory", "", "limit on memory held across all files, e.g. 512MB (unlimited when empty)")
+	fs.String("metrics-addr", "", "serve Prometheus metrics on this address, e.g. localhost:9090 (not reloaded)")
+	verboseFlag := fs.Bool("verbose", false, "print the header details of every file")
+	sinks := fs.String("sinks", "", "also copy every file's outputs to these comma-separated locations: directories, s3://, gs://, az://, ftp:// or sftp:// prefixes, or http(s):// URLs that are POSTed a JSON event holding them")
+	webhookURL := fs.String("webhook", "", "POST a JSON event about every file to this URL when it is done")
+	historyDB := fs.String("db", "", "record every processed file in this SQLite database (needs the sqlite3 command)")
+	skipDone := fs.Bool("skip-done", false, "skip files the -db history shows were processed completely with the same content and options, e.g. after a restart")
+	onError := fs.String("on-error", OnErrorContinue, "what to do about a failing file: continue (keep the outputs it did produce) or skip-file (drop all its outputs)")
+	quarantine := fs.String("quarantine", "", "copy failing inputs to this directory and list them with their errors in its "+quarantineList)
+	buildOptions := optionFlags(fs)
+	if err := parseFlags(fs, d.args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	verbose = *verboseFlag
+
+	if *interval <= 0 {
+		return nil, fmt.Errorf("-interval must be positive")
+	}
+	if *onError == OnErrorAbort {
+		return nil, fmt.Errorf("-on-error abort would stop the daemon for good; use continue or skip-file")
+	}
+
+	opts, err := buildOptions()
+	if err != nil {
+		return nil, err
+	}
+	if *maxMemory != "" {
+		limit, err := parseByteSize(*maxMemory)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse -max-memory: %w", err)
+		}
+		opts.Memory = NewMemoryBudget(limit)
+	}
+	if opts.OnError, err = NewErrorPolicy(*onError, *quarantine); err != nil {
+		return nil, err
+	}
+
+	batch, err := newStorageBatch(*inputPath, *outputDir, *storageConcurrency)
+	if err != nil {
+		return nil, err
+	}
+	batch.retry = RetryPolicy{Retries: *retries, Delay: *retryDelay}
+	if batch.sinks, err = openOutputSinks(*sinks, batch.retry); err != nil {
+		batch.Close()
+		return nil, err
+	}
+
+	run := &batchRun{outputDir: *outputDir, batch: batch, opts: opts}
+	if *webhookURL != "" {
+		run.webhook = newWebhookSink(*webhookURL)
+	}
+	if *historyDB != "" {
+		if run.history, err = OpenHistory(*historyDB); err != nil {
+			batch.Close()
+			return nil, fmt.Errorf("failed to open -db: %w", err)
+		}
+		run.skipDone = *skipDone
+		run.options = strings.Join(optionArgs(fs), " ")
+	} else if *skipDone {
+		batch.Close()
+		return nil, fmt.Errorf("-skip-done requires -db")
+	}
+
+	return &daemonConfig{inputPath: *inputPath, interval: *interval, run: run}, nil
+}
+
+// poll lists the input once and processes the inputs that are ready, up to
+// the daemon's concurrency at a time, returning when they are done. No
+// further file is started once ctx is done.
+func (d *daemon) poll(ctx context.Context, cfg *daemonConfig) {
+	objects, err := cfg.run.batch.listInputs()
+	if err != nil {
+		fmt.Printf("Error listing input files: %v\n", err)
+		return
+	}
+
+	var names []string
+	for _, obj := range objects {
+		if isInputName(obj.Name) {
+			names = append(names, obj.Name)
+		}
+	}
+	cfg.run.batch.assignOutputNames(names)
+
+	slots := make(chan struct{}, d.concurrency)
+	var wg sync.WaitGroup
+	for _, obj := range d.ready(objects) {
+		select {
+		case <-ctx.Done():
+		case slots <- struct{}{}:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		queueDepth.add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			cfg.run.process(obj)
+		}()
+	}
+	wg.Wait()
+}
+
+// ready returns the inputs of a listing to process: those whose version
+// differs from the one processed and matches the one on the last poll.
+// Inputs that are gone are forgotten, so they are processed if they return.
+// A failed input is tried again when it changes.
+func (d *daemon) ready(objects []ObjectInfo) []ObjectInfo {
+	var ready []ObjectInfo
+	listed := map[string]bool{}
+	for _, obj := range objects {
+		if !isInputName(obj.Name) {
+			continue
+		}
+		listed[obj.Name] = true
+
+		version := fmt.Sprintf("%d|%s|%d", obj.Size, obj.ETag, obj.ModTime.UnixNano())
+		if d.seen[obj.Name] == version {
+			continue
+		}
+		if d.pending[obj.Name] != version {
+			d.pending[obj.Name] = version
+			continue
+		}
+		delete(d.pending, obj.Name)
+		d.seen[obj.Name] = version
+		ready = append(ready, obj)
+	}
+
+	for name := range d.seen {
+		if !listed[name] {
+			delete(d.seen, name)
+		}
+	}
+	for name := range d.pending {
+		if !listed[name] {
+			delete(d.pending, name)
+		}
+	}
+	return ready
+}
+
+// intFlag returns the value of an int flag of fs
+func intFlag(fs *flag.FlagSet, name string) int {
+	return fs.Lookup(name).Value.(flag.Getter).Get().(int)
+}
+
+// sdNotify tells systemd about the state of the daemon when it runs as a
+// Type=notify service, which sets NOTIFY_SOCKET; otherwise it does nothing
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		fmt.Printf("Warning: failed to notify systemd: %v\n", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		fmt.Printf("Warning: failed to notify systemd: %v\n", err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDaemonReady(t *testing.T) {
+	d := &daemon{seen: map[string]string{}, pending: map[string]string{}}
+	take := ObjectInfo{Name: "take.wav", Size: 100}
+
+	if ready := d.ready([]ObjectInfo{take, {Name: "notes.txt"}}); len(ready) != 0 {
+		t.Errorf("a file ready on its first listing: %v", ready)
+	}
+	// Still growing
+	take.Size = 200
+	if ready := d.ready([]ObjectInfo{take}); len(ready) != 0 {
+		t.Errorf("a growing file ready: %v", ready)
+	}
+	if ready := d.ready([]ObjectInfo{take}); len(ready) != 1 {
+		t.Errorf("a settled file not ready: %v", ready)
+	}
+	if ready := d.ready([]ObjectInfo{take}); len(ready) != 0 {
+		t.Errorf("a processed file ready again: %v", ready)
+	}
+
+	// A file that goes and comes back is processed again
+	d.ready(nil)
+	d.ready([]ObjectInfo{take})
+	if ready := d.ready([]ObjectInfo{take}); len(ready) != 1 {
+		t.Errorf("a returning file not ready: %v", ready)
+	}
+}
+
+func TestDaemonPollAndReload(t *testing.T) {
+	inputDir, outputDir := t.TempDir(), t.TempDir()
+	WriteTestAudioFile(filepath.Join(inputDir, "take.wav"), DefaultTestAudio())
+	config := filepath.Join(t.TempDir(), "daemon.conf")
+	os.WriteFile(config, []byte("width = 100\nheight = 20\n"), 0o644)
+
+	d := &daemon{args: []string{"-config", config, "-input", inputDir, "-output", outputDir}, concurrency: 2,
+		seen: map[string]string{}, pending: map[string]string{}}
+	cfg, err := d.load(flag.NewFlagSet("daemon", flag.ContinueOnError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cfg.run.batch.Close()
+	if cfg.run.opts.Width != 100 || cfg.interval != defaultPollInterval {
+		t.Errorf("loaded width %d, interval %v", cfg.run.opts.Width, cfg.interval)
+	}
+
+	d.poll(context.Background(), cfg)
+	if _, err := os.Stat(filepath.Join(outputDir, "take.png")); err == nil {
+		t.Error("rendered on the first poll")
+	}
+	d.poll(context.Background(), cfg)
+	if _, err := os.Stat(filepath.Join(outputDir, "take.png")); err != nil {
+		t.Error(err)
+	}
+
+	// A reload reads the config file again
+	os.WriteFile(config, []byte("width = 300\ninterval = 5s\n"), 0o644)
+	cfg, err = d.load(flag.NewFlagSet("daemon", flag.ContinueOnError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cfg.run.batch.Close()
+	if cfg.run.opts.Width != 300 || cfg.interval.Seconds() != 5 {
+		t.Errorf("reloaded width %d, interval %v", cfg.run.opts.Width, cfg.interval)
+	}
+
+	os.WriteFile(config, []byte("on-error = abort\n"), 0o644)
+	if _, err := d.load(flag.NewFlagSet("daemon", flag.ContinueOnError)); err == nil {
+		t.Error("-on-error abort accepted")
+	}
+}
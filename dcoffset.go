@@ -0,0 +1,72 @@
+package main
+
+import "math"
+
+// ChannelDCOffset holds the mean sample value of one channel
+type ChannelDCOffset struct {
+	Channel string   `json:"channel"`
+	Offset  float64  `json:"offset"` // normalized, in [-1, 1]
+	LevelDB *float64 `json:"level_dbfs"`
+}
+
+// DCOffsetReport is the "dc_offset" section of a file report
+type DCOffsetReport struct {
+	Removed  bool              `json:"removed"`
+	Channels []ChannelDCOffset `json:"channels"`
+}
+
+// dcAnalyzer measures the mean of each channel
+type dcAnalyzer struct {
+	sum     [2]int64
+	frames  int64
+	stereo  bool
+	removed bool // set when the offset was subtracted before rendering
+}
+
+func newDCAnalyzer(cfg AnalysisConfig) Analyzer {
+	return &dcAnalyzer{}
+}
+
+// Start implements Analyzer
+func (a *dcAnalyzer) Start(info StreamInfo) {}
+
+// Add implements Analyzer
+func (a *dcAnalyzer) Add(left, right []int16) {
+	for _, v := range left {
+		a.sum[0] += int64(v)
+	}
+	if right != nil {
+		a.stereo = true
+		for _, v := range right {
+			a.sum[1] += int64(v)
+		}
+	}
+	a.frames += int64(len(left))
+}
+
+// offset returns the mean of a channel in 16-bit sample units
+func (a *dcAnalyzer) offset(ch int) float64 {
+	if a.frames == 0 {
+		return 0
+	}
+	return float64(a.sum[ch]) / float64(a.frames)
+}
+
+// Result implements Analyzer
+func (a *dcAnalyzer) Result() any {
+	report := DCOffsetReport{Removed: a.removed}
+
+	for ch, name := range channelNames {
+		if ch == 1 && !a.stereo {
+			break
+		}
+		offset := a.offset(ch) / 32768.0
+		report.Channels = append(report.Channels, ChannelDCOffset{
+			Channel: name,
+			Offset:  offset,
+			LevelDB: finiteOrNil(20 * math.Log10(math.Abs(offset))),
+		})
+	}
+
+	return report
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+)
+
+// imageOverlay draws on top of the waveform itself, such as trim markers
+type imageOverlay interface {
+	drawOverlay(img *image.RGBA)
+}
+
+// imageStrip is an extra band drawn below the waveform, such as the
+// correlation strip
+type imageStrip interface {
+	stripHeight() int
+	drawStrip(img *image.RGBA, band image.Rectangle)
+}
+
+// imagePanel is an extra area drawn to the right of the waveform, level
+// with it, such as the histogram panel
+type imagePanel interface {
+	panelWidth() int
+	drawPanel(img *image.RGBA, area image.Rectangle)
+}
+
+// decorations are drawn over and around a rendered waveform
+type decorations struct {
+	overlays []imageOverlay
+	strips   []imageStrip
+	panels   []imagePanel
+}
+
+// size returns the size of the image a waveform of the given size ends up
+// in once strips and panels are added
+func (d decorations) size(width, height int) (int, int) {
+	for _, p := range d.panels {
+		width += p.panelWidth()
+	}
+	for _, s := range d.strips {
+		height += s.stripHeight()
+	}
+	return width, height
+}
+
+// apply draws the overlays onto img and returns it extended with the panels
+// to the right and the strips below. img is returned as is when there are no
+// strips or panels.
+func (d decorations) apply(img *image.RGBA) *image.RGBA {
+	for _, o := range d.overlays {
+		o.drawOverlay(img)
+	}
+
+	if len(d.strips) == 0 && len(d.panels) == 0 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	waveWidth, waveHeight := bounds.Dx(), bounds.Dy()
+	width, height := d.size(waveWidth, waveHeight)
+
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(out, out.Bounds(), &image.Uniform{backgroundColor}, image.Point{}, draw.Src)
+	draw.Draw(out, image.Rect(0, 0, waveWidth, waveHeight), img, bounds.Min, draw.Src)
+
+	x := waveWidth
+	for _, p := range d.panels {
+		area := image.Rect(x, 0, x+p.panelWidth(), waveHeight)
+		p.drawPanel(out, area)
+		x = area.Max.X
+	}
+
+	// Strips run under the waveform only, so their columns line up with it
+	y := waveHeight
+	for _, s := range d.strips {
+		band := image.Rect(0, y, waveWidth, y+s.stripHeight())
+		s.drawStrip(out, band)
+		y = band.Max.Y
+	}
+
+	return out
+}
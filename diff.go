@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"math/cmplx"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// diffAlignSeconds is how much of the start of each file is used to find the
+// offset between them
+const diffAlignSeconds = 10
+
+// DiffReport compares two versions of the same audio. Offset is how far B's
+// audio starts into A (negative when B starts later); Similarity is the
+// correlation of the aligned signals and ResidualDB the level of the
+// difference relative to A.
+type DiffReport struct {
+	A                string   `json:"a"`
+	B                string   `json:"b"`
+	OffsetFrames     int      `json:"offset_frames"`
+	OffsetSeconds    float64  `json:"offset_seconds"`
+	OverlapSeconds   float64  `json:"overlap_seconds"`
+	Similarity       *float64 `json:"similarity"`
+	ResidualDB       *float64 `json:"residual_db"`
+	PeakDifferenceDB *float64 `json:"peak_difference_dbfs"`
+}
+
+// mono returns up to n frames of the mono mix of audio
+func mono(audio *AudioData, n int) []float64 {
+	n = min(n, len(audio.LeftChannel))
+	out := make([]float64, n)
+	for i := range out {
+		out[i] = (audio.LeftChannel[i] + audio.RightChannel[i]) / 2
+	}
+	return out
+}
+
+// alignmentOffset returns the lag within ±maxLag at which b best matches a,
+// such that a[i+lag] lines up with b[i]. It cross-correlates via FFT.
+func alignmentOffset(a, b []float64, maxLag int) int {
+	n := 1
+	for n < len(a)+len(b) {
+		n <<= 1
+	}
+
+	x := make([]complex128, n)
+	y := make([]complex128, n)
+	for i, v := range a {
+		x[i] = complex(v, 0)
+	}
+	for i, v := range b {
+		y[i] = complex(v, 0)
+	}
+	fft(x)
+	fft(y)
+
+	// The inverse transform of X·conj(Y) is the circular cross-correlation;
+	// it is computed as a forward transform of the conjugate
+	for i := range x {
+		x[i] = cmplx.Conj(x[i] * cmplx.Conj(y[i]))
+	}
+	fft(x)
+
+	best, bestValue := 0, math.Inf(-1)
+	for lag := -min(maxLag, len(b)-1); lag <= min(maxLag, len(a)-1); lag++ {
+		v := real(x[(lag+n)%n])
+		if v > bestValue {
+			best, bestValue = lag, v
+		}
+	}
+	return best
+}
+
+// diffAudio aligns b to a and returns the left channel difference a-b over
+// their overlap, with the comparison of both channels
+func diffAudio(a, b *AudioData, maxOffset time.Duration) ([]float64, DiffReport) {
+	rate := a.SampleRate
+	alignFrames := diffAlignSeconds * int(rate)
+	maxLag := int(maxOffset.Seconds() * float64(rate))
+
+	offset := alignmentOffset(mono(a, alignFrames+maxLag), mono(b, alignFrames+maxLag), maxLag)
+
+	// Overlap in A's frames
+	startA, startB := max(offset, 0), max(-offset, 0)
+	overlap := max(0, min(len(a.LeftChannel)-startA, len(b.LeftChannel)-startB))
+
+	diff := make([]float64, overlap)
+	var energyA, energyB, cross, energyDiff, peakDiff float64
+
+	channels := [][2][]float64{
+		{a.LeftChannel, b.LeftChannel},
+		{a.RightChannel, b.RightChannel},
+	}
+	for ch, pair := range channels {
+		for i := 0; i < overlap; i++ {
+			va, vb := pair[0][startA+i], pair[1][startB+i]
+			d := va - vb
+			if ch == 0 {
+				diff[i] = d
+			}
+
+			energyA += va * va
+			energyB += vb * vb
+			cross += va * vb
+			energyDiff += d * d
+			peakDiff = max(peakDiff, math.Abs(d))
+		}
+	}
+
+	report := DiffReport{
+		OffsetFrames:     offset,
+		OffsetSeconds:    framesToSeconds(offset, rate),
+		OverlapSeconds:   framesToSeconds(overlap, rate),
+		Similarity:       finiteOrNil(cross / math.Sqrt(energyA*energyB)),
+		ResidualDB:       finiteOrNil(10 * math.Log10(energyDiff/energyA)),
+		PeakDifferenceDB: finiteOrNil(20 * math.Log10(peakDiff)),
+	}
+
+	return diff, report
+}
+
+// runDiff implements the diff subcommand
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	output := fs.String("o", "diff.png", "difference waveform image to write")
+	reportFile := fs.String("report", "", "also write the comparison as JSON to this file")
+	width := fs.Int("width", 1920, "image width in pixels")
+	height := fs.Int("height", 640, "image height in pixels")
+	maxOffset := fs.Duration("max-offset", time.Second, "largest offset between the files searched when aligning them")
+	maxDecoded := fs.String("max-decoded-size", defaultMaxDecodedSize, "largest decoded size of each file held in memory (0 for no limit)")
+	tolerance := fs.Uint("tolerance", 0, "largest per-channel color difference of two images treated as equal")
+	maxDifference := fs.Float64("max-difference", 1, "fail when the difference of two images or peak files is above this (0 fails on any change)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: only_waveform diff [flags] a.wav b.wav | a.png b.png | a.peaks b.peaks\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("diff needs exactly two input files")
+	}
+
+	ro := DefaultRenderOptions()
+	ro.Width, ro.Height = *width, *height
+
+	// Images and peak files from two runs of the renderer are compared as
+	// they are
+	extA, extB := strings.ToLower(filepath.Ext(fs.Arg(0))), strings.ToLower(filepath.Ext(fs.Arg(1)))
+	if extA == ".png" || extB == ".png" || extA == ".peaks" || extB == ".peaks" {
+		if extA != extB {
+			return fmt.Errorf("can't compare a %s file with a %s file", extA, extB)
+		}
+		tol := ImageTolerance{ChannelDelta: uint8(min(*tolerance, 255))}
+		return diffRenders(fs.Arg(0), fs.Arg(1), ro, tol, *output, *reportFile, *maxDifference)
+	}
+
+	maxDecodedSize, err := parseByteSize(*maxDecoded)
+	if err != nil {
+		return fmt.Errorf("failed to parse -max-decoded-size: %w", err)
+	}
+
+	a, err := parseWAVFile(fs.Arg(0), nil, maxDecodedSize)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+	b, err := parseWAVFile(fs.Arg(1), nil, maxDecodedSize)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(1), err)
+	}
+	if a.SampleRate != b.SampleRate {
+		return fmt.Errorf("sample rates differ (%d Hz and %d Hz)", a.SampleRate, b.SampleRate)
+	}
+
+	diff, report := diffAudio(a, b, *maxOffset)
+	report.A, report.B = fs.Arg(0), fs.Arg(1)
+	if len(diff) == 0 {
+		return fmt.Errorf("the files don't overlap at the best offset")
+	}
+
+	peaks := ComputePeaks(diff, *width)
+	defer releasePeaks(&Peaks{Channels: []ChannelPeaks{peaks}})
+
+	img, err := drawPeaks(peaks, ro, nil)
+	if err != nil {
+		return err
+	}
+	defer putImage(img)
+
+	return writeDiff("Difference waveform", img, *output, *reportFile, report)
+}
+
+// writeDiff saves the image of a comparison and prints its report, also
+// writing it to reportFile when set
+func writeDiff(label string, img image.Image, output, reportFile string, report any) error {
+	if err := savePNG(img, output, png.DefaultCompression); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	if reportFile != "" {
+		if err := atomicWriteFile(reportFile, append(data, '\n')); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+	}
+
+	fmt.Printf("%s: %s\n", label, output)
+	fmt.Println(string(data))
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DiskGuard keeps a batch from filling the volume it writes to. Files are
+// only started while the volume has a minimum of free space and the outputs
+// written so far are within a quota; once either runs out the batch is
+// aborted and the files not yet started fail with the reason, while files
+// already in progress are finished. A nil guard allows everything.
+type DiskGuard struct {
+	dir     string
+	minFree int64 // 0 disables the free space check
+	quota   int64 // 0 disables the quota
+
+	mu      sync.Mutex
+	written int64
+	err     error // why the batch was aborted
+}
+
+// NewDiskGuard returns a guard on the volume of dir keeping minFree bytes
+// free and allowing quota bytes of outputs; either may be 0 for no limit
+func NewDiskGuard(dir string, minFree, quota int64) *DiskGuard {
+	return &DiskGuard{dir: dir, minFree: minFree, quota: quota}
+}
+
+// Check returns an error when no further file may be started. The first
+// failure aborts the batch, so every later Check returns it too.
+func (g *DiskGuard) Check() error {
+	if g == nil {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.err != nil {
+		return g.err
+	}
+	if g.quota > 0 && g.written >= g.quota {
+		g.err = fmt.Errorf("output quota of %s reached (%s written)", formatSize(g.quota), formatSize(g.written))
+		return g.err
+	}
+	if g.minFree > 0 {
+		free, err := diskFree(existingParent(g.dir))
+		if errors.Is(err, errors.ErrUnsupported) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to check free disk space: %w", err)
+		}
+		if free < g.minFree {
+			g.err = fmt.Errorf("only %s free on the volume of %s, below the minimum of %s", formatSize(free), g.dir, formatSize(g.minFree))
+			return g.err
+		}
+	}
+	return nil
+}
+
+// Aborted returns why the batch was aborted, or nil when it wasn't
+func (g *DiskGuard) Aborted() error {
+	if g == nil {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.err
+}
+
+// Add counts the files of a finished input against the quota
+func (g *DiskGuard) Add(files []string) {
+	if g == nil {
+		return
+	}
+
+	var size int64
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			size += info.Size()
+		}
+	}
+
+	g.mu.Lock()
+	g.written += size
+	g.mu.Unlock()
+}
+
+// existingParent returns dir, or its closest ancestor that exists when dir
+// hasn't been created yet
+func existingParent(dir string) string {
+	dir = filepath.Clean(dir)
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package main
+
+import "errors"
+
+// diskFree is not available on this platform, so free space goes unchecked
+func diskFree(dir string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
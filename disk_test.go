@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiskGuardQuota(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "take.png")
+	if err := os.WriteFile(output, make([]byte, 600), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	g := NewDiskGuard(dir, 0, 1000)
+	g.Add([]string{output})
+	if err := g.Check(); err != nil {
+		t.Fatalf("600 of 1000 bytes: %v", err)
+	}
+	g.Add([]string{output, filepath.Join(dir, "missing.png")})
+	err := g.Check()
+	if err == nil || !strings.Contains(err.Error(), "quota") {
+		t.Fatalf("1200 of 1000 bytes: err = %v", err)
+	}
+	if g.Aborted() != err {
+		t.Errorf("Aborted() = %v, want %v", g.Aborted(), err)
+	}
+
+	var none *DiskGuard
+	none.Add([]string{output})
+	if err := none.Check(); err != nil {
+		t.Errorf("nil guard: %v", err)
+	}
+}
+
+func TestDiskGuardFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := diskFree(dir); errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("free space is not available on this platform")
+	}
+
+	// The output directory doesn't have to exist yet
+	notYet := filepath.Join(dir, "waveforms", "2024")
+	if err := NewDiskGuard(notYet, 1, 0).Check(); err != nil {
+		t.Errorf("1 byte free: %v", err)
+	}
+	if err := NewDiskGuard(notYet, 1<<62, 0).Check(); err == nil || !strings.Contains(err.Error(), "free") {
+		t.Errorf("4 EB free: err = %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// dominantWindow is the length of the windows a dominant frequency is
+// reported for
+const dominantWindow = 0.5 // seconds
+
+// dominantFFTSize is the FFT frame size averaged within each window
+const dominantFFTSize = 2048
+
+// frequencyBand is a named range of frequencies with its waveform color
+type frequencyBand struct {
+	name  string
+	upper float64 // exclusive upper edge in Hz
+	color color.RGBA
+}
+
+// frequencyBands cover the audible range in ascending order
+var frequencyBands = []frequencyBand{
+	{"sub", 60, color.RGBA{90, 0, 140, 255}},
+	{"bass", 250, color.RGBA{40, 40, 200, 255}},
+	{"low_mid", 500, color.RGBA{0, 140, 200, 255}},
+	{"mid", 2000, color.RGBA{0, 160, 60, 255}},
+	{"high_mid", 4000, color.RGBA{200, 170, 0, 255}},
+	{"presence", 6000, color.RGBA{230, 110, 0, 255}},
+	{"brilliance", 0, color.RGBA{210, 20, 20, 255}}, // no upper edge
+}
+
+// bandFor returns the index into frequencyBands of a frequency
+func bandFor(freq float64) int {
+	for i, b := range frequencyBands[:len(frequencyBands)-1] {
+		if freq < b.upper {
+			return i
+		}
+	}
+	return len(frequencyBands) - 1
+}
+
+// DominantWindow is the dominant frequency of one window. Frequency is nil
+// and Band empty when the window is silent.
+type DominantWindow struct {
+	Start     float64  `json:"start"`
+	Frequency *float64 `json:"frequency"`
+	Band      string   `json:"band,omitempty"`
+}
+
+// DominantFrequencyReport is the "dominant_frequency" section of a file
+// report. Bands gives the share of non-silent windows in each band.
+type DominantFrequencyReport struct {
+	WindowSeconds float64            `json:"window_seconds"`
+	Bands         map[string]float64 `json:"bands"`
+	Windows       []DominantWindow   `json:"windows"`
+}
+
+// dominantAnalyzer finds the strongest frequency of each window
+type dominantAnalyzer struct {
+	info StreamInfo
+
+	frame        *spectrumFrame
+	samples      []float64
+	magnitudes   []float64
+	power        []float64 // summed power per bin in the current window
+	framesPerWin int
+	frames       int
+
+	// bins holds the dominant bin of each window, or -1 when silent
+	bins []int
+
+	// colormap colors the bands from low to high instead of their own
+	// colors when set
+	colormap *Colormap
+}
+
+func newDominantAnalyzer(cfg AnalysisConfig) Analyzer {
+	return &dominantAnalyzer{}
+}
+
+// Start implements Analyzer
+func (a *dominantAnalyzer) Start(info StreamInfo) {
+	a.info = info
+	a.frame = newSpectrumFrame(dominantFFTSize)
+	a.samples = make([]float64, 0, dominantFFTSize)
+	a.magnitudes = make([]float64, dominantFFTSize/2+1)
+	a.power = make([]float64, dominantFFTSize/2+1)
+	a.framesPerWin = max(1, int(dominantWindow*float64(info.SampleRate))/dominantFFTSize)
+}
+
+// memoryEstimate implements memoryUser: the FFT buffers, and the strongest
+// bin and report entry of every window
+func (a *dominantAnalyzer) memoryEstimate(info StreamInfo) int64 {
+	return spectrumMemory(dominantFFTSize) + windowCount(info, dominantWindow)*64
+}
+
+// Add implements Analyzer
+func (a *dominantAnalyzer) Add(left, right []int16) {
+	for i := range left {
+		s := float64(left[i])
+		if right != nil {
+			s = (s + float64(right[i])) / 2
+		}
+		a.samples = append(a.samples, s/32768.0)
+
+		if len(a.samples) == dominantFFTSize {
+			a.endFrame()
+		}
+	}
+}
+
+// endFrame adds the frame that just filled up to the current window
+func (a *dominantAnalyzer) endFrame() {
+	a.frame.magnitudes(a.samples, a.magnitudes)
+	for i, m := range a.magnitudes {
+		a.power[i] += m * m
+	}
+	a.samples = a.samples[:0]
+
+	a.frames++
+	if a.frames == a.framesPerWin {
+		a.endWindow()
+	}
+}
+
+// endWindow records the dominant bin of the window that just finished
+func (a *dominantAnalyzer) endWindow() {
+	// The DC bin is skipped; an offset isn't a frequency
+	best := -1
+	for i := 1; i < len(a.power); i++ {
+		if a.power[i] > 0 && (best < 0 || a.power[i] > a.power[best]) {
+			best = i
+		}
+	}
+	a.bins = append(a.bins, best)
+
+	clear(a.power)
+	a.frames = 0
+}
+
+// binFrequency returns the center frequency of an FFT bin
+func (a *dominantAnalyzer) binFrequency(bin int) float64 {
+	return float64(bin) * float64(a.info.SampleRate) / dominantFFTSize
+}
+
+// Result implements Analyzer
+func (a *dominantAnalyzer) Result() any {
+	a.flush()
+
+	windowSeconds := framesToSeconds(a.framesPerWin*dominantFFTSize, a.info.SampleRate)
+	report := DominantFrequencyReport{
+		WindowSeconds: windowSeconds,
+		Bands:         map[string]float64{},
+		Windows:       make([]DominantWindow, len(a.bins)),
+	}
+
+	counts := make([]int, len(frequencyBands))
+	audible := 0
+	for i, bin := range a.bins {
+		report.Windows[i].Start = float64(i) * windowSeconds
+		if bin < 0 {
+			continue
+		}
+
+		freq := a.binFrequency(bin)
+		band := bandFor(freq)
+		report.Windows[i].Frequency = &freq
+		report.Windows[i].Band = frequencyBands[band].name
+
+		counts[band]++
+		audible++
+	}
+
+	for i, b := range frequencyBands {
+		share := 0.0
+		if audible > 0 {
+			share = float64(counts[i]) / float64(audible)
+		}
+		report.Bands[b.name] = share
+	}
+
+	return report
+}
+
+// flush finishes a trailing partial frame and window
+func (a *dominantAnalyzer) flush() {
+	if len(a.samples) > 0 {
+		for len(a.samples) < dominantFFTSize {
+			a.samples = append(a.samples, 0)
+		}
+		a.endFrame()
+	}
+	if a.frames > 0 {
+		a.endWindow()
+	}
+}
+
+// drawOverlay implements imageOverlay, coloring each waveform column by the
+// band of the window it falls in
+func (a *dominantAnalyzer) drawOverlay(img *image.RGBA) {
+	a.flush()
+	if len(a.bins) == 0 {
+		return
+	}
+
+	bounds := img.Bounds()
+	width := bounds.Dx()
+
+	for x := 0; x < width; x++ {
+		bin := a.bins[x*len(a.bins)/width]
+		if bin < 0 {
+			continue
+		}
+		band := bandFor(a.binFrequency(bin))
+		c := frequencyBands[band].color
+		if a.colormap != nil {
+			c = a.colormap.At(float64(band) / float64(len(frequencyBands)-1))
+		}
+
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			if img.RGBAAt(bounds.Min.X+x, y) == waveformColor {
+				img.SetRGBA(bounds.Min.X+x, y, c)
+			}
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// drBlock is the length of the blocks the DR value is measured over
+const drBlock = 3.0 // seconds
+
+// drLoudestFraction is the share of loudest blocks whose RMS DR is judged by
+const drLoudestFraction = 0.2
+
+// ChannelDynamics holds the dynamic range figures of one channel
+type ChannelDynamics struct {
+	Channel     string   `json:"channel"`
+	PeakDB      *float64 `json:"peak_dbfs"`
+	RMSDB       *float64 `json:"rms_dbfs"`
+	CrestFactor *float64 `json:"crest_factor_db"`
+	DR          *float64 `json:"dr"`
+}
+
+// DynamicsReport is the "dynamics" section of a file report. DR follows the
+// usual DR meter method: the second highest block peak against the RMS of
+// the loudest 20% of 3 s blocks.
+type DynamicsReport struct {
+	Channels []ChannelDynamics `json:"channels"`
+	DR       *int              `json:"dr"`
+}
+
+// dynamicsAnalyzer measures crest factor and DR of each channel
+type dynamicsAnalyzer struct {
+	blockFrames int
+	blockPos    int
+
+	frames int
+	sum    [2]float64 // squared samples over the whole file
+	peak   [2]int32
+
+	blockSum  [2]float64
+	blockPeak [2]int32
+	blocks    [2][]drBlockLevel
+}
+
+// drBlockLevel is the RMS and peak of one DR block, normalized
+type drBlockLevel struct {
+	rms, peak float64
+}
+
+func newDynamicsAnalyzer(cfg AnalysisConfig) Analyzer {
+	return &dynamicsAnalyzer{}
+}
+
+// Start implements Analyzer
+func (a *dynamicsAnalyzer) Start(info StreamInfo) {
+	a.blockFrames = max(1, int(drBlock*float64(info.SampleRate)))
+}
+
+// memoryEstimate implements memoryUser: the levels of every block of both
+// channels, and the sorted peak and RMS copies Result makes
+func (a *dynamicsAnalyzer) memoryEstimate(info StreamInfo) int64 {
+	return 2 * windowCount(info, drBlock) * 2 * 16
+}
+
+// Add implements Analyzer
+func (a *dynamicsAnalyzer) Add(left, right []int16) {
+	for i := range left {
+		a.addSample(0, left[i])
+		if right != nil {
+			a.addSample(1, right[i])
+		}
+
+		a.blockPos++
+		if a.blockPos == a.blockFrames {
+			a.endBlock()
+		}
+	}
+	a.frames += len(left)
+}
+
+// addSample adds one sample of channel ch to the running sums
+func (a *dynamicsAnalyzer) addSample(ch int, v int16) {
+	s := float64(v)
+	a.blockSum[ch] += s * s
+	a.blockPeak[ch] = max(a.blockPeak[ch], abs16(v))
+}
+
+// endBlock records the levels of the block that just finished
+func (a *dynamicsAnalyzer) endBlock() {
+	for ch := range a.blocks {
+		// The factor of 2 makes a full scale sine read 0 dB, as DR meters do
+		rms := math.Sqrt(2*a.blockSum[ch]/float64(a.blockPos)) / 32768.0
+		a.blocks[ch] = append(a.blocks[ch], drBlockLevel{
+			rms:  rms,
+			peak: float64(a.blockPeak[ch]) / 32768.0,
+		})
+
+		a.sum[ch] += a.blockSum[ch]
+		a.peak[ch] = max(a.peak[ch], a.blockPeak[ch])
+	}
+
+	a.blockSum = [2]float64{}
+	a.blockPeak = [2]int32{}
+	a.blockPos = 0
+}
+
+// channelDR returns the DR value of a channel's blocks in dB
+func channelDR(blocks []drBlockLevel) float64 {
+	if len(blocks) == 0 {
+		return math.NaN()
+	}
+
+	peaks := make([]float64, len(blocks))
+	rms := make([]float64, len(blocks))
+	for i, b := range blocks {
+		peaks[i], rms[i] = b.peak, b.rms
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(peaks)))
+	sort.Sort(sort.Reverse(sort.Float64Slice(rms)))
+
+	// The second highest peak ignores a single stray transient
+	peak := peaks[min(1, len(peaks)-1)]
+
+	loudest := max(1, int(float64(len(rms))*drLoudestFraction))
+	sum := 0.0
+	for _, r := range rms[:loudest] {
+		sum += r * r
+	}
+
+	return 20 * math.Log10(peak/math.Sqrt(sum/float64(loudest)))
+}
+
+// Result implements Analyzer
+func (a *dynamicsAnalyzer) Result() any {
+	if a.blockPos > 0 {
+		a.endBlock()
+	}
+
+	var report DynamicsReport
+	drSum, drCount := 0.0, 0
+
+	for ch, name := range channelNames {
+		rms := 0.0
+		if a.frames > 0 {
+			rms = math.Sqrt(a.sum[ch]/float64(a.frames)) / 32768.0
+		}
+		peak := float64(a.peak[ch]) / 32768.0
+		dr := channelDR(a.blocks[ch])
+
+		report.Channels = append(report.Channels, ChannelDynamics{
+			Channel:     name,
+			PeakDB:      finiteOrNil(20 * math.Log10(peak)),
+			RMSDB:       finiteOrNil(20 * math.Log10(rms)),
+			CrestFactor: finiteOrNil(20 * math.Log10(peak/rms)),
+			DR:          finiteOrNil(dr),
+		})
+
+		if !math.IsNaN(dr) && !math.IsInf(dr, 0) {
+			drSum += dr
+			drCount++
+		}
+	}
+
+	// The file's DR is the rounded mean over its channels
+	if drCount > 0 {
+		dr := int(math.Round(drSum / float64(drCount)))
+		report.DR = &dr
+	}
+
+	return report
+}
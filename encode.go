@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"sync"
+)
+
+// pngBufferPool lets concurrent workers share PNG encoder buffers
+type pngBufferPool struct {
+	pool sync.Pool
+}
+
+// Get returns a pooled buffer, or nil so the encoder allocates one
+func (p *pngBufferPool) Get() *png.EncoderBuffer {
+	buf, _ := p.pool.Get().(*png.EncoderBuffer)
+	return buf
+}
+
+// Put returns a buffer to the pool
+func (p *pngBufferPool) Put(buf *png.EncoderBuffer) {
+	p.pool.Put(buf)
+}
+
+var encoderBuffers = &pngBufferPool{}
+
+// newPNGEncoder returns an encoder using the given compression level
+func newPNGEncoder(level png.CompressionLevel) *png.Encoder {
+	return &png.Encoder{
+		CompressionLevel: level,
+		BufferPool:       encoderBuffers,
+	}
+}
+
+// encodePNG encodes img to w, tagged as sRGB so browsers and design tools
+// show its colors as specified rather than in the display's own space
+func encodePNG(w io.Writer, img image.Image, level png.CompressionLevel) error {
+	return newPNGEncoder(level).Encode(&srgbWriter{w: w}, img)
+}
+
+// pngHeaderSize is the length of the PNG signature and the IHDR chunk,
+// which must come first in every PNG
+const pngHeaderSize = 8 + 4 + 4 + 13 + 4
+
+// srgbChunks are the chunks declaring a PNG sRGB: sRGB with the perceptual
+// rendering intent, and the gAMA and cHRM of sRGB for decoders predating it
+var srgbChunks = func() []byte {
+	var buf []byte
+	chunk := func(kind string, data ...uint32) {
+		body := []byte(kind)
+		for _, v := range data {
+			body = binary.BigEndian.AppendUint32(body, v)
+		}
+		if kind == "sRGB" {
+			body = append(body, 0)
+		}
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(body)-4))
+		buf = append(buf, body...)
+		buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(body))
+	}
+	chunk("sRGB")
+	chunk("gAMA", 45455)
+	chunk("cHRM", 31270, 32900, 64000, 33000, 30000, 60000, 15000, 6000)
+	return buf
+}()
+
+// srgbWriter passes a PNG through, inserting srgbChunks after its header
+type srgbWriter struct {
+	w io.Writer
+	n int // bytes of the header written so far
+}
+
+// Write implements io.Writer
+func (s *srgbWriter) Write(p []byte) (int, error) {
+	if s.n >= pngHeaderSize {
+		return s.w.Write(p)
+	}
+
+	head := min(len(p), pngHeaderSize-s.n)
+	n, err := s.w.Write(p[:head])
+	s.n += n
+	if err != nil || s.n < pngHeaderSize {
+		return n, err
+	}
+	if _, err := s.w.Write(srgbChunks); err != nil {
+		return n, err
+	}
+	m, err := s.w.Write(p[head:])
+	return n + m, err
+}
+
+// parseCompressionLevel maps a -png-compression value to a PNG compression level
+func parseCompressionLevel(name string) (png.CompressionLevel, error) {
+	switch name {
+	case "default", "":
+		return png.DefaultCompression, nil
+	case "none":
+		return png.NoCompression, nil
+	case "fast":
+		return png.BestSpeed, nil
+	case "best":
+		return png.BestCompression, nil
+	}
+	return 0, fmt.Errorf("unknown PNG compression %q (want default, none, fast or best)", name)
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Encrypted inputs are AES-GCM sealed in chunks, so they can be decrypted
+// block by block as they are decoded instead of being written out
+// decrypted. A file is the magic, a random nonce prefix and then the
+// chunks of encryptedChunkSize bytes of plaintext, the last one shorter or
+// empty, each followed by its tag. A chunk's nonce is the prefix, its index
+// and whether it is the last one, so chunks can't be reordered or the file
+// cut short at a chunk boundary without failing to decrypt.
+const (
+	encryptedMagic      = "OWGCM01\n"
+	encryptedPrefixSize = 7
+	encryptedHeaderSize = len(encryptedMagic) + encryptedPrefixSize
+	encryptedChunkSize  = 64 << 10
+)
+
+// ErrEncrypted is returned for encrypted inputs when no key was given
+var ErrEncrypted = errors.New("the file is encrypted; give its key with -decrypt-key")
+
+// InputCipher decrypts encrypted inputs with one key
+type InputCipher struct {
+	aead cipher.AEAD
+}
+
+// NewInputCipher returns a cipher for a 16, 24 or 32 byte AES key
+func NewInputCipher(key []byte) (*InputCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &InputCipher{aead: aead}, nil
+}
+
+// LoadInputCipher reads a key file of the raw key bytes, or of the key in
+// hex as `openssl rand -hex 32` writes it
+func LoadInputCipher(keyFile string) (*InputCipher, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	key := data
+	if text := strings.TrimSpace(string(data)); len(text) >= 32 {
+		if decoded, err := hex.DecodeString(text); err == nil {
+			key = decoded
+		}
+	}
+	c, err := NewInputCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w (want 16, 24 or 32 bytes, raw or in hex)", keyFile, err)
+	}
+	return c, nil
+}
+
+// chunkNonce returns the nonce of chunk i of a file with the given prefix
+func chunkNonce(prefix []byte, i int64, last bool) []byte {
+	nonce := make([]byte, 0, 12)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, uint32(i))
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// isEncrypted reports whether the named file starts with encryptedMagic
+func isEncrypted(filename string) bool {
+	file, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(encryptedMagic))
+	_, err = io.ReadFull(file, magic)
+	return err == nil && string(magic) == encryptedMagic
+}
+
+// decryptingReader reads the plaintext of an encrypted file, decrypting
+// the chunk under the read position as it goes
+type decryptingReader struct {
+	file   *os.File
+	aead   cipher.AEAD
+	header []byte
+	size   int64 // plaintext bytes
+	chunks int64
+	pos    int64
+
+	chunk  int64 // index of the chunk in plain, or -1
+	sealed []byte
+	plain  []byte
+}
+
+// open opens an encrypted file for reading its plaintext, which is size
+// bytes long
+func (c *InputCipher) open(filename string) (r *decryptingReader, size int64, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	header := make([]byte, encryptedHeaderSize)
+	if _, err := io.ReadFull(file, header); err != nil || string(header[:len(encryptedMagic)]) != encryptedMagic {
+		file.Close()
+		return nil, 0, fmt.Errorf("not an encrypted input")
+	}
+
+	// Every chunk is full but the last, which has at least its tag
+	sealedChunk := int64(encryptedChunkSize + c.aead.Overhead())
+	body := info.Size() - int64(encryptedHeaderSize)
+	chunks := (body + sealedChunk - 1) / sealedChunk
+	if body < int64(c.aead.Overhead()) || body-(chunks-1)*sealedChunk < int64(c.aead.Overhead()) {
+		file.Close()
+		return nil, 0, fmt.Errorf("encrypted input is truncated")
+	}
+
+	r = &decryptingReader{
+		file:   file,
+		aead:   c.aead,
+		header: header,
+		size:   body - chunks*int64(c.aead.Overhead()),
+		chunks: chunks,
+		chunk:  -1,
+	}
+	return r, r.size, nil
+}
+
+// load decrypts chunk i into plain
+func (r *decryptingReader) load(i int64) error {
+	sealedChunk := int64(encryptedChunkSize + r.aead.Overhead())
+	offset := int64(encryptedHeaderSize) + i*sealedChunk
+	n := sealedChunk
+	if i == r.chunks-1 {
+		n = r.size - i*encryptedChunkSize + int64(r.aead.Overhead())
+	}
+
+	if int64(cap(r.sealed)) < n {
+		r.sealed = make([]byte, sealedChunk)
+	}
+	r.sealed = r.sealed[:n]
+	if _, err := r.file.ReadAt(r.sealed, offset); err != nil {
+		return fmt.Errorf("failed to read encrypted chunk %d: %w", i, err)
+	}
+	plain, err := r.aead.Open(r.plain[:0], chunkNonce(r.header[len(encryptedMagic):], i, i == r.chunks-1), r.sealed, r.header)
+	if err != nil {
+		r.chunk = -1
+		return fmt.Errorf("chunk %d doesn't decrypt: wrong key or a damaged file", i)
+	}
+	r.plain, r.chunk = plain, i
+	return nil
+}
+
+// Read implements io.Reader
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) && r.pos < r.size {
+		i := r.pos / encryptedChunkSize
+		if i != r.chunk {
+			if err := r.load(i); err != nil {
+				return n, err
+			}
+		}
+		copied := copy(p[n:], r.plain[r.pos-i*encryptedChunkSize:])
+		n += copied
+		r.pos += int64(copied)
+	}
+	if n == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// Seek implements io.Seeker
+func (r *decryptingReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("seek before the start of the file")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+// Close implements io.Closer
+func (r *decryptingReader) Close() error {
+	return r.file.Close()
+}
+
+// encrypt writes the encryption of everything read from src to w
+func (c *InputCipher) encrypt(w io.Writer, src io.Reader) error {
+	header := make([]byte, encryptedHeaderSize)
+	copy(header, encryptedMagic)
+	if _, err := rand.Read(header[len(encryptedMagic):]); err != nil {
+		return err
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	// Read a chunk ahead, since the last one is sealed differently
+	chunk := make([]byte, encryptedChunkSize)
+	next := make([]byte, encryptedChunkSize)
+	n, err := io.ReadFull(src, chunk)
+	var sealed []byte
+	for i := int64(0); ; i++ {
+		var m int
+		last := true
+		switch err {
+		case nil:
+			// A full chunk, which is the last one when nothing follows
+			m, err = io.ReadFull(src, next)
+			last = m == 0 && err == io.EOF
+		case io.EOF, io.ErrUnexpectedEOF:
+		default:
+			return err
+		}
+
+		sealed = c.aead.Seal(sealed[:0], chunkNonce(header[len(encryptedMagic):], i, last), chunk[:n], header)
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		chunk, next, n = next, chunk, m
+	}
+}
+
+// runEncrypt implements the encrypt subcommand, which encrypts a file for
+// -decrypt-key
+func runEncrypt(args []string) error {
+	fs := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	keyFile := fs.String("key", "", "key file: 16, 24 or 32 bytes, raw or in hex (required)")
+	output := fs.String("o", "", "file to write (default the input's name ending in .enc)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: only_waveform encrypt -key file.key [-o take.wav.enc] take.wav\n")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *keyFile == "" {
+		fs.Usage()
+		return fmt.Errorf("encrypt needs -key and exactly one file")
+	}
+	c, err := LoadInputCipher(*keyFile)
+	if err != nil {
+		return err
+	}
+
+	input := fs.Arg(0)
+	if *output == "" {
+		*output = input + ".enc"
+	}
+	src, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	err = atomicWrite(*output, func(file *os.File) error {
+		return c.encrypt(file, src)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Encrypted %s: %s\n", input, *output)
+	return nil
+}
+
+// openInputWAV opens an input for decoding as openWAVChannels does,
+// decrypting it on the fly when it is encrypted
+func openInputWAV(filename string, opts Options, leftOnly bool) (*wavReader, error) {
+	if !isEncrypted(filename) {
+		return openWAVChannels(filename, opts.UseMmap, leftOnly, opts.Channels)
+	}
+	if opts.Decrypt == nil {
+		return nil, ErrEncrypted
+	}
+	source, size, err := opts.Decrypt.open(filename)
+	if err != nil {
+		return nil, err
+	}
+	return openWAVSource(source, size, filename, leftOnly, opts.Channels)
+}
+
+// openInputSource opens the WAV data of an input and returns its size,
+// reading through the decryption of encrypted ones
+func openInputSource(filename string, opts Options) (io.ReadSeekCloser, int64, error) {
+	if isEncrypted(filename) {
+		if opts.Decrypt == nil {
+			return nil, 0, ErrEncrypted
+		}
+		return opts.Decrypt.open(filename)
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+// probeInput returns the stream an input holds, as probeWAV does, reading
+// through the decryption of encrypted ones
+func probeInput(filename string, opts Options) (StreamInfo, error) {
+	if opts.Decrypt == nil || !isEncrypted(filename) {
+		return probeWAV(filename)
+	}
+	source, size, err := opts.Decrypt.open(filename)
+	if err != nil {
+		return StreamInfo{}, err
+	}
+	defer source.Close()
+	return probeWAVSource(source, size)
+}
+
+// readInputMetadata returns the tags of an input, as readWAVMetadata does,
+// reading through the decryption of encrypted ones
+func readInputMetadata(filename string, opts Options) (*AudioMetadata, error) {
+	if opts.Decrypt == nil || !isEncrypted(filename) {
+		return readWAVMetadata(filename)
+	}
+	source, _, err := opts.Decrypt.open(filename)
+	if err != nil {
+		return nil, nil // decoding reports it
+	}
+	defer source.Close()
+	return readWAVSourceMetadata(source)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEncryptedInput(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewInputCipher(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{0, 1, encryptedChunkSize, encryptedChunkSize + 1, 2*encryptedChunkSize + 5} {
+		plain := make([]byte, size)
+		for i := range plain {
+			plain[i] = byte(i * 31)
+		}
+		file := filepath.Join(dir, "data.enc")
+		var sealed bytes.Buffer
+		if err := c.encrypt(&sealed, bytes.NewReader(plain)); err != nil {
+			t.Fatal(err)
+		}
+		os.WriteFile(file, sealed.Bytes(), 0o644)
+
+		r, n, err := c.open(file)
+		if err != nil || n != int64(size) {
+			t.Fatalf("%d bytes: opened as %d, %v", size, n, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("%d bytes: read %d, %v", size, len(got), err)
+		}
+		if size > 10 {
+			r.Seek(-10, io.SeekEnd)
+			tail, _ := io.ReadAll(r)
+			if !bytes.Equal(tail, plain[size-10:]) {
+				t.Errorf("%d bytes: read %v after seeking to the end", size, tail)
+			}
+		}
+		r.Close()
+
+		// Cut at a chunk boundary, the new last chunk isn't sealed as one
+		if size > encryptedChunkSize {
+			os.WriteFile(file, sealed.Bytes()[:encryptedHeaderSize+encryptedChunkSize+16], 0o644)
+			r, _, err := c.open(file)
+			if err == nil {
+				_, err = io.ReadAll(r)
+				r.Close()
+			}
+			if err == nil {
+				t.Errorf("%d bytes: truncated file decrypted", size)
+			}
+		}
+	}
+
+	other, _ := NewInputCipher(bytes.Repeat([]byte{8}, 32))
+	file := filepath.Join(dir, "data.enc")
+	var sealed bytes.Buffer
+	c.encrypt(&sealed, bytes.NewReader([]byte("secret")))
+	os.WriteFile(file, sealed.Bytes(), 0o644)
+	r, _, err := other.open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); err == nil {
+		t.Error("decrypted with the wrong key")
+	}
+	r.Close()
+}
+
+func TestGenerateEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "take.wav")
+	audio := DefaultTestAudio()
+	audio.Duration = 100 * time.Millisecond
+	if err := WriteTestAudioFile(input, audio); err != nil {
+		t.Fatal(err)
+	}
+
+	// A hex key file, as openssl rand -hex 32 writes it
+	keyFile := filepath.Join(dir, "take.key")
+	os.WriteFile(keyFile, []byte("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f\n"), 0o600)
+	if err := runEncrypt([]string{"-key", keyFile, input}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadInputCipher(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plainOut, encryptedOut := t.TempDir(), t.TempDir()
+	opts := Options{Width: 100, Height: 20, PreviewClip: 50 * time.Millisecond, PreviewFormat: PreviewWAV}
+	if _, err := GenerateStereoWaveforms(batchInput{Name: "take.wav", Path: input, Location: input}, plainOut, opts); err != nil {
+		t.Fatal(err)
+	}
+	encrypted := batchInput{Name: "take.wav.enc", Path: input + ".enc", Location: input + ".enc"}
+	if _, err := GenerateStereoWaveforms(encrypted, encryptedOut, opts); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("without a key: %v", err)
+	}
+	opts.Decrypt = c
+	if _, err := GenerateStereoWaveforms(encrypted, encryptedOut, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := os.ReadFile(filepath.Join(plainOut, "take.png"))
+	got, err := os.ReadFile(filepath.Join(encryptedOut, "take.png"))
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("the encrypted input rendered differently (%v)", err)
+	}
+	// Preview clips are cut from the decryption
+	want, _ = os.ReadFile(filepath.Join(plainOut, "take.preview.wav"))
+	got, err = os.ReadFile(filepath.Join(encryptedOut, "take.preview.wav"))
+	if err != nil || len(want) == 0 || !bytes.Equal(got, want) {
+		t.Errorf("the encrypted input was cut differently (%v)", err)
+	}
+}
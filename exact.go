@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ColumnMap says which frames of the input every column of an exact render
+// covers, so positions in the image can be traced back to sample offsets.
+// Frames count from the start of the input file, whatever -start says, and
+// every range includes its start and excludes its end.
+type ColumnMap struct {
+	Image      string `json:"image"`
+	SampleRate uint32 `json:"sample_rate"`
+	// FirstFrame is where column 0 starts and Frames how many frames the
+	// columns cover between them
+	FirstFrame int `json:"first_frame"`
+	Frames     int `json:"frames"`
+	Width      int `json:"width"`
+	// SamplesPerPixel is set for a fixed bucket size; without it the
+	// frames are spread evenly over the columns
+	SamplesPerPixel int    `json:"samples_per_pixel,omitempty"`
+	Rule            string `json:"rule"`
+	// Columns holds the [start, end) frames of every column, in order
+	Columns [][2]int `json:"columns"`
+}
+
+const (
+	fittedColumnRule = "column i covers frames first_frame + ceil(i * frames / width) up to first_frame + ceil((i + 1) * frames / width)"
+	fixedColumnRule  = "column i covers frames first_frame + i * samples_per_pixel up to first_frame + (i + 1) * samples_per_pixel, the last one ending at first_frame + frames"
+)
+
+// exactFrames returns the stretch of a file an exact render lays out over
+// its columns: the frame it starts at and how many frames it has, from the
+// header, as the buckets are laid out when decoding
+func exactFrames(info StreamInfo, opts Options) (first, frames int) {
+	start, end := opts.windowFrames(info.SampleRate)
+	total := info.NumFrames
+	if end > 0 && end < total {
+		total = end
+	}
+	return start, max(total-start, 0)
+}
+
+// checkExact refuses a file that can't be drawn a column per bucket: a clip
+// shorter than -width would be interpolated, and a fixed bucket size too
+// small for it would make the image wider than -max-width
+func checkExact(info StreamInfo, opts Options) error {
+	_, frames := exactFrames(info, opts)
+	if opts.SamplesPerPixel <= 0 {
+		if frames < opts.Width {
+			return fmt.Errorf("-exact needs a frame for every column, but the file has %d frames for a -width of %d", frames, opts.Width)
+		}
+		return nil
+	}
+	if _, width := opts.bucketLayout(frames); opts.MaxWidth > 0 && width > opts.MaxWidth {
+		return fmt.Errorf("-exact would draw the file %d pixels wide, over -max-width %d; raise -samples-per-pixel or -max-width", width, opts.MaxWidth)
+	}
+	return nil
+}
+
+// columnMap returns the column map of an exact render of a file. offset is
+// the frame the decoded file starts at within the input, for inputs fetched
+// in part.
+func columnMap(image string, info StreamInfo, offset int, opts Options) ColumnMap {
+	first, frames := exactFrames(info, opts)
+	samplesPerPixel, width := opts.bucketLayout(frames)
+	m := ColumnMap{
+		Image:      image,
+		SampleRate: info.SampleRate,
+		FirstFrame: offset + first,
+		Frames:     frames,
+		Width:      width,
+		Rule:       fittedColumnRule,
+		Columns:    make([][2]int, width),
+	}
+	if opts.SamplesPerPixel > 0 {
+		m.SamplesPerPixel, m.Rule = samplesPerPixel, fixedColumnRule
+	}
+
+	for i := range m.Columns {
+		start, end := bucketStart(i, frames, width), bucketStart(i+1, frames, width)
+		if opts.SamplesPerPixel > 0 {
+			start, end = i*samplesPerPixel, min((i+1)*samplesPerPixel, frames)
+		}
+		m.Columns[i] = [2]int{m.FirstFrame + start, m.FirstFrame + end}
+	}
+	return m
+}
+
+// writeColumnMap writes a column map as JSON
+func writeColumnMap(filename string, m ColumnMap) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode column map: %w", err)
+	}
+	if err := atomicWriteFile(filename, append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write column map: %w", err)
+	}
+	return nil
+}
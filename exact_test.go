@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestColumnMap(t *testing.T) {
+	info := StreamInfo{SampleRate: 10, NumFrames: 30}
+
+	m := columnMap("take.png", info, 0, Options{Width: 4, Start: time.Second})
+	want := [][2]int{{10, 15}, {15, 20}, {20, 25}, {25, 30}}
+	if m.FirstFrame != 10 || m.Frames != 20 || m.Width != 4 || !reflect.DeepEqual(m.Columns, want) {
+		t.Errorf("fitted map = %+v", m)
+	}
+
+	m = columnMap("take.png", info, 5, Options{Width: 4, SamplesPerPixel: 8})
+	want = [][2]int{{5, 13}, {13, 21}, {21, 29}, {29, 35}}
+	if m.Width != 4 || m.SamplesPerPixel != 8 || !reflect.DeepEqual(m.Columns, want) {
+		t.Errorf("fixed map = %+v", m)
+	}
+}
+
+func TestExactRender(t *testing.T) {
+	audio := DefaultTestAudio()
+	audio.Duration = 100 * time.Millisecond
+	input := writeFixture(t, audio)
+	data, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputDir := t.TempDir()
+	opts := Options{Width: 333, Height: 20, Exact: true}
+	in := batchInput{Name: "fixture.wav", Path: input, Location: input}
+	if _, err := GenerateStereoWaveforms(in, outputDir, opts); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(filepath.Join(outputDir, "fixture.columns.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m ColumnMap
+	if err := json.Unmarshal(raw, &m); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every column holds exactly the lowest and highest left sample of
+	// the frames the map gives it
+	peaks, _, err := decodePeaks(input, opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Columns) != 333 || m.Columns[0][0] != 0 || m.Columns[332][1] != 4410 {
+		t.Fatalf("map of %d columns covers %v to %v", len(m.Columns), m.Columns[0], m.Columns[len(m.Columns)-1])
+	}
+	for i, c := range m.Columns {
+		lowest, highest := int16(32767), int16(-32768)
+		for frame := c[0]; frame < c[1]; frame++ {
+			v := int16(binary.LittleEndian.Uint16(data[canonicalHeaderSize+int64(frame)*4:]))
+			lowest, highest = min(lowest, v), max(highest, v)
+		}
+		if peaks.Channels[0].Min[i] != lowest || peaks.Channels[0].Max[i] != highest {
+			t.Fatalf("column %d is %d..%d, want %d..%d of frames %v", i, peaks.Channels[0].Min[i], peaks.Channels[0].Max[i], lowest, highest, c)
+		}
+	}
+
+	// Clips shorter than the image would be interpolated
+	opts.Width = 5000
+	if _, err := GenerateStereoWaveforms(in, outputDir, opts); err == nil {
+		t.Error("exact render of a clip narrower than -width succeeded")
+	}
+}
+
+func TestExactOptions(t *testing.T) {
+	for _, args := range [][]string{
+		{"-exact", "-aggregate", "rms"},
+		{"-exact", "-width", "40000"},
+		{"-exact", "-incremental", "-cache-dir", "cache"},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		buildOptions := optionFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := buildOptions(); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"math/cmplx"
+)
+
+// isPowerOfTwo reports whether n is a positive power of two
+func isPowerOfTwo(n int) bool {
+	return n > 0 && n&(n-1) == 0
+}
+
+// hannWindow returns a Hann window of length n
+func hannWindow(n int) []float64 {
+	w := make([]float64, n)
+	for i := range w {
+		w[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n))
+	}
+	return w
+}
+
+// fft transforms x in place with an iterative radix-2 FFT. len(x) must be
+// a power of two.
+func fft(x []complex128) {
+	n := len(x)
+	shift := 64 - uint(bits.Len(uint(n-1)))
+
+	// Bit-reversal permutation
+	for i := range x {
+		j := int(bits.Reverse64(uint64(i)) >> shift)
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], w*x[start+k+size/2]
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+}
+
+// spectrumFrame computes magnitude spectra of windowed frames
+type spectrumFrame struct {
+	window []float64
+	gain   float64 // scales magnitudes so a full scale sine reads 1
+	buf    []complex128
+}
+
+// spectrumMemory returns the bytes a spectrum frame of size samples and the
+// sample, magnitude and power buffers analyzers keep next to it take
+func spectrumMemory(size int) int64 {
+	n := int64(size)
+	bins := n/2 + 1
+	return n*16 + n*8 + n*8 + 2*bins*8
+}
+
+func newSpectrumFrame(size int) *spectrumFrame {
+	window := hannWindow(size)
+	sum := 0.0
+	for _, w := range window {
+		sum += w
+	}
+
+	return &spectrumFrame{
+		window: window,
+		gain:   2 / sum,
+		buf:    make([]complex128, size),
+	}
+}
+
+// magnitudes writes the normalized magnitude of bins 0..size/2 of samples
+// (in [-1, 1]) to out
+func (f *spectrumFrame) magnitudes(samples []float64, out []float64) {
+	for i, s := range samples {
+		f.buf[i] = complex(s*f.window[i], 0)
+	}
+	fft(f.buf)
+
+	for i := range out {
+		out[i] = cmplx.Abs(f.buf[i]) * f.gain
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"math"
+)
+
+// fingerprintFrameSize is the FFT frame size used for chroma features
+const fingerprintFrameSize = 4096
+
+// fingerprintHop is the distance between consecutive frames
+const fingerprintHop = fingerprintFrameSize / 2
+
+// fingerprintMinFreq and fingerprintMaxFreq bound the frequencies folded
+// into the chroma features, as in Chromaprint
+const (
+	fingerprintMinFreq = 28
+	fingerprintMaxFreq = 3520
+)
+
+// FingerprintReport is the "fingerprint" section of a file report. The
+// fingerprint is one 32-bit value per frame, little endian and base64
+// encoded; files with the same audio give the same values, and similar audio
+// gives values differing in few bits.
+type FingerprintReport struct {
+	Algorithm    string  `json:"algorithm"`
+	FrameSeconds float64 `json:"frame_seconds"`
+	Length       int     `json:"length"`
+	Fingerprint  string  `json:"fingerprint"`
+}
+
+// fingerprintAnalyzer derives a chroma-based fingerprint of the mono mix
+type fingerprintAnalyzer struct {
+	info StreamInfo
+
+	frame      *spectrumFrame
+	samples    []float64
+	magnitudes []float64
+	pitchClass []int // chroma bin of each FFT bin, or -1 outside the range
+
+	prev    [12]float64
+	hasPrev bool
+	values  []uint32
+}
+
+func newFingerprintAnalyzer(cfg AnalysisConfig) Analyzer {
+	return &fingerprintAnalyzer{}
+}
+
+// Start implements Analyzer
+func (a *fingerprintAnalyzer) Start(info StreamInfo) {
+	a.info = info
+	a.frame = newSpectrumFrame(fingerprintFrameSize)
+	a.samples = make([]float64, 0, fingerprintFrameSize)
+	a.magnitudes = make([]float64, fingerprintFrameSize/2+1)
+	a.values = make([]uint32, 0, info.NumFrames/fingerprintHop+1)
+
+	a.pitchClass = make([]int, len(a.magnitudes))
+	for bin := range a.pitchClass {
+		freq := float64(bin) * float64(info.SampleRate) / fingerprintFrameSize
+		if freq < fingerprintMinFreq || freq > fingerprintMaxFreq {
+			a.pitchClass[bin] = -1
+			continue
+		}
+		note := int(math.Round(12*math.Log2(freq/440))) + 69
+		a.pitchClass[bin] = ((note % 12) + 12) % 12
+	}
+}
+
+// memoryEstimate implements memoryUser: the FFT buffers, and every 32-bit
+// value with its byte and base64 copies
+func (a *fingerprintAnalyzer) memoryEstimate(info StreamInfo) int64 {
+	return spectrumMemory(fingerprintFrameSize) + (int64(info.NumFrames)/fingerprintHop+1)*14
+}
+
+// Add implements Analyzer
+func (a *fingerprintAnalyzer) Add(left, right []int16) {
+	for i := range left {
+		s := float64(left[i])
+		if right != nil {
+			s = (s + float64(right[i])) / 2
+		}
+		a.samples = append(a.samples, s/32768.0)
+
+		if len(a.samples) == fingerprintFrameSize {
+			a.endFrame()
+			// Keep the second half as the start of the next frame
+			a.samples = a.samples[:copy(a.samples, a.samples[fingerprintHop:])]
+		}
+	}
+}
+
+// endFrame adds the sub-fingerprint of the frame that just filled up
+func (a *fingerprintAnalyzer) endFrame() {
+	a.frame.magnitudes(a.samples, a.magnitudes)
+
+	var chroma [12]float64
+	for bin, m := range a.magnitudes {
+		if pc := a.pitchClass[bin]; pc >= 0 {
+			chroma[pc] += m * m
+		}
+	}
+
+	// Normalize so the fingerprint doesn't depend on the level
+	norm := 0.0
+	for _, c := range chroma {
+		norm += c * c
+	}
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+		for i := range chroma {
+			chroma[i] /= norm
+		}
+	}
+
+	if a.hasPrev {
+		a.values = append(a.values, subFingerprint(chroma, a.prev))
+	}
+	a.prev, a.hasPrev = chroma, true
+}
+
+// subFingerprint packs the shape of a chroma vector and its change from the
+// previous frame into 32 bits: neighbouring pitch classes compared within
+// the frame (12 bits), each class against the previous frame (12 bits) and
+// opposite pairs of the circle of fifths (8 bits)
+func subFingerprint(chroma, prev [12]float64) uint32 {
+	var v uint32
+	bit := 0
+	set := func(cond bool) {
+		if cond {
+			v |= 1 << bit
+		}
+		bit++
+	}
+
+	for k := range 12 {
+		set(chroma[k] > chroma[(k+1)%12])
+	}
+	for k := range 12 {
+		set(chroma[k] > prev[k])
+	}
+	for k := range 8 {
+		set(chroma[k]+chroma[(k+7)%12] > chroma[(k+4)%12]+chroma[(k+11)%12])
+	}
+
+	return v
+}
+
+// Result implements Analyzer
+func (a *fingerprintAnalyzer) Result() any {
+	data := make([]byte, 4*len(a.values))
+	for i, v := range a.values {
+		binary.LittleEndian.PutUint32(data[4*i:], v)
+	}
+
+	return FingerprintReport{
+		Algorithm:    "chroma32",
+		FrameSeconds: framesToSeconds(fingerprintHop, a.info.SampleRate),
+		Length:       len(a.values),
+		Fingerprint:  base64.StdEncoding.EncodeToString(data),
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"unicode"
+)
+
+// Captions are drawn with a built-in 5x7 bitmap font so rendering needs no
+// font files. Each glyph is seven rows of five bits, the leftmost pixel in
+// the highest bit. Lines leave room above the glyphs and below them for
+// accents (font_intl.go).
+const (
+	glyphWidth   = 5
+	glyphHeight  = 7
+	glyphSpacing = 1
+	glyphAscent  = 2
+	glyphDescent = 1
+)
+
+// glyphs holds the ASCII characters the caption font can draw; lowercase
+// letters are drawn in uppercase, fallbackGlyphs and glyphAliases cover
+// more and anything else is drawn as '?'
+var glyphs = map[rune][glyphHeight]uint8{
+	' ':  {},
+	'!':  {0x04, 0x04, 0x04, 0x04, 0x04, 0x00, 0x04},
+	'"':  {0x0A, 0x0A, 0x0A, 0x00, 0x00, 0x00, 0x00},
+	'#':  {0x0A, 0x0A, 0x1F, 0x0A, 0x1F, 0x0A, 0x0A},
+	'$':  {0x04, 0x0F, 0x14, 0x0E, 0x05, 0x1E, 0x04},
+	'%':  {0x18, 0x19, 0x02, 0x04, 0x08, 0x13, 0x03},
+	'&':  {0x0C, 0x12, 0x14, 0x08, 0x15, 0x12, 0x0D},
+	'\'': {0x0C, 0x04, 0x08, 0x00, 0x00, 0x00, 0x00},
+	'(':  {0x02, 0x04, 0x08, 0x08, 0x08, 0x04, 0x02},
+	')':  {0x08, 0x04, 0x02, 0x02, 0x02, 0x04, 0x08},
+	'*':  {0x00, 0x04, 0x15, 0x0E, 0x15, 0x04, 0x00},
+	'+':  {0x00, 0x04, 0x04, 0x1F, 0x04, 0x04, 0x00},
+	',':  {0x00, 0x00, 0x00, 0x00, 0x0C, 0x04, 0x08},
+	'-':  {0x00, 0x00, 0x00, 0x1F, 0x00, 0x00, 0x00},
+	'.':  {0x00, 0x00, 0x00, 0x00, 0x00, 0x0C, 0x0C},
+	'/':  {0x00, 0x01, 0x02, 0x04, 0x08, 0x10, 0x00},
+	'0':  {0x0E, 0x11, 0x13, 0x15, 0x19, 0x11, 0x0E},
+	'1':  {0x04, 0x0C, 0x04, 0x04, 0x04, 0x04, 0x0E},
+	'2':  {0x0E, 0x11, 0x01, 0x02, 0x04, 0x08, 0x1F},
+	'3':  {0x1F, 0x02, 0x04, 0x02, 0x01, 0x11, 0x0E},
+	'4':  {0x02, 0x06, 0x0A, 0x12, 0x1F, 0x02, 0x02},
+	'5':  {0x1F, 0x10, 0x1E, 0x01, 0x01, 0x11, 0x0E},
+	'6':  {0x06, 0x08, 0x10, 0x1E, 0x11, 0x11, 0x0E},
+	'7':  {0x1F, 0x01, 0x02, 0x04, 0x08, 0x08, 0x08},
+	'8':  {0x0E, 0x11, 0x11, 0x0E, 0x11, 0x11, 0x0E},
+	'9':  {0x0E, 0x11, 0x11, 0x0F, 0x01, 0x02, 0x0C},
+	':':  {0x00, 0x0C, 0x0C, 0x00, 0x0C, 0x0C, 0x00},
+	';':  {0x00, 0x0C, 0x0C, 0x00, 0x0C, 0x04, 0x08},
+	'<':  {0x02, 0x04, 0x08, 0x10, 0x08, 0x04, 0x02},
+	'=':  {0x00, 0x00, 0x1F, 0x00, 0x1F, 0x00, 0x00},
+	'>':  {0x08, 0x04, 0x02, 0x01, 0x02, 0x04, 0x08},
+	'?':  {0x0E, 0x11, 0x01, 0x02, 0x04, 0x00, 0x04},
+	'@':  {0x0E, 0x11, 0x01, 0x0D, 0x15, 0x15, 0x0E},
+	'A':  {0x0E, 0x11, 0x11, 0x1F, 0x11, 0x11, 0x11},
+	'B':  {0x1E, 0x11, 0x11, 0x1E, 0x11, 0x11, 0x1E},
+	'C':  {0x0E, 0x11, 0x10, 0x10, 0x10, 0x11, 0x0E},
+	'D':  {0x1C, 0x12, 0x11, 0x11, 0x11, 0x12, 0x1C},
+	'E':  {0x1F, 0x10, 0x10, 0x1E, 0x10, 0x10, 0x1F},
+	'F':  {0x1F, 0x10, 0x10, 0x1E, 0x10, 0x10, 0x10},
+	'G':  {0x0E, 0x11, 0x10, 0x17, 0x11, 0x11, 0x0F},
+	'H':  {0x11, 0x11, 0x11, 0x1F, 0x11, 0x11, 0x11},
+	'I':  {0x0E, 0x04, 0x04, 0x04, 0x04, 0x04, 0x0E},
+	'J':  {0x07, 0x02, 0x02, 0x02, 0x02, 0x12, 0x0C},
+	'K':  {0x11, 0x12, 0x14, 0x18, 0x14, 0x12, 0x11},
+	'L':  {0x10, 0x10, 0x10, 0x10, 0x10, 0x10, 0x1F},
+	'M':  {0x11, 0x1B, 0x15, 0x15, 0x11, 0x11, 0x11},
+	'N':  {0x11, 0x11, 0x19, 0x15, 0x13, 0x11, 0x11},
+	'O':  {0x0E, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0E},
+	'P':  {0x1E, 0x11, 0x11, 0x1E, 0x10, 0x10, 0x10},
+	'Q':  {0x0E, 0x11, 0x11, 0x11, 0x15, 0x12, 0x0D},
+	'R':  {0x1E, 0x11, 0x11, 0x1E, 0x14, 0x12, 0x11},
+	'S':  {0x0F, 0x10, 0x10, 0x0E, 0x01, 0x01, 0x1E},
+	'T':  {0x1F, 0x04, 0x04, 0x04, 0x04, 0x04, 0x04},
+	'U':  {0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0E},
+	'V':  {0x11, 0x11, 0x11, 0x11, 0x11, 0x0A, 0x04},
+	'W':  {0x11, 0x11, 0x11, 0x15, 0x15, 0x15, 0x0A},
+	'X':  {0x11, 0x11, 0x0A, 0x04, 0x0A, 0x11, 0x11},
+	'Y':  {0x11, 0x11, 0x11, 0x0A, 0x04, 0x04, 0x04},
+	'Z':  {0x1F, 0x01, 0x02, 0x04, 0x08, 0x10, 0x1F},
+	'[':  {0x0E, 0x08, 0x08, 0x08, 0x08, 0x08, 0x0E},
+	'\\': {0x00, 0x10, 0x08, 0x04, 0x02, 0x01, 0x00},
+	']':  {0x0E, 0x02, 0x02, 0x02, 0x02, 0x02, 0x0E},
+	'^':  {0x04, 0x0A, 0x11, 0x00, 0x00, 0x00, 0x00},
+	'_':  {0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x1F},
+	'`':  {0x08, 0x04, 0x02, 0x00, 0x00, 0x00, 0x00},
+	'{':  {0x02, 0x04, 0x04, 0x08, 0x04, 0x04, 0x02},
+	'|':  {0x04, 0x04, 0x04, 0x04, 0x04, 0x04, 0x04},
+	'}':  {0x08, 0x04, 0x04, 0x02, 0x04, 0x04, 0x08},
+	'~':  {0x00, 0x00, 0x08, 0x15, 0x02, 0x00, 0x00},
+}
+
+// glyphFor returns the rows of the glyph drawn for r, which is drawn in
+// uppercase
+func glyphFor(r rune) [glyphHeight]uint8 {
+	r = unicode.ToUpper(r)
+	if alias, ok := glyphAliases[r]; ok {
+		r = alias
+	}
+	if g, ok := glyphs[r]; ok {
+		return g
+	}
+	if g, ok := fallbackGlyphs[r]; ok {
+		return g
+	}
+	return glyphs['?']
+}
+
+// glyphCell is what is drawn in one cell of a line: a glyph and the accents
+// over and under it
+type glyphCell struct {
+	rows  [glyphHeight]uint8
+	above [glyphAscent]uint8
+	below uint8
+}
+
+// addAccent draws a combining mark over or under the cell's glyph; marks
+// the font has no rows for are left out
+func (c *glyphCell) addAccent(mark rune) {
+	a := accents[mark]
+	for i, bits := range a.above {
+		c.above[i] |= bits
+	}
+	c.below |= a.below
+}
+
+// zeroWidthJoiner joins the emoji on either side into one, as in family
+// and profession emoji
+const zeroWidthJoiner = '\u200d'
+
+// layoutCells splits text into the cells of its line, one per character as
+// a reader sees it: combining marks, variation selectors, skin tones and
+// whatever a zero width joiner attaches go into the cell of the character
+// before them, and so does the second of the regional indicators of a flag.
+// Other invisible format characters take no cell, and controls and spaces
+// of any width take a space's.
+func layoutCells(text string) []glyphCell {
+	var cells []glyphCell
+	joined, flag := false, false
+	for _, r := range text {
+		continues := joined || unicode.In(r, unicode.Mn, unicode.Me) ||
+			r >= 0x1f3fb && r <= 0x1f3ff || // skin tones
+			flag && isRegionalIndicator(r)
+		joined = r == zeroWidthJoiner
+		switch {
+		case continues && len(cells) > 0:
+			cells[len(cells)-1].addAccent(r)
+			flag = false
+			continue
+		case joined || unicode.Is(unicode.Cf, r) || continues:
+			continue
+		case unicode.IsControl(r) || unicode.Is(unicode.Zs, r):
+			r = ' '
+		}
+		flag = isRegionalIndicator(r)
+
+		var cell glyphCell
+		if d, ok := decompositions[unicode.ToUpper(r)]; ok {
+			r = d[0]
+			cell.addAccent(d[1])
+		}
+		cell.rows = glyphFor(r)
+		cells = append(cells, cell)
+	}
+	return cells
+}
+
+// isRegionalIndicator reports whether r is one of the letters flag emoji are
+// written as pairs of
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+// textWidth returns the width in pixels of text drawn at scale
+func textWidth(text string, scale int) int {
+	n := len(layoutCells(text))
+	if n == 0 {
+		return 0
+	}
+	return (n*(glyphWidth+glyphSpacing) - glyphSpacing) * scale
+}
+
+// textHeight returns the height in pixels of a line drawn at scale
+func textHeight(scale int) int {
+	return (glyphAscent + glyphHeight + glyphDescent) * scale
+}
+
+// drawCaption draws text in c on a box of bg, padded by a font pixel, with
+// the top left corner of the box at corner. Translucent colors blend with
+// the image beneath.
+func drawCaption(img *image.RGBA, corner image.Point, text string, c, bg color.RGBA, scale int) {
+	pad := scale
+	box := captionBox(text, scale).Add(corner).Intersect(img.Bounds())
+
+	for y := box.Min.Y; y < box.Max.Y; y++ {
+		for x := box.Min.X; x < box.Max.X; x++ {
+			blendPixel(img, x, y, bg)
+		}
+	}
+	drawText(img, corner.X+pad, corner.Y+pad, text, c, scale)
+}
+
+// captionBox returns the size of the box drawCaption draws text in, at the
+// origin
+func captionBox(text string, scale int) image.Rectangle {
+	return image.Rect(0, 0, textWidth(text, scale)+2*scale, textHeight(scale)+2*scale)
+}
+
+// drawText draws text with its top left corner at x, y, each font pixel
+// as a scale x scale square. Pixels outside img are skipped.
+func drawText(img *image.RGBA, x, y int, text string, c color.RGBA, scale int) {
+	for _, cell := range layoutCells(text) {
+		for row, bits := range cell.above {
+			drawGlyphRow(img, x, y+row*scale, bits, c, scale)
+		}
+		for row, bits := range cell.rows {
+			drawGlyphRow(img, x, y+(glyphAscent+row)*scale, bits, c, scale)
+		}
+		drawGlyphRow(img, x, y+(glyphAscent+glyphHeight)*scale, cell.below, c, scale)
+		x += (glyphWidth + glyphSpacing) * scale
+	}
+}
+
+// drawGlyphRow draws one row of font pixels with its left end at x, y
+func drawGlyphRow(img *image.RGBA, x, y int, bits uint8, c color.RGBA, scale int) {
+	bounds := img.Bounds()
+	for col := 0; col < glyphWidth; col++ {
+		if bits&(1<<(glyphWidth-1-col)) == 0 {
+			continue
+		}
+		fill := image.Rect(x+col*scale, y, x+(col+1)*scale, y+scale).Intersect(bounds)
+		for py := fill.Min.Y; py < fill.Max.Y; py++ {
+			for px := fill.Min.X; px < fill.Max.X; px++ {
+				blendPixel(img, px, py, c)
+			}
+		}
+	}
+}
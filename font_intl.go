@@ -0,0 +1,133 @@
+package main
+
+// The caption font goes past ASCII so titles and file names in other
+// languages don't come out as rows of '?': accented Latin letters are drawn
+// as their base letter with the accent in the rows above or below it, and
+// Cyrillic and Greek capitals that look like Latin ones share their glyphs.
+// Scripts the 5x7 grid can't hold, such as CJK and emoji, are still drawn
+// as a single '?' per character.
+
+// fallbackGlyphs holds the glyphs past ASCII, in the layout of glyphs
+var fallbackGlyphs = map[rune][glyphHeight]uint8{
+	// Latin
+	'ß': {0x0C, 0x12, 0x12, 0x14, 0x12, 0x11, 0x16},
+	'Æ': {0x0F, 0x14, 0x14, 0x1F, 0x14, 0x14, 0x17},
+	'Œ': {0x0F, 0x14, 0x14, 0x17, 0x14, 0x14, 0x0F},
+	'Ø': {0x0E, 0x13, 0x15, 0x15, 0x15, 0x19, 0x0E},
+	'Ð': {0x1C, 0x12, 0x11, 0x1D, 0x11, 0x12, 0x1C},
+	'Þ': {0x10, 0x1E, 0x11, 0x11, 0x1E, 0x10, 0x10},
+	'Ł': {0x10, 0x10, 0x14, 0x18, 0x10, 0x10, 0x1F},
+
+	// Cyrillic
+	'Б': {0x1F, 0x10, 0x10, 0x1E, 0x11, 0x11, 0x1E},
+	'Г': {0x1F, 0x10, 0x10, 0x10, 0x10, 0x10, 0x10},
+	'Д': {0x06, 0x0A, 0x0A, 0x0A, 0x0A, 0x1F, 0x11},
+	'Є': {0x0E, 0x11, 0x10, 0x1C, 0x10, 0x11, 0x0E},
+	'Ж': {0x15, 0x15, 0x15, 0x0E, 0x15, 0x15, 0x15},
+	'И': {0x11, 0x11, 0x13, 0x15, 0x19, 0x11, 0x11},
+	'Л': {0x07, 0x09, 0x09, 0x09, 0x09, 0x09, 0x11},
+	'П': {0x1F, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11},
+	'У': {0x11, 0x11, 0x11, 0x0F, 0x01, 0x11, 0x0E},
+	'Ф': {0x04, 0x0E, 0x15, 0x15, 0x15, 0x0E, 0x04},
+	'Ц': {0x12, 0x12, 0x12, 0x12, 0x12, 0x1F, 0x01},
+	'Ч': {0x11, 0x11, 0x11, 0x0F, 0x01, 0x01, 0x01},
+	'Ш': {0x15, 0x15, 0x15, 0x15, 0x15, 0x15, 0x1F},
+	'Щ': {0x15, 0x15, 0x15, 0x15, 0x15, 0x1F, 0x01},
+	'Ъ': {0x18, 0x08, 0x08, 0x0E, 0x09, 0x09, 0x0E},
+	'Ы': {0x11, 0x11, 0x11, 0x19, 0x15, 0x15, 0x19},
+	'Ь': {0x10, 0x10, 0x10, 0x1E, 0x11, 0x11, 0x1E},
+	'Э': {0x0E, 0x11, 0x01, 0x07, 0x01, 0x11, 0x0E},
+	'Ю': {0x12, 0x15, 0x15, 0x1D, 0x15, 0x15, 0x12},
+	'Я': {0x0F, 0x11, 0x11, 0x0F, 0x05, 0x09, 0x11},
+
+	// Greek
+	'Δ': {0x04, 0x04, 0x0A, 0x0A, 0x11, 0x11, 0x1F},
+	'Θ': {0x0E, 0x11, 0x11, 0x1F, 0x11, 0x11, 0x0E},
+	'Λ': {0x04, 0x04, 0x0A, 0x0A, 0x11, 0x11, 0x11},
+	'Ξ': {0x1F, 0x00, 0x00, 0x0E, 0x00, 0x00, 0x1F},
+	'Σ': {0x1F, 0x10, 0x08, 0x04, 0x08, 0x10, 0x1F},
+	'Ψ': {0x15, 0x15, 0x15, 0x0E, 0x04, 0x04, 0x04},
+	'Ω': {0x0E, 0x11, 0x11, 0x11, 0x0A, 0x0A, 0x1B},
+
+	// Punctuation and symbols
+	'…': {0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x15},
+	'«': {0x00, 0x05, 0x0A, 0x14, 0x0A, 0x05, 0x00},
+	'»': {0x00, 0x14, 0x0A, 0x05, 0x0A, 0x14, 0x00},
+	'•': {0x00, 0x00, 0x0E, 0x0E, 0x0E, 0x00, 0x00},
+	'·': {0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00},
+	'°': {0x0C, 0x12, 0x12, 0x0C, 0x00, 0x00, 0x00},
+	'×': {0x00, 0x11, 0x0A, 0x04, 0x0A, 0x11, 0x00},
+	'¡': {0x04, 0x00, 0x04, 0x04, 0x04, 0x04, 0x04},
+	'¿': {0x04, 0x00, 0x04, 0x08, 0x10, 0x11, 0x0E},
+	'€': {0x06, 0x09, 0x1C, 0x08, 0x1C, 0x09, 0x06},
+	'£': {0x06, 0x09, 0x08, 0x1C, 0x08, 0x08, 0x1F},
+}
+
+// glyphAliases draws characters with the glyph of one that looks the same
+var glyphAliases = map[rune]rune{
+	'Đ': 'Ð', 'ẞ': 'ß',
+
+	'А': 'A', 'В': 'B', 'Е': 'E', 'З': '3', 'І': 'I', 'Ј': 'J', 'К': 'K', 'М': 'M',
+	'Н': 'H', 'О': 'O', 'Р': 'P', 'С': 'C', 'Т': 'T', 'Х': 'X', 'Ѕ': 'S',
+
+	'Α': 'A', 'Β': 'B', 'Γ': 'Г', 'Ε': 'E', 'Ζ': 'Z', 'Η': 'H', 'Ι': 'I', 'Κ': 'K',
+	'Μ': 'M', 'Ν': 'N', 'Ο': 'O', 'Π': 'П', 'Ρ': 'P', 'Τ': 'T', 'Υ': 'Y', 'Φ': 'Ф',
+	'Χ': 'X',
+
+	'‘': '\'', '’': '\'', '‚': '\'', '′': '\'',
+	'“': '"', '”': '"', '„': '"', '″': '"',
+	'‐': '-', '‑': '-', '–': '-', '—': '-', '−': '-',
+}
+
+// accent is a combining mark as drawn: rows over the glyph, the top one
+// first, and a row under it
+type accent struct {
+	above [glyphAscent]uint8
+	below uint8
+}
+
+// accents holds the combining marks the font draws
+var accents = map[rune]accent{
+	'\u0300': {above: [glyphAscent]uint8{0x08, 0x04}}, // grave
+	'\u0301': {above: [glyphAscent]uint8{0x02, 0x04}}, // acute
+	'\u0302': {above: [glyphAscent]uint8{0x04, 0x0A}}, // circumflex
+	'\u0303': {above: [glyphAscent]uint8{0x0D, 0x16}}, // tilde
+	'\u0304': {above: [glyphAscent]uint8{0x00, 0x0E}}, // macron
+	'\u0306': {above: [glyphAscent]uint8{0x11, 0x0E}}, // breve
+	'\u0307': {above: [glyphAscent]uint8{0x00, 0x04}}, // dot above
+	'\u0308': {above: [glyphAscent]uint8{0x00, 0x0A}}, // diaeresis
+	'\u030a': {above: [glyphAscent]uint8{0x0E, 0x0E}}, // ring above
+	'\u030b': {above: [glyphAscent]uint8{0x05, 0x0A}}, // double acute
+	'\u030c': {above: [glyphAscent]uint8{0x0A, 0x04}}, // caron
+	'\u0327': {below: 0x04},                           // cedilla
+	'\u0328': {below: 0x02},                           // ogonek
+}
+
+// decompositions maps precomposed capitals to their base letter and mark,
+// for which the standard library has no tables. Lowercase letters are
+// looked up by their capital.
+var decompositions = map[rune][2]rune{}
+
+func init() {
+	// Pairs of a precomposed capital and its base letter, by mark
+	for mark, pairs := range map[rune]string{
+		'\u0300': "ÀAÈEÌIÒOÙUẀWỲYǸN",
+		'\u0301': "ÁAÉEÍIÓOÚUÝYĆCĹLŃNŔRŚSŹZǴGẂWǼÆǾØΆΑΈΕΉΗΊΙΌΟΎΥΏΩЃГЌК",
+		'\u0302': "ÂAÊEÎIÔOÛUĈCĜGĤHĴJŜSŴWŶY",
+		'\u0303': "ÃAÑNÕOĨIŨUỸY",
+		'\u0304': "ĀAĒEĪIŌOŪUȲY",
+		'\u0306': "ĂAĔEĞGĬIŎOŬUЙИЎУ",
+		'\u0307': "ĊCĖEĠGİIŻZ",
+		'\u0308': "ÄAËEÏIÖOÜUŸYẄWЁЕЇІΪΙΫΥ",
+		'\u030a': "ÅAŮU",
+		'\u030b': "ŐOŰU",
+		'\u030c': "ǍAČCĎDĚEǦGȞHǏIǨKĽLŇNǑOŘRŠSŤTǓUŽZ",
+		'\u0327': "ÇCĢGĶKĻLŅNŖRŞSŢT",
+		'\u0328': "ĄAĘEĮIǪOŲU",
+	} {
+		runes := []rune(pairs)
+		for i := 0; i+1 < len(runes); i += 2 {
+			decompositions[runes[i]] = [2]rune{runes[i+1], mark}
+		}
+	}
+}
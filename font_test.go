@@ -0,0 +1,124 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestTextWidth(t *testing.T) {
+	tests := []struct {
+		text  string
+		scale int
+		want  int
+	}{
+		{"", 1, 0},
+		{"A", 1, 5},
+		{"AB", 1, 11},
+		{"LUFS", 2, 46},
+		{"é", 1, 5},
+		{"e\u0301", 1, 5},         // a combining accent shares its letter's cell
+		{"👍🏽", 1, 5},              // a skin tone
+		{"👨\u200d👩\u200d👧", 1, 5}, // a family joined by zero width joiners
+		{"🇩🇪🇫🇷", 1, 11},           // two flags of two regional indicators each
+		{"a\u200bb", 1, 11},       // a zero width space
+	}
+
+	for _, tt := range tests {
+		if got := textWidth(tt.text, tt.scale); got != tt.want {
+			t.Errorf("textWidth(%q, %d) = %d, want %d", tt.text, tt.scale, got, tt.want)
+		}
+	}
+}
+
+func TestLayoutCells(t *testing.T) {
+	// Accented letters are their base letter with the accent above or below,
+	// whether precomposed or not
+	e := layoutCells("e")[0]
+	for _, text := range []string{"É", "é", "E\u0301"} {
+		cells := layoutCells(text)
+		if len(cells) != 1 || cells[0].rows != e.rows || cells[0].above != accents['\u0301'].above {
+			t.Errorf("%q = %+v", text, cells)
+		}
+	}
+	if c := layoutCells("ç")[0]; c.rows != glyphFor('C') || c.below == 0 {
+		t.Errorf("ç = %+v", c)
+	}
+
+	// Cyrillic and Greek are drawn, with Latin glyphs where they look alike
+	unknown := glyphFor('?')
+	for _, r := range "АБВГДЕЁЖЗИЙКЛМНОПРСТУФХЦЧШЩЪЫЬЭЮЯабвгдеёжзийклмнопрстуфхцчшщъыьэюяΑΒΓΔΕΖΗΘΙΚΛΜΝΞΟΠΡΣΤΥΦΧΨΩαβγδεζηθικλμνξοπρσςτυφχψωáàâäãåāçčćðéèêëěęğíìîïıłñńňóòôöõøőœřśšşßťúùûüůűýÿžźż&@[]{}<>|~“”‘’–—… " {
+		if cells := layoutCells(string(r)); len(cells) != 1 || cells[0].rows == unknown {
+			t.Errorf("%q drawn as '?'", r)
+		}
+	}
+	if cells := layoutCells("漢"); len(cells) != 1 || cells[0].rows != unknown {
+		t.Errorf("漢 = %+v, want '?'", cells)
+	}
+
+	for r, g := range fallbackGlyphs {
+		for _, bits := range g {
+			if bits >= 1<<glyphWidth {
+				t.Errorf("the glyph of %q is wider than %d pixels", r, glyphWidth)
+			}
+		}
+	}
+	for r, alias := range glyphAliases {
+		if glyphFor(alias) == unknown {
+			t.Errorf("%q is drawn as %q, which has no glyph", r, alias)
+		}
+	}
+}
+
+func TestLoudnessCaption(t *testing.T) {
+	lufs, lra := -14.25, 5.06
+
+	tests := []struct {
+		name   string
+		report LoudnessReport
+		want   string
+	}{
+		{"both", LoudnessReport{IntegratedLUFS: &lufs, LoudnessRangeLU: &lra}, "-14.2 LUFS  LRA 5.1 LU"},
+		{"no range", LoudnessReport{IntegratedLUFS: &lufs}, "-14.2 LUFS"},
+		{"silent", LoudnessReport{}, "-INF LUFS"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.report.caption(); got != tt.want {
+				t.Errorf("caption() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoudnessCaptionOverlay(t *testing.T) {
+	opts := Options{Width: 200, Height: 100, LoudnessCaption: true}
+	consumers := newFileConsumers(opts)
+	if consumers.loudness == nil {
+		t.Fatal("-loudness-caption did not add a loudness analyzer")
+	}
+	if _, _, err := decodePeaks(writeFixture(t, DefaultTestAudio()), opts, consumers.all()); err != nil {
+		t.Fatalf("decodePeaks: %v", err)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, opts.Width, opts.Height))
+	consumers.decorations().apply(img)
+
+	text := consumers.loudness.Result().(LoudnessReport).caption()
+	inked := 0
+	for y := 0; y < opts.Height; y++ {
+		for x := 0; x < opts.Width; x++ {
+			if img.RGBAAt(x, y) == waveformColor {
+				inside := x >= captionMargin && x < captionMargin+textWidth(text, 1)+2 &&
+					y >= captionMargin && y < captionMargin+textHeight(1)+2
+				if !inside {
+					t.Fatalf("caption pixel at %d,%d outside the caption box", x, y)
+				}
+				inked++
+			}
+		}
+	}
+	if inked == 0 {
+		t.Error("no caption pixels drawn")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// decimalCommaLanguages write decimal fractions with a comma
+var decimalCommaLanguages = map[string]bool{
+	"bg": true, "ca": true, "cs": true, "da": true, "de": true, "el": true, "es": true, "et": true,
+	"eu": true, "fi": true, "fr": true, "gl": true, "hr": true, "hu": true, "id": true, "is": true,
+	"it": true, "lt": true, "lv": true, "nb": true, "nl": true, "nn": true, "no": true, "pl": true,
+	"pt": true, "ro": true, "ru": true, "sk": true, "sl": true, "sr": true, "sv": true, "tr": true,
+	"uk": true, "vi": true,
+}
+
+// reportDecimal is the decimal separator of formatted durations and sizes,
+// from the locale of the environment
+var reportDecimal = localeDecimal(os.Getenv)
+
+// localeDecimal returns the decimal separator of the locale named by the
+// first of LC_ALL, LC_NUMERIC and LANG that is set, e.g. "," for de_DE.UTF-8.
+// It is "." for C, POSIX and anything unknown.
+func localeDecimal(getenv func(string) string) string {
+	for _, name := range []string{"LC_ALL", "LC_NUMERIC", "LANG"} {
+		locale := getenv(name)
+		if locale == "" {
+			continue
+		}
+		language, _, _ := strings.Cut(strings.ToLower(locale), "_")
+		language, _, _ = strings.Cut(language, ".")
+		if decimalCommaLanguages[language] {
+			return ","
+		}
+		return "."
+	}
+	return "."
+}
+
+// formatDuration formats a duration as hh:mm:ss.mmm, rounded to the
+// millisecond, as reports, captions and printed summaries show it
+func formatDuration(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	ms := d.Round(time.Millisecond).Milliseconds()
+	return fmt.Sprintf("%s%02d:%02d:%02d%s%03d", sign, ms/3600000, ms/60000%60, ms/1000%60, reportDecimal, ms%1000)
+}
+
+// formatSeconds formats a duration in seconds as formatDuration does
+func formatSeconds(seconds float64) string {
+	if math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return "--:--:--" + reportDecimal + "---"
+	}
+	return formatDuration(time.Duration(math.Round(seconds * float64(time.Second))))
+}
+
+// formatSize formats a size in bytes with the units parseByteSize reads
+// (1 KB is 1024 bytes), e.g. "512 B" or "1.5 MB"
+func formatSize(bytes int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value, unit := float64(bytes), 0
+	for math.Abs(value) >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	return strings.Replace(fmt.Sprintf("%.1f %s", value, units[unit]), ".", reportDecimal, 1)
+}
+
+// FormattedFields holds values of a report formatted for display, so UIs
+// show them as the command line does
+type FormattedFields struct {
+	Duration   string `json:"duration,omitempty"`
+	FileSize   string `json:"file_size,omitempty"`
+	OutputSize string `json:"output_size,omitempty"`
+	Elapsed    string `json:"elapsed,omitempty"`
+}
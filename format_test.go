@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLocaleDecimal(t *testing.T) {
+	for _, tc := range []struct {
+		env  map[string]string
+		want string
+	}{
+		{nil, "."},
+		{map[string]string{"LANG": "C"}, "."},
+		{map[string]string{"LANG": "de_DE.UTF-8"}, ","},
+		{map[string]string{"LANG": "fr"}, ","},
+		{map[string]string{"LANG": "de_DE.UTF-8", "LC_NUMERIC": "en_US.UTF-8"}, "."},
+		{map[string]string{"LC_NUMERIC": "en_US", "LC_ALL": "pt_BR"}, ","},
+	} {
+		if got := localeDecimal(func(name string) string { return tc.env[name] }); got != tc.want {
+			t.Errorf("localeDecimal(%v) = %q, want %q", tc.env, got, tc.want)
+		}
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	defer func(decimal string) { reportDecimal = decimal }(reportDecimal)
+	reportDecimal = "."
+
+	for d, want := range map[time.Duration]string{
+		0:                              "00:00:00.000",
+		1500 * time.Microsecond:        "00:00:00.002",
+		3*time.Minute + 25*time.Second: "00:03:25.000",
+		59*time.Minute + 59999600*time.Microsecond:         "01:00:00.000",
+		26*time.Hour + 3*time.Second + 45*time.Millisecond: "26:00:03.045",
+		-2 * time.Second: "-00:00:02.000",
+	} {
+		if got := formatDuration(d); got != want {
+			t.Errorf("formatDuration(%v) = %q, want %q", d, got, want)
+		}
+	}
+
+	if got := formatSeconds(2.0005); got != "00:00:02.001" {
+		t.Errorf("formatSeconds(2.0005) = %q", got)
+	}
+	if got := formatSeconds(math.NaN()); got != "--:--:--.---" {
+		t.Errorf("formatSeconds(NaN) = %q", got)
+	}
+
+	reportDecimal = ","
+	if got := formatSeconds(61.25); got != "00:01:01,250" {
+		t.Errorf("formatSeconds(61.25) with a decimal comma = %q", got)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	defer func(decimal string) { reportDecimal = decimal }(reportDecimal)
+	reportDecimal = "."
+
+	for bytes, want := range map[int64]string{
+		0:               "0 B",
+		512:             "512 B",
+		1536:            "1.5 KB",
+		5 << 20:         "5.0 MB",
+		3<<30 + 512<<20: "3.5 GB",
+		2048 << 40:      "2048.0 TB",
+	} {
+		if got := formatSize(bytes); got != want {
+			t.Errorf("formatSize(%d) = %q, want %q", bytes, got, want)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ftpTimeout bounds connecting and every command exchange with an FTP server
+const ftpTimeout = 30 * time.Second
+
+// ftpStorage is a directory on an FTP server. Every operation uses its own
+// control connection, so transfers can run concurrently.
+type ftpStorage struct {
+	addr     string
+	user     string
+	password string
+	dir      string
+}
+
+// newFTPStorage opens an ftp://[user[:password]@]host[:port]/dir location.
+// Credentials missing from the URL come from FTP_USER and FTP_PASSWORD, and
+// default to an anonymous login.
+func newFTPStorage(location string) (*ftpStorage, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", location, err)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("%s: missing host name", location)
+	}
+
+	s := &ftpStorage{
+		addr:     u.Host,
+		user:     os.Getenv("FTP_USER"),
+		password: os.Getenv("FTP_PASSWORD"),
+		dir:      strings.TrimSuffix(u.Path, "/"),
+	}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "21")
+	}
+	if u.User != nil {
+		s.user = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			s.password = password
+		}
+	}
+	if s.user == "" {
+		s.user, s.password = "anonymous", "anonymous@"
+	}
+	return s, nil
+}
+
+// filePath returns the server path of a file in the directory
+func (s *ftpStorage) filePath(name string) string {
+	if s.dir == "" {
+		return name
+	}
+	return s.dir + "/" + name
+}
+
+// ftpConn is a logged in control connection
+type ftpConn struct {
+	conn net.Conn
+	text *textproto.Conn
+	host string
+}
+
+// dial connects and logs in, switching to binary transfers
+func (s *ftpStorage) dial(ctx context.Context) (*ftpConn, error) {
+	d := net.Dialer{Timeout: ftpTimeout}
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	host, _, _ := net.SplitHostPort(s.addr)
+	c := &ftpConn{conn: conn, text: textproto.NewConn(conn), host: host}
+	if _, _, err := c.response(220); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	code, _, err := c.cmd(0, "USER %s", s.user)
+	if err == nil && code == 331 {
+		_, _, err = c.cmd(230, "PASS %s", s.password)
+	} else if err == nil && code != 230 {
+		err = fmt.Errorf("ftp: USER: unexpected reply %d", code)
+	}
+	if err == nil {
+		_, _, err = c.cmd(200, "TYPE I")
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// cmd sends a command and reads its reply. An expect of 0 accepts any
+// reply below 400; see textproto.Reader.ReadResponse for how it matches.
+func (c *ftpConn) cmd(expect int, format string, args ...any) (int, string, error) {
+	for _, arg := range args {
+		if s, ok := arg.(string); ok && strings.ContainsAny(s, "\r\n") {
+			return 0, "", fmt.Errorf("ftp: %q contains a line break", s)
+		}
+	}
+
+	c.conn.SetDeadline(time.Now().Add(ftpTimeout))
+	if err := c.text.PrintfLine(format, args...); err != nil {
+		return 0, "", err
+	}
+	return c.response(expect)
+}
+
+// response reads one reply
+func (c *ftpConn) response(expect int) (int, string, error) {
+	c.conn.SetDeadline(time.Now().Add(ftpTimeout))
+	code, msg, err := c.text.ReadResponse(expect)
+	if err == nil && expect == 0 && code >= 400 {
+		err = &textproto.Error{Code: code, Msg: msg}
+	}
+	if err != nil {
+		return code, msg, fmt.Errorf("ftp: %w", err)
+	}
+	return code, msg, nil
+}
+
+// transfer opens a passive data connection and starts a command that uses
+// it. The caller closes the connection and reads the final reply with
+// finish.
+func (c *ftpConn) transfer(ctx context.Context, format string, args ...any) (net.Conn, error) {
+	port, err := c.passivePort()
+	if err != nil {
+		return nil, err
+	}
+
+	// The data connection goes to the control host, whatever address a
+	// passive reply names, as servers behind NAT often name the wrong one
+	d := net.Dialer{Timeout: ftpTimeout}
+	data, err := d.DialContext(ctx, "tcp", net.JoinHostPort(c.host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, _, err := c.cmd(1, format, args...); err != nil {
+		data.Close()
+		return nil, err
+	}
+	return data, nil
+}
+
+// passivePort asks for a data port, with EPSV and then PASV
+func (c *ftpConn) passivePort() (int, error) {
+	if _, msg, err := c.cmd(229, "EPSV"); err == nil {
+		// Entering Extended Passive Mode (|||port|)
+		start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)")
+		if start >= 0 && end > start+4 {
+			if port, err := strconv.Atoi(msg[start+4 : end]); err == nil {
+				return port, nil
+			}
+		}
+		return 0, fmt.Errorf("ftp: can't parse EPSV reply %q", msg)
+	}
+
+	_, msg, err := c.cmd(227, "PASV")
+	if err != nil {
+		return 0, err
+	}
+	// Entering Passive Mode (h1,h2,h3,h4,p1,p2)
+	start, end := strings.Index(msg, "("), strings.Index(msg, ")")
+	if start < 0 || end < start {
+		return 0, fmt.Errorf("ftp: can't parse PASV reply %q", msg)
+	}
+	fields := strings.Split(msg[start+1:end], ",")
+	if len(fields) != 6 {
+		return 0, fmt.Errorf("ftp: can't parse PASV reply %q", msg)
+	}
+	hi, err1 := strconv.Atoi(fields[4])
+	lo, err2 := strconv.Atoi(fields[5])
+	if err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("ftp: can't parse PASV reply %q", msg)
+	}
+	return hi<<8 | lo, nil
+}
+
+// finish reads the reply that ends a transfer
+func (c *ftpConn) finish() error {
+	_, _, err := c.response(2)
+	return err
+}
+
+// Close logs out and closes the control connection
+func (c *ftpConn) Close() error {
+	c.conn.SetDeadline(time.Now().Add(ftpTimeout))
+	c.text.PrintfLine("QUIT")
+	return c.text.Close()
+}
+
+func (s *ftpStorage) List(ctx context.Context) ([]ObjectInfo, error) {
+	c, err := s.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	dir := s.dir
+	if dir == "" {
+		dir = "."
+	}
+	data, err := c.transfer(ctx, "NLST %s", dir)
+	if err != nil {
+		return nil, err
+	}
+	listing, err := io.ReadAll(data)
+	data.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.finish(); err != nil {
+		return nil, err
+	}
+
+	var objects []ObjectInfo
+	for _, line := range strings.Split(string(listing), "\n") {
+		name := path.Base(strings.TrimSpace(line))
+		if name == "" || name == "." || name == ".." {
+			continue
+		}
+
+		// SIZE and MDTM are extensions; without them a file is still listed
+		obj := ObjectInfo{Name: name}
+		if _, msg, err := c.cmd(213, "SIZE %s", s.filePath(name)); err == nil {
+			obj.Size, _ = strconv.ParseInt(strings.TrimSpace(msg), 10, 64)
+		}
+		if _, msg, err := c.cmd(213, "MDTM %s", s.filePath(name)); err == nil {
+			obj.ETag = strings.TrimSpace(msg)
+		}
+		objects = append(objects, obj)
+	}
+	return objects, nil
+}
+
+// ftpDownload is the body of a RETR; closing it ends the session
+type ftpDownload struct {
+	net.Conn
+	control *ftpConn
+}
+
+func (d *ftpDownload) Close() error {
+	d.Conn.Close()
+	err := d.control.finish()
+	d.control.Close()
+	return err
+}
+
+func (s *ftpStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	c, err := s.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := c.transfer(ctx, "RETR %s", s.filePath(name))
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return &ftpDownload{Conn: data, control: c}, nil
+}
+
+func (s *ftpStorage) Put(ctx context.Context, name string, r io.Reader, size int64, contentType string) error {
+	c, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	// Create the directory a level at a time; levels that exist fail
+	if s.dir != "" {
+		dir := ""
+		if strings.HasPrefix(s.dir, "/") {
+			dir = "/"
+		}
+		for _, part := range strings.Split(strings.Trim(s.dir, "/"), "/") {
+			dir = path.Join(dir, part)
+			c.cmd(0, "MKD %s", dir)
+		}
+	}
+
+	data, err := c.transfer(ctx, "STOR %s", s.filePath(name))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(data, r); err != nil {
+		data.Close()
+		return err
+	}
+	if err := data.Close(); err != nil {
+		return err
+	}
+	return c.finish()
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeFTPServer is an in-memory FTP server with just enough of the protocol
+// for ftpStorage
+type fakeFTPServer struct {
+	ln   net.Listener
+	mu   sync.Mutex
+	dirs map[string]bool
+	// files holds the content of every file by path
+	files map[string][]byte
+}
+
+func newFakeFTPServer(t *testing.T) *fakeFTPServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeFTPServer{ln: ln, dirs: map[string]bool{"/": true}, files: map[string][]byte{}}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeFTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 ready")
+
+	var data net.Listener
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		name := path.Join("/", arg)
+
+		switch cmd {
+		case "USER":
+			text.PrintfLine("331 password please")
+		case "PASS":
+			text.PrintfLine("230 logged in")
+		case "TYPE":
+			text.PrintfLine("200 binary")
+		case "EPSV":
+			data, _ = net.Listen("tcp", "127.0.0.1:0")
+			text.PrintfLine("229 Entering Extended Passive Mode (|||%d|)", data.Addr().(*net.TCPAddr).Port)
+		case "MKD":
+			s.mu.Lock()
+			s.dirs[name] = true
+			s.mu.Unlock()
+			text.PrintfLine("257 created")
+		case "SIZE", "MDTM":
+			s.mu.Lock()
+			content, ok := s.files[name]
+			s.mu.Unlock()
+			if !ok {
+				text.PrintfLine("550 no such file")
+			} else if cmd == "SIZE" {
+				text.PrintfLine("213 %d", len(content))
+			} else {
+				text.PrintfLine("213 20260102030405")
+			}
+		case "NLST", "RETR", "STOR":
+			s.transfer(text, data, cmd, name)
+			data.Close()
+		case "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func (s *fakeFTPServer) transfer(text *textproto.Conn, data net.Listener, cmd, name string) {
+	conn, err := data.Accept()
+	if err != nil {
+		return
+	}
+	text.PrintfLine("150 opening data connection")
+
+	s.mu.Lock()
+	switch cmd {
+	case "NLST":
+		for file := range s.files {
+			if path.Dir(file) == name {
+				fmt.Fprintf(conn, "%s\r\n", file)
+			}
+		}
+	case "RETR":
+		conn.Write(s.files[name])
+	}
+	s.mu.Unlock()
+
+	if cmd == "STOR" {
+		content, _ := io.ReadAll(conn)
+		s.mu.Lock()
+		s.files[name] = content
+		s.mu.Unlock()
+	}
+	conn.Close()
+	text.PrintfLine("226 done")
+}
+
+func TestFTPStorage(t *testing.T) {
+	server := newFakeFTPServer(t)
+	s, err := newFTPStorage("ftp://radio:secret@" + server.ln.Addr().String() + "/out/waveforms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	content := []byte("PNG data")
+	if err := s.Put(ctx, "take.png", bytes.NewReader(content), int64(len(content)), "image/png"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	server.mu.Lock()
+	if !server.dirs["/out"] || !server.dirs["/out/waveforms"] {
+		t.Errorf("directories not created: %v", server.dirs)
+	}
+	server.mu.Unlock()
+
+	objects, err := s.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	want := ObjectInfo{Name: "take.png", Size: int64(len(content)), ETag: "20260102030405"}
+	if len(objects) != 1 || objects[0] != want {
+		t.Errorf("List = %+v, want [%+v]", objects, want)
+	}
+
+	body, err := s.Open(ctx, "take.png")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	got, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := body.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("Open read %q, want %q", got, content)
+	}
+
+	if _, err := s.Open(ctx, "bad\r\nDELE x"); err == nil {
+		t.Error("Open accepted a name with a line break")
+	}
+}
+
+func TestNewFTPStorage(t *testing.T) {
+	t.Setenv("FTP_USER", "")
+	t.Setenv("FTP_PASSWORD", "")
+
+	tests := []struct {
+		location string
+		want     ftpStorage
+		wantErr  bool
+	}{
+		{"ftp://example.com/pub/", ftpStorage{addr: "example.com:21", user: "anonymous", password: "anonymous@", dir: "/pub"}, false},
+		{"ftp://me:pw@example.com:2121", ftpStorage{addr: "example.com:2121", user: "me", password: "pw"}, false},
+		{"ftp:///pub", ftpStorage{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.location, func(t *testing.T) {
+			got, err := newFTPStorage(tt.location)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && *got != tt.want {
+				t.Errorf("got %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gcsScope is the OAuth scope requested for service account tokens
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// gcsStorage is a prefix in a Google Cloud Storage bucket, accessed over the
+// JSON API
+type gcsStorage struct {
+	bucket   string
+	prefix   string
+	endpoint string
+	tokens   *gcsTokenSource // nil when talking to an emulator
+	http     *http.Client
+}
+
+// newGCSStorage opens a gs://bucket/prefix location. Credentials come from
+// GOOGLE_OAUTH_ACCESS_TOKEN or a service account key file named by
+// GOOGLE_APPLICATION_CREDENTIALS; STORAGE_EMULATOR_HOST points at an emulator
+// instead, without authentication.
+func newGCSStorage(location string) (*gcsStorage, error) {
+	bucket, prefix, err := splitBucketURL(location, "gs://")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &gcsStorage{bucket: bucket, prefix: prefix, endpoint: "https://storage.googleapis.com", http: http.DefaultClient}
+
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		s.endpoint = strings.TrimSuffix(host, "/")
+		return s, nil
+	}
+
+	s.tokens, err = newGCSTokenSource()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// do authenticates and sends a request, turning error responses into errors
+func (s *gcsStorage) do(req *http.Request) (*http.Response, error) {
+	if s.tokens != nil {
+		token, err := s.tokens.token(req.Context())
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// objectURL returns the URL of an object's metadata, or its data with
+// ?alt=media
+func (s *gcsStorage) objectURL(name string) string {
+	return s.endpoint + "/storage/v1/b/" + url.PathEscape(s.bucket) + "/o/" + url.PathEscape(joinKey(s.prefix, name))
+}
+
+func (s *gcsStorage) List(ctx context.Context) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	token := ""
+
+	for {
+		q := url.Values{"prefix": {listPrefix(s.prefix)}, "delimiter": {"/"}, "fields": {"items(name,size,etag),nextPageToken"}}
+		if token != "" {
+			q.Set("pageToken", token)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+			s.endpoint+"/storage/v1/b/"+url.PathEscape(s.bucket)+"/o?"+q.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Items []struct {
+				Name string `json:"name"`
+				Size int64  `json:"size,string"`
+				ETag string `json:"etag"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse bucket listing: %w", err)
+		}
+
+		for _, obj := range result.Items {
+			objects = append(objects, ObjectInfo{Name: path.Base(obj.Name), Size: obj.Size, ETag: obj.ETag})
+		}
+		if result.NextPageToken == "" {
+			return objects, nil
+		}
+		token = result.NextPageToken
+	}
+}
+
+func (s *gcsStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(name)+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *gcsStorage) OpenRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(name)+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", byteRange(offset, length))
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return rangeBody(resp, offset, length)
+}
+
+func (s *gcsStorage) Put(ctx context.Context, name string, r io.Reader, size int64, contentType string) error {
+	q := url.Values{"uploadType": {"media"}, "name": {joinKey(s.prefix, name)}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		s.endpoint+"/upload/storage/v1/b/"+url.PathEscape(s.bucket)+"/o?"+q.Encode(), r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// gcsServiceAccount holds the fields of a service account key file that are
+// needed to get tokens
+type gcsServiceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// gcsTokenSource hands out OAuth access tokens, either a fixed one or ones
+// exchanged for a signed service account assertion and cached until shortly
+// before they expire
+type gcsTokenSource struct {
+	account *gcsServiceAccount
+	key     *rsa.PrivateKey
+	http    *http.Client
+
+	mu      sync.Mutex
+	current string
+	expiry  time.Time // zero for a fixed token
+}
+
+// newGCSTokenSource configures tokens from the environment
+func newGCSTokenSource() (*gcsTokenSource, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return &gcsTokenSource{current: token}, nil
+	}
+
+	keyFile := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if keyFile == "" {
+		return nil, fmt.Errorf("GCS needs GOOGLE_OAUTH_ACCESS_TOKEN or GOOGLE_APPLICATION_CREDENTIALS")
+	}
+
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account key: %w", err)
+	}
+	var account gcsServiceAccount
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, fmt.Errorf("failed to parse service account key: %w", err)
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("service account key has no PEM private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse service account private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("service account private key is not RSA")
+	}
+
+	return &gcsTokenSource{account: &account, key: key, http: http.DefaultClient}, nil
+}
+
+// token returns a valid access token
+func (t *gcsTokenSource) token(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.account == nil || time.Until(t.expiry) > time.Minute {
+		return t.current, nil
+	}
+
+	assertion, err := t.assertion(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {assertion}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.account.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := t.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get access token: %w", err)
+	}
+	if err := checkResponse(resp); err != nil {
+		return "", fmt.Errorf("failed to get access token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to parse access token: %w", err)
+	}
+
+	t.current = result.AccessToken
+	t.expiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	return t.current, nil
+}
+
+// assertion returns a JWT signed with the service account key (RS256)
+func (t *gcsTokenSource) assertion(now time.Time) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]any{
+		"iss":   t.account.ClientEmail,
+		"scope": gcsScope,
+		"aud":   t.account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, t.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token request: %w", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
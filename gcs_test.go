@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGCS is a bucket of the GCS JSON API that lists pageSize objects at a
+// time, and the token endpoint that hands out the access tokens it accepts
+type fakeGCS struct {
+	t        *testing.T
+	bucket   string
+	pageSize int
+	key      *rsa.PublicKey
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+	tokens  int // access tokens handed out
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/token" {
+		f.token(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != fmt.Sprintf("Bearer token-%d", f.tokens) {
+		http.Error(w, "invalid or expired token", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/storage/v1/b/"+f.bucket+"/o":
+		f.list(w, r)
+	case r.Method == http.MethodGet && r.URL.Query().Get("alt") == "media":
+		data, ok := f.objects[strings.TrimPrefix(r.URL.Path, "/storage/v1/b/"+f.bucket+"/o/")]
+		if !ok {
+			http.Error(w, "No such object", http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/"+f.bucket+"/o" && r.URL.Query().Get("uploadType") == "media":
+		name := r.URL.Query().Get("name")
+		data, _ := io.ReadAll(r.Body)
+		f.objects[name] = data
+		f.types[name] = r.Header.Get("Content-Type")
+		json.NewEncoder(w).Encode(map[string]string{"name": name})
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// token checks a JWT bearer assertion and answers with the next token
+func (f *fakeGCS) token(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+		http.Error(w, "unsupported grant type", http.StatusBadRequest)
+		return
+	}
+	parts := strings.Split(r.FormValue("assertion"), ".")
+	if len(parts) != 3 {
+		http.Error(w, "malformed assertion", http.StatusBadRequest)
+		return
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	if err := rsa.VerifyPKCS1v15(f.key, crypto.SHA256, digest[:], signature); err != nil {
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+
+	var claims struct {
+		Iss, Scope, Aud string
+		Iat, Exp        int64
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Iss != "waveform@project.iam.gserviceaccount.com" ||
+		claims.Scope != gcsScope || claims.Aud != "http://"+r.Host+"/token" || claims.Exp-claims.Iat != 3600 {
+		f.t.Errorf("claims %s", payload)
+	}
+
+	f.tokens++
+	json.NewEncoder(w).Encode(map[string]any{"access_token": fmt.Sprintf("token-%d", f.tokens), "expires_in": 3600, "token_type": "Bearer"})
+}
+
+// list answers objects.list, the page token being the index of the first
+// object of the page
+func (f *fakeGCS) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var names []string
+	for name := range f.objects {
+		rest, ok := strings.CutPrefix(name, q.Get("prefix"))
+		if ok && !(q.Get("delimiter") == "/" && strings.Contains(rest, "/")) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	start, _ := strconv.Atoi(q.Get("pageToken"))
+	end := min(start+f.pageSize, len(names))
+	type item struct {
+		Name string `json:"name"`
+		Size string `json:"size"`
+		ETag string `json:"etag"`
+	}
+	result := struct {
+		Items         []item `json:"items,omitempty"`
+		NextPageToken string `json:"nextPageToken,omitempty"`
+	}{}
+	for _, name := range names[start:end] {
+		result.Items = append(result.Items, item{name, strconv.Itoa(len(f.objects[name])), "etag-" + name})
+	}
+	if end < len(names) {
+		result.NextPageToken = strconv.Itoa(end)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+func TestGCSStorage(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeGCS{t: t, bucket: "media", pageSize: 2, key: &key.PublicKey, types: map[string]string{}, objects: map[string][]byte{
+		"rec/a.wav":        []byte("aaaa"),
+		"rec/b.wav":        []byte("bb"),
+		"rec/c.wav":        []byte("c"),
+		"rec/deeper/d.wav": []byte("d"),
+		"other/e.wav":      []byte("e"),
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	tokens := &gcsTokenSource{
+		account: &gcsServiceAccount{ClientEmail: "waveform@project.iam.gserviceaccount.com", TokenURI: server.URL + "/token"},
+		key:     key,
+		http:    server.Client(),
+	}
+	s := &gcsStorage{bucket: "media", prefix: "rec", endpoint: server.URL, tokens: tokens, http: server.Client()}
+	ctx := context.Background()
+
+	// Three objects directly under the prefix take two pages
+	objects, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, obj := range objects {
+		names = append(names, obj.Name)
+	}
+	if got := strings.Join(names, " "); got != "a.wav b.wav c.wav" {
+		t.Errorf("listed %s", got)
+	}
+	if objects[0].Size != 4 || objects[0].ETag != "etag-rec/a.wav" {
+		t.Errorf("a.wav listed as %+v", objects[0])
+	}
+
+	body, err := s.Open(ctx, "b.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if string(data) != "bb" {
+		t.Errorf("b.wav = %q", data)
+	}
+	if _, err := s.Open(ctx, "missing.wav"); err == nil {
+		t.Error("opened a missing object")
+	}
+
+	if err := s.Put(ctx, "out/a.png", strings.NewReader("png"), 3, "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	if string(fake.objects["rec/out/a.png"]) != "png" || fake.types["rec/out/a.png"] != "image/png" {
+		t.Errorf("upload stored %q as %q", fake.objects["rec/out/a.png"], fake.types["rec/out/a.png"])
+	}
+
+	// One token served every request so far; a token about to expire is
+	// replaced before the next one
+	if fake.tokens != 1 {
+		t.Errorf("%d tokens fetched, want 1", fake.tokens)
+	}
+	tokens.mu.Lock()
+	tokens.expiry = time.Now().Add(30 * time.Second)
+	tokens.mu.Unlock()
+	if _, err := s.List(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if fake.tokens != 2 {
+		t.Errorf("%d tokens fetched after expiry, want 2", fake.tokens)
+	}
+
+	// A fixed token is sent as it is
+	s.tokens = &gcsTokenSource{current: "token-2"}
+	if _, err := s.List(ctx); err != nil {
+		t.Error(err)
+	}
+	s.tokens = &gcsTokenSource{current: "token-1"}
+	if _, err := s.List(ctx); err == nil {
+		t.Error("listed with a stale token")
+	}
+}
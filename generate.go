@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runGenerate implements the generate subcommand, which processes a single
+// file like a batch of one. With -o - the image is written to stdout for
+// shell pipelines and CGI-style wrappers.
+func runGenerate(args []string) error {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	output := fs.String("o", "", "image to write, ending in .png, or - for stdout (default the input's name ending in .png, next to it)")
+	buildOptions := optionFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: only_waveform generate [flags] file.wav\n")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	// Flags may follow the file too, as in generate clip.wav -o -
+	files := fs.Args()
+	if len(files) > 1 {
+		if err := fs.Parse(files[1:]); err != nil {
+			return err
+		}
+		files = append(files[:1:1], fs.Args()...)
+	}
+	if len(files) != 1 {
+		fs.Usage()
+		return fmt.Errorf("generate needs exactly one WAV file")
+	}
+	file := files[0]
+
+	opts, err := buildOptions()
+	if err != nil {
+		return err
+	}
+
+	input := batchInput{Name: filepath.Base(file), Path: file, Location: file}
+	if *output == "-" {
+		return generateToStdout(input, opts)
+	}
+
+	outputDir := filepath.Dir(file)
+	if *output != "" {
+		if !strings.EqualFold(filepath.Ext(*output), ".png") {
+			return fmt.Errorf("-o must end in .png, or be - for stdout")
+		}
+		outputDir = filepath.Dir(*output)
+		input.OutputName = strings.TrimSuffix(filepath.Base(*output), filepath.Ext(*output))
+	}
+	_, err = GenerateStereoWaveforms(input, outputDir, opts)
+	return err
+}
+
+// generateToStdout processes input in a scratch directory and writes the
+// image to stdout. Everything printed meanwhile goes to stderr, so stdout
+// carries only the PNG.
+func generateToStdout(input batchInput, opts Options) error {
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	defer func() { os.Stdout = stdout }()
+
+	dir, err := os.MkdirTemp("", "only_waveform-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	result, err := GenerateStereoWaveforms(input, dir, opts)
+	if len(result.Outputs) == 0 {
+		return err
+	}
+	// The image is always the first output; the rest have nowhere to go
+	data, readErr := os.ReadFile(result.Outputs[0])
+	if readErr != nil {
+		return readErr
+	}
+	if _, writeErr := stdout.Write(data); writeErr != nil {
+		return fmt.Errorf("failed to write to stdout: %w", writeErr)
+	}
+	if len(result.Outputs) > 1 {
+		fmt.Printf("Warning: -o - writes only the image; %d other outputs were dropped\n", len(result.Outputs)-1)
+	}
+	return err
+}
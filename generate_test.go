@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunGenerate(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "clip.wav")
+	audio := DefaultTestAudio()
+	audio.Duration = 50 * time.Millisecond
+	if err := WriteTestAudioFile(input, audio); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flags may come after the file
+	output := filepath.Join(dir, "out", "wave.png")
+	os.Mkdir(filepath.Dir(output), 0o755)
+	if err := runGenerate([]string{"-height", "20", input, "-width", "40", "-o", output}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Error(err)
+	}
+	if err := runGenerate([]string{"-o", "wave.jpg", input}); err == nil {
+		t.Error("-o wave.jpg accepted")
+	}
+
+	// With -o - stdout carries the PNG and nothing else
+	capture, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = capture
+	err = runGenerate([]string{"-width", "40", "-height", "20", input, "-o", "-"})
+	os.Stdout = stdout
+	capture.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(capture.Name())
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("stdout is not a PNG: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 40 || b.Dy() != 20 {
+		t.Errorf("image is %v", b)
+	}
+}
+
+func TestGenerateSamplesPerPixel(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "hit.wav")
+	audio := DefaultTestAudio()
+	audio.Duration = 50 * time.Millisecond // 2205 frames
+	if err := WriteTestAudioFile(input, audio); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fixed bucket size sets the width, as long as -max-width allows
+	for _, tc := range []struct {
+		maxWidth, width int
+		spp             float64
+	}{
+		{0, 23, 2205.0 / 23},
+		{10, 10, 220.5},
+	} {
+		output := t.TempDir()
+		opts := Options{Width: 1920, Height: 20, SamplesPerPixel: 100, MaxWidth: tc.maxWidth, Oversize: OversizeDownscale}
+		result, err := GenerateStereoWaveforms(batchInput{Name: "hit.wav", Path: input, Location: input}, output, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.SamplesPerPixel != tc.spp {
+			t.Errorf("max width %d: samples per pixel = %g, want %g", tc.maxWidth, result.SamplesPerPixel, tc.spp)
+		}
+		file, err := os.Open(filepath.Join(output, "hit.png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := png.DecodeConfig(file)
+		file.Close()
+		if err != nil || config.Width != tc.width {
+			t.Errorf("max width %d: image is %d wide, want %d (%v)", tc.maxWidth, config.Width, tc.width, err)
+		}
+	}
+
+	// Fitted to the width, each pixel covers the frames over the columns
+	result, err := GenerateStereoWaveforms(batchInput{Name: "hit.wav", Path: input, Location: input}, t.TempDir(), Options{Width: 441, Height: 20})
+	if err != nil || result.SamplesPerPixel != 5 {
+		t.Errorf("fitted: samples per pixel = %g, %v", result.SamplesPerPixel, err)
+	}
+}
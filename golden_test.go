@@ -0,0 +1,239 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	updateGolden    = flag.Bool("update", false, "rewrite golden files from the current renderer")
+	goldenTolerance = flag.Uint("tolerance", 0, "largest per-channel color difference treated as equal")
+	goldenMaxPixels = flag.Int("max-pixels", 0, "number of differing pixels allowed per golden image")
+)
+
+// goldenDir holds the golden PNG and peak files
+var goldenDir = filepath.Join("testdata", "golden")
+
+// goldenFixture is a synthetic input rendered with fixed options
+type goldenFixture struct {
+	Name   string
+	Audio  TestAudio
+	Width  int
+	Height int
+}
+
+// goldenFixtures returns the fixtures checked by TestGolden
+func goldenFixtures() []goldenFixture {
+	sine := DefaultTestAudio()
+	sine.Frequency = 2
+
+	square := DefaultTestAudio()
+	square.Waveform = "square"
+	square.Frequency = 3
+	square.Amplitude = 0.5
+
+	noise := DefaultTestAudio()
+	noise.Waveform = "noise"
+	noise.Duration = 500 * time.Millisecond
+
+	sweep := DefaultTestAudio()
+	sweep.Waveform = "sweep"
+	sweep.Frequency = 1
+	sweep.EndFreq = 40
+	sweep.Duration = 2 * time.Second
+
+	short := DefaultTestAudio()
+	short.Duration = 5 * time.Millisecond
+
+	return []goldenFixture{
+		{Name: "sine", Audio: sine, Width: 400, Height: 100},
+		{Name: "square", Audio: square, Width: 400, Height: 100},
+		{Name: "noise", Audio: noise, Width: 200, Height: 80},
+		{Name: "sweep", Audio: sweep, Width: 800, Height: 120},
+		{Name: "short", Audio: short, Width: 400, Height: 100},
+	}
+}
+
+// comparePeaks reports the first bucket where two peak sets differ
+func comparePeaks(want, got *Peaks) error {
+	if want.SampleRate != got.SampleRate || want.SamplesPerPixel != got.SamplesPerPixel {
+		return fmt.Errorf("header mismatch: want %d Hz/%d spp, got %d Hz/%d spp",
+			want.SampleRate, want.SamplesPerPixel, got.SampleRate, got.SamplesPerPixel)
+	}
+
+	if len(want.Channels) != len(got.Channels) || want.Len() != got.Len() {
+		return fmt.Errorf("shape mismatch: want %dx%d, got %dx%d",
+			len(want.Channels), want.Len(), len(got.Channels), got.Len())
+	}
+
+	for c := range want.Channels {
+		for i := range want.Channels[c].Min {
+			if want.Channels[c].Min[i] != got.Channels[c].Min[i] || want.Channels[c].Max[i] != got.Channels[c].Max[i] {
+				return fmt.Errorf("channel %d bucket %d: want [%d, %d], got [%d, %d]", c, i,
+					want.Channels[c].Min[i], want.Channels[c].Max[i], got.Channels[c].Min[i], got.Channels[c].Max[i])
+			}
+		}
+	}
+
+	return nil
+}
+
+// renderFixture generates, decodes and renders a fixture inside workDir
+func renderFixture(f goldenFixture, workDir string) (*Peaks, *image.RGBA, error) {
+	wavFile := filepath.Join(workDir, f.Name+".wav")
+	if err := WriteTestAudioFile(wavFile, f.Audio); err != nil {
+		return nil, nil, err
+	}
+
+	peaks, _, err := decodePeaks(wavFile, Options{Width: f.Width}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ro := DefaultRenderOptions()
+	ro.Width, ro.Height = f.Width, f.Height
+
+	img, err := drawPeaks(peaks.Channels[0], ro, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return peaks, img, nil
+}
+
+// checkFixture compares a fixture's render against its golden files, writing
+// a diff image into diffDir on mismatch
+func checkFixture(f goldenFixture, workDir, diffDir string, tol ImageTolerance) error {
+	peaks, img, err := renderFixture(f, workDir)
+	if err != nil {
+		return err
+	}
+
+	wantPeaks, err := ReadPeaksFile(filepath.Join(goldenDir, f.Name+".peaks"))
+	if err != nil {
+		return err
+	}
+	if err := comparePeaks(wantPeaks, peaks); err != nil {
+		return fmt.Errorf("peaks differ: %w", err)
+	}
+
+	file, err := os.Open(filepath.Join(goldenDir, f.Name+".png"))
+	if err != nil {
+		return fmt.Errorf("failed to open golden image: %w", err)
+	}
+	defer file.Close()
+
+	wantImg, err := png.Decode(file)
+	if err != nil {
+		return fmt.Errorf("failed to decode golden image: %w", err)
+	}
+
+	differing, diff, err := diffImages(wantImg, img, tol)
+	if err != nil {
+		return err
+	}
+
+	if differing > tol.MaxPixels {
+		diffFile := filepath.Join(diffDir, f.Name+".diff.png")
+		if err := savePNG(diff, diffFile, png.DefaultCompression); err != nil {
+			return err
+		}
+		return fmt.Errorf("%d pixels differ (allowed %d), see %s", differing, tol.MaxPixels, diffFile)
+	}
+
+	return nil
+}
+
+// updateFixture rewrites a fixture's golden files from the current renderer
+func updateFixture(f goldenFixture, workDir string) error {
+	peaks, img, err := renderFixture(f, workDir)
+	if err != nil {
+		return err
+	}
+
+	if err := WritePeaksFile(filepath.Join(goldenDir, f.Name+".peaks"), peaks); err != nil {
+		return err
+	}
+
+	return savePNG(img, filepath.Join(goldenDir, f.Name+".png"), png.DefaultCompression)
+}
+
+// TestGolden compares renders of the fixtures against testdata/golden; run
+// with -update to rewrite the golden files after an intended change
+func TestGolden(t *testing.T) {
+	tol := ImageTolerance{ChannelDelta: uint8(min(*goldenTolerance, 255)), MaxPixels: *goldenMaxPixels}
+
+	if *updateGolden {
+		if err := os.MkdirAll(goldenDir, 0755); err != nil {
+			t.Fatalf("failed to create golden directory: %v", err)
+		}
+	}
+
+	// Diff images outlive the test so they can be inspected
+	var diffDir string
+
+	for _, f := range goldenFixtures() {
+		t.Run(f.Name, func(t *testing.T) {
+			if *updateGolden {
+				if err := updateFixture(f, t.TempDir()); err != nil {
+					t.Fatal(err)
+				}
+				t.Logf("updated %s", f.Name)
+				return
+			}
+
+			if diffDir == "" {
+				var err error
+				if diffDir, err = os.MkdirTemp("", "only_waveform_golden"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := checkFixture(f, t.TempDir(), diffDir, tol); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	if diffDir != "" && !t.Failed() {
+		os.RemoveAll(diffDir)
+	}
+}
+
+func TestDiffImages(t *testing.T) {
+	base := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	changed := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	changed.Pix[0] = 10   // within a tolerance of 10
+	changed.Pix[4*5] = 50 // beyond it
+
+	tests := []struct {
+		name    string
+		tol     ImageTolerance
+		differs int
+	}{
+		{"exact", ImageTolerance{}, 2},
+		{"tolerant", ImageTolerance{ChannelDelta: 10}, 1},
+		{"lenient", ImageTolerance{ChannelDelta: 50}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, _, err := diffImages(base, changed, tt.tol)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.differs {
+				t.Errorf("%d pixels differ, want %d", n, tt.differs)
+			}
+		})
+	}
+
+	if _, _, err := diffImages(base, image.NewRGBA(image.Rect(0, 0, 3, 4)), ImageTolerance{}); err == nil {
+		t.Error("size mismatch: expected an error")
+	}
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// grpcService is the path prefix of the methods in waveform.proto
+const grpcService = "/onlywaveform.v1.Waveform/"
+
+// grpcMaxMessage bounds one message of a call, as gRPC servers do by
+// default; uploads are sent as several messages
+const grpcMaxMessage = 4 << 20
+
+// gRPC status codes
+const (
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcNotFound          = 5
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+)
+
+// grpcError is a failed call, reported in the Grpc-Status and Grpc-Message
+// trailers
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e *grpcError) Error() string {
+	return e.message
+}
+
+func grpcErrorf(code int, format string, args ...any) error {
+	return &grpcError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// grpcStatus maps the HTTP status of a failed render onto a gRPC code
+func grpcStatus(status int) int {
+	if status == http.StatusUnprocessableEntity {
+		return grpcInvalidArgument
+	}
+	return grpcInternal
+}
+
+// handleGRPC serves the methods of waveform.proto. Every method takes a
+// stream of AudioRequest messages and answers with one message.
+func (s *Server) handleGRPC(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var call func(*grpcCall) ([]byte, error)
+	switch r.PathValue("method") {
+	case "GenerateWaveform":
+		call = s.grpcGenerateWaveform
+	case "GetPeaks":
+		call = s.grpcGetPeaks
+	case "AnalyzeAudio":
+		call = s.grpcAnalyzeAudio
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	var resp []byte
+	var err error
+	if call == nil {
+		err = grpcErrorf(grpcUnimplemented, "unknown method %s", r.PathValue("method"))
+	} else {
+		resp, err = s.runGRPCCall(r.Context(), r.Body, call)
+	}
+
+	if err == nil {
+		frame := make([]byte, 5, 5+len(resp))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(resp)))
+		_, err = w.Write(append(frame, resp...))
+	}
+
+	code, message := grpcOK, ""
+	if err != nil {
+		var gerr *grpcError
+		if !errors.As(err, &gerr) {
+			gerr = &grpcError{code: grpcInternal, message: err.Error()}
+		}
+		code, message = gerr.code, gerr.message
+		if tenant := tenantOf(r.Context()); tenant != "" {
+			tenantErrors.inc(tenant)
+		}
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", grpcPercentEncode(message))
+	}
+}
+
+// runGRPCCall reads the request stream and runs a method on it
+func (s *Server) runGRPCCall(ctx context.Context, body io.Reader, method func(*grpcCall) ([]byte, error)) ([]byte, error) {
+	c := &grpcCall{query: url.Values{}, maxUpload: s.Limits.upload()}
+	defer c.close()
+
+	for {
+		msg, err := readGRPCMessage(body)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := c.add(msg); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.upload != nil {
+		if err := c.upload.Close(); err != nil {
+			return nil, fmt.Errorf("failed to write upload: %w", err)
+		}
+		if err := s.Limits.checkDuration(c.inputFile()); err != nil {
+			return nil, grpcFailure(grpcInvalidArgument, err)
+		}
+		return method(c)
+	}
+
+	if c.file == "" {
+		return nil, grpcErrorf(grpcInvalidArgument, "no file or data sent")
+	}
+	inputFile, err := s.resolve(ctx, c.file)
+	if err == nil {
+		_, err = os.Stat(inputFile)
+	}
+	if err != nil {
+		return nil, grpcErrorf(grpcNotFound, "%s not found", c.file)
+	}
+	c.file = inputFile
+	if err := s.Limits.checkDuration(inputFile); err != nil {
+		return nil, grpcFailure(grpcInvalidArgument, err)
+	}
+	return method(c)
+}
+
+// readGRPCMessage reads one length-prefixed message. It returns io.EOF at
+// the end of the stream.
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, grpcErrorf(grpcInternal, "failed to read message: %v", err)
+	}
+	if prefix[0] != 0 {
+		return nil, grpcErrorf(grpcUnimplemented, "compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > grpcMaxMessage {
+		return nil, grpcErrorf(grpcResourceExhausted, "message of %d bytes is larger than %d", size, grpcMaxMessage)
+	}
+
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, grpcErrorf(grpcInternal, "failed to read message: %v", err)
+	}
+	return msg, nil
+}
+
+// grpcPercentEncode encodes a Grpc-Message value, which must be printable
+// ASCII
+func grpcPercentEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// grpcCall collects the AudioRequest messages of a call: the file they name
+// or upload and their options, as the query parameters of the HTTP
+// endpoints so they are checked the same way
+type grpcCall struct {
+	file      string
+	upload    *os.File
+	uploaded  int64
+	maxUpload int64
+	query     url.Values
+	analyses  []string
+}
+
+// add applies one AudioRequest message
+func (c *grpcCall) add(msg []byte) error {
+	err := decodeProto(msg, func(f protoField) error {
+		switch f.num {
+		case 1:
+			c.file = string(f.bytes)
+		case 2:
+			return c.write(f.bytes)
+		case 3:
+			c.setInt("width", f)
+		case 4:
+			c.setInt("height", f)
+		case 5:
+			c.query.Set("fg", string(f.bytes))
+		case 6:
+			c.query.Set("bg", string(f.bytes))
+		case 7:
+			c.query.Set("style", string(f.bytes))
+		case 8:
+			c.query.Set("normalize", strconv.FormatBool(f.varint != 0))
+		case 9:
+			c.setInt("samples_per_pixel", f)
+		case 10:
+			c.setInt("bits", f)
+		case 11:
+			c.analyses = append(c.analyses, string(f.bytes))
+		case 12:
+			c.query.Set("extended", strconv.FormatBool(f.varint != 0))
+		case 13:
+			c.query.Set("colormap", string(f.bytes))
+		case 14:
+			c.query.Set("auto_range", strconv.FormatBool(f.varint != 0))
+		case 15:
+			if db := math.Float64frombits(f.varint); db != 0 {
+				c.query.Set("headroom", strconv.FormatFloat(db, 'g', -1, 64))
+			}
+		case 16:
+			c.query.Set("scale", string(f.bytes))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.file != "" && c.upload != nil {
+		return grpcErrorf(grpcInvalidArgument, "set either file or data, not both")
+	}
+	return nil
+}
+
+// setInt sets an int32 option; zero is its unset value
+func (c *grpcCall) setInt(name string, f protoField) {
+	if v := int32(f.varint); v != 0 {
+		c.query.Set(name, strconv.Itoa(int(v)))
+	}
+}
+
+// write appends uploaded data to a temporary file, as POST /peaks spools
+// its body
+func (c *grpcCall) write(data []byte) error {
+	if c.uploaded+int64(len(data)) > c.maxUpload {
+		return grpcFailure(grpcResourceExhausted, uploadLimitError(c.maxUpload, -1))
+	}
+	if c.upload == nil {
+		file, err := os.CreateTemp("", "only_waveform_upload_*.wav")
+		if err != nil {
+			return fmt.Errorf("failed to create upload file: %w", err)
+		}
+		c.upload = file
+	}
+	if _, err := c.upload.Write(data); err != nil {
+		return fmt.Errorf("failed to write upload: %w", err)
+	}
+	c.uploaded += int64(len(data))
+	return nil
+}
+
+// inputFile is the file the call decodes
+func (c *grpcCall) inputFile() string {
+	if c.upload != nil {
+		return c.upload.Name()
+	}
+	return c.file
+}
+
+// close removes an uploaded file
+func (c *grpcCall) close() {
+	if c.upload != nil {
+		c.upload.Close()
+		os.Remove(c.upload.Name())
+	}
+}
+
+func (s *Server) grpcGenerateWaveform(c *grpcCall) ([]byte, error) {
+	ro, err := parseRenderQuery(c.query, s.Defaults)
+	if err == nil {
+		err = s.Limits.checkSize(ro)
+	}
+	if err != nil {
+		return nil, grpcFailure(grpcInvalidArgument, err)
+	}
+
+	data, status, err := s.renderWaveform(c.inputFile(), ro)
+	if err != nil {
+		return nil, grpcFailure(grpcStatus(status), err)
+	}
+
+	var e protoEncoder
+	e.bytes(1, data)
+	return e.buf, nil
+}
+
+func (s *Server) grpcGetPeaks(c *grpcCall) ([]byte, error) {
+	opts, format, err := parsePeaksQuery(c.query, s.Defaults.Width)
+	if err != nil {
+		return nil, grpcFailure(grpcInvalidArgument, err)
+	}
+
+	// Each bucket is two values of up to 3 bytes in the packed data, and
+	// its RMS and two flags when extended
+	bytesPerBucket := int64(6)
+	if format.extended {
+		bytesPerBucket += 5
+	}
+	release, err := s.acquire(c.inputFile(), opts, bytesPerBucket)
+	if err != nil {
+		return nil, grpcFailure(grpcInvalidArgument, err)
+	}
+	defer release()
+
+	analyzers := format.analyzers(opts)
+	peaks, _, err := decodePeaks(c.inputFile(), opts, analyzers)
+	if err != nil {
+		errorsTotal.inc("decode")
+		return nil, grpcErrorf(grpcInvalidArgument, "failed to decode: %v", err)
+	}
+	defer releasePeaks(peaks)
+
+	p := format.export(peaks, analyzers)
+	var e protoEncoder
+	e.varint(1, uint64(p.Version))
+	e.varint(2, uint64(p.Channels))
+	e.varint(3, uint64(p.SampleRate))
+	e.varint(4, uint64(p.SamplesPerPixel))
+	e.varint(5, uint64(p.Bits))
+	e.varint(6, uint64(p.Length))
+	e.packedSint32(7, p.Data)
+	e.packedSint32(8, p.RMS)
+	e.packedBool(9, p.Clipped)
+	e.packedBool(10, p.Silent)
+	return e.buf, nil
+}
+
+func (s *Server) grpcAnalyzeAudio(c *grpcCall) ([]byte, error) {
+	names, err := parseAnalyses(strings.Join(c.analyses, ","))
+	if len(c.analyses) == 0 {
+		names, err = parseAnalyses("all")
+	}
+	if err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+
+	// Only the analyses are returned, so the peaks are a single bucket
+	opts := Options{Width: 1, Analyses: names, Analysis: DefaultAnalysisConfig()}
+	consumers := newFileConsumers(opts)
+	release, err := s.acquire(c.inputFile(), opts, 0)
+	if err != nil {
+		return nil, grpcFailure(grpcInvalidArgument, err)
+	}
+	defer release()
+
+	peaks, frames, err := decodePeaks(c.inputFile(), opts, consumers.all())
+	if err != nil {
+		errorsTotal.inc("decode")
+		return nil, grpcErrorf(grpcInvalidArgument, "failed to decode: %v", err)
+	}
+	defer releasePeaks(peaks)
+
+	analysis, err := json.Marshal(analysisResults(consumers.report))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode analysis: %w", err)
+	}
+
+	var e protoEncoder
+	e.varint(1, uint64(peaks.SampleRate))
+	e.varint(2, uint64(frames))
+	e.double(3, framesToSeconds(frames, peaks.SampleRate))
+	e.bytes(4, analysis)
+	return e.buf, nil
+}
+
+// protoField is one field of an encoded protobuf message. Varint and
+// fixed-size values are in varint, length-delimited ones in bytes.
+type protoField struct {
+	num    int
+	varint uint64
+	bytes  []byte
+}
+
+// decodeProto calls fn for each field of a message in order, so repeated
+// fields are seen once per value
+func decodeProto(msg []byte, fn func(protoField) error) error {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return grpcErrorf(grpcInvalidArgument, "malformed message")
+		}
+		msg = msg[n:]
+
+		f := protoField{num: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			f.varint, n = binary.Uvarint(msg)
+			if n <= 0 {
+				return grpcErrorf(grpcInvalidArgument, "malformed message")
+			}
+			msg = msg[n:]
+		case 1:
+			if len(msg) < 8 {
+				return grpcErrorf(grpcInvalidArgument, "malformed message")
+			}
+			f.varint, msg = binary.LittleEndian.Uint64(msg), msg[8:]
+		case 2:
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || size > uint64(len(msg)-n) {
+				return grpcErrorf(grpcInvalidArgument, "malformed message")
+			}
+			f.bytes, msg = msg[n:n+int(size)], msg[n+int(size):]
+		case 5:
+			if len(msg) < 4 {
+				return grpcErrorf(grpcInvalidArgument, "malformed message")
+			}
+			f.varint, msg = uint64(binary.LittleEndian.Uint32(msg)), msg[4:]
+		default:
+			return grpcErrorf(grpcInvalidArgument, "unsupported wire type %d", key&7)
+		}
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// protoEncoder appends protobuf fields to buf. Fields holding their zero
+// value are left out, as proto3 does.
+type protoEncoder struct {
+	buf []byte
+}
+
+func (e *protoEncoder) key(num, wireType int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(num)<<3|uint64(wireType))
+}
+
+func (e *protoEncoder) varint(num int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.key(num, 0)
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *protoEncoder) double(num int, v float64) {
+	if v == 0 {
+		return
+	}
+	e.key(num, 1)
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(v))
+}
+
+func (e *protoEncoder) bytes(num int, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	e.key(num, 2)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *protoEncoder) string(num int, s string) {
+	e.bytes(num, []byte(s))
+}
+
+// packedBool appends a packed repeated bool field
+func (e *protoEncoder) packedBool(num int, vs []bool) {
+	packed := make([]byte, len(vs))
+	for i, v := range vs {
+		if v {
+			packed[i] = 1
+		}
+	}
+	e.bytes(num, packed)
+}
+
+// packedSint32 appends a packed repeated sint32 field
+func (e *protoEncoder) packedSint32(num int, vs []int) {
+	var packed []byte
+	for _, v := range vs {
+		packed = binary.AppendUvarint(packed, uint64(uint32(int32(v)<<1^int32(v)>>31)))
+	}
+	e.bytes(num, packed)
+}
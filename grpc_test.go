@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"image/png"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// newGRPCTestServer serves s over HTTP/2 without TLS and returns a client
+// speaking it
+func newGRPCTestServer(t *testing.T, s *Server) (*httptest.Server, *http.Client) {
+	t.Helper()
+
+	ts := httptest.NewUnstartedServer(s.Handler(false))
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	t.Cleanup(ts.Close)
+
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	return ts, &http.Client{Transport: transport}
+}
+
+// grpcInvoke calls a method with a stream of encoded requests and returns
+// the response message and status
+func grpcInvoke(t *testing.T, ts *httptest.Server, client *http.Client, method string, requests ...[]byte) ([]byte, string, string) {
+	t.Helper()
+
+	var body bytes.Buffer
+	for _, msg := range requests {
+		var prefix [5]byte
+		binary.BigEndian.PutUint32(prefix[1:], uint32(len(msg)))
+		body.Write(prefix[:])
+		body.Write(msg)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+grpcService+method, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("response over %s, want HTTP/2", resp.Proto)
+	}
+
+	// Trailers are only complete once the body has been read
+	var msg []byte
+	if data, err := io.ReadAll(resp.Body); err != nil {
+		t.Fatal(err)
+	} else if len(data) > 0 {
+		msg, err = readGRPCMessage(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("readGRPCMessage: %v", err)
+		}
+	}
+	return msg, resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+}
+
+// protoMessage decodes a response into its fields by number
+func protoMessage(t *testing.T, msg []byte) map[int]protoField {
+	t.Helper()
+
+	fields := map[int]protoField{}
+	if err := decodeProto(msg, func(f protoField) error {
+		fields[f.num] = f
+		return nil
+	}); err != nil {
+		t.Fatalf("decodeProto: %v", err)
+	}
+	return fields
+}
+
+func TestGRPCService(t *testing.T) {
+	input := writeFixture(t, DefaultTestAudio())
+	data, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{Root: filepath.Dir(input), Defaults: DefaultRenderOptions()}
+	ts, client := newGRPCTestServer(t, s)
+
+	fileRequest := func(fields func(e *protoEncoder)) []byte {
+		var e protoEncoder
+		e.string(1, "fixture.wav")
+		if fields != nil {
+			fields(&e)
+		}
+		return e.buf
+	}
+
+	t.Run("GenerateWaveform", func(t *testing.T) {
+		msg, status, message := grpcInvoke(t, ts, client, "GenerateWaveform", fileRequest(func(e *protoEncoder) {
+			e.varint(3, 300)
+			e.varint(4, 80)
+			e.string(7, "bars")
+		}))
+		if status != "0" {
+			t.Fatalf("status %s: %s", status, message)
+		}
+		img, err := png.Decode(bytes.NewReader(protoMessage(t, msg)[1].bytes))
+		if err != nil {
+			t.Fatalf("png.Decode: %v", err)
+		}
+		if b := img.Bounds(); b.Dx() != 300 || b.Dy() != 80 {
+			t.Errorf("image is %v, want 300x80", b)
+		}
+	})
+
+	t.Run("GetPeaks from chunked upload", func(t *testing.T) {
+		var requests [][]byte
+		for chunk := range slices.Chunk(data, 1000) {
+			var e protoEncoder
+			e.bytes(2, chunk)
+			requests = append(requests, e.buf)
+		}
+		var e protoEncoder
+		e.varint(3, 50)
+		e.varint(10, 8)
+		e.varint(12, 1)
+		requests = append(requests, e.buf)
+
+		msg, status, message := grpcInvoke(t, ts, client, "GetPeaks", requests...)
+		if status != "0" {
+			t.Fatalf("status %s: %s", status, message)
+		}
+		fields := protoMessage(t, msg)
+		if fields[5].varint != 8 || fields[6].varint != 50 || fields[2].varint != 1 {
+			t.Errorf("bits %d, length %d, channels %d; want 8, 50, 1", fields[5].varint, fields[6].varint, fields[2].varint)
+		}
+		if len(fields[8].bytes) == 0 || len(fields[9].bytes) != 50 || len(fields[10].bytes) != 50 {
+			t.Errorf("extended fields of %d, %d and %d bytes, want RMS and 50 of each flag", len(fields[8].bytes), len(fields[9].bytes), len(fields[10].bytes))
+		}
+
+		var values []int32
+		packed := fields[7].bytes
+		for len(packed) > 0 {
+			v, n := binary.Uvarint(packed)
+			if n <= 0 {
+				t.Fatal("malformed packed data")
+			}
+			values = append(values, int32(uint32(v)>>1)^-int32(v&1))
+			packed = packed[n:]
+		}
+		if len(values) != 2*50 {
+			t.Fatalf("%d values, want %d", len(values), 2*50)
+		}
+		for i := 0; i < len(values); i += 2 {
+			if values[i] > values[i+1] || values[i] < -128 || values[i+1] > 127 {
+				t.Fatalf("pair %d = %d, %d; want min <= max within 8 bits", i/2, values[i], values[i+1])
+			}
+		}
+	})
+
+	t.Run("AnalyzeAudio", func(t *testing.T) {
+		msg, status, message := grpcInvoke(t, ts, client, "AnalyzeAudio", fileRequest(func(e *protoEncoder) {
+			e.string(11, "stats")
+			e.string(11, "clipping")
+		}))
+		if status != "0" {
+			t.Fatalf("status %s: %s", status, message)
+		}
+		fields := protoMessage(t, msg)
+		audio := DefaultTestAudio()
+		if fields[1].varint != uint64(audio.SampleRate) {
+			t.Errorf("sample rate %d, want %d", fields[1].varint, audio.SampleRate)
+		}
+		if d := math.Float64frombits(fields[3].varint); d <= 0 {
+			t.Errorf("duration %v", d)
+		}
+		var analysis map[string]json.RawMessage
+		if err := json.Unmarshal(fields[4].bytes, &analysis); err != nil {
+			t.Fatalf("analysis_json: %v", err)
+		}
+		if len(analysis) != 2 || analysis["stats"] == nil || analysis["clipping"] == nil {
+			t.Errorf("analysis = %s, want stats and clipping", fields[4].bytes)
+		}
+	})
+
+	errorTests := []struct {
+		name     string
+		method   string
+		requests [][]byte
+		status   string
+	}{
+		{"missing file", "GenerateWaveform", [][]byte{func() []byte {
+			var e protoEncoder
+			e.string(1, "missing.wav")
+			return e.buf
+		}()}, "5"},
+		{"escaping root", "GetPeaks", [][]byte{func() []byte {
+			var e protoEncoder
+			e.string(1, "../../etc/passwd")
+			return e.buf
+		}()}, "5"},
+		{"bad option", "GenerateWaveform", [][]byte{fileRequest(func(e *protoEncoder) {
+			e.string(7, "dots")
+		})}, "3"},
+		{"unknown analysis", "AnalyzeAudio", [][]byte{fileRequest(func(e *protoEncoder) {
+			e.string(11, "tempo")
+		})}, "3"},
+		{"not a WAV file", "GetPeaks", [][]byte{func() []byte {
+			var e protoEncoder
+			e.string(2, "not audio")
+			return e.buf
+		}()}, "3"},
+		{"no input", "GetPeaks", nil, "3"},
+		{"unknown method", "Transcode", nil, "12"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, status, message := grpcInvoke(t, ts, client, tt.method, tt.requests...)
+			if status != tt.status || msg != nil {
+				t.Errorf("status %s (%s) with %d byte response, want %s and none", status, message, len(msg), tt.status)
+			}
+		})
+	}
+}
+
+func TestDecodeProtoSkipsUnknownFields(t *testing.T) {
+	var e protoEncoder
+	e.string(1, "take.wav")
+	e.key(20, 5)
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, 7)
+	e.double(21, 1.5)
+	e.varint(3, uint64(math.MaxUint64)) // -1 as int32
+	e.varint(8, 1)
+
+	c := &grpcCall{query: map[string][]string{}}
+	if err := c.add(e.buf); err != nil {
+		t.Fatal(err)
+	}
+	if c.file != "take.wav" || c.query.Get("width") != "-1" || c.query.Get("normalize") != "true" {
+		t.Errorf("call = %+v", c)
+	}
+
+	if err := c.add([]byte{0x0a, 0x10, 'x'}); err == nil {
+		t.Error("truncated field decoded")
+	}
+}
+
+func TestGRPCPercentEncode(t *testing.T) {
+	if got := grpcPercentEncode("100% \"ok\"\n"); got != "100%25 \"ok\"%0A" {
+		t.Errorf("grpcPercentEncode = %q", got)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// histogramPanelWidth is the width of the panel drawn by -histogram-panel
+const histogramPanelWidth = 80
+
+// ChannelHistogram holds the amplitude histogram of one channel. Counts[i]
+// covers amplitudes from -1 + i*BinWidth; UsedLevels is the number of
+// distinct 16-bit values that occur, which is low for quantized or
+// bit-reduced audio.
+type ChannelHistogram struct {
+	Channel    string `json:"channel"`
+	UsedLevels int    `json:"used_levels"`
+	Counts     []int  `json:"counts"`
+}
+
+// HistogramReport is the "histogram" section of a file report
+type HistogramReport struct {
+	Bins     int                `json:"bins"`
+	BinWidth float64            `json:"bin_width"`
+	Channels []ChannelHistogram `json:"channels"`
+}
+
+// histogramAnalyzer counts how often each 16-bit sample value occurs
+type histogramAnalyzer struct {
+	cfg    AnalysisConfig
+	counts [2][]int // indexed by sample value + 32768
+}
+
+func newHistogramAnalyzer(cfg AnalysisConfig) Analyzer {
+	return &histogramAnalyzer{cfg: cfg}
+}
+
+// Start implements Analyzer
+func (a *histogramAnalyzer) Start(info StreamInfo) {
+	for ch := range a.counts {
+		a.counts[ch] = make([]int, 1<<16)
+	}
+}
+
+// memoryEstimate implements memoryUser: a count for every 16-bit value of
+// both channels
+func (a *histogramAnalyzer) memoryEstimate(info StreamInfo) int64 {
+	return 2 * (1 << 16) * 8
+}
+
+// Add implements Analyzer
+func (a *histogramAnalyzer) Add(left, right []int16) {
+	for _, v := range left {
+		a.counts[0][int(v)+32768]++
+	}
+	for _, v := range right {
+		a.counts[1][int(v)+32768]++
+	}
+}
+
+// Result implements Analyzer
+func (a *histogramAnalyzer) Result() any {
+	bins := min(max(a.cfg.HistogramBins, 1), 1<<16)
+	report := HistogramReport{
+		Bins:     bins,
+		BinWidth: 2 / float64(bins),
+	}
+
+	for ch, name := range channelNames {
+		h := ChannelHistogram{Channel: name, Counts: make([]int, bins)}
+		for value, n := range a.counts[ch] {
+			if n == 0 {
+				continue
+			}
+			h.UsedLevels++
+			h.Counts[value*bins>>16] += n
+		}
+		report.Channels = append(report.Channels, h)
+	}
+
+	return report
+}
+
+// panelWidth implements imagePanel
+func (a *histogramAnalyzer) panelWidth() int {
+	return histogramPanelWidth
+}
+
+// drawPanel implements imagePanel. Rows line up with the amplitudes of the
+// waveform beside it; bars are on a log scale so rare values stay visible.
+func (a *histogramAnalyzer) drawPanel(img *image.RGBA, area image.Rectangle) {
+	height := area.Dy()
+	centerY := height / 2
+	maxAmplitude := float64(height) / 2.0
+
+	// Only the left channel is rendered, so it is the one shown
+	rows := make([]int, height)
+	for value, n := range a.counts[0] {
+		amp := float64(value-32768) / 32767.0
+		y := min(max(centerY-int(amp*maxAmplitude), 0), height-1)
+		rows[y] += n
+	}
+
+	most := 0
+	for _, n := range rows {
+		most = max(most, n)
+	}
+	if most == 0 {
+		return
+	}
+
+	scale := float64(area.Dx()-1) / math.Log1p(float64(most))
+	for y, n := range rows {
+		length := int(math.Round(math.Log1p(float64(n)) * scale))
+		for x := 0; x < length; x++ {
+			img.SetRGBA(area.Min.X+x, area.Min.Y+y, waveformColor)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historySchema creates the table of processed files. hash identifies the
+// content of an input (empty when it can't be known without downloading it)
+// and options the option flags it was processed with; a row without an
+// error is a file that was processed completely.
+const historySchema = `CREATE TABLE IF NOT EXISTS files (
+	id INTEGER PRIMARY KEY,
+	input TEXT NOT NULL,
+	hash TEXT NOT NULL,
+	options TEXT NOT NULL,
+	outputs TEXT NOT NULL,
+	started_at TEXT NOT NULL,
+	elapsed_ms INTEGER NOT NULL,
+	duration_seconds REAL,
+	error TEXT
+);
+CREATE INDEX IF NOT EXISTS files_input ON files (input, hash, options);`
+
+// History records processed files in a SQLite database. Statements run the
+// sqlite3 command, one at a time, as sftp transfers run the sftp client.
+type History struct {
+	path string
+	mu   sync.Mutex
+
+	// command is the sqlite3 client to run
+	command string
+}
+
+// HistoryRecord is one processed file
+type HistoryRecord struct {
+	Input    string
+	Hash     string
+	Options  string
+	Outputs  []string
+	Started  time.Time
+	Elapsed  time.Duration
+	Duration float64
+	Err      error
+}
+
+// OpenHistory opens the database at path, creating it and its table when
+// they don't exist yet
+func OpenHistory(path string) (*History, error) {
+	h := &History{path: path, command: "sqlite3"}
+	if _, err := h.exec(historySchema); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// exec runs SQL statements and returns what they print
+func (h *History) exec(sql string) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Other processes may be writing to the database, so wait for them
+	// rather than failing at once
+	cmd := exec.Command(h.command, "-batch", "-bail", "-noheader", h.path)
+	cmd.Stdin = strings.NewReader(".timeout 10000\n" + sql + "\n")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("sqlite3 %s: %w: %s", h.path, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// sqlQuote quotes a string literal
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// Done reports whether the last time an input was processed with the same
+// content and options, it was processed completely. Inputs without a hash
+// never count as done.
+func (h *History) Done(input, hash, options string) (bool, error) {
+	if hash == "" {
+		return false, nil
+	}
+	out, err := h.exec(fmt.Sprintf("SELECT error IS NULL FROM files WHERE input = %s AND hash = %s AND options = %s ORDER BY id DESC LIMIT 1;",
+		sqlQuote(input), sqlQuote(hash), sqlQuote(options)))
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(out) == "1", nil
+}
+
+// Record adds a processed file
+func (h *History) Record(r HistoryRecord) error {
+	outputs, err := json.Marshal(r.Outputs)
+	if err != nil {
+		return err
+	}
+	if r.Outputs == nil {
+		outputs = []byte("[]")
+	}
+	duration, errText := "NULL", "NULL"
+	if r.Duration > 0 {
+		duration = strconv.FormatFloat(r.Duration, 'f', -1, 64)
+	}
+	if r.Err != nil {
+		errText = sqlQuote(r.Err.Error())
+	}
+
+	_, err = h.exec(fmt.Sprintf("INSERT INTO files (input, hash, options, outputs, started_at, elapsed_ms, duration_seconds, error) VALUES (%s, %s, %s, %s, %s, %d, %s, %s);",
+		sqlQuote(r.Input), sqlQuote(r.Hash), sqlQuote(r.Options), sqlQuote(string(outputs)),
+		sqlQuote(r.Started.UTC().Format(time.RFC3339Nano)), r.Elapsed.Milliseconds(), duration, errText))
+	return err
+}
+
+// contentHash identifies the content of a batch input: the SHA-256 of a
+// local file, or the ETag and size of a remote object. It is empty for
+// remote objects listed without an ETag.
+func contentHash(input batchInput) (string, error) {
+	if input.Path == "" {
+		if input.Version == "" {
+			return "", nil
+		}
+		return "etag:" + input.Version, nil
+	}
+
+	file, err := os.Open(input.Path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
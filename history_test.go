@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+
+	h, err := OpenHistory(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := func(input, hash string, err error) {
+		t.Helper()
+		r := HistoryRecord{Input: input, Hash: hash, Options: "-width=800", Outputs: []string{"out/it's.png"}, Started: time.Now(), Elapsed: time.Second, Duration: 2.5, Err: err}
+		if err := h.Record(r); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+	record("a.wav", "sha256:aa", nil)
+	record("b.wav", "sha256:bb", errors.New("failed to parse WAV file: it's 'broken'"))
+	record("c.wav", "sha256:cc", nil)
+	record("c.wav", "sha256:cc", errors.New("post-cmd failed"))
+
+	tests := []struct {
+		input, hash, options string
+		want                 bool
+	}{
+		{"a.wav", "sha256:aa", "-width=800", true},
+		{"a.wav", "sha256:changed", "-width=800", false},
+		{"a.wav", "sha256:aa", "-width=900", false},
+		{"b.wav", "sha256:bb", "-width=800", false},
+		{"c.wav", "sha256:cc", "-width=800", false}, // the last run failed
+		{"d.wav", "sha256:dd", "-width=800", false},
+		{"a.wav", "", "-width=800", false},
+	}
+	for _, tt := range tests {
+		got, err := h.Done(tt.input, tt.hash, tt.options)
+		if err != nil {
+			t.Fatalf("Done: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("Done(%q, %q, %q) = %v, want %v", tt.input, tt.hash, tt.options, got, tt.want)
+		}
+	}
+
+	out, err := h.exec("SELECT error FROM files WHERE input = 'b.wav';")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(out) != "failed to parse WAV file: it's 'broken'" {
+		t.Errorf("stored error = %q", out)
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	file := writeFixture(t, DefaultTestAudio())
+	local, err := contentHash(batchInput{Name: "fixture.wav", Path: file, Location: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(local, "sha256:") || len(local) != len("sha256:")+64 {
+		t.Errorf("local hash = %q", local)
+	}
+
+	remote, _ := contentHash(batchInput{Name: "a.wav", Location: "s3://b/a.wav", Version: `"abc"|10`})
+	if remote != `etag:"abc"|10` {
+		t.Errorf("remote hash = %q", remote)
+	}
+	if unknown, _ := contentHash(batchInput{Name: "a.wav", Location: "s3://b/a.wav"}); unknown != "" {
+		t.Errorf("hash without an ETag = %q, want none", unknown)
+	}
+}
+
+func TestHistoryHashes(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+
+	inputDir, outputDir := t.TempDir(), t.TempDir()
+	if err := WriteTestAudioFile(filepath.Join(inputDir, "a.wav"), DefaultTestAudio()); err != nil {
+		t.Fatal(err)
+	}
+	batch, err := newStorageBatch(inputDir, outputDir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer batch.Close()
+	h, err := OpenHistory(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := &batchRun{outputDir: outputDir, batch: batch, history: h, opts: Options{Width: 100, Height: 20}}
+
+	// Without -skip-done local inputs aren't read for their hash, unless
+	// -hash-inputs takes it anyway
+	run.process(ObjectInfo{Name: "a.wav"})
+	run.opts.HashInputs = true
+	run.process(ObjectInfo{Name: "a.wav"})
+	run.opts.HashInputs, run.skipDone = false, true
+	run.process(ObjectInfo{Name: "a.wav"})
+
+	// The last run is skipped, as the hash taken by -hash-inputs matches
+	out, err := h.exec("SELECT hash LIKE 'sha256:%' FROM files ORDER BY id;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(out); strings.Join(got, ",") != "0,1" {
+		t.Errorf("hashed runs = %v, want only the second", got)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// hookVars returns the template variables available to pre/post commands.
+// {input} is where the file came from, which for remote inputs is a URL;
+// {local} is always the local file that was decoded. {title}, {artist} and
+// {album} are its tags, empty when it has none.
+func hookVars(input batchInput, outputFile string) map[string]string {
+	var md AudioMetadata
+	if input.Metadata != nil {
+		md = *input.Metadata
+	}
+	return map[string]string{
+		"input":  input.Location,
+		"local":  input.Path,
+		"output": outputFile,
+		"name":   strings.TrimSuffix(input.Name, filepath.Ext(input.Name)),
+		"dir":    filepath.Dir(outputFile),
+		"title":  md.Title,
+		"artist": md.Artist,
+		"album":  md.Album,
+	}
+}
+
+// splitCommand splits a command template into arguments, honoring single and
+// double quotes so arguments may contain spaces
+func splitCommand(cmd string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	var quote rune
+	inArg := false
+
+	for _, r := range cmd {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in command %q", cmd)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return args, nil
+}
+
+// expandHook substitutes {name} style variables into each argument. Variables
+// are expanded after splitting, so file names containing spaces stay one
+// argument, and in a single pass, so a value that itself contains {output}
+// or the like is left as is.
+func expandHook(args []string, vars map[string]string) []string {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		pairs = append(pairs, "{"+name+"}", vars[name])
+	}
+	r := strings.NewReplacer(pairs...)
+
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		expanded[i] = r.Replace(arg)
+	}
+	return expanded
+}
+
+// runHook runs a command template with the given variables. The command is
+// executed directly rather than through a shell.
+func runHook(cmd string, vars map[string]string) error {
+	args, err := splitCommand(cmd)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	args = expandHook(args, vars)
+
+	c := exec.Command(args[0], args[1:]...)
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("command %q failed: %w", strings.Join(args, " "), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		cmd     string
+		want    []string
+		wantErr bool
+	}{
+		{"optipng {output}", []string{"optipng", "{output}"}, false},
+		{`cp "{input}" 'a b'`, []string{"cp", "{input}", "a b"}, false},
+		{"  spaced\targs\n", []string{"spaced", "args"}, false},
+		{"", nil, false},
+		{`echo "open`, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cmd, func(t *testing.T) {
+			got, err := splitCommand(tt.cmd)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandHook(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		vars map[string]string
+		want []string
+	}{
+		{"simple", []string{"cp", "{input}", "{dir}/x"}, map[string]string{"input": "a.wav", "dir": "out"},
+			[]string{"cp", "a.wav", "out/x"}},
+		{"spaces stay one argument", []string{"{output}"}, map[string]string{"output": "my file.png"},
+			[]string{"my file.png"}},
+		{"unknown left alone", []string{"{other}"}, map[string]string{"input": "a.wav"},
+			[]string{"{other}"}},
+		// A value that looks like a variable must not be expanded again,
+		// whatever order the variables are visited in
+		{"no recursive expansion", []string{"{name}-{output}"},
+			map[string]string{"name": "{output}", "output": "x.png", "input": "{name}"},
+			[]string{"{output}-x.png"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 20 {
+				if got := expandHook(tt.args, tt.vars); !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("got %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHookVars(t *testing.T) {
+	tests := []struct {
+		name  string
+		input batchInput
+		want  map[string]string
+	}{
+		{"local", batchInput{Name: "take.1.wav", Path: "audios/take.1.wav", Location: "audios/take.1.wav"},
+			map[string]string{"input": "audios/take.1.wav", "local": "audios/take.1.wav", "name": "take.1", "output": "out/take.png", "dir": "out",
+				"title": "", "artist": "", "album": ""}},
+		// Remote inputs show where they came from, not the download
+		{"remote", batchInput{Name: "take.wav", Path: "/tmp/in1/take.wav", Location: "s3://media/rec/take.wav", Version: `"abc"|10`},
+			map[string]string{"input": "s3://media/rec/take.wav", "local": "/tmp/in1/take.wav", "name": "take", "output": "out/take.png", "dir": "out",
+				"title": "", "artist": "", "album": ""}},
+		{"tagged", batchInput{Name: "01.wav", Path: "audios/01.wav", Location: "audios/01.wav", Metadata: &AudioMetadata{Title: "Intro", Artist: "Band"}},
+			map[string]string{"input": "audios/01.wav", "local": "audios/01.wav", "name": "01", "output": "out/take.png", "dir": "out",
+				"title": "Intro", "artist": "Band", "album": ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hookVars(tt.input, "out/take.png"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ImageTolerance controls how far a render may drift from the image it is
+// compared with
+type ImageTolerance struct {
+	// ChannelDelta is the largest per-channel difference treated as equal
+	ChannelDelta uint8
+	// MaxPixels is how many differing pixels are allowed before failing
+	MaxPixels int
+}
+
+// diffImages counts pixels that differ by more than tol.ChannelDelta and
+// returns an image highlighting them in red
+func diffImages(want, got image.Image, tol ImageTolerance) (int, *image.RGBA, error) {
+	if want.Bounds() != got.Bounds() {
+		return 0, nil, fmt.Errorf("size mismatch: want %v, got %v", want.Bounds().Size(), got.Bounds().Size())
+	}
+
+	bounds := want.Bounds()
+	diff := image.NewRGBA(bounds)
+	differing := 0
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			wr, wg, wb, wa := want.At(x, y).RGBA()
+			gr, gg, gb, ga := got.At(x, y).RGBA()
+
+			i := diff.PixOffset(x, y)
+			if channelDelta(wr, gr) > tol.ChannelDelta || channelDelta(wg, gg) > tol.ChannelDelta ||
+				channelDelta(wb, gb) > tol.ChannelDelta || channelDelta(wa, ga) > tol.ChannelDelta {
+				differing++
+				copy(diff.Pix[i:i+4], []uint8{255, 0, 0, 255})
+			} else {
+				// Keep unchanged pixels as a faint copy for context
+				copy(diff.Pix[i:i+4], []uint8{uint8(wr >> 8), uint8(wg >> 8), uint8(wb >> 8), 64})
+			}
+		}
+	}
+
+	return differing, diff, nil
+}
+
+// channelDelta returns the 8-bit difference between two 16-bit color channels
+func channelDelta(a, b uint32) uint8 {
+	if a > b {
+		return uint8((a - b) >> 8)
+	}
+	return uint8((b - a) >> 8)
+}
+
+// meanImageDifference is the mean absolute difference of the color
+// channels of two images of the same size, from 0 (identical) to 1
+func meanImageDifference(a, b image.Image) float64 {
+	bounds := a.Bounds()
+	var total float64
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			ar, ag, ab, aa := a.At(x, y).RGBA()
+			br, bg, bb, ba := b.At(x, y).RGBA()
+			total += math.Abs(float64(ar)-float64(br)) + math.Abs(float64(ag)-float64(bg)) +
+				math.Abs(float64(ab)-float64(bb)) + math.Abs(float64(aa)-float64(ba))
+		}
+	}
+	return total / (4 * 0xffff * float64(bounds.Dx()*bounds.Dy()))
+}
+
+// ImageDiffReport compares two waveform images. Difference is the mean
+// absolute color difference, from 0 for identical images to 1.
+type ImageDiffReport struct {
+	A               string  `json:"a"`
+	B               string  `json:"b"`
+	Width           int     `json:"width"`
+	Height          int     `json:"height"`
+	DifferingPixels int     `json:"differing_pixels"`
+	DifferingShare  float64 `json:"differing_share"`
+	Difference      float64 `json:"difference"`
+}
+
+// readPNG decodes a PNG file
+func readPNG(filename string) (image.Image, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, err := png.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return img, nil
+}
+
+// diffPNGFiles compares two images and returns the image of the pixels that
+// differ, in red over a faint copy of a
+func diffPNGFiles(a, b string, tol ImageTolerance) (*image.RGBA, ImageDiffReport, error) {
+	imgA, err := readPNG(a)
+	if err != nil {
+		return nil, ImageDiffReport{}, err
+	}
+	imgB, err := readPNG(b)
+	if err != nil {
+		return nil, ImageDiffReport{}, err
+	}
+
+	differing, overlay, err := diffImages(imgA, imgB, tol)
+	if err != nil {
+		return nil, ImageDiffReport{}, err
+	}
+
+	size := imgA.Bounds().Size()
+	return overlay, ImageDiffReport{
+		A:               a,
+		B:               b,
+		Width:           size.X,
+		Height:          size.Y,
+		DifferingPixels: differing,
+		DifferingShare:  float64(differing) / float64(size.X*size.Y),
+		Difference:      meanImageDifference(imgA, imgB),
+	}, nil
+}
+
+// PeaksDiffReport compares the left channel of two peak files. Difference
+// is the mean absolute difference of the bucket minimums and maximums as a
+// fraction of full scale, MaxDifference the largest.
+type PeaksDiffReport struct {
+	A                string  `json:"a"`
+	B                string  `json:"b"`
+	BucketsA         int     `json:"buckets_a"`
+	BucketsB         int     `json:"buckets_b"`
+	DifferingBuckets int     `json:"differing_buckets"`
+	Difference       float64 `json:"difference"`
+	MaxDifference    float64 `json:"max_difference"`
+}
+
+// diffPeaksFiles compares two peak files bucket by bucket, over the buckets
+// both have, and draws them over each other: a in gray, b in red
+func diffPeaksFiles(a, b string, ro RenderOptions) (*image.RGBA, PeaksDiffReport, error) {
+	peaksA, err := ReadPeaksFile(a)
+	if err != nil {
+		return nil, PeaksDiffReport{}, fmt.Errorf("%s: %w", a, err)
+	}
+	peaksB, err := ReadPeaksFile(b)
+	if err != nil {
+		return nil, PeaksDiffReport{}, fmt.Errorf("%s: %w", b, err)
+	}
+	if peaksA.SampleRate != peaksB.SampleRate || peaksA.SamplesPerPixel != peaksB.SamplesPerPixel {
+		return nil, PeaksDiffReport{}, fmt.Errorf("peaks differ in resolution: %d Hz/%d spp and %d Hz/%d spp",
+			peaksA.SampleRate, peaksA.SamplesPerPixel, peaksB.SampleRate, peaksB.SamplesPerPixel)
+	}
+	if len(peaksA.Channels) == 0 || len(peaksB.Channels) == 0 {
+		return nil, PeaksDiffReport{}, fmt.Errorf("no peaks to compare")
+	}
+
+	left, right := peaksA.Channels[0], peaksB.Channels[0]
+	report := PeaksDiffReport{A: a, B: b, BucketsA: len(left.Min), BucketsB: len(right.Min)}
+	n := min(len(left.Min), len(right.Min))
+	var total float64
+	for i := range n {
+		dMin := math.Abs(float64(left.Min[i]) - float64(right.Min[i]))
+		dMax := math.Abs(float64(left.Max[i]) - float64(right.Max[i]))
+		if dMin > 0 || dMax > 0 {
+			report.DifferingBuckets++
+		}
+		total += dMin + dMax
+		report.MaxDifference = max(report.MaxDifference, dMin/65535, dMax/65535)
+	}
+	if n > 0 {
+		report.Difference = total / (2 * 65535 * float64(n))
+	}
+
+	base := ro
+	base.Foreground = color.RGBA{128, 128, 128, 255}
+	overlay, err := drawPeaks(left, base, nil)
+	if err != nil {
+		return nil, report, err
+	}
+
+	over := ro
+	over.Foreground = color.RGBA{255, 0, 0, 160}
+	over.Background = color.RGBA{}
+	top, err := drawPeaks(right, over, nil)
+	if err != nil {
+		putImage(overlay)
+		return nil, report, err
+	}
+	defer putImage(top)
+
+	bounds := overlay.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			blendPixel(overlay, x, y, unpremultiply(top.RGBAAt(x, y)))
+		}
+	}
+	return overlay, report, nil
+}
+
+// diffRenders compares two images, or two peak files, writes the image of
+// the comparison and fails when their difference is above maxDifference
+func diffRenders(a, b string, ro RenderOptions, tol ImageTolerance, output, reportFile string, maxDifference float64) error {
+	var img *image.RGBA
+	var report any
+	var difference float64
+
+	if strings.EqualFold(filepath.Ext(a), ".png") {
+		r := ImageDiffReport{}
+		var err error
+		if img, r, err = diffPNGFiles(a, b, tol); err != nil {
+			return err
+		}
+		report, difference = r, r.Difference
+	} else {
+		r := PeaksDiffReport{}
+		var err error
+		if img, r, err = diffPeaksFiles(a, b, ro); err != nil {
+			return err
+		}
+		defer putImage(img)
+		report, difference = r, r.Difference
+	}
+
+	if err := writeDiff("Difference image", img, output, reportFile, report); err != nil {
+		return err
+	}
+	if difference > maxDifference {
+		return fmt.Errorf("difference %g is above -max-difference %g", difference, maxDifference)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestPNG writes a 10x10 image that is white but for a black
+// rectangle
+func writeTestPNG(t *testing.T, name string, black image.Rectangle) string {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for y := range 10 {
+		for x := range 10 {
+			c := color.RGBA{255, 255, 255, 255}
+			if image.Pt(x, y).In(black) {
+				c = color.RGBA{0, 0, 0, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	file := filepath.Join(t.TempDir(), name)
+	if err := savePNG(img, file, 0); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestDiffPNGFiles(t *testing.T) {
+	a := writeTestPNG(t, "a.png", image.Rect(0, 0, 5, 10))
+	b := writeTestPNG(t, "b.png", image.Rect(0, 0, 6, 10))
+
+	_, same, err := diffPNGFiles(a, a, ImageTolerance{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same.DifferingPixels != 0 || same.Difference != 0 {
+		t.Errorf("identical images: %+v", same)
+	}
+
+	overlay, report, err := diffPNGFiles(a, b, ImageTolerance{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One column of 10 pixels went from white to black
+	if report.DifferingPixels != 10 || report.DifferingShare != 0.1 || report.Difference < 0.074 || report.Difference > 0.076 {
+		t.Errorf("report = %+v, want 10 pixels, a share of 0.1 and a difference of 0.075", report)
+	}
+	if got := overlay.RGBAAt(5, 3); got != (color.RGBA{255, 0, 0, 255}) {
+		t.Errorf("changed pixel drawn as %v, want red", got)
+	}
+
+	small := filepath.Join(t.TempDir(), "small.png")
+	savePNG(image.NewRGBA(image.Rect(0, 0, 4, 4)), small, 0)
+	if _, _, err := diffPNGFiles(a, small, ImageTolerance{}); err == nil {
+		t.Error("images of different sizes compared")
+	}
+}
+
+func TestDiffPeaksFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, peaks ChannelPeaks) string {
+		file := filepath.Join(dir, name)
+		if err := WritePeaksFile(file, &Peaks{SampleRate: 44100, SamplesPerPixel: 256, Channels: []ChannelPeaks{peaks}}); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	a := write("a.peaks", ChannelPeaks{Min: []int16{-100, -200, -300, -400}, Max: []int16{100, 200, 300, 400}})
+	b := write("b.peaks", ChannelPeaks{Min: []int16{-100, -200, -300}, Max: []int16{100, 200, 300 + 6553}})
+
+	ro := DefaultRenderOptions()
+	ro.Width, ro.Height = 40, 20
+	overlay, report, err := diffPeaksFiles(a, b, ro)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer putImage(overlay)
+
+	if report.BucketsA != 4 || report.BucketsB != 3 || report.DifferingBuckets != 1 {
+		t.Errorf("report = %+v", report)
+	}
+	if report.MaxDifference < 0.09 || report.MaxDifference > 0.11 {
+		t.Errorf("max difference = %g, want about 0.1", report.MaxDifference)
+	}
+	if b := overlay.Bounds(); b.Dx() != 40 || b.Dy() != 20 {
+		t.Errorf("overlay is %v, want 40x20", b)
+	}
+}
+
+func TestDiffRenders(t *testing.T) {
+	a := writeTestPNG(t, "a.png", image.Rect(0, 0, 5, 10))
+	b := writeTestPNG(t, "b.png", image.Rect(0, 0, 6, 10))
+	dir := t.TempDir()
+	output, reportFile := filepath.Join(dir, "diff.png"), filepath.Join(dir, "diff.json")
+
+	if err := diffRenders(a, b, DefaultRenderOptions(), ImageTolerance{}, output, reportFile, 1); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report ImageDiffReport
+	if err := json.Unmarshal(data, &report); err != nil || report.DifferingPixels != 10 {
+		t.Errorf("report file = %s (%v)", data, err)
+	}
+	if _, err := readPNG(output); err != nil {
+		t.Errorf("difference image: %v", err)
+	}
+
+	if err := diffRenders(a, b, DefaultRenderOptions(), ImageTolerance{}, output, "", 0); err == nil {
+		t.Error("a changed image passed -max-difference 0")
+	}
+	if err := diffRenders(a, a, DefaultRenderOptions(), ImageTolerance{}, output, "", 0); err != nil {
+		t.Errorf("identical images failed -max-difference 0: %v", err)
+	}
+}
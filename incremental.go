@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// incrementalSamplesPerPixel is the fixed bucket size used for growing files,
+// so buckets computed earlier stay valid as the file gets longer
+const incrementalSamplesPerPixel = 256
+
+// decodePeaksIncremental brings prev up to date with a file that may have
+// grown since prev was computed, decoding only the new tail. prev may be nil,
+// in which case the whole file is decoded. It also returns the total number
+// of samples covered by the returned peaks.
+func decodePeaksIncremental(inputFile string, prev *Peaks, opts Options) (*Peaks, int, error) {
+	defer decodeDuration.since(time.Now())
+
+	r, err := openWAVChannels(inputFile, opts.UseMmap, true, opts.Channels)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer r.Close()
+
+	samplesPerPixel := incrementalSamplesPerPixel
+	numBuckets := (r.numFrames + samplesPerPixel - 1) / samplesPerPixel
+	if numBuckets == 0 {
+		return nil, 0, fmt.Errorf("no audio data found in file")
+	}
+
+	// Every bucket of prev but the last is complete; the last may have been
+	// partial when it was computed, so decoding resumes at its first sample
+	keep := 0
+	if prev != nil && prev.SampleRate == r.header.SampleRate &&
+		prev.SamplesPerPixel == uint32(samplesPerPixel) && len(prev.Channels) == 1 &&
+		prev.Len() > 0 && prev.Len() <= numBuckets {
+		keep = prev.Len() - 1
+	}
+
+	builder := NewPeakBuilder(samplesPerPixel, numBuckets)
+	builder.agg = opts.Aggregation
+	if keep > 0 {
+		builder.resume(prev.Channels[0], keep)
+	}
+
+	if err := r.seekFrame(keep * samplesPerPixel); err != nil {
+		return nil, 0, err
+	}
+
+	for {
+		opts.Progress.decode(r.progress())
+
+		left, _, err := r.readPCM()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		builder.AddInt16(left)
+	}
+
+	opts.Progress.decode(1)
+
+	peaks := &Peaks{
+		SampleRate:      r.header.SampleRate,
+		SamplesPerPixel: uint32(samplesPerPixel),
+		Channels:        []ChannelPeaks{builder.Peaks()},
+	}
+
+	return peaks, r.framesRead, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import "io"
+
+// SampleIterator yields blocks of frames decoded from a WAV file. Blocks are
+// decoded into buffers owned by the iterator and recycled across files, so
+// long-running consumers don't allocate per block:
+//
+//	it, err := NewSampleIterator("input.wav")
+//	if err != nil { ... }
+//	defer it.Close()
+//	for it.Next() {
+//		left, right := it.Frames()
+//		...
+//	}
+//	if err := it.Err(); err != nil { ... }
+type SampleIterator struct {
+	r *wavReader
+
+	pcmLeft  []int16
+	pcmRight []int16
+	err      error
+}
+
+// NewSampleIterator opens a WAV file for iteration
+func NewSampleIterator(filename string) (*SampleIterator, error) {
+	r, err := openWAV(filename, false, false)
+	if err != nil {
+		return nil, err
+	}
+	return &SampleIterator{r: r}, nil
+}
+
+// Next decodes the next block of frames, returning false at the end of the
+// data or on error
+func (it *SampleIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	it.pcmLeft, it.pcmRight, it.err = it.r.readPCM()
+	if it.err == io.EOF {
+		it.err = nil
+		it.pcmLeft, it.pcmRight = nil, nil
+		return false
+	}
+
+	return it.err == nil
+}
+
+// Frames returns the current block as normalized samples in [-1, 1]. The
+// slices are only valid until the next call to Next or Close.
+func (it *SampleIterator) Frames() (left, right []float64) {
+	if it.pcmLeft == nil {
+		return nil, nil
+	}
+	return it.r.normalize(it.pcmLeft, it.pcmRight)
+}
+
+// PCM returns the current block as raw 16-bit samples. The slices are only
+// valid until the next call to Next or Close.
+func (it *SampleIterator) PCM() (left, right []int16) {
+	return it.pcmLeft, it.pcmRight
+}
+
+// SampleRate returns the sample rate of the file
+func (it *SampleIterator) SampleRate() uint32 {
+	return it.r.header.SampleRate
+}
+
+// NumFrames returns the number of frames the file is expected to hold
+func (it *SampleIterator) NumFrames() int {
+	return it.r.numFrames
+}
+
+// Err returns the first error met while iterating
+func (it *SampleIterator) Err() error {
+	return it.err
+}
+
+// Close releases the file and returns the buffers for reuse by later iterators
+func (it *SampleIterator) Close() error {
+	it.pcmLeft, it.pcmRight = nil, nil
+	return it.r.Close()
+}
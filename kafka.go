@@ -0,0 +1,1083 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// kafkaTimeout bounds connecting and every request to a Kafka broker
+const kafkaTimeout = 30 * time.Second
+
+// kafkaPollWait is how long a fetch waits on the broker for new records
+const kafkaPollWait = 5 * time.Second
+
+// Kafka API keys
+const (
+	kafkaProduce         int16 = 0
+	kafkaFetch           int16 = 1
+	kafkaListOffsets     int16 = 2
+	kafkaMetadata        int16 = 3
+	kafkaOffsetCommit    int16 = 8
+	kafkaOffsetFetch     int16 = 9
+	kafkaFindCoordinator int16 = 10
+)
+
+// kafkaVersions are the API versions used: the oldest that brokers from 2.1
+// through 4.x all still accept, before the flexible encodings
+var kafkaVersions = map[int16]int16{
+	kafkaProduce:         3,
+	kafkaFetch:           4,
+	kafkaListOffsets:     1,
+	kafkaMetadata:        1,
+	kafkaOffsetCommit:    2,
+	kafkaOffsetFetch:     1,
+	kafkaFindCoordinator: 1,
+}
+
+// Kafka error codes handled specially
+const (
+	kafkaOffsetOutOfRange   int16 = 1
+	kafkaUnknownTopic       int16 = 3
+	kafkaLeaderNotAvailable int16 = 5
+	kafkaNotLeader          int16 = 6
+	kafkaNotCoordinator     int16 = 16
+)
+
+// kafkaError is an error code returned by a broker
+type kafkaError int16
+
+func (e kafkaError) Error() string { return fmt.Sprintf("kafka: error code %d", int16(e)) }
+
+// staleMetadata reports whether an error code means the partition leaders
+// or the group coordinator moved
+func staleMetadata(code int16) bool {
+	return code == kafkaUnknownTopic || code == kafkaLeaderNotAvailable || code == kafkaNotLeader || code == kafkaNotCoordinator
+}
+
+// kafkaEncoder builds a request in the Kafka wire format
+type kafkaEncoder struct {
+	b []byte
+}
+
+func (e *kafkaEncoder) int8(v int8)   { e.b = append(e.b, byte(v)) }
+func (e *kafkaEncoder) int16(v int16) { e.b = binary.BigEndian.AppendUint16(e.b, uint16(v)) }
+func (e *kafkaEncoder) int32(v int32) { e.b = binary.BigEndian.AppendUint32(e.b, uint32(v)) }
+func (e *kafkaEncoder) int64(v int64) { e.b = binary.BigEndian.AppendUint64(e.b, uint64(v)) }
+
+func (e *kafkaEncoder) varint(v int64) { e.b = binary.AppendVarint(e.b, v) }
+
+func (e *kafkaEncoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.b = append(e.b, s...)
+}
+
+func (e *kafkaEncoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.b = append(e.b, b...)
+}
+
+// kafkaDecoder reads a response; the first short read sets err and makes
+// every later read return zero
+type kafkaDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *kafkaDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = fmt.Errorf("kafka: short response")
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = fmt.Errorf("kafka: bad varint")
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+// string reads a string; null strings read as empty
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+// bytes reads a byte array; null arrays read as nil
+func (d *kafkaDecoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+// arrayLen reads the length of an array, treating null as empty
+func (d *kafkaDecoder) arrayLen() int {
+	n := int(d.int32())
+	if n < 0 || d.err != nil {
+		return 0
+	}
+	// Every element takes at least a byte, which bounds bad lengths
+	if n > len(d.b) {
+		d.err = fmt.Errorf("kafka: bad array length %d", n)
+		return 0
+	}
+	return n
+}
+
+// kafkaConn is a connection to one broker. Requests from several goroutines
+// take turns.
+type kafkaConn struct {
+	mu          sync.Mutex
+	conn        net.Conn
+	correlation int32
+}
+
+// roundTrip sends a request and returns a decoder over its response body
+func (c *kafkaConn) roundTrip(apiKey int16, body []byte) (*kafkaDecoder, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.correlation++
+	var header kafkaEncoder
+	header.int32(0) // size, filled in below
+	header.int16(apiKey)
+	header.int16(kafkaVersions[apiKey])
+	header.int32(c.correlation)
+	header.string("only_waveform")
+	msg := append(header.b, body...)
+	binary.BigEndian.PutUint32(msg, uint32(len(msg)-4))
+
+	// Fetches wait on the broker, so allow for that
+	c.conn.SetDeadline(time.Now().Add(kafkaTimeout + kafkaPollWait))
+	if _, err := c.conn.Write(msg); err != nil {
+		return nil, err
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(c.conn, size[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(c.conn, resp); err != nil {
+		return nil, err
+	}
+
+	d := &kafkaDecoder{b: resp}
+	if id := d.int32(); id != c.correlation {
+		return nil, fmt.Errorf("kafka: response %d to request %d", id, c.correlation)
+	}
+	return d, nil
+}
+
+// kafkaClient talks to the brokers of a cluster about one topic
+type kafkaClient struct {
+	bootstrap []string
+	topic     string
+
+	mu    sync.Mutex
+	conns map[string]*kafkaConn
+	// brokers maps node IDs to addresses and leaders partitions to the node
+	// leading them; both are nil until metadata has been loaded
+	brokers    map[int32]string
+	leaders    map[int32]int32
+	partitions []int32
+}
+
+// parseKafkaURL reads kafka://host[:port][,host[:port]...]/topic[?query];
+// the port defaults to 9092
+func parseKafkaURL(location string) (*kafkaClient, url.Values, error) {
+	rest, ok := strings.CutPrefix(location, "kafka://")
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: not a kafka:// URL", location)
+	}
+	hosts, rest, _ := strings.Cut(rest, "/")
+	topic, query, _ := strings.Cut(rest, "?")
+	if hosts == "" || topic == "" {
+		return nil, nil, fmt.Errorf("%s: want kafka://host[:port]/topic", location)
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", location, err)
+	}
+
+	c := &kafkaClient{topic: topic, conns: map[string]*kafkaConn{}}
+	for _, host := range strings.Split(hosts, ",") {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, "9092")
+		}
+		c.bootstrap = append(c.bootstrap, host)
+	}
+	return c, values, nil
+}
+
+// call sends a request to the broker at addr, dropping the connection when
+// it breaks so the next request reconnects
+func (c *kafkaClient) call(ctx context.Context, addr string, apiKey int16, body []byte) (*kafkaDecoder, error) {
+	// Fetches block on the broker, so they get connections of their own
+	key := addr
+	if apiKey == kafkaFetch {
+		key += " fetch"
+	}
+
+	c.mu.Lock()
+	conn := c.conns[key]
+	c.mu.Unlock()
+
+	if conn == nil {
+		d := net.Dialer{Timeout: kafkaTimeout}
+		nc, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		conn = &kafkaConn{conn: nc}
+
+		c.mu.Lock()
+		if existing := c.conns[key]; existing != nil {
+			nc.Close()
+			conn = existing
+		} else {
+			c.conns[key] = conn
+		}
+		c.mu.Unlock()
+	}
+
+	d, err := conn.roundTrip(apiKey, body)
+	if err != nil {
+		c.mu.Lock()
+		if c.conns[key] == conn {
+			conn.conn.Close()
+			delete(c.conns, key)
+		}
+		c.mu.Unlock()
+		return nil, err
+	}
+	return d, nil
+}
+
+// callAny sends a request to the first broker that answers, known brokers
+// before the bootstrap list
+func (c *kafkaClient) callAny(ctx context.Context, apiKey int16, body []byte) (*kafkaDecoder, error) {
+	c.mu.Lock()
+	addrs := slices.Clone(c.bootstrap)
+	for _, addr := range c.brokers {
+		addrs = append(addrs, addr)
+	}
+	c.mu.Unlock()
+
+	var errs []error
+	for _, addr := range addrs {
+		d, err := c.call(ctx, addr, apiKey, body)
+		if err == nil {
+			return d, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// refreshMetadata loads the brokers and the partition leaders of the topic
+func (c *kafkaClient) refreshMetadata(ctx context.Context) error {
+	var e kafkaEncoder
+	e.int32(1)
+	e.string(c.topic)
+
+	d, err := c.callAny(ctx, kafkaMetadata, e.b)
+	if err != nil {
+		return err
+	}
+
+	brokers := map[int32]string{}
+	for range d.arrayLen() {
+		node := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+		brokers[node] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // controller
+
+	leaders := map[int32]int32{}
+	var partitions []int32
+	var topicErr int16
+	for range d.arrayLen() {
+		code := d.int16()
+		name := d.string()
+		d.int8() // internal
+		for range d.arrayLen() {
+			d.int16() // partition error, e.g. a replica being offline
+			partition := d.int32()
+			leader := d.int32()
+			for range d.arrayLen() {
+				d.int32() // replicas
+			}
+			for range d.arrayLen() {
+				d.int32() // in-sync replicas
+			}
+			if name == c.topic {
+				partitions = append(partitions, partition)
+				leaders[partition] = leader
+			}
+		}
+		if name == c.topic {
+			topicErr = code
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if topicErr != 0 {
+		return fmt.Errorf("topic %s: %w", c.topic, kafkaError(topicErr))
+	}
+	if len(partitions) == 0 {
+		return fmt.Errorf("topic %s: no partitions", c.topic)
+	}
+	slices.Sort(partitions)
+
+	c.mu.Lock()
+	c.brokers, c.leaders, c.partitions = brokers, leaders, partitions
+	c.mu.Unlock()
+	return nil
+}
+
+// forgetMetadata makes the next request reload the partition leaders
+func (c *kafkaClient) forgetMetadata() {
+	c.mu.Lock()
+	c.leaders = nil
+	c.mu.Unlock()
+}
+
+// loadMetadata loads the metadata unless it already is
+func (c *kafkaClient) loadMetadata(ctx context.Context) error {
+	c.mu.Lock()
+	loaded := c.leaders != nil
+	c.mu.Unlock()
+	if loaded {
+		return nil
+	}
+	return c.refreshMetadata(ctx)
+}
+
+// leader returns the address of the broker leading a partition
+func (c *kafkaClient) leader(ctx context.Context, partition int32) (string, error) {
+	if err := c.loadMetadata(ctx); err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node, ok := c.leaders[partition]
+	if !ok {
+		return "", fmt.Errorf("topic %s: no partition %d", c.topic, partition)
+	}
+	addr, ok := c.brokers[node]
+	if !ok {
+		return "", fmt.Errorf("topic %s: partition %d has no leader", c.topic, partition)
+	}
+	return addr, nil
+}
+
+// topicPartitions returns the partitions of the topic
+func (c *kafkaClient) topicPartitions(ctx context.Context) ([]int32, error) {
+	if err := c.loadMetadata(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.partitions), nil
+}
+
+// kafkaRecord is one record read from a partition
+type kafkaRecord struct {
+	offset int64
+	key    []byte
+	value  []byte
+}
+
+// kafkaCRC is the checksum of record batches, CRC-32C
+var kafkaCRC = crc32.MakeTable(crc32.Castagnoli)
+
+// encodeRecordBatch returns a record batch (message format 2) holding one
+// record
+func encodeRecordBatch(key, value []byte, now time.Time) []byte {
+	var record kafkaEncoder
+	record.int8(0)   // attributes
+	record.varint(0) // timestamp delta
+	record.varint(0) // offset delta
+	record.varint(int64(len(key)))
+	record.b = append(record.b, key...)
+	record.varint(int64(len(value)))
+	record.b = append(record.b, value...)
+	record.varint(0) // headers
+
+	var checked kafkaEncoder
+	checked.int16(0) // attributes: uncompressed
+	checked.int32(0) // last offset delta
+	checked.int64(now.UnixMilli())
+	checked.int64(now.UnixMilli())
+	checked.int64(-1) // producer ID
+	checked.int16(-1) // producer epoch
+	checked.int32(-1) // base sequence
+	checked.int32(1)  // records
+	checked.varint(int64(len(record.b)))
+	checked.b = append(checked.b, record.b...)
+
+	var batch kafkaEncoder
+	batch.int64(0)                         // base offset, set by the broker
+	batch.int32(int32(9 + len(checked.b))) // length after this field
+	batch.int32(-1)                        // partition leader epoch
+	batch.int8(2)                          // magic
+	batch.int32(int32(crc32.Checksum(checked.b, kafkaCRC)))
+	batch.b = append(batch.b, checked.b...)
+	return batch.b
+}
+
+// decodeRecordBatches reads the records of a fetched record set. A batch
+// that was cut off at the end of the set is left for the next fetch, and
+// control batches of transactions are skipped.
+func decodeRecordBatches(data []byte) ([]kafkaRecord, error) {
+	var records []kafkaRecord
+	for len(data) >= 12 {
+		baseOffset := int64(binary.BigEndian.Uint64(data))
+		length := int(int32(binary.BigEndian.Uint32(data[8:])))
+		if length < 9 || len(data)-12 < length {
+			break
+		}
+		batch := data[12 : 12+length]
+		data = data[12+length:]
+
+		if magic := batch[4]; magic != 2 {
+			return records, fmt.Errorf("kafka: unsupported message format %d", magic)
+		}
+		checked := batch[9:]
+		if binary.BigEndian.Uint32(batch[5:]) != crc32.Checksum(checked, kafkaCRC) {
+			return records, fmt.Errorf("kafka: corrupt record batch at offset %d", baseOffset)
+		}
+
+		d := &kafkaDecoder{b: checked}
+		attributes := d.int16()
+		d.next(4 + 8 + 8 + 8 + 2 + 4) // offset delta, timestamps, producer
+		count := int(d.int32())
+		if attributes&0x20 != 0 {
+			continue // transaction marker
+		}
+
+		switch compression := attributes & 7; compression {
+		case 0:
+		case 1:
+			zr, err := gzip.NewReader(bytes.NewReader(d.b))
+			if err != nil {
+				return records, fmt.Errorf("kafka: %w", err)
+			}
+			if d.b, err = io.ReadAll(zr); err != nil {
+				return records, fmt.Errorf("kafka: %w", err)
+			}
+		default:
+			return records, fmt.Errorf("kafka: unsupported compression %d (only gzip)", compression)
+		}
+
+		for range count {
+			d.varint() // length
+			d.int8()   // attributes
+			d.varint() // timestamp delta
+			offsetDelta := d.varint()
+			key := d.varintBytes()
+			value := d.varintBytes()
+			for range d.varint() {
+				d.varintBytes() // header key
+				d.varintBytes() // header value
+			}
+			if d.err != nil {
+				return records, d.err
+			}
+			records = append(records, kafkaRecord{offset: baseOffset + offsetDelta, key: key, value: value})
+		}
+	}
+	return records, nil
+}
+
+// varintBytes reads a byte array with a varint length, as records use
+func (d *kafkaDecoder) varintBytes() []byte {
+	n := d.varint()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+// kafkaQueue consumes a Kafka topic. Offsets are committed to a consumer
+// group without joining it, so one worker consumes every partition named in
+// its URL; several workers split a topic by naming different partitions.
+// A failed job is committed like a finished one, as Kafka can't hand a
+// single record back.
+type kafkaQueue struct {
+	client *kafkaClient
+	group  string
+	// only lists the partitions to consume; nil is all of them
+	only []int32
+
+	mu          sync.Mutex
+	coordinator string
+	// positions holds the next offset to fetch of every partition consumed,
+	// and is nil until they have been loaded
+	positions map[int32]int64
+	// buffered holds fetched records not yet received
+	buffered []kafkaQueued
+	// pending holds the offsets of every partition that were fetched and not
+	// yet finished, in order; the lowest is where a restart has to resume
+	pending   map[int32][]int64
+	committed map[int32]int64
+}
+
+// kafkaQueued is a fetched record and where it came from
+type kafkaQueued struct {
+	partition int32
+	record    kafkaRecord
+}
+
+// newKafkaQueue opens kafka://host[:port][,host...]/topic[?group=name][&partitions=0,1]
+func newKafkaQueue(location string) (*kafkaQueue, error) {
+	client, query, err := parseKafkaURL(location)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &kafkaQueue{client: client, group: query.Get("group"), pending: map[int32][]int64{}, committed: map[int32]int64{}}
+	if q.group == "" {
+		q.group = "only_waveform"
+	}
+	if list := query.Get("partitions"); list != "" {
+		for _, p := range strings.Split(list, ",") {
+			n, err := strconv.ParseInt(p, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%s: bad partition %q", location, p)
+			}
+			q.only = append(q.only, int32(n))
+		}
+	}
+	return q, nil
+}
+
+// findCoordinator returns the address of the broker managing the group
+func (q *kafkaQueue) findCoordinator(ctx context.Context) (string, error) {
+	q.mu.Lock()
+	addr := q.coordinator
+	q.mu.Unlock()
+	if addr != "" {
+		return addr, nil
+	}
+
+	var e kafkaEncoder
+	e.string(q.group)
+	e.int8(0) // group, not transaction
+
+	d, err := q.client.callAny(ctx, kafkaFindCoordinator, e.b)
+	if err != nil {
+		return "", err
+	}
+	d.int32() // throttle time
+	code := d.int16()
+	d.string() // error message
+	d.int32()  // node
+	host := d.string()
+	port := d.int32()
+	if d.err != nil {
+		return "", d.err
+	}
+	if code != 0 {
+		return "", fmt.Errorf("group %s: %w", q.group, kafkaError(code))
+	}
+
+	addr = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	q.mu.Lock()
+	q.coordinator = addr
+	q.mu.Unlock()
+	return addr, nil
+}
+
+// loadPositions starts every partition at the group's committed offset, or
+// at the earliest one still kept when nothing was committed
+func (q *kafkaQueue) loadPositions(ctx context.Context) error {
+	partitions := q.only
+	if partitions == nil {
+		var err error
+		if partitions, err = q.client.topicPartitions(ctx); err != nil {
+			return err
+		}
+	}
+
+	coordinator, err := q.findCoordinator(ctx)
+	if err != nil {
+		return err
+	}
+	var e kafkaEncoder
+	e.string(q.group)
+	e.int32(1)
+	e.string(q.client.topic)
+	e.int32(int32(len(partitions)))
+	for _, p := range partitions {
+		e.int32(p)
+	}
+
+	d, err := q.client.call(ctx, coordinator, kafkaOffsetFetch, e.b)
+	if err != nil {
+		return err
+	}
+	positions := map[int32]int64{}
+	for range d.arrayLen() {
+		d.string() // topic
+		for range d.arrayLen() {
+			partition := d.int32()
+			offset := d.int64()
+			d.string() // metadata
+			if code := d.int16(); code != 0 {
+				if staleMetadata(code) {
+					q.mu.Lock()
+					q.coordinator = ""
+					q.mu.Unlock()
+				}
+				return fmt.Errorf("group %s: %w", q.group, kafkaError(code))
+			}
+			positions[partition] = offset
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	for _, p := range partitions {
+		if offset, ok := positions[p]; !ok || offset < 0 {
+			if positions[p], err = q.earliestOffset(ctx, p); err != nil {
+				return err
+			}
+		}
+	}
+
+	q.mu.Lock()
+	q.positions = positions
+	for p, offset := range positions {
+		q.committed[p] = offset
+	}
+	q.mu.Unlock()
+	return nil
+}
+
+// earliestOffset returns the first offset a partition still keeps
+func (q *kafkaQueue) earliestOffset(ctx context.Context, partition int32) (int64, error) {
+	leader, err := q.client.leader(ctx, partition)
+	if err != nil {
+		return 0, err
+	}
+
+	var e kafkaEncoder
+	e.int32(-1) // replica: a consumer
+	e.int32(1)
+	e.string(q.client.topic)
+	e.int32(1)
+	e.int32(partition)
+	e.int64(-2) // earliest
+
+	d, err := q.client.call(ctx, leader, kafkaListOffsets, e.b)
+	if err != nil {
+		return 0, err
+	}
+	for range d.arrayLen() {
+		d.string() // topic
+		for range d.arrayLen() {
+			d.int32() // partition
+			code := d.int16()
+			d.int64() // timestamp
+			offset := d.int64()
+			if code != 0 {
+				if staleMetadata(code) {
+					q.client.forgetMetadata()
+				}
+				return 0, fmt.Errorf("partition %d: %w", partition, kafkaError(code))
+			}
+			if d.err == nil {
+				return offset, nil
+			}
+		}
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return 0, fmt.Errorf("partition %d: no offset returned", partition)
+}
+
+func (q *kafkaQueue) Receive(ctx context.Context) (*QueuedJob, error) {
+	if job := q.next(); job != nil {
+		return job, nil
+	}
+
+	q.mu.Lock()
+	loaded := q.positions != nil
+	q.mu.Unlock()
+	if !loaded {
+		if err := q.loadPositions(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := q.fetch(ctx); err != nil {
+		return nil, err
+	}
+	return q.next(), nil
+}
+
+// next hands out the first buffered record
+func (q *kafkaQueue) next() *QueuedJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.buffered) == 0 {
+		return nil
+	}
+	r := q.buffered[0]
+	q.buffered = q.buffered[1:]
+	return &QueuedJob{Body: r.record.value, handle: fmt.Sprintf("%d:%d", r.partition, r.record.offset)}
+}
+
+// fetch reads new records from every partition, asking the leaders of the
+// partitions at the same time
+func (q *kafkaQueue) fetch(ctx context.Context) error {
+	q.mu.Lock()
+	positions := make(map[int32]int64, len(q.positions))
+	for p, offset := range q.positions {
+		positions[p] = offset
+	}
+	q.mu.Unlock()
+
+	byLeader := map[string][]int32{}
+	for p := range positions {
+		leader, err := q.client.leader(ctx, p)
+		if err != nil {
+			return err
+		}
+		byLeader[leader] = append(byLeader[leader], p)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	for leader, partitions := range byLeader {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := q.fetchFrom(ctx, leader, partitions, positions); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// fetchFrom fetches partitions led by one broker and buffers their records
+func (q *kafkaQueue) fetchFrom(ctx context.Context, leader string, partitions []int32, positions map[int32]int64) error {
+	var e kafkaEncoder
+	e.int32(-1) // replica: a consumer
+	e.int32(int32(kafkaPollWait / time.Millisecond))
+	e.int32(1)        // min bytes
+	e.int32(50 << 20) // max bytes
+	e.int8(0)         // read uncommitted
+	e.int32(1)
+	e.string(q.client.topic)
+	e.int32(int32(len(partitions)))
+	for _, p := range partitions {
+		e.int32(p)
+		e.int64(positions[p])
+		e.int32(1 << 20) // partition max bytes
+	}
+
+	d, err := q.client.call(ctx, leader, kafkaFetch, e.b)
+	if err != nil {
+		return err
+	}
+	d.int32() // throttle time
+
+	var errs []error
+	for range d.arrayLen() {
+		d.string() // topic
+		for range d.arrayLen() {
+			partition := d.int32()
+			code := d.int16()
+			d.int64() // high watermark
+			d.int64() // last stable offset
+			for range d.arrayLen() {
+				d.int64() // aborted transaction producer
+				d.int64() // and first offset
+			}
+			data := d.bytes()
+			if d.err != nil {
+				return d.err
+			}
+
+			switch {
+			case code == kafkaOffsetOutOfRange:
+				// The records were deleted before they were consumed
+				offset, err := q.earliestOffset(ctx, partition)
+				if err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				q.mu.Lock()
+				q.positions[partition] = offset
+				q.mu.Unlock()
+				continue
+			case code != 0:
+				if staleMetadata(code) {
+					q.client.forgetMetadata()
+				}
+				errs = append(errs, fmt.Errorf("partition %d: %w", partition, kafkaError(code)))
+				continue
+			}
+
+			records, err := decodeRecordBatches(data)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("partition %d: %w", partition, err))
+			}
+			q.buffer(partition, positions[partition], records)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// buffer queues fetched records from offset on; batches can start before
+// the offset asked for
+func (q *kafkaQueue) buffer(partition int32, from int64, records []kafkaRecord) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, r := range records {
+		if r.offset < from {
+			continue
+		}
+		q.buffered = append(q.buffered, kafkaQueued{partition: partition, record: r})
+		q.pending[partition] = append(q.pending[partition], r.offset)
+		q.positions[partition] = r.offset + 1
+	}
+}
+
+func (q *kafkaQueue) Ack(ctx context.Context, job *QueuedJob) error {
+	return q.finish(ctx, job)
+}
+
+func (q *kafkaQueue) Fail(ctx context.Context, job *QueuedJob) error {
+	return q.finish(ctx, job)
+}
+
+// finish marks a job done and commits the offset the partition is done up to
+func (q *kafkaQueue) finish(ctx context.Context, job *QueuedJob) error {
+	var partition int32
+	var offset int64
+	if _, err := fmt.Sscanf(job.handle, "%d:%d", &partition, &offset); err != nil {
+		return fmt.Errorf("kafka: bad job handle %q", job.handle)
+	}
+
+	q.mu.Lock()
+	pending := q.pending[partition]
+	if i := slices.Index(pending, offset); i >= 0 {
+		q.pending[partition] = slices.Delete(pending, i, i+1)
+	}
+	commit := q.positions[partition]
+	if len(q.pending[partition]) > 0 {
+		commit = q.pending[partition][0]
+	}
+	if commit <= q.committed[partition] {
+		q.mu.Unlock()
+		return nil // an earlier job of the partition is still running
+	}
+	q.mu.Unlock()
+
+	if err := q.commit(ctx, partition, commit); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	q.committed[partition] = max(q.committed[partition], commit)
+	q.mu.Unlock()
+	return nil
+}
+
+// commit stores the offset a partition resumes from
+func (q *kafkaQueue) commit(ctx context.Context, partition int32, offset int64) error {
+	coordinator, err := q.findCoordinator(ctx)
+	if err != nil {
+		return err
+	}
+
+	var e kafkaEncoder
+	e.string(q.group)
+	e.int32(-1) // generation: not a member
+	e.string("")
+	e.int64(-1) // broker's retention time
+	e.int32(1)
+	e.string(q.client.topic)
+	e.int32(1)
+	e.int32(partition)
+	e.int64(offset)
+	e.int16(-1) // no metadata
+
+	d, err := q.client.call(ctx, coordinator, kafkaOffsetCommit, e.b)
+	if err != nil {
+		return err
+	}
+	for range d.arrayLen() {
+		d.string() // topic
+		for range d.arrayLen() {
+			d.int32() // partition
+			if code := d.int16(); code != 0 {
+				if staleMetadata(code) {
+					q.mu.Lock()
+					q.coordinator = ""
+					q.mu.Unlock()
+				}
+				return fmt.Errorf("group %s: %w", q.group, kafkaError(code))
+			}
+		}
+	}
+	return d.err
+}
+
+// kafkaProducer publishes events to a Kafka topic. Events are keyed by
+// their input, so the events of one file stay in order.
+type kafkaProducer struct {
+	client *kafkaClient
+}
+
+// newKafkaProducer opens kafka://host[:port][,host...]/topic
+func newKafkaProducer(location string) (*kafkaProducer, error) {
+	client, _, err := parseKafkaURL(location)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaProducer{client: client}, nil
+}
+
+// produce writes one record and waits for every in-sync replica to have it.
+// It tries again once when the partition leader moved.
+func (p *kafkaProducer) produce(ctx context.Context, key, value []byte) error {
+	var err error
+	for range 2 {
+		if err = p.produceOnce(ctx, key, value); err == nil {
+			return nil
+		}
+		var code kafkaError
+		if !errors.As(err, &code) || !staleMetadata(int16(code)) {
+			return err
+		}
+		p.client.forgetMetadata()
+	}
+	return err
+}
+
+func (p *kafkaProducer) produceOnce(ctx context.Context, key, value []byte) error {
+	partitions, err := p.client.topicPartitions(ctx)
+	if err != nil {
+		return err
+	}
+	h := fnv.New32a()
+	h.Write(key)
+	partition := partitions[h.Sum32()%uint32(len(partitions))]
+
+	leader, err := p.client.leader(ctx, partition)
+	if err != nil {
+		return err
+	}
+
+	var e kafkaEncoder
+	e.int16(-1) // no transaction
+	e.int16(-1) // acks: all in-sync replicas
+	e.int32(int32(kafkaTimeout / time.Millisecond))
+	e.int32(1)
+	e.string(p.client.topic)
+	e.int32(1)
+	e.int32(partition)
+	e.bytes(encodeRecordBatch(key, value, time.Now()))
+
+	d, err := p.client.call(ctx, leader, kafkaProduce, e.b)
+	if err != nil {
+		return err
+	}
+	for range d.arrayLen() {
+		d.string() // topic
+		for range d.arrayLen() {
+			d.int32() // partition
+			code := d.int16()
+			d.int64() // base offset
+			d.int64() // log append time
+			if code != 0 {
+				return fmt.Errorf("topic %s: %w", p.client.topic, kafkaError(code))
+			}
+		}
+	}
+	return d.err
+}
+
+func (p *kafkaProducer) Publish(ctx context.Context, event JobEvent) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return p.produce(ctx, []byte(event.Input), value)
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKafkaBroker is a single in-memory Kafka broker with one topic, just
+// enough of the protocol for kafkaQueue and kafkaProducer
+type fakeKafkaBroker struct {
+	ln    net.Listener
+	topic string
+
+	mu sync.Mutex
+	// logs holds the record values of every partition by offset
+	logs map[int32][][]byte
+	// commits holds the committed offsets of every group
+	commits map[string]map[int32]int64
+}
+
+func newFakeKafkaBroker(t *testing.T, topic string, partitions int) *fakeKafkaBroker {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeKafkaBroker{ln: ln, topic: topic, logs: map[int32][][]byte{}, commits: map[string]map[int32]int64{}}
+	for p := range partitions {
+		b.logs[int32(p)] = nil
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *fakeKafkaBroker) addr() string { return b.ln.Addr().String() }
+
+func (b *fakeKafkaBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+
+		d := &kafkaDecoder{b: req}
+		apiKey := d.int16()
+		d.int16() // version
+		correlation := d.int32()
+		d.string() // client ID
+
+		var e kafkaEncoder
+		e.int32(0)
+		e.int32(correlation)
+		b.mu.Lock()
+		b.handle(apiKey, d, &e)
+		b.mu.Unlock()
+		binary.BigEndian.PutUint32(e.b, uint32(len(e.b)-4))
+		conn.Write(e.b)
+	}
+}
+
+func (b *fakeKafkaBroker) handle(apiKey int16, d *kafkaDecoder, e *kafkaEncoder) {
+	host, port, _ := net.SplitHostPort(b.addr())
+	portNum, _ := strconv.Atoi(port)
+
+	switch apiKey {
+	case kafkaMetadata:
+		e.int32(1)
+		e.int32(1)
+		e.string(host)
+		e.int32(int32(portNum))
+		e.int16(-1) // rack
+		e.int32(1)  // controller
+		e.int32(1)
+		e.int16(0)
+		e.string(b.topic)
+		e.int8(0)
+		e.int32(int32(len(b.logs)))
+		for p := range int32(len(b.logs)) {
+			e.int16(0)
+			e.int32(p)
+			e.int32(1) // leader
+			e.int32(1)
+			e.int32(1) // replicas
+			e.int32(1)
+			e.int32(1) // in-sync replicas
+		}
+
+	case kafkaFindCoordinator:
+		e.int32(0)
+		e.int16(0)
+		e.int16(-1)
+		e.int32(1)
+		e.string(host)
+		e.int32(int32(portNum))
+
+	case kafkaOffsetFetch:
+		group := d.string()
+		d.arrayLen()
+		topic := d.string()
+		e.int32(1)
+		e.string(topic)
+		n := d.arrayLen()
+		e.int32(int32(n))
+		for range n {
+			p := d.int32()
+			offset, ok := b.commits[group][p]
+			if !ok {
+				offset = -1
+			}
+			e.int32(p)
+			e.int64(offset)
+			e.int16(-1)
+			e.int16(0)
+		}
+
+	case kafkaOffsetCommit:
+		group := d.string()
+		d.int32()  // generation
+		d.string() // member
+		d.int64()  // retention
+		d.arrayLen()
+		topic := d.string()
+		e.int32(1)
+		e.string(topic)
+		n := d.arrayLen()
+		e.int32(int32(n))
+		for range n {
+			p := d.int32()
+			offset := d.int64()
+			d.string()
+			if b.commits[group] == nil {
+				b.commits[group] = map[int32]int64{}
+			}
+			b.commits[group][p] = offset
+			e.int32(p)
+			e.int16(0)
+		}
+
+	case kafkaListOffsets:
+		d.int32()
+		d.arrayLen()
+		topic := d.string()
+		d.arrayLen()
+		p := d.int32()
+		e.int32(1)
+		e.string(topic)
+		e.int32(1)
+		e.int32(p)
+		e.int16(0)
+		e.int64(-1)
+		e.int64(0) // nothing is ever deleted
+
+	case kafkaFetch:
+		d.next(4 + 4 + 4 + 4 + 1)
+		d.arrayLen()
+		topic := d.string()
+		e.int32(0)
+		e.int32(1)
+		e.string(topic)
+		n := d.arrayLen()
+		e.int32(int32(n))
+		for range n {
+			p := d.int32()
+			offset := d.int64()
+			d.int32()
+
+			var records []byte
+			for o := offset; o < int64(len(b.logs[p])); o++ {
+				batch := encodeRecordBatch(nil, b.logs[p][o], time.Now())
+				binary.BigEndian.PutUint64(batch, uint64(o))
+				records = append(records, batch...)
+			}
+			e.int32(p)
+			e.int16(0)
+			e.int64(int64(len(b.logs[p])))
+			e.int64(int64(len(b.logs[p])))
+			e.int32(-1) // no aborted transactions
+			e.bytes(records)
+		}
+
+	case kafkaProduce:
+		d.string() // transaction
+		d.int16()  // acks
+		d.int32()  // timeout
+		d.arrayLen()
+		topic := d.string()
+		d.arrayLen()
+		p := d.int32()
+		records, err := decodeRecordBatches(d.bytes())
+		code := int16(0)
+		if err != nil {
+			code = 2 // corrupt message
+		}
+		base := int64(len(b.logs[p]))
+		for _, r := range records {
+			b.logs[p] = append(b.logs[p], r.value)
+		}
+		e.int32(1)
+		e.string(topic)
+		e.int32(1)
+		e.int32(p)
+		e.int16(code)
+		e.int64(base)
+		e.int64(-1)
+		e.int32(0)
+	}
+}
+
+func TestKafkaRecordBatch(t *testing.T) {
+	batch := encodeRecordBatch([]byte("key"), []byte(`{"input":"a.wav"}`), time.Now())
+	second := encodeRecordBatch(nil, []byte("second"), time.Now())
+	binary.BigEndian.PutUint64(second, 7)
+	data := append(append([]byte{}, batch...), second...)
+
+	records, err := decodeRecordBatches(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || string(records[0].key) != "key" || string(records[0].value) != `{"input":"a.wav"}` ||
+		records[1].offset != 7 || string(records[1].value) != "second" {
+		t.Errorf("records = %+v", records)
+	}
+
+	// A batch cut off at the end of a fetch is left for the next one
+	records, err = decodeRecordBatches(data[:len(data)-3])
+	if err != nil || len(records) != 1 {
+		t.Errorf("truncated set: %d records, %v; want just the first", len(records), err)
+	}
+
+	corrupt := bytes.Clone(batch)
+	corrupt[len(corrupt)-2] ^= 0xff
+	if _, err := decodeRecordBatches(corrupt); err == nil {
+		t.Error("corrupt batch decoded without an error")
+	}
+}
+
+func TestKafkaQueue(t *testing.T) {
+	broker := newFakeKafkaBroker(t, "jobs", 2)
+	broker.logs[0] = [][]byte{[]byte("a"), []byte("b")}
+	broker.logs[1] = [][]byte{[]byte("c")}
+	ctx := context.Background()
+
+	q, err := openQueue("kafka://" + broker.addr() + "/jobs?group=renders")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobs := map[string]*QueuedJob{}
+	for len(jobs) < 3 {
+		job, err := q.Receive(ctx)
+		if err != nil {
+			t.Fatalf("Receive: %v", err)
+		}
+		if job == nil {
+			t.Fatalf("Receive returned nothing after %d jobs", len(jobs))
+		}
+		jobs[string(job.Body)] = job
+	}
+
+	// b finishes before a, so nothing of partition 0 can be committed yet
+	if err := q.Ack(ctx, jobs["b"]); err != nil {
+		t.Fatal(err)
+	}
+	broker.mu.Lock()
+	if _, ok := broker.commits["renders"][0]; ok {
+		t.Errorf("committed partition 0 past a running job: %v", broker.commits)
+	}
+	broker.mu.Unlock()
+
+	if err := q.Ack(ctx, jobs["a"]); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Fail(ctx, jobs["c"]); err != nil {
+		t.Fatal(err)
+	}
+	broker.mu.Lock()
+	if got := broker.commits["renders"]; got[0] != 2 || got[1] != 1 {
+		t.Errorf("commits = %v, want partition 0 at 2 and 1 at 1", got)
+	}
+	broker.logs[1] = append(broker.logs[1], []byte("d"))
+	broker.mu.Unlock()
+
+	// Another consumer of the group resumes after what was committed
+	resumed, err := openQueue("kafka://" + broker.addr() + "/jobs?group=renders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := resumed.Receive(ctx)
+	if err != nil || job == nil || string(job.Body) != "d" {
+		t.Fatalf("resumed Receive = %v, %v; want d", job, err)
+	}
+	if job, err := resumed.Receive(ctx); job != nil || err != nil {
+		t.Errorf("Receive at the end = %v, %v, want nil, nil", job, err)
+	}
+}
+
+func TestKafkaQueuePartitions(t *testing.T) {
+	broker := newFakeKafkaBroker(t, "jobs", 2)
+	broker.logs[0] = [][]byte{[]byte("a")}
+	broker.logs[1] = [][]byte{[]byte("b")}
+
+	q, err := openQueue("kafka://" + broker.addr() + "/jobs?partitions=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := q.Receive(context.Background())
+	if err != nil || job == nil || string(job.Body) != "b" {
+		t.Fatalf("Receive = %v, %v; want b", job, err)
+	}
+	if job, err := q.Receive(context.Background()); job != nil || err != nil {
+		t.Errorf("Receive = %v, %v; want nothing from partition 0", job, err)
+	}
+}
+
+func TestKafkaProducer(t *testing.T) {
+	broker := newFakeKafkaBroker(t, "events", 3)
+	sink, err := openEventSink("kafka://" + broker.addr() + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := JobEvent{FileResult: FileResult{Input: "s3://media/a.wav", Outputs: []string{"s3://media/out/a.png"}}, Output: "s3://media/out"}
+	for range 2 {
+		if err := sink.Publish(context.Background(), event); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	var published [][]byte
+	for _, log := range broker.logs {
+		if len(log) > 0 && published != nil {
+			t.Error("events of one input went to different partitions")
+		}
+		published = append(published, log...)
+	}
+	if len(published) != 2 {
+		t.Fatalf("published %d events, want 2", len(published))
+	}
+	var got JobEvent
+	if err := json.Unmarshal(published[0], &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Input != event.Input || got.Output != event.Output || len(got.Outputs) != 1 {
+		t.Errorf("event = %+v, want %+v", got, event)
+	}
+}
+
+func TestParseKafkaURL(t *testing.T) {
+	c, query, err := parseKafkaURL("kafka://a.example.com,b.example.com:9093/jobs?group=g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.bootstrap) != 2 || c.bootstrap[0] != "a.example.com:9092" || c.bootstrap[1] != "b.example.com:9093" {
+		t.Errorf("bootstrap = %v", c.bootstrap)
+	}
+	if c.topic != "jobs" || query.Get("group") != "g" {
+		t.Errorf("topic %q, group %q", c.topic, query.Get("group"))
+	}
+
+	for _, bad := range []string{"kafka://host", "kafka:///topic", "redis://host/topic"} {
+		if _, _, err := parseKafkaURL(bad); err == nil {
+			t.Errorf("parseKafkaURL(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+)
+
+const (
+	// loudnessSubBlock is the hop between loudness measurements (100 ms)
+	loudnessSubBlock = 0.1
+	// momentaryBlocks and shortTermBlocks are the window lengths in sub-blocks
+	momentaryBlocks = 4  // 400 ms
+	shortTermBlocks = 30 // 3 s
+
+	absoluteGateLUFS = -70.0
+	integratedGateLU = -10.0 // relative gate for integrated loudness
+	rangeGateLU      = -20.0 // relative gate for loudness range
+)
+
+// biquad is a direct form II transposed second-order IIR filter
+type biquad struct {
+	b0, b1, b2 float64
+	a1, a2     float64
+	z1, z2     float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+// kWeighting returns the ITU-R BS.1770 pre-filter and RLB high-pass for a
+// sample rate, derived the same way as libebur128
+func kWeighting(sampleRate float64) (biquad, biquad) {
+	f0 := 1681.974450955533
+	g := 3.999843853973347
+	q := 0.7071752369554196
+
+	k := math.Tan(math.Pi * f0 / sampleRate)
+	vh := math.Pow(10, g/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+
+	shelf := biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	f0 = 38.13547087602444
+	q = 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / sampleRate)
+	a0 = 1 + k/q + k*k
+
+	highpass := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	return shelf, highpass
+}
+
+// energyToLUFS converts a mean-square channel sum to loudness
+func energyToLUFS(energy float64) float64 {
+	return -0.691 + 10*math.Log10(energy)
+}
+
+// finiteOrNil returns nil for values JSON can't hold, such as the -Inf
+// loudness of digital silence
+func finiteOrNil(v float64) *float64 {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return nil
+	}
+	return &v
+}
+
+// LoudnessReport is the "loudness" section of a file report. Values are nil
+// when the audio is too quiet to measure.
+type LoudnessReport struct {
+	IntegratedLUFS   *float64 `json:"integrated_lufs"`
+	LoudnessRangeLU  *float64 `json:"loudness_range_lu"`
+	MaxMomentaryLUFS *float64 `json:"max_momentary_lufs"`
+	MaxShortTermLUFS *float64 `json:"max_short_term_lufs"`
+}
+
+// loudnessAnalyzer measures EBU R128 loudness
+type loudnessAnalyzer struct {
+	filters [2][2]biquad // shelf and high-pass per channel
+	// mono counts only the left channel, as BS.1770 measures a single
+	// channel, where the right one repeats it
+	mono bool
+
+	subBlockFrames int
+	subBlockPos    int
+	sum            [2]float64 // K-weighted squares of the current sub-block
+	subBlocks      []float64  // mean-square channel sum of each 100 ms sub-block
+}
+
+func newLoudnessAnalyzer(cfg AnalysisConfig) Analyzer {
+	return &loudnessAnalyzer{}
+}
+
+// Start implements Analyzer
+func (a *loudnessAnalyzer) Start(info StreamInfo) {
+	rate := float64(info.SampleRate)
+	for ch := range a.filters {
+		shelf, highpass := kWeighting(rate)
+		a.filters[ch] = [2]biquad{shelf, highpass}
+	}
+	a.mono = info.Mono
+	a.subBlockFrames = max(1, int(loudnessSubBlock*rate))
+	a.subBlocks = make([]float64, 0, info.NumFrames/a.subBlockFrames+1)
+}
+
+// memoryEstimate implements memoryUser: one energy per 100 ms sub-block,
+// plus the block energies and gated copies Result builds from them
+func (a *loudnessAnalyzer) memoryEstimate(info StreamInfo) int64 {
+	return 4 * windowCount(info, loudnessSubBlock) * 8
+}
+
+// Add implements Analyzer
+func (a *loudnessAnalyzer) Add(left, right []int16) {
+	for i := range left {
+		a.sum[0] += a.weigh(0, left[i])
+		if right != nil && !a.mono {
+			a.sum[1] += a.weigh(1, right[i])
+		}
+
+		a.subBlockPos++
+		if a.subBlockPos == a.subBlockFrames {
+			n := float64(a.subBlockFrames)
+			a.subBlocks = append(a.subBlocks, a.sum[0]/n+a.sum[1]/n)
+			a.sum = [2]float64{}
+			a.subBlockPos = 0
+		}
+	}
+}
+
+// weigh filters one sample of a channel and returns its square
+func (a *loudnessAnalyzer) weigh(ch int, v int16) float64 {
+	f := &a.filters[ch]
+	y := f[1].process(f[0].process(float64(v) / 32768.0))
+	return y * y
+}
+
+// windowEnergies returns the mean energy of every window of n sub-blocks,
+// advancing one sub-block at a time
+func (a *loudnessAnalyzer) windowEnergies(n int) []float64 {
+	if len(a.subBlocks) < n {
+		return nil
+	}
+
+	energies := make([]float64, 0, len(a.subBlocks)-n+1)
+	sum := 0.0
+	for i, e := range a.subBlocks {
+		sum += e
+		if i >= n {
+			sum -= a.subBlocks[i-n]
+		}
+		if i >= n-1 {
+			energies = append(energies, math.Max(sum, 0)/float64(n))
+		}
+	}
+	return energies
+}
+
+// Result implements Analyzer
+func (a *loudnessAnalyzer) Result() any {
+	momentary := a.windowEnergies(momentaryBlocks)
+	shortTerm := a.windowEnergies(shortTermBlocks)
+
+	return LoudnessReport{
+		IntegratedLUFS:   finiteOrNil(integratedLoudness(momentary)),
+		LoudnessRangeLU:  finiteOrNil(loudnessRange(shortTerm)),
+		MaxMomentaryLUFS: finiteOrNil(maxLoudness(momentary)),
+		MaxShortTermLUFS: finiteOrNil(maxLoudness(shortTerm)),
+	}
+}
+
+// gate returns the energies whose loudness is above the absolute gate and
+// within relativeLU of their mean
+func gate(energies []float64, relativeLU float64) []float64 {
+	var aboveAbsolute []float64
+	sum := 0.0
+	for _, e := range energies {
+		if energyToLUFS(e) > absoluteGateLUFS {
+			aboveAbsolute = append(aboveAbsolute, e)
+			sum += e
+		}
+	}
+	if len(aboveAbsolute) == 0 {
+		return nil
+	}
+
+	relativeGate := energyToLUFS(sum/float64(len(aboveAbsolute))) + relativeLU
+
+	var gated []float64
+	for _, e := range aboveAbsolute {
+		if energyToLUFS(e) > relativeGate {
+			gated = append(gated, e)
+		}
+	}
+	return gated
+}
+
+// integratedLoudness gates the 400 ms block energies as EBU R128 specifies
+func integratedLoudness(momentary []float64) float64 {
+	gated := gate(momentary, integratedGateLU)
+	if len(gated) == 0 {
+		return math.Inf(-1)
+	}
+
+	sum := 0.0
+	for _, e := range gated {
+		sum += e
+	}
+	return energyToLUFS(sum / float64(len(gated)))
+}
+
+// loudnessRange is the spread between the 10th and 95th percentile of gated
+// short-term loudness (EBU Tech 3342)
+func loudnessRange(shortTerm []float64) float64 {
+	gated := gate(shortTerm, rangeGateLU)
+	if len(gated) == 0 {
+		return math.Inf(-1)
+	}
+
+	sort.Float64s(gated)
+	low := gated[int(math.Round(float64(len(gated)-1)*0.10))]
+	high := gated[int(math.Round(float64(len(gated)-1)*0.95))]
+
+	return energyToLUFS(high) - energyToLUFS(low)
+}
+
+// maxLoudness returns the loudest window
+func maxLoudness(energies []float64) float64 {
+	loudest := math.Inf(-1)
+	for _, e := range energies {
+		loudest = math.Max(loudest, energyToLUFS(e))
+	}
+	return loudest
+}
+
+// captionMargin is the gap in pixels between the loudness caption and the
+// image edges
+const captionMargin = 4
+
+// caption returns the text of the loudness caption, such as
+// "-14.2 LUFS  LRA 5.1 LU". Files too quiet to gate read "-INF LUFS".
+func (r LoudnessReport) caption() string {
+	text := "-INF LUFS"
+	if r.IntegratedLUFS != nil {
+		text = fmt.Sprintf("%.1f LUFS", *r.IntegratedLUFS)
+	}
+	if r.LoudnessRangeLU != nil {
+		text += fmt.Sprintf("  LRA %.1f LU", *r.LoudnessRangeLU)
+	}
+	return text
+}
+
+// drawOverlay implements imageOverlay, captioning the top left corner with
+// the integrated loudness and loudness range. The text is doubled in size on
+// images tall enough for it.
+func (a *loudnessAnalyzer) drawOverlay(img *image.RGBA) {
+	bounds := img.Bounds()
+	scale := 1
+	if bounds.Dy() >= 200 {
+		scale = 2
+	}
+
+	text := a.Result().(LoudnessReport).caption()
+	drawCaption(img, bounds.Min.Add(image.Pt(captionMargin, captionMargin)), text, waveformColor, backgroundColor, scale)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// loudnessOf measures stretches of a 1 kHz tone at 48 kHz, each given as
+// its seconds and level in dBFS (silence below -200), fed to both channels
+func loudnessOf(mono bool, stretches ...[2]float64) LoudnessReport {
+	const rate = 48000
+	a := &loudnessAnalyzer{}
+	a.Start(StreamInfo{SampleRate: rate, Mono: mono})
+
+	frame := 0
+	for _, s := range stretches {
+		amplitude := 0.0
+		if s[1] > -200 {
+			amplitude = math.Pow(10, s[1]/20) * 32767
+		}
+		samples := make([]int16, int(s[0]*rate))
+		for i := range samples {
+			samples[i] = int16(math.Round(amplitude * math.Sin(2*math.Pi*1000*float64(frame)/rate)))
+			frame++
+		}
+		for len(samples) > 0 {
+			n := min(len(samples), 4096)
+			a.Add(samples[:n], samples[:n])
+			samples = samples[n:]
+		}
+	}
+	return a.Result().(LoudnessReport)
+}
+
+func TestLoudness(t *testing.T) {
+	near := func(name string, got *float64, want, tolerance float64) {
+		t.Helper()
+		if got == nil || math.Abs(*got-want) > tolerance {
+			t.Errorf("%s = %v, want %.1f", name, valueOr(got), want)
+		}
+	}
+
+	// EBU Tech 3341 cases 1 and 3: a stereo tone at -23 dBFS, and one
+	// between quieter stretches the relative gate leaves out
+	r := loudnessOf(false, [2]float64{20, -23})
+	near("integrated loudness of -23 dBFS stereo", r.IntegratedLUFS, -23, 0.1)
+	near("momentary maximum", r.MaxMomentaryLUFS, -23, 0.1)
+	near("short-term maximum", r.MaxShortTermLUFS, -23, 0.1)
+	r = loudnessOf(false, [2]float64{10, -36}, [2]float64{60, -23}, [2]float64{10, -36})
+	near("integrated loudness with quiet stretches", r.IntegratedLUFS, -23, 0.1)
+
+	// A single channel counts once, though it comes in as both
+	r = loudnessOf(true, [2]float64{20, -20})
+	near("integrated loudness of -20 dBFS mono", r.IntegratedLUFS, -23, 0.1)
+
+	// Silence is below the absolute gate
+	r = loudnessOf(false, [2]float64{10, -23}, [2]float64{10, -300})
+	near("integrated loudness with silence", r.IntegratedLUFS, -23, 0.1)
+	if r := loudnessOf(false, [2]float64{5, -300}); r.IntegratedLUFS != nil || r.MaxMomentaryLUFS != nil {
+		t.Errorf("silence measured %v LUFS", valueOr(r.IntegratedLUFS))
+	}
+
+	// EBU Tech 3342 case 1: 20 s at -20 dBFS and 20 s at -30 dBFS
+	r = loudnessOf(false, [2]float64{20, -20}, [2]float64{20, -30})
+	near("loudness range", r.LoudnessRangeLU, 10, 1)
+}
+
+// valueOr returns what v points to, or NaN for nil
+func valueOr(v *float64) float64 {
+	if v == nil {
+		return math.NaN()
+	}
+	return *v
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
-	"encoding/binary"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
 	"image/png"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
@@ -14,42 +18,416 @@ import (
 	"time"
 )
 
-// WAVHeader represents the header of a WAV file
-type WAVHeader struct {
-	ChunkID       [4]byte
-	ChunkSize     uint32
-	Format        [4]byte
-	SubChunk1ID   [4]byte
-	SubChunk1Size uint32
-	AudioFormat   uint16
-	NumChannels   uint16
-	SampleRate    uint32
-	ByteRate      uint32
-	BlockAlign    uint16
-	BitsPerSample uint16
-	SubChunk2ID   [4]byte
-	SubChunk2Size uint32
-}
+var (
+	backgroundColor = color.RGBA{255, 255, 255, 255} // White background
+	waveformColor   = color.RGBA{0, 0, 0, 255}       // Black waveform
+)
+
+// Options configures waveform generation
+type Options struct {
+	Width  int
+	Height int
+
+	// MaxWidth bounds the width images are drawn at (0 is unbounded). Wider
+	// renders are drawn MaxWidth wide and, when Oversize is OversizeTile,
+	// also as full resolution tiles of at most MaxWidth each.
+	MaxWidth int
+	Oversize string
+
+	// CacheDir holds cached peaks; an empty CacheDir disables caching
+	CacheDir string
+
+	// PreCmd and PostCmd are command templates run before decoding and after
+	// writing each file, e.g. "optipng {output}"; empty disables them
+	PreCmd  string
+	PostCmd string
+
+	// Incremental keeps fixed-resolution peaks of growing files in the cache
+	// and only decodes what was appended since the last run
+	Incremental bool
+
+	// Analyses names the analyzers run while decoding; their results are
+	// written to a JSON report next to each image
+	Analyses []string
+	Analysis AnalysisConfig
+
+	// RMSWindow exports per-window RMS to <name>.rms.<RMSFormat> (json or
+	// csv) when non-zero
+	RMSWindow time.Duration
+	RMSFormat string
+
+	// SpectrumSize exports the average magnitude spectrum over FFT frames
+	// of this many samples to <name>.spectrum.<SpectrumFormat> (json or
+	// csv); 0 disables it. SpectrumWindows adds the spectrum of every frame.
+	SpectrumSize    int
+	SpectrumWindows bool
+	SpectrumFormat  string
+
+	// RemoveDC subtracts each channel's mean before rendering
+	RemoveDC bool
+
+	// CorrelationStrip draws stereo correlation in a strip below the waveform
+	CorrelationStrip bool
+
+	// TrimMarkers draws the suggested trim points over the waveform
+	TrimMarkers bool
+
+	// ColorByFrequency colors the waveform by the dominant frequency band
+	ColorByFrequency bool
+
+	// AutoRange scales the waveform to the file's loudest peak, leaving
+	// HeadroomDB above it, and annotates the scale used
+	AutoRange  bool
+	HeadroomDB float64
+
+	// Gain, when set, replaces the scale fitted to each file by a common
+	// one; the batch sets it with -common-scale
+	Gain float64
+
+	// Colormap colors the waveform by level, or the frequency bands of
+	// ColorByFrequency from low to high; nil keeps the default colors
+	Colormap *Colormap
+
+	// Scale is the amplitude scale of the image, ScaleLinear or ScaleDB.
+	// Profiles are rendered as extra images from the same peaks.
+	Scale    string
+	Profiles []RenderProfile
+
+	// Channels selects the channels decoded and mixed into the rendered
+	// one; nil renders the left channel
+	Channels *ChannelSelection
+
+	// SplitChannels also renders every channel of a file on its own,
+	// named by the speaker it feeds
+	SplitChannels bool
+
+	// Aggregation collapses the samples of each bucket; nil keeps their
+	// min and max
+	Aggregation *Aggregation
+
+	// TiersOver, when set, adds zoomed-in detail images of every TierLength
+	// of files longer than it, next to the overview image
+	TiersOver  time.Duration
+	TierLength time.Duration
+
+	// ScrubInterval, when set, adds a scrub strip of every file: a
+	// ScrubWidth x ScrubHeight mini-waveform of every ScrubInterval side by
+	// side in <name>.scrub.png, indexed by <name>.scrub.json
+	ScrubInterval           time.Duration
+	ScrubWidth, ScrubHeight int
+
+	// PreviewClip, when set, also cuts the loudest PreviewClip of every
+	// file, as its peaks show it, into <name>.preview.<PreviewFormat>,
+	// encoded by PreviewCmd when the format is compressed
+	PreviewClip   time.Duration
+	PreviewFormat string
+	PreviewCmd    string
+
+	// ShadeSegments tints the background by speech/music/silence segment
+	ShadeSegments bool
+
+	// HistogramPanel draws the amplitude histogram beside the waveform
+	HistogramPanel bool
+
+	// LoudnessCaption captions the image with the integrated loudness
+	LoudnessCaption bool
+
+	// Annotations is a file of labelled markers and regions to draw over
+	// the waveform; {name} is replaced by the input's base name
+	Annotations string
+
+	// Transcript is a file of timed words and sentences to draw in a strip
+	// below the waveform, named as Annotations is
+	Transcript string
+
+	// SamplesPerPixel, when set, fixes the bucket size instead of fitting
+	// the file into Width buckets, and images are as wide as the file needs;
+	// MaxBuckets then raises it as far as needed to keep the number of
+	// buckets within bounds (0 is unbounded)
+	SamplesPerPixel int
+	MaxBuckets      int
+
+	// Exact draws one bucket of min and max to a column, never
+	// interpolating or merging them, and writes <name>.columns.json saying
+	// which frames every column covers
+	Exact bool
+
+	// Start and End, when set, limit the render to that stretch of each
+	// file; an End of 0 is the end of the file. Remote inputs that can be
+	// read in parts only have that stretch downloaded.
+	Start, End time.Duration
+
+	// UseMmap decodes memory-mapped files instead of using buffered reads
+	UseMmap bool
+
+	// Checksums, when set, holds the SHA-256 checksums inputs must match
+	// to be processed; with it or HashInputs the checksum of every input is
+	// recorded in its result
+	Checksums  *ChecksumManifest
+	HashInputs bool
 
-// AudioData holds separated channel data
-type AudioData struct {
-	LeftChannel  []float64
-	RightChannel []float64
-	SampleRate   uint32
+	// Decrypt decrypts encrypted inputs as they are decoded, so their audio
+	// is never written to disk; nil fails them
+	Decrypt *InputCipher
+
+	// Memory bounds the memory held across all workers; nil is unlimited
+	Memory *MemoryBudget
+
+	// Disk stops a batch before it runs out of disk space or output quota;
+	// nil is unlimited
+	Disk *DiskGuard
+
+	// OnError is what a batch does about failing files; nil continues
+	OnError *ErrorPolicy
+
+	// Compression is the PNG compression level of written images
+	Compression png.CompressionLevel
+
+	// Palette writes indexed-color PNGs, much smaller for the few flat
+	// colors of most waveforms
+	Palette bool
+
+	// Backend draws the images; nil uses the CPU renderer
+	Backend RenderBackend
+
+	// Progress receives decode/render progress; may be nil
+	Progress *Progress
 }
 
+// jsMain replaces the command line in the WebAssembly build (wasm.go)
+var jsMain func()
+
 func main() {
+	if jsMain != nil {
+		jsMain()
+		return
+	}
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "gen":
+			if err := runGen(os.Args[2:]); err != nil {
+				fmt.Printf("Error generating test audio: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "selftest":
+			os.Exit(runSelftest(os.Args[2:]))
+		case "diff":
+			if err := runDiff(os.Args[2:]); err != nil {
+				fmt.Printf("Diff failed: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "stitch":
+			if err := runStitch(os.Args[2:]); err != nil {
+				fmt.Printf("Stitch failed: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "generate":
+			if err := runGenerate(os.Args[2:]); err != nil {
+				// stdout may be carrying the image
+				fmt.Fprintf(os.Stderr, "Generate failed: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "encrypt":
+			if err := runEncrypt(os.Args[2:]); err != nil {
+				fmt.Printf("Encrypt failed: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "render-peaks":
+			if err := runRenderPeaks(os.Args[2:]); err != nil {
+				fmt.Printf("Render failed: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "album":
+			if err := runAlbum(os.Args[2:]); err != nil {
+				fmt.Printf("Album failed: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "serve":
+			if err := runServe(os.Args[2:]); err != nil {
+				fmt.Printf("Server failed: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "preview":
+			if err := runPreview(os.Args[2:]); err != nil {
+				fmt.Printf("Preview failed: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "capture":
+			if err := runCapture(os.Args[2:]); err != nil {
+				fmt.Printf("Capture failed: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "worker":
+			if err := runWorker(os.Args[2:]); err != nil {
+				fmt.Printf("Worker failed: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "daemon":
+			if err := runDaemon(os.Args[2:]); err != nil {
+				fmt.Printf("Daemon failed: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	inputPath := flag.String("input", "./audios", "directory or s3://, gs:// or az:// bucket/prefix containing WAV files")
+	outputDir := flag.String("output", "./waveforms", "directory or s3://, gs:// or az:// bucket/prefix to write waveform images to")
+	verboseFlag := flag.Bool("verbose", false, "print the header details of every file")
+	storageConcurrency := flag.Int("storage-concurrency", 4, "concurrent downloads and uploads for remote storage")
+	retries, retryDelay := retryFlags(flag.CommandLine)
+	maxMemory := flag.String("max-memory", "", "limit on memory held across all workers, e.g. 512MB (unlimited when empty)")
+	minFreeSpace := flag.String("min-free-space", "", "stop starting files once the output volume has less than this free, e.g. 1GB (unchecked when empty)")
+	outputQuota := flag.String("output-quota", "", "stop starting files once the outputs written reach this size, e.g. 50GB (unlimited when empty)")
+	cpuProfile := flag.String("cpuprofile", "", "write a CPU profile to this file")
+	memProfile := flag.String("memprofile", "", "write a heap profile to this file when the batch finishes")
+	metricsAddr := flag.String("metrics-addr", "", "serve Prometheus metrics on this address while running, e.g. localhost:9090")
+	pprofAddr := flag.String("pprof-addr", "", "serve net/http/pprof on this address while running, e.g. localhost:6060")
+	webhookURL := flag.String("webhook", "", "POST a JSON event about every file to this URL when it is done")
+	sinks := flag.String("sinks", "", "also copy every file's outputs to these comma-separated locations: directories, s3://, gs://, az://, ftp:// or sftp:// prefixes, or http(s):// URLs that are POSTed a JSON event holding them")
+	webhookBatch := flag.Bool("webhook-batch", false, "POST one event about the whole batch when it is done instead")
+	historyDB := flag.String("db", "", "record every processed file in this SQLite database (needs the sqlite3 command)")
+	commonScale := flag.Bool("common-scale", false, "draw every file on one amplitude scale, fitted to the loudest of the batch, so the images compare; measures every file before rendering any")
+	targetLUFS := flag.Float64("target-lufs", 0, "with -common-scale, compare the files as if loudness normalized to this integrated loudness, e.g. -23 (disabled when 0)")
+	onError := flag.String("on-error", OnErrorContinue, "what to do about a failing file: continue (keep the outputs it did produce), skip-file (drop all its outputs) or abort (start no further files)")
+	quarantine := flag.String("quarantine", "", "copy failing inputs to this directory and list them with their errors in its "+quarantineList)
+	manifest := flag.String("manifest", "", "write a JSON manifest of every input's SHA-256 and outputs to this file when the batch finishes")
+	skipDone := flag.Bool("skip-done", false, "skip files the -db history shows were processed completely with the same content and options")
+	buildOptions := optionFlags(flag.CommandLine)
+	if err := parseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(2)
+	}
+	verbose = *verboseFlag
+
+	stopProfiling, err := startProfiling(*cpuProfile, *memProfile)
+	if err != nil {
+		fmt.Printf("Error starting profiling: %v\n", err)
+		return
+	}
+	defer stopProfiling()
+
+	if *pprofAddr != "" {
+		servePprof(*pprofAddr)
+	}
+	if *metricsAddr != "" {
+		serveMetrics(*metricsAddr)
+	}
+
+	opts, err := buildOptions()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	if *maxMemory != "" {
+		limit, err := parseByteSize(*maxMemory)
+		if err != nil {
+			fmt.Printf("Error parsing -max-memory: %v\n", err)
+			return
+		}
+		opts.Memory = NewMemoryBudget(limit)
+	}
+
+	if opts.OnError, err = NewErrorPolicy(*onError, *quarantine); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	batch, err := newStorageBatch(*inputPath, *outputDir, *storageConcurrency)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	defer batch.Close()
+	batch.retry = RetryPolicy{Retries: *retries, Delay: *retryDelay}
+	if batch.sinks, err = openOutputSinks(*sinks, batch.retry); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
 
-	// inputFile := "input.wav" // Change this to your WAV file path
-	inputPath := "./audios"
-	outputDir := "./waveforms"
-	width := 1920
-	height := 640
+	if *minFreeSpace != "" || *outputQuota != "" {
+		var minFree, quota int64
+		if *minFreeSpace != "" {
+			if minFree, err = parseByteSize(*minFreeSpace); err != nil {
+				fmt.Printf("Error parsing -min-free-space: %v\n", err)
+				return
+			}
+		}
+		if *outputQuota != "" {
+			if quota, err = parseByteSize(*outputQuota); err != nil {
+				fmt.Printf("Error parsing -output-quota: %v\n", err)
+				return
+			}
+		}
+		// Remote outputs are staged in the work directory before upload
+		writeDir := *outputDir
+		if batch.remoteOutput() {
+			writeDir = batch.workDir
+		}
+		opts.Disk = NewDiskGuard(writeDir, minFree, quota)
+		if err := opts.Disk.Check(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+	}
 
-	// Read directory contents
-	files, err := os.ReadDir(inputPath)
+	inputs, err := batch.listInputs()
 	if err != nil {
-		fmt.Printf("Error reading directory: %v\n", err)
+		fmt.Printf("Error listing input files: %v\n", err)
+		return
+	}
+
+	var wavNames []string
+	for _, obj := range inputs {
+		if isInputName(obj.Name) {
+			wavNames = append(wavNames, obj.Name)
+		}
+	}
+	batch.assignOutputNames(wavNames)
+
+	run := &batchRun{outputDir: *outputDir, batch: batch, opts: opts, webhookBatch: *webhookBatch}
+	if *webhookURL != "" {
+		run.webhook = newWebhookSink(*webhookURL)
+	}
+	if *historyDB != "" {
+		run.history, err = OpenHistory(*historyDB)
+		if err != nil {
+			fmt.Printf("Error opening -db: %v\n", err)
+			return
+		}
+		run.skipDone = *skipDone
+		run.options = strings.Join(optionArgs(flag.CommandLine), " ")
+	} else if *skipDone {
+		fmt.Printf("Error: -skip-done requires -db\n")
+		return
+	}
+
+	if *manifest != "" {
+		run.manifest = &outputManifest{}
+		run.opts.HashInputs = true
+	}
+
+	switch {
+	case *targetLUFS != 0 && !*commonScale:
+		fmt.Printf("Error: -target-lufs requires -common-scale\n")
+		return
+	case *commonScale && opts.AutoRange:
+		fmt.Printf("Error: -common-scale and -auto-range can't be combined\n")
 		return
 	}
 
@@ -57,18 +435,28 @@ func main() {
 
 	startTime := time.Now()
 
-	for _, file := range files {
+	if *commonScale {
+		var scale float64
+		run.gains, scale = run.commonScale(inputs, *targetLUFS)
+		if *targetLUFS != 0 {
+			fmt.Printf("Common scale: %s at %g LUFS\n", scaleCaption(scale), *targetLUFS)
+		} else {
+			fmt.Printf("Common scale: %s\n", scaleCaption(scale))
+		}
+	}
 
-		fileName := file.Name()
+	for _, obj := range inputs {
 
-		if !strings.HasSuffix(strings.ToLower(fileName), ".wav") {
+		if !isInputName(obj.Name) {
 			continue // Skip non-WAV files
 		}
 
-		inputFile := filepath.Join(inputPath, fileName)
-
 		wg.Add(1)
-		go GenerateStereoWaveforms(inputFile, outputDir, fileName, width, height, &wg)
+		queueDepth.add(1)
+		go func() {
+			defer wg.Done()
+			run.process(obj)
+		}()
 	}
 
 	wg.Wait()
@@ -76,288 +464,1254 @@ func main() {
 	endTime := time.Now()
 	totalTime := time.Since(startTime)
 
+	if run.webhook != nil && run.webhookBatch {
+		summary := BatchEvent{Input: *inputPath, Output: *outputDir, Files: run.events, Elapsed: totalTime.Seconds(), Finished: endTime.UTC()}
+		summary.Formatted.Elapsed = formatDuration(totalTime)
+		for _, event := range run.events {
+			if event.Error != "" {
+				summary.Failed++
+			}
+			if event.Transient {
+				summary.Transient++
+			}
+		}
+		if err := run.webhook.post(context.Background(), summary); err != nil {
+			fmt.Printf("failed to send webhook: %v\n", err)
+		}
+	}
+
+	if run.manifest != nil {
+		if err := run.manifest.write(*manifest); err != nil {
+			fmt.Printf("failed to write manifest: %v\n", err)
+		} else {
+			fmt.Printf("\nManifest: %s\n", *manifest)
+		}
+	}
+
 	fmt.Printf("\nTime Start: %v\n", startTime)
 	fmt.Printf("\nTime End: %v\n", endTime)
 
-	fmt.Printf("\nTime Taken: %v \n", totalTime)
+	fmt.Printf("\nTime Taken: %s\n", formatDuration(totalTime))
+
+	if run.failed > 0 {
+		fmt.Printf("\nFailed: %d files, %d transient (storage failures that outlasted their retries; worth running again) and %d permanent\n",
+			run.failed, run.transient, run.failed-run.transient)
+	}
+
+	if err := opts.Disk.Aborted(); err != nil {
+		fmt.Printf("\nBatch aborted: %v\n", err)
+	}
+	if err := opts.OnError.Aborted(); err != nil {
+		fmt.Printf("\nBatch aborted by -on-error abort: %v\n", err)
+	}
+}
+
+// optionFlags registers the flags that shape how each file is processed on
+// fs and returns a function that builds the Options from them once fs has
+// been parsed
+func optionFlags(fs *flag.FlagSet) func() (Options, error) {
+	width := fs.Int("width", 1920, "image width in pixels")
+	height := fs.Int("height", 640, "image height in pixels")
+	maxWidth := fs.Int("max-width", defaultMaxWidth, "widest image drawn; wider renders are drawn this wide instead (0 is unlimited)")
+	samplesPerPixel := fs.Int("samples-per-pixel", 0, "frames each pixel covers, for images comparable across files; the width follows the file's length instead of -width (fitted to -width when 0)")
+	exact := fs.Bool("exact", false, "draw every column from exactly the frames of one bucket, with no interpolation or merging, and write the frames of every column to <name>.columns.json")
+	oversize := fs.String("oversize", OversizeTile, "what renders wider than -max-width also write: tile for full resolution <name>.tile-NNN.png tiles, or downscale for nothing more")
+	cacheDir := fs.String("cache-dir", "", "directory for cached peaks (disabled when empty)")
+	preCmd := fs.String("pre-cmd", "", "command run before each file; {input}, {output}, {name} and {dir} are substituted")
+	postCmd := fs.String("post-cmd", "", "command run after each generated file, e.g. 'optipng {output}'")
+	incremental := fs.Bool("incremental", false, "only decode audio appended since the last run (requires -cache-dir)")
+	useMmap := fs.Bool("mmap", false, "decode memory-mapped files (unix only)")
+	start := fs.Duration("start", 0, "render only the audio from this far into each file, e.g. 1h30m")
+	end := fs.Duration("end", 0, "render only the audio up to this far into each file (the end of the file when 0)")
+	checksums := fs.String("checksums", "", "verify inputs against this SHA-256 manifest, as sha256sum writes it, before processing them; unlisted or mismatching files fail")
+	decryptKey := fs.String("decrypt-key", "", "key file to decrypt inputs encrypted with the encrypt subcommand on the fly (AES-GCM; 16, 24 or 32 bytes, raw or in hex)")
+	analyze := fs.String("analyze", "", "comma-separated analyses to report as JSON next to each image (silence, clipping, loudness, true_peak, dc_offset, correlation, balance, dynamics, noise_floor, trim, dominant_frequency, fingerprint, segments, stats, histogram), or all")
+	silenceThreshold := fs.Float64("silence-threshold", -60, "level in dBFS below which audio counts as silence")
+	silenceMin := fs.Duration("silence-min", 500*time.Millisecond, "shortest internal silent gap to report")
+	clipThreshold := fs.Float64("clip-threshold", 0, "level in dBFS at or above which samples count as clipped")
+	imbalanceThreshold := fs.Float64("imbalance-threshold", 6, "RMS difference in dB between the channels at which a file is flagged as imbalanced")
+	noiseFloorLimit := fs.Float64("noise-floor-limit", -60, "noise floor in dBFS above which a file is flagged")
+	histogramBins := fs.Int("histogram-bins", 256, "number of bins of the amplitude histogram")
+	correlationThreshold := fs.Float64("correlation-threshold", -0.5, "stereo correlation below which windows are reported as out of phase")
+	correlationStrip := fs.Bool("correlation-strip", false, "draw stereo correlation in a strip below the waveform")
+	trimMarkers := fs.Bool("trim-markers", false, "draw the suggested trim-in and trim-out points over the waveform")
+	colorByFrequency := fs.Bool("color-by-frequency", false, "color the waveform by the dominant frequency band of each window of about half a second")
+	autoRange := fs.Bool("auto-range", false, "scale the waveform to the file's loudest peak and annotate the scale used, so quiet files stay legible")
+	headroom := fs.Float64("headroom", defaultHeadroomDB, "space in dB left above the loudest peak with -auto-range or -common-scale")
+	scale := fs.String("scale", ScaleLinear, "amplitude scale: linear, or db to show quiet passages")
+	profile := fs.String("profile", "", "comma-separated name:scale profiles rendered from the same decode as <name>.<profile>.png, e.g. overview:linear,detail:db")
+	channels := fs.String("channels", "", "channels to decode and render, mixed into one: L, R, channel numbers such as 1,3,5, speakers of the file's channel mask such as C or FL,FR, or mix for all (default L)")
+	splitChannels := fs.Bool("split-channels", false, "also render every channel on its own as <name>.<channel>.png, named by its speaker (FL, FR, C, LFE, SL, SR...) when the file has a channel mask, otherwise by number")
+	aggregate := fs.String("aggregate", "minmax", "how the samples of a pixel collapse: minmax, peak (largest magnitude), rms, or a percentile such as p95")
+	tiersOver := fs.Duration("tiers-over", 0, "for files longer than this, e.g. 2h, also render a zoomed-in <name>.zoom-NNN.png of every -tier-length (disabled when 0)")
+	tierLength := fs.Duration("tier-length", defaultTierLength, "stretch of audio each -tiers-over image covers")
+	scrubInterval := fs.Duration("scrub-interval", 0, "also render a scrub strip for hover previews: a mini-waveform of every this much audio, e.g. 10s, side by side in <name>.scrub.png with a <name>.scrub.json index (disabled when 0)")
+	scrubWidth := fs.Int("scrub-width", defaultScrubWidth, "width of each -scrub-interval mini-waveform in pixels")
+	scrubHeight := fs.Int("scrub-height", defaultScrubHeight, "height of each -scrub-interval mini-waveform in pixels")
+	previewClip := fs.Duration("preview-clip", 0, "also cut the loudest stretch of this length of every file, e.g. 15s, as chosen from its peaks, into <name>.preview.<format> for catalogs (disabled when 0)")
+	previewFormat := fs.String("preview-format", PreviewMP3, "format of -preview-clip: wav, or mp3 or opus encoded by -preview-cmd")
+	previewCmd := fs.String("preview-cmd", "", "command encoding -preview-clip, reading WAV on stdin and writing the clip to stdout (default ffmpeg for the format)")
+	colormap := fs.String("colormap", "", "color the waveform by level, or the bands of -color-by-frequency, with a colormap: viridis, magma, grayscale or comma-separated RRGGBB stops")
+	shadeSegments := fs.Bool("shade-segments", false, "tint the background of speech, music and silence segments")
+	histogramPanel := fs.Bool("histogram-panel", false, "draw the amplitude histogram in a panel beside the waveform")
+	loudnessCaption := fs.Bool("loudness-caption", false, "caption the image with the integrated loudness and loudness range")
+	transcript := fs.String("transcript", "", "mark the words and sentence boundaries of this transcript in a strip below the waveform: WebVTT (.vtt), SubRip (.srt) or JSON with word timestamps; {name} is replaced as in -annotations")
+	annotations := fs.String("annotations", "", "draw the labelled markers and regions of this file over the waveform: JSON, an Audacity label track (.txt) or a DAW marker export (.csv); {name} is replaced by each input's base name, e.g. markers/{name}.txt")
+	rmsWindow := fs.Duration("rms-window", 0, "export RMS over windows of this length, e.g. 100ms (disabled when 0)")
+	rmsFormat := fs.String("rms-format", "json", "RMS export format: json or csv")
+	spectrumSize := fs.Int("spectrum-size", 0, "export the average spectrum over FFT frames of this many samples, e.g. 4096 (disabled when 0)")
+	spectrumWindows := fs.Bool("spectrum-windows", false, "also export the spectrum of every FFT frame")
+	spectrumFormat := fs.String("spectrum-format", "json", "spectrum export format: json or csv")
+	removeDC := fs.Bool("remove-dc", false, "subtract the DC offset of each channel before rendering")
+	renderer := fs.String("renderer", "cpu", "rendering backend: cpu or rowmajor")
+	pngCompression := fs.String("png-compression", "default", "PNG compression: default, none, fast or best")
+	pngPalette := fs.Bool("png-palette", false, pngPaletteUsage)
+
+	return func() (Options, error) {
+		opts := Options{
+			Width:       *width,
+			Height:      *height,
+			CacheDir:    *cacheDir,
+			PreCmd:      *preCmd,
+			PostCmd:     *postCmd,
+			UseMmap:     *useMmap,
+			Incremental: *incremental,
+		}
+
+		var err error
+		if *width <= 0 || *height <= 0 || *maxWidth < 0 {
+			return Options{}, fmt.Errorf("-width and -height must be positive and -max-width must not be negative")
+		}
+		opts.MaxWidth = *maxWidth
+		if opts.SamplesPerPixel = *samplesPerPixel; opts.SamplesPerPixel < 0 {
+			return Options{}, fmt.Errorf("-samples-per-pixel must not be negative")
+		}
+		if opts.Oversize, err = parseOversize(*oversize); err != nil {
+			return Options{}, err
+		}
+
+		opts.Compression, err = parseCompressionLevel(*pngCompression)
+		opts.Palette = *pngPalette
+		if err != nil {
+			return Options{}, err
+		}
+
+		opts.Analyses, err = parseAnalyses(*analyze)
+		if err != nil {
+			return Options{}, err
+		}
+		opts.Analysis = DefaultAnalysisConfig()
+		opts.Analysis.SilenceThresholdDB = *silenceThreshold
+		opts.Analysis.SilenceMinDuration = *silenceMin
+		opts.Analysis.ClipThresholdDB = *clipThreshold
+		opts.Analysis.ImbalanceThresholdDB = *imbalanceThreshold
+		opts.Analysis.NoiseFloorLimitDB = *noiseFloorLimit
+		opts.Analysis.CorrelationThreshold = *correlationThreshold
+		opts.Analysis.HistogramBins = *histogramBins
+
+		opts.RemoveDC = *removeDC
+		opts.CorrelationStrip = *correlationStrip
+		opts.TrimMarkers = *trimMarkers
+		opts.ColorByFrequency = *colorByFrequency
+		opts.AutoRange = *autoRange
+		if opts.HeadroomDB, err = parseHeadroom(*headroom); err != nil {
+			return Options{}, err
+		}
+		if *colormap != "" {
+			if opts.Colormap, err = parseColormap(*colormap); err != nil {
+				return Options{}, err
+			}
+		}
+		if opts.Scale, err = parseScale(*scale); err != nil {
+			return Options{}, err
+		}
+		if opts.Profiles, err = parseProfiles(*profile); err != nil {
+			return Options{}, err
+		}
+		if opts.Channels, err = parseChannels(*channels); err != nil {
+			return Options{}, err
+		}
+		opts.SplitChannels = *splitChannels
+		if opts.Aggregation, err = parseAggregation(*aggregate); err != nil {
+			return Options{}, err
+		}
+		opts.TiersOver, opts.TierLength = *tiersOver, *tierLength
+		if opts.TiersOver < 0 || opts.TierLength <= 0 {
+			return Options{}, fmt.Errorf("-tiers-over must not be negative and -tier-length must be positive")
+		}
+		if err := parseScrubOptions(*scrubInterval, *scrubWidth, *scrubHeight); err != nil {
+			return Options{}, err
+		}
+		opts.ScrubInterval, opts.ScrubWidth, opts.ScrubHeight = *scrubInterval, *scrubWidth, *scrubHeight
+		if *previewClip < 0 {
+			return Options{}, fmt.Errorf("-preview-clip must not be negative")
+		}
+		opts.PreviewClip = *previewClip
+		if opts.PreviewFormat, err = parsePreviewFormat(*previewFormat); err != nil {
+			return Options{}, err
+		}
+		if opts.PreviewCmd = *previewCmd; opts.PreviewCmd == "" {
+			opts.PreviewCmd = previewEncoders[opts.PreviewFormat]
+		}
+		opts.ShadeSegments = *shadeSegments
+		opts.HistogramPanel = *histogramPanel
+		opts.LoudnessCaption = *loudnessCaption
+		opts.Annotations = *annotations
+		opts.Transcript = *transcript
+		opts.RMSWindow = *rmsWindow
+		opts.RMSFormat = *rmsFormat
+		if opts.RMSFormat != "json" && opts.RMSFormat != "csv" {
+			return Options{}, fmt.Errorf("unknown RMS format %q (want json or csv)", opts.RMSFormat)
+		}
+
+		opts.SpectrumSize = *spectrumSize
+		opts.SpectrumWindows = *spectrumWindows
+		opts.SpectrumFormat = *spectrumFormat
+		if opts.SpectrumSize != 0 && !isPowerOfTwo(opts.SpectrumSize) {
+			return Options{}, fmt.Errorf("-spectrum-size must be a power of two, got %d", opts.SpectrumSize)
+		}
+		if opts.SpectrumFormat != "json" && opts.SpectrumFormat != "csv" {
+			return Options{}, fmt.Errorf("unknown spectrum format %q (want json or csv)", opts.SpectrumFormat)
+		}
+
+		if flags := wholeFileFlags(opts); len(flags) > 0 && opts.Incremental {
+			return Options{}, fmt.Errorf("%s need the whole file and can't be combined with -incremental", strings.Join(flags, ", "))
+		}
+
+		opts.Backend, err = lookupBackend(*renderer)
+		if err != nil {
+			return Options{}, err
+		}
+
+		if opts.Incremental && opts.CacheDir == "" {
+			return Options{}, fmt.Errorf("-incremental requires -cache-dir")
+		}
+		if *checksums != "" {
+			if opts.Checksums, err = ReadChecksumManifest(*checksums); err != nil {
+				return Options{}, err
+			}
+		}
+		if *decryptKey != "" {
+			if opts.Incremental {
+				return Options{}, fmt.Errorf("-incremental reads growing files and can't be combined with -decrypt-key")
+			}
+			if opts.Decrypt, err = LoadInputCipher(*decryptKey); err != nil {
+				return Options{}, err
+			}
+		}
+
+		if opts.Incremental && opts.SamplesPerPixel > 0 {
+			return Options{}, fmt.Errorf("-incremental keeps its own bucket size and can't be combined with -samples-per-pixel")
+		}
+		if opts.Exact = *exact; opts.Exact {
+			switch {
+			case opts.Incremental:
+				return Options{}, fmt.Errorf("-incremental keeps its own bucket size and can't be combined with -exact")
+			case opts.Aggregation != nil:
+				return Options{}, fmt.Errorf("-exact draws the lowest and highest sample of every column and can't be combined with -aggregate %s", *aggregate)
+			case opts.SamplesPerPixel <= 0 && opts.oversized():
+				return Options{}, fmt.Errorf("-exact draws every column of -width and can't be wider than -max-width %d", opts.MaxWidth)
+			}
+		}
+
+		opts.Start, opts.End = *start, *end
+		switch {
+		case opts.Start < 0 || opts.End < 0:
+			return Options{}, fmt.Errorf("-start and -end can't be negative")
+		case opts.End > 0 && opts.End <= opts.Start:
+			return Options{}, fmt.Errorf("-end must be after -start")
+		case opts.Incremental && opts.windowed():
+			return Options{}, fmt.Errorf("-incremental decodes whole files and can't be combined with -start or -end")
+		}
+
+		return opts, nil
+	}
 }
 
-// GenerateStereoWaveforms creates separate waveform images for left and right channels
-func GenerateStereoWaveforms(inputFile, outputDir, fileName string, width, height int, wg *sync.WaitGroup) {
+// optionArgs returns the option flags set on fs as -name=value, in name
+// order, which identifies the options files were processed with
+func optionArgs(fs *flag.FlagSet) []string {
+	known := flag.NewFlagSet("", flag.ContinueOnError)
+	optionFlags(known)
 
-	defer wg.Done()
+	var args []string
+	fs.Visit(func(f *flag.Flag) {
+		if known.Lookup(f.Name) != nil {
+			args = append(args, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	return args
+}
 
-	// Parse WAV file
-	audioData, err := parseWAVFile(inputFile)
-	if err != nil {
-		// return fmt.Errorf("failed to parse WAV file: %w", err)
-		fmt.Printf("failed to parse WAV file: %v  %v", inputFile, err)
+// renderOptions returns how batch images are drawn
+func (o Options) renderOptions() RenderOptions {
+	ro := DefaultRenderOptions()
+	ro.Width, ro.Height = o.imageWidth(), o.Height
+	ro.AutoRange, ro.HeadroomDB, ro.Gain = o.AutoRange, o.HeadroomDB, o.Gain
+	if o.Scale != "" {
+		ro.Scale = o.Scale
 	}
+	// With ColorByFrequency the colormap colors the bands instead, over a
+	// waveform drawn in one color
+	if !o.ColorByFrequency {
+		ro.Colormap = o.Colormap
+	}
+	return ro
+}
 
-	// Create output directory
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		// return fmt.Errorf("failed to create output directory: %w", err)
-		fmt.Printf("failed to create output directory: %v  %v", inputFile, err)
+// wholeFileFlags returns the set flags whose analysis needs every sample of
+// a file
+func wholeFileFlags(opts Options) []string {
+	var flags []string
+	if len(opts.Analyses) > 0 {
+		flags = append(flags, "-analyze")
+	}
+	if opts.RMSWindow > 0 {
+		flags = append(flags, "-rms-window")
+	}
+	if opts.SpectrumSize > 0 {
+		flags = append(flags, "-spectrum-size")
+	}
+	if opts.RemoveDC {
+		flags = append(flags, "-remove-dc")
+	}
+	if opts.CorrelationStrip {
+		flags = append(flags, "-correlation-strip")
+	}
+	if opts.TrimMarkers {
+		flags = append(flags, "-trim-markers")
+	}
+	if opts.ColorByFrequency {
+		flags = append(flags, "-color-by-frequency")
+	}
+	if opts.ShadeSegments {
+		flags = append(flags, "-shade-segments")
+	}
+	if opts.HistogramPanel {
+		flags = append(flags, "-histogram-panel")
 	}
+	if opts.LoudnessCaption {
+		flags = append(flags, "-loudness-caption")
+	}
+	if opts.TiersOver > 0 {
+		flags = append(flags, "-tiers-over")
+	}
+	if opts.ScrubInterval > 0 {
+		flags = append(flags, "-scrub-interval")
+	}
+	if opts.PreviewClip > 0 {
+		flags = append(flags, "-preview-clip")
+	}
+	return flags
+}
 
-	// Generate left channel waveform
-	leftFile := fmt.Sprintf("%s/%s.png", outputDir, strings.Split(fileName, ".")[0])
-	if err := generateWaveformImage(audioData.LeftChannel, width, height, leftFile); err != nil {
-		// return fmt.Errorf("failed to generate left channel waveform: %w", err)
-		fmt.Printf("failed to generate left channel waveform: %v  %v", inputFile, err)
+// batchRun processes the files of a batch and reports on them
+type batchRun struct {
+	outputDir string
+	batch     *storageBatch
+	opts      Options
+
+	// history records files when set; with skipDone, files it has seen
+	// processed completely with the same content and options are skipped
+	history  *History
+	skipDone bool
+	options  string
+
+	// webhook is told about every file, or about the batch when
+	// webhookBatch is set, in which case the events collect in events
+	webhook      *webhookSink
+	webhookBatch bool
+	mu           sync.Mutex
+	events       []JobEvent
+
+	// failed counts the files that failed, transient those of them that
+	// failed on storage after every retry
+	failed, transient int
+
+	// manifest, when set, records every input's checksum and outputs
+	manifest *outputManifest
+
+	// gains, with -common-scale, are the gains of the inputs by name
+	gains map[string]float64
+}
+
+// process processes one input of the batch
+func (r *batchRun) process(obj ObjectInfo) {
+	input := r.batch.batchInput(obj)
+
+	var hash string
+	if r.history != nil {
+		// Local inputs are only read through for their hash when they may
+		// be skipped; otherwise the checksum -hash-inputs takes is recorded
+		var err error
+		if r.skipDone || input.Path == "" {
+			if hash, err = contentHash(input); err != nil {
+				fmt.Printf("Warning: failed to hash input: %v  %v\n", input.Location, err)
+			}
+		}
+		if r.skipDone {
+			done, err := r.history.Done(input.Location, hash, r.options)
+			if err != nil {
+				fmt.Printf("Warning: failed to look up history: %v  %v\n", input.Location, err)
+			}
+			if done {
+				fmt.Printf("Skipping %s: already processed\n", input.Location)
+				queueDepth.add(-1)
+				return
+			}
+		}
 	}
 
-	fmt.Printf("Successfully generated waveforms:\n")
-	fmt.Printf("  Left channel: %s\n", leftFile)
-	fmt.Printf("  Sample rate: %d Hz\n", audioData.SampleRate)
-	fmt.Printf("  Duration: %.2f seconds\n", float64(len(audioData.LeftChannel))/float64(audioData.SampleRate))
-	fmt.Printf("  Samples: %d\n", len(audioData.LeftChannel))
+	opts := r.opts
+	opts.Gain = r.gains[obj.Name]
+
+	started := time.Now()
+	result, err := processBatchFile(obj, r.outputDir, r.batch, opts)
+	if err != nil {
+		opts.OnError.failed(input, err, func() (string, error) { return r.batch.fetch(input.Name) })
+	}
+	if r.manifest != nil {
+		r.manifest.add(result, err)
+	}
+	if err != nil {
+		r.mu.Lock()
+		r.failed++
+		if isTransientFailure(err) {
+			r.transient++
+		}
+		r.mu.Unlock()
+	}
+
+	if r.history != nil {
+		if hash == "" && result.InputSHA256 != "" {
+			hash = "sha256:" + result.InputSHA256
+		}
+		record := HistoryRecord{
+			Input:    input.Location,
+			Hash:     hash,
+			Options:  r.options,
+			Outputs:  result.Outputs,
+			Started:  started,
+			Elapsed:  time.Since(started),
+			Duration: result.Duration,
+			Err:      err,
+		}
+		if err := r.history.Record(record); err != nil {
+			fmt.Printf("Warning: failed to record history: %v  %v\n", input.Location, err)
+		}
+	}
 
+	if r.webhook != nil {
+		event := newJobEvent(input.Location, result, r.outputDir, err)
+		if r.webhookBatch {
+			r.mu.Lock()
+			r.events = append(r.events, event)
+			r.mu.Unlock()
+		} else if err := r.webhook.Publish(context.Background(), event); err != nil {
+			fmt.Printf("failed to send webhook: %v  %v\n", event.Input, err)
+		}
+	}
 }
 
-// parseWAVFile reads a WAV file and extracts stereo audio data
-func parseWAVFile(filename string) (*AudioData, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %w", err)
+// processBatchFile generates the outputs of one batch input, moving it and
+// its outputs to and from remote storage when the batch uses it. The result
+// names outputs where they were stored; the error says whether everything
+// was generated and stored.
+func processBatchFile(obj ObjectInfo, outputDir string, batch *storageBatch, opts Options) (FileResult, error) {
+	defer queueDepth.add(-1)
+
+	input := batch.batchInput(obj)
+	if err := opts.Disk.Check(); err != nil {
+		fmt.Printf("skipping file: %v  %v\n", input.Location, err)
+		errorsTotal.inc("disk")
+		return FileResult{Input: input.Location}, fmt.Errorf("%w: %w", errBatchAborted, err)
+	}
+	if err := opts.OnError.Check(); err != nil {
+		fmt.Printf("skipping file: %v  %v\n", input.Location, err)
+		return FileResult{Input: input.Location}, fmt.Errorf("%w: %w", errBatchAborted, err)
+	}
+	if batch.remoteInput() {
+		local, windowed, err := batch.fetchInput(input.Name, opts)
+		if err != nil {
+			fmt.Printf("failed to fetch input: %v  %v\n", input.Location, err)
+			errorsTotal.inc("storage")
+			return FileResult{Input: input.Location}, fmt.Errorf("failed to fetch input: %w", err)
+		}
+		defer os.Remove(local)
+		input.Path = local
+		if windowed {
+			// The local copy holds only the stretch to render
+			input.Offset = opts.Start
+			opts.Start, opts.End = 0, 0
+		}
 	}
-	defer file.Close()
 
-	// Get file size for validation
-	fileInfo, err := file.Stat()
+	if !batch.remoteOutput() {
+		result, err := GenerateStereoWaveforms(input, outputDir, opts)
+		if err != nil && opts.OnError.skipFile() {
+			dropOutputs(&result)
+		}
+		opts.Disk.Add(result.Outputs)
+		return result, errors.Join(err, deliverOutputs(batch.sinks, input.Location, &result))
+	}
+
+	staging, err := batch.stagingDir()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get file info: %w", err)
+		fmt.Printf("failed to create staging directory: %v  %v\n", input.Location, err)
+		errorsTotal.inc("storage")
+		return FileResult{Input: input.Location}, fmt.Errorf("failed to create staging directory: %w", err)
 	}
-	fileSize := fileInfo.Size()
+	defer os.RemoveAll(staging)
 
-	// Read WAV header
-	var header WAVHeader
-	if err := binary.Read(file, binary.LittleEndian, &header); err != nil {
-		return nil, fmt.Errorf("failed to read WAV header: %w", err)
+	// Whatever was generated is uploaded, even when some outputs failed
+	result, genErr := GenerateStereoWaveforms(input, staging, opts)
+	if genErr != nil && opts.OnError.skipFile() {
+		dropOutputs(&result)
+		return result, genErr
 	}
+	opts.Disk.Add(result.Outputs)
+	genErr = errors.Join(genErr, deliverOutputs(batch.sinks, input.Location, &result))
+
+	if err := batch.upload(staging); err != nil {
+		fmt.Printf("failed to upload outputs: %v  %v\n", input.Location, err)
+		errorsTotal.inc("storage")
+		result.Outputs = nil
+		return result, errors.Join(genErr, fmt.Errorf("failed to upload outputs: %w", err))
+	}
+	for i, output := range result.Outputs {
+		result.Outputs[i] = strings.TrimSuffix(outputDir, "/") + "/" + filepath.Base(output)
+	}
+	return result, genErr
+}
 
-	// Validate WAV format
-	if string(header.ChunkID[:]) != "RIFF" || string(header.Format[:]) != "WAVE" {
-		return nil, fmt.Errorf("not a valid WAV file")
+// FileResult describes what was made of one input. Frames and Duration are
+// unknown when the waveform was rendered from cached peaks. OutputBytes is
+// the size of the waveform image.
+type FileResult struct {
+	Input      string   `json:"input"`
+	Outputs    []string `json:"outputs,omitempty"`
+	SampleRate uint32   `json:"sample_rate,omitempty"`
+	Frames     int      `json:"frames,omitempty"`
+	Duration   float64  `json:"duration_seconds,omitempty"`
+	// SamplesPerPixel is how many frames each column of the image covers
+	SamplesPerPixel float64 `json:"samples_per_pixel,omitempty"`
+	// InputSHA256 is the checksum of the input, when verified or recorded
+	InputSHA256 string           `json:"input_sha256,omitempty"`
+	OutputBytes int64            `json:"output_bytes,omitempty"`
+	Formatted   *FormattedFields `json:"formatted,omitempty"`
+	Metadata    *AudioMetadata   `json:"metadata,omitempty"`
+	Analysis    map[string]any   `json:"analysis,omitempty"`
+	// Sinks says how delivery to every -sinks destination went
+	Sinks []SinkResult `json:"sinks,omitempty"`
+}
+
+// GenerateStereoWaveforms creates separate waveform images for left and right
+// channels. Failures are printed and counted as they happen; the error
+// returned says whether every output was written, and the result lists the
+// ones that were.
+func GenerateStereoWaveforms(input batchInput, outputDir string, opts Options) (FileResult, error) {
+	result := FileResult{Input: input.Location}
+
+	baseName := input.OutputName
+	if baseName == "" {
+		baseName = outputBaseName(input.Name)
 	}
+	leftFile := filepath.Join(outputDir, baseName+".png")
 
-	if header.NumChannels != 2 {
-		return nil, fmt.Errorf("only stereo files are supported (found %d channels)", header.NumChannels)
+	// Inputs are vouched for before anything is read from them
+	if opts.Checksums != nil || opts.HashInputs {
+		sum, err := fileSHA256(input.Path)
+		if err == nil && opts.Checksums != nil {
+			err = opts.Checksums.Verify(input.Name, sum)
+		}
+		if err != nil {
+			fmt.Printf("failed to verify input: %v  %v\n", input.Location, err)
+			errorsTotal.inc("checksum")
+			return result, fmt.Errorf("failed to verify input: %w", err)
+		}
+		result.InputSHA256 = sum
 	}
 
-	if header.BitsPerSample != 16 {
-		return nil, fmt.Errorf("only 16-bit samples are supported (found %d bits)", header.BitsPerSample)
+	md, err := readInputMetadata(input.Path, opts)
+	if err != nil {
+		fmt.Printf("Warning: failed to read tags: %v  %v\n", input.Location, err)
+	}
+	input.Metadata, result.Metadata = md, md
+	vars := hookVars(input, leftFile)
+
+	if opts.PreCmd != "" {
+		if err := runHook(opts.PreCmd, vars); err != nil {
+			fmt.Printf("pre-cmd failed, skipping file: %v  %v\n", input.Location, err)
+			errorsTotal.inc("hook")
+			return result, fmt.Errorf("pre-cmd failed: %w", err)
+		}
 	}
 
-	fmt.Printf("File: %s\n", filename)
-	fmt.Printf("SampleRate: %d\n", header.SampleRate)
-	fmt.Printf("NumChannels: %d\n", header.NumChannels)
-	fmt.Printf("BitsPerSample: %d\n", header.BitsPerSample)
-	fmt.Printf("SubChunk2Size (header): %d bytes\n", header.SubChunk2Size)
-	fmt.Printf("BlockAlign: %d bytes\n", header.BlockAlign)
-	fmt.Printf("File size: %d bytes\n", fileSize)
+	consumers := newFileConsumers(opts)
+	if opts.Annotations != "" {
+		consumers.annotations = loadInputAnnotations(input, opts)
+	}
+	if opts.Transcript != "" {
+		consumers.transcript = loadInputTranscript(input, opts)
+	}
 
-	// Calculate actual audio data size
-	headerSize := int64(44) // Standard WAV header size
-	actualAudioDataSize := fileSize - headerSize
+	// Wait for room in the memory budget before decoding anything. A file
+	// that can't be probed fails to decode below, so it is sized as empty.
+	info, _ := probeInput(input.Path, opts)
+	memoryNeeded := estimateMemory(opts, info, consumers.all(), consumers.decorations())
+	opts.Memory.Acquire(memoryNeeded)
+	defer opts.Memory.Release(memoryNeeded)
+
+	if opts.Exact && info.SampleRate > 0 {
+		if err := checkExact(info, opts); err != nil {
+			fmt.Printf("failed to render exactly: %v  %v\n", input.Location, err)
+			errorsTotal.inc("render")
+			return result, fmt.Errorf("failed to render exactly: %w", err)
+		}
+	}
 
-	// Use the actual file size if header reports 0 or unrealistic size
-	audioDataSize := header.SubChunk2Size
-	if audioDataSize == 0 || int64(audioDataSize) > actualAudioDataSize {
-		fmt.Printf("Warning: Header reports SubChunk2Size=%d, but calculated actual size=%d. Using actual size.\n",
-			header.SubChunk2Size, actualAudioDataSize)
-		audioDataSize = uint32(actualAudioDataSize)
+	// Files waiting for memory may outlast the batch
+	if err := opts.OnError.Check(); err != nil {
+		fmt.Printf("skipping file: %v  %v\n", input.Location, err)
+		return result, fmt.Errorf("%w: %w", errBatchAborted, err)
 	}
 
-	// Calculate number of samples
-	bytesPerSample := header.NumChannels * (header.BitsPerSample / 8)
-	numSamples := int(audioDataSize) / int(bytesPerSample)
+	peaks, numSamples, cached, err := loadPeaks(input, opts, consumers.all())
+	if err != nil {
+		fmt.Printf("failed to parse WAV file: %v  %v\n", input.Location, err)
+		errorsTotal.inc("decode")
+		return result, fmt.Errorf("failed to parse WAV file: %w", err)
+	}
 
-	fmt.Printf("Calculated audio data size: %d bytes\n", audioDataSize)
-	fmt.Printf("Bytes per sample: %d\n", bytesPerSample)
-	fmt.Printf("Number of samples: %d\n", numSamples)
+	// A fixed bucket size makes the image as wide as the file needs
+	if opts.SamplesPerPixel > 0 {
+		opts.Width = peaks.Len()
+	}
 
-	// Read audio data
-	audioData := &AudioData{
-		SampleRate: header.SampleRate,
+	// A constant offset moves every bucket by the same amount, so it can be
+	// removed after the fact; cached peaks stay uncorrected
+	var tiers, scrubTiles []ChannelPeaks
+	if consumers.tiers != nil {
+		tiers = consumers.tiers.Result().([]ChannelPeaks)
+	}
+	if consumers.scrub != nil {
+		scrubTiles = consumers.scrub.Result().([]ChannelPeaks)
+	}
+	if consumers.dc != nil {
+		delta := -int(math.Round(consumers.dc.offset(0)))
+		peaks.Channels[0].shift(delta)
+		for _, tier := range append(tiers, scrubTiles...) {
+			tier.shift(delta)
+		}
+		consumers.dc.removed = true
+	}
+	defer releasePeaks(peaks)
+	// Markers and transcripts are placed by the time the image covers
+	if consumers.annotations != nil || consumers.transcript != nil {
+		frames := numSamples
+		if frames == 0 {
+			frames = int(peaks.SamplesPerPixel) * peaks.Len()
+		}
+		span := framesToSeconds(frames, peaks.SampleRate)
+		if consumers.annotations != nil {
+			consumers.annotations.span = span
+		}
+		if consumers.transcript != nil {
+			consumers.transcript.span = span
+		}
+	}
+
+	// Create output directory
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		fmt.Printf("failed to create output directory: %v  %v\n", input.Location, err)
+		errorsTotal.inc("write")
+		return result, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	// Generate left channel waveform
+	if err := renderPeaksImage(peaks.Channels[0], leftFile, opts, consumers.decorations()); err != nil {
+		fmt.Printf("failed to generate left channel waveform: %v  %v\n", input.Location, err)
+		errorsTotal.inc("render")
+		return result, fmt.Errorf("failed to generate left channel waveform: %w", err)
+	}
+	filesProcessed.inc()
+	result.Outputs = append(result.Outputs, leftFile)
+	result.SampleRate = peaks.SampleRate
+	result.Frames = numSamples
+	result.Duration = framesToSeconds(numSamples, peaks.SampleRate)
+	result.SamplesPerPixel = samplesPerPixelOf(peaks, numSamples, opts.imageWidth())
+	result.Formatted = &FormattedFields{}
+	if info, err := os.Stat(leftFile); err == nil {
+		result.OutputBytes = info.Size()
+		result.Formatted.OutputSize = formatSize(info.Size())
+	}
+
+	fmt.Printf("Successfully generated waveforms:\n")
+	fmt.Printf("  Left channel: %s (%s)\n", leftFile, result.Formatted.OutputSize)
+	fmt.Printf("  Sample rate: %d Hz\n", peaks.SampleRate)
+	fmt.Printf("  Samples per pixel: %.1f\n", result.SamplesPerPixel)
+	if cached {
+		fmt.Printf("  Rendered from cached peaks\n")
+	} else {
+		result.Formatted.Duration = formatSeconds(result.Duration)
+		fmt.Printf("  Duration: %s\n", result.Formatted.Duration)
+		fmt.Printf("  Samples: %d\n", numSamples)
 	}
 
-	// Pre-allocate slices for better performance
-	audioData.LeftChannel = make([]float64, 0, numSamples)
-	audioData.RightChannel = make([]float64, 0, numSamples)
+	// Failures past this point lose one output, the rest are still written
+	var errs []error
 
-	samplesRead := 0
-	for samplesRead < numSamples {
-		if header.NumChannels == 1 {
-			// Mono file - read one sample and duplicate it
-			var sample int16
-			if err := binary.Read(file, binary.LittleEndian, &sample); err != nil {
-				if err == io.EOF {
-					break
+	if opts.Exact {
+		mapFile := filepath.Join(outputDir, baseName+".columns.json")
+		offset := int(input.Offset.Seconds() * float64(info.SampleRate))
+		if err := writeColumnMap(mapFile, columnMap(filepath.Base(leftFile), info, offset, opts)); err != nil {
+			fmt.Printf("failed to map columns: %v  %v\n", input.Location, err)
+			errorsTotal.inc("write")
+			errs = append(errs, fmt.Errorf("failed to map columns: %w", err))
+		} else {
+			fmt.Printf("  Column map: %s\n", mapFile)
+			result.Outputs = append(result.Outputs, mapFile)
+		}
+	}
+
+	// The images above and below were drawn MaxWidth wide; tiles keep the
+	// full resolution in pieces no wider
+	if opts.oversized() {
+		fmt.Printf("  Warning: a %d pixel wide image is over -max-width %d, drew it %d wide\n", opts.Width, opts.MaxWidth, opts.MaxWidth)
+		if opts.Oversize == OversizeTile {
+			for i, tile := range tilePeaks(peaks.Channels[0], opts.Width, opts.MaxWidth) {
+				tileOpts := opts
+				tileOpts.Width = tile.width
+				tileFile := filepath.Join(outputDir, tileFileName(baseName, i+1))
+				if err := renderPeaksImage(tile.peaks, tileFile, tileOpts, decorations{}); err != nil {
+					fmt.Printf("failed to generate tile %d: %v  %v\n", i+1, input.Location, err)
+					errorsTotal.inc("render")
+					errs = append(errs, fmt.Errorf("failed to generate tile %d: %w", i+1, err))
+				} else {
+					fmt.Printf("  Tile %d: %s (%d pixels wide)\n", i+1, tileFile, tile.width)
+					result.Outputs = append(result.Outputs, tileFile)
 				}
-				return nil, fmt.Errorf("failed to read mono sample at position %d: %w", samplesRead, err)
 			}
+		}
+	}
 
-			// Convert to float64 and normalize to [-1, 1]
-			normalizedSample := float64(sample) / 32767.0
-			audioData.LeftChannel = append(audioData.LeftChannel, normalizedSample)
-			audioData.RightChannel = append(audioData.RightChannel, normalizedSample)
+	for _, profile := range opts.Profiles {
+		profileOpts := opts
+		profileOpts.Scale = profile.Scale
+		profileFile := filepath.Join(outputDir, baseName+"."+profile.Name+".png")
+		if err := renderPeaksImage(peaks.Channels[0], profileFile, profileOpts, consumers.decorations()); err != nil {
+			fmt.Printf("failed to generate %s profile: %v  %v\n", profile.Name, input.Location, err)
+			errorsTotal.inc("render")
+			errs = append(errs, fmt.Errorf("failed to generate %s profile: %w", profile.Name, err))
 		} else {
-			// Stereo file - read left and right samples
-			var leftSample, rightSample int16
+			fmt.Printf("  Profile %s: %s\n", profile.Name, profileFile)
+			result.Outputs = append(result.Outputs, profileFile)
+		}
+	}
 
-			if err := binary.Read(file, binary.LittleEndian, &leftSample); err != nil {
-				if err == io.EOF {
-					break
-				}
-				return nil, fmt.Errorf("failed to read left sample at position %d: %w", samplesRead, err)
+	// Each channel is decoded again on its own; decorations describe the
+	// rendered mix, so they don't apply
+	if opts.SplitChannels {
+		for ch, name := range speakerNames(info.ChannelMask, info.NumChannels) {
+			channelOpts := opts
+			channelOpts.Channels = singleChannel(ch)
+			channelFile := filepath.Join(outputDir, baseName+"."+name+".png")
+			channelPeaks, _, _, err := loadPeaks(input, channelOpts, nil)
+			if err == nil {
+				err = renderPeaksImage(channelPeaks.Channels[0], channelFile, channelOpts, decorations{})
+				releasePeaks(channelPeaks)
 			}
+			if err != nil {
+				fmt.Printf("failed to generate channel %s: %v  %v\n", name, input.Location, err)
+				errorsTotal.inc("render")
+				errs = append(errs, fmt.Errorf("failed to generate channel %s: %w", name, err))
+			} else {
+				fmt.Printf("  Channel %s: %s\n", name, channelFile)
+				result.Outputs = append(result.Outputs, channelFile)
+			}
+		}
+	}
 
-			if err := binary.Read(file, binary.LittleEndian, &rightSample); err != nil {
-				if err == io.EOF {
-					fmt.Printf("Warning: EOF reached while reading right channel at sample %d\n", samplesRead)
-					break
-				}
-				return nil, fmt.Errorf("failed to read right sample at position %d: %w", samplesRead, err)
+	// Zoom tiers show a stretch each, so decorations of the whole file don't
+	// apply to them
+	for i, tier := range tiers {
+		tierFile := filepath.Join(outputDir, tierFileName(baseName, i+1))
+		if err := renderPeaksImage(tier, tierFile, opts, decorations{}); err != nil {
+			fmt.Printf("failed to generate zoom tier %d: %v  %v\n", i+1, input.Location, err)
+			errorsTotal.inc("render")
+			errs = append(errs, fmt.Errorf("failed to generate zoom tier %d: %w", i+1, err))
+		} else {
+			fmt.Printf("  Zoom tier %d: %s\n", i+1, tierFile)
+			result.Outputs = append(result.Outputs, tierFile)
+		}
+	}
+
+	if len(scrubTiles) > 0 {
+		scrubFile := filepath.Join(outputDir, baseName+".scrub.png")
+		indexFile := filepath.Join(outputDir, baseName+".scrub.json")
+		offset := (opts.Start + input.Offset).Seconds()
+		index := scrubIndex(filepath.Base(scrubFile), len(scrubTiles), offset, result.Duration, opts)
+		strip, err := drawScrubStrip(scrubTiles, opts.renderOptions().gain(peaks.Channels[0]), opts)
+		if err == nil {
+			out := image.Image(strip)
+			if opts.Palette {
+				out = paletted(strip)
 			}
+			err = savePNG(out, scrubFile, opts.Compression)
+		}
+		if err == nil {
+			result.Outputs = append(result.Outputs, scrubFile)
+			err = writeScrubIndex(indexFile, index)
+		}
+		if err != nil {
+			fmt.Printf("failed to generate scrub strip: %v  %v\n", input.Location, err)
+			errorsTotal.inc("render")
+			errs = append(errs, fmt.Errorf("failed to generate scrub strip: %w", err))
+		} else {
+			fmt.Printf("  Scrub strip: %s (%d tiles)\n", scrubFile, len(scrubTiles))
+			result.Outputs = append(result.Outputs, indexFile)
+		}
+	}
 
-			// Convert to float64 and normalize to [-1, 1]
-			audioData.LeftChannel = append(audioData.LeftChannel, float64(leftSample)/32767.0)
-			audioData.RightChannel = append(audioData.RightChannel, float64(rightSample)/32767.0)
+	if opts.PreviewClip > 0 {
+		previewFile := filepath.Join(outputDir, baseName+".preview."+opts.PreviewFormat)
+		perBucket := samplesPerPixelOf(peaks, numSamples, peaks.Len())
+		clipFrames := int(opts.PreviewClip.Seconds() * float64(peaks.SampleRate))
+		first := loudestWindow(peaks.Channels[0], int(math.Ceil(float64(clipFrames)/perBucket)))
+		windowStart, _ := opts.windowFrames(peaks.SampleRate)
+		start := windowStart + int(float64(first)*perBucket)
+		encoder := opts.PreviewCmd
+		if opts.PreviewFormat == PreviewWAV {
+			encoder = ""
+		}
+		if err := writePreviewClip(input.Path, previewFile, start, clipFrames, encoder, opts); err != nil {
+			fmt.Printf("failed to cut preview clip: %v  %v\n", input.Location, err)
+			errorsTotal.inc("preview")
+			errs = append(errs, fmt.Errorf("failed to cut preview clip: %w", err))
+		} else {
+			offset := framesToSeconds(start, peaks.SampleRate) + input.Offset.Seconds()
+			fmt.Printf("  Preview clip: %s (from %s)\n", previewFile, formatSeconds(offset))
+			result.Outputs = append(result.Outputs, previewFile)
 		}
+	}
+
+	if len(consumers.report) > 0 {
+		report := &FileReport{
+			Input:           input.Location,
+			Output:          leftFile,
+			SampleRate:      peaks.SampleRate,
+			Frames:          numSamples,
+			Duration:        framesToSeconds(numSamples, peaks.SampleRate),
+			Formatted:       FormattedFields{Duration: formatSeconds(framesToSeconds(numSamples, peaks.SampleRate))},
+			SamplesPerPixel: result.SamplesPerPixel,
+			InputSHA256:     result.InputSHA256,
+			Metadata:        md,
+			Analysis:        analysisResults(consumers.report),
+		}
+		result.Analysis = report.Analysis
 
-		samplesRead++
+		reportFile := filepath.Join(outputDir, baseName+".json")
+		if err := writeReport(reportFile, report); err != nil {
+			fmt.Printf("failed to write report: %v  %v\n", input.Location, err)
+			errorsTotal.inc("report")
+			errs = append(errs, fmt.Errorf("failed to write report: %w", err))
+		} else {
+			fmt.Printf("  Report: %s\n", reportFile)
+			result.Outputs = append(result.Outputs, reportFile)
+		}
 	}
 
-	actualDuration := float64(len(audioData.LeftChannel)) / float64(header.SampleRate)
-	fmt.Printf("Actual samples read: %d\n", len(audioData.LeftChannel))
-	fmt.Printf("Actual duration: %.2f seconds\n", actualDuration)
+	if consumers.rms != nil {
+		rmsFile := filepath.Join(outputDir, baseName+".rms."+opts.RMSFormat)
+		if err := writeRMS(rmsFile, opts.RMSFormat, consumers.rms.Result().(RMSExport)); err != nil {
+			fmt.Printf("failed to write RMS: %v  %v\n", input.Location, err)
+			errorsTotal.inc("report")
+			errs = append(errs, fmt.Errorf("failed to write RMS: %w", err))
+		} else {
+			fmt.Printf("  RMS: %s\n", rmsFile)
+			result.Outputs = append(result.Outputs, rmsFile)
+		}
+	}
 
-	if len(audioData.LeftChannel) == 0 {
-		return nil, fmt.Errorf("no audio data found in file")
+	if consumers.spectrum != nil {
+		spectrumFile := filepath.Join(outputDir, baseName+".spectrum."+opts.SpectrumFormat)
+		if err := writeSpectrum(spectrumFile, opts.SpectrumFormat, consumers.spectrum.Result().(SpectrumExport)); err != nil {
+			fmt.Printf("failed to write spectrum: %v  %v\n", input.Location, err)
+			errorsTotal.inc("report")
+			errs = append(errs, fmt.Errorf("failed to write spectrum: %w", err))
+		} else {
+			fmt.Printf("  Spectrum: %s\n", spectrumFile)
+			result.Outputs = append(result.Outputs, spectrumFile)
+		}
 	}
 
-	return audioData, nil
+	if opts.PostCmd != "" {
+		if err := runHook(opts.PostCmd, vars); err != nil {
+			fmt.Printf("post-cmd failed: %v  %v\n", input.Location, err)
+			errorsTotal.inc("hook")
+			errs = append(errs, fmt.Errorf("post-cmd failed: %w", err))
+		}
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// loadPeaks returns the peaks to render for an input, from the cache when it
+// is still valid and no analyzers need the samples. It also returns the
+// number of decoded samples, and whether the peaks came straight from the
+// cache without decoding.
+func loadPeaks(input batchInput, opts Options, analyzers []namedAnalyzer) (*Peaks, int, bool, error) {
+	cache := PeakCache{Dir: opts.CacheDir, Channels: opts.Channels, Aggregation: opts.Aggregation}
+
+	if opts.Incremental {
+		// Extend the previous peaks with whatever was appended since
+		prev, _ := cache.LookupIncremental(input)
+		peaks, numSamples, err := decodePeaksIncremental(input.Path, prev, opts)
+		if prev != nil {
+			releasePeaks(prev)
+		}
+		if err != nil {
+			return nil, 0, false, err
+		}
+
+		if err := cache.StoreIncremental(input, peaks); err != nil {
+			fmt.Printf("Warning: failed to cache peaks: %v  %v\n", input.Location, err)
+		}
+		return peaks, numSamples, false, nil
+	}
 
-	// numSamples = int(header.SubChunk2Size) / int(header.BlockAlign)
+	// Entries are keyed by width, so peaks of a fixed bucket size, whose
+	// number changes with the file, or of part of it aren't cached
+	if opts.SamplesPerPixel > 0 || opts.windowed() {
+		cache.Dir = ""
+	}
 
-	// for i := 0; i < numSamples; i++ {
-	// 	var leftSample, rightSample int16
+	// Render straight from cached peaks when the input hasn't changed
+	if len(analyzers) == 0 {
+		if peaks, ok := cache.Lookup(input, opts.Width); ok {
+			return peaks, 0, true, nil
+		}
+	}
 
-	// 	if err := binary.Read(file, binary.LittleEndian, &leftSample); err != nil {
-	// 		if err == io.EOF {
-	// 			break
-	// 		}
-	// 		return nil, fmt.Errorf("failed to read left sample: %w", err)
-	// 	}
+	peaks, numSamples, err := decodePeaks(input.Path, opts, analyzers)
+	if err != nil {
+		return nil, 0, false, err
+	}
 
-	// 	if err := binary.Read(file, binary.LittleEndian, &rightSample); err != nil {
-	// 		if err == io.EOF {
-	// 			break
-	// 		}
-	// 		return nil, fmt.Errorf("failed to read right sample: %w", err)
-	// 	}
+	if err := cache.Store(input, opts.Width, peaks); err != nil {
+		fmt.Printf("Warning: failed to cache peaks: %v  %v\n", input.Location, err)
+	}
 
-	// 	// Convert to float64 and normalize to [-1, 1]
-	// 	audioData.LeftChannel = append(audioData.LeftChannel, float64(leftSample)/32767.0)
-	// 	audioData.RightChannel = append(audioData.RightChannel, float64(rightSample)/32767.0)
-	// }
+	return peaks, numSamples, false, nil
+}
 
-	// fmt.Printf("SampleRate: %d\n", header.SampleRate)
-	// fmt.Printf("NumChannels: %d\n", header.NumChannels)
-	// fmt.Printf("BitsPerSample: %d\n", header.BitsPerSample)
-	// fmt.Printf("Subchunk2Size: %d bytes\n", header.SubChunk2Size)
-	// fmt.Printf("BlockAlign: %d bytes\n", header.BlockAlign)
+// decodePeaks streams a WAV file into width min/max buckets of the left
+// channel without holding the decoded samples in memory. Analyzers see every
+// block of both channels; without any, the right channel isn't rendered and
+// is never decoded. It also returns the number of samples that were decoded.
+func decodePeaks(inputFile string, opts Options, analyzers []namedAnalyzer) (*Peaks, int, error) {
+	defer decodeDuration.since(time.Now())
 
-	// duration := float64(header.SubChunk2Size) / float64(header.SampleRate*uint32(header.BlockAlign))
-	// fmt.Printf("Calculated Duration: %.2f seconds\n", duration)
+	r, err := openInputWAV(inputFile, opts, len(analyzers) == 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer r.Close()
 
-	// return audioData, nil
+	return decodeWAVPeaks(r, opts, analyzers)
 }
 
-// generateWaveformImage creates a waveform image from audio samples
-func generateWaveformImage(samples []float64, width, height int, filename string) error {
-	img := image.NewRGBA(image.Rect(0, 0, width, height))
+// decodeWAVPeaks decodes an open WAV reader into peaks, as decodePeaks does
+// for a file
+func decodeWAVPeaks(r *wavReader, opts Options, analyzers []namedAnalyzer) (*Peaks, int, error) {
+	progress := opts.Progress
+
+	if err := r.window(opts.windowFrames(r.header.SampleRate)); err != nil {
+		return nil, 0, err
+	}
+	numFrames := r.numFrames - r.first
+
+	for _, a := range analyzers {
+		a.Start(StreamInfo{SampleRate: r.header.SampleRate, NumFrames: numFrames, Mono: r.header.NumChannels == 1 || r.selected != nil})
+	}
+
+	samplesPerPixel, _ := opts.bucketLayout(numFrames)
+	leftPeaks := opts.newPeakBuilder(numFrames)
+
+	for {
+		progress.decode(r.progress())
+
+		left, right, err := r.readPCM()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		// Peaks stay in the integer domain; floats are only used when drawing
+		leftPeaks.AddInt16(left)
 
-	// Fill background with white
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			img.Set(x, y, color.RGBA{255, 255, 255, 255})
+		for _, a := range analyzers {
+			a.Add(left, right)
 		}
 	}
 
-	if len(samples) == 0 {
-		return fmt.Errorf("no audio samples to process")
+	if r.framesRead == r.first {
+		return nil, 0, fmt.Errorf("no audio data found in file")
+	}
+
+	progress.decode(1)
+
+	peaks := &Peaks{
+		SampleRate:      r.header.SampleRate,
+		SamplesPerPixel: uint32(samplesPerPixel),
+		Channels:        []ChannelPeaks{leftPeaks.Peaks()},
+	}
+
+	return peaks, r.framesRead - r.first, nil
+}
+
+// bucketLayout returns the bucket size and number of buckets the peaks of a
+// file of numFrames frames are decoded into. They come from the declared
+// frame count, since the samples aren't kept around to count afterwards.
+// Buckets fitted to Width are spread evenly over the frames, so their size
+// is only the average, rounded down.
+func (o Options) bucketLayout(numFrames int) (samplesPerPixel, width int) {
+	if o.SamplesPerPixel <= 0 {
+		return samplesPerPixelFor(numFrames, o.Width), o.Width
+	}
+
+	// A fixed bucket size gives as many buckets as the file needs
+	samplesPerPixel = o.SamplesPerPixel
+	if o.MaxBuckets > 0 {
+		samplesPerPixel = max(samplesPerPixel, (numFrames+o.MaxBuckets-1)/o.MaxBuckets)
+	}
+	return samplesPerPixel, max(1, (numFrames+samplesPerPixel-1)/samplesPerPixel)
+}
+
+// windowed reports whether only a stretch of each file is rendered
+func (o Options) windowed() bool {
+	return o.Start > 0 || o.End > 0
+}
+
+// windowFrames returns the frames Start and End fall on at a sample rate;
+// end is 0 for the end of the file
+func (o Options) windowFrames(sampleRate uint32) (start, end int) {
+	start = int(o.Start.Seconds() * float64(sampleRate))
+	if o.End > 0 {
+		end = int(o.End.Seconds() * float64(sampleRate))
+	}
+	return start, end
+}
+
+// samplesPerPixelOf returns how many frames each column of an image width
+// columns wide drawn from peaks covers: exact when the number of frames is
+// known, and from the bucket size otherwise, as for cached peaks
+func samplesPerPixelOf(peaks *Peaks, frames, width int) float64 {
+	if frames == 0 {
+		frames = int(peaks.SamplesPerPixel) * peaks.Len()
+	}
+	return float64(frames) / float64(width)
+}
+
+// newPeakBuilder returns the builder of the peaks of a file of numFrames
+// frames, laid out as bucketLayout says
+func (o Options) newPeakBuilder(numFrames int) *PeakBuilder {
+	samplesPerPixel, width := o.bucketLayout(numFrames)
+	var b *PeakBuilder
+	if o.SamplesPerPixel <= 0 {
+		b = newFittedPeakBuilder(numFrames, width)
+	} else {
+		b = NewPeakBuilder(samplesPerPixel, width)
+	}
+	b.agg = o.Aggregation
+	return b
+}
+
+// renderPeaksImage draws channel peaks into a PNG file of the configured size,
+// with its decorations drawn over and around the waveform
+func renderPeaksImage(peaks ChannelPeaks, filename string, opts Options, deco decorations) error {
+	backend := opts.Backend
+	if backend == nil {
+		backend = cpuBackend{}
+	}
+
+	img, err := backend.Draw(peaks, opts.renderOptions(), opts.Progress)
+	if err != nil {
+		return err
+	}
+	defer putImage(img)
+
+	out := image.Image(deco.apply(img))
+	if opts.Palette {
+		out = paletted(out)
+	}
+	return savePNG(out, filename, opts.Compression)
+}
+
+// drawPeaks draws channel peaks into an image of the configured size. When
+// the number of buckets differs from the width, buckets are merged or
+// repeated to fit.
+func drawPeaks(peaks ChannelPeaks, ro RenderOptions, progress *Progress) (*image.RGBA, error) {
+	if len(peaks.Min) == 0 {
+		return nil, fmt.Errorf("no peaks to render")
+	}
+
+	defer renderDuration.since(time.Now())
+
+	width, height := ro.Width, ro.Height
+	img := getImage(width, height)
+
+	// Fill background
+	draw.Draw(img, img.Bounds(), &image.Uniform{ro.backgroundPixel()}, image.Point{}, draw.Src)
+
+	gain := ro.gain(peaks)
+
+	counter := newProgressCounter(width, progress.render)
+
+	// Very large images are split into x-ranges drawn concurrently
+	if width*height >= parallelRenderPixels {
+		parallelRanges(width, func(lo, hi int) {
+			drawColumns(img, peaks, ro, gain, lo, hi, counter)
+		})
+	} else {
+		drawColumns(img, peaks, ro, gain, 0, width, counter)
+	}
+	if ro.captionsScale() {
+		drawScaleCaption(img, ro, gain)
 	}
 
-	// Calculate samples per pixel
-	samplesPerPixel := len(samples) / width
-	if samplesPerPixel == 0 {
-		samplesPerPixel = 1
+	progress.render(1)
+
+	return img, nil
+}
+
+// normalizeGain returns the factor that makes the loudest peak fill the
+// image height
+func normalizeGain(peaks ChannelPeaks) float64 {
+	loudest := loudestPeak(peaks)
+	if loudest == 0 {
+		return 1
 	}
+	return 32767.0 / float64(loudest)
+}
 
+// loudestPeak returns the largest magnitude of the peaks
+func loudestPeak(peaks ChannelPeaks) int {
+	loudest := 0
+	for i := range peaks.Min {
+		loudest = max(loudest, -int(peaks.Min[i]), int(peaks.Max[i]))
+	}
+	return loudest
+}
+
+// columnPeaks returns the lowest and highest peak of the buckets covering
+// columns [first, last) of a width-column image
+func columnPeaks(peaks ChannelPeaks, first, last, width int) (int, int) {
+	numBuckets := len(peaks.Min)
+
+	startBucket := first * numBuckets / width
+	endBucket := last * numBuckets / width
+	if endBucket <= startBucket {
+		endBucket = startBucket + 1
+	}
+
+	minPeak, maxPeak := int(peaks.Min[startBucket]), int(peaks.Max[startBucket])
+	for i := startBucket + 1; i < endBucket; i++ {
+		minPeak = min(minPeak, int(peaks.Min[i]))
+		maxPeak = max(maxPeak, int(peaks.Max[i]))
+	}
+
+	return minPeak, maxPeak
+}
+
+// columnSpan returns the rows [minY, maxY] column x of a width x height
+// waveform covers, with amplitudes scaled by gain. ok is false for the empty
+// gap columns between bars.
+func columnSpan(peaks ChannelPeaks, ro RenderOptions, gain float64, x, width, height int) (minY, maxY int, ok bool) {
 	centerY := height / 2
 	maxAmplitude := float64(height) / 2.0
 
-	// Draw waveform
-	for x := 0; x < width; x++ {
-		startSample := x * samplesPerPixel
-		endSample := startSample + samplesPerPixel
-		if endSample > len(samples) {
-			endSample = len(samples)
-		}
-
-		// Find min and max amplitude in this pixel range
-		var minAmp, maxAmp float64
-		for i := startSample; i < endSample; i++ {
-			amp := samples[i]
-			if i == startSample || amp < minAmp {
-				minAmp = amp
-			}
-			if i == startSample || amp > maxAmp {
-				maxAmp = amp
-			}
+	var minPeak, maxPeak int
+	if ro.Style == StyleBars {
+		// Every column of a bar shows the bar's loudest peak, mirrored
+		// around the center; the gap columns stay empty
+		if x%barStride >= barWidth {
+			return 0, 0, false
 		}
+		first := x - x%barStride
+		lowest, highest := columnPeaks(peaks, first, min(first+barStride, width), width)
+		maxPeak = max(-lowest, highest)
+		minPeak = -maxPeak
+	} else {
+		minPeak, maxPeak = columnPeaks(peaks, x, x+1, width)
+	}
 
-		// Convert amplitude to pixel coordinates
-		minY := centerY - int(minAmp*maxAmplitude)
-		maxY := centerY - int(maxAmp*maxAmplitude)
+	minAmp := ro.scaleAmplitude(float64(minPeak) / 32767.0 * gain)
+	maxAmp := ro.scaleAmplitude(float64(maxPeak) / 32767.0 * gain)
 
-		// Clamp values
-		if minY < 0 {
-			minY = 0
-		}
-		if minY >= height {
-			minY = height - 1
-		}
-		if maxY < 0 {
-			maxY = 0
-		}
-		if maxY >= height {
-			maxY = height - 1
-		}
+	// Convert amplitude to pixel coordinates
+	minY = centerY - int(minAmp*maxAmplitude)
+	maxY = centerY - int(maxAmp*maxAmplitude)
+
+	// Clamp values
+	minY = min(max(minY, 0), height-1)
+	maxY = min(max(maxY, 0), height-1)
+
+	// Ensure maxY >= minY
+	if maxY < minY {
+		minY, maxY = maxY, minY
+	}
+
+	return minY, maxY, true
+}
+
+// drawColumns draws the waveform columns [lo, hi) of img from peaks, with
+// amplitudes scaled by gain
+func drawColumns(img *image.RGBA, peaks ChannelPeaks, ro RenderOptions, gain float64, lo, hi int, counter *progressCounter) {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	bg := ro.backgroundPixel()
+	fg := blendOver(bg, ro.Foreground)
 
-		// Ensure maxY >= minY
-		if maxY < minY {
-			minY, maxY = maxY, minY
+	// Draw waveform
+	for x := lo; x < hi; x++ {
+		counter.step()
+
+		minY, maxY, ok := columnSpan(peaks, ro, gain, x, width, height)
+		if !ok {
+			continue
+		}
+		if ro.Colormap != nil {
+			fg = blendOver(bg, ro.Colormap.At(columnLevel(minY, maxY, height)))
 		}
 
-		// Draw vertical line from minY to maxY
-		for y := minY; y <= maxY; y++ {
-			img.Set(x, y, color.RGBA{0, 0, 0, 255}) // Black waveform
+		// Draw vertical line from minY to maxY, writing straight into Pix
+		for i := img.PixOffset(x, minY); i <= img.PixOffset(x, maxY); i += img.Stride {
+			img.Pix[i+0] = fg.R
+			img.Pix[i+1] = fg.G
+			img.Pix[i+2] = fg.B
+			img.Pix[i+3] = fg.A
 		}
 	}
 
-	// Save image
-	file, err := os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("failed to create image file: %w", err)
-	}
-	defer file.Close()
+}
 
-	if err := png.Encode(file, img); err != nil {
-		return fmt.Errorf("failed to encode PNG: %w", err)
-	}
+// columnLevel returns how far the column spanning rows [minY, maxY] of a
+// waveform of the given height reaches from the center, from 0 to 1
+func columnLevel(minY, maxY, height int) float64 {
+	centerY := height / 2
+	return float64(max(centerY-minY, maxY-centerY)) / max(float64(height)/2, 1)
+}
 
+// savePNG encodes an image to the named PNG file. The file is written
+// atomically, so it is never seen half encoded.
+func savePNG(img image.Image, filename string, level png.CompressionLevel) error {
+	err := atomicWrite(filename, func(file *os.File) error {
+		return encodePNG(file, img, level)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write PNG: %w", err)
+	}
 	return nil
 }
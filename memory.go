@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MemoryBudget limits the bytes of decoded audio, peaks and images held across
+// all workers. A nil budget is unlimited.
+type MemoryBudget struct {
+	limit int64
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	inUse int64
+}
+
+// NewMemoryBudget returns a budget allowing limit bytes in use at once
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	b := &MemoryBudget{limit: limit}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// Acquire blocks until n bytes fit within the budget. A request larger than
+// the whole budget is admitted once nothing else is in use, so it can't block forever.
+func (b *MemoryBudget) Acquire(n int64) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for b.inUse > 0 && b.inUse+n > b.limit {
+		b.cond.Wait()
+	}
+	b.inUse += n
+}
+
+// Release returns n bytes previously acquired
+func (b *MemoryBudget) Release(n int64) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	b.inUse -= n
+	b.mu.Unlock()
+
+	b.cond.Broadcast()
+}
+
+// memoryUser is implemented by analyzers holding state that grows with the
+// input or is big enough to count against the memory budget
+type memoryUser interface {
+	// memoryEstimate returns the most bytes the analyzer holds at once
+	// for a stream, including what Result builds
+	memoryEstimate(info StreamInfo) int64
+}
+
+// windowCount returns how many windows of seconds a stream is split into
+func windowCount(info StreamInfo, seconds float64) int64 {
+	frames := max(1, int64(seconds*float64(info.SampleRate)))
+	return int64(info.NumFrames)/frames + 1
+}
+
+// peakBuckets returns how many buckets decoding a stream with opts produces
+func peakBuckets(opts Options, info StreamInfo) int64 {
+	frames := int64(info.NumFrames)
+
+	if opts.Incremental {
+		// Growing files keep fixed-size buckets for the whole file, and the
+		// previous run's buckets are held while they are extended
+		return 2 * (frames/incrementalSamplesPerPixel + 1)
+	}
+
+	if opts.SamplesPerPixel > 0 {
+		spp := int64(opts.SamplesPerPixel)
+		if opts.MaxBuckets > 0 {
+			spp = max(spp, (frames+int64(opts.MaxBuckets)-1)/int64(opts.MaxBuckets))
+		}
+		return max(1, (frames+spp-1)/spp)
+	}
+
+	return int64(opts.Width)
+}
+
+// estimateMemory returns roughly how many bytes processing one file needs,
+// following the allocations made on the way: the raw block and the 16-bit
+// channel buffers it is decoded into, the peak buckets, analyzer state, the
+// rendered image and the larger one decorations are composed into
+func estimateMemory(opts Options, info StreamInfo, analyzers []namedAnalyzer, deco decorations) int64 {
+	blockFrames := int64(decodeBlockSize / 4) // 16-bit stereo frames
+	decode := 2 * blockFrames * 2             // int16 left/right block slices
+	if !opts.UseMmap {
+		decode += decodeBlockSize
+	}
+
+	// Min and max of each bucket of the rendered channel
+	peaks := peakBuckets(opts, info) * 4
+
+	var analysis int64
+	for _, a := range analyzers {
+		if m, ok := a.Analyzer.(memoryUser); ok {
+			analysis += m.memoryEstimate(info)
+		}
+	}
+
+	// Oversized renders are drawn MaxWidth wide, and their tiles one at a
+	// time no wider. A fixed bucket size draws a column per bucket.
+	layout := opts
+	if opts.SamplesPerPixel > 0 {
+		layout.Width = int(peakBuckets(opts, info))
+	}
+	imageWidth := layout.imageWidth()
+	img := int64(imageWidth) * int64(opts.Height) * 4
+	if width, height := deco.size(imageWidth, opts.Height); width != imageWidth || height != opts.Height {
+		img += int64(width) * int64(height) * 4
+	}
+
+	return decode + peaks + analysis + img
+}
+
+// parseByteSize parses sizes such as "512MB", "2G" or "1048576"
+func parseByteSize(size string) (int64, error) {
+	s := strings.TrimSpace(strings.ToUpper(size))
+
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+		{"B", 1},
+	}
+
+	multiplier := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			multiplier = u.size
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+
+	return int64(value * float64(multiplier)), nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"1048576", 1 << 20, false},
+		{"512MB", 512 << 20, false},
+		{"2g", 2 << 30, false},
+		{"1.5K", 1536, false},
+		{" 10 B ", 10, false},
+		{"-1MB", 0, true},
+		{"lots", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseByteSize(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeWAV(t *testing.T) {
+	audio := DefaultTestAudio()
+	audio.SampleRate = 22050
+	audio.Duration = 2 * time.Second
+
+	info, err := probeWAV(writeFixture(t, audio))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.SampleRate != 22050 || info.NumFrames != 44100 {
+		t.Errorf("got %+v, want 22050 Hz and 44100 frames", info)
+	}
+}
+
+func TestEstimateMemory(t *testing.T) {
+	short := StreamInfo{SampleRate: 44100, NumFrames: 44100}
+	long := StreamInfo{SampleRate: 44100, NumFrames: 44100 * 3600}
+	base := Options{Width: 1920, Height: 640}
+
+	cfg := DefaultAnalysisConfig()
+	growing := newAnalyzers([]string{"loudness", "correlation", "segments", "fingerprint"}, cfg)
+	fixed := newAnalyzers([]string{"histogram"}, cfg)
+
+	incremental := base
+	incremental.Incremental = true
+
+	tests := []struct {
+		name       string
+		opts       Options
+		analyzers  []namedAnalyzer
+		deco       decorations
+		longLarger bool // an hour of audio needs more than a second
+		minExtra   int64
+	}{
+		{"render only", base, nil, decorations{}, false, 0},
+		{"growing analyzers", base, growing, decorations{}, true, 0},
+		{"histogram counts", base, fixed, decorations{}, false, 2 * (1 << 16) * 8},
+		{"incremental buckets", incremental, nil, decorations{}, true, 0},
+		{"decorated image", base, nil, decorations{strips: []imageStrip{&correlationAnalyzer{}}}, false,
+			int64(1920) * int64(640+correlationStripHeight) * 4},
+	}
+
+	plain := estimateMemory(base, short, nil, decorations{})
+	if want := int64(1920 * 640 * 4); plain < want {
+		t.Fatalf("render estimate %d is below the image size %d", plain, want)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := estimateMemory(tt.opts, short, tt.analyzers, tt.deco)
+			b := estimateMemory(tt.opts, long, tt.analyzers, tt.deco)
+
+			if tt.longLarger && b <= a {
+				t.Errorf("estimate doesn't grow with the file: %d for 1 s, %d for 1 h", a, b)
+			}
+			if !tt.longLarger && b != a {
+				t.Errorf("estimate depends on the length: %d for 1 s, %d for 1 h", a, b)
+			}
+			if tt.minExtra > 0 && a-plain < tt.minExtra {
+				t.Errorf("estimate adds %d bytes, want at least %d", a-plain, tt.minExtra)
+			}
+		})
+	}
+}
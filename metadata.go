@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+// maxMetadataChunk bounds the size of a tag chunk that is read, so a
+// corrupt size can't make a file allocate gigabytes
+const maxMetadataChunk = 1 << 20
+
+// AudioMetadata holds the tags of a file that captions, hook commands and
+// reports can show. Fields a file doesn't tag are empty.
+type AudioMetadata struct {
+	Title  string `json:"title,omitempty"`
+	Artist string `json:"artist,omitempty"`
+	Album  string `json:"album,omitempty"`
+}
+
+// empty reports whether no field is set
+func (m AudioMetadata) empty() bool {
+	return m == AudioMetadata{}
+}
+
+// merge fills the fields of m that are empty from other
+func (m *AudioMetadata) merge(other AudioMetadata) {
+	if m.Title == "" {
+		m.Title = other.Title
+	}
+	if m.Artist == "" {
+		m.Artist = other.Artist
+	}
+	if m.Album == "" {
+		m.Album = other.Album
+	}
+}
+
+// MetadataReader parses the tags held in one kind of chunk of a WAV file
+type MetadataReader func(data []byte) (AudioMetadata, error)
+
+// metadataReaders holds the readers by the RIFF chunk ID they parse
+var metadataReaders = map[string]MetadataReader{}
+
+// registerMetadataReader makes a reader parse every chunk with the given ID
+func registerMetadataReader(chunkID string, read MetadataReader) {
+	metadataReaders[chunkID] = read
+}
+
+func init() {
+	registerMetadataReader("LIST", readRIFFInfo)
+	registerMetadataReader("id3 ", readID3v2)
+	registerMetadataReader("ID3 ", readID3v2)
+}
+
+// sidecarExtensions are the formats of the compressed originals WAV files
+// are looked up next to for tags, in the order tried
+var sidecarExtensions = []string{".flac", ".ogg", ".opus", ".mp3"}
+
+// readWAVMetadata returns the tags of a WAV file, read from the chunks
+// after its sample data, where taggers append them. Where several chunks
+// tag the same field, the first one wins. Fields the file leaves out are
+// filled from a compressed original next to it with the same base name,
+// such as take.flac for take.wav. Files without tags give nil, as do files
+// that can't be read, which decoding reports.
+func readWAVMetadata(filename string) (*AudioMetadata, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil
+	}
+	defer file.Close()
+	md, err := readWAVSourceMetadata(file)
+	if err != nil {
+		return nil, err
+	}
+
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	for _, ext := range sidecarExtensions {
+		if _, err := os.Stat(base + ext); err != nil {
+			continue
+		}
+		original, err := readMetadata(base + ext)
+		if err != nil {
+			return md, fmt.Errorf("%s: %w", filepath.Base(base+ext), err)
+		}
+		if original != nil {
+			if md == nil {
+				md = &AudioMetadata{}
+			}
+			md.merge(*original)
+		}
+		break
+	}
+	return md, nil
+}
+
+// readMetadata returns the tags of an audio file of any format a
+// FileMetadataReader is registered for, told apart by its first bytes.
+// Files of other formats give nil.
+func readMetadata(filename string) (*AudioMetadata, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	head := make([]byte, 4)
+	n, _ := io.ReadFull(file, head)
+	for _, format := range fileMetadataFormats {
+		if !format.match(head[:n]) {
+			continue
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		md, err := format.read(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s tags: %w", format.name, err)
+		}
+		return md, nil
+	}
+	return nil, nil
+}
+
+// readWAVSourceMetadata returns the tags of WAV data, as readWAVMetadata
+// does for a file
+func readWAVSourceMetadata(file io.ReadSeeker) (*AudioMetadata, error) {
+	header, layout, err := readWAVHeader(file)
+	if err != nil {
+		return nil, nil
+	}
+	if header.SubChunk2Size == 0 {
+		return nil, nil // the end of the data is unknown
+	}
+	// Chunks are padded to an even size
+	next := layout.dataOffset + int64(header.SubChunk2Size) + int64(header.SubChunk2Size&1)
+
+	var md AudioMetadata
+	for {
+		var chunk struct {
+			ID   [4]byte
+			Size uint32
+		}
+		if _, err := file.Seek(next, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(file, binary.LittleEndian, &chunk); err != nil {
+			break // the end of the file
+		}
+		next += 8 + int64(chunk.Size) + int64(chunk.Size&1)
+
+		read, ok := metadataReaders[string(chunk.ID[:])]
+		if !ok || chunk.Size > maxMetadataChunk {
+			continue
+		}
+		data := make([]byte, chunk.Size)
+		if _, err := io.ReadFull(file, data); err != nil {
+			return nil, fmt.Errorf("failed to read %q chunk: %w", chunk.ID[:], err)
+		}
+		tags, err := read(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %q chunk: %w", chunk.ID[:], err)
+		}
+		md.merge(tags)
+	}
+
+	if md.empty() {
+		return nil, nil
+	}
+	return &md, nil
+}
+
+// readRIFFInfo parses a LIST chunk of type INFO: INAM is the title, IART
+// the artist and IPRD the album. Lists of other types tag nothing.
+func readRIFFInfo(data []byte) (AudioMetadata, error) {
+	var md AudioMetadata
+	if !bytes.HasPrefix(data, []byte("INFO")) {
+		return md, nil
+	}
+	data = data[4:]
+
+	for len(data) >= 8 {
+		id := string(data[:4])
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		data = data[8:]
+		if size > len(data) {
+			return md, fmt.Errorf("%s runs past the end of the list", id)
+		}
+		value := strings.TrimSpace(string(bytes.TrimRight(data[:size], "\x00")))
+		switch id {
+		case "INAM":
+			md.Title = value
+		case "IART":
+			md.Artist = value
+		case "IPRD":
+			md.Album = value
+		}
+		data = data[min(size+size&1, len(data)):]
+	}
+	return md, nil
+}
+
+// readID3v2 parses an ID3v2.2, 2.3 or 2.4 tag for its title, artist and
+// album text frames
+func readID3v2(data []byte) (AudioMetadata, error) {
+	var md AudioMetadata
+	if len(data) < 10 || string(data[:3]) != "ID3" {
+		return md, fmt.Errorf("not an ID3v2 tag")
+	}
+	version, flags := data[3], data[5]
+	if version < 2 || version > 4 {
+		return md, fmt.Errorf("unsupported ID3v2.%d tag", version)
+	}
+	size := min(int(syncsafe(data[6:10])), len(data)-10)
+	frames := data[10 : 10+size]
+
+	if flags&0x40 != 0 && version > 2 && len(frames) >= 4 {
+		// Skip the extended header: its size excludes itself in 2.3 and
+		// is syncsafe and includes itself in 2.4
+		ext := int(binary.BigEndian.Uint32(frames)) + 4
+		if version == 4 {
+			ext = int(syncsafe(frames[:4]))
+		}
+		frames = frames[min(ext, len(frames)):]
+	}
+
+	idSize, headerSize := 4, 10
+	names := map[string]*string{"TIT2": &md.Title, "TPE1": &md.Artist, "TALB": &md.Album}
+	if version == 2 {
+		idSize, headerSize = 3, 6
+		names = map[string]*string{"TT2": &md.Title, "TP1": &md.Artist, "TAL": &md.Album}
+	}
+
+	for len(frames) >= headerSize && frames[0] != 0 {
+		id := string(frames[:idSize])
+		var n int
+		switch version {
+		case 2:
+			n = int(frames[3])<<16 | int(frames[4])<<8 | int(frames[5])
+		case 3:
+			n = int(binary.BigEndian.Uint32(frames[4:8]))
+		default:
+			n = int(syncsafe(frames[4:8]))
+		}
+		frames = frames[headerSize:]
+		if n > len(frames) {
+			return md, fmt.Errorf("frame %s runs past the end of the tag", id)
+		}
+		if field, ok := names[id]; ok {
+			*field = id3Text(frames[:n])
+		}
+		frames = frames[n:]
+	}
+	return md, nil
+}
+
+// syncsafe decodes a 28-bit ID3 integer held in the low 7 bits of 4 bytes
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7f)<<21 | uint32(b[1]&0x7f)<<14 | uint32(b[2]&0x7f)<<7 | uint32(b[3]&0x7f)
+}
+
+// id3Text decodes a text frame: an encoding byte and then the text, of
+// which only the first value is kept
+func id3Text(frame []byte) string {
+	if len(frame) == 0 {
+		return ""
+	}
+	text := frame[1:]
+	var s string
+	switch frame[0] {
+	case 0: // ISO-8859-1
+		runes := make([]rune, len(text))
+		for i, b := range text {
+			runes[i] = rune(b)
+		}
+		s = string(runes)
+	case 1, 2: // UTF-16 with a byte order mark, or big endian without
+		order := binary.ByteOrder(binary.BigEndian)
+		if len(text) >= 2 && text[0] == 0xff && text[1] == 0xfe {
+			order, text = binary.LittleEndian, text[2:]
+		} else if len(text) >= 2 && text[0] == 0xfe && text[1] == 0xff {
+			text = text[2:]
+		}
+		units := make([]uint16, len(text)/2)
+		for i := range units {
+			units[i] = order.Uint16(text[2*i:])
+		}
+		s = string(utf16.Decode(units))
+	default: // UTF-8
+		s = string(text)
+	}
+	s, _, _ = strings.Cut(s, "\x00")
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// riffChunk returns a chunk with its header and padding
+func riffChunk(id string, data []byte) []byte {
+	chunk := append([]byte(id), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// id3Tag returns an ID3v2.3 tag of the given text frames
+func id3Tag(frames map[string][]byte) []byte {
+	var body []byte
+	for id, text := range frames {
+		body = append(body, id...)
+		body = binary.BigEndian.AppendUint32(body, uint32(len(text)))
+		body = append(body, 0, 0)
+		body = append(body, text...)
+	}
+	n := len(body)
+	tag := []byte{'I', 'D', '3', 3, 0, 0, byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+	return append(tag, body...)
+}
+
+// utf16Text returns an ID3 text frame body in little endian UTF-16
+func utf16Text(s string) []byte {
+	text := []byte{1, 0xff, 0xfe}
+	for _, u := range utf16.Encode([]rune(s)) {
+		text = binary.LittleEndian.AppendUint16(text, u)
+	}
+	return text
+}
+
+func TestReadWAVMetadata(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "01.wav")
+	audio := DefaultTestAudio()
+	audio.Duration = 10 * time.Millisecond
+	if err := WriteTestAudioFile(file, audio); err != nil {
+		t.Fatal(err)
+	}
+	if md, err := readWAVMetadata(file); md != nil || err != nil {
+		t.Errorf("untagged file: %+v, %v", md, err)
+	}
+
+	// The INFO list comes first, so its title wins over the ID3 one
+	info := []byte("INFO")
+	info = append(info, riffChunk("INAM", []byte("Intro\x00"))...)
+	info = append(info, riffChunk("ICMT", []byte("comment\x00"))...)
+	tag := id3Tag(map[string][]byte{
+		"TIT2": append([]byte{0}, "Other"...),
+		"TPE1": utf16Text("Sigur Rós"),
+		"TALB": append([]byte{3}, "Ágætis byrjun"...),
+	})
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(riffChunk("LIST", info))
+	f.Write(riffChunk("id3 ", tag))
+	f.Close()
+
+	md, err := readWAVMetadata(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AudioMetadata{Title: "Intro", Artist: "Sigur Rós", Album: "Ágætis byrjun"}
+	if md == nil || *md != want {
+		t.Errorf("tags = %+v, want %+v", md, want)
+	}
+}
+
+func TestReadID3v2Versions(t *testing.T) {
+	// ID3v2.2 has three letter frame IDs and sizes
+	v22 := []byte{'I', 'D', '3', 2, 0, 0, 0, 0, 0, 11, 'T', 'T', '2', 0, 0, 5, 0, 'S', 'o', 'n', 'g'}
+	if md, err := readID3v2(v22); err != nil || md.Title != "Song" {
+		t.Errorf("ID3v2.2: %+v, %v", md, err)
+	}
+
+	// ID3v2.4 frame sizes are syncsafe: 0x01 0x00 is 128
+	title := make([]byte, 128)
+	title[0] = 3
+	for i := 1; i < len(title); i++ {
+		title[i] = 'a'
+	}
+	frame := append([]byte{'T', 'I', 'T', '2', 0, 0, 1, 0, 0, 0}, title...)
+	v24 := append([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, 1, 10}, frame...)
+	if md, err := readID3v2(v24); err != nil || len(md.Title) != 127 {
+		t.Errorf("ID3v2.4: %+v, %v", md, err)
+	}
+
+	for _, bad := range [][]byte{[]byte("ID3"), []byte("TAG........"), {'I', 'D', '3', 3, 0, 0, 0, 0, 0, 20, 'T', 'I', 'T', '2', 0, 0, 0, 99, 0, 0}} {
+		if _, err := readID3v2(bad); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+}
+
+func TestAlbumTrackLabel(t *testing.T) {
+	for want, track := range map[string]albumTrack{
+		"01 intro":     {name: "01 intro.wav"},
+		"Intro":        {name: "01.wav", metadata: &AudioMetadata{Title: "Intro"}},
+		"Band - Intro": {name: "01.wav", metadata: &AudioMetadata{Title: "Intro", Artist: "Band"}},
+		"02 untitled":  {name: "02 untitled.wav", metadata: &AudioMetadata{Artist: "Band"}},
+	} {
+		if got := track.label(); got != want {
+			t.Errorf("label = %q, want %q", got, want)
+		}
+	}
+}
+
+// vorbisComment returns a Vorbis comment of the given fields
+func vorbisComment(fields ...string) []byte {
+	field := func(b []byte, s string) []byte {
+		return append(binary.LittleEndian.AppendUint32(b, uint32(len(s))), s...)
+	}
+	data := field(nil, "test vendor")
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(fields)))
+	for _, f := range fields {
+		data = field(data, f)
+	}
+	return data
+}
+
+// oggPage returns an Ogg page of the given stream holding packets, the
+// last of which continues on the next page when open is set
+func oggPage(serial uint32, open bool, packets ...[]byte) []byte {
+	var segments, body []byte
+	for i, p := range packets {
+		body = append(body, p...)
+		for n := len(p); ; n -= 255 {
+			if n < 255 {
+				if !open || i < len(packets)-1 {
+					segments = append(segments, byte(n))
+				}
+				break
+			}
+			segments = append(segments, 255)
+		}
+	}
+	page := append([]byte("OggS"), make([]byte, 10)...)
+	page = binary.LittleEndian.AppendUint32(page, serial)
+	page = append(page, make([]byte, 8)...)
+	page = append(page, byte(len(segments)))
+	return append(append(page, segments...), body...)
+}
+
+func TestReadMetadataFormats(t *testing.T) {
+	dir := t.TempDir()
+	comment := vorbisComment("title=Intro", "ARTIST=Band", "ARTIST=Other", "ALBUM=First", "COMMENT=x")
+	want := AudioMetadata{Title: "Intro", Artist: "Band", Album: "First"}
+
+	flac := append([]byte("fLaC"), 0, 0, 0, 34)
+	flac = append(flac, make([]byte, 34)...)
+	flac = append(flac, 0x84, 0, byte(len(comment)>>8), byte(len(comment)))
+	flac = append(flac, comment...)
+
+	// The comment header spans two pages, with a page of another stream
+	// in between
+	long := append(append([]byte("\x03vorbis"), comment...), make([]byte, 600)...)
+	ogg := oggPage(7, false, []byte("\x01vorbis identification"))
+	ogg = append(ogg, oggPage(7, true, long[:510])...)
+	ogg = append(ogg, oggPage(9, false, []byte("other stream"))...)
+	ogg = append(ogg, oggPage(7, false, long[510:])...)
+
+	opus := oggPage(1, false, []byte("OpusHead"), append([]byte("OpusTags"), comment...))
+
+	// ID3v1 fills in what the ID3v2 tag leaves out
+	mp3 := id3Tag(map[string][]byte{"TIT2": append([]byte{3}, "Intro"...), "TPE1": append([]byte{3}, "Band"...)})
+	mp3 = append(mp3, 0xff, 0xfb, 0x90, 0x00)
+	v1 := make([]byte, 128)
+	copy(v1, "TAG")
+	copy(v1[3:], "Ignored")
+	copy(v1[63:], "First")
+	mp3 = append(mp3, v1...)
+
+	for name, data := range map[string][]byte{"take.flac": flac, "take.ogg": ogg, "take.opus": opus, "take.mp3": mp3} {
+		file := filepath.Join(dir, name)
+		os.WriteFile(file, data, 0o644)
+		md, err := readMetadata(file)
+		if err != nil || md == nil || *md != want {
+			t.Errorf("%s: %+v, %v", name, md, err)
+		}
+	}
+
+	// An untagged WAV takes the tags of its original
+	wav := filepath.Join(dir, "take.wav")
+	audio := DefaultTestAudio()
+	audio.Duration = 10 * time.Millisecond
+	if err := WriteTestAudioFile(wav, audio); err != nil {
+		t.Fatal(err)
+	}
+	if md, err := readWAVMetadata(wav); err != nil || md == nil || *md != want {
+		t.Errorf("WAV next to take.flac: %+v, %v", md, err)
+	}
+
+	if md, err := readMetadata(filepath.Join(dir, "take.wav")); md != nil || err != nil {
+		t.Errorf("untagged WAV: %+v, %v", md, err)
+	}
+	os.WriteFile(filepath.Join(dir, "cut.flac"), flac[:50], 0o644)
+	if _, err := readMetadata(filepath.Join(dir, "cut.flac")); err == nil {
+		t.Error("truncated FLAC parsed")
+	}
+}
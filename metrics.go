@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds of the duration histograms, in seconds
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Metrics exposed on /metrics in the Prometheus text format
+var (
+	filesProcessed = newCounter("waveform_files_processed_total", "Files rendered successfully.")
+	errorsTotal    = newCounterVec("waveform_errors_total", "Failures by the step that failed.", "type")
+	decodeDuration = newHistogram("waveform_decode_duration_seconds", "Time spent decoding audio into peaks.", durationBuckets)
+	renderDuration = newHistogram("waveform_render_duration_seconds", "Time spent drawing peaks into images.", durationBuckets)
+	storageRetries = newCounter("waveform_storage_retries_total", "Storage transfers tried again after a transient failure.")
+	queueDepth     = newGauge("waveform_queue_depth", "Files or requests waiting or in progress.")
+	configReloads  = newCounter("waveform_config_reloads_total", "Configuration reloads of the daemon on SIGHUP.")
+)
+
+// metric is anything that can write itself in the text format
+type metric interface {
+	write(w io.Writer)
+}
+
+// registry holds every metric in registration order
+var registry []metric
+
+// counter is a monotonically increasing value
+type counter struct {
+	name, help string
+	mu         sync.Mutex
+	value      float64
+}
+
+func newCounter(name, help string) *counter {
+	c := &counter{name: name, help: help}
+	registry = append(registry, c)
+	return c
+}
+
+// inc adds one to the counter
+func (c *counter) inc() {
+	c.mu.Lock()
+	c.value++
+	c.mu.Unlock()
+}
+
+func (c *counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %s\n", c.name, c.help, c.name, c.name, formatValue(c.value))
+}
+
+// counterVec is a set of counters told apart by one label
+type counterVec struct {
+	name, help, label string
+	mu                sync.Mutex
+	values            map[string]float64
+}
+
+func newCounterVec(name, help, label string) *counterVec {
+	c := &counterVec{name: name, help: help, label: label, values: map[string]float64{}}
+	registry = append(registry, c)
+	return c
+}
+
+// inc adds one to the counter of a label value
+func (c *counterVec) inc(value string) {
+	c.mu.Lock()
+	c.values[value]++
+	c.mu.Unlock()
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+
+	values := make([]string, 0, len(c.values))
+	for v := range c.values {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	for _, v := range values {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %s\n", c.name, c.label, labelEscaper.Replace(v), formatValue(c.values[v]))
+	}
+}
+
+// labelEscaper escapes label values as the text format has them: only
+// backslashes, double quotes and line feeds, and everything else as is
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// gauge is a value that goes up and down
+type gauge struct {
+	name, help string
+	mu         sync.Mutex
+	value      float64
+}
+
+func newGauge(name, help string) *gauge {
+	g := &gauge{name: name, help: help}
+	registry = append(registry, g)
+	return g
+}
+
+// add changes the gauge by delta
+func (g *gauge) add(delta float64) {
+	g.mu.Lock()
+	g.value += delta
+	g.mu.Unlock()
+}
+
+func (g *gauge) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatValue(g.value))
+}
+
+// histogram counts observations into cumulative buckets
+type histogram struct {
+	name, help string
+	bounds     []float64
+	mu         sync.Mutex
+	counts     []uint64 // per bucket, not cumulative; the last is +Inf
+	sum        float64
+	count      uint64
+}
+
+func newHistogram(name, help string, bounds []float64) *histogram {
+	h := &histogram{name: name, help: help, bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+	registry = append(registry, h)
+	return h
+}
+
+// observe records one value
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+// since records the time elapsed since start, in seconds
+func (h *histogram) since(start time.Time) {
+	h.observe(time.Since(start).Seconds())
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+
+	cumulative := uint64(0)
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatValue(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", h.name, formatValue(h.sum), h.name, h.count)
+}
+
+// formatValue formats a sample value the way Prometheus expects
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// metricsHandler serves every registered metric
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	for _, m := range registry {
+		m.write(&b)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	io.WriteString(w, b.String())
+}
+
+// serveMetrics serves /metrics on addr in the background
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", metricsHandler)
+
+	// Scrapes are small, so the timeouts are tighter than serve's
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: defaultReadHeaderTimeout,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       defaultIdleTimeout,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			fmt.Printf("Warning: metrics server stopped: %v\n", err)
+		}
+	}()
+	fmt.Printf("Serving metrics on http://%s/metrics\n", addr)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	// Metrics of their own, so the ones other tests move don't show
+	saved := registry
+	registry = nil
+	defer func() { registry = saved }()
+
+	files := newCounter("test_files_total", "Files.")
+	errs := newCounterVec("test_errors_total", "Errors by type.", "type")
+	depth := newGauge("test_depth", "Depth.")
+	latency := newHistogram("test_seconds", "Latency.", []float64{0.1, 1, 10})
+
+	files.inc()
+	files.inc()
+	errs.inc("decode")
+	errs.inc(`back\slash "quoted"` + "\nnext line")
+	errs.inc("ünïcode\ttab")
+	depth.add(3)
+	depth.add(-1.5)
+	for _, v := range []float64{0.05, 0.1, 0.5, 2, 100} {
+		latency.observe(v)
+	}
+
+	rec := httptest.NewRecorder()
+	metricsHandler(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	want := `# HELP test_files_total Files.
+# TYPE test_files_total counter
+test_files_total 2
+# HELP test_errors_total Errors by type.
+# TYPE test_errors_total counter
+test_errors_total{type="back\\slash \"quoted\"\nnext line"} 1
+test_errors_total{type="decode"} 1
+test_errors_total{type="ünïcode	tab"} 1
+# HELP test_depth Depth.
+# TYPE test_depth gauge
+test_depth 1.5
+# HELP test_seconds Latency.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 2
+test_seconds_bucket{le="1"} 3
+test_seconds_bucket{le="10"} 4
+test_seconds_bucket{le="+Inf"} 5
+test_seconds_sum 102.65
+test_seconds_count 5
+`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("metrics =\n%s\nwant\n%s", got, want)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// mmapFile is not available on this platform
+func mmapFile(file *os.File, size int64) ([]byte, error) {
+	return nil, errors.New("memory-mapped decoding is not supported on this platform")
+}
+
+// munmapFile is not available on this platform
+func munmapFile(data []byte) error {
+	return nil
+}
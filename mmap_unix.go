@@ -0,0 +1,18 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps size bytes of file read-only into memory
+func mmapFile(file *os.File, size int64) ([]byte, error) {
+	return syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// munmapFile releases a mapping returned by mmapFile
+func munmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
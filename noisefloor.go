@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// noiseFloorSegment is the length of the segments the noise floor is judged
+// over, long enough that short pauses between words don't count
+const noiseFloorSegment = 0.5 // seconds
+
+// noiseFloorFraction is the share of quietest segments averaged into the
+// noise floor
+const noiseFloorFraction = 0.05
+
+// NoiseFloorReport is the "noise_floor" section of a file report
+type NoiseFloorReport struct {
+	LimitDB      float64  `json:"limit_dbfs"`
+	NoiseFloorDB *float64 `json:"noise_floor_dbfs"`
+	Segments     int      `json:"segments"`
+	ExceedsLimit bool     `json:"exceeds_limit"`
+}
+
+// noiseFloorAnalyzer estimates the noise floor from the quietest segments
+type noiseFloorAnalyzer struct {
+	cfg AnalysisConfig
+
+	segmentFrames int
+	segmentPos    int
+	segmentSum    float64
+
+	// power holds the mean square of each segment, normalized
+	power []float64
+}
+
+func newNoiseFloorAnalyzer(cfg AnalysisConfig) Analyzer {
+	return &noiseFloorAnalyzer{cfg: cfg}
+}
+
+// Start implements Analyzer
+func (a *noiseFloorAnalyzer) Start(info StreamInfo) {
+	a.segmentFrames = max(1, int(noiseFloorSegment*float64(info.SampleRate)))
+}
+
+// memoryEstimate implements memoryUser: the power of every segment and the
+// sorted copy Result makes
+func (a *noiseFloorAnalyzer) memoryEstimate(info StreamInfo) int64 {
+	return 2 * windowCount(info, noiseFloorSegment) * 8
+}
+
+// Add implements Analyzer
+func (a *noiseFloorAnalyzer) Add(left, right []int16) {
+	for i := range left {
+		l := float64(left[i])
+		power := l * l
+		if right != nil {
+			r := float64(right[i])
+			power = (power + r*r) / 2
+		}
+		a.segmentSum += power
+
+		a.segmentPos++
+		if a.segmentPos == a.segmentFrames {
+			a.endSegment()
+		}
+	}
+}
+
+// endSegment records the power of the segment that just finished
+func (a *noiseFloorAnalyzer) endSegment() {
+	// Digital silence (exact zeros from editing) has no noise to measure
+	if a.segmentSum > 0 {
+		a.power = append(a.power, a.segmentSum/float64(a.segmentPos)/(32768.0*32768.0))
+	}
+	a.segmentSum = 0
+	a.segmentPos = 0
+}
+
+// Result implements Analyzer
+func (a *noiseFloorAnalyzer) Result() any {
+	// A trailing partial segment is only used when there is nothing else
+	if a.segmentPos > 0 && len(a.power) == 0 {
+		a.endSegment()
+	}
+
+	report := NoiseFloorReport{
+		LimitDB:  a.cfg.NoiseFloorLimitDB,
+		Segments: len(a.power),
+	}
+	if len(a.power) == 0 {
+		return report
+	}
+
+	sort.Float64s(a.power)
+	quietest := max(1, int(float64(len(a.power))*noiseFloorFraction))
+	sum := 0.0
+	for _, p := range a.power[:quietest] {
+		sum += p
+	}
+
+	floor := 10 * math.Log10(sum/float64(quietest))
+	report.NoiseFloorDB = finiteOrNil(floor)
+	report.ExceedsLimit = floor > a.cfg.NoiseFloorLimitDB
+
+	return report
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// What a batch does about a file that fails, as -on-error says
+const (
+	// OnErrorContinue keeps the outputs a failing file did produce and
+	// carries on with the others
+	OnErrorContinue = "continue"
+
+	// OnErrorSkipFile drops every output of a failing file, so a batch
+	// leaves complete sets of outputs or none
+	OnErrorSkipFile = "skip-file"
+
+	// OnErrorAbort stops the batch at the first failing file: files not
+	// yet started are skipped, files in progress are finished
+	OnErrorAbort = "abort"
+)
+
+// quarantineList names the list of failed inputs in a quarantine directory
+const quarantineList = "quarantine.jsonl"
+
+// errBatchAborted marks the files a batch skipped once it was aborted,
+// which didn't fail on their own account
+var errBatchAborted = errors.New("batch aborted")
+
+// ErrorPolicy is what a batch does about failing files, and where it
+// quarantines them. A nil policy continues and quarantines nothing.
+type ErrorPolicy struct {
+	mode string
+
+	// quarantine is a directory failing inputs are copied to and listed in
+	// quarantineList; none when empty
+	quarantine string
+
+	mu  sync.Mutex
+	err error // why the batch was aborted
+}
+
+// QuarantineEntry is a line of quarantineList
+type QuarantineEntry struct {
+	Input string    `json:"input"`
+	Error string    `json:"error"`
+	Time  time.Time `json:"time"`
+	// Copy is the name of the copy of the input in the directory, empty
+	// when it couldn't be copied
+	Copy string `json:"copy,omitempty"`
+}
+
+// NewErrorPolicy returns the policy of an -on-error mode and -quarantine
+// directory
+func NewErrorPolicy(mode, quarantine string) (*ErrorPolicy, error) {
+	switch mode {
+	case "", OnErrorContinue:
+		mode = OnErrorContinue
+	case OnErrorSkipFile, OnErrorAbort:
+	default:
+		return nil, fmt.Errorf("unknown -on-error %q (want continue, skip-file or abort)", mode)
+	}
+	if quarantine != "" {
+		if err := os.MkdirAll(quarantine, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create quarantine directory: %w", err)
+		}
+	}
+	return &ErrorPolicy{mode: mode, quarantine: quarantine}, nil
+}
+
+// Check returns an error once the batch has been aborted, when no further
+// file may be started
+func (p *ErrorPolicy) Check() error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// Aborted returns why the batch was aborted, or nil when it wasn't
+func (p *ErrorPolicy) Aborted() error {
+	return p.Check()
+}
+
+// skipFile reports whether the outputs of failing files are dropped
+func (p *ErrorPolicy) skipFile() bool {
+	return p != nil && p.mode == OnErrorSkipFile
+}
+
+// failed records that an input failed with err: it aborts the batch with
+// OnErrorAbort, and is quarantined with a copy of the local file holding
+// it, when there is one, or of what fetch downloads
+func (p *ErrorPolicy) failed(input batchInput, err error, fetch func() (string, error)) {
+	if p == nil || errors.Is(err, errBatchAborted) {
+		return
+	}
+
+	p.mu.Lock()
+	if p.mode == OnErrorAbort && p.err == nil {
+		p.err = fmt.Errorf("%s failed: %w", input.Location, err)
+	}
+	p.mu.Unlock()
+
+	if p.quarantine == "" {
+		return
+	}
+	entry := QuarantineEntry{Input: input.Location, Error: err.Error(), Time: time.Now().UTC()}
+	if name, copyErr := p.copyInput(input, fetch); copyErr != nil {
+		fmt.Printf("Warning: failed to quarantine input: %v  %v\n", input.Location, copyErr)
+	} else {
+		entry.Copy = name
+	}
+	if err := p.list(entry); err != nil {
+		fmt.Printf("Warning: failed to list quarantined input: %v  %v\n", input.Location, err)
+	}
+}
+
+// copyInput copies an input into the quarantine directory, under its
+// output base name so inputs from different directories don't collide
+func (p *ErrorPolicy) copyInput(input batchInput, fetch func() (string, error)) (string, error) {
+	path := input.Path
+	if path == "" {
+		local, err := fetch()
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(local)
+		path = local
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	base := input.OutputName
+	if base == "" {
+		base = outputBaseName(input.Name)
+	}
+	name := base + filepath.Ext(input.Name)
+	err = atomicWrite(filepath.Join(p.quarantine, name), func(file *os.File) error {
+		_, err := io.Copy(file, src)
+		return err
+	})
+	return name, err
+}
+
+// list appends an entry to the quarantine list
+func (p *ErrorPolicy) list(entry QuarantineEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	file, err := os.OpenFile(filepath.Join(p.quarantine, quarantineList), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// dropOutputs removes the outputs of a file that failed with
+// OnErrorSkipFile, so the batch leaves none of it behind
+func dropOutputs(result *FileResult) {
+	for _, output := range result.Outputs {
+		if err := os.Remove(output); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("Warning: failed to remove output of failed file: %v  %v\n", output, err)
+		}
+	}
+	if len(result.Outputs) > 0 {
+		fmt.Printf("  Dropped %d outputs of failed file %s\n", len(result.Outputs), result.Input)
+	}
+	result.Outputs = nil
+}
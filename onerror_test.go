@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestErrorPolicyModes(t *testing.T) {
+	if _, err := NewErrorPolicy("retry", ""); err == nil {
+		t.Error("unknown mode accepted")
+	}
+
+	p, err := NewErrorPolicy(OnErrorAbort, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := batchInput{Name: "a.wav", Location: "in/a.wav"}
+	p.failed(in, errors.Join(errBatchAborted, errors.New("disk full")), nil)
+	if p.Check() != nil {
+		t.Error("a file skipped by an aborted batch aborted it")
+	}
+	p.failed(in, errors.New("bad header"), nil)
+	if err := p.Check(); err == nil || err.Error() != "in/a.wav failed: bad header" {
+		t.Errorf("Check() = %v", err)
+	}
+
+	p, _ = NewErrorPolicy(OnErrorContinue, "")
+	p.failed(in, errors.New("bad header"), nil)
+	if p.Check() != nil || p.skipFile() {
+		t.Error("continue aborted or skips files")
+	}
+
+	var none *ErrorPolicy
+	if none.Check() != nil || none.skipFile() {
+		t.Error("a nil policy isn't continue")
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "quarantine")
+	p, err := NewErrorPolicy(OnErrorContinue, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local := filepath.Join(t.TempDir(), "take.wav")
+	os.WriteFile(local, []byte("RIFF junk"), 0o644)
+	p.failed(batchInput{Name: "take.wav", Path: local, Location: local, OutputName: "take-2"}, errors.New("bad header"), nil)
+
+	// Remote inputs are fetched again to be copied
+	remote := filepath.Join(t.TempDir(), "download")
+	os.WriteFile(remote, []byte("remote junk"), 0o644)
+	p.failed(batchInput{Name: "b.wav", Location: "s3://bucket/b.wav"}, errors.New("truncated"), func() (string, error) { return remote, nil })
+	p.failed(batchInput{Name: "c.wav", Location: "s3://bucket/c.wav"}, errors.New("timeout"), func() (string, error) { return "", errors.New("gone") })
+
+	if data, _ := os.ReadFile(filepath.Join(dir, "take-2.wav")); string(data) != "RIFF junk" {
+		t.Errorf("local copy %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "b.wav")); string(data) != "remote junk" {
+		t.Errorf("remote copy %q", data)
+	}
+
+	file, err := os.Open(filepath.Join(dir, quarantineList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var entries []QuarantineEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e QuarantineEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) != 3 || entries[0].Copy != "take-2.wav" || entries[0].Error != "bad header" || entries[2].Copy != "" {
+		t.Errorf("entries %+v", entries)
+	}
+}
+
+func TestSkipFileDropsOutputs(t *testing.T) {
+	inputDir := t.TempDir()
+	WriteTestAudioFile(filepath.Join(inputDir, "take.wav"), DefaultTestAudio())
+
+	for _, mode := range []string{OnErrorContinue, OnErrorSkipFile} {
+		outputDir := t.TempDir()
+		batch, err := newStorageBatch(inputDir, outputDir, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := Options{Width: 100, Height: 20, PostCmd: "false"}
+		opts.OnError, _ = NewErrorPolicy(mode, "")
+
+		queueDepth.add(1)
+		result, err := processBatchFile(ObjectInfo{Name: "take.wav"}, outputDir, batch, opts)
+		batch.Close()
+		if err == nil {
+			t.Fatalf("%s: the failing post-cmd succeeded", mode)
+		}
+		_, statErr := os.Stat(filepath.Join(outputDir, "take.png"))
+		if kept := statErr == nil; kept != (mode == OnErrorContinue) || kept != (len(result.Outputs) > 0) {
+			t.Errorf("%s: image kept %v, outputs %v", mode, kept, result.Outputs)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import "fmt"
+
+// defaultMaxWidth is the widest image written unless -max-width says
+// otherwise: the widest canvas browsers draw, and well within what image
+// tools open
+const defaultMaxWidth = 32767
+
+// What renders wider than -max-width become
+const (
+	OversizeTile      = "tile"      // an overview at the limit plus tiles of the full width
+	OversizeDownscale = "downscale" // only the overview at the limit
+)
+
+// parseOversize validates an -oversize value
+func parseOversize(s string) (string, error) {
+	switch s {
+	case OversizeTile, OversizeDownscale:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown -oversize %q (want %s or %s)", s, OversizeTile, OversizeDownscale)
+}
+
+// oversized reports whether Width is over MaxWidth
+func (o Options) oversized() bool {
+	return o.MaxWidth > 0 && o.Width > o.MaxWidth
+}
+
+// imageWidth returns the width images are drawn at: Width, or MaxWidth
+// when Width is over it, so no render allocates more than MaxWidth columns
+func (o Options) imageWidth() int {
+	if o.oversized() {
+		return o.MaxWidth
+	}
+	return o.Width
+}
+
+// peakTile is one tile of an oversized render
+type peakTile struct {
+	peaks ChannelPeaks
+	width int
+}
+
+// tilePeaks splits peaks drawn width columns wide into tiles of at most
+// maxWidth columns, left to right. Each tile takes the buckets of its
+// columns, so peaks need not have one bucket per column.
+func tilePeaks(peaks ChannelPeaks, width, maxWidth int) []peakTile {
+	buckets := len(peaks.Min)
+	var tiles []peakTile
+	for lo := 0; lo < width; lo += maxWidth {
+		hi := min(lo+maxWidth, width)
+		first, last := lo*buckets/width, max(hi*buckets/width, lo*buckets/width+1)
+		last = min(last, buckets)
+		tiles = append(tiles, peakTile{
+			peaks: ChannelPeaks{Min: peaks.Min[first:last], Max: peaks.Max[first:last]},
+			width: hi - lo,
+		})
+	}
+	return tiles
+}
+
+// tileFileName returns the name of the n-th tile of an oversized render,
+// counted from 1
+func tileFileName(baseName string, n int) string {
+	return fmt.Sprintf("%s.tile-%03d.png", baseName, n)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTilePeaks(t *testing.T) {
+	peaks := ChannelPeaks{Min: make([]int16, 10), Max: []int16{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}}
+
+	// One bucket per column
+	tiles := tilePeaks(peaks, 10, 4)
+	if len(tiles) != 3 || tiles[0].width != 4 || tiles[2].width != 2 {
+		t.Fatalf("tiles = %+v", tiles)
+	}
+	if tiles[1].peaks.Max[0] != 4 || len(tiles[2].peaks.Max) != 2 {
+		t.Errorf("tiles = %+v", tiles)
+	}
+
+	// Fewer buckets than columns still give every tile one
+	for i, tile := range tilePeaks(peaks, 100, 7) {
+		if len(tile.peaks.Max) == 0 {
+			t.Errorf("tile %d has no buckets", i+1)
+		}
+	}
+}
+
+func TestGenerateOversized(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "take.wav")
+	audio := DefaultTestAudio()
+	audio.Duration = 100 * time.Millisecond
+	if err := WriteTestAudioFile(input, audio); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []string{OversizeTile, OversizeDownscale} {
+		output := t.TempDir()
+		opts := Options{Width: 250, Height: 20, MaxWidth: 100, Oversize: mode}
+		result, err := GenerateStereoWaveforms(batchInput{Name: "take.wav", Path: input, Location: input}, output, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The overview is drawn at the limit, and tiles add the full 250
+		want, outputs := map[string]int{"take.png": 100}, 1
+		if mode == OversizeTile {
+			want["take.tile-001.png"] = 100
+			want["take.tile-003.png"] = 50
+			outputs = 4
+		}
+		if len(result.Outputs) != outputs {
+			t.Errorf("%s: outputs = %q", mode, result.Outputs)
+		}
+		for name, width := range want {
+			file, err := os.Open(filepath.Join(output, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			config, err := png.DecodeConfig(file)
+			file.Close()
+			if err != nil || config.Width != width {
+				t.Errorf("%s: %s is %d wide, want %d (%v)", mode, name, config.Width, width, err)
+			}
+		}
+	}
+}
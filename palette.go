@@ -0,0 +1,94 @@
+package main
+
+import (
+	"cmp"
+	"image"
+	"image/color"
+	"slices"
+)
+
+// maxPaletteColors is the most colors an indexed PNG holds
+const maxPaletteColors = 256
+
+// pngPaletteUsage documents -png-palette wherever PNGs are written
+const pngPaletteUsage = "write indexed-color PNGs: exact for waveforms of up to 256 colors, the nearest of the 256 most common otherwise; several times smaller for web delivery"
+
+// paletted returns img as an indexed-color image, which PNG stores in 1 to
+// 8 bits a pixel instead of 32. Waveforms in a few flat colors convert
+// exactly; images of more colors, such as colormapped ones, keep their 256
+// most common and draw the rest in the nearest of them.
+func paletted(img image.Image) *image.Paletted {
+	bounds := img.Bounds()
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(bounds)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				rgba.Set(x, y, img.At(x, y))
+			}
+		}
+	}
+
+	counts := map[color.RGBA]int{}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := rgba.Pix[rgba.PixOffset(bounds.Min.X, y):rgba.PixOffset(bounds.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			counts[color.RGBA{row[i], row[i+1], row[i+2], row[i+3]}]++
+		}
+	}
+	colors := make([]color.RGBA, 0, len(counts))
+	for c := range counts {
+		colors = append(colors, c)
+	}
+	// Most common first, ties in a fixed order so output is reproducible
+	slices.SortFunc(colors, func(a, b color.RGBA) int {
+		if counts[a] != counts[b] {
+			return counts[b] - counts[a]
+		}
+		return cmp.Compare(packRGBA(a), packRGBA(b))
+	})
+	colors = colors[:min(len(colors), maxPaletteColors)]
+
+	palette := make(color.Palette, len(colors))
+	index := make(map[color.RGBA]uint8, len(counts))
+	for i, c := range colors {
+		palette[i] = c
+		index[c] = uint8(i)
+	}
+
+	out := image.NewPaletted(bounds, palette)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := rgba.Pix[rgba.PixOffset(bounds.Min.X, y):rgba.PixOffset(bounds.Max.X, y)]
+		indices := out.Pix[out.PixOffset(bounds.Min.X, y):]
+		for i := 0; i < len(row); i += 4 {
+			c := color.RGBA{row[i], row[i+1], row[i+2], row[i+3]}
+			n, ok := index[c]
+			if !ok {
+				n = nearestColor(colors, c)
+				index[c] = n
+			}
+			indices[i/4] = n
+		}
+	}
+	return out
+}
+
+// nearestColor returns the index of the color of colors closest to c
+func nearestColor(colors []color.RGBA, c color.RGBA) uint8 {
+	best, bestDistance := 0, -1
+	for i, p := range colors {
+		d := 0
+		for _, delta := range [4]int{int(p.R) - int(c.R), int(p.G) - int(c.G), int(p.B) - int(c.B), int(p.A) - int(c.A)} {
+			d += delta * delta
+		}
+		if bestDistance < 0 || d < bestDistance {
+			best, bestDistance = i, d
+		}
+	}
+	return uint8(best)
+}
+
+// packRGBA packs a color into one number, to order colors by
+func packRGBA(c color.RGBA) uint32 {
+	return uint32(c.R)<<24 | uint32(c.G)<<16 | uint32(c.B)<<8 | uint32(c.A)
+}
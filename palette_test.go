@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestPalettedExact(t *testing.T) {
+	ro := DefaultRenderOptions()
+	ro.Width, ro.Height = 200, 50
+	img, err := drawPeaks(testPeaks(200), ro, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := paletted(img)
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if got := color.RGBAModel.Convert(p.At(x, y)); got != img.RGBAAt(x, y) {
+				t.Fatalf("pixel %d,%d is %v, want %v", x, y, got, img.RGBAAt(x, y))
+			}
+		}
+	}
+
+	var full, indexed bytes.Buffer
+	if err := encodePNG(&full, img, png.DefaultCompression); err != nil {
+		t.Fatal(err)
+	}
+	if err := encodePNG(&indexed, p, png.DefaultCompression); err != nil {
+		t.Fatal(err)
+	}
+	if indexed.Len()*2 > full.Len() {
+		t.Errorf("indexed PNG is %d bytes, full color %d", indexed.Len(), full.Len())
+	}
+	if _, err := png.Decode(&indexed); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPalettedQuantized(t *testing.T) {
+	// 256 grays in two pixels each, then 256 colors a step off them in one
+	img := image.NewRGBA(image.Rect(0, 0, 768, 1))
+	for x := range 512 {
+		v := uint8(x / 2)
+		img.SetRGBA(x, 0, color.RGBA{v, v, v, 255})
+	}
+	for v := range 256 {
+		b := uint8(v) ^ 1
+		img.SetRGBA(512+v, 0, color.RGBA{uint8(v), uint8(v), b, 255})
+	}
+
+	p := paletted(img)
+	if len(p.Palette) != maxPaletteColors {
+		t.Fatalf("%d colors, want %d", len(p.Palette), maxPaletteColors)
+	}
+	for x := range 768 {
+		want, got := img.RGBAAt(x, 0), color.RGBAModel.Convert(p.At(x, 0)).(color.RGBA)
+		if d := int(want.B) - int(got.B); d < -1 || d > 1 || got.R != want.R {
+			t.Fatalf("pixel %d is %v, want about %v", x, got, want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+)
+
+const (
+	// parallelRenderPixels is the image size from which columns are drawn in parallel
+	parallelRenderPixels = 1 << 22
+	// parallelPeakSamples is the sample count from which buckets are computed in parallel
+	parallelPeakSamples = 1 << 22
+)
+
+// parallelRanges splits [0, n) into contiguous ranges, one per CPU, and runs fn
+// on each concurrently, returning once all have finished
+func parallelRanges(n int, fn func(lo, hi int)) {
+	workers := min(runtime.NumCPU(), n)
+	if workers <= 1 {
+		fn(0, n)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		lo := w * n / workers
+		hi := (w + 1) * n / workers
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(lo, hi)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxBaseNameBytes bounds output base names, leaving room within the usual
+// 255 byte file name limit for collision suffixes and extensions such as
+// .spectrum.json
+const maxBaseNameBytes = 200
+
+// reservedNames can't be used as file names on Windows, whatever their
+// extension
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// safeFileName returns name as a file name valid on Windows, macOS and
+// Linux alike: characters that are invalid anywhere (path separators,
+// <>:"|?*, control characters and invalid UTF-8) become '_', trailing dots
+// and spaces are dropped, reserved device names get a '_' appended and
+// overlong names are cut short
+func safeFileName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r < 0x20 || r == 0x7f || r == utf8.RuneError || strings.ContainsRune(`<>:"/\|?*`, r):
+			b.WriteByte('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	safe := b.String()
+
+	if len(safe) > maxBaseNameBytes {
+		cut := maxBaseNameBytes
+		for cut > 0 && !utf8.RuneStart(safe[cut]) {
+			cut--
+		}
+		safe = safe[:cut]
+	}
+
+	safe = strings.TrimRight(safe, ". ")
+	if safe == "" {
+		return "_"
+	}
+	stem, _, _ := strings.Cut(safe, ".")
+	if reservedNames[strings.ToUpper(stem)] {
+		safe = stem + "_" + safe[len(stem):]
+	}
+	return safe
+}
+
+// encryptedSuffix ends the names of inputs written by the encrypt
+// subcommand
+const encryptedSuffix = ".enc"
+
+// isInputName reports whether a batch processes the file of that name: a
+// WAV file, or one encrypted as take.wav.enc
+func isInputName(name string) bool {
+	lower := strings.TrimSuffix(strings.ToLower(name), encryptedSuffix)
+	return strings.HasSuffix(lower, ".wav")
+}
+
+// outputBaseName returns the name the outputs of an input are written
+// under: its file name without the last extension, and without .enc before
+// that, made safe to use on any filesystem. Earlier dots are kept, so
+// take.1.wav and take.2.wav don't share outputs.
+func outputBaseName(name string) string {
+	base := path.Base(strings.ReplaceAll(name, `\`, "/"))
+	if strings.EqualFold(path.Ext(base), encryptedSuffix) {
+		base = base[:len(base)-len(encryptedSuffix)]
+	}
+	return safeFileName(strings.TrimSuffix(base, path.Ext(base)))
+}
+
+// outputBaseNames assigns each input an output base name that no other
+// input of the batch has, ignoring case, since the outputs of Take.wav and
+// take.WAV would overwrite each other on case-insensitive filesystems. Names
+// are assigned in sorted order of the inputs, so every run picks the same
+// ones: the first keeps its name and later ones get a -2, -3... suffix.
+func outputBaseNames(names []string) map[string]string {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+
+	assigned := make(map[string]string, len(names))
+	taken := make(map[string]bool, len(names))
+	for _, name := range sorted {
+		if _, ok := assigned[name]; ok {
+			continue
+		}
+		base := outputBaseName(name)
+		candidate := base
+		for n := 2; taken[strings.ToLower(candidate)]; n++ {
+			candidate = fmt.Sprintf("%s-%d", base, n)
+		}
+		taken[strings.ToLower(candidate)] = true
+		assigned[name] = candidate
+	}
+	return assigned
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestOutputBaseName(t *testing.T) {
+	tests := map[string]string{
+		"take.wav":              "take",
+		"take.1.final.wav":      "take.1.final",
+		"rec/sub/take.wav":      "take",
+		`C:\uploads\take.wav`:   "take",
+		"what? \"mix\" <2>.wav": "what_ _mix_ _2_",
+		"a:b|c*d.wav":           "a_b_c_d",
+		"ends with dot..wav":    "ends with dot",
+		"tab\there.wav":         "tab_here",
+		"Ünïcödé テイク.wav":       "Ünïcödé テイク",
+		"con.wav":               "con_",
+		"LPT1.mix.wav":          "LPT1_.mix",
+		"console.wav":           "console",
+		".wav":                  "_",
+		"...wav":                "_",
+		"bad\xffbyte.wav":       "bad_byte",
+		"take.wav.enc":          "take",
+	}
+	for name, want := range tests {
+		if got := outputBaseName(name); got != want {
+			t.Errorf("outputBaseName(%q) = %q, want %q", name, got, want)
+		}
+	}
+
+	long := outputBaseName(strings.Repeat("é", 150) + ".wav")
+	if len(long) > maxBaseNameBytes || !utf8.ValidString(long) {
+		t.Errorf("long name cut to %d bytes, valid UTF-8 %t", len(long), utf8.ValidString(long))
+	}
+}
+
+func TestOutputBaseNames(t *testing.T) {
+	names := outputBaseNames([]string{"take.WAV", "Take.wav", "take-2.wav", "other.wav", "take.wav"})
+	want := map[string]string{
+		// Sorted: Take.wav, other.wav, take-2.wav, take.WAV, take.wav
+		"Take.wav":   "Take",
+		"other.wav":  "other",
+		"take-2.wav": "take-2",
+		"take.WAV":   "take-3",
+		"take.wav":   "take-4",
+	}
+	for name, base := range want {
+		if names[name] != base {
+			t.Errorf("%s gets %q, want %q", name, names[name], base)
+		}
+	}
+}
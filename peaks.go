@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// peaksMagic identifies a peak file
+var peaksMagic = [4]byte{'W', 'F', 'P', 'K'}
+
+// peaksVersion is the current peak file format version
+const peaksVersion uint16 = 1
+
+// Peaks holds per-bucket min/max amplitudes for every channel of a file
+type Peaks struct {
+	SampleRate      uint32
+	SamplesPerPixel uint32
+	Channels        []ChannelPeaks
+}
+
+// ChannelPeaks holds the min and max sample value of each bucket of one channel
+type ChannelPeaks struct {
+	Min []int16
+	Max []int16
+}
+
+// peaksHeader is the fixed-size header at the start of a peak file
+type peaksHeader struct {
+	Magic           [4]byte
+	Version         uint16
+	NumChannels     uint16
+	SampleRate      uint32
+	SamplesPerPixel uint32
+	NumBuckets      uint32
+}
+
+// Len returns the number of buckets per channel
+func (p *Peaks) Len() int {
+	if len(p.Channels) == 0 {
+		return 0
+	}
+	return len(p.Channels[0].Min)
+}
+
+// ComputePeaks collapses normalized samples into width min/max buckets, as
+// a fitted PeakBuilder does
+func ComputePeaks(samples []float64, width int) ChannelPeaks {
+	if len(samples) < parallelPeakSamples || len(samples) < width {
+		b := newFittedPeakBuilder(len(samples), width)
+		b.Add(samples)
+		return b.Peaks()
+	}
+
+	peaks := newChannelPeaks(width)
+
+	// Buckets are independent, so ranges of them are filled concurrently
+	parallelRanges(width, func(lo, hi int) {
+		for bucket := lo; bucket < hi; bucket++ {
+			start := bucketStart(bucket, len(samples), width)
+			end := bucketStart(bucket+1, len(samples), width)
+
+			minPeak, maxPeak := toInt16(samples[start]), toInt16(samples[start])
+			for _, amp := range samples[start+1 : end] {
+				v := toInt16(amp)
+				minPeak, maxPeak = min(minPeak, v), max(maxPeak, v)
+			}
+			peaks.Min[bucket], peaks.Max[bucket] = minPeak, maxPeak
+		}
+	})
+
+	return peaks
+}
+
+// PeakBuilder accumulates min/max buckets for one channel from samples
+// streamed in any number of chunks
+type PeakBuilder struct {
+	samplesPerPixel int
+	peaks           ChannelPeaks
+	pos             int // samples consumed so far
+
+	// frames, when set, are spread evenly over the buckets instead of
+	// filling samplesPerPixel each. Clips of fewer frames than buckets are
+	// kept in samples and interpolated across them.
+	frames  int
+	samples []int16
+
+	// agg, when set, collapses each bucket's samples its way instead of
+	// into their min and max; acc holds the bucket being filled. Short
+	// clips have less than a sample per bucket, so they are interpolated
+	// whatever agg says.
+	agg *Aggregation
+	acc bucketAggregate
+}
+
+// NewPeakBuilder returns a builder producing width buckets of samplesPerPixel samples
+func NewPeakBuilder(samplesPerPixel, width int) *PeakBuilder {
+	return &PeakBuilder{
+		samplesPerPixel: samplesPerPixel,
+		peaks:           newChannelPeaks(width),
+	}
+}
+
+// newFittedPeakBuilder returns a builder spreading numFrames frames evenly
+// over width buckets, whatever their ratio: each bucket of a longer file
+// gets numFrames/width frames or one more, so none are left out, and a clip
+// shorter than width is interpolated across every bucket rather than
+// filling only the first ones
+func newFittedPeakBuilder(numFrames, width int) *PeakBuilder {
+	b := NewPeakBuilder(samplesPerPixelFor(numFrames, width), width)
+	b.frames = numFrames
+	return b
+}
+
+// bucketOf returns the bucket frame pos falls in when numFrames frames are
+// spread evenly over width buckets
+func bucketOf(pos, numFrames, width int) int {
+	return int(int64(pos) * int64(width) / int64(numFrames))
+}
+
+// bucketStart returns the first frame of a bucket when numFrames frames are
+// spread evenly over width buckets, or numFrames past the last one
+func bucketStart(bucket, numFrames, width int) int {
+	return int((int64(bucket)*int64(numFrames) + int64(width) - 1) / int64(width))
+}
+
+// interpolated reports whether the builder stretches a short clip
+func (b *PeakBuilder) interpolated() bool {
+	return b.frames > 0 && b.frames < len(b.peaks.Min)
+}
+
+// Add feeds the next normalized samples into the builder. Samples past the
+// last bucket are ignored.
+func (b *PeakBuilder) Add(samples []float64) {
+	for _, amp := range samples {
+		if !b.add(toInt16(amp)) {
+			return
+		}
+	}
+}
+
+// AddInt16 feeds the next raw 16-bit samples into the builder, avoiding any
+// float conversion. Samples past the last bucket are ignored.
+func (b *PeakBuilder) AddInt16(samples []int16) {
+	for _, v := range samples {
+		if !b.add(v) {
+			return
+		}
+	}
+}
+
+// add folds one sample into its bucket, reporting false once all buckets are full
+func (b *PeakBuilder) add(v int16) bool {
+	if b.interpolated() {
+		if b.pos >= b.frames {
+			return false
+		}
+		b.samples = append(b.samples, v)
+		b.pos++
+		return true
+	}
+
+	bucket, first := b.pos/b.samplesPerPixel, b.pos%b.samplesPerPixel == 0
+	if b.frames > 0 {
+		bucket = bucketOf(b.pos, b.frames, len(b.peaks.Min))
+		first = b.pos == 0 || bucketOf(b.pos-1, b.frames, len(b.peaks.Min)) != bucket
+	}
+	if bucket >= len(b.peaks.Min) {
+		return false
+	}
+
+	if b.agg != nil {
+		if first {
+			b.flush()
+			b.acc.reset(bucket)
+		}
+		b.acc.add(b.agg, v)
+		b.pos++
+		return true
+	}
+
+	if first {
+		// First sample of this pixel range
+		b.peaks.Min[bucket] = v
+		b.peaks.Max[bucket] = v
+	} else {
+		if v < b.peaks.Min[bucket] {
+			b.peaks.Min[bucket] = v
+		}
+		if v > b.peaks.Max[bucket] {
+			b.peaks.Max[bucket] = v
+		}
+	}
+
+	b.pos++
+	return true
+}
+
+// resume copies the first n buckets of prev into the builder and continues
+// with the sample that starts bucket n
+func (b *PeakBuilder) resume(prev ChannelPeaks, n int) {
+	copy(b.peaks.Min, prev.Min[:n])
+	copy(b.peaks.Max, prev.Max[:n])
+	b.pos = n * b.samplesPerPixel
+	b.acc.reset(n)
+}
+
+// flush writes the aggregate of the bucket being filled into it
+func (b *PeakBuilder) flush() {
+	if b.agg != nil && b.acc.count > 0 {
+		b.peaks.Min[b.acc.bucket], b.peaks.Max[b.acc.bucket] = b.acc.result(b.agg)
+	}
+}
+
+// Peaks returns the buckets built so far; buckets without samples are zero
+func (b *PeakBuilder) Peaks() ChannelPeaks {
+	if b.interpolated() {
+		b.interpolate()
+	}
+	b.flush()
+	return b.peaks
+}
+
+// interpolate fills the buckets of a short clip from its samples. The clip
+// is taken as a line through the samples, at the centers of the frames they
+// stand for, and each bucket covers the span of it under its column.
+// Buckets past the samples decoded so far stay zero.
+func (b *PeakBuilder) interpolate() {
+	samples, width := b.samples, len(b.peaks.Min)
+	if len(samples) == 0 {
+		return
+	}
+
+	at := func(t float64) float64 {
+		t = min(max(t-0.5, 0), float64(len(samples)-1))
+		i := min(int(t), len(samples)-2)
+		if i < 0 {
+			return float64(samples[0])
+		}
+		f := t - float64(i)
+		return float64(samples[i])*(1-f) + float64(samples[i+1])*f
+	}
+
+	scale := float64(b.frames) / float64(width)
+	for x := range width {
+		lo, hi := float64(x)*scale, float64(x+1)*scale
+		if lo >= float64(len(samples)) {
+			break
+		}
+
+		a, c := at(lo), at(min(hi, float64(len(samples))))
+		minPeak, maxPeak := min(a, c), max(a, c)
+		// Samples whose centers fall within the column
+		for i := int(math.Ceil(lo - 0.5)); i < len(samples) && float64(i)+0.5 < hi; i++ {
+			minPeak = min(minPeak, float64(samples[i]))
+			maxPeak = max(maxPeak, float64(samples[i]))
+		}
+		b.peaks.Min[x] = clampInt16(int(math.Round(minPeak)))
+		b.peaks.Max[x] = clampInt16(int(math.Round(maxPeak)))
+	}
+}
+
+// shift adds delta to every bucket, saturating at the 16-bit range
+func (c ChannelPeaks) shift(delta int) {
+	for i := range c.Min {
+		c.Min[i] = clampInt16(int(c.Min[i]) + delta)
+		c.Max[i] = clampInt16(int(c.Max[i]) + delta)
+	}
+}
+
+// scale multiplies every peak by gain, in place, saturating
+func (c ChannelPeaks) scale(gain float64) {
+	if gain == 1 {
+		return
+	}
+	for i := range c.Min {
+		c.Min[i] = clampInt16(int(math.Round(float64(c.Min[i]) * gain)))
+		c.Max[i] = clampInt16(int(math.Round(float64(c.Max[i]) * gain)))
+	}
+}
+
+// clampInt16 saturates v to the 16-bit sample range
+func clampInt16(v int) int16 {
+	return int16(min(max(v, math.MinInt16), math.MaxInt16))
+}
+
+// samplesPerPixelFor returns how many samples fall into one of width buckets
+func samplesPerPixelFor(numSamples, width int) int {
+	samplesPerPixel := numSamples / width
+	if samplesPerPixel == 0 {
+		samplesPerPixel = 1
+	}
+	return samplesPerPixel
+}
+
+// toInt16 converts a normalized sample back to its 16-bit value
+func toInt16(amp float64) int16 {
+	v := math.Round(amp * 32767.0)
+	if v > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if v < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(v)
+}
+
+// WritePeaks writes peaks to w in the binary peak file format. Peaks that
+// ReadPeaks would refuse are refused here instead.
+func WritePeaks(w io.Writer, p *Peaks) error {
+	if len(p.Channels) < 1 || len(p.Channels) > maxPeakChannels {
+		return fmt.Errorf("peak files hold 1 to %d channels, not %d", maxPeakChannels, len(p.Channels))
+	}
+	numBuckets := p.Len()
+	if int64(numBuckets) > math.MaxUint32 {
+		return fmt.Errorf("%d buckets are more than a peak file holds", numBuckets)
+	}
+	for i, ch := range p.Channels {
+		if len(ch.Min) != numBuckets || len(ch.Max) != numBuckets {
+			return fmt.Errorf("channel %d has %d/%d buckets, expected %d", i, len(ch.Min), len(ch.Max), numBuckets)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+
+	header := peaksHeader{
+		Magic:           peaksMagic,
+		Version:         peaksVersion,
+		NumChannels:     uint16(len(p.Channels)),
+		SampleRate:      p.SampleRate,
+		SamplesPerPixel: p.SamplesPerPixel,
+		NumBuckets:      uint32(numBuckets),
+	}
+	if err := binary.Write(bw, binary.LittleEndian, &header); err != nil {
+		return fmt.Errorf("failed to write peaks header: %w", err)
+	}
+
+	for i, ch := range p.Channels {
+		if err := binary.Write(bw, binary.LittleEndian, ch.Min); err != nil {
+			return fmt.Errorf("failed to write min peaks of channel %d: %w", i, err)
+		}
+		if err := binary.Write(bw, binary.LittleEndian, ch.Max); err != nil {
+			return fmt.Errorf("failed to write max peaks of channel %d: %w", i, err)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// maxPeakChannels is the most channels a peak file may hold
+const maxPeakChannels = 2
+
+// peaksReadChunk is how many values ReadPeaks reads at a time when the
+// stream length is unknown, so a corrupt bucket count can't force one huge
+// allocation up front
+const peaksReadChunk = 1 << 16
+
+// ReadPeaks reads peaks previously written by WritePeaks. The header is
+// checked against the stream before anything is sized from it: a seekable r
+// must hold exactly the buckets it declares, and other readers are read in
+// chunks that only grow as data actually arrives.
+func ReadPeaks(r io.Reader) (*Peaks, error) {
+	remaining := int64(-1)
+	if seeker, ok := r.(io.Seeker); ok {
+		if n, err := streamRemaining(seeker); err == nil {
+			remaining = n
+		}
+	}
+
+	br := bufio.NewReader(r)
+
+	var header peaksHeader
+	if err := binary.Read(br, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("failed to read peaks header: %w", err)
+	}
+
+	if header.Magic != peaksMagic {
+		return nil, fmt.Errorf("not a valid peak file")
+	}
+
+	if header.Version != peaksVersion {
+		return nil, fmt.Errorf("unsupported peak file version %d", header.Version)
+	}
+
+	if header.NumChannels < 1 || header.NumChannels > maxPeakChannels {
+		return nil, fmt.Errorf("peak file declares %d channels", header.NumChannels)
+	}
+
+	if remaining >= 0 {
+		want := int64(binary.Size(header)) + int64(header.NumChannels)*int64(header.NumBuckets)*4
+		if want != remaining {
+			return nil, fmt.Errorf("peak file is %d bytes but its header declares %d", remaining, want)
+		}
+	}
+
+	p := &Peaks{
+		SampleRate:      header.SampleRate,
+		SamplesPerPixel: header.SamplesPerPixel,
+		Channels:        make([]ChannelPeaks, header.NumChannels),
+	}
+
+	n := int(header.NumBuckets)
+	for i := range p.Channels {
+		var err error
+		if p.Channels[i].Min, err = readInt16s(br, n); err != nil {
+			return nil, fmt.Errorf("failed to read min peaks of channel %d: %w", i, err)
+		}
+		if p.Channels[i].Max, err = readInt16s(br, n); err != nil {
+			return nil, fmt.Errorf("failed to read max peaks of channel %d: %w", i, err)
+		}
+	}
+
+	return p, nil
+}
+
+// streamRemaining returns how many bytes are left after the current offset
+func streamRemaining(s io.Seeker) (int64, error) {
+	start, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	end, err := s.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := s.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return end - start, nil
+}
+
+// readInt16s reads n little-endian values in chunks of peaksReadChunk
+func readInt16s(r io.Reader, n int) ([]int16, error) {
+	values := make([]int16, 0, min(n, peaksReadChunk))
+	for len(values) < n {
+		chunk := make([]int16, min(n-len(values), peaksReadChunk))
+		if err := binary.Read(r, binary.LittleEndian, chunk); err != nil {
+			return nil, err
+		}
+		values = append(values, chunk...)
+	}
+	return values, nil
+}
+
+// WritePeaksFile writes peaks to the named file. It is written atomically,
+// so concurrent writers and readers never see a partial file.
+func WritePeaksFile(filename string, p *Peaks) error {
+	err := atomicWrite(filename, func(file *os.File) error {
+		return WritePeaks(file, p)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write peak file: %w", err)
+	}
+	return nil
+}
+
+// ReadPeaksFile reads peaks from the named file
+func ReadPeaksFile(filename string) (*Peaks, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open peak file: %w", err)
+	}
+	defer file.Close()
+
+	return ReadPeaks(file)
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPeakBuilder(t *testing.T) {
+	tests := []struct {
+		name            string
+		samples         []int16
+		samplesPerPixel int
+		width           int
+		min, max        []int16
+	}{
+		{"one per bucket", []int16{1, -2, 3}, 1, 3, []int16{1, -2, 3}, []int16{1, -2, 3}},
+		{"pairs", []int16{1, -2, 3, 4, -5, 0}, 2, 3, []int16{-2, 3, -5}, []int16{1, 4, 0}},
+		{"short input", []int16{5, 6}, 2, 2, []int16{5, 0}, []int16{6, 0}},
+		{"excess ignored", []int16{1, 2, 3, 4, 5}, 2, 2, []int16{1, 3}, []int16{2, 4}},
+		{"partial bucket", []int16{7, -7, 9}, 2, 2, []int16{-7, 9}, []int16{7, 9}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewPeakBuilder(tt.samplesPerPixel, tt.width)
+			b.AddInt16(tt.samples)
+
+			got := b.Peaks()
+			if !reflect.DeepEqual(got.Min, tt.min) || !reflect.DeepEqual(got.Max, tt.max) {
+				t.Errorf("got min %v max %v, want min %v max %v", got.Min, got.Max, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestPeakBuilderChunking(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	samples := make([]int16, 10007)
+	for i := range samples {
+		samples[i] = int16(rng.Intn(65536) - 32768)
+	}
+
+	whole := NewPeakBuilder(37, 271)
+	whole.AddInt16(samples)
+
+	for _, chunk := range []int{1, 36, 37, 38, 1000} {
+		b := NewPeakBuilder(37, 271)
+		for i := 0; i < len(samples); i += chunk {
+			b.AddInt16(samples[i:min(i+chunk, len(samples))])
+		}
+		if !reflect.DeepEqual(b.Peaks(), whole.Peaks()) {
+			t.Errorf("chunks of %d give different peaks", chunk)
+		}
+	}
+}
+
+func TestFittedPeakBuilder(t *testing.T) {
+	// 15 frames over 10 buckets: every frame lands in one, none are dropped
+	b := newFittedPeakBuilder(15, 10)
+	for v := int16(1); v <= 15; v++ {
+		b.AddInt16([]int16{v})
+	}
+	got := b.Peaks()
+	want := ChannelPeaks{
+		Min: []int16{1, 3, 4, 6, 7, 9, 10, 12, 13, 15},
+		Max: []int16{2, 3, 5, 6, 8, 9, 11, 12, 14, 15},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("15 frames over 10 buckets = %v, want %v", got, want)
+	}
+
+	// A 3 frame ramp is stretched over 6 buckets as a line through the
+	// frame centers
+	b = newFittedPeakBuilder(3, 6)
+	b.AddInt16([]int16{0, 100, 200})
+	got = b.Peaks()
+	want = ChannelPeaks{
+		Min: []int16{0, 0, 50, 100, 150, 200},
+		Max: []int16{0, 50, 100, 150, 200, 200},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("3 frames over 6 buckets = %v, want %v", got, want)
+	}
+
+	// Buckets past a truncated clip stay empty
+	b = newFittedPeakBuilder(3, 6)
+	b.AddInt16([]int16{100})
+	if got := b.Peaks(); got.Max[1] != 100 || got.Max[2] != 0 {
+		t.Errorf("1 of 3 frames over 6 buckets = %v", got)
+	}
+}
+
+func TestComputePeaksMatchesBuilder(t *testing.T) {
+	// Large enough to take the parallel path
+	samples := make([]float64, parallelPeakSamples*2+123)
+	rng := rand.New(rand.NewSource(2))
+	for i := range samples {
+		samples[i] = rng.Float64()*2 - 1
+	}
+
+	for _, width := range []int{1, 640, 1920} {
+		b := newFittedPeakBuilder(len(samples), width)
+		b.Add(samples)
+
+		if got := ComputePeaks(samples, width); !reflect.DeepEqual(got, b.Peaks()) {
+			t.Errorf("width %d: parallel peaks differ from sequential ones", width)
+		}
+	}
+}
+
+func TestPeaksRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		peaks *Peaks
+	}{
+		{"mono", &Peaks{SampleRate: 44100, SamplesPerPixel: 256, Channels: []ChannelPeaks{
+			{Min: []int16{-1, -32768, 0}, Max: []int16{1, 32767, 0}},
+		}}},
+		{"stereo", &Peaks{SampleRate: 48000, SamplesPerPixel: 1, Channels: []ChannelPeaks{
+			{Min: []int16{-5}, Max: []int16{5}},
+			{Min: []int16{-6}, Max: []int16{6}},
+		}}},
+		{"empty", &Peaks{SampleRate: 8000, SamplesPerPixel: 8, Channels: []ChannelPeaks{
+			{Min: []int16{}, Max: []int16{}},
+		}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WritePeaks(&buf, tt.peaks); err != nil {
+				t.Fatalf("WritePeaks: %v", err)
+			}
+
+			got, err := ReadPeaks(&buf)
+			if err != nil {
+				t.Fatalf("ReadPeaks: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.peaks) {
+				t.Errorf("got %+v, want %+v", got, tt.peaks)
+			}
+
+			file := filepath.Join(t.TempDir(), "x.peaks")
+			if err := WritePeaksFile(file, tt.peaks); err != nil {
+				t.Fatalf("WritePeaksFile: %v", err)
+			}
+			got, err = ReadPeaksFile(file)
+			if err != nil {
+				t.Fatalf("ReadPeaksFile: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.peaks) {
+				t.Errorf("file round trip: got %+v, want %+v", got, tt.peaks)
+			}
+		})
+	}
+}
+
+func TestReadPeaksCorrupt(t *testing.T) {
+	var valid bytes.Buffer
+	p := &Peaks{SampleRate: 44100, SamplesPerPixel: 4, Channels: []ChannelPeaks{{Min: []int16{1, 2}, Max: []int16{3, 4}}}}
+	if err := WritePeaks(&valid, p); err != nil {
+		t.Fatal(err)
+	}
+
+	// patch returns the valid file with a header field overwritten
+	patch := func(offset int, value uint32, size int) []byte {
+		b := bytes.Clone(valid.Bytes())
+		if size == 2 {
+			binary.LittleEndian.PutUint16(b[offset:], uint16(value))
+		} else {
+			binary.LittleEndian.PutUint32(b[offset:], value)
+		}
+		return b
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"magic", append([]byte("XXXX"), valid.Bytes()[4:]...)},
+		{"version", patch(4, 99, 2)},
+		{"no channels", patch(6, 0, 2)},
+		{"too many channels", patch(6, 1000, 2)},
+		{"huge bucket count", patch(16, 1<<31, 4)},
+		{"truncated", valid.Bytes()[:valid.Len()-1]},
+		{"header only", valid.Bytes()[:10]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Both the seekable and the streaming paths must reject it
+			if _, err := ReadPeaks(bytes.NewReader(tt.data)); err == nil {
+				t.Error("ReadPeaks on a seekable reader: expected an error")
+			}
+			if _, err := ReadPeaks(bytes.NewBuffer(tt.data)); err == nil {
+				t.Error("ReadPeaks on a stream: expected an error")
+			}
+
+			file := filepath.Join(t.TempDir(), "bad.peaks")
+			if err := os.WriteFile(file, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ReadPeaksFile(file); err == nil {
+				t.Error("ReadPeaksFile: expected an error")
+			}
+		})
+	}
+}
+
+func TestWritePeaksInvalid(t *testing.T) {
+	bucket := ChannelPeaks{Min: []int16{1, 2}, Max: []int16{3, 4}}
+	tests := []struct {
+		name  string
+		peaks *Peaks
+	}{
+		{"no channels", &Peaks{}},
+		{"too many channels", &Peaks{Channels: []ChannelPeaks{bucket, bucket, bucket}}},
+		{"min and max differ", &Peaks{Channels: []ChannelPeaks{{Min: []int16{1, 2}, Max: []int16{3}}}}},
+		{"channels differ", &Peaks{Channels: []ChannelPeaks{bucket, {Min: []int16{1}, Max: []int16{2}}}}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := WritePeaks(&buf, tt.peaks); err == nil {
+			t.Errorf("%s: WritePeaks wrote %d bytes", tt.name, buf.Len())
+		}
+	}
+}
+
+func TestDecodePeaksFixture(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*TestAudio)
+		width  int
+		peak   int16
+	}{
+		{"sine", func(a *TestAudio) {}, 400, toInt16(0.8)},
+		{"square", func(a *TestAudio) { a.Waveform = "square"; a.Amplitude = 0.5 }, 100, toInt16(0.5)},
+		{"fewer frames than pixels", func(a *TestAudio) {
+			a.Waveform = "square"
+			a.SampleRate = 10000
+			a.Duration = 10 * time.Millisecond
+		}, 400, toInt16(0.8)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audio := DefaultTestAudio()
+			tt.modify(&audio)
+			peaks, n, err := decodePeaks(writeFixture(t, audio), Options{Width: tt.width}, nil)
+			if err != nil {
+				t.Fatalf("decodePeaks: %v", err)
+			}
+			if n != audio.NumFrames() {
+				t.Errorf("decoded %d frames, want %d", n, audio.NumFrames())
+			}
+			if peaks.Len() != tt.width {
+				t.Fatalf("got %d buckets, want %d", peaks.Len(), tt.width)
+			}
+
+			top := int16(0)
+			for _, v := range peaks.Channels[0].Max {
+				top = max(top, v)
+			}
+			if d := int(tt.peak) - int(top); d < 0 || d > int(tt.peak)/100 {
+				t.Errorf("highest bucket = %d, want about %d", top, tt.peak)
+			}
+		})
+	}
+}
+
+func TestPeakBuilderAggregation(t *testing.T) {
+	samples := []int16{3, -4, 0, 0, 10, 1, 2, 3, 1, 1, -30000, 2, 3, 4, 5, 6, 7, 8, 9, 11}
+	tests := []struct {
+		aggregate string
+		min, max  []int16
+	}{
+		{"minmax", []int16{-4, -30000}, []int16{10, 11}},
+		{"peak", []int16{-10, -30000}, []int16{10, 30000}},
+		{"rms", []int16{-4, -9487}, []int16{4, 9487}},
+		{"p90", []int16{-4, -30000}, []int16{3, 9}},
+	}
+	for _, tt := range tests {
+		agg, err := parseAggregation(tt.aggregate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := NewPeakBuilder(10, 2)
+		b.agg = agg
+		// Chunks split buckets, as reads of a file do
+		b.AddInt16(samples[:7])
+		b.AddInt16(samples[7:])
+		got := b.Peaks()
+		if !reflect.DeepEqual(got.Min, tt.min) || !reflect.DeepEqual(got.Max, tt.max) {
+			t.Errorf("%s: got min %v max %v, want min %v max %v", tt.aggregate, got.Min, got.Max, tt.min, tt.max)
+		}
+	}
+
+	for _, s := range []string{"p50", "p101", "median", "p"} {
+		if _, err := parseAggregation(s); err == nil {
+			t.Errorf("%s accepted", s)
+		}
+	}
+}
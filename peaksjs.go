@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// PeaksJSON is the audiowaveform JSON format read by peaks.js. Data holds
+// interleaved min/max pairs, one per bucket. The extended export adds the
+// BucketStats of each bucket as RMS, Clipped and Silent, which peaks.js
+// ignores.
+type PeaksJSON struct {
+	Version         int    `json:"version"`
+	Channels        int    `json:"channels"`
+	SampleRate      int    `json:"sample_rate"`
+	SamplesPerPixel int    `json:"samples_per_pixel"`
+	Bits            int    `json:"bits"`
+	Length          int    `json:"length"`
+	Data            []int  `json:"data"`
+	RMS             []int  `json:"rms,omitempty"`
+	Clipped         []bool `json:"clipped,omitempty"`
+	Silent          []bool `json:"silent,omitempty"`
+}
+
+// peaksFormat is how peaks are exported: with 8 or 16 bit values, and with
+// the BucketStats of each bucket when extended
+type peaksFormat struct {
+	bits     int
+	extended bool
+}
+
+// analyzers returns what decoding needs to run for the export
+func (f peaksFormat) analyzers(opts Options) []namedAnalyzer {
+	if !f.extended {
+		return nil
+	}
+	return []namedAnalyzer{{name: "buckets", Analyzer: newBucketStatsAnalyzer(opts, DefaultAnalysisConfig())}}
+}
+
+// export converts peaks decoded with f's analyzers to the peaks.js format
+func (f peaksFormat) export(p *Peaks, analyzers []namedAnalyzer) PeaksJSON {
+	out := peaksJSON(p, f.bits)
+	for _, a := range analyzers {
+		if stats, ok := a.Result().(BucketStats); ok {
+			out.RMS = make([]int, len(stats.RMS))
+			for i, v := range stats.RMS {
+				out.RMS[i] = int(v) >> bitsShift(f.bits)
+			}
+			out.Clipped, out.Silent = stats.Clipped, stats.Silent
+		}
+	}
+	return out
+}
+
+// bitsShift returns how far 16-bit values are shifted to export them with
+// bits bits; 8 bit data keeps the top byte of each value
+func bitsShift(bits int) int {
+	if bits == 8 {
+		return 8
+	}
+	return 0
+}
+
+// peaksJSON converts peaks to the peaks.js format with 8 or 16 bit values
+func peaksJSON(p *Peaks, bits int) PeaksJSON {
+	out := PeaksJSON{
+		Version:         2,
+		Channels:        len(p.Channels),
+		SampleRate:      int(p.SampleRate),
+		SamplesPerPixel: int(p.SamplesPerPixel),
+		Bits:            bits,
+		Length:          p.Len(),
+		Data:            make([]int, 0, 2*p.Len()*len(p.Channels)),
+	}
+
+	shift := bitsShift(bits)
+
+	// Channels are interleaved per bucket
+	for i := 0; i < p.Len(); i++ {
+		for _, ch := range p.Channels {
+			out.Data = append(out.Data, int(ch.Min[i])>>shift, int(ch.Max[i])>>shift)
+		}
+	}
+
+	return out
+}
+
+// parsePeaksJSON reads peaks from the audiowaveform JSON format, as GET
+// /peaks and audiowaveform itself write it. Version 1 files
+// have no channels field and hold one channel. 8 bit values are scaled back
+// to the 16 bit range.
+func parsePeaksJSON(data []byte) (*Peaks, error) {
+	var in PeaksJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return nil, fmt.Errorf("failed to parse peaks JSON: %w", err)
+	}
+	if in.Version == 1 && in.Channels == 0 {
+		in.Channels = 1
+	}
+	switch {
+	case in.Version != 1 && in.Version != 2:
+		return nil, fmt.Errorf("unsupported peaks JSON version %d", in.Version)
+	case in.Bits != 8 && in.Bits != 16:
+		return nil, fmt.Errorf("unsupported peaks JSON bits %d (want 8 or 16)", in.Bits)
+	case in.Channels < 1:
+		return nil, fmt.Errorf("peaks JSON has %d channels", in.Channels)
+	case in.Length < 0 || len(in.Data) != 2*in.Length*in.Channels:
+		return nil, fmt.Errorf("peaks JSON holds %d values, want %d for %d buckets of %d channels",
+			len(in.Data), 2*in.Length*in.Channels, in.Length, in.Channels)
+	}
+
+	p := &Peaks{SampleRate: uint32(in.SampleRate), SamplesPerPixel: uint32(in.SamplesPerPixel), Channels: make([]ChannelPeaks, in.Channels)}
+	shift := bitsShift(in.Bits)
+	for c := range p.Channels {
+		p.Channels[c] = ChannelPeaks{Min: make([]int16, in.Length), Max: make([]int16, in.Length)}
+	}
+	for i := range in.Length {
+		for c, ch := range p.Channels {
+			pair := in.Data[2*(i*in.Channels+c):]
+			ch.Min[i] = int16(min(max(pair[0]<<shift, math.MinInt16), math.MaxInt16))
+			ch.Max[i] = int16(min(max(pair[1]<<shift, math.MinInt16), math.MaxInt16))
+		}
+	}
+	return p, nil
+}
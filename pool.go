@@ -0,0 +1,72 @@
+package main
+
+import (
+	"image"
+	"sync"
+)
+
+// slicePool recycles slices across files so large batches don't churn the GC
+type slicePool[T any] struct {
+	pool sync.Pool
+}
+
+// get returns a slice of length n, reusing a pooled one when it is big enough.
+// The contents are not cleared.
+func (p *slicePool[T]) get(n int) []T {
+	if s, ok := p.pool.Get().(*[]T); ok && cap(*s) >= n {
+		return (*s)[:n]
+	}
+	return make([]T, n)
+}
+
+// put returns a slice to the pool; it must not be used afterwards
+func (p *slicePool[T]) put(s []T) {
+	if cap(s) == 0 {
+		return
+	}
+	p.pool.Put(&s)
+}
+
+var (
+	blockPool  slicePool[byte]    // raw data chunk blocks
+	pcmPool    slicePool[int16]   // decoded 16-bit sample blocks
+	samplePool slicePool[float64] // normalized sample blocks
+	bucketPool slicePool[int16]   // peak min/max arrays
+	imagePool  sync.Pool          // *image.RGBA
+)
+
+// newChannelPeaks returns zeroed min/max arrays of width buckets from the pool
+func newChannelPeaks(width int) ChannelPeaks {
+	peaks := ChannelPeaks{
+		Min: bucketPool.get(width),
+		Max: bucketPool.get(width),
+	}
+	clear(peaks.Min)
+	clear(peaks.Max)
+	return peaks
+}
+
+// releasePeaks returns the bucket arrays of peaks to the pool
+func releasePeaks(p *Peaks) {
+	for _, ch := range p.Channels {
+		bucketPool.put(ch.Min)
+		bucketPool.put(ch.Max)
+	}
+	p.Channels = nil
+}
+
+// getImage returns a width x height image, reusing a pooled one of the same
+// size when available. The pixels are not cleared.
+func getImage(width, height int) *image.RGBA {
+	if img, ok := imagePool.Get().(*image.RGBA); ok {
+		if img.Bounds().Dx() == width && img.Bounds().Dy() == height {
+			return img
+		}
+	}
+	return image.NewRGBA(image.Rect(0, 0, width, height))
+}
+
+// putImage returns an image to the pool; it must not be used afterwards
+func putImage(img *image.RGBA) {
+	imagePool.Put(img)
+}
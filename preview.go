@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image/png"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// previewServer serves a page for trying render options on the files under
+// Root, redrawn as the options or the file change
+type previewServer struct {
+	*Server
+
+	// poll is how often a watched file is checked for changes
+	poll time.Duration
+}
+
+func (p *previewServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", p.handlePage)
+	mux.HandleFunc("GET /files", p.handleFiles)
+	mux.HandleFunc("GET /events", p.handleEvents)
+	// /waveform and /peaks as the server has them
+	mux.Handle("/", p.Server.Handler(false))
+	return mux
+}
+
+func (p *previewServer) handlePage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, previewPage)
+}
+
+// handleFiles lists the WAV files under Root as paths relative to it
+func (p *previewServer) handleFiles(w http.ResponseWriter, r *http.Request) {
+	var files []string
+	err := filepath.WalkDir(p.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.EqualFold(filepath.Ext(path), ".wav") {
+			rel, err := filepath.Rel(p.Root, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to list files: %v", err), http.StatusInternalServerError)
+		return
+	}
+	sort.Strings(files)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(files)
+}
+
+// handleEvents streams server-sent events naming the version of a file:
+// one at first and another whenever its size or modification time changes
+// and then stays the same for a poll
+func (p *previewServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	inputFile, err := p.resolve(r.Context(), r.URL.Query().Get("file"))
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ticker := time.NewTicker(p.poll)
+	defer ticker.Stop()
+
+	// A version is only sent once it has held for a poll, so a file still
+	// being written isn't announced half way through
+	last, seen := "", ""
+	for {
+		version := fileVersion(inputFile)
+		if version == seen && version != last {
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", version); err != nil {
+				return
+			}
+			flusher.Flush()
+			last = version
+		}
+		seen = version
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fileVersion identifies the state of a file by size and modification
+// time; it is "missing" while the file doesn't exist
+func fileVersion(filename string) string {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return "missing"
+	}
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+}
+
+// runPreview implements the preview subcommand
+func runPreview(args []string) error {
+	fs := flag.NewFlagSet("preview", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8081", "address to listen on")
+	root := fs.String("root", "./audios", "directory holding the WAV files previewed")
+	width := fs.Int("width", 1920, "default image width in pixels")
+	height := fs.Int("height", 640, "default image height in pixels")
+	poll := fs.Duration("poll", 500*time.Millisecond, "how often the previewed file is checked for changes")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	// Nothing is cached, so every change is drawn again
+	s := &Server{Root: *root, Compression: png.BestSpeed, Defaults: DefaultRenderOptions()}
+	s.Defaults.Width = min(max(*width, 1), maxServeWidth)
+	s.Defaults.Height = min(max(*height, 1), maxServeHeight)
+	p := &previewServer{Server: s, poll: max(*poll, 10*time.Millisecond)}
+
+	fmt.Printf("Previewing waveforms of %s on http://%s/\n", *root, *addr)
+
+	if err := http.ListenAndServe(*addr, p.Handler()); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// previewPage lets the look of a render be changed while watching the
+// result. The options are kept in the page URL, so a look can be shared or
+// reloaded.
+const previewPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>only_waveform preview</title>
+<style>
+body { font: 14px sans-serif; margin: 1em; background: #f4f4f4; }
+form { display: flex; flex-wrap: wrap; gap: 1em; align-items: center; margin-bottom: 1em; }
+img { max-width: 100%; border: 1px solid #ccc; background: repeating-conic-gradient(#ddd 0 25%, #fff 0 50%) 0 0 / 16px 16px; }
+#error { color: #b00; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<form id="options">
+<label>File <select name="file"></select></label>
+<label>Width <input name="width" type="number" min="1" max="8192"></label>
+<label>Height <input name="height" type="number" min="1" max="4096"></label>
+<label>Foreground <input name="fg" type="color" value="#000000"></label>
+<label>Background <input name="bg" type="color" value="#ffffff"></label>
+<label>Style <select name="style"><option>line</option><option>bars</option></select></label>
+<label>Scale <select name="scale"><option>linear</option><option>db</option></select></label>
+<label>Colormap <select name="colormap"><option value="">none</option><option>viridis</option><option>magma</option><option>grayscale</option></select></label>
+<label><input name="normalize" type="checkbox"> Normalize</label>
+<label><input name="auto_range" type="checkbox"> Auto-range</label>
+</form>
+<div id="error"></div>
+<img id="waveform" alt="">
+<script>
+const form = document.getElementById("options");
+const img = document.getElementById("waveform");
+const error = document.getElementById("error");
+let version = "", events = null, timer = null;
+
+function params() {
+  const q = new URLSearchParams();
+  for (const el of form.elements) {
+    if (el.type === "checkbox") { if (el.checked) q.set(el.name, "1"); }
+    else if (el.value !== "" && (el.name !== "fg" && el.name !== "bg" || el.dataset.set)) q.set(el.name, el.value.replace("#", ""));
+  }
+  return q;
+}
+
+function render() {
+  const q = params();
+  history.replaceState(null, "", "?" + q);
+  const file = q.get("file");
+  if (!file) return;
+  q.delete("file");
+  q.set("v", version);
+  img.src = "/waveform/" + encodeURI(file) + "?" + q;
+}
+
+function watch() {
+  if (events) events.close();
+  const file = form.elements.file.value;
+  if (!file) return;
+  events = new EventSource("/events?file=" + encodeURIComponent(file));
+  events.onmessage = e => { version = e.data; render(); };
+}
+
+img.onload = () => { error.textContent = ""; };
+img.onerror = () => fetch(img.src).then(r => r.text()).then(t => { error.textContent = t; });
+
+form.addEventListener("input", e => {
+  e.target.dataset.set = "1";
+  if (e.target.name === "file") { watch(); return; }
+  clearTimeout(timer);
+  timer = setTimeout(render, 150);
+});
+
+fetch("/files").then(r => r.json()).then(files => {
+  const select = form.elements.file;
+  for (const f of files || []) select.add(new Option(f, f));
+  const q = new URLSearchParams(location.search);
+  for (const [name, value] of q) {
+    const el = form.elements[name];
+    if (!el) continue;
+    if (el.type === "checkbox") el.checked = value === "1";
+    else { el.value = el.type === "color" ? "#" + value.slice(0, 6) : value; el.dataset.set = "1"; }
+  }
+  watch();
+});
+</script>
+</body>
+</html>
+`
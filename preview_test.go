@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreviewServer(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "takes"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"b.wav", "takes/a.WAV"} {
+		if err := WriteTestAudioFile(filepath.Join(root, name), DefaultTestAudio()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(root, "notes.txt"), []byte("not audio"), 0o644)
+
+	s := &Server{Root: root, Defaults: DefaultRenderOptions()}
+	s.Defaults.Width, s.Defaults.Height = 200, 50
+	p := &previewServer{Server: s, poll: 10 * time.Millisecond}
+	ts := httptest.NewServer(p.Handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), "EventSource") {
+		t.Errorf("page not served: %.100s", page)
+	}
+
+	resp, err = http.Get(ts.URL + "/files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	json.NewDecoder(resp.Body).Decode(&files)
+	resp.Body.Close()
+	if strings.Join(files, ",") != "b.wav,takes/a.WAV" {
+		t.Errorf("files = %q", files)
+	}
+
+	// Renders come from the server's endpoint
+	resp, err = http.Get(ts.URL + "/waveform/b.wav?width=100&style=bars&v=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/png" {
+		t.Errorf("waveform: %s %s", resp.Status, resp.Header.Get("Content-Type"))
+	}
+
+	// A changed file sends a new version, and a removed one "missing"
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+"/events?file=b.wav", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	events := bufio.NewReader(resp.Body)
+	readVersion := func() string {
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v, ok := strings.CutPrefix(strings.TrimSpace(line), "data: "); ok {
+				return v
+			}
+		}
+	}
+
+	file := filepath.Join(root, "b.wav")
+	first := readVersion()
+	if first != fileVersion(file) {
+		t.Errorf("first version = %s, want %s", first, fileVersion(file))
+	}
+	audio := DefaultTestAudio()
+	audio.Duration = 2 * time.Second
+	if err := WriteTestAudioFile(file+".tmp", audio); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(file+".tmp", file); err != nil {
+		t.Fatal(err)
+	}
+	if second, want := readVersion(), fileVersion(file); second != want {
+		t.Errorf("version after rewriting the file = %s, want %s", second, want)
+	}
+	os.Remove(file)
+	if v := readVersion(); v != "missing" {
+		t.Errorf("version of a removed file = %s, want missing", v)
+	}
+}
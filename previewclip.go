@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Formats of -preview-clip
+const (
+	PreviewWAV  = "wav"
+	PreviewMP3  = "mp3"
+	PreviewOpus = "opus"
+)
+
+// previewEncoders are the commands that encode a preview clip of each
+// compressed format, reading WAV on stdin and writing to stdout
+var previewEncoders = map[string]string{
+	PreviewMP3:  "ffmpeg -loglevel error -f wav -i - -c:a libmp3lame -q:a 4 -f mp3 -",
+	PreviewOpus: "ffmpeg -loglevel error -f wav -i - -c:a libopus -b:a 96k -f ogg -",
+}
+
+// parsePreviewFormat checks a -preview-format
+func parsePreviewFormat(format string) (string, error) {
+	switch format {
+	case PreviewWAV, PreviewMP3, PreviewOpus:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown preview format %q (want wav, mp3 or opus)", format)
+}
+
+// loudestWindow returns the first of the n consecutive buckets of peaks
+// with the most energy, taking the larger magnitude of each bucket as its
+// level. Silence and streams of fewer buckets start at 0.
+func loudestWindow(peaks ChannelPeaks, n int) int {
+	numBuckets := len(peaks.Min)
+	if n >= numBuckets {
+		return 0
+	}
+
+	energy := func(i int) float64 {
+		level := max(math.Abs(float64(peaks.Min[i])), math.Abs(float64(peaks.Max[i])))
+		return level * level
+	}
+	sum := 0.0
+	for i := range n {
+		sum += energy(i)
+	}
+	best, bestSum := 0, sum
+	for i := n; i < numBuckets; i++ {
+		sum += energy(i) - energy(i-n)
+		if sum > bestSum {
+			best, bestSum = i-n+1, sum
+		}
+	}
+	return best
+}
+
+// writePreviewClip cuts length frames from frame start out of an input,
+// keeping its format and header chunks, and writes them to clipFile as a
+// WAV file or encoded by encoder, which reads the WAV clip on stdin.
+// Encrypted inputs are cut from their decryption as it streams.
+func writePreviewClip(inputFile, clipFile string, start, length int, encoder string, opts Options) error {
+	source, sourceSize, err := openInputSource(inputFile, opts)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	var head bytes.Buffer
+	header, layout, err := readWAVHeader(io.TeeReader(source, &head))
+	if err != nil {
+		return err
+	}
+	frameSize := int64(header.BlockAlign)
+	if string(header.ChunkID[:]) != "RIFF" || string(header.Format[:]) != "WAVE" || frameSize == 0 {
+		return fmt.Errorf("only WAV files can be cut")
+	}
+
+	dataSize := int64(header.SubChunk2Size)
+	if available := sourceSize - layout.dataOffset; dataSize == 0 || dataSize > available {
+		dataSize = available
+	}
+	offset := min(int64(start)*frameSize, dataSize)
+	size := min(int64(length)*frameSize, dataSize-offset)
+	size -= size % frameSize
+	if _, err := source.Seek(layout.dataOffset+offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	raw := head.Bytes()[:layout.dataOffset]
+	binary.LittleEndian.PutUint32(raw[4:], uint32(layout.dataOffset-8+size))
+	binary.LittleEndian.PutUint32(raw[layout.dataOffset-4:], uint32(size))
+	clip := io.MultiReader(bytes.NewReader(raw), io.LimitReader(source, size))
+
+	return atomicWrite(clipFile, func(out *os.File) error {
+		if encoder == "" {
+			_, err := io.Copy(out, clip)
+			return err
+		}
+		args, err := splitCommand(encoder)
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("empty preview encoder command")
+		}
+		var stderr strings.Builder
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = clip, out, &stderr
+		if err := cmd.Run(); err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return fmt.Errorf("%s: %w: %s", args[0], err, msg)
+			}
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoudestWindow(t *testing.T) {
+	peaks := ChannelPeaks{Min: []int16{-1, -2, -900, -1, -1000, -3}, Max: []int16{1, 2, 100, 800, 5, 3}}
+	if got := loudestWindow(peaks, 2); got != 3 {
+		t.Errorf("loudestWindow = %d, want 3", got)
+	}
+	if got := loudestWindow(peaks, 10); got != 0 {
+		t.Errorf("window longer than the peaks starts at %d", got)
+	}
+}
+
+func TestPreviewClip(t *testing.T) {
+	// A quiet tone with a loud second from 1s to 2s
+	audio := DefaultTestAudio()
+	audio.Amplitude, audio.Duration = 0.01, 4*time.Second
+	input := writeFixture(t, audio)
+	data, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := canonicalHeaderSize + 44100*4; i < canonicalHeaderSize+2*44100*4; i += 2 {
+		binary.LittleEndian.PutUint16(data[i:], uint16(20000))
+	}
+	os.WriteFile(input, data, 0o644)
+
+	outputDir := t.TempDir()
+	opts := Options{Width: 400, Height: 20, PreviewClip: time.Second, PreviewFormat: PreviewWAV}
+	in := batchInput{Name: "fixture.wav", Path: input, Location: input}
+	if _, err := GenerateStereoWaveforms(in, outputDir, opts); err != nil {
+		t.Fatal(err)
+	}
+	clip, err := os.ReadFile(filepath.Join(outputDir, "fixture.preview.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Buckets are 441 frames, so the clip starts within one of the burst
+	want := data[canonicalHeaderSize+44100*4 : canonicalHeaderSize+(2*44100-441)*4]
+	if int64(len(clip)) != canonicalHeaderSize+44100*4 || !bytes.Contains(clip, want) {
+		t.Fatalf("clip of %d bytes doesn't hold the burst", len(clip))
+	}
+	header, _, err := readWAVHeader(bytes.NewReader(clip))
+	if err != nil || header.SubChunk2Size != 44100*4 || header.ChunkSize != 36+44100*4 || header.SampleRate != 44100 {
+		t.Errorf("clip header %+v: %v", header, err)
+	}
+
+	// Compressed formats are piped through the encoder
+	opts.PreviewFormat, opts.PreviewCmd = PreviewMP3, "cat"
+	if _, err := GenerateStereoWaveforms(in, outputDir, opts); err != nil {
+		t.Fatal(err)
+	}
+	if encoded, _ := os.ReadFile(filepath.Join(outputDir, "fixture.preview.mp3")); !bytes.Equal(encoded, clip) {
+		t.Errorf("encoder got %d bytes, want the %d of the clip", len(encoded), len(clip))
+	}
+
+	opts.PreviewCmd = "false"
+	if _, err := GenerateStereoWaveforms(in, outputDir, opts); err == nil {
+		t.Error("a failing encoder succeeded")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	_ "net/http/pprof" // registers /debug/pprof handlers on http.DefaultServeMux
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// startProfiling starts CPU profiling into cpuFile and arranges for a heap
+// profile to be written to memFile; either may be empty. The returned stop
+// function finishes both profiles and must be called before exiting.
+func startProfiling(cpuFile, memFile string) (func(), error) {
+	var cpuOut *os.File
+
+	if cpuFile != "" {
+		f, err := os.Create(cpuFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to start CPU profile: %w", err)
+		}
+		cpuOut = f
+	}
+
+	stop := func() {
+		if cpuOut != nil {
+			pprof.StopCPUProfile()
+			cpuOut.Close()
+		}
+
+		if memFile != "" {
+			if err := writeHeapProfile(memFile); err != nil {
+				fmt.Printf("Warning: %v\n", err)
+			}
+		}
+	}
+
+	return stop, nil
+}
+
+// writeHeapProfile writes the current heap profile to the named file
+func writeHeapProfile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create memory profile: %w", err)
+	}
+	defer f.Close()
+
+	// Get up-to-date statistics
+	runtime.GC()
+
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		return fmt.Errorf("failed to write memory profile: %w", err)
+	}
+
+	return nil
+}
+
+// servePprof serves the net/http/pprof endpoints on addr in the background
+func servePprof(addr string) {
+	go func() {
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			fmt.Printf("Warning: pprof server stopped: %v\n", err)
+		}
+	}()
+	fmt.Printf("Serving pprof on http://%s/debug/pprof/\n", addr)
+}
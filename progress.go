@@ -0,0 +1,66 @@
+package main
+
+import "sync"
+
+// progressSteps is how many times per phase the progress callbacks are invoked
+const progressSteps = 100
+
+// Progress holds optional callbacks used to report how far a long file has got.
+// Fractions are in the range [0, 1]; either callback may be nil.
+type Progress struct {
+	OnDecodeProgress func(frac float64)
+	OnRenderProgress func(frac float64)
+}
+
+// decode reports decoding progress, ignoring a nil receiver or callback
+func (p *Progress) decode(frac float64) {
+	if p != nil && p.OnDecodeProgress != nil {
+		p.OnDecodeProgress(frac)
+	}
+}
+
+// render reports rendering progress, ignoring a nil receiver or callback
+func (p *Progress) render(frac float64) {
+	if p != nil && p.OnRenderProgress != nil {
+		p.OnRenderProgress(frac)
+	}
+}
+
+// progressInterval returns how many units of work pass between two progress reports
+func progressInterval(total int) int {
+	interval := total / progressSteps
+	if interval == 0 {
+		interval = 1
+	}
+	return interval
+}
+
+// progressCounter reports progress for units of work that may be completed
+// by several goroutines at once
+type progressCounter struct {
+	mu          sync.Mutex
+	done        int
+	total       int
+	reportEvery int
+	report      func(frac float64)
+}
+
+// newProgressCounter returns a counter calling report every 1/progressSteps of total
+func newProgressCounter(total int, report func(frac float64)) *progressCounter {
+	return &progressCounter{
+		total:       total,
+		reportEvery: progressInterval(total),
+		report:      report,
+	}
+}
+
+// step records one unit of work as about to be done
+func (c *progressCounter) step() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done%c.reportEvery == 0 {
+		c.report(float64(c.done) / float64(c.total))
+	}
+	c.done++
+}
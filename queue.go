@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QueuedJob is a job message taken from a queue, to be acknowledged once
+// it has been processed
+type QueuedJob struct {
+	Body []byte
+
+	// handle identifies the delivery to the queue
+	handle string
+}
+
+// JobQueue delivers render jobs to a worker
+type JobQueue interface {
+	// Receive waits for the next job. It returns nil without an error when
+	// none arrived in a while, so callers can check for shutdown.
+	Receive(ctx context.Context) (*QueuedJob, error)
+	// Ack removes a processed job from the queue
+	Ack(ctx context.Context, job *QueuedJob) error
+	// Fail gives up on a job that couldn't be processed
+	Fail(ctx context.Context, job *QueuedJob) error
+}
+
+// openQueue returns the queue for a -queue URL: redis:// or rediss:// for a
+// Redis list, sqs:// for an SQS queue URL without its https scheme, kafka://
+// for a Kafka topic
+func openQueue(location string) (JobQueue, error) {
+	switch {
+	case strings.HasPrefix(location, "redis://"), strings.HasPrefix(location, "rediss://"):
+		return newRedisQueue(location)
+	case strings.HasPrefix(location, "sqs://"):
+		return newSQSQueue(location)
+	case strings.HasPrefix(location, "kafka://"):
+		return newKafkaQueue(location)
+	}
+	return nil, fmt.Errorf("unknown queue %q (want redis://, rediss://, sqs:// or kafka://)", location)
+}
+
+// redisPollSeconds is how long a Redis receive blocks before returning empty
+const redisPollSeconds = 5
+
+// redisQueue is a Redis list that producers LPUSH job messages onto. Jobs
+// are moved atomically to <list>:processing while they run and removed on
+// success; failed jobs go to <list>:failed.
+type redisQueue struct {
+	addr     string
+	useTLS   bool
+	user     string
+	password string
+	db       int
+	list     string
+
+	// receive blocks in BLMOVE, so acknowledgements use their own
+	// connection
+	mu      sync.Mutex
+	receive *redisConn
+	control *redisConn
+}
+
+// newRedisQueue opens redis://[user:password@]host[:port][/db][?list=name];
+// the list defaults to waveform:jobs
+func newRedisQueue(location string) (*redisQueue, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", location, err)
+	}
+
+	q := &redisQueue{addr: u.Host, useTLS: u.Scheme == "rediss", list: u.Query().Get("list")}
+	if u.Port() == "" {
+		q.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		q.user = u.User.Username()
+		q.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if q.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("%s: bad database number %q", location, db)
+		}
+	}
+	if q.list == "" {
+		q.list = "waveform:jobs"
+	}
+	return q, nil
+}
+
+// conn returns the connection in *c, dialing a new one when there is none
+func (q *redisQueue) conn(ctx context.Context, c **redisConn) (*redisConn, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if *c != nil {
+		return *c, nil
+	}
+
+	conn, err := dialRedis(ctx, q.addr, q.useTLS)
+	if err != nil {
+		return nil, err
+	}
+	if q.password != "" {
+		args := []string{"AUTH", q.password}
+		if q.user != "" {
+			args = []string{"AUTH", q.user, q.password}
+		}
+		if _, err := conn.do(args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if q.db != 0 {
+		if _, err := conn.do("SELECT", strconv.Itoa(q.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	*c = conn
+	return conn, nil
+}
+
+// run sends a command on *c, dropping the connection when it breaks so the
+// next command reconnects
+func (q *redisQueue) run(ctx context.Context, c **redisConn, args ...string) (any, error) {
+	conn, err := q.conn(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.do(args...)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		q.mu.Lock()
+		if *c == conn {
+			conn.Close()
+			*c = nil
+		}
+		q.mu.Unlock()
+	}
+	return reply, err
+}
+
+func (q *redisQueue) Receive(ctx context.Context) (*QueuedJob, error) {
+	reply, err := q.run(ctx, &q.receive, "BLMOVE", q.list, q.list+":processing", "RIGHT", "LEFT", strconv.Itoa(redisPollSeconds))
+	if err != nil {
+		return nil, err
+	}
+	body, ok := reply.([]byte)
+	if !ok {
+		return nil, nil // timed out
+	}
+	return &QueuedJob{Body: body}, nil
+}
+
+func (q *redisQueue) Ack(ctx context.Context, job *QueuedJob) error {
+	_, err := q.run(ctx, &q.control, "LREM", q.list+":processing", "1", string(job.Body))
+	return err
+}
+
+func (q *redisQueue) Fail(ctx context.Context, job *QueuedJob) error {
+	if _, err := q.run(ctx, &q.control, "LPUSH", q.list+":failed", string(job.Body)); err != nil {
+		return err
+	}
+	return q.Ack(ctx, job)
+}
+
+// redisConn is a connection speaking RESP, the Redis protocol. Commands
+// from several goroutines take turns.
+type redisConn struct {
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// redisError is an error reply from the server
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// dialRedis connects to a Redis server
+func dialRedis(ctx context.Context, addr string, useTLS bool) (*redisConn, error) {
+	d := net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	var err error
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		conn, err = (&tls.Dialer{NetDialer: &d, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = d.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &redisConn{conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// do sends a command and reads its reply: a string for status replies, an
+// int64, []byte or nil for null bulk strings, or []any. Error replies are
+// returned as redisError.
+func (c *redisConn) do(args ...string) (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	// Blocking commands wait on the server, so allow for that
+	c.conn.SetDeadline(time.Now().Add(30*time.Second + redisPollSeconds*time.Second))
+	if _, err := c.conn.Write(b.Bytes()); err != nil {
+		return nil, err
+	}
+	return c.reply()
+}
+
+// reply reads one reply
+func (c *redisConn) reply() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err // $-1 is a null bulk string
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = c.reply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// Close closes the connection
+func (c *redisConn) Close() error {
+	return c.conn.Close()
+}
+
+// sqsWaitSeconds is the long polling time of an SQS receive
+const sqsWaitSeconds = 20
+
+// sqsQueue is an Amazon SQS queue, used through its JSON protocol. Failed
+// jobs are left alone: they come back once the visibility timeout expires,
+// so the queue's redrive policy decides how often they are retried.
+type sqsQueue struct {
+	client   *S3Client // only for the credentials, region and HTTP client
+	queueURL string
+	endpoint string
+}
+
+// newSQSQueue opens sqs://sqs.<region>.amazonaws.com/<account>/<queue>.
+// Credentials are the AWS_ ones S3 uses; AWS_ENDPOINT_URL points at a local
+// SQS compatible service instead.
+func newSQSQueue(location string) (*sqsQueue, error) {
+	client, err := NewS3ClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	rest, _ := strings.CutPrefix(location, "sqs://")
+	host, _, _ := strings.Cut(rest, "/")
+	if host == "" {
+		return nil, fmt.Errorf("%s: missing queue host", location)
+	}
+
+	q := &sqsQueue{client: client, queueURL: "https://" + rest, endpoint: "https://" + host}
+	if client.Endpoint != "" {
+		q.endpoint = client.Endpoint
+		q.queueURL = client.Endpoint + "/" + strings.TrimPrefix(rest, host+"/")
+	}
+	if os.Getenv("AWS_REGION") == "" {
+		// sqs.<region>.amazonaws.com names its region
+		if parts := strings.Split(host, "."); len(parts) == 4 && parts[0] == "sqs" {
+			client.Region = parts[1]
+		}
+	}
+	return q, nil
+}
+
+// call sends one SQS action and decodes its result into out
+func (q *sqsQueue) call(ctx context.Context, action string, params map[string]any, out any) error {
+	params["QueueUrl"] = q.queueURL
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, q.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "AmazonSQS."+action)
+
+	sum := sha256.Sum256(body)
+	q.client.signService(req, "sqs", hex.EncodeToString(sum[:]), time.Now().UTC())
+
+	resp, err := q.client.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to parse SQS %s response: %w", action, err)
+	}
+	return nil
+}
+
+func (q *sqsQueue) Receive(ctx context.Context) (*QueuedJob, error) {
+	var result struct {
+		Messages []struct {
+			ReceiptHandle string
+			Body          string
+		}
+	}
+	err := q.call(ctx, "ReceiveMessage", map[string]any{"MaxNumberOfMessages": 1, "WaitTimeSeconds": sqsWaitSeconds}, &result)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Messages) == 0 {
+		return nil, nil
+	}
+	m := result.Messages[0]
+	return &QueuedJob{Body: []byte(m.Body), handle: m.ReceiptHandle}, nil
+}
+
+func (q *sqsQueue) Ack(ctx context.Context, job *QueuedJob) error {
+	return q.call(ctx, "DeleteMessage", map[string]any{"ReceiptHandle": job.handle}, nil)
+}
+
+func (q *sqsQueue) Fail(ctx context.Context, job *QueuedJob) error {
+	return nil
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRedisServer is an in-memory Redis server with the list commands
+// redisQueue uses. BLMOVE returns at once when the list is empty.
+type fakeRedisServer struct {
+	ln    net.Listener
+	mu    sync.Mutex
+	lists map[string][]string
+	// commands records every command received
+	commands []string
+}
+
+func newFakeRedisServer(t *testing.T) *fakeRedisServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeRedisServer{ln: ln, lists: map[string][]string{}}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeRedisServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.commands = append(s.commands, strings.Join(args, " "))
+		switch args[0] {
+		case "AUTH", "SELECT":
+			io.WriteString(conn, "+OK\r\n")
+		case "LPUSH":
+			s.lists[args[1]] = append([]string{args[2]}, s.lists[args[1]]...)
+			fmt.Fprintf(conn, ":%d\r\n", len(s.lists[args[1]]))
+		case "BLMOVE":
+			src := s.lists[args[1]]
+			if len(src) == 0 {
+				io.WriteString(conn, "$-1\r\n")
+				break
+			}
+			v := src[len(src)-1]
+			s.lists[args[1]] = src[:len(src)-1]
+			s.lists[args[2]] = append([]string{v}, s.lists[args[2]]...)
+			fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+		case "LREM":
+			removed := 0
+			list := s.lists[args[1]]
+			for i, v := range list {
+				if v == args[3] {
+					s.lists[args[1]] = append(list[:i:i], list[i+1:]...)
+					removed = 1
+					break
+				}
+			}
+			fmt.Fprintf(conn, ":%d\r\n", removed)
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		s.mu.Unlock()
+	}
+}
+
+// readRESPCommand reads a command sent as an array of bulk strings
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func TestRedisQueue(t *testing.T) {
+	server := newFakeRedisServer(t)
+	server.lists["renders"] = []string{`{"input":"b.wav"}`, `{"input":"a.wav"}`}
+
+	q, err := openQueue("redis://:secret@" + server.ln.Addr().String() + "/2?list=renders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	first, err := q.Receive(ctx)
+	if err != nil || first == nil {
+		t.Fatalf("Receive = %v, %v", first, err)
+	}
+	if string(first.Body) != `{"input":"a.wav"}` {
+		t.Errorf("first job = %s, want the oldest one", first.Body)
+	}
+	second, err := q.Receive(ctx)
+	if err != nil || second == nil {
+		t.Fatalf("Receive = %v, %v", second, err)
+	}
+	if empty, err := q.Receive(ctx); empty != nil || err != nil {
+		t.Errorf("Receive on an empty queue = %v, %v, want nil, nil", empty, err)
+	}
+
+	if err := q.Ack(ctx, first); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	if err := q.Fail(ctx, second); err != nil {
+		t.Fatalf("Fail: %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.lists["renders"]) != 0 || len(server.lists["renders:processing"]) != 0 {
+		t.Errorf("jobs left behind: %v", server.lists)
+	}
+	if got := server.lists["renders:failed"]; len(got) != 1 || got[0] != `{"input":"b.wav"}` {
+		t.Errorf("failed list = %v", got)
+	}
+	if server.commands[0] != "AUTH secret" || server.commands[1] != "SELECT 2" {
+		t.Errorf("connection setup = %v, want AUTH and SELECT first", server.commands[:2])
+	}
+}
+
+func TestNewRedisQueue(t *testing.T) {
+	// settings are the fields of a redisQueue that come from its URL
+	type settings struct {
+		addr, user, password, list string
+		useTLS                     bool
+		db                         int
+	}
+	tests := []struct {
+		location string
+		want     settings
+		wantErr  bool
+	}{
+		{"redis://localhost", settings{addr: "localhost:6379", list: "waveform:jobs"}, false},
+		{"rediss://me:pw@cache.example.com:6380/3?list=jobs", settings{addr: "cache.example.com:6380", useTLS: true, user: "me", password: "pw", db: 3, list: "jobs"}, false},
+		{"redis://localhost/zero", settings{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.location, func(t *testing.T) {
+			got, err := newRedisQueue(tt.location)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			s := settings{addr: got.addr, user: got.user, password: got.password, list: got.list, useTLS: got.useTLS, db: got.db}
+			if s != tt.want {
+				t.Errorf("got %+v, want %+v", s, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQSQueue(t *testing.T) {
+	var mu sync.Mutex
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/sqs/aws4_request") {
+			http.Error(w, "bad signature scope: "+r.Header.Get("Authorization"), http.StatusForbidden)
+			return
+		}
+
+		var params map[string]any
+		json.NewDecoder(r.Body).Decode(&params)
+		if !strings.HasSuffix(params["QueueUrl"].(string), "/123456789012/renders") {
+			http.Error(w, "bad queue URL", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonSQS.ReceiveMessage":
+			fmt.Fprint(w, `{"Messages":[{"MessageId":"1","ReceiptHandle":"handle-1","Body":"{\"input\":\"a.wav\"}"}]}`)
+		case "AmazonSQS.DeleteMessage":
+			mu.Lock()
+			deleted = append(deleted, params["ReceiptHandle"].(string))
+			mu.Unlock()
+			fmt.Fprint(w, `{}`)
+		default:
+			http.Error(w, "unknown target", http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+
+	q, err := openQueue("sqs://sqs.eu-west-1.amazonaws.com/123456789012/renders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	job, err := q.Receive(ctx)
+	if err != nil || job == nil {
+		t.Fatalf("Receive = %v, %v", job, err)
+	}
+	if string(job.Body) != `{"input":"a.wav"}` {
+		t.Errorf("Body = %s", job.Body)
+	}
+	if err := q.Ack(ctx, job); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(deleted) != 1 || deleted[0] != "handle-1" {
+		t.Errorf("deleted %v, want [handle-1]", deleted)
+	}
+}
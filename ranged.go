@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// RangeStorage is storage that can read part of a file, so a stretch of a
+// long remote recording is rendered without downloading all of it
+type RangeStorage interface {
+	// OpenRange streams length bytes of a file from offset, or everything
+	// from offset on when length is negative; the caller closes it
+	OpenRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error)
+}
+
+// errNotRangeable is returned by downloadWindow for inputs that have to be
+// downloaded whole
+var errNotRangeable = errors.New("the input can't be read in parts")
+
+// byteRange returns the Range header of length bytes from offset
+func byteRange(offset, length int64) string {
+	if length < 0 {
+		return fmt.Sprintf("bytes=%d-", offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+}
+
+// rangeBody returns the part of a response to a range request that was
+// asked for: the body of a 206, or the same part of the body of a server
+// that ignored the range and sent the whole file
+func rangeBody(resp *http.Response, offset, length int64) (io.ReadCloser, error) {
+	if resp.StatusCode != http.StatusPartialContent {
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	if length < 0 {
+		return resp.Body, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(resp.Body, length), resp.Body}, nil
+}
+
+// fetchInput downloads an input. When only a stretch of it is rendered
+// and its storage can read parts of files, only the header and that
+// stretch of the data chunk are, as a WAV file of just the stretch;
+// windowed reports whether that was done.
+func (b *storageBatch) fetchInput(name string, opts Options) (localFile string, windowed bool, err error) {
+	ranged, ok := b.input.(RangeStorage)
+	// Checksums are of whole files
+	if !ok || !opts.windowed() || opts.Checksums != nil || opts.HashInputs {
+		localFile, err = b.fetch(name)
+		return localFile, false, err
+	}
+
+	localFile, err = b.fetchWith(name, func(localFile string) error {
+		return downloadWindow(ranged, name, localFile, opts)
+	})
+	if errors.Is(err, errNotRangeable) {
+		localFile, err = b.fetch(name)
+		return localFile, false, err
+	}
+	return localFile, err == nil, err
+}
+
+// maxRangedHeader bounds the bytes read for the header of a ranged input;
+// one whose chunks before the data run longer is downloaded whole
+const maxRangedHeader = 64 << 10
+
+// downloadWindow makes one attempt at copying the stretch of an input
+// Start and End ask for to localFile, with the input's header and the
+// chunks before its data. The tags after the data chunk aren't read.
+func downloadWindow(ranged RangeStorage, name, localFile string, opts Options) error {
+	ctx := context.Background()
+
+	// Most files have nothing but their header before their samples, so
+	// more is only read for those that don't
+	var header WAVHeader
+	var layout wavLayout
+	var head bytes.Buffer
+	for _, size := range []int64{canonicalHeaderSize, maxRangedHeader} {
+		body, err := ranged.OpenRange(ctx, name, 0, size)
+		if err != nil {
+			return err
+		}
+		head.Reset()
+		header, layout, err = readWAVHeader(io.TeeReader(body, &head))
+		body.Close()
+		if err == nil {
+			break
+		}
+		if size == maxRangedHeader {
+			return errNotRangeable
+		}
+	}
+	frameSize := int64(header.NumChannels) * int64(header.BitsPerSample/8)
+	if string(header.ChunkID[:]) != "RIFF" || string(header.Format[:]) != "WAVE" || frameSize == 0 {
+		// Encrypted inputs, for one, are decoded from the whole file
+		return errNotRangeable
+	}
+	headerSize := layout.dataOffset
+
+	start, end := opts.windowFrames(header.SampleRate)
+	offset, length := headerSize+int64(start)*frameSize, int64(-1)
+	if end > 0 {
+		length = int64(end-start) * frameSize
+	}
+	if declared := int64(header.SubChunk2Size); declared > 0 {
+		dataEnd := headerSize + declared
+		if offset >= dataEnd {
+			return fmt.Errorf("-start is past the end of the file (%d frames)", declared/frameSize)
+		}
+		if length < 0 || offset+length > dataEnd {
+			length = dataEnd - offset
+		}
+	}
+
+	body, err := ranged.OpenRange(ctx, name, offset, length)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	file, err := os.Create(localFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// The data size is only known once the stretch is in, since it may
+	// run past the end of a file whose header doesn't say how long it is
+	if _, err := file.Seek(headerSize, io.SeekStart); err != nil {
+		return err
+	}
+	n, err := io.Copy(file, body)
+	if err != nil {
+		return err
+	}
+	n -= n % frameSize
+	raw := head.Bytes()[:headerSize]
+	binary.LittleEndian.PutUint32(raw[4:], uint32(headerSize-8+n))
+	binary.LittleEndian.PutUint32(raw[headerSize-4:], uint32(n))
+	if _, err := file.WriteAt(raw, 0); err != nil {
+		return err
+	}
+	if err := file.Truncate(headerSize + n); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// httpStorage is a directory on a web server, read over HTTP(S) GETs, as
+// inputs of worker jobs are. It can't be listed or written to.
+type httpStorage struct {
+	base string // without a trailing slash
+	http *http.Client
+}
+
+// newHTTPStorage opens an http:// or https:// location
+func newHTTPStorage(location string) *httpStorage {
+	return &httpStorage{base: strings.TrimSuffix(location, "/"), http: http.DefaultClient}
+}
+
+func (s *httpStorage) List(ctx context.Context) ([]ObjectInfo, error) {
+	return nil, fmt.Errorf("%s: web server directories can't be listed; give the file as a worker job instead", s.base)
+}
+
+func (s *httpStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := s.get(ctx, name, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *httpStorage) OpenRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	resp, err := s.get(ctx, name, byteRange(offset, length))
+	if err != nil {
+		return nil, err
+	}
+	return rangeBody(resp, offset, length)
+}
+
+func (s *httpStorage) Put(ctx context.Context, name string, r io.Reader, size int64, contentType string) error {
+	return fmt.Errorf("%s: web server directories are read-only", s.base)
+}
+
+// get sends a GET for a file, of a range of it when byteRange is set
+func (s *httpStorage) get(ctx context.Context, name, byteRange string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.base+"/"+url.PathEscape(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWindowedRender(t *testing.T) {
+	input := writeFixture(t, DefaultTestAudio())
+	in := batchInput{Name: "fixture.wav", Path: input, Location: input}
+
+	opts := Options{Width: 100, Height: 20, Start: 250 * time.Millisecond, End: 750 * time.Millisecond}
+	result, err := GenerateStereoWaveforms(in, t.TempDir(), opts)
+	if err != nil || result.Frames != 22050 {
+		t.Errorf("rendered %d frames, %v; want 22050", result.Frames, err)
+	}
+
+	// An end past the file is its end
+	opts.End = time.Hour
+	if result, err := GenerateStereoWaveforms(in, t.TempDir(), opts); err != nil || result.Frames != 33075 {
+		t.Errorf("rendered %d frames, %v; want 33075", result.Frames, err)
+	}
+
+	opts.Start, opts.End = 2*time.Second, 0
+	if _, err := GenerateStereoWaveforms(in, t.TempDir(), opts); err == nil {
+		t.Error("-start past the end rendered")
+	}
+}
+
+func TestRangedFetch(t *testing.T) {
+	audio := DefaultTestAudio()
+	audio.Duration = 4 * time.Second
+	input := writeFixture(t, audio)
+	data, _ := os.ReadFile(input)
+
+	for _, ignoreRanges := range []bool{false, true} {
+		var sent atomic.Int64
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/audio/take.wav" {
+				http.NotFound(w, r)
+				return
+			}
+			if ignoreRanges {
+				r.Header.Del("Range")
+			}
+			counted := &countingWriter{ResponseWriter: w, n: &sent}
+			http.ServeContent(counted, r, "take.wav", time.Time{}, bytes.NewReader(data))
+		}))
+
+		batch, err := newStorageBatch(srv.URL+"/audio", t.TempDir(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := Options{Width: 100, Height: 20, Start: time.Second, End: 2 * time.Second}
+		queueDepth.add(1)
+		remote, err := processBatchFile(ObjectInfo{Name: "take.wav"}, t.TempDir(), batch, opts)
+		batch.Close()
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		local, err := GenerateStereoWaveforms(batchInput{Name: "take.wav", Path: input, Location: input}, t.TempDir(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := os.ReadFile(local.Outputs[0])
+		got, _ := os.ReadFile(remote.Outputs[0])
+		if !bytes.Equal(got, want) || remote.Frames != local.Frames {
+			t.Errorf("ignoring ranges %v: the ranged copy rendered differently", ignoreRanges)
+		}
+		if !ignoreRanges && sent.Load() > int64(len(data))/3 {
+			t.Errorf("%d of %d bytes downloaded for a quarter of the file", sent.Load(), len(data))
+		}
+	}
+}
+
+func TestRangedFetchExtensible(t *testing.T) {
+	data, _ := os.ReadFile(writeExtensibleFixture(t))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "surround.wav", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	s, err := openStorage(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := filepath.Join(t.TempDir(), "window.wav")
+	opts := Options{Start: 250 * time.Microsecond, End: 750 * time.Microsecond}
+	if err := downloadWindow(s.(RangeStorage), "surround.wav", local, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// The chunks before the data, channel mask and all, come along
+	info, err := probeWAV(local)
+	if err != nil || info.NumFrames != 4 || info.ChannelMask != 0x603 {
+		t.Errorf("window %+v, %v; want 4 frames of FL,FR,SL,SR", info, err)
+	}
+	sel, _ := parseChannels("SR")
+	if r, err := openWAVChannels(local, false, false, sel); err != nil {
+		t.Error(err)
+	} else {
+		if left, _, _ := r.readPCM(); len(left) != 4 || left[0] != 4000 {
+			t.Errorf("SR of the window decodes %v", left)
+		}
+		r.Close()
+	}
+}
+
+func TestHTTPStorageReadOnly(t *testing.T) {
+	s, err := openStorage("https://media.example.com/takes/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.List(t.Context()); err == nil || !strings.Contains(err.Error(), "can't be listed") {
+		t.Errorf("List: %v", err)
+	}
+	if err := s.Put(t.Context(), "x.png", nil, 0, ""); err == nil {
+		t.Error("Put succeeded")
+	}
+}
+
+// countingWriter counts the body bytes written to a response
+type countingWriter struct {
+	http.ResponseWriter
+	n *atomic.Int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n.Add(int64(len(p)))
+	return w.ResponseWriter.Write(p)
+}
@@ -0,0 +1,11 @@
+//go:build js
+
+package main
+
+import "os"
+
+// reloadSignals returns nil where there is no SIGHUP: the configuration is
+// never reloaded
+func reloadSignals() <-chan os.Signal {
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !js
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reloadSignals returns the channel SIGHUP is delivered on, which asks the
+// daemon to reload its configuration
+func reloadSignals() <-chan os.Signal {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	return c
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// readPeaksAny reads a peak file in the binary format of -cache-dir, or in
+// the audiowaveform JSON format when it ends in .json or starts with '{'
+func readPeaksAny(filename string) (*Peaks, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open peak file: %w", err)
+	}
+	if strings.EqualFold(filepath.Ext(filename), ".json") || bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return parsePeaksJSON(data)
+	}
+	return ReadPeaks(bytes.NewReader(data))
+}
+
+// runRenderPeaks implements the render-peaks subcommand
+func runRenderPeaks(args []string) error {
+	fs := flag.NewFlagSet("render-peaks", flag.ExitOnError)
+	output := fs.String("o", "", "image to write (default the peak file's name ending in .png)")
+	width := fs.Int("width", 1920, "image width in pixels")
+	height := fs.Int("height", 640, "image height in pixels")
+	maxWidth := fs.Int("max-width", defaultMaxWidth, "widest image drawn; wider renders are drawn this wide instead (0 is unlimited)")
+	oversize := fs.String("oversize", OversizeTile, "what renders wider than -max-width also write: tile for full resolution tiles next to the image, or downscale for nothing more")
+	channel := fs.Int("channel", 1, "channel of the peak file to render, counted from 1")
+	fg := fs.String("fg", "", "waveform color as RRGGBB or RRGGBBAA")
+	bg := fs.String("bg", "", "background color as RRGGBB or RRGGBBAA")
+	style := fs.String("style", StyleLine, "render style: line or bars")
+	scale := fs.String("scale", ScaleLinear, "amplitude scale: linear, or db to show quiet passages")
+	normalize := fs.Bool("normalize", false, "scale the waveform so its loudest peak fills the height")
+	autoRange := fs.Bool("auto-range", false, "scale the waveform to its loudest peak and annotate the scale used")
+	headroom := fs.Float64("headroom", defaultHeadroomDB, "space in dB left above the loudest peak with -auto-range")
+	colormap := fs.String("colormap", "", "color the waveform by level: viridis, magma, grayscale or comma-separated RRGGBB stops")
+	renderer := fs.String("renderer", "cpu", "rendering backend: cpu or rowmajor")
+	pngCompression := fs.String("png-compression", "default", "PNG compression: default, none, fast or best")
+	pngPalette := fs.Bool("png-palette", false, pngPaletteUsage)
+	start := fs.Duration("start", 0, "render the audio from this far in, e.g. 1m30s, resampled from whichever of the peak files suits the zoom")
+	end := fs.Duration("end", 0, "render the audio up to this far in (default the end)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: only_waveform render-peaks [flags] file.peaks|file.json [more peaks of the same audio...]\n")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("render-peaks needs a peak file")
+	}
+	if *width <= 0 || *height <= 0 || *maxWidth < 0 {
+		return fmt.Errorf("-width and -height must be positive and -max-width must not be negative")
+	}
+	if *start < 0 || *end < 0 || *end > 0 && *end <= *start {
+		return fmt.Errorf("-start and -end must not be negative, and -end must be after -start")
+	}
+	size := Options{Width: *width, MaxWidth: *maxWidth}
+	var err error
+	if size.Oversize, err = parseOversize(*oversize); err != nil {
+		return err
+	}
+
+	ro := DefaultRenderOptions()
+	ro.Width, ro.Height = size.imageWidth(), *height
+	ro.Normalize, ro.AutoRange = *normalize, *autoRange
+	if *fg != "" {
+		if ro.Foreground, err = parseHexColor(*fg); err != nil {
+			return fmt.Errorf("-fg: %w", err)
+		}
+	}
+	if *bg != "" {
+		if ro.Background, err = parseHexColor(*bg); err != nil {
+			return fmt.Errorf("-bg: %w", err)
+		}
+	}
+	if ro.Style, err = parseStyle(*style); err != nil {
+		return err
+	}
+	if ro.Scale, err = parseScale(*scale); err != nil {
+		return err
+	}
+	if ro.HeadroomDB, err = parseHeadroom(*headroom); err != nil {
+		return err
+	}
+	if *colormap != "" {
+		if ro.Colormap, err = parseColormap(*colormap); err != nil {
+			return err
+		}
+	}
+	backend, err := lookupBackend(*renderer)
+	if err != nil {
+		return err
+	}
+	level, err := parseCompressionLevel(*pngCompression)
+	if err != nil {
+		return err
+	}
+
+	input := fs.Arg(0)
+	var selected ChannelPeaks
+	var zoomed string
+	if fs.NArg() == 1 && *start == 0 && *end == 0 {
+		peaks, err := readPeaksAny(input)
+		if err != nil {
+			return fmt.Errorf("%s: %w", input, err)
+		}
+		if *channel < 1 || *channel > len(peaks.Channels) {
+			return fmt.Errorf("%s: channel %d selected but the file has %d", input, *channel, len(peaks.Channels))
+		}
+		selected = peaks.Channels[*channel-1]
+	} else {
+		// The peaks of one audio at several resolutions
+		tiers, err := LoadPeakTiers(fs.Args()...)
+		if err != nil {
+			return err
+		}
+		if *channel < 1 || *channel > len(tiers[0].Channels) {
+			return fmt.Errorf("%s: channel %d selected but the file has %d", input, *channel, len(tiers[0].Channels))
+		}
+		view, err := tiers.Zoom(*start, *end, size.Width)
+		if err != nil {
+			return err
+		}
+		selected = view.Channels[*channel-1]
+		zoomed = fmt.Sprintf(" from %s to %s, resampled from %d samples per pixel", formatSeconds(view.Start.Seconds()), formatSeconds(view.End.Seconds()), tiers[view.Tier].SamplesPerPixel)
+	}
+
+	img, err := backend.Draw(selected, ro, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", input, err)
+	}
+	defer putImage(img)
+
+	if *output == "" {
+		*output = strings.TrimSuffix(input, filepath.Ext(input)) + ".png"
+	}
+	save := func(img *image.RGBA, filename string) error {
+		if *pngPalette {
+			return savePNG(paletted(img), filename, level)
+		}
+		return savePNG(img, filename, level)
+	}
+	if err := save(img, *output); err != nil {
+		return err
+	}
+	fmt.Printf("Rendered %d buckets of %s%s: %s\n", len(selected.Min), input, zoomed, *output)
+
+	if !size.oversized() {
+		return nil
+	}
+	fmt.Printf("Warning: a %d pixel wide image is over -max-width %d, drew it %d wide\n", size.Width, size.MaxWidth, size.MaxWidth)
+	if size.Oversize != OversizeTile {
+		return nil
+	}
+	base := strings.TrimSuffix(*output, filepath.Ext(*output))
+	for i, tile := range tilePeaks(selected, size.Width, size.MaxWidth) {
+		tileRO := ro
+		tileRO.Width = tile.width
+		tileImg, err := backend.Draw(tile.peaks, tileRO, nil)
+		if err != nil {
+			return fmt.Errorf("%s: tile %d: %w", input, i+1, err)
+		}
+		tileFile := tileFileName(base, i+1)
+		err = save(tileImg, tileFile)
+		putImage(tileImg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Tile %d: %s (%d pixels wide)\n", i+1, tileFile, tile.width)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParsePeaksJSON(t *testing.T) {
+	p := &Peaks{SampleRate: 44100, SamplesPerPixel: 256, Channels: []ChannelPeaks{
+		{Min: []int16{-1000, -32768}, Max: []int16{1000, 32767}},
+		{Min: []int16{-5, 0}, Max: []int16{5, 0}},
+	}}
+
+	for _, bits := range []int{16, 8} {
+		data, _ := json.Marshal(peaksJSON(p, bits))
+		got, err := parsePeaksJSON(data)
+		if err != nil {
+			t.Fatalf("%d bits: %v", bits, err)
+		}
+		if got.SampleRate != 44100 || got.SamplesPerPixel != 256 || len(got.Channels) != 2 || got.Len() != 2 {
+			t.Fatalf("%d bits: %+v", bits, got)
+		}
+		// 8 bit values keep the top byte of each 16 bit one
+		want := int16(1000)
+		if bits == 8 {
+			want = 1000 >> 8 << 8
+		}
+		if got.Channels[0].Max[0] != want || got.Channels[0].Min[1] != -32768 {
+			t.Errorf("%d bits: left channel %v", bits, got.Channels[0])
+		}
+	}
+
+	// audiowaveform's version 1 files have one channel and no channels field
+	got, err := parsePeaksJSON([]byte(`{"version": 1, "sample_rate": 8000, "samples_per_pixel": 80, "bits": 16, "length": 1, "data": [-7, 9]}`))
+	if err != nil || len(got.Channels) != 1 || got.Channels[0].Max[0] != 9 {
+		t.Errorf("version 1: %+v, %v", got, err)
+	}
+
+	for _, bad := range []string{
+		`{"version": 3, "bits": 16, "channels": 1}`,
+		`{"version": 2, "bits": 12, "channels": 1}`,
+		`{"version": 2, "bits": 16, "channels": 1, "length": 2, "data": [1, 2]}`,
+		`not json`,
+	} {
+		if _, err := parsePeaksJSON([]byte(bad)); err == nil {
+			t.Errorf("%s parsed", bad)
+		}
+	}
+}
+
+func TestRunRenderPeaks(t *testing.T) {
+	dir := t.TempDir()
+	p := &Peaks{SampleRate: 44100, SamplesPerPixel: 256, Channels: []ChannelPeaks{
+		{Min: []int16{-1000, -20000, -3000}, Max: []int16{1000, 20000, 3000}},
+	}}
+	binary := filepath.Join(dir, "take.peaks")
+	if err := WritePeaksFile(binary, p); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(peaksJSON(p, 16))
+	jsonFile := filepath.Join(dir, "take.dat")
+	os.WriteFile(jsonFile, data, 0o644)
+
+	for _, input := range []string{binary, jsonFile} {
+		output := filepath.Join(dir, "out.png")
+		if err := runRenderPeaks([]string{"-width", "90", "-height", "30", "-style", "bars", "-fg", "ff0000", "-o", output, input}); err != nil {
+			t.Fatalf("%s: %v", input, err)
+		}
+		file, err := os.Open(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(file)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := img.Bounds(); b.Dx() != 90 || b.Dy() != 30 {
+			t.Errorf("%s: rendered %v", input, b)
+		}
+	}
+
+	// The image is named after the peak file by default
+	if err := runRenderPeaks([]string{"-width", "10", "-height", "10", binary}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "take.png")); err != nil {
+		t.Error(err)
+	}
+
+	if err := runRenderPeaks([]string{"-channel", "2", binary}); err == nil {
+		t.Error("channel 2 of a one channel file rendered")
+	}
+}
+
+func TestRunRenderPeaksOversized(t *testing.T) {
+	dir := t.TempDir()
+	p := &Peaks{SampleRate: 44100, SamplesPerPixel: 256, Channels: []ChannelPeaks{
+		{Min: []int16{-1000, -20000, -3000}, Max: []int16{1000, 20000, 3000}},
+	}}
+	input := filepath.Join(dir, "take.peaks")
+	if err := WritePeaksFile(input, p); err != nil {
+		t.Fatal(err)
+	}
+	if err := runRenderPeaks([]string{"-width", "30", "-height", "10", "-max-width", "20", input}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"take.png", "take.tile-001.png", "take.tile-002.png"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestRunRenderPeaksZoom(t *testing.T) {
+	dir := t.TempDir()
+	fine, coarse := filepath.Join(dir, "fine.peaks"), filepath.Join(dir, "coarse.peaks")
+	WritePeaksFile(fine, spikePeaks(1000, 10, 777))
+	WritePeaksFile(coarse, spikePeaks(100, 100, 77))
+
+	output := filepath.Join(dir, "zoom.png")
+	if err := runRenderPeaks([]string{"-width", "100", "-height", "10", "-start", "7s", "-end", "8s", "-o", output, coarse, fine}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Error(err)
+	}
+	if err := runRenderPeaks([]string{"-start", "20s", fine}); err == nil {
+		t.Error("-start past the end rendered")
+	}
+}
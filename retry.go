@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/textproto"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// maxRetryDelay caps the doubling wait between storage retries
+const maxRetryDelay = 30 * time.Second
+
+// RetryPolicy says how storage transfers are tried again after failures
+// that may pass: lost connections, timeouts, throttling and server errors.
+// Anything else, such as a missing object or a refused login, fails at once.
+type RetryPolicy struct {
+	// Retries is how many times a transfer is tried again
+	Retries int
+	// Delay is the wait before the first retry; it doubles after every
+	// one, up to maxRetryDelay, and is jittered so workers don't retry in
+	// step
+	Delay time.Duration
+}
+
+// DefaultRetryPolicy returns the policy of -retries and -retry-delay
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{Retries: 3, Delay: time.Second}
+}
+
+// retryFlags registers -retries and -retry-delay on fs
+func retryFlags(fs *flag.FlagSet) (*int, *time.Duration) {
+	policy := DefaultRetryPolicy()
+	retries := fs.Int("retries", policy.Retries, "times a remote storage transfer is tried again after a transient failure such as a timeout or a 5xx")
+	delay := fs.Duration("retry-delay", policy.Delay, "wait before the first retry of a storage transfer, doubling after each one up to 30s")
+	return retries, delay
+}
+
+// transientError is a transient failure that outlasted its retries. It
+// marks failures worth trying again later, apart from permanent ones.
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+// isTransientFailure reports whether err is, or wraps, a transientError
+func isTransientFailure(err error) bool {
+	var transient *transientError
+	return errors.As(err, &transient)
+}
+
+// do runs op until it succeeds, fails permanently or has used its retries.
+// op is run afresh every time, so it has to start its transfer over.
+func (p RetryPolicy) do(ctx context.Context, op func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || !retryable(err) {
+			return err
+		}
+		if attempt >= p.Retries {
+			return &transientError{err}
+		}
+
+		delay := min(p.Delay<<attempt, maxRetryDelay)
+		delay = delay/2 + rand.N(delay/2+1)
+		storageRetries.inc()
+		select {
+		case <-ctx.Done():
+			return &transientError{err}
+		case <-time.After(delay):
+		}
+	}
+}
+
+// retryable reports whether a storage failure may pass when tried again
+func retryable(err error) bool {
+	var status *httpStatusError
+	if errors.As(err, &status) {
+		return status.Code == 408 || status.Code == 429 || status.Code/100 == 5
+	}
+	// FTP replies in the 400s are transient by definition
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		return reply.Code/100 == 4
+	}
+	// ssh exits with 255 when the connection fails, sftp with 1 when a
+	// command does
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return exit.ExitCode() == 255
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{Retries: 2, Delay: time.Millisecond}
+	unavailable := &httpStatusError{Method: "GET", Path: "/take.wav", Status: "503 Service Unavailable", Code: 503}
+
+	calls := 0
+	err := policy.do(context.Background(), func() error {
+		if calls++; calls < 3 {
+			return unavailable
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("flaky transfer: err = %v after %d calls, want success after 3", err, calls)
+	}
+
+	calls = 0
+	err = policy.do(context.Background(), func() error {
+		calls++
+		return fmt.Errorf("failed to download: %w", unavailable)
+	})
+	if calls != 3 || !isTransientFailure(err) || !errors.Is(err, unavailable) {
+		t.Errorf("down transfer: err = %v after %d calls, want a transient failure after 3", err, calls)
+	}
+
+	calls = 0
+	err = policy.do(context.Background(), func() error {
+		calls++
+		return &httpStatusError{Method: "GET", Path: "/gone.wav", Status: "404 Not Found", Code: 404}
+	})
+	if calls != 1 || err == nil || isTransientFailure(err) {
+		t.Errorf("missing object: err = %v after %d calls, want a permanent failure at once", err, calls)
+	}
+}
+
+// flakyStorage is a Storage that resets the connection of the first
+// failures transfers
+type flakyStorage struct {
+	failures int
+	files    map[string]string
+}
+
+func (s *flakyStorage) fail() error {
+	if s.failures > 0 {
+		s.failures--
+		return &os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET}
+	}
+	return nil
+}
+
+func (s *flakyStorage) List(ctx context.Context) ([]ObjectInfo, error) {
+	return nil, s.fail()
+}
+
+func (s *flakyStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := s.fail(); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(strings.NewReader(s.files[name])), nil
+}
+
+func (s *flakyStorage) Put(ctx context.Context, name string, r io.Reader, size int64, contentType string) error {
+	data, _ := io.ReadAll(r)
+	if err := s.fail(); err != nil {
+		return err
+	}
+	s.files[name] = string(data)
+	return nil
+}
+
+func TestStorageBatchRetries(t *testing.T) {
+	storage := &flakyStorage{failures: 2, files: map[string]string{"take.wav": "RIFF"}}
+	batch := &storageBatch{input: storage, output: storage, workDir: t.TempDir(), transfers: make(chan struct{}, 1),
+		retry: RetryPolicy{Retries: 2, Delay: time.Millisecond}}
+
+	local, err := batch.fetch("take.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(local); string(data) != "RIFF" {
+		t.Errorf("downloaded %q", data)
+	}
+
+	// Every attempt uploads the whole file again
+	storage.failures = 2
+	png := filepath.Join(t.TempDir(), "take.png")
+	os.WriteFile(png, []byte("PNG data"), 0o644)
+	if err := batch.uploadFile(png); err != nil {
+		t.Fatal(err)
+	}
+	if storage.files["take.png"] != "PNG data" {
+		t.Errorf("uploaded %q", storage.files["take.png"])
+	}
+
+	storage.failures = 3
+	if _, err := batch.fetch("take.wav"); !isTransientFailure(err) {
+		t.Errorf("err = %v, want a transient failure once retries run out", err)
+	}
+}
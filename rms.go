@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// RMSExport is the per-window RMS series written by -rms-window. Values are
+// linear RMS in [0, 1], one [left, right] pair per window.
+type RMSExport struct {
+	WindowSeconds float64      `json:"window_seconds"`
+	SampleRate    uint32       `json:"sample_rate"`
+	Channels      []string     `json:"channels"`
+	Values        [][2]float64 `json:"values"`
+}
+
+// rmsAnalyzer computes RMS over fixed windows of both channels
+type rmsAnalyzer struct {
+	window time.Duration
+	info   StreamInfo
+
+	windowFrames int
+	pos          int
+	sum          [2]float64
+	values       [][2]float64
+}
+
+func newRMSAnalyzer(window time.Duration) *rmsAnalyzer {
+	return &rmsAnalyzer{window: window}
+}
+
+// Start implements Analyzer
+func (a *rmsAnalyzer) Start(info StreamInfo) {
+	a.info = info
+	a.windowFrames = max(1, int(a.window.Seconds()*float64(info.SampleRate)))
+	a.values = make([][2]float64, 0, info.NumFrames/a.windowFrames+1)
+}
+
+// memoryEstimate implements memoryUser: both channels' RMS per window
+func (a *rmsAnalyzer) memoryEstimate(info StreamInfo) int64 {
+	return windowCount(info, a.window.Seconds()) * 16
+}
+
+// Add implements Analyzer
+func (a *rmsAnalyzer) Add(left, right []int16) {
+	for i := range left {
+		l := float64(left[i]) / 32768.0
+		a.sum[0] += l * l
+		if right != nil {
+			r := float64(right[i]) / 32768.0
+			a.sum[1] += r * r
+		}
+
+		a.pos++
+		if a.pos == a.windowFrames {
+			a.endWindow()
+		}
+	}
+}
+
+// endWindow appends the RMS of the window that just finished
+func (a *rmsAnalyzer) endWindow() {
+	n := float64(a.pos)
+	a.values = append(a.values, [2]float64{math.Sqrt(a.sum[0] / n), math.Sqrt(a.sum[1] / n)})
+	a.sum = [2]float64{}
+	a.pos = 0
+}
+
+// Result implements Analyzer
+func (a *rmsAnalyzer) Result() any {
+	// A trailing partial window still gets a value
+	if a.pos > 0 {
+		a.endWindow()
+	}
+
+	return RMSExport{
+		WindowSeconds: a.window.Seconds(),
+		SampleRate:    a.info.SampleRate,
+		Channels:      channelNames,
+		Values:        a.values,
+	}
+}
+
+// writeRMS writes an RMS series as JSON or CSV to the named file
+func writeRMS(filename, format string, export RMSExport) error {
+	if format == "json" {
+		data, err := json.Marshal(export)
+		if err != nil {
+			return fmt.Errorf("failed to encode RMS: %w", err)
+		}
+		return atomicWriteFile(filename, append(data, '\n'))
+	}
+
+	return atomicWrite(filename, func(file *os.File) error {
+		return writeRMSCSV(file, export)
+	})
+}
+
+// writeRMSCSV writes an RMS series as CSV, a row per window
+func writeRMSCSV(out io.Writer, export RMSExport) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"time", "left", "right"})
+	for i, v := range export.Values {
+		w.Write([]string{
+			strconv.FormatFloat(float64(i)*export.WindowSeconds, 'f', 3, 64),
+			strconv.FormatFloat(v[0], 'f', 6, 64),
+			strconv.FormatFloat(v[1], 'f', 6, 64),
+		})
+	}
+	w.Flush()
+
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write RMS: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3EmptyHash is the SHA-256 of an empty payload
+const s3EmptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Client talks to S3, or an S3 compatible store, over its REST API with
+// Signature Version 4 authentication
+type S3Client struct {
+	Region       string
+	Endpoint     string // e.g. http://localhost:9000 for MinIO; empty for AWS
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	HTTP         *http.Client
+}
+
+// NewS3ClientFromEnv configures a client from the standard AWS_ environment
+// variables (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN,
+// AWS_REGION and AWS_ENDPOINT_URL)
+func NewS3ClientFromEnv() (*S3Client, error) {
+	c := &S3Client{
+		Region:       os.Getenv("AWS_REGION"),
+		Endpoint:     strings.TrimSuffix(os.Getenv("AWS_ENDPOINT_URL"), "/"),
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		HTTP:         http.DefaultClient,
+	}
+	if c.Region == "" {
+		c.Region = "us-east-1"
+	}
+	if c.AccessKey == "" || c.SecretKey == "" {
+		return nil, fmt.Errorf("S3 needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return c, nil
+}
+
+// objectURL returns the URL of a key in a bucket. AWS uses virtual-hosted
+// style; custom endpoints use path style, which compatible stores expect.
+func (c *S3Client) objectURL(bucket, key string) *url.URL {
+	if c.Endpoint == "" {
+		return &url.URL{Scheme: "https", Host: bucket + ".s3." + c.Region + ".amazonaws.com", Path: "/" + key}
+	}
+
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		u = &url.URL{Scheme: "https", Host: c.Endpoint}
+	}
+	u.Path = "/" + bucket + "/" + key
+	return u
+}
+
+// do signs and sends a request, turning error responses into errors
+func (c *S3Client) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	c.sign(req, payloadHash, time.Now().UTC())
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// s3Object is an object in a bucket listing
+type s3Object struct {
+	Key  string
+	Size int64
+	ETag string
+}
+
+// List returns the objects directly under prefix (not in deeper "folders")
+func (c *S3Client) List(ctx context.Context, bucket, prefix string) ([]s3Object, error) {
+	var objects []s3Object
+	token := ""
+
+	for {
+		u := c.objectURL(bucket, "")
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {"/"}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u.RawQuery = q.Encode()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.do(req, s3EmptyHash)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents              []s3Object
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse bucket listing: %w", err)
+		}
+
+		objects = append(objects, result.Contents...)
+		if !result.IsTruncated {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Get streams an object; the caller closes the body
+func (c *S3Client) Get(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.objectURL(bucket, key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req, s3EmptyHash)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// GetRange streams length bytes of an object from offset, or all of it
+// from offset on when length is negative
+func (c *S3Client) GetRange(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.objectURL(bucket, key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", byteRange(offset, length))
+	resp, err := c.do(req, s3EmptyHash)
+	if err != nil {
+		return nil, err
+	}
+	return rangeBody(resp, offset, length)
+}
+
+// Put streams size bytes from body into an object. The payload is sent
+// unsigned so it doesn't have to be read twice.
+func (c *S3Client) Put(ctx context.Context, bucket, key string, body io.Reader, size int64, contentType string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.objectURL(bucket, key).String(), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.do(req, "UNSIGNED-PAYLOAD")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// s3Storage is a prefix in an S3 bucket
+type s3Storage struct {
+	client *S3Client
+	bucket string
+	prefix string
+}
+
+// newS3Storage opens an s3://bucket/prefix location
+func newS3Storage(location string) (*s3Storage, error) {
+	bucket, prefix, err := splitBucketURL(location, "s3://")
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewS3ClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return &s3Storage{client: client, bucket: bucket, prefix: prefix}, nil
+}
+
+func (s *s3Storage) List(ctx context.Context) ([]ObjectInfo, error) {
+	listed, err := s.client.List(ctx, s.bucket, listPrefix(s.prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]ObjectInfo, 0, len(listed))
+	for _, obj := range listed {
+		objects = append(objects, ObjectInfo{Name: path.Base(obj.Key), Size: obj.Size, ETag: obj.ETag})
+	}
+	return objects, nil
+}
+
+func (s *s3Storage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return s.client.Get(ctx, s.bucket, joinKey(s.prefix, name))
+}
+
+func (s *s3Storage) OpenRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	return s.client.GetRange(ctx, s.bucket, joinKey(s.prefix, name), offset, length)
+}
+
+func (s *s3Storage) Put(ctx context.Context, name string, r io.Reader, size int64, contentType string) error {
+	return s.client.Put(ctx, s.bucket, joinKey(s.prefix, name), r, size, contentType)
+}
+
+// sign adds Signature Version 4 headers for S3 to req
+func (c *S3Client) sign(req *http.Request, payloadHash string, now time.Time) {
+	c.signService(req, "s3", payloadHash, now)
+}
+
+// signService adds Signature Version 4 headers for an AWS service to req;
+// the same credentials sign requests to other services, such as SQS
+func (c *S3Client) signService(req *http.Request, service, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+
+	// Host and the x-amz-* headers are signed, plus any the request sets
+	// that S3 checks
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" || lower == "range" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.Region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretKey), date)
+	key = hmacSHA256(key, c.Region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKey, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data under key
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape percent-encodes everything but the unreserved characters, as
+// SigV4 requires
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if 'A' <= ch && ch <= 'Z' || 'a' <= ch && ch <= 'z' || '0' <= ch && ch <= '9' || strings.IndexByte("-._~", ch) >= 0 {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+// s3EscapePath escapes each segment of a path, keeping the slashes
+func s3EscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = s3Escape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// s3CanonicalQuery returns the query sorted and escaped for signing
+func s3CanonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), q[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// Amplitude scales
+const (
+	ScaleLinear = "linear" // height proportional to amplitude
+	ScaleDB     = "db"     // height proportional to level in dB, so quiet passages stay visible
+)
+
+// dbScaleRangeDB is the span of levels a dB-scaled waveform shows: 0 dBFS
+// fills the height and anything this far below it stays on the center line
+const dbScaleRangeDB = 60.0
+
+// parseScale validates an amplitude scale name
+func parseScale(scale string) (string, error) {
+	switch scale {
+	case ScaleLinear, ScaleDB:
+		return scale, nil
+	}
+	return "", fmt.Errorf("unknown scale %q (want %s or %s)", scale, ScaleLinear, ScaleDB)
+}
+
+// scaleAmplitude maps an amplitude, already scaled by the gain, onto the
+// share of the half height it reaches, keeping its sign
+func (ro RenderOptions) scaleAmplitude(amp float64) float64 {
+	if ro.Scale != ScaleDB || amp == 0 {
+		return amp
+	}
+	level := 1 + 20*math.Log10(math.Abs(amp))/dbScaleRangeDB
+	return math.Copysign(max(level, 0), amp)
+}
+
+// RenderProfile is an extra image rendered from the same decode as the
+// main one, written to <name>.<Name>.png
+type RenderProfile struct {
+	Name  string
+	Scale string
+}
+
+// profileNamePattern keeps profile names usable in output file names
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// parseProfiles parses comma-separated name:scale profiles, e.g.
+// "overview:linear,detail:db"
+func parseProfiles(s string) ([]RenderProfile, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var profiles []RenderProfile
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ",") {
+		name, scale, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid profile %q (want name:scale)", part)
+		}
+		if !profileNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid profile name %q (want letters, digits, - and _)", name)
+		}
+		if seen[strings.ToLower(name)] {
+			return nil, fmt.Errorf("duplicate profile %q", name)
+		}
+		seen[strings.ToLower(name)] = true
+
+		scale, err := parseScale(scale)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %w", name, err)
+		}
+		profiles = append(profiles, RenderProfile{Name: name, Scale: scale})
+	}
+	return profiles, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestScaleAmplitude(t *testing.T) {
+	ro := DefaultRenderOptions()
+	if got := ro.scaleAmplitude(0.1); got != 0.1 {
+		t.Errorf("linear 0.1 = %g", got)
+	}
+
+	ro.Scale = ScaleDB
+	for amp, want := range map[float64]float64{
+		0:     0,
+		1:     1,
+		0.1:   1 - 20/dbScaleRangeDB,
+		-0.1:  -(1 - 20/dbScaleRangeDB),
+		0.001: 0,
+		1e-6:  0,
+	} {
+		if got := ro.scaleAmplitude(amp); math.Abs(got-want) > 1e-9 {
+			t.Errorf("db %g = %g, want %g", amp, got, want)
+		}
+	}
+}
+
+func TestParseProfiles(t *testing.T) {
+	profiles, err := parseProfiles("overview:linear, detail:db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 2 || profiles[0] != (RenderProfile{"overview", ScaleLinear}) || profiles[1] != (RenderProfile{"detail", ScaleDB}) {
+		t.Errorf("profiles = %+v", profiles)
+	}
+
+	for _, bad := range []string{"overview", "overview:log", "a/b:db", ":db", "x:db,X:linear"} {
+		if _, err := parseProfiles(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestGenerateProfiles(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "take.wav")
+	audio := DefaultTestAudio()
+	audio.Amplitude = 0.1
+	if err := WriteTestAudioFile(input, audio); err != nil {
+		t.Fatal(err)
+	}
+
+	output := t.TempDir()
+	opts := Options{Width: 200, Height: 100, Profiles: []RenderProfile{{"overview", ScaleLinear}, {"detail", ScaleDB}}}
+	result, err := GenerateStereoWaveforms(batchInput{Name: "take.wav", Path: input, Location: input}, output, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Outputs) != 3 {
+		t.Fatalf("outputs = %q", result.Outputs)
+	}
+
+	// The dB render of a quiet file reaches further from the center
+	extent := func(name string) int {
+		img, err := readPNG(filepath.Join(output, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		top := img.Bounds().Dy()
+		for y := img.Bounds().Dy() - 1; y >= 0; y-- {
+			for x := range img.Bounds().Dx() {
+				if color.RGBAModel.Convert(img.At(x, y)) == waveformColor {
+					top = y
+				}
+			}
+		}
+		return img.Bounds().Dy()/2 - top
+	}
+	linear, db := extent("take.overview.png"), extent("take.detail.png")
+	if linear != extent("take.png") || linear > 10 || db < 25 {
+		t.Errorf("waveform reaches %d rows above the center linear and %d in dB", linear, db)
+	}
+}
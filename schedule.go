@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// jobScheduler holds jobs taken from the queue until a worker slot is free
+// and hands out the one to run next: the highest priority first, and
+// within a priority the group served longest ago, so one group's backfill
+// of thousands of files takes turns with everyone else's renders instead
+// of running ahead of them. Jobs of a group run in the order received.
+type jobScheduler struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	pending []scheduledJob
+	// served holds the tick each group with pending jobs last ran at
+	served map[string]uint64
+	tick   uint64
+	closed bool
+}
+
+// scheduledJob is a held job with the scheduling fields of its message
+type scheduledJob struct {
+	queued   *QueuedJob
+	priority int
+	group    string
+}
+
+func newJobScheduler() *jobScheduler {
+	s := &jobScheduler{served: map[string]uint64{}}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// push adds a received job. Messages that don't parse are scheduled with
+// the defaults and fail when run.
+func (s *jobScheduler) push(queued *QueuedJob) {
+	var job Job
+	json.Unmarshal(queued.Body, &job)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Groups are within a tenant, so tenants take turns too
+	group := job.Group
+	if job.Tenant != "" {
+		group = job.Tenant + "/" + job.Group
+	}
+	s.pending = append(s.pending, scheduledJob{queued: queued, priority: job.Priority, group: group})
+	s.cond.Signal()
+}
+
+// next waits for the job to run next. It returns nil once the scheduler
+// is closed and every held job has been handed out.
+func (s *jobScheduler) next() *QueuedJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.pending) == 0 {
+		if s.closed {
+			return nil
+		}
+		s.cond.Wait()
+	}
+
+	// pending is in the order received, so the first job found of the
+	// chosen group is its oldest
+	best := 0
+	for i, job := range s.pending[1:] {
+		b := s.pending[best]
+		if job.priority > b.priority || job.priority == b.priority && s.served[job.group] < s.served[b.group] {
+			best = i + 1
+		}
+	}
+	job := s.pending[best]
+	s.pending = append(s.pending[:best], s.pending[best+1:]...)
+
+	s.tick++
+	s.served[job.group] = s.tick
+	if !s.holds(job.group) {
+		delete(s.served, job.group)
+	}
+	return job.queued
+}
+
+// holds reports whether a job of group is pending
+func (s *jobScheduler) holds(group string) bool {
+	for _, job := range s.pending {
+		if job.group == group {
+			return true
+		}
+	}
+	return false
+}
+
+// close lets next return nil once the held jobs have been handed out
+func (s *jobScheduler) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.cond.Broadcast()
+}
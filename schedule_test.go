@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestJobScheduler(t *testing.T) {
+	s := newJobScheduler()
+	push := func(input string, priority int, group string) {
+		body, _ := json.Marshal(Job{Input: input, Priority: priority, Group: group})
+		s.push(&QueuedJob{Body: body})
+	}
+
+	// A backfill ahead of two users' renders, one of them urgent
+	for _, input := range []string{"archive1", "archive2", "archive3"} {
+		push(input, -1, "backfill")
+	}
+	push("alice1", 0, "alice")
+	push("alice2", 0, "alice")
+	push("bob1", 0, "bob")
+	push("urgent", 10, "bob")
+	s.push(&QueuedJob{Body: []byte("not json")})
+	s.close()
+
+	var order []string
+	for job := s.next(); job != nil; job = s.next() {
+		var j Job
+		json.Unmarshal(job.Body, &j)
+		order = append(order, j.Input)
+	}
+
+	// bob last ran before alice, so bob1 goes ahead of alice2
+	want := []string{"urgent", "alice1", "", "bob1", "alice2", "archive1", "archive2", "archive3"}
+	if len(order) != len(want) {
+		t.Fatalf("order = %q, want %q", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("order = %q, want %q", order, want)
+		}
+	}
+}
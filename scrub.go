@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"time"
+)
+
+// Default size of the mini-waveforms of a scrub strip, a common size of
+// hover previews
+const (
+	defaultScrubWidth  = 160
+	defaultScrubHeight = 40
+)
+
+// newScrubAnalyzer builds the peaks of the mini-waveforms of a scrub
+// strip, one of every ScrubInterval as zoom tiers are, of every file
+func newScrubAnalyzer(opts Options) *tierAnalyzer {
+	return &tierAnalyzer{length: opts.ScrubInterval, width: opts.ScrubWidth, agg: opts.Aggregation}
+}
+
+// ScrubIndex describes a scrub strip, as <name>.scrub.json: which stretch
+// of the audio each mini-waveform of the image covers, for players showing
+// them on hover
+type ScrubIndex struct {
+	Image      string      `json:"image"`
+	Interval   float64     `json:"interval"` // seconds each tile covers
+	Duration   float64     `json:"duration"`
+	TileWidth  int         `json:"tile_width"`
+	TileHeight int         `json:"tile_height"`
+	Tiles      []ScrubTile `json:"tiles"`
+}
+
+// ScrubTile is one mini-waveform of a scrub strip
+type ScrubTile struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	X     int     `json:"x"` // left edge in the image
+}
+
+// scrubIndex returns the index of a strip of tiles covering duration
+// seconds of audio from offset seconds into the file, where a -start
+// render begins
+func scrubIndex(imageFile string, tiles int, offset, duration float64, opts Options) ScrubIndex {
+	interval := opts.ScrubInterval.Seconds()
+	index := ScrubIndex{Image: imageFile, Interval: interval, Duration: duration, TileWidth: opts.ScrubWidth, TileHeight: opts.ScrubHeight}
+	for i := range tiles {
+		start := float64(i) * interval
+		index.Tiles = append(index.Tiles, ScrubTile{Start: offset + start, End: offset + min(start+interval, duration), X: i * opts.ScrubWidth})
+	}
+	return index
+}
+
+// drawScrubStrip draws tiles side by side, each at the scale gain of the
+// whole file so loud and quiet stretches compare as in the overview
+func drawScrubStrip(tiles []ChannelPeaks, gain float64, opts Options) (*image.RGBA, error) {
+	backend := opts.Backend
+	if backend == nil {
+		backend = cpuBackend{}
+	}
+	ro := opts.renderOptions()
+	ro.Width, ro.Height = opts.ScrubWidth, opts.ScrubHeight
+	// Tiles this small have no room for a caption of their scale
+	ro.Normalize, ro.AutoRange, ro.Gain = false, false, 0
+
+	strip := image.NewRGBA(image.Rect(0, 0, len(tiles)*opts.ScrubWidth, opts.ScrubHeight))
+	for i, tile := range tiles {
+		tile.scale(gain)
+		img, err := backend.Draw(tile, ro, nil)
+		if err != nil {
+			return nil, fmt.Errorf("tile %d: %w", i+1, err)
+		}
+		draw.Draw(strip, img.Bounds().Add(image.Pt(i*opts.ScrubWidth, 0)), img, image.Point{}, draw.Src)
+		putImage(img)
+	}
+	return strip, nil
+}
+
+// writeScrubIndex writes the index of a scrub strip
+func writeScrubIndex(filename string, index ScrubIndex) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode scrub index: %w", err)
+	}
+	if err := atomicWriteFile(filename, append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write scrub index: %w", err)
+	}
+	return nil
+}
+
+// parseScrubOptions validates the scrub strip flags
+func parseScrubOptions(interval time.Duration, width, height int) error {
+	if interval < 0 {
+		return fmt.Errorf("-scrub-interval must not be negative")
+	}
+	if interval > 0 && (width <= 0 || height <= 0) {
+		return fmt.Errorf("-scrub-width and -scrub-height must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScrubStrip(t *testing.T) {
+	audio := DefaultTestAudio()
+	audio.Duration = 2500 * time.Millisecond
+	input := writeFixture(t, audio)
+	outputDir := t.TempDir()
+
+	opts := Options{Width: 100, Height: 20, ScrubInterval: time.Second, ScrubWidth: 30, ScrubHeight: 10}
+	in := batchInput{Name: "fixture.wav", Path: input, Location: input}
+	if _, err := GenerateStereoWaveforms(in, outputDir, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(filepath.Join(outputDir, "fixture.scrub.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	config, err := png.DecodeConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 90 || config.Height != 10 {
+		t.Errorf("strip is %dx%d, want three 30x10 tiles", config.Width, config.Height)
+	}
+
+	data, err := os.ReadFile(filepath.Join(outputDir, "fixture.scrub.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index ScrubIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatal(err)
+	}
+	if index.Image != "fixture.scrub.png" || len(index.Tiles) != 3 || index.Interval != 1 {
+		t.Fatalf("index %+v", index)
+	}
+	if last := index.Tiles[2]; last.Start != 2 || last.End != 2.5 || last.X != 60 {
+		t.Errorf("last tile %+v, want 2s to 2.5s at x 60", last)
+	}
+}
+
+func TestScrubIndexOffset(t *testing.T) {
+	opts := Options{ScrubInterval: 10 * time.Second, ScrubWidth: 160, ScrubHeight: 40}
+	index := scrubIndex("a.scrub.png", 2, 60, 15, opts)
+	if first := index.Tiles[0]; first.Start != 60 || first.End != 70 {
+		t.Errorf("first tile %+v, want 60s to 70s", first)
+	}
+	if second := index.Tiles[1]; second.End != 75 || second.X != 160 {
+		t.Errorf("second tile %+v, want to end at 75s at x 160", second)
+	}
+}
+
+func TestScrubOptions(t *testing.T) {
+	if err := parseScrubOptions(-time.Second, 160, 40); err == nil {
+		t.Error("negative interval accepted")
+	}
+	if err := parseScrubOptions(time.Second, 0, 40); err == nil {
+		t.Error("zero width accepted")
+	}
+	if err := parseScrubOptions(0, 0, 0); err != nil {
+		t.Errorf("disabled strip: %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// segmentFrame is the length of the frames energy and zero crossings are
+// measured over
+const segmentFrame = 0.02 // seconds
+
+// segmentLength is the length of the segments that are classified; adjacent
+// segments of the same class are merged
+const segmentLength = 1.0 // seconds
+
+// Classifier thresholds. Speech alternates between syllables and short
+// pauses, so many of its frames are far below the segment's mean energy and
+// its zero crossing rate swings between voiced and unvoiced sounds.
+const (
+	speechLowEnergyRatio = 0.3
+	speechZCRDeviation   = 0.03
+)
+
+// Segment classes
+const (
+	classSilence = "silence"
+	classSpeech  = "speech"
+	classMusic   = "music"
+)
+
+// segmentShades tint the background of each class for -shade-segments
+var segmentShades = map[string]color.RGBA{
+	classSilence: {235, 235, 235, 255},
+	classSpeech:  {215, 230, 250, 255},
+	classMusic:   {250, 240, 205, 255},
+}
+
+// AudioSegment is a region of one class, in seconds
+type AudioSegment struct {
+	Start    float64 `json:"start"`
+	End      float64 `json:"end"`
+	Duration float64 `json:"duration"`
+	Class    string  `json:"class"`
+}
+
+// SegmentsReport is the "segments" section of a file report
+type SegmentsReport struct {
+	Segments []AudioSegment     `json:"segments"`
+	Totals   map[string]float64 `json:"totals_seconds"`
+}
+
+// segmentAnalyzer classifies segments as speech, music or silence from the
+// energy and zero crossing rate of their frames
+type segmentAnalyzer struct {
+	cfg       AnalysisConfig
+	threshold float64 // silence RMS, normalized
+	info      StreamInfo
+
+	frameFrames   int
+	framesPerSeg  int
+	framePos      int
+	frameSum      float64
+	frameCrossing int
+	last          float64
+
+	energies  []float64 // RMS of each frame of the current segment
+	crossings []float64 // zero crossing rate of each frame
+
+	classes []string // class of each finished segment
+	pos     int
+}
+
+func newSegmentAnalyzer(cfg AnalysisConfig) Analyzer {
+	return &segmentAnalyzer{
+		cfg:       cfg,
+		threshold: dbToAmplitude(cfg.SilenceThresholdDB) / 32768.0,
+	}
+}
+
+// Start implements Analyzer
+func (a *segmentAnalyzer) Start(info StreamInfo) {
+	a.info = info
+	a.frameFrames = max(1, int(segmentFrame*float64(info.SampleRate)))
+	a.framesPerSeg = max(1, int(segmentLength/segmentFrame))
+}
+
+// memoryEstimate implements memoryUser: energy and zero crossings of every
+// 20 ms frame, and the class of every segment
+func (a *segmentAnalyzer) memoryEstimate(info StreamInfo) int64 {
+	return windowCount(info, segmentFrame)*16 + windowCount(info, segmentLength)*8
+}
+
+// Add implements Analyzer
+func (a *segmentAnalyzer) Add(left, right []int16) {
+	for i := range left {
+		s := float64(left[i])
+		if right != nil {
+			s = (s + float64(right[i])) / 2
+		}
+		s /= 32768.0
+
+		a.frameSum += s * s
+		if (s >= 0) != (a.last >= 0) {
+			a.frameCrossing++
+		}
+		a.last = s
+
+		a.framePos++
+		if a.framePos == a.frameFrames {
+			a.endFrame()
+		}
+	}
+	a.pos += len(left)
+}
+
+// endFrame records the frame that just finished
+func (a *segmentAnalyzer) endFrame() {
+	a.energies = append(a.energies, math.Sqrt(a.frameSum/float64(a.framePos)))
+	a.crossings = append(a.crossings, float64(a.frameCrossing)/float64(a.framePos))
+	a.frameSum, a.frameCrossing, a.framePos = 0, 0, 0
+
+	if len(a.energies) == a.framesPerSeg {
+		a.endSegment()
+	}
+}
+
+// endSegment classifies the segment that just finished
+func (a *segmentAnalyzer) endSegment() {
+	a.classes = append(a.classes, classifySegment(a.energies, a.crossings, a.threshold))
+	a.energies, a.crossings = a.energies[:0], a.crossings[:0]
+}
+
+// classifySegment returns the class of a segment from its frame features
+func classifySegment(energies, crossings []float64, threshold float64) string {
+	meanEnergy, meanZCR := 0.0, 0.0
+	for i := range energies {
+		meanEnergy += energies[i]
+		meanZCR += crossings[i]
+	}
+	n := float64(len(energies))
+	meanEnergy /= n
+	meanZCR /= n
+
+	if meanEnergy < threshold {
+		return classSilence
+	}
+
+	low, zcrVariance := 0, 0.0
+	for i := range energies {
+		if energies[i] < meanEnergy/2 {
+			low++
+		}
+		d := crossings[i] - meanZCR
+		zcrVariance += d * d
+	}
+
+	if float64(low)/n > speechLowEnergyRatio || math.Sqrt(zcrVariance/n) > speechZCRDeviation {
+		return classSpeech
+	}
+	return classMusic
+}
+
+// flush classifies a trailing partial segment
+func (a *segmentAnalyzer) flush() {
+	if a.framePos > 0 {
+		a.endFrame()
+	}
+	if len(a.energies) > 0 {
+		a.endSegment()
+	}
+}
+
+// Result implements Analyzer
+func (a *segmentAnalyzer) Result() any {
+	a.flush()
+
+	report := SegmentsReport{
+		Segments: []AudioSegment{},
+		Totals:   map[string]float64{classSilence: 0, classSpeech: 0, classMusic: 0},
+	}
+
+	segFrames := a.framesPerSeg * a.frameFrames
+	for i, class := range a.classes {
+		start := framesToSeconds(i*segFrames, a.info.SampleRate)
+		end := framesToSeconds(min((i+1)*segFrames, a.pos), a.info.SampleRate)
+
+		if n := len(report.Segments); n > 0 && report.Segments[n-1].Class == class {
+			report.Segments[n-1].End = end
+			report.Segments[n-1].Duration = end - report.Segments[n-1].Start
+		} else {
+			report.Segments = append(report.Segments, AudioSegment{Start: start, End: end, Duration: end - start, Class: class})
+		}
+		report.Totals[class] += end - start
+	}
+
+	return report
+}
+
+// drawOverlay implements imageOverlay, tinting the background of each
+// column with the class of its segment
+func (a *segmentAnalyzer) drawOverlay(img *image.RGBA) {
+	a.flush()
+	if len(a.classes) == 0 || a.pos == 0 {
+		return
+	}
+
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	segFrames := a.framesPerSeg * a.frameFrames
+
+	for x := 0; x < width; x++ {
+		seg := min(x*a.pos/width/segFrames, len(a.classes)-1)
+		shade := segmentShades[a.classes[seg]]
+
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			if img.RGBAAt(bounds.Min.X+x, y) == backgroundColor {
+				img.SetRGBA(bounds.Min.X+x, y, shade)
+			}
+		}
+	}
+}
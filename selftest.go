@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Size of the images the self-test renders
+const (
+	selftestWidth  = 400
+	selftestHeight = 100
+)
+
+// SelftestReport is the outcome of the selftest subcommand
+type SelftestReport struct {
+	Passed       bool            `json:"passed"`
+	Checks       []SelftestCheck `json:"checks"`
+	Capabilities Capabilities    `json:"capabilities"`
+}
+
+// SelftestCheck is one step of the self-test
+type SelftestCheck struct {
+	Name    string  `json:"name"`
+	Passed  bool    `json:"passed"`
+	Error   string  `json:"error,omitempty"`
+	Elapsed float64 `json:"elapsed_seconds"`
+}
+
+// Capabilities is what this build and its environment support
+type Capabilities struct {
+	Go       string `json:"go"`
+	Platform string `json:"platform"`
+	CPUs     int    `json:"cpus"`
+
+	// InputBitDepths are the WAV bit depths that decoded, Unsupported
+	// those that were refused
+	InputBitDepths       []int `json:"input_bit_depths"`
+	UnsupportedBitDepths []int `json:"unsupported_bit_depths"`
+
+	Encoders  []string `json:"encoders"`
+	Renderers []string `json:"renderers"`
+	Styles    []string `json:"styles"`
+	Scales    []string `json:"scales"`
+	Storage   []string `json:"storage"`
+	Queues    []string `json:"queues"`
+
+	// Commands are the external commands some features run, with where
+	// they were found, or empty when they are missing
+	Commands map[string]string `json:"commands"`
+}
+
+// selftestCommands are the external commands features depend on, and the
+// features they are for
+var selftestCommands = map[string]string{
+	"sqlite3": "-db history",
+	"sftp":    "sftp:// storage",
+	"ffmpeg":  "mp3 and opus -preview-clip and compressed capture -url streams",
+}
+
+// runSelftest implements the selftest subcommand and returns its exit
+// code: 0 when every check passed, 1 otherwise
+func runSelftest(args []string) int {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	keep := fs.String("keep", "", "write the synthetic audio and images to this directory and keep them (default a temporary one)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: only_waveform selftest [-json] [-keep dir]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	dir := *keep
+	if dir == "" {
+		tmp, err := os.MkdirTemp("", "only_waveform-selftest-")
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		defer os.RemoveAll(tmp)
+		dir = tmp
+	} else if err := os.MkdirAll(dir, 0o755); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	report := selftest(dir)
+
+	if *asJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		fmt.Println(string(data))
+	} else {
+		printSelftest(report)
+	}
+	if !report.Passed {
+		return 1
+	}
+	return 0
+}
+
+// selftest generates synthetic audio in dir, decodes and renders it every
+// way this build can and checks the results against what they must be
+func selftest(dir string) SelftestReport {
+	report := SelftestReport{Passed: true, Capabilities: capabilities()}
+	check := func(name string, fn func() error) {
+		start := time.Now()
+		err := fn()
+		c := SelftestCheck{Name: name, Passed: err == nil, Elapsed: time.Since(start).Seconds()}
+		if err != nil {
+			c.Error = err.Error()
+			report.Passed = false
+		}
+		report.Checks = append(report.Checks, c)
+	}
+
+	// A second of 440 Hz at 80% of full scale: every bucket holds whole
+	// cycles, so peaks are the amplitude throughout
+	audio := DefaultTestAudio()
+	input := filepath.Join(dir, "selftest.wav")
+	check("generate", func() error {
+		if err := WriteTestAudioFile(input, audio); err != nil {
+			return err
+		}
+		if result := validateWAV(input); !result.Valid {
+			return fmt.Errorf("generated file is invalid: %s%v", result.Error, result.Issues)
+		}
+		return nil
+	})
+
+	var peaks *Peaks
+	check("decode", func() error {
+		var err error
+		var frames int
+		if peaks, frames, err = decodePeaks(input, Options{Width: selftestWidth}, nil); err != nil {
+			return err
+		}
+		wantFrames := int(audio.Duration.Seconds() * float64(audio.SampleRate))
+		if frames != wantFrames || peaks.SampleRate != uint32(audio.SampleRate) || peaks.Len() != selftestWidth {
+			return fmt.Errorf("decoded %d frames at %d Hz into %d buckets, want %d at %d Hz into %d",
+				frames, peaks.SampleRate, peaks.Len(), wantFrames, audio.SampleRate, selftestWidth)
+		}
+		want := audio.Amplitude * 32767
+		if got := float64(loudestPeak(peaks.Channels[0])); got < want*0.99 || got > want*1.01 {
+			return fmt.Errorf("loudest peak is %.0f, want %.0f", got, want)
+		}
+		return nil
+	})
+
+	check("bit depths", func() error {
+		for _, bits := range []int{8, 16, 24, 32} {
+			depth := audio
+			depth.BitDepth, depth.Duration = bits, 10*time.Millisecond
+			file := filepath.Join(dir, fmt.Sprintf("selftest-%d.wav", bits))
+			if err := WriteTestAudioFile(file, depth); err != nil {
+				return err
+			}
+			if _, _, err := decodePeaks(file, Options{Width: 10}, nil); err != nil {
+				report.Capabilities.UnsupportedBitDepths = append(report.Capabilities.UnsupportedBitDepths, bits)
+			} else {
+				report.Capabilities.InputBitDepths = append(report.Capabilities.InputBitDepths, bits)
+			}
+		}
+		if len(report.Capabilities.InputBitDepths) == 0 {
+			return fmt.Errorf("no bit depth decodes")
+		}
+		return nil
+	})
+
+	if peaks == nil {
+		return report
+	}
+	for _, style := range report.Capabilities.Styles {
+		for _, scale := range report.Capabilities.Scales {
+			var want *image.RGBA
+			for _, name := range report.Capabilities.Renderers {
+				check(fmt.Sprintf("render %s %s %s", name, style, scale), func() error {
+					img, err := selftestRender(renderBackends[name], peaks.Channels[0], style, scale)
+					if err != nil {
+						return err
+					}
+					// Every renderer draws what the first does, pixel for pixel
+					if want == nil {
+						want = img
+						return savePNG(img, filepath.Join(dir, fmt.Sprintf("selftest-%s-%s.png", style, scale)), png.DefaultCompression)
+					}
+					defer putImage(img)
+					if !bytes.Equal(img.Pix, want.Pix) {
+						return fmt.Errorf("differs from the %s renderer", report.Capabilities.Renderers[0])
+					}
+					return nil
+				})
+			}
+			if want != nil {
+				putImage(want)
+			}
+		}
+	}
+
+	check("encode png", func() error {
+		ro := DefaultRenderOptions()
+		ro.Width, ro.Height = selftestWidth, selftestHeight
+		img, err := drawPeaks(peaks.Channels[0], ro, nil)
+		if err != nil {
+			return err
+		}
+		defer putImage(img)
+		for _, out := range []image.Image{img, paletted(img)} {
+			var buf bytes.Buffer
+			if err := encodePNG(&buf, out, png.DefaultCompression); err != nil {
+				return err
+			}
+			decoded, err := png.Decode(&buf)
+			if err != nil {
+				return err
+			}
+			diff, _, err := diffImages(img, decoded, ImageTolerance{})
+			if err != nil {
+				return err
+			}
+			if diff > 0 {
+				return fmt.Errorf("%d pixels changed in a %T round trip", diff, out)
+			}
+		}
+		return nil
+	})
+
+	return report
+}
+
+// selftestRender draws the self-test peaks in a style and scale and checks
+// the image: the background in the corners, and a waveform as tall as the
+// amplitude of the audio on the linear scale, taller on the dB one
+func selftestRender(backend RenderBackend, peaks ChannelPeaks, style, scale string) (*image.RGBA, error) {
+	ro := DefaultRenderOptions()
+	ro.Width, ro.Height = selftestWidth, selftestHeight
+	ro.Style, ro.Scale = style, scale
+	img, err := backend.Draw(peaks, ro, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	bg := ro.backgroundPixel()
+	if b := img.Bounds(); b.Dx() != selftestWidth || b.Dy() != selftestHeight {
+		putImage(img)
+		return nil, fmt.Errorf("drew %dx%d, want %dx%d", b.Dx(), b.Dy(), selftestWidth, selftestHeight)
+	}
+	if img.RGBAAt(0, 0) != bg || img.RGBAAt(selftestWidth-1, selftestHeight-1) != bg {
+		putImage(img)
+		return nil, fmt.Errorf("corners aren't the background")
+	}
+
+	top, bottom := selftestHeight, -1
+	for y := range selftestHeight {
+		for x := range selftestWidth {
+			if img.RGBAAt(x, y) != bg {
+				top, bottom = min(top, y), max(bottom, y)
+				break
+			}
+		}
+	}
+	extent := bottom - top + 1
+	low, high := int(0.8*selftestHeight)-3, int(0.8*selftestHeight)+3
+	if scale == ScaleDB {
+		low, high = high, selftestHeight
+	}
+	if extent < low || extent > high {
+		putImage(img)
+		return nil, fmt.Errorf("waveform is %d rows tall, want %d to %d", extent, low, high)
+	}
+	return img, nil
+}
+
+// capabilities returns what this build supports, and which of the commands
+// it runs for some features are installed
+func capabilities() Capabilities {
+	c := Capabilities{
+		Go:       runtime.Version(),
+		Platform: runtime.GOOS + "/" + runtime.GOARCH,
+		CPUs:     runtime.NumCPU(),
+		Encoders: []string{"png", "png indexed (-png-palette)", "peaks binary (-cache-dir)", "peaks.js json (/peaks)",
+			"rms json/csv", "spectrum json/csv", "report json", "preview clip wav (mp3, opus with ffmpeg)"},
+		Styles:   []string{StyleLine, StyleBars},
+		Scales:   []string{ScaleLinear, ScaleDB},
+		Storage:  []string{"local", "s3://", "gs://", "az://", "ftp://", "sftp://", "http(s):// (read only)"},
+		Queues:   []string{"redis://", "sqs://", "kafka://"},
+		Commands: map[string]string{},
+	}
+	for name := range renderBackends {
+		c.Renderers = append(c.Renderers, name)
+	}
+	// cpu first, as the reference the others are checked against
+	sort.Slice(c.Renderers, func(i, j int) bool {
+		return c.Renderers[i] == "cpu" || c.Renderers[j] != "cpu" && c.Renderers[i] < c.Renderers[j]
+	})
+	for command := range selftestCommands {
+		path, _ := exec.LookPath(command)
+		c.Commands[command] = path
+	}
+	return c
+}
+
+// printSelftest prints a report as text
+func printSelftest(r SelftestReport) {
+	for _, c := range r.Checks {
+		if c.Passed {
+			fmt.Printf("ok    %s (%s)\n", c.Name, formatDuration(time.Duration(c.Elapsed*float64(time.Second))))
+		} else {
+			fmt.Printf("FAIL  %s: %s\n", c.Name, c.Error)
+		}
+	}
+
+	c := r.Capabilities
+	fmt.Printf("\nBuild: %s %s, %d CPUs\n", c.Go, c.Platform, c.CPUs)
+	fmt.Printf("Input: WAV of %v bit samples", c.InputBitDepths)
+	if len(c.UnsupportedBitDepths) > 0 {
+		fmt.Printf(" (not %v)", c.UnsupportedBitDepths)
+	}
+	fmt.Printf("\nEncoders: %s\n", strings.Join(c.Encoders, ", "))
+	fmt.Printf("Renderers: %s\n", strings.Join(c.Renderers, ", "))
+	fmt.Printf("Styles: %s; scales: %s\n", strings.Join(c.Styles, ", "), strings.Join(c.Scales, ", "))
+	fmt.Printf("Storage: %s\n", strings.Join(c.Storage, ", "))
+	fmt.Printf("Queues: %s\n", strings.Join(c.Queues, ", "))
+	commands := make([]string, 0, len(c.Commands))
+	for command := range c.Commands {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	for _, command := range commands {
+		if path := c.Commands[command]; path != "" {
+			fmt.Printf("Command %s: %s\n", command, path)
+		} else {
+			fmt.Printf("Command %s: missing, needed for %s\n", command, selftestCommands[command])
+		}
+	}
+
+	if r.Passed {
+		fmt.Printf("\nAll %d checks passed\n", len(r.Checks))
+	} else {
+		fmt.Printf("\nSelf-test failed\n")
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSelftest(t *testing.T) {
+	dir := t.TempDir()
+	report := selftest(dir)
+	for _, c := range report.Checks {
+		if !c.Passed {
+			t.Errorf("%s: %s", c.Name, c.Error)
+		}
+	}
+	want := 3 + len(renderBackends)*4 + 1
+	if !report.Passed || len(report.Checks) != want {
+		t.Errorf("passed %v with %d checks, want %d", report.Passed, len(report.Checks), want)
+	}
+
+	c := report.Capabilities
+	if len(c.InputBitDepths) != 1 || c.InputBitDepths[0] != 16 || len(c.UnsupportedBitDepths) != 3 {
+		t.Errorf("bit depths %v, unsupported %v", c.InputBitDepths, c.UnsupportedBitDepths)
+	}
+	if c.Renderers[0] != "cpu" {
+		t.Errorf("renderers %v don't start with the reference", c.Renderers)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "selftest-bars-db.png")); err != nil {
+		t.Error(err)
+	}
+}
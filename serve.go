@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits on the image size a request may ask for, which -max-width and
+// -max-height can only lower
+const (
+	maxServeWidth  = 8192
+	maxServeHeight = 4096
+)
+
+// maxPeaksBuckets bounds the buckets of a /peaks response; requests for a
+// finer resolution get the smallest samples per pixel within it
+const maxPeaksBuckets = 1 << 20
+
+// maxUploadSize is the default bound on the WAV data posted to /peaks or
+// uploaded over gRPC
+const maxUploadSize = 1 << 30
+
+// Server renders waveforms of the WAV files under Root on request
+type Server struct {
+	Root        string
+	Compression png.CompressionLevel
+	Palette     bool // indexed-color PNGs, as -png-palette writes
+	Defaults    RenderOptions
+
+	// Cache keeps rendered responses by content hash and options; nil
+	// disables it. MaxAge is sent to clients in Cache-Control.
+	Cache  *responseCache
+	MaxAge time.Duration
+
+	// Memory bounds the memory held by renders in progress; nil is
+	// unlimited
+	Memory *MemoryBudget
+
+	// Limits bound what each request may ask for
+	Limits ServeLimits
+
+	// Tenants, when set, requires every request to name its tenant in the
+	// X-Tenant header: its files are served from Root/<tenant>, its
+	// responses are cached apart from other tenants' and Limit applies to
+	// each tenant on its own
+	Tenants bool
+	Limit   *RateLimiter
+
+	hashes   contentHashes
+	inflight inflightGroup
+}
+
+// Handler returns the HTTP handler of the server. With pprof the
+// net/http/pprof endpoints are mounted under /debug/pprof/ as well.
+func (s *Server) Handler(pprof bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /waveform/{file...}", s.tenanted(s.handleWaveform))
+	mux.HandleFunc("GET /peaks", s.tenanted(s.handlePeaks))
+	mux.HandleFunc("POST /peaks", s.tenanted(s.handlePeaks))
+	mux.HandleFunc("GET /metrics", metricsHandler)
+	mux.HandleFunc("POST "+grpcService+"{method}", s.tenanted(s.handleGRPC))
+
+	if pprof {
+		// net/http/pprof registers itself on the default mux
+		mux.Handle("/debug/pprof/", http.DefaultServeMux)
+	}
+
+	return mux
+}
+
+// tenanted runs h with the tenant of the request in its context when
+// Tenants is set, refusing requests without a valid tenant or over their
+// tenant's rate
+func (s *Server) tenanted(h http.HandlerFunc) http.HandlerFunc {
+	if !s.Tenants {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenant := r.Header.Get(tenantHeader)
+		if err := validateTenant(tenant); err != nil {
+			refuse(w, r, http.StatusBadRequest, grpcInvalidArgument, fmt.Sprintf("%s: %v", tenantHeader, err))
+			return
+		}
+		tenantRequests.inc(tenant)
+		if !s.Limit.Allow(tenant) {
+			tenantThrottled.inc(tenant)
+			refuse(w, r, http.StatusTooManyRequests, grpcResourceExhausted, "rate limit of the tenant exceeded")
+			return
+		}
+		h(w, r.WithContext(withTenant(r.Context(), tenant)))
+	}
+}
+
+// refuse answers a request with an error before it is handled: as HTTP
+// status, or as a trailers-only response of a gRPC status for gRPC calls
+func refuse(w http.ResponseWriter, r *http.Request, status, grpcCode int, message string) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Grpc-Status", strconv.Itoa(grpcCode))
+		w.Header().Set("Grpc-Message", grpcPercentEncode(message))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	http.Error(w, message, status)
+}
+
+// resolve maps a request path onto a file under Root, or under the
+// directory of the request's tenant, refusing anything that would escape it
+func (s *Server) resolve(ctx context.Context, name string) (string, error) {
+	clean := path.Clean("/" + name)
+	if strings.Contains(clean, "\x00") || !strings.HasSuffix(strings.ToLower(clean), ".wav") {
+		return "", fs.ErrNotExist
+	}
+	return filepath.Join(s.Root, tenantOf(ctx), filepath.FromSlash(clean)), nil
+}
+
+// parseRenderQuery applies the styling parameters of a request (width,
+// height, fg, bg, style, normalize, auto_range, headroom, colormap) to the
+// defaults
+func parseRenderQuery(q url.Values, defaults RenderOptions) (RenderOptions, error) {
+	ro := defaults
+	var err error
+
+	parseSize := func(name string, limit int, dst *int) {
+		v := q.Get(name)
+		if v == "" || err != nil {
+			return
+		}
+		n, convErr := strconv.Atoi(v)
+		if convErr != nil || n <= 0 {
+			err = fmt.Errorf("%s must be between 1 and %d", name, limit)
+			return
+		}
+		if n > limit {
+			err = &LimitError{Status: http.StatusBadRequest, Message: fmt.Sprintf("%s must be between 1 and %d", name, limit),
+				Limit: "max_" + name, Max: float64(limit), Value: float64(n)}
+			return
+		}
+		*dst = n
+	}
+	parseSize("width", maxServeWidth, &ro.Width)
+	parseSize("height", maxServeHeight, &ro.Height)
+	if err != nil {
+		return ro, err
+	}
+
+	if v := q.Get("fg"); v != "" {
+		if ro.Foreground, err = parseHexColor(v); err != nil {
+			return ro, fmt.Errorf("fg: %w", err)
+		}
+	}
+	if v := q.Get("bg"); v != "" {
+		if ro.Background, err = parseHexColor(v); err != nil {
+			return ro, fmt.Errorf("bg: %w", err)
+		}
+	}
+	if v := q.Get("style"); v != "" {
+		if ro.Style, err = parseStyle(v); err != nil {
+			return ro, err
+		}
+	}
+	if v := q.Get("normalize"); v != "" {
+		if ro.Normalize, err = strconv.ParseBool(v); err != nil {
+			return ro, fmt.Errorf("normalize must be 0 or 1")
+		}
+	}
+	if v := q.Get("auto_range"); v != "" {
+		if ro.AutoRange, err = strconv.ParseBool(v); err != nil {
+			return ro, fmt.Errorf("auto_range must be 0 or 1")
+		}
+	}
+	if v := q.Get("headroom"); v != "" {
+		db, convErr := strconv.ParseFloat(v, 64)
+		if convErr != nil {
+			db = math.NaN()
+		}
+		if ro.HeadroomDB, err = parseHeadroom(db); err != nil {
+			return ro, err
+		}
+	}
+	if v := q.Get("scale"); v != "" {
+		if ro.Scale, err = parseScale(v); err != nil {
+			return ro, err
+		}
+	}
+	if v := q.Get("colormap"); v != "" {
+		if ro.Colormap, err = parseColormap(v); err != nil {
+			return ro, err
+		}
+	}
+
+	return ro, nil
+}
+
+// handleWaveform renders the waveform PNG of a file
+func (s *Server) handleWaveform(w http.ResponseWriter, r *http.Request) {
+	ro, err := parseRenderQuery(r.URL.Query(), s.Defaults)
+	if err == nil {
+		err = s.Limits.checkSize(ro)
+	}
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	inputFile, err := s.resolve(r.Context(), r.PathValue("file"))
+	if err == nil {
+		_, err = os.Stat(inputFile)
+	}
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if err := s.Limits.checkDuration(inputFile); err != nil {
+		writeError(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	hash, err := s.hashes.hash(inputFile)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	s.respond(w, r, responseKey(hash, "waveform", renderOptionsKey(ro)), "image/png", func() ([]byte, int, error) {
+		return s.renderWaveform(inputFile, ro)
+	})
+}
+
+// renderOptionsKey identifies the look of a render for caching
+func renderOptionsKey(ro RenderOptions) string {
+	colormap := ""
+	if ro.Colormap != nil {
+		colormap = ro.Colormap.Name
+	}
+	return fmt.Sprintf("%dx%d|%v|%v|%s|%s|%t|%t|%g|%s", ro.Width, ro.Height, ro.Foreground, ro.Background, ro.Style, ro.Scale, ro.Normalize, ro.AutoRange, ro.HeadroomDB, colormap)
+}
+
+// renderWaveform decodes and renders a file to PNG, returning the status to
+// report on failure
+func (s *Server) renderWaveform(inputFile string, ro RenderOptions) ([]byte, int, error) {
+	opts := Options{Width: ro.Width, Height: ro.Height}
+	release, err := s.acquire(inputFile, opts, 0)
+	if err != nil {
+		return nil, http.StatusUnprocessableEntity, err
+	}
+	defer release()
+
+	peaks, _, err := decodePeaks(inputFile, opts, nil)
+	if err != nil {
+		errorsTotal.inc("decode")
+		return nil, http.StatusUnprocessableEntity, fmt.Errorf("failed to decode: %w", err)
+	}
+	defer releasePeaks(peaks)
+
+	img, err := drawPeaks(peaks.Channels[0], ro, nil)
+	if err != nil {
+		errorsTotal.inc("render")
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to render: %w", err)
+	}
+	defer putImage(img)
+
+	out := image.Image(img)
+	if s.Palette {
+		out = paletted(img)
+	}
+	var buf bytes.Buffer
+	if err := encodePNG(&buf, out, s.Compression); err != nil {
+		errorsTotal.inc("render")
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to encode PNG: %w", err)
+	}
+
+	return buf.Bytes(), 0, nil
+}
+
+// acquire waits for room in the memory budget to decode a file with opts,
+// where every bucket of the response takes bytesPerBucket more, and returns
+// the function that gives it back. Requests needing more than
+// MaxRequestMemory are refused instead.
+func (s *Server) acquire(inputFile string, opts Options, bytesPerBucket int64) (func(), error) {
+	info, _ := probeWAV(inputFile)
+	n := estimateMemory(opts, info, nil, decorations{}) + peakBuckets(opts, info)*bytesPerBucket
+	if err := s.Limits.checkMemory(n); err != nil {
+		return nil, err
+	}
+
+	s.Memory.Acquire(n)
+	return func() { s.Memory.Release(n) }, nil
+}
+
+// parsePeaksQuery returns the decode options and export format a /peaks
+// request asks for: samples_per_pixel for a fixed zoom level, or width to
+// fit the whole file into that many buckets; bits for the value size and
+// extended for the BucketStats of each bucket
+func parsePeaksQuery(q url.Values, defaultWidth int) (Options, peaksFormat, error) {
+	opts := Options{Width: defaultWidth, MaxBuckets: maxPeaksBuckets}
+	format := peaksFormat{bits: 16}
+
+	if v := q.Get("samples_per_pixel"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return opts, format, fmt.Errorf("samples_per_pixel must be a positive integer")
+		}
+		opts.SamplesPerPixel = n
+	} else if v := q.Get("width"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return opts, format, fmt.Errorf("width must be between 1 and %d", maxPeaksBuckets)
+		}
+		if n > maxPeaksBuckets {
+			return opts, format, &LimitError{Status: http.StatusBadRequest, Message: fmt.Sprintf("width must be between 1 and %d", maxPeaksBuckets),
+				Limit: "max_buckets", Max: maxPeaksBuckets, Value: float64(n)}
+		}
+		opts.Width = n
+	}
+
+	if v := q.Get("bits"); v != "" {
+		if v != "8" && v != "16" {
+			return opts, format, fmt.Errorf("bits must be 8 or 16")
+		}
+		format.bits, _ = strconv.Atoi(v)
+	}
+
+	if v := q.Get("extended"); v != "" {
+		var err error
+		if format.extended, err = strconv.ParseBool(v); err != nil {
+			return opts, format, fmt.Errorf("extended must be 0 or 1")
+		}
+	}
+
+	return opts, format, nil
+}
+
+// handlePeaks returns peaks.js JSON for a file under Root (GET, ?file=) or
+// for WAV data posted in the request body (POST)
+func (s *Server) handlePeaks(w http.ResponseWriter, r *http.Request) {
+	opts, format, err := parsePeaksQuery(r.URL.Query(), s.Defaults.Width)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var inputFile, hash string
+	if r.Method == http.MethodPost {
+		if err := s.Limits.checkUploadSize(r.ContentLength); err != nil {
+			writeError(w, err, http.StatusRequestEntityTooLarge)
+			return
+		}
+		// decodePeaks reads files, so the upload is spooled to disk first
+		inputFile, hash, err = spoolUpload(http.MaxBytesReader(w, r.Body, s.Limits.upload()))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			err = uploadLimitError(tooLarge.Limit, -1)
+		}
+		if err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		defer os.Remove(inputFile)
+	} else {
+		inputFile, err = s.resolve(r.Context(), r.URL.Query().Get("file"))
+		if err == nil {
+			hash, err = s.hashes.hash(inputFile)
+		}
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+	}
+	if err := s.Limits.checkDuration(inputFile); err != nil {
+		writeError(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	options := fmt.Sprintf("%d|%d|%d|%t", opts.Width, opts.SamplesPerPixel, format.bits, format.extended)
+	s.respond(w, r, responseKey(hash, "peaks", options), "application/json", func() ([]byte, int, error) {
+		// Each bucket is two numbers of up to 6 characters and a comma in
+		// the JSON text, and its RMS and two flags when extended
+		bytesPerBucket := int64(14)
+		if format.extended {
+			bytesPerBucket += 20
+		}
+		release, err := s.acquire(inputFile, opts, bytesPerBucket)
+		if err != nil {
+			return nil, http.StatusUnprocessableEntity, err
+		}
+		defer release()
+
+		analyzers := format.analyzers(opts)
+		peaks, _, err := decodePeaks(inputFile, opts, analyzers)
+		if err != nil {
+			errorsTotal.inc("decode")
+			return nil, http.StatusUnprocessableEntity, fmt.Errorf("failed to decode: %w", err)
+		}
+		defer releasePeaks(peaks)
+
+		data, err := json.Marshal(format.export(peaks, analyzers))
+		if err != nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("failed to encode peaks: %w", err)
+		}
+		return data, 0, nil
+	})
+}
+
+// spoolUpload copies a request body into a temporary file and returns its
+// name and content hash; the caller removes it
+func spoolUpload(body io.Reader) (string, string, error) {
+	file, err := os.CreateTemp("", "only_waveform_upload_*.wav")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create upload file: %w", err)
+	}
+	defer file.Close()
+
+	hash, err := hashReader(io.TeeReader(body, file))
+	if err != nil {
+		os.Remove(file.Name())
+		return "", "", fmt.Errorf("failed to read upload: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", "", fmt.Errorf("failed to write upload: %w", err)
+	}
+
+	return file.Name(), hash, nil
+}
+
+// runServe implements the serve subcommand
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	root := fs.String("root", "./audios", "directory holding the WAV files served")
+	width := fs.Int("width", 1920, "default image width in pixels")
+	height := fs.Int("height", 640, "default image height in pixels")
+	pngCompression := fs.String("png-compression", "default", "PNG compression: default, none, fast or best")
+	pngPalette := fs.Bool("png-palette", false, pngPaletteUsage)
+	cacheSize := fs.String("cache-size", "256MB", "memory for cached responses (0 disables caching)")
+	maxAge := fs.Duration("max-age", time.Hour, "how long clients may use a response before revalidating")
+	maxMemory := fs.String("max-memory", "", "limit on memory held by renders in progress, e.g. 512MB (unlimited when empty)")
+	pprof := fs.Bool("pprof", false, "also serve net/http/pprof under /debug/pprof/")
+	maxUpload := fs.String("max-upload", "1GB", "largest WAV file a request may upload")
+	maxDuration := fs.Duration("max-duration", 0, "refuse files longer than this, e.g. 2h (unlimited when 0)")
+	maxWidth := fs.Int("max-width", maxServeWidth, "widest image a request may ask for, at most 8192")
+	maxHeight := fs.Int("max-height", maxServeHeight, "tallest image a request may ask for, at most 4096")
+	maxRequestMemory := fs.String("max-request-memory", "", "refuse requests estimated to need more memory than this, e.g. 256MB (unlimited when empty)")
+	tenants, tenantRate, tenantBurst := tenantFlags(fs)
+	applyTimeouts := timeoutFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	compression, err := parseCompressionLevel(*pngCompression)
+	if err != nil {
+		return err
+	}
+
+	s := &Server{Root: *root, Compression: compression, Palette: *pngPalette, Defaults: DefaultRenderOptions(), MaxAge: *maxAge, Tenants: *tenants}
+	if s.Limit, err = tenantLimiter(*tenants, *tenantRate, *tenantBurst); err != nil {
+		return err
+	}
+	if *cacheSize != "0" {
+		limit, err := parseByteSize(*cacheSize)
+		if err != nil {
+			return fmt.Errorf("failed to parse -cache-size: %w", err)
+		}
+		s.Cache = newResponseCache(limit)
+	}
+	if *maxMemory != "" {
+		limit, err := parseByteSize(*maxMemory)
+		if err != nil {
+			return fmt.Errorf("failed to parse -max-memory: %w", err)
+		}
+		s.Memory = NewMemoryBudget(limit)
+	}
+	if s.Limits, err = serveLimits(*maxUpload, *maxDuration, *maxWidth, *maxHeight, *maxRequestMemory); err != nil {
+		return err
+	}
+	s.Defaults.Width = min(max(*width, 1), s.Limits.MaxWidth)
+	s.Defaults.Height = min(max(*height, 1), s.Limits.MaxHeight)
+
+	fmt.Printf("Serving waveforms of %s on http://%s/waveform/, /peaks and gRPC\n", *root, *addr)
+
+	// gRPC clients connect with HTTP/2 without TLS
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	server := &http.Server{Addr: *addr, Handler: s.Handler(*pprof), Protocols: &protocols}
+	if err := applyTimeouts(server); err != nil {
+		return err
+	}
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRenderQuery(t *testing.T) {
+	defaults := DefaultRenderOptions()
+	tests := []struct {
+		query string
+		check func(ro RenderOptions) bool
+	}{
+		{"", func(ro RenderOptions) bool { return ro == defaults }},
+		{"width=640&height=120", func(ro RenderOptions) bool { return ro.Width == 640 && ro.Height == 120 }},
+		{"fg=ff8000", func(ro RenderOptions) bool { return ro.Foreground == color.RGBA{0xff, 0x80, 0x00, 0xff} }},
+		{"fg=%23ff800080", func(ro RenderOptions) bool { return ro.Foreground == color.RGBA{0xff, 0x80, 0x00, 0x80} }},
+		{"bg=000000", func(ro RenderOptions) bool {
+			return ro.Background == color.RGBA{0, 0, 0, 0xff} && ro.Foreground == defaults.Foreground
+		}},
+		{"normalize=1", func(ro RenderOptions) bool { return ro.Normalize }},
+		{"normalize=true", func(ro RenderOptions) bool { return ro.Normalize }},
+		{"normalize=0", func(ro RenderOptions) bool { return !ro.Normalize }},
+		{"style=bars", func(ro RenderOptions) bool { return ro.Style == StyleBars }},
+	}
+	for _, tt := range tests {
+		q, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ro, err := parseRenderQuery(q, defaults)
+		if err != nil || !tt.check(ro) {
+			t.Errorf("%q gave %+v, %v", tt.query, ro, err)
+		}
+	}
+}
+
+func TestServeWaveform(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "root")
+	if err := os.Mkdir(root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteTestAudioFile(filepath.Join(root, "clip.wav"), DefaultTestAudio()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file next to the root that traversal must not reach
+	if err := WriteTestAudioFile(filepath.Join(filepath.Dir(root), "secret.wav"), DefaultTestAudio()); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{Root: root, Defaults: DefaultRenderOptions()}
+	s.Defaults.Width, s.Defaults.Height = 100, 20
+	handler := s.Handler(false)
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"styled", "/waveform/clip.wav?width=50&height=10&fg=ff8000&bg=00000000&normalize=1", http.StatusOK},
+		{"zero width", "/waveform/clip.wav?width=0", http.StatusBadRequest},
+		{"width not a number", "/waveform/clip.wav?width=wide", http.StatusBadRequest},
+		{"width over the ceiling", "/waveform/clip.wav?width=100000", http.StatusBadRequest},
+		{"short fg", "/waveform/clip.wav?fg=fff", http.StatusBadRequest},
+		{"fg not hex", "/waveform/clip.wav?fg=gg0000", http.StatusBadRequest},
+		{"bg not hex", "/waveform/clip.wav?bg=red", http.StatusBadRequest},
+		{"normalize not a bool", "/waveform/clip.wav?normalize=maybe", http.StatusBadRequest},
+		{"unknown style", "/waveform/clip.wav?style=dots", http.StatusBadRequest},
+		{"missing file", "/waveform/other.wav", http.StatusNotFound},
+		{"not a WAV file", "/waveform/clip.txt", http.StatusNotFound},
+		{"escaped traversal", "/waveform/..%2fsecret.wav", http.StatusNotFound},
+		{"nested traversal", "/waveform/a/..%2f..%2fsecret.wav", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.status {
+				t.Errorf("%s: %d %q, want %d", tt.target, rec.Code, rec.Body, tt.status)
+			}
+		})
+	}
+}
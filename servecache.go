@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cachedResponse is a rendered response body kept for repeat requests
+type cachedResponse struct {
+	key         string
+	contentType string
+	body        []byte
+}
+
+// responseCache keeps rendered responses up to a total size, evicting the
+// least recently used. A nil cache stores nothing.
+type responseCache struct {
+	mu      sync.Mutex
+	limit   int64
+	size    int64
+	entries map[string]*list.Element
+	order   *list.List // most recently used at the front
+}
+
+// newResponseCache returns a cache holding up to limit bytes of responses
+func newResponseCache(limit int64) *responseCache {
+	return &responseCache{
+		limit:   limit,
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+// get returns the response stored under key
+func (c *responseCache) get(key string) (*cachedResponse, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cachedResponse), true
+}
+
+// put stores a response, evicting old ones to make room. Responses larger
+// than the whole cache aren't stored.
+func (c *responseCache) put(resp *cachedResponse) {
+	if c == nil || int64(len(resp.body)) > c.limit {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[resp.key]; ok {
+		return
+	}
+
+	c.entries[resp.key] = c.order.PushFront(resp)
+	c.size += int64(len(resp.body))
+
+	for c.size > c.limit {
+		oldest := c.order.Back()
+		evicted := c.order.Remove(oldest).(*cachedResponse)
+		delete(c.entries, evicted.key)
+		c.size -= int64(len(evicted.body))
+	}
+}
+
+// contentHashEntry remembers the hash of a file's content, valid while its
+// size and modification time are unchanged
+type contentHashEntry struct {
+	modTime time.Time
+	size    int64
+	sum     string
+}
+
+// contentHashes memoizes file content hashes so requests for unchanged
+// files don't read them again
+type contentHashes struct {
+	mu      sync.Mutex
+	entries map[string]contentHashEntry
+}
+
+// hash returns the hex SHA-256 of a file's content
+func (h *contentHashes) hash(filename string) (string, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return "", err
+	}
+
+	h.mu.Lock()
+	e, ok := h.entries[filename]
+	h.mu.Unlock()
+	if ok && e.size == info.Size() && e.modTime.Equal(info.ModTime()) {
+		return e.sum, nil
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	sum, err := hashReader(file)
+	if err != nil {
+		return "", err
+	}
+
+	h.mu.Lock()
+	if h.entries == nil {
+		h.entries = map[string]contentHashEntry{}
+	}
+	h.entries[filename] = contentHashEntry{modTime: info.ModTime(), size: info.Size(), sum: sum}
+	h.mu.Unlock()
+
+	return sum, nil
+}
+
+// hashReader returns the hex SHA-256 of everything read from r
+func hashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", fmt.Errorf("failed to hash content: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// responseKey combines a content hash with the options that shaped the
+// response
+func responseKey(contentHash, kind, options string) string {
+	sum := sha256.Sum256([]byte(contentHash + "|" + kind + "|" + options))
+	return hex.EncodeToString(sum[:])
+}
+
+// etagMatches reports whether an If-None-Match header lists etag
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// inflightCall is a response being produced for the requests waiting on it
+type inflightCall struct {
+	done   chan struct{}
+	resp   *cachedResponse
+	status int
+	err    error
+}
+
+// inflightGroup coalesces concurrent cache misses: the first request for a
+// key produces the response and the others with the same key wait for it
+// instead of rendering the same thing again. The zero value is ready to use.
+type inflightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*inflightCall
+}
+
+// do runs produce for key unless a call for it is already in flight, in
+// which case it waits and returns that call's result
+func (g *inflightGroup) do(key string, produce func() (*cachedResponse, int, error)) (*cachedResponse, int, error) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.resp, call.status, call.err
+	}
+	if g.calls == nil {
+		g.calls = map[string]*inflightCall{}
+	}
+	// Waiters see an error rather than nothing if produce panics
+	call := &inflightCall{done: make(chan struct{}), status: http.StatusInternalServerError, err: fmt.Errorf("render failed")}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.resp, call.status, call.err = produce()
+	return call.resp, call.status, call.err
+}
+
+// respond serves the response stored under key, producing and caching it
+// first when needed. Concurrent misses for the same key share one render. The key doubles as the ETag, so clients revalidating
+// an unchanged asset get 304 Not Modified without anything being rendered.
+func (s *Server) respond(w http.ResponseWriter, r *http.Request, key, contentType string, produce func() ([]byte, int, error)) {
+	// Tenants share nothing, not even renders of the same content
+	tenant := tenantOf(r.Context())
+	if tenant != "" {
+		key = responseKey(key, "tenant", tenant)
+	}
+	w.Header().Set("ETag", strconv.Quote(key[:32]))
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(s.MaxAge.Seconds())))
+
+	if etagMatches(r.Header.Get("If-None-Match"), w.Header().Get("ETag")) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	resp, ok := s.Cache.get(key)
+	if !ok {
+		var status int
+		var err error
+		resp, status, err = s.inflight.do(key, func() (*cachedResponse, int, error) {
+			queueDepth.add(1)
+			body, status, err := produce()
+			queueDepth.add(-1)
+			if err != nil {
+				return nil, status, err
+			}
+			filesProcessed.inc()
+			resp := &cachedResponse{key: key, contentType: contentType, body: body}
+			s.Cache.put(resp)
+			return resp, status, nil
+		})
+		if err != nil {
+			if tenant != "" {
+				tenantErrors.inc(tenant)
+			}
+			// Errors aren't cacheable
+			w.Header().Del("ETag")
+			w.Header().Set("Cache-Control", "no-store")
+			writeError(w, err, status)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", resp.contentType)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(resp.body))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInflightGroupCoalesces(t *testing.T) {
+	var g inflightGroup
+	var calls atomic.Int32
+	release := make(chan struct{})
+
+	const waiters = 8
+	var started, wg sync.WaitGroup
+	started.Add(waiters)
+	results := make([]*cachedResponse, waiters)
+	for i := range waiters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			started.Done()
+			results[i], _, _ = g.do("key", func() (*cachedResponse, int, error) {
+				calls.Add(1)
+				<-release
+				return &cachedResponse{key: "key", body: []byte("png")}, http.StatusOK, nil
+			})
+		}()
+	}
+
+	// Give every goroutine time to reach do before the first call finishes
+	started.Wait()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("produce ran %d times, want 1", n)
+	}
+	for i, resp := range results {
+		if resp == nil || string(resp.body) != "png" {
+			t.Errorf("waiter %d got %+v", i, resp)
+		}
+	}
+	if len(g.calls) != 0 {
+		t.Errorf("%d calls left in flight", len(g.calls))
+	}
+}
+
+func TestInflightGroupSequential(t *testing.T) {
+	var g inflightGroup
+	calls := 0
+	produce := func() (*cachedResponse, int, error) {
+		calls++
+		return nil, http.StatusBadRequest, errors.New("bad")
+	}
+
+	for range 2 {
+		if _, status, err := g.do("key", produce); err == nil || status != http.StatusBadRequest {
+			t.Fatalf("do = %d, %v; want the error of produce", status, err)
+		}
+	}
+	// Finished calls aren't remembered, so a failed render is retried
+	if calls != 2 {
+		t.Errorf("produce ran %d times, want 2", calls)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Connection timeouts of the servers, so clients that send or read slowly,
+// or keep idle connections open, can't hold them forever. Bodies and
+// responses get long enough for the largest uploads and renders.
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultReadTimeout       = 10 * time.Minute
+	defaultWriteTimeout      = 10 * time.Minute
+	defaultIdleTimeout       = 2 * time.Minute
+)
+
+// timeoutFlags registers the connection timeout flags of a server on fs
+// and returns a function applying them to it once fs has been parsed
+func timeoutFlags(fs *flag.FlagSet) func(*http.Server) error {
+	readHeader := fs.Duration("read-header-timeout", defaultReadHeaderTimeout, "longest a client may take to send the headers of a request")
+	read := fs.Duration("read-timeout", defaultReadTimeout, "longest a client may take to send a whole request, upload included (unlimited when 0)")
+	write := fs.Duration("write-timeout", defaultWriteTimeout, "longest a response may take from the end of the request headers (unlimited when 0)")
+	idle := fs.Duration("idle-timeout", defaultIdleTimeout, "how long an idle keep-alive connection is kept open")
+	return func(server *http.Server) error {
+		if *readHeader <= 0 || *idle <= 0 || *read < 0 || *write < 0 {
+			return fmt.Errorf("-read-header-timeout and -idle-timeout must be positive, -read-timeout and -write-timeout not negative")
+		}
+		server.ReadHeaderTimeout, server.ReadTimeout = *readHeader, *read
+		server.WriteTimeout, server.IdleTimeout = *write, *idle
+		return nil
+	}
+}
+
+// ServeLimits bound what one request may ask of the server, so a public
+// deployment can't be tied up by a huge upload, a file of many hours or an
+// enormous render. Zero values are the defaults: the maxServeWidth by
+// maxServeHeight and maxUploadSize ceilings, and no limit on duration or
+// request memory.
+type ServeLimits struct {
+	MaxUpload   int64
+	MaxDuration time.Duration
+	MaxWidth    int
+	MaxHeight   int
+	// MaxRequestMemory bounds what a single render may hold, as
+	// estimated before decoding
+	MaxRequestMemory int64
+}
+
+// LimitError is a request refused for going over a limit. HTTP clients
+// get Status with the error as JSON; gRPC ones RESOURCE_EXHAUSTED.
+type LimitError struct {
+	Status  int     `json:"-"`
+	Message string  `json:"error"`
+	Limit   string  `json:"limit"`
+	Max     float64 `json:"max"`
+	Value   float64 `json:"value,omitempty"`
+}
+
+func (e *LimitError) Error() string {
+	return e.Message
+}
+
+// serveLimits returns the limits the serve flags set
+func serveLimits(maxUpload string, maxDuration time.Duration, maxWidth, maxHeight int, maxRequestMemory string) (ServeLimits, error) {
+	l := ServeLimits{MaxDuration: maxDuration, MaxWidth: maxWidth, MaxHeight: maxHeight}
+	if maxWidth <= 0 || maxWidth > maxServeWidth {
+		return l, fmt.Errorf("-max-width must be between 1 and %d", maxServeWidth)
+	}
+	if maxHeight <= 0 || maxHeight > maxServeHeight {
+		return l, fmt.Errorf("-max-height must be between 1 and %d", maxServeHeight)
+	}
+	if maxDuration < 0 {
+		return l, fmt.Errorf("-max-duration can't be negative")
+	}
+	var err error
+	if l.MaxUpload, err = parseByteSize(maxUpload); err != nil || l.MaxUpload <= 0 {
+		return l, fmt.Errorf("failed to parse -max-upload: %q isn't a positive size", maxUpload)
+	}
+	if maxRequestMemory != "" {
+		if l.MaxRequestMemory, err = parseByteSize(maxRequestMemory); err != nil {
+			return l, fmt.Errorf("failed to parse -max-request-memory: %w", err)
+		}
+	}
+	return l, nil
+}
+
+// upload returns the largest upload allowed
+func (l ServeLimits) upload() int64 {
+	if l.MaxUpload > 0 {
+		return l.MaxUpload
+	}
+	return maxUploadSize
+}
+
+// checkSize refuses a render larger than the limits
+func (l ServeLimits) checkSize(ro RenderOptions) error {
+	if l.MaxWidth > 0 && ro.Width > l.MaxWidth {
+		return &LimitError{Status: http.StatusBadRequest, Message: fmt.Sprintf("width must be at most %d", l.MaxWidth),
+			Limit: "max_width", Max: float64(l.MaxWidth), Value: float64(ro.Width)}
+	}
+	if l.MaxHeight > 0 && ro.Height > l.MaxHeight {
+		return &LimitError{Status: http.StatusBadRequest, Message: fmt.Sprintf("height must be at most %d", l.MaxHeight),
+			Limit: "max_height", Max: float64(l.MaxHeight), Value: float64(ro.Height)}
+	}
+	return nil
+}
+
+// checkUploadSize refuses an upload whose declared size is over the limit
+// before any of it is read; -1 is unknown
+func (l ServeLimits) checkUploadSize(size int64) error {
+	if size > l.upload() {
+		return uploadLimitError(l.upload(), size)
+	}
+	return nil
+}
+
+func uploadLimitError(limit, size int64) *LimitError {
+	e := &LimitError{Status: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("upload is larger than %d bytes", limit),
+		Limit: "max_upload", Max: float64(limit)}
+	if size > 0 {
+		e.Value = float64(size)
+	}
+	return e
+}
+
+// checkDuration refuses a file longer than the limit, by its header.
+// Files whose header can't be read are left for decoding to report.
+func (l ServeLimits) checkDuration(inputFile string) error {
+	if l.MaxDuration <= 0 {
+		return nil
+	}
+	info, err := probeWAV(inputFile)
+	if err != nil || info.SampleRate == 0 {
+		return nil
+	}
+	seconds := framesToSeconds(info.NumFrames, info.SampleRate)
+	if seconds > l.MaxDuration.Seconds() {
+		return &LimitError{Status: http.StatusUnprocessableEntity, Message: fmt.Sprintf("audio of %s is longer than %s", formatSeconds(seconds), formatDuration(l.MaxDuration)),
+			Limit: "max_duration", Max: l.MaxDuration.Seconds(), Value: seconds}
+	}
+	return nil
+}
+
+// checkMemory refuses a render estimated to need more than the limit
+func (l ServeLimits) checkMemory(n int64) error {
+	if l.MaxRequestMemory > 0 && n > l.MaxRequestMemory {
+		return &LimitError{Status: http.StatusUnprocessableEntity, Message: fmt.Sprintf("request would need %s of memory, more than %s", formatSize(n), formatSize(l.MaxRequestMemory)),
+			Limit: "max_request_memory", Max: float64(l.MaxRequestMemory), Value: float64(n)}
+	}
+	return nil
+}
+
+// writeError answers a request with an error: a LimitError as JSON with
+// its own status, anything else as text with status
+func writeError(w http.ResponseWriter, err error, status int) {
+	var limit *LimitError
+	if !errors.As(err, &limit) {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	body, _ := json.Marshal(limit)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(limit.Status)
+	w.Write(append(body, '\n'))
+}
+
+// grpcFailure is err as the gRPC error of a call: RESOURCE_EXHAUSTED for a
+// LimitError, code otherwise
+func grpcFailure(code int, err error) error {
+	var limit *LimitError
+	if errors.As(err, &limit) {
+		code = grpcResourceExhausted
+	}
+	return grpcErrorf(code, "%v", err)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestServeLimits(t *testing.T) {
+	input := writeFixture(t, DefaultTestAudio())
+	data, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits, err := serveLimits("10KB", 500*time.Millisecond, 1000, 200, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Root: filepath.Dir(input), Defaults: DefaultRenderOptions(), Limits: limits}
+	handler := s.Handler(false)
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+		limit  string
+	}{
+		{"width over the limit", httptest.NewRequest(http.MethodGet, "/waveform/fixture.wav?width=1001", nil), http.StatusBadRequest, "max_width"},
+		{"width over the ceiling", httptest.NewRequest(http.MethodGet, "/waveform/fixture.wav?width=9000", nil), http.StatusBadRequest, "max_width"},
+		{"height over the limit", httptest.NewRequest(http.MethodGet, "/waveform/fixture.wav?width=100&height=201", nil), http.StatusBadRequest, "max_height"},
+		{"file too long", httptest.NewRequest(http.MethodGet, "/waveform/fixture.wav?width=100&height=20", nil), http.StatusUnprocessableEntity, "max_duration"},
+		{"upload too large", httptest.NewRequest(http.MethodPost, "/peaks?width=10", bytes.NewReader(data)), http.StatusRequestEntityTooLarge, "max_upload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, tt.req)
+			var body LimitError
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("%d %q: %v", rec.Code, rec.Body, err)
+			}
+			if rec.Code != tt.status || body.Limit != tt.limit || body.Max == 0 || body.Message == "" {
+				t.Errorf("%d %+v, want %d for %s", rec.Code, body, tt.status, tt.limit)
+			}
+		})
+	}
+
+	// Uploads of unknown length are cut off as they are read
+	req := httptest.NewRequest(http.MethodPost, "/peaks?width=10", bytes.NewReader(data))
+	req.ContentLength = -1
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("streamed upload: %d %s", rec.Code, rec.Body)
+	}
+
+	s.Limits = ServeLimits{MaxRequestMemory: 1 << 10}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/waveform/fixture.wav?width=100&height=20", nil))
+	if rec.Code != http.StatusUnprocessableEntity || !bytes.Contains(rec.Body.Bytes(), []byte(`"max_request_memory"`)) {
+		t.Errorf("memory: %d %s", rec.Code, rec.Body)
+	}
+
+	// A file within the limits renders
+	s.Limits = limits
+	s.Limits.MaxDuration = 2 * time.Second
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/waveform/fixture.wav?width=100&height=20", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("within limits: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestServeLimitsGRPC(t *testing.T) {
+	input := writeFixture(t, DefaultTestAudio())
+	s := &Server{Root: filepath.Dir(input), Defaults: DefaultRenderOptions(), Limits: ServeLimits{MaxDuration: 500 * time.Millisecond, MaxWidth: 1000}}
+	ts, client := newGRPCTestServer(t, s)
+
+	for _, width := range []int{1001, 100} {
+		var e protoEncoder
+		e.string(1, "fixture.wav")
+		e.varint(3, uint64(width))
+		_, status, message := grpcInvoke(t, ts, client, "GenerateWaveform", e.buf)
+		if status != strconv.Itoa(grpcResourceExhausted) {
+			t.Errorf("width %d: status %s: %s", width, status, message)
+		}
+	}
+}
+
+func TestServeLimitsFlags(t *testing.T) {
+	for _, bad := range []struct {
+		upload        string
+		width, height int
+	}{{"1GB", 9000, 100}, {"1GB", 100, 0}, {"0", 100, 100}, {"lots", 100, 100}} {
+		if _, err := serveLimits(bad.upload, 0, bad.width, bad.height, ""); err == nil {
+			t.Errorf("%+v accepted", bad)
+		}
+	}
+}
+
+func TestServeTimeouts(t *testing.T) {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	applyTimeouts := timeoutFlags(fs)
+	if err := fs.Parse([]string{"-read-header-timeout", "50ms"}); err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.NotFoundHandler()}
+	if err := applyTimeouts(server); err != nil {
+		t.Fatal(err)
+	}
+	if server.ReadTimeout != defaultReadTimeout || server.WriteTimeout != defaultWriteTimeout || server.IdleTimeout != defaultIdleTimeout {
+		t.Errorf("timeouts = %v, %v, %v", server.ReadTimeout, server.WriteTimeout, server.IdleTimeout)
+	}
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	defer server.Close()
+
+	// A client that never finishes its headers is cut off
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Errorf("slow client kept its connection: %v", err)
+	}
+
+	fs = flag.NewFlagSet("serve", flag.ContinueOnError)
+	applyTimeouts = timeoutFlags(fs)
+	fs.Parse([]string{"-idle-timeout", "0"})
+	if err := applyTimeouts(&http.Server{}); err == nil {
+		t.Error("a zero -idle-timeout was accepted")
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// sftpStorage is a directory on an SFTP server. Transfers run the system
+// sftp client in batch mode, so authentication is whatever ssh is set up
+// with (keys, agent, ~/.ssh/config); password prompts aren't supported.
+type sftpStorage struct {
+	target string // [user@]host
+	port   string
+	dir    string
+
+	// command is the sftp client to run
+	command string
+}
+
+// newSFTPStorage opens an sftp://[user@]host[:port]/dir location. The path
+// is absolute; /~/dir is relative to the login directory.
+func newSFTPStorage(location string) (*sftpStorage, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", location, err)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("%s: missing host name", location)
+	}
+	if _, ok := u.User.Password(); ok {
+		return nil, fmt.Errorf("%s: sftp locations can't carry a password; use an SSH key", location)
+	}
+
+	s := &sftpStorage{
+		target:  u.Hostname(),
+		port:    u.Port(),
+		dir:     strings.TrimSuffix(u.Path, "/"),
+		command: "sftp",
+	}
+	if u.User != nil {
+		s.target = u.User.Username() + "@" + s.target
+	}
+	if rest, ok := strings.CutPrefix(s.dir, "/~"); ok {
+		s.dir = strings.TrimPrefix(rest, "/")
+	}
+	return s, nil
+}
+
+// filePath returns the server path of a file in the directory
+func (s *sftpStorage) filePath(name string) string {
+	if s.dir == "" {
+		return name
+	}
+	return s.dir + "/" + name
+}
+
+// sftpQuote quotes an argument of a batch command
+func sftpQuote(arg string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+// run executes batch commands in one session and returns what they print,
+// without the commands sftp echoes back
+func (s *sftpStorage) run(ctx context.Context, commands ...string) (string, error) {
+	for _, c := range commands {
+		if strings.ContainsAny(c, "\r\n") {
+			return "", fmt.Errorf("sftp: %q contains a line break", c)
+		}
+	}
+
+	args := []string{"-b", "-", "-o", "BatchMode=yes"}
+	if s.port != "" {
+		args = append(args, "-P", s.port)
+	}
+	args = append(args, s.target)
+
+	cmd := exec.CommandContext(ctx, s.command, args...)
+	cmd.Stdin = strings.NewReader(strings.Join(commands, "\n") + "\n")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("sftp %s: %w: %s", s.target, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var out strings.Builder
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if !strings.HasPrefix(line, "sftp> ") && line != "" {
+			out.WriteString(line + "\n")
+		}
+	}
+	return out.String(), nil
+}
+
+func (s *sftpStorage) List(ctx context.Context) ([]ObjectInfo, error) {
+	dir := s.dir
+	if dir == "" {
+		dir = "."
+	}
+	out, err := s.run(ctx, "ls -l "+sftpQuote(dir))
+	if err != nil {
+		return nil, err
+	}
+	return parseSFTPListing(out), nil
+}
+
+// parseSFTPListing reads the files of an ls -l listing:
+// -rw-r--r--  1 user group 1234 Jan  2 03:04 dir/name
+func parseSFTPListing(out string) []ObjectInfo {
+	var objects []ObjectInfo
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 9 || !strings.HasPrefix(fields[0], "-") {
+			continue // directories, links and anything unexpected
+		}
+
+		size, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		// The name is everything after the date, spaces included; there are
+		// at least nine fields, so each of the first eight ends in a space
+		rest := line
+		for range 8 {
+			rest = strings.TrimLeft(rest, " \t")
+			rest = rest[strings.IndexAny(rest, " \t"):]
+		}
+		name := path.Base(strings.TrimLeft(rest, " \t"))
+
+		objects = append(objects, ObjectInfo{Name: name, Size: size, ETag: strings.Join(fields[5:8], " ")})
+	}
+	return objects
+}
+
+// sftpDownload is a file fetched to a temporary path; closing it removes it
+type sftpDownload struct {
+	*os.File
+}
+
+func (d sftpDownload) Close() error {
+	err := d.File.Close()
+	os.Remove(d.File.Name())
+	return err
+}
+
+func (s *sftpStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	tmp, err := os.CreateTemp("", "only_waveform_sftp")
+	if err != nil {
+		return nil, err
+	}
+	tmp.Close()
+
+	if _, err := s.run(ctx, "get "+sftpQuote(s.filePath(name))+" "+sftpQuote(tmp.Name())); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	file, err := os.Open(tmp.Name())
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return sftpDownload{file}, nil
+}
+
+func (s *sftpStorage) Put(ctx context.Context, name string, r io.Reader, size int64, contentType string) error {
+	// sftp uploads local files, so the data is spooled to one first
+	file, err := os.CreateTemp("", "only_waveform_sftp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if _, err := io.Copy(file, r); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	// A leading - lets a batch go on when the command fails, as mkdir does
+	// for every level that already exists
+	var commands []string
+	if s.dir != "" {
+		dir := ""
+		if strings.HasPrefix(s.dir, "/") {
+			dir = "/"
+		}
+		for _, part := range strings.Split(strings.Trim(s.dir, "/"), "/") {
+			dir = path.Join(dir, part)
+			commands = append(commands, "-mkdir "+sftpQuote(dir))
+		}
+	}
+	commands = append(commands, "put "+sftpQuote(file.Name())+" "+sftpQuote(s.filePath(name)))
+
+	_, err = s.run(ctx, commands...)
+	return err
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSFTPListing(t *testing.T) {
+	out := strings.Join([]string{
+		"-rw-r--r--    1 radio    radio        1234 Jan  2 03:04 /srv/in/take.wav",
+		"drwxr-xr-x    2 radio    radio        4096 Jan  2 03:04 /srv/in/old",
+		"-rw-r--r--    1 radio    radio          10 Mar 15  2025 /srv/in/my take.wav",
+		"garbage",
+	}, "\n")
+
+	want := []ObjectInfo{
+		{Name: "take.wav", Size: 1234, ETag: "Jan 2 03:04"},
+		{Name: "my take.wav", Size: 10, ETag: "Mar 15 2025"},
+	}
+	got := parseSFTPListing(out)
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestNewSFTPStorage(t *testing.T) {
+	tests := []struct {
+		location string
+		want     sftpStorage
+		wantErr  bool
+	}{
+		{"sftp://cdn@example.com/srv/out/", sftpStorage{target: "cdn@example.com", dir: "/srv/out", command: "sftp"}, false},
+		{"sftp://example.com:2222/~/out", sftpStorage{target: "example.com", port: "2222", dir: "out", command: "sftp"}, false},
+		{"sftp://cdn:pw@example.com/out", sftpStorage{}, true},
+		{"sftp:///out", sftpStorage{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.location, func(t *testing.T) {
+			got, err := newSFTPStorage(tt.location)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && *got != tt.want {
+				t.Errorf("got %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeSFTP writes a stand-in for the sftp client that records its arguments
+// and batch commands, copying files for get and put like the real one
+func fakeSFTP(t *testing.T) (command, log string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	log = filepath.Join(dir, "log")
+	command = filepath.Join(dir, "sftp")
+	script := `#!/bin/sh
+echo "args: $*" >> ` + log + `
+while read -r cmd a b; do
+	echo "sftp> $cmd $a $b"
+	echo "$cmd $a $b" >> ` + log + `
+	a=$(echo "$a" | tr -d '"'); b=$(echo "$b" | tr -d '"')
+	case "$cmd" in
+	get) printf 'remote data' > "$b" ;;
+	put) cp "$a" "` + dir + `/uploaded" ;;
+	esac
+done
+`
+	if err := os.WriteFile(command, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return command, log
+}
+
+func TestSFTPStorageTransfers(t *testing.T) {
+	command, log := fakeSFTP(t)
+	s := &sftpStorage{target: "cdn@example.com", port: "2222", dir: "/srv/out", command: command}
+	ctx := context.Background()
+
+	if err := s.Put(ctx, "take.png", strings.NewReader("png"), 3, "image/png"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	uploaded, err := os.ReadFile(filepath.Join(filepath.Dir(command), "uploaded"))
+	if err != nil || string(uploaded) != "png" {
+		t.Errorf("uploaded %q, %v", uploaded, err)
+	}
+
+	body, err := s.Open(ctx, "take.wav")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	got, _ := io.ReadAll(body)
+	body.Close()
+	if string(got) != "remote data" {
+		t.Errorf("Open read %q", got)
+	}
+
+	logged, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"args: -b - -o BatchMode=yes -P 2222 cdn@example.com",
+		`-mkdir "/srv" `,
+		`-mkdir "/srv/out" `,
+		`"/srv/out/take.png"`,
+		`get "/srv/out/take.wav"`,
+	} {
+		if !bytes.Contains(logged, []byte(want)) {
+			t.Errorf("sftp session missing %q:\n%s", want, logged)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+// silenceWindow is the length of the windows silence is judged over, so
+// zero crossings inside loud audio don't count as silence
+const silenceWindow = 0.01 // seconds
+
+// SilenceGap is a silent region of a file, in seconds
+type SilenceGap struct {
+	Start    float64 `json:"start"`
+	End      float64 `json:"end"`
+	Duration float64 `json:"duration"`
+}
+
+// SilenceReport is the "silence" section of a file report
+type SilenceReport struct {
+	ThresholdDB float64      `json:"threshold_db"`
+	MinDuration float64      `json:"min_duration_seconds"`
+	AllSilent   bool         `json:"all_silent"`
+	Leading     float64      `json:"leading_seconds"`
+	Trailing    float64      `json:"trailing_seconds"`
+	Gaps        []SilenceGap `json:"gaps"`
+}
+
+// silenceAnalyzer finds leading, trailing and internal silence
+type silenceAnalyzer struct {
+	cfg       AnalysisConfig
+	threshold int32
+	info      StreamInfo
+
+	windowFrames int
+	minFrames    int   // shortest gap worth reporting
+	windowPos    int   // frames into the current window
+	windowPeak   int32 // loudest sample of the current window
+
+	pos         int // frames seen so far
+	silentStart int // first frame of the current silent run, or -1
+	firstSound  int // first frame of the first loud window, or -1
+	lastSound   int // frame after the last loud window
+
+	gaps [][2]int // internal silent runs as [start, end) frames
+}
+
+func newSilenceAnalyzer(cfg AnalysisConfig) Analyzer {
+	return &silenceAnalyzer{
+		cfg:         cfg,
+		threshold:   int32(dbToAmplitude(cfg.SilenceThresholdDB)),
+		silentStart: -1,
+		firstSound:  -1,
+	}
+}
+
+// Start implements Analyzer
+func (a *silenceAnalyzer) Start(info StreamInfo) {
+	a.info = info
+	a.windowFrames = max(1, int(silenceWindow*float64(info.SampleRate)))
+	a.minFrames = int(a.cfg.SilenceMinDuration.Seconds() * float64(info.SampleRate))
+}
+
+// Add implements Analyzer
+func (a *silenceAnalyzer) Add(left, right []int16) {
+	for i := range left {
+		peak := abs16(left[i])
+		if right != nil {
+			peak = max(peak, abs16(right[i]))
+		}
+		a.windowPeak = max(a.windowPeak, peak)
+
+		a.windowPos++
+		if a.windowPos == a.windowFrames {
+			a.endWindow()
+		}
+	}
+}
+
+// endWindow classifies the window that just finished
+func (a *silenceAnalyzer) endWindow() {
+	start := a.pos
+	a.pos += a.windowPos
+
+	if a.windowPeak < a.threshold {
+		if a.silentStart < 0 {
+			a.silentStart = start
+		}
+	} else {
+		// Silence before the first sound is leading silence, not a gap
+		if a.silentStart >= 0 && a.firstSound >= 0 && start-a.silentStart >= a.minFrames {
+			a.gaps = append(a.gaps, [2]int{a.silentStart, start})
+		}
+		if a.firstSound < 0 {
+			a.firstSound = start
+		}
+		a.silentStart = -1
+		a.lastSound = a.pos
+	}
+
+	a.windowPos = 0
+	a.windowPeak = 0
+}
+
+// Result implements Analyzer
+func (a *silenceAnalyzer) Result() any {
+	if a.windowPos > 0 {
+		a.endWindow()
+	}
+
+	rate := a.info.SampleRate
+
+	report := SilenceReport{
+		ThresholdDB: a.cfg.SilenceThresholdDB,
+		MinDuration: a.cfg.SilenceMinDuration.Seconds(),
+		Gaps:        []SilenceGap{},
+	}
+
+	if a.firstSound < 0 {
+		report.AllSilent = true
+		report.Leading = framesToSeconds(a.pos, rate)
+		report.Trailing = report.Leading
+		return report
+	}
+
+	report.Leading = framesToSeconds(a.firstSound, rate)
+	report.Trailing = framesToSeconds(a.pos-a.lastSound, rate)
+
+	for _, g := range a.gaps {
+		report.Gaps = append(report.Gaps, SilenceGap{
+			Start:    framesToSeconds(g[0], rate),
+			End:      framesToSeconds(g[1], rate),
+			Duration: framesToSeconds(g[1]-g[0], rate),
+		})
+	}
+
+	return report
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// OutputSink is a destination the outputs of every file are copied to as
+// well as the output location, so a pipeline needs no copy step of its own
+type OutputSink interface {
+	// Deliver copies the outputs of one file, the local files result
+	// lists; input is where the file came from
+	Deliver(ctx context.Context, input string, result FileResult) error
+	// String names the sink in reports
+	String() string
+}
+
+// SinkResult says how delivery to one sink went
+type SinkResult struct {
+	Sink  string `json:"sink"`
+	Error string `json:"error,omitempty"`
+}
+
+// openOutputSinks returns the sinks of a -sinks list: http:// and https://
+// URLs are sent a JSON payload holding the outputs, other locations are
+// storage the outputs are put in, tried again as retry says
+func openOutputSinks(list string, retry RetryPolicy) ([]OutputSink, error) {
+	var sinks []OutputSink
+	for _, location := range strings.Split(list, ",") {
+		location = strings.TrimSpace(location)
+		if location == "" {
+			continue
+		}
+		if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+			sinks = append(sinks, payloadSink{newWebhookSink(location)})
+			continue
+		}
+		storage, err := openStorage(location)
+		if err != nil {
+			return nil, fmt.Errorf("sink %s: %w", location, err)
+		}
+		sinks = append(sinks, storageSink{location: location, storage: storage, retry: retry})
+	}
+	return sinks, nil
+}
+
+// storageSink puts outputs in a storage location
+type storageSink struct {
+	location string
+	storage  Storage
+	retry    RetryPolicy
+}
+
+func (s storageSink) String() string { return s.location }
+
+func (s storageSink) Deliver(ctx context.Context, input string, result FileResult) error {
+	for _, output := range result.Outputs {
+		err := s.retry.do(ctx, func() error {
+			return putFile(ctx, s.storage, output)
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(output), err)
+		}
+	}
+	return nil
+}
+
+// putFile makes one attempt at putting a local file in storage under its
+// base name
+func putFile(ctx context.Context, storage Storage, localFile string) error {
+	file, err := os.Open(localFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Base(localFile)
+	return storage.Put(ctx, name, file, info.Size(), mime.TypeByExtension(filepath.Ext(name)))
+}
+
+// payloadSink POSTs the event of a file to a webhook with the outputs
+// inline, signed and retried like -webhook events
+type payloadSink struct {
+	webhook *webhookSink
+}
+
+// OutputPayload is what a payloadSink POSTs about a file
+type OutputPayload struct {
+	JobEvent
+	Files []OutputFile `json:"files"`
+}
+
+// OutputFile is an output carried in an OutputPayload, its content base64
+// encoded in the JSON
+type OutputFile struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Data        []byte `json:"data"`
+}
+
+func (s payloadSink) String() string { return s.webhook.url }
+
+func (s payloadSink) Deliver(ctx context.Context, input string, result FileResult) error {
+	payload := OutputPayload{JobEvent: newJobEvent(input, result, s.webhook.url, nil)}
+	for _, output := range result.Outputs {
+		data, err := os.ReadFile(output)
+		if err != nil {
+			return err
+		}
+		name := filepath.Base(output)
+		payload.Files = append(payload.Files, OutputFile{Name: name, ContentType: mime.TypeByExtension(filepath.Ext(name)), Data: data})
+	}
+	// Receivers see the names the outputs were sent under
+	payload.Outputs = nil
+	for _, file := range payload.Files {
+		payload.Outputs = append(payload.Outputs, file.Name)
+	}
+	return s.webhook.post(ctx, payload)
+}
+
+// deliverOutputs copies the outputs of a file to every sink at once and
+// records how each went in the result. A failing sink doesn't stop the
+// others; the error joins the failures of all of them.
+func deliverOutputs(sinks []OutputSink, input string, result *FileResult) error {
+	if len(sinks) == 0 || len(result.Outputs) == 0 {
+		return nil
+	}
+
+	delivered := *result
+	results := make([]SinkResult, len(sinks))
+	errs := make([]error, len(sinks))
+	var wg sync.WaitGroup
+	for i, sink := range sinks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i].Sink = sink.String()
+			if err := sink.Deliver(context.Background(), input, delivered); err != nil {
+				results[i].Error = err.Error()
+				errs[i] = fmt.Errorf("%s: %w", sink, err)
+			}
+		}()
+	}
+	wg.Wait()
+	result.Sinks = results
+
+	for i, err := range errs {
+		if err != nil {
+			fmt.Printf("failed to deliver outputs: %v  %v\n", input, err)
+			errorsTotal.inc("sink")
+		} else {
+			fmt.Printf("  Delivered to %s\n", results[i].Sink)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("failed to deliver outputs: %w", err)
+	}
+	return nil
+}
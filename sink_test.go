@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutputSinksFanOut(t *testing.T) {
+	inputDir, outputDir, copyDir := t.TempDir(), t.TempDir(), filepath.Join(t.TempDir(), "copies")
+	WriteTestAudioFile(filepath.Join(inputDir, "take.wav"), DefaultTestAudio())
+
+	payloads := make(chan OutputPayload, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload OutputPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+		payloads <- payload
+	}))
+	defer hook.Close()
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusForbidden)
+	}))
+	defer rejecting.Close()
+
+	batch, err := newStorageBatch(inputDir, outputDir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer batch.Close()
+	if batch.sinks, err = openOutputSinks(copyDir+", "+hook.URL+","+rejecting.URL, batch.retry); err != nil {
+		t.Fatal(err)
+	}
+
+	queueDepth.add(1)
+	result, err := processBatchFile(ObjectInfo{Name: "take.wav"}, outputDir, batch, Options{Width: 100, Height: 20})
+	if err == nil {
+		t.Error("a rejecting sink didn't fail the file")
+	}
+	if len(result.Sinks) != 3 || result.Sinks[0].Error != "" || result.Sinks[1].Error != "" || result.Sinks[2].Error == "" {
+		t.Errorf("sinks %+v", result.Sinks)
+	}
+
+	// The outputs stay in -output and reach the other sinks
+	want, err := os.ReadFile(filepath.Join(outputDir, "take.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(copyDir, "take.png")); string(got) != string(want) {
+		t.Error("directory sink copy differs")
+	}
+	payload := <-payloads
+	if len(payload.Files) != 1 || payload.Files[0].Name != "take.png" || payload.Files[0].ContentType != "image/png" || string(payload.Files[0].Data) != string(want) {
+		t.Errorf("payload files %+v", payload.Files)
+	}
+	if len(payload.Outputs) != 1 || payload.Outputs[0] != "take.png" {
+		t.Errorf("payload outputs %v", payload.Outputs)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// spectrumFloorDB is the lowest level exported, so silent bins stay finite
+const spectrumFloorDB = -200
+
+// SpectrumExport is the magnitude spectrum written by -spectrum-size. Levels
+// are in dBFS per bin; Windows holds one spectrum per FFT frame when
+// per-window export is enabled.
+type SpectrumExport struct {
+	FFTSize       int         `json:"fft_size"`
+	SampleRate    uint32      `json:"sample_rate"`
+	WindowSeconds float64     `json:"window_seconds"`
+	Frequencies   []float64   `json:"frequencies"`
+	Average       []float64   `json:"average"`
+	Windows       [][]float32 `json:"windows,omitempty"`
+}
+
+// spectrumAnalyzer computes spectra of consecutive frames of the mono mix
+type spectrumAnalyzer struct {
+	size       int
+	perWindow  bool
+	info       StreamInfo
+	frame      *spectrumFrame
+	samples    []float64
+	magnitudes []float64
+
+	power   []float64 // summed power per bin over all frames
+	frames  int
+	windows [][]float32
+}
+
+func newSpectrumAnalyzer(size int, perWindow bool) *spectrumAnalyzer {
+	return &spectrumAnalyzer{size: size, perWindow: perWindow}
+}
+
+// Start implements Analyzer
+func (a *spectrumAnalyzer) Start(info StreamInfo) {
+	a.info = info
+	a.frame = newSpectrumFrame(a.size)
+	a.samples = make([]float64, 0, a.size)
+	a.magnitudes = make([]float64, a.size/2+1)
+	a.power = make([]float64, a.size/2+1)
+}
+
+// memoryEstimate implements memoryUser: the FFT buffers and, with
+// per-window output, the levels of every frame
+func (a *spectrumAnalyzer) memoryEstimate(info StreamInfo) int64 {
+	n := spectrumMemory(a.size)
+	if a.perWindow {
+		n += (int64(info.NumFrames)/int64(a.size) + 1) * int64(a.size/2+1) * 4
+	}
+	return n
+}
+
+// Add implements Analyzer
+func (a *spectrumAnalyzer) Add(left, right []int16) {
+	for i := range left {
+		s := float64(left[i])
+		if right != nil {
+			s = (s + float64(right[i])) / 2
+		}
+		a.samples = append(a.samples, s/32768.0)
+
+		if len(a.samples) == a.size {
+			a.endFrame()
+		}
+	}
+}
+
+// endFrame transforms the frame that just filled up
+func (a *spectrumAnalyzer) endFrame() {
+	a.frame.magnitudes(a.samples, a.magnitudes)
+
+	for i, m := range a.magnitudes {
+		a.power[i] += m * m
+	}
+	a.frames++
+
+	if a.perWindow {
+		levels := make([]float32, len(a.magnitudes))
+		for i, m := range a.magnitudes {
+			levels[i] = float32(magnitudeToDB(m))
+		}
+		a.windows = append(a.windows, levels)
+	}
+
+	a.samples = a.samples[:0]
+}
+
+// magnitudeToDB converts a normalized magnitude to dBFS, floored
+func magnitudeToDB(m float64) float64 {
+	return max(20*math.Log10(m), spectrumFloorDB)
+}
+
+// Result implements Analyzer
+func (a *spectrumAnalyzer) Result() any {
+	// A trailing partial frame is zero padded rather than dropped
+	if len(a.samples) > 0 {
+		for len(a.samples) < a.size {
+			a.samples = append(a.samples, 0)
+		}
+		a.endFrame()
+	}
+
+	bins := a.size/2 + 1
+	export := SpectrumExport{
+		FFTSize:       a.size,
+		SampleRate:    a.info.SampleRate,
+		WindowSeconds: framesToSeconds(a.size, a.info.SampleRate),
+		Frequencies:   make([]float64, bins),
+		Average:       make([]float64, bins),
+		Windows:       a.windows,
+	}
+
+	for i := range bins {
+		export.Frequencies[i] = float64(i) * float64(a.info.SampleRate) / float64(a.size)
+		power := 0.0
+		if a.frames > 0 {
+			power = a.power[i] / float64(a.frames)
+		}
+		export.Average[i] = magnitudeToDB(math.Sqrt(power))
+	}
+
+	return export
+}
+
+// writeSpectrum writes a spectrum as JSON or CSV to the named file. The CSV
+// has a row per bin: frequency, average, then one column per window.
+func writeSpectrum(filename, format string, export SpectrumExport) error {
+	if format == "json" {
+		data, err := json.Marshal(export)
+		if err != nil {
+			return fmt.Errorf("failed to encode spectrum: %w", err)
+		}
+		return atomicWriteFile(filename, append(data, '\n'))
+	}
+
+	return atomicWrite(filename, func(file *os.File) error {
+		return writeSpectrumCSV(file, export)
+	})
+}
+
+// writeSpectrumCSV writes a spectrum as CSV
+func writeSpectrumCSV(out io.Writer, export SpectrumExport) error {
+	w := csv.NewWriter(out)
+
+	header := []string{"frequency", "average"}
+	for i := range export.Windows {
+		header = append(header, strconv.FormatFloat(float64(i)*export.WindowSeconds, 'f', 3, 64))
+	}
+	w.Write(header)
+
+	for bin, freq := range export.Frequencies {
+		row := []string{
+			strconv.FormatFloat(freq, 'f', 2, 64),
+			strconv.FormatFloat(export.Average[bin], 'f', 2, 64),
+		}
+		for _, window := range export.Windows {
+			row = append(row, strconv.FormatFloat(float64(window[bin]), 'f', 2, 32))
+		}
+		w.Write(row)
+	}
+	w.Flush()
+
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write spectrum: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import "math"
+
+// ChannelStats holds basic statistics of one channel. Sample values are
+// normalized to [-1, 1]; ZeroCrossingRate is in crossings per second.
+type ChannelStats struct {
+	Channel          string   `json:"channel"`
+	Min              float64  `json:"min"`
+	Max              float64  `json:"max"`
+	Mean             float64  `json:"mean"`
+	RMS              float64  `json:"rms"`
+	PeakDB           *float64 `json:"peak_dbfs"`
+	ZeroCrossingRate float64  `json:"zero_crossing_rate"`
+}
+
+// StatsReport is the "stats" section of a file report
+type StatsReport struct {
+	Channels []ChannelStats `json:"channels"`
+}
+
+// channelAccumulator gathers the statistics of one channel
+type channelAccumulator struct {
+	min, max  int16
+	sum       int64
+	squares   float64
+	crossings int
+	negative  bool // sign of the last sample
+}
+
+// add adds samples to the statistics
+func (c *channelAccumulator) add(samples []int16, first bool) {
+	for i, v := range samples {
+		if first && i == 0 {
+			c.min, c.max, c.negative = v, v, v < 0
+		}
+		c.min = min(c.min, v)
+		c.max = max(c.max, v)
+		c.sum += int64(v)
+		c.squares += float64(v) * float64(v)
+
+		if (v < 0) != c.negative {
+			c.crossings++
+			c.negative = v < 0
+		}
+	}
+}
+
+// statsAnalyzer computes per-channel statistics
+type statsAnalyzer struct {
+	info     StreamInfo
+	frames   int
+	channels [2]channelAccumulator
+}
+
+func newStatsAnalyzer(cfg AnalysisConfig) Analyzer {
+	return &statsAnalyzer{}
+}
+
+// Start implements Analyzer
+func (a *statsAnalyzer) Start(info StreamInfo) {
+	a.info = info
+}
+
+// Add implements Analyzer
+func (a *statsAnalyzer) Add(left, right []int16) {
+	first := a.frames == 0
+	a.channels[0].add(left, first)
+	if right != nil {
+		a.channels[1].add(right, first)
+	}
+	a.frames += len(left)
+}
+
+// Result implements Analyzer
+func (a *statsAnalyzer) Result() any {
+	var report StatsReport
+
+	for ch, name := range channelNames {
+		c := a.channels[ch]
+		stats := ChannelStats{
+			Channel: name,
+			Min:     float64(c.min) / 32768.0,
+			Max:     float64(c.max) / 32768.0,
+		}
+
+		if a.frames > 0 {
+			n := float64(a.frames)
+			stats.Mean = float64(c.sum) / n / 32768.0
+			stats.RMS = math.Sqrt(c.squares/n) / 32768.0
+			stats.ZeroCrossingRate = float64(c.crossings) / framesToSeconds(a.frames, a.info.SampleRate)
+		}
+
+		peak := max(math.Abs(stats.Min), math.Abs(stats.Max))
+		stats.PeakDB = finiteOrNil(20 * math.Log10(peak))
+
+		report.Channels = append(report.Channels, stats)
+	}
+
+	return report
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+)
+
+// stitchPeaks decodes the files in order into one timeline of width
+// buckets, as if they were a single recording. It also returns the markers
+// of where each file after the first starts.
+func stitchPeaks(files []string, width int) (*Peaks, boundaryMarkers, error) {
+	// The bucket size has to be known up front, from every file's length
+	var rate uint32
+	total := 0
+	for _, file := range files {
+		info, err := probeWAV(file)
+		if err != nil {
+			return nil, boundaryMarkers{}, fmt.Errorf("%s: %w", file, err)
+		}
+		if rate == 0 {
+			rate = info.SampleRate
+		} else if info.SampleRate != rate {
+			return nil, boundaryMarkers{}, fmt.Errorf("%s: sample rate %d Hz differs from %d Hz of %s", file, info.SampleRate, rate, files[0])
+		}
+		total += info.NumFrames
+	}
+
+	samplesPerPixel := samplesPerPixelFor(total, width)
+	builder := newFittedPeakBuilder(total, width)
+
+	var markers boundaryMarkers
+	for i, file := range files {
+		if i > 0 {
+			markers.boundaries = append(markers.boundaries, markers.total)
+		}
+		n, err := stitchFile(builder, file)
+		if err != nil {
+			return nil, boundaryMarkers{}, fmt.Errorf("%s: %w", file, err)
+		}
+		markers.total += n
+	}
+	if markers.total == 0 {
+		return nil, boundaryMarkers{}, fmt.Errorf("no audio data found in the files")
+	}
+
+	peaks := &Peaks{
+		SampleRate:      rate,
+		SamplesPerPixel: uint32(samplesPerPixel),
+		Channels:        []ChannelPeaks{builder.Peaks()},
+	}
+	return peaks, markers, nil
+}
+
+// stitchFile adds the left channel of a file to builder and returns the
+// number of frames it had
+func stitchFile(builder *PeakBuilder, file string) (int, error) {
+	r, err := openWAV(file, false, true)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	for {
+		left, _, err := r.readPCM()
+		if err == io.EOF {
+			return r.framesRead, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		builder.AddInt16(left)
+	}
+}
+
+// boundaryMarkers draws a marker where each part of a stitched timeline
+// starts
+type boundaryMarkers struct {
+	boundaries []int // first frame of each part after the first
+	total      int   // frames in the whole timeline
+}
+
+// drawOverlay implements imageOverlay
+func (m boundaryMarkers) drawOverlay(img *image.RGBA) {
+	width := img.Bounds().Dx()
+	for _, frame := range m.boundaries {
+		drawMarker(img, min(frame*width/m.total, width-1))
+	}
+}
+
+// readFileList reads the files named one per line in list, skipping blank
+// lines and lines starting with #
+func readFileList(list string) ([]string, error) {
+	f, err := os.Open(list)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var files []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			files = append(files, line)
+		}
+	}
+	return files, scanner.Err()
+}
+
+// runStitch implements the stitch subcommand
+func runStitch(args []string) error {
+	fs := flag.NewFlagSet("stitch", flag.ExitOnError)
+	output := fs.String("o", "stitched.png", "waveform image to write")
+	width := fs.Int("width", 1920, "image width in pixels")
+	height := fs.Int("height", 640, "image height in pixels")
+	list := fs.String("list", "", "file naming the inputs in order, one per line, before any given as arguments")
+	drawMarkers := fs.Bool("markers", false, "draw a marker where each file after the first starts")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: only_waveform stitch [flags] part1.wav part2.wav ...\n")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	var files []string
+	if *list != "" {
+		var err error
+		if files, err = readFileList(*list); err != nil {
+			return fmt.Errorf("failed to read -list: %w", err)
+		}
+	}
+	files = append(files, fs.Args()...)
+	if len(files) == 0 {
+		fs.Usage()
+		return fmt.Errorf("stitch needs at least one input file")
+	}
+
+	peaks, markers, err := stitchPeaks(files, *width)
+	if err != nil {
+		return err
+	}
+	defer releasePeaks(peaks)
+
+	ro := DefaultRenderOptions()
+	ro.Width, ro.Height = *width, *height
+	img, err := drawPeaks(peaks.Channels[0], ro, nil)
+	if err != nil {
+		return err
+	}
+	defer putImage(img)
+
+	if *drawMarkers {
+		markers.drawOverlay(img)
+	}
+
+	if err := savePNG(img, *output, png.DefaultCompression); err != nil {
+		return err
+	}
+	fmt.Printf("Stitched %d files: %s\n", len(files), *output)
+	return nil
+}
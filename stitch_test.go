@@ -0,0 +1,76 @@
+package main
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStitchPeaks(t *testing.T) {
+	dir := t.TempDir()
+	loud, quiet := DefaultTestAudio(), DefaultTestAudio()
+	loud.Duration = 3 * time.Second
+	quiet.Amplitude = 0.1
+	files := []string{filepath.Join(dir, "1.wav"), filepath.Join(dir, "2.wav")}
+	for i, audio := range []TestAudio{loud, quiet} {
+		if err := WriteTestAudioFile(files[i], audio); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	peaks, markers, err := stitchPeaks(files, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releasePeaks(peaks)
+
+	if peaks.Len() != 100 {
+		t.Fatalf("%d buckets, want 100", peaks.Len())
+	}
+	if markers.total != 4*44100 || len(markers.boundaries) != 1 || markers.boundaries[0] != 3*44100 {
+		t.Errorf("markers = %v of %d frames, want one at frame %d of %d", markers.boundaries, markers.total, 3*44100, 4*44100)
+	}
+	// The loud part fills the first three quarters of the timeline
+	ch := peaks.Channels[0]
+	if ch.Max[70] < 20000 || ch.Max[80] > 5000 {
+		t.Errorf("max of bucket 70 = %d and of bucket 80 = %d, want the loud part first", ch.Max[70], ch.Max[80])
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 100, 10))
+	markers.drawOverlay(img)
+	if img.RGBAAt(75, 5) != markerColor {
+		t.Error("no marker drawn at the boundary")
+	}
+
+	other := DefaultTestAudio()
+	other.SampleRate = 48000
+	mismatched := filepath.Join(dir, "48k.wav")
+	WriteTestAudioFile(mismatched, other)
+	if _, _, err := stitchPeaks([]string{files[0], mismatched}, 100); err == nil {
+		t.Error("files of different sample rates stitched")
+	}
+}
+
+func TestRunStitchList(t *testing.T) {
+	dir := t.TempDir()
+	part := filepath.Join(dir, "part.wav")
+	if err := WriteTestAudioFile(part, DefaultTestAudio()); err != nil {
+		t.Fatal(err)
+	}
+	list := filepath.Join(dir, "parts.txt")
+	os.WriteFile(list, []byte("# tape 1\n"+part+"\n\n"+part+"\n"), 0o644)
+
+	output := filepath.Join(dir, "tape.png")
+	if err := runStitch([]string{"-list", list, "-markers", "-o", output, "-width", "200", "-height", "50", part}); err != nil {
+		t.Fatal(err)
+	}
+	img, err := readPNG(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 200 || b.Dy() != 50 {
+		t.Errorf("image is %v, want 200x50", b)
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ObjectInfo describes a file listed in a storage location
+type ObjectInfo struct {
+	Name string
+	Size int64
+	// ETag changes whenever the content does; local files have none
+	ETag string
+	// ModTime is when a local file was last written; remote ones have none
+	ModTime time.Time
+}
+
+// Storage is a directory-like location that batch inputs are listed and read
+// from and outputs are written to: a local directory or a bucket prefix
+type Storage interface {
+	// List returns the files directly in the location
+	List(ctx context.Context) ([]ObjectInfo, error)
+	// Open streams a file; the caller closes it
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	// Put writes size bytes from r to a file, replacing any existing one
+	Put(ctx context.Context, name string, r io.Reader, size int64, contentType string) error
+}
+
+// openStorage returns the storage for a location: s3://, gs:// and az://
+// URLs name bucket prefixes, ftp:// and sftp:// URLs server directories,
+// http:// and https:// URLs web server directories to read from, anything
+// else is a local directory
+func openStorage(location string) (Storage, error) {
+	switch {
+	case strings.HasPrefix(location, "ftp://"):
+		return newFTPStorage(location)
+	case strings.HasPrefix(location, "sftp://"):
+		return newSFTPStorage(location)
+	case strings.HasPrefix(location, "s3://"):
+		return newS3Storage(location)
+	case strings.HasPrefix(location, "gs://"):
+		return newGCSStorage(location)
+	case strings.HasPrefix(location, "az://"):
+		return newAzureStorage(location)
+	case strings.HasPrefix(location, "http://"), strings.HasPrefix(location, "https://"):
+		return newHTTPStorage(location), nil
+	}
+	return localStorage(location), nil
+}
+
+// splitBucketURL splits scheme://bucket/prefix into the bucket and the prefix
+// without surrounding slashes
+func splitBucketURL(location, scheme string) (string, string, error) {
+	rest, _ := strings.CutPrefix(location, scheme)
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("%s: missing bucket name", location)
+	}
+	return bucket, strings.Trim(prefix, "/"), nil
+}
+
+// joinKey returns the object key of name under prefix
+func joinKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "/" + name
+}
+
+// listPrefix returns prefix with a trailing slash, as listings expect
+func listPrefix(prefix string) string {
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+// httpStatusError is a non-2xx response of a storage or queue API
+type httpStatusError struct {
+	Method string
+	Path   string
+	Status string
+	Code   int
+	Body   string // the start of the body
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("%s %s: %s: %s", e.Method, e.Path, e.Status, e.Body)
+}
+
+// checkResponse turns a non-2xx response into an httpStatusError that
+// includes the start of the body, closing it
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return &httpStatusError{
+		Method: resp.Request.Method,
+		Path:   resp.Request.URL.Path,
+		Status: resp.Status,
+		Code:   resp.StatusCode,
+		Body:   strings.TrimSpace(string(body)),
+	}
+}
+
+// localStorage is a directory on local disk
+type localStorage string
+
+// path returns the local path of a file in the directory
+func (d localStorage) path(name string) string {
+	return filepath.Join(string(d), name)
+}
+
+func (d localStorage) List(ctx context.Context) ([]ObjectInfo, error) {
+	files, err := os.ReadDir(string(d))
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]ObjectInfo, 0, len(files))
+	for _, file := range files {
+		obj := ObjectInfo{Name: file.Name()}
+		if info, err := file.Info(); err == nil {
+			obj.Size, obj.ModTime = info.Size(), info.ModTime()
+		}
+		objects = append(objects, obj)
+	}
+	return objects, nil
+}
+
+func (d localStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(d.path(name))
+}
+
+func (d localStorage) Put(ctx context.Context, name string, r io.Reader, size int64, contentType string) error {
+	if err := os.MkdirAll(string(d), 0755); err != nil {
+		return err
+	}
+
+	return atomicWrite(d.path(name), func(file *os.File) error {
+		_, err := io.Copy(file, r)
+		return err
+	})
+}
+
+// storageBatch moves batch inputs and outputs between storage and local
+// files. Remote inputs are downloaded before decoding and outputs for remote
+// storage are written to a local staging directory, then uploaded; local
+// directories are used in place.
+type storageBatch struct {
+	inputPath string
+
+	input   Storage
+	output  Storage
+	workDir string
+
+	// transfers limits concurrent downloads and uploads
+	transfers chan struct{}
+	// retry says how transfers are retried after transient failures
+	retry RetryPolicy
+
+	// outputNames holds the output base names assigned to the inputs of
+	// the batch, from assignOutputNames
+	outputNames map[string]string
+
+	// sinks are further destinations every file's outputs are copied to
+	sinks []OutputSink
+}
+
+// newStorageBatch opens the input and output locations of a batch
+func newStorageBatch(inputPath, outputDir string, concurrency int) (*storageBatch, error) {
+	input, err := openStorage(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	output, err := openStorage(outputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &storageBatch{inputPath: inputPath, input: input, output: output, transfers: make(chan struct{}, max(concurrency, 1)), retry: DefaultRetryPolicy()}
+	if b.remoteInput() || b.remoteOutput() {
+		b.workDir, err = os.MkdirTemp("", "only_waveform_storage")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create work directory: %w", err)
+		}
+	}
+	return b, nil
+}
+
+// remoteInput reports whether inputs have to be downloaded
+func (b *storageBatch) remoteInput() bool {
+	_, local := b.input.(localStorage)
+	return !local
+}
+
+// remoteOutput reports whether outputs have to be uploaded
+func (b *storageBatch) remoteOutput() bool {
+	_, local := b.output.(localStorage)
+	return !local
+}
+
+// Close removes the local work directory
+func (b *storageBatch) Close() error {
+	if b.workDir == "" {
+		return nil
+	}
+	return os.RemoveAll(b.workDir)
+}
+
+// listInputs returns the files in the input location
+func (b *storageBatch) listInputs() ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := b.retry.do(context.Background(), func() error {
+		var err error
+		objects, err = b.input.List(context.Background())
+		return err
+	})
+	return objects, err
+}
+
+// assignOutputNames gives the inputs of the batch output base names that
+// don't collide; inputs left out get their outputBaseName
+func (b *storageBatch) assignOutputNames(names []string) {
+	b.outputNames = outputBaseNames(names)
+}
+
+// batchInput is one file of a batch
+type batchInput struct {
+	Name string // file name in the input location
+	Path string // local file that is decoded
+
+	// OutputName is the base name its outputs are written under
+	OutputName string
+
+	// Location is where the file came from as reports and hooks show it:
+	// the local path, or the URL of a remote object
+	Location string
+
+	// Offset is how far into the file Path starts, when only a stretch of
+	// it was fetched
+	Offset time.Duration
+
+	// Version identifies the content of a remote object for the peak cache.
+	// It is empty for local files, which are checked by mtime and size, and
+	// for objects listed without an ETag, whose fresh download never matches.
+	Version string
+
+	// Metadata holds the tags of the file once it has been read; nil when
+	// it has none
+	Metadata *AudioMetadata
+}
+
+// batchInput describes an input listed in the input location. Remote
+// inputs have no local Path until they are fetched.
+func (b *storageBatch) batchInput(obj ObjectInfo) batchInput {
+	outputName, ok := b.outputNames[obj.Name]
+	if !ok {
+		outputName = outputBaseName(obj.Name)
+	}
+
+	if !b.remoteInput() {
+		path := filepath.Join(b.inputPath, obj.Name)
+		return batchInput{Name: obj.Name, Path: path, Location: path, OutputName: outputName}
+	}
+	input := batchInput{Name: obj.Name, Location: strings.TrimSuffix(b.inputPath, "/") + "/" + obj.Name, OutputName: outputName}
+	if obj.ETag != "" {
+		input.Version = fmt.Sprintf("%s|%d", obj.ETag, obj.Size)
+	}
+	return input
+}
+
+// fetch downloads an input to the work directory and returns its path. A
+// download failing transiently starts over.
+func (b *storageBatch) fetch(name string) (string, error) {
+	return b.fetchWith(name, func(localFile string) error {
+		return b.download(name, localFile)
+	})
+}
+
+// fetchWith copies an input to a fresh local file with download, which
+// makes one attempt at it, retrying as the batch does
+func (b *storageBatch) fetchWith(name string, download func(localFile string) error) (string, error) {
+	b.transfers <- struct{}{}
+	defer func() { <-b.transfers }()
+
+	dir, err := os.MkdirTemp(b.workDir, "in")
+	if err != nil {
+		return "", err
+	}
+	// Object names may hold characters the local filesystem doesn't allow
+	localFile := filepath.Join(dir, safeFileName(name))
+
+	err = b.retry.do(context.Background(), func() error {
+		return download(localFile)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to download: %w", err)
+	}
+	return localFile, nil
+}
+
+// download makes one attempt at copying an input to localFile
+func (b *storageBatch) download(name, localFile string) error {
+	body, err := b.input.Open(context.Background(), name)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	file, err := os.Create(localFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, body); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// stagingDir returns a fresh local directory for the outputs of one file
+func (b *storageBatch) stagingDir() (string, error) {
+	return os.MkdirTemp(b.workDir, "out")
+}
+
+// upload sends every file in a staging directory to the output location
+func (b *storageBatch) upload(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if err := b.uploadFile(filepath.Join(dir, e.Name())); err != nil {
+			return fmt.Errorf("failed to upload %s: %w", e.Name(), err)
+		}
+	}
+	return nil
+}
+
+// uploadFile streams one local file to the output location. Put replaces
+// the object, so an upload failing transiently is simply sent again.
+func (b *storageBatch) uploadFile(localFile string) error {
+	b.transfers <- struct{}{}
+	defer func() { <-b.transfers }()
+
+	return b.retry.do(context.Background(), func() error {
+		return b.put(localFile)
+	})
+}
+
+// put makes one attempt at uploading a local file
+func (b *storageBatch) put(localFile string) error {
+	return putFile(context.Background(), b.output, localFile)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBatchInput(t *testing.T) {
+	obj := ObjectInfo{Name: "take.wav", Size: 1234, ETag: `"abc"`}
+	remote := &storageBatch{inputPath: "s3://media/rec/", input: &s3Storage{bucket: "media", prefix: "rec"}}
+
+	tests := []struct {
+		name  string
+		batch *storageBatch
+		obj   ObjectInfo
+		want  batchInput
+	}{
+		{"local", &storageBatch{inputPath: "audios", input: localStorage("audios")}, obj,
+			batchInput{Name: "take.wav", Path: filepath.Join("audios", "take.wav"), Location: filepath.Join("audios", "take.wav"), OutputName: "take"}},
+		{"remote", remote, obj,
+			batchInput{Name: "take.wav", Location: "s3://media/rec/take.wav", Version: `"abc"|1234`, OutputName: "take"}},
+		// Size alone can't tell a changed object from the cached one
+		{"remote without ETag", remote, ObjectInfo{Name: "take.wav", Size: 1234},
+			batchInput{Name: "take.wav", Location: "s3://media/rec/take.wav", OutputName: "take"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.batch.batchInput(tt.obj); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
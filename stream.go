@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// defaultStreamCommand decodes compressed streams (MP3, AAC, Ogg, HLS
+// playlists) to the PCM capture reads, reconnecting when they drop
+const defaultStreamCommand = "ffmpeg -loglevel error -reconnect 1 -reconnect_streamed 1 -i {url} -vn -ac {channels} -ar {rate} -f s16le -"
+
+// pcmStream is uncompressed 16-bit audio received over HTTP
+type pcmStream struct {
+	io.ReadCloser
+	rate      int
+	channels  int
+	bigEndian bool
+}
+
+// openPCMStream connects to a stream URL. Streams of uncompressed audio
+// (audio/wav and audio/L16) are returned ready to read; for anything else
+// it returns nil, and the stream is left to a decoding command.
+func openPCMStream(ctx context.Context, url string) (*pcmStream, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "only_waveform")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch mediaType {
+	case "audio/wav", "audio/x-wav", "audio/wave", "audio/vnd.wave":
+		rate, channels, err := readWAVStreamHeader(resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: %w", url, err)
+		}
+		return &pcmStream{ReadCloser: resp.Body, rate: rate, channels: channels}, nil
+
+	case "audio/l16":
+		// RFC 2586: big-endian samples, one channel unless stated
+		rate, err := strconv.Atoi(params["rate"])
+		channels := 1
+		if err == nil && params["channels"] != "" {
+			channels, err = strconv.Atoi(params["channels"])
+		}
+		if err != nil || rate <= 0 || channels <= 0 {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: invalid audio/L16 parameters %v", url, params)
+		}
+		return &pcmStream{ReadCloser: resp.Body, rate: rate, channels: channels, bigEndian: true}, nil
+	}
+
+	resp.Body.Close()
+	return nil, nil
+}
+
+// readWAVStreamHeader reads the chunks of a WAV stream up to its sample
+// data. Streams are open-ended, so the data size isn't used.
+func readWAVStreamHeader(r io.Reader) (rate, channels int, err error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return 0, 0, fmt.Errorf("failed to read WAV header: %w", err)
+	}
+	if string(riff[:4]) != "RIFF" || string(riff[8:]) != "WAVE" {
+		return 0, 0, fmt.Errorf("not a valid WAV stream")
+	}
+
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return 0, 0, fmt.Errorf("failed to read WAV header: %w", err)
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+
+		switch string(chunk[:4]) {
+		case "fmt ":
+			if size < 16 {
+				return 0, 0, fmt.Errorf("fmt chunk of %d bytes is too short", size)
+			}
+			var format [16]byte
+			if _, err := io.ReadFull(r, format[:]); err != nil {
+				return 0, 0, fmt.Errorf("failed to read WAV header: %w", err)
+			}
+			if audioFormat := binary.LittleEndian.Uint16(format[0:]); audioFormat != 1 {
+				return 0, 0, fmt.Errorf("only PCM streams are supported (format %d)", audioFormat)
+			}
+			if bits := binary.LittleEndian.Uint16(format[14:]); bits != 16 {
+				return 0, 0, fmt.Errorf("only 16-bit streams are supported (found %d bits)", bits)
+			}
+			channels = int(binary.LittleEndian.Uint16(format[2:]))
+			rate = int(binary.LittleEndian.Uint32(format[4:]))
+			// Chunks are padded to an even size
+			size -= 16
+		case "data":
+			if rate <= 0 || channels <= 0 {
+				return 0, 0, fmt.Errorf("sample data before the fmt chunk")
+			}
+			return rate, channels, nil
+		}
+
+		if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+			return 0, 0, fmt.Errorf("failed to read WAV header: %w", err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// wavStreamHeader is the header of an open-ended WAV stream with a LIST
+// chunk before the fmt chunk, as some encoders write
+func wavStreamHeader(rate, channels int) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(0xffffffff))
+	b.WriteString("WAVE")
+	b.WriteString("LIST")
+	binary.Write(&b, binary.LittleEndian, uint32(5))
+	b.WriteString("INFO\x00\x00") // odd size, padded
+	b.WriteString("fmt ")
+	for _, v := range []any{
+		uint32(16), uint16(1), uint16(channels), uint32(rate),
+		uint32(rate * channels * 2), uint16(channels * 2), uint16(16),
+	} {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(0xffffffff))
+	return b.Bytes()
+}
+
+func TestOpenPCMStream(t *testing.T) {
+	samples := rawPCM(100, 2, func(i int) int16 { return int16(i) })
+	mux := http.NewServeMux()
+	mux.HandleFunc("/wav", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/x-wav")
+		w.Write(wavStreamHeader(22050, 2))
+		w.Write(samples)
+	})
+	mux.HandleFunc("/l16", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/L16; rate=8000")
+		w.Write([]byte{0x12, 0x34})
+	})
+	mux.HandleFunc("/mp3", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write([]byte{0xff, 0xfb})
+	})
+	mux.HandleFunc("/bad", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/wav")
+		w.Write([]byte("RIFF\x00\x00\x00\x00AVI "))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	ctx := context.Background()
+
+	stream, err := openPCMStream(ctx, ts.URL+"/wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream.rate != 22050 || stream.channels != 2 || stream.bigEndian {
+		t.Errorf("wav stream = %d Hz, %d channels, big endian %t", stream.rate, stream.channels, stream.bigEndian)
+	}
+	var data bytes.Buffer
+	data.ReadFrom(stream)
+	stream.Close()
+	if !bytes.Equal(data.Bytes(), samples) {
+		t.Errorf("read %d bytes of samples, want %d", data.Len(), len(samples))
+	}
+
+	stream, err = openPCMStream(ctx, ts.URL+"/l16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Close()
+	if stream.rate != 8000 || stream.channels != 1 || !stream.bigEndian {
+		t.Errorf("L16 stream = %d Hz, %d channels, big endian %t", stream.rate, stream.channels, stream.bigEndian)
+	}
+
+	if stream, err := openPCMStream(ctx, ts.URL+"/mp3"); err != nil || stream != nil {
+		t.Errorf("mp3 stream = %v, %v; want it left to the decoding command", stream, err)
+	}
+	if _, err := openPCMStream(ctx, ts.URL+"/bad"); err == nil {
+		t.Error("invalid WAV stream accepted")
+	}
+	if _, err := openPCMStream(ctx, ts.URL+"/missing"); err == nil {
+		t.Error("404 accepted")
+	}
+}
+
+func TestRunCaptureStream(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/wav")
+		w.Write(wavStreamHeader(8000, 1))
+		// Endless, like a radio stream
+		chunk := rawPCM(800, 1, func(i int) int16 { return int16(i * 40) })
+		for r.Context().Err() == nil {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	output := filepath.Join(t.TempDir(), "air.png")
+	if err := runCapture([]string{"-url", ts.URL, "-duration", "2s", "-window", "1s", "-o", output, "-width", "100", "-height", "40"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Errorf("no image written: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// Render styles
+const (
+	StyleLine = "line" // a continuous min/max line per column
+	StyleBars = "bars" // separated bars mirrored around the center
+)
+
+// Bar geometry of StyleBars, in pixels
+const (
+	barWidth  = 3
+	barStride = barWidth + 1 // bar plus a one column gap
+)
+
+// RenderOptions control how peaks are drawn into an image
+type RenderOptions struct {
+	Width  int
+	Height int
+
+	Foreground color.RGBA
+	Background color.RGBA
+
+	// Style is StyleLine or StyleBars
+	Style string
+
+	// Scale is ScaleLinear or ScaleDB
+	Scale string
+
+	// Normalize scales the waveform so its loudest peak fills the height
+	Normalize bool
+
+	// AutoRange scales it so its loudest peak fills all but HeadroomDB of
+	// the height instead, and annotates the level of the image edges
+	AutoRange  bool
+	HeadroomDB float64
+
+	// Gain, when set, is the factor amplitudes are scaled by instead, as
+	// a scale shared by several files; the level of the edges is annotated
+	// as with AutoRange
+	Gain float64
+
+	// Colormap, when set, colors each column by its level instead of
+	// drawing it in Foreground
+	Colormap *Colormap
+}
+
+// DefaultRenderOptions returns the look of batch renders
+func DefaultRenderOptions() RenderOptions {
+	return RenderOptions{
+		Foreground: waveformColor,
+		Background: backgroundColor,
+		Style:      StyleLine,
+		Scale:      ScaleLinear,
+		HeadroomDB: defaultHeadroomDB,
+	}
+}
+
+// parseStyle validates a render style name
+func parseStyle(style string) (string, error) {
+	switch style {
+	case StyleLine, StyleBars:
+		return style, nil
+	}
+	return "", fmt.Errorf("unknown style %q (want %s or %s)", style, StyleLine, StyleBars)
+}
+
+// parseHexColor parses an RRGGBB or RRGGBBAA color, with or without a
+// leading '#'
+func parseHexColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) != 6 && len(hex) != 8 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q (want RRGGBB or RRGGBBAA)", s)
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q (want RRGGBB or RRGGBBAA)", s)
+	}
+
+	return color.RGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// FileMetadataReader finds the tags of one format of audio file, read from
+// its start
+type FileMetadataReader func(file io.ReadSeeker) (*AudioMetadata, error)
+
+// fileMetadataFormat is a format of audio file tags can be read from,
+// recognized by its first bytes
+type fileMetadataFormat struct {
+	name  string
+	match func(head []byte) bool
+	read  FileMetadataReader
+}
+
+// fileMetadataFormats holds the formats in the order they are tried
+var fileMetadataFormats []fileMetadataFormat
+
+// registerFileMetadataReader makes read parse files whose first bytes match
+func registerFileMetadataReader(name string, match func(head []byte) bool, read FileMetadataReader) {
+	fileMetadataFormats = append(fileMetadataFormats, fileMetadataFormat{name: name, match: match, read: read})
+}
+
+func init() {
+	registerFileMetadataReader("WAV", matchPrefix("RIFF"), readWAVSourceMetadata)
+	registerFileMetadataReader("FLAC", matchPrefix("fLaC"), readFLACMetadata)
+	registerFileMetadataReader("Ogg", matchPrefix("OggS"), readOggMetadata)
+	registerFileMetadataReader("MP3", matchMP3, readMP3Metadata)
+}
+
+func matchPrefix(magic string) func(head []byte) bool {
+	return func(head []byte) bool { return bytes.HasPrefix(head, []byte(magic)) }
+}
+
+// matchMP3 recognizes an ID3v2 tag or an MPEG audio frame sync
+func matchMP3(head []byte) bool {
+	return bytes.HasPrefix(head, []byte("ID3")) || len(head) >= 2 && head[0] == 0xff && head[1]&0xe0 == 0xe0
+}
+
+// readFLACMetadata reads the Vorbis comment block among the metadata
+// blocks at the start of a FLAC file
+func readFLACMetadata(file io.ReadSeeker) (*AudioMetadata, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(file, magic[:]); err != nil || string(magic[:]) != "fLaC" {
+		return nil, fmt.Errorf("not a FLAC file")
+	}
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(file, header[:]); err != nil {
+			return nil, fmt.Errorf("failed to read metadata block: %w", err)
+		}
+		last, kind := header[0]&0x80 != 0, header[0]&0x7f
+		size := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+
+		// Block 4 is VORBIS_COMMENT
+		if kind == 4 && size <= maxMetadataChunk {
+			data := make([]byte, size)
+			if _, err := io.ReadFull(file, data); err != nil {
+				return nil, fmt.Errorf("failed to read Vorbis comment: %w", err)
+			}
+			md, err := readVorbisComment(data)
+			return tagged(md), err
+		}
+		if last {
+			return nil, nil
+		}
+		if _, err := file.Seek(size, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readOggMetadata reads the comment header of the first stream of an Ogg
+// file: its second packet, "\x03vorbis" or "OpusTags" and then a Vorbis
+// comment
+func readOggMetadata(file io.ReadSeeker) (*AudioMetadata, error) {
+	var packets [][]byte
+	var packet []byte
+	var serial uint32
+	read := 0
+	for first := true; len(packets) < 2; first = false {
+		var header [27]byte
+		if _, err := io.ReadFull(file, header[:]); err != nil {
+			return nil, fmt.Errorf("failed to read Ogg page: %w", err)
+		}
+		if string(header[:4]) != "OggS" {
+			return nil, fmt.Errorf("lost Ogg page sync")
+		}
+		segments := make([]byte, header[26])
+		if _, err := io.ReadFull(file, segments); err != nil {
+			return nil, fmt.Errorf("failed to read Ogg page: %w", err)
+		}
+		body := 0
+		for _, n := range segments {
+			body += int(n)
+		}
+		data := make([]byte, body)
+		if _, err := io.ReadFull(file, data); err != nil {
+			return nil, fmt.Errorf("failed to read Ogg page: %w", err)
+		}
+
+		// Pages of other streams interleaved with the first are skipped
+		if pageSerial := binary.LittleEndian.Uint32(header[14:18]); first {
+			serial = pageSerial
+		} else if pageSerial != serial {
+			continue
+		}
+		if read += body; read > maxMetadataChunk {
+			return nil, fmt.Errorf("Ogg headers larger than %d bytes", maxMetadataChunk)
+		}
+
+		// A packet ends with the first segment shorter than 255 bytes
+		for _, n := range segments {
+			packet = append(packet, data[:n]...)
+			data = data[n:]
+			if n < 255 {
+				packets, packet = append(packets, packet), nil
+			}
+		}
+	}
+
+	comment := packets[1]
+	switch {
+	case bytes.HasPrefix(comment, []byte("\x03vorbis")):
+		comment = comment[7:]
+	case bytes.HasPrefix(comment, []byte("OpusTags")):
+		comment = comment[8:]
+	default:
+		return nil, nil // a codec without Vorbis comments
+	}
+	md, err := readVorbisComment(comment)
+	return tagged(md), err
+}
+
+// readVorbisComment parses a Vorbis comment: a vendor string and then
+// KEY=value fields, of which TITLE, ARTIST and ALBUM are kept, the first
+// one of each
+func readVorbisComment(data []byte) (AudioMetadata, error) {
+	var md AudioMetadata
+	next := func() ([]byte, error) {
+		if len(data) < 4 {
+			return nil, errors.New("Vorbis comment is cut short")
+		}
+		n := int(binary.LittleEndian.Uint32(data))
+		if n > len(data)-4 {
+			return nil, errors.New("Vorbis comment field runs past its end")
+		}
+		field := data[4 : 4+n]
+		data = data[4+n:]
+		return field, nil
+	}
+
+	if _, err := next(); err != nil { // the vendor
+		return md, err
+	}
+	if len(data) < 4 {
+		return md, errors.New("Vorbis comment is cut short")
+	}
+	count := int(binary.LittleEndian.Uint32(data))
+	data = data[4:]
+
+	names := map[string]*string{"TITLE": &md.Title, "ARTIST": &md.Artist, "ALBUM": &md.Album}
+	for range count {
+		field, err := next()
+		if err != nil {
+			return md, err
+		}
+		key, value, ok := strings.Cut(string(field), "=")
+		if dst := names[strings.ToUpper(key)]; ok && dst != nil && *dst == "" {
+			*dst = strings.TrimSpace(value)
+		}
+	}
+	return md, nil
+}
+
+// readMP3Metadata reads the ID3v2 tag at the start of an MP3 file, and
+// fills what it leaves out from an ID3v1 tag in the last 128 bytes
+func readMP3Metadata(file io.ReadSeeker) (*AudioMetadata, error) {
+	var md AudioMetadata
+	var header [10]byte
+	if _, err := io.ReadFull(file, header[:]); err == nil && string(header[:3]) == "ID3" {
+		size := int64(syncsafe(header[6:10]))
+		if size > maxMetadataChunk {
+			return nil, fmt.Errorf("ID3v2 tag larger than %d bytes", maxMetadataChunk)
+		}
+		tag := make([]byte, 10+size)
+		copy(tag, header[:])
+		if _, err := io.ReadFull(file, tag[10:]); err != nil {
+			return nil, fmt.Errorf("failed to read ID3v2 tag: %w", err)
+		}
+		if md, err = readID3v2(tag); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := file.Seek(-128, io.SeekEnd); err == nil {
+		var v1 [128]byte
+		if _, err := io.ReadFull(file, v1[:]); err == nil && string(v1[:3]) == "TAG" {
+			md.merge(AudioMetadata{Title: id3v1Text(v1[3:33]), Artist: id3v1Text(v1[33:63]), Album: id3v1Text(v1[63:93])})
+		}
+	}
+	return tagged(md), nil
+}
+
+// id3v1Text decodes a fixed ID3v1 field: ISO-8859-1 padded with NULs or
+// spaces
+func id3v1Text(field []byte) string {
+	return id3Text(append([]byte{0}, field...))
+}
+
+// tagged returns md, or nil when it tags nothing
+func tagged(md AudioMetadata) *AudioMetadata {
+	if md.empty() {
+		return nil
+	}
+	return &md
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tenantHeader names the tenant of a request in -tenants service modes
+const tenantHeader = "X-Tenant"
+
+// Metrics by tenant, so one deployment serving several applications can
+// tell their load apart
+var (
+	tenantRequests  = newCounterVec("waveform_tenant_requests_total", "Requests and jobs by tenant.", "tenant")
+	tenantErrors    = newCounterVec("waveform_tenant_errors_total", "Failed requests and jobs by tenant.", "tenant")
+	tenantThrottled = newCounterVec("waveform_tenant_throttled_total", "Requests refused or jobs delayed by tenant for going over -tenant-rate.", "tenant")
+)
+
+// validateTenant checks a tenant identifier, which ends up in paths, cache
+// keys and metric labels: 1 to 64 lowercase letters, digits, - and _,
+// starting with a letter or digit
+func validateTenant(tenant string) error {
+	if tenant == "" {
+		return fmt.Errorf("no tenant given")
+	}
+	ok := len(tenant) <= 64 && tenant[0] != '-' && tenant[0] != '_'
+	for _, c := range tenant {
+		ok = ok && (c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_')
+	}
+	if !ok {
+		return fmt.Errorf("invalid tenant %q (want up to 64 lowercase letters, digits, - and _)", tenant)
+	}
+	return nil
+}
+
+// tenantLocation returns where the outputs of a tenant go under location,
+// a directory or storage URL
+func tenantLocation(location, tenant string) string {
+	if tenant == "" {
+		return location
+	}
+	return strings.TrimSuffix(location, "/") + "/" + tenant
+}
+
+// tenantFlags registers the flags of tenancy in service modes
+func tenantFlags(fs *flag.FlagSet) (tenants *bool, rate *float64, burst *int) {
+	tenants = fs.Bool("tenants", false, "serve several applications: every request or job must name its tenant, which namespaces its files, cache entries, rate limit and metrics")
+	rate = fs.Float64("tenant-rate", 0, "with -tenants, requests or jobs a second each tenant may make (unlimited when 0)")
+	burst = fs.Int("tenant-burst", 10, "with -tenant-rate, requests or jobs a tenant may make at once after being idle")
+	return tenants, rate, burst
+}
+
+// tenantLimiter returns the limiter the tenancy flags ask for, or nil
+func tenantLimiter(tenants bool, rate float64, burst int) (*RateLimiter, error) {
+	switch {
+	case rate < 0:
+		return nil, fmt.Errorf("-tenant-rate can't be negative")
+	case rate == 0:
+		return nil, nil
+	case !tenants:
+		return nil, fmt.Errorf("-tenant-rate requires -tenants")
+	}
+	return NewRateLimiter(rate, burst), nil
+}
+
+// tenantKey is the context key of a request's tenant
+type tenantKey struct{}
+
+// withTenant returns ctx carrying a tenant
+func withTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// tenantOf returns the tenant of a request, or "" without tenants
+func tenantOf(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// RateLimiter bounds the rate of requests or jobs of each tenant on its
+// own with a token bucket, so one application's burst doesn't starve the
+// others
+type RateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+// tokenBucket is the budget of one tenant
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter of rate a second per tenant, allowing
+// bursts of up to burst at once
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{rate: rate, burst: float64(max(burst, 1)), buckets: map[string]*tokenBucket{}, now: time.Now}
+}
+
+// reserve takes a token of tenant and returns how long to wait before
+// using it: 0 when one was available
+func (l *RateLimiter) reserve(tenant string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[tenant]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[tenant] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / l.rate * float64(time.Second))
+}
+
+// Allow reports whether a request of tenant may go ahead now; refused
+// requests take nothing from the budget. A nil limiter allows everything.
+func (l *RateLimiter) Allow(tenant string) bool {
+	if l == nil {
+		return true
+	}
+	if l.reserve(tenant) == 0 {
+		return true
+	}
+	l.mu.Lock()
+	l.buckets[tenant].tokens++
+	l.mu.Unlock()
+	return false
+}
+
+// Wait blocks until a job of tenant may go ahead, or ctx is done. A nil
+// limiter never waits.
+func (l *RateLimiter) Wait(ctx context.Context, tenant string) error {
+	if l == nil {
+		return nil
+	}
+	delay := l.reserve(tenant)
+	if delay == 0 {
+		return nil
+	}
+	tenantThrottled.inc(tenant)
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
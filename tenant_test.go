@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValidateTenant(t *testing.T) {
+	for _, tenant := range []string{"acme", "app-2", "a_b", "0"} {
+		if err := validateTenant(tenant); err != nil {
+			t.Errorf("%q: %v", tenant, err)
+		}
+	}
+	for _, tenant := range []string{"", "Acme", "../other", "a/b", "-x", "a b", string(make([]byte, 65))} {
+		if err := validateTenant(tenant); err == nil {
+			t.Errorf("%q accepted", tenant)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewRateLimiter(2, 3)
+	l.now = func() time.Time { return now }
+
+	for i := range 3 {
+		if !l.Allow("a") {
+			t.Fatalf("request %d of the burst refused", i+1)
+		}
+	}
+	if l.Allow("a") {
+		t.Error("request past the burst allowed")
+	}
+	if !l.Allow("b") {
+		t.Error("another tenant was limited too")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if !l.Allow("a") || l.Allow("a") {
+		t.Error("want one request after half a second at 2 a second")
+	}
+	if d := l.reserve("a"); d != 500*time.Millisecond {
+		t.Errorf("waits %v, want 500ms", d)
+	}
+}
+
+func TestServerTenants(t *testing.T) {
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, "acme"), 0o755)
+	if err := WriteTestAudioFile(filepath.Join(root, "acme", "take.wav"), DefaultTestAudio()); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Root: root, Defaults: DefaultRenderOptions(), Tenants: true, Limit: NewRateLimiter(0.001, 2)}
+	s.Defaults.Width, s.Defaults.Height = 100, 20
+	h := s.Handler(false)
+
+	get := func(tenant string) int {
+		req := httptest.NewRequest("GET", "/waveform/take.wav", nil)
+		if tenant != "" {
+			req.Header.Set(tenantHeader, tenant)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := get(""); code != http.StatusBadRequest {
+		t.Errorf("without a tenant: %d", code)
+	}
+	if code := get("acme"); code != http.StatusOK {
+		t.Errorf("acme: %d", code)
+	}
+	// Other tenants don't see acme's files
+	if code := get("other"); code != http.StatusNotFound {
+		t.Errorf("other: %d", code)
+	}
+	get("acme")
+	if code := get("acme"); code != http.StatusTooManyRequests {
+		t.Errorf("acme over its rate: %d", code)
+	}
+}
+
+func TestWorkerTenants(t *testing.T) {
+	input := writeFixture(t, DefaultTestAudio())
+	output := t.TempDir()
+
+	w := newTestWorker(t, nil, "-width", "100", "-height", "20")
+	w.tenants = true
+
+	body, _ := json.Marshal(Job{Input: input, Output: output, Tenant: "acme"})
+	job, result, err := w.process(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(output, "acme", "fixture.png")
+	if job.Output != output+"/acme" || len(result.Outputs) != 1 || result.Outputs[0] != want {
+		t.Errorf("output %s, outputs %v; want %s", job.Output, result.Outputs, want)
+	}
+
+	body, _ = json.Marshal(Job{Input: input, Output: output})
+	if _, _, err := w.process(body); err == nil {
+		t.Error("job without a tenant processed")
+	}
+}
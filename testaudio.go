@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// TestAudio describes a synthetic WAV file
+type TestAudio struct {
+	Waveform   string // sine, square, noise or sweep
+	SampleRate int
+	BitDepth   int // 8, 16, 24 or 32
+	Channels   int
+	Duration   time.Duration
+	Frequency  float64 // tone frequency, or sweep start frequency
+	EndFreq    float64 // sweep end frequency
+	Amplitude  float64 // peak amplitude in (0, 1]
+	Seed       int64   // noise seed, so output is reproducible
+}
+
+// DefaultTestAudio returns a 1 second 440 Hz stereo 16-bit sine at 44.1 kHz
+func DefaultTestAudio() TestAudio {
+	return TestAudio{
+		Waveform:   "sine",
+		SampleRate: 44100,
+		BitDepth:   16,
+		Channels:   2,
+		Duration:   time.Second,
+		Frequency:  440,
+		EndFreq:    8000,
+		Amplitude:  0.8,
+		Seed:       1,
+	}
+}
+
+// validate checks that the description can be written as a PCM WAV file
+func (t TestAudio) validate() error {
+	switch t.Waveform {
+	case "sine", "square", "noise", "sweep":
+	default:
+		return fmt.Errorf("unknown waveform %q", t.Waveform)
+	}
+
+	switch t.BitDepth {
+	case 8, 16, 24, 32:
+	default:
+		return fmt.Errorf("unsupported bit depth %d", t.BitDepth)
+	}
+
+	if t.SampleRate <= 0 {
+		return fmt.Errorf("invalid sample rate %d", t.SampleRate)
+	}
+
+	if t.Channels <= 0 || t.Channels > math.MaxUint16 {
+		return fmt.Errorf("invalid channel count %d", t.Channels)
+	}
+
+	if t.Duration < 0 {
+		return fmt.Errorf("invalid duration %v", t.Duration)
+	}
+
+	if t.Amplitude <= 0 || t.Amplitude > 1 {
+		return fmt.Errorf("amplitude must be in (0, 1], got %v", t.Amplitude)
+	}
+
+	return nil
+}
+
+// NumFrames returns how many frames the described file holds
+func (t TestAudio) NumFrames() int {
+	return int(t.Duration.Seconds() * float64(t.SampleRate))
+}
+
+// WriteTestAudio writes the described synthetic audio to w as a PCM WAV file
+func WriteTestAudio(w io.Writer, t TestAudio) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+
+	numFrames := t.NumFrames()
+	bytesPerSample := t.BitDepth / 8
+	blockAlign := t.Channels * bytesPerSample
+	dataSize := numFrames * blockAlign
+
+	header := WAVHeader{
+		ChunkID:       [4]byte{'R', 'I', 'F', 'F'},
+		ChunkSize:     uint32(36 + dataSize),
+		Format:        [4]byte{'W', 'A', 'V', 'E'},
+		SubChunk1ID:   [4]byte{'f', 'm', 't', ' '},
+		SubChunk1Size: 16,
+		AudioFormat:   1, // PCM
+		NumChannels:   uint16(t.Channels),
+		SampleRate:    uint32(t.SampleRate),
+		ByteRate:      uint32(t.SampleRate * blockAlign),
+		BlockAlign:    uint16(blockAlign),
+		BitsPerSample: uint16(t.BitDepth),
+		SubChunk2ID:   [4]byte{'d', 'a', 't', 'a'},
+		SubChunk2Size: uint32(dataSize),
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := binary.Write(bw, binary.LittleEndian, &header); err != nil {
+		return fmt.Errorf("failed to write WAV header: %w", err)
+	}
+
+	rng := rand.New(rand.NewSource(t.Seed))
+	duration := t.Duration.Seconds()
+	frame := make([]byte, blockAlign)
+
+	for i := 0; i < numFrames; i++ {
+		tm := float64(i) / float64(t.SampleRate)
+
+		var v float64
+		switch t.Waveform {
+		case "sine":
+			v = math.Sin(2 * math.Pi * t.Frequency * tm)
+		case "square":
+			if math.Sin(2*math.Pi*t.Frequency*tm) >= 0 {
+				v = 1
+			} else {
+				v = -1
+			}
+		case "sweep":
+			// Linear chirp from Frequency to EndFreq over the whole duration
+			phase := t.Frequency * tm
+			if duration > 0 {
+				phase += (t.EndFreq - t.Frequency) * tm * tm / (2 * duration)
+			}
+			v = math.Sin(2 * math.Pi * phase)
+		}
+
+		for ch := 0; ch < t.Channels; ch++ {
+			sample := v
+			if t.Waveform == "noise" {
+				sample = rng.Float64()*2 - 1
+			}
+			putPCMSample(frame[ch*bytesPerSample:], sample*t.Amplitude, t.BitDepth)
+		}
+
+		if _, err := bw.Write(frame); err != nil {
+			return fmt.Errorf("failed to write frame %d: %w", i, err)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// putPCMSample encodes a sample in [-1, 1] as little-endian PCM of the given bit depth
+func putPCMSample(b []byte, v float64, bitDepth int) {
+	switch bitDepth {
+	case 8:
+		b[0] = uint8(int(math.Round(v*127)) + 128) // 8-bit WAV is unsigned
+	case 16:
+		binary.LittleEndian.PutUint16(b, uint16(int16(math.Round(v*math.MaxInt16))))
+	case 24:
+		s := int32(math.Round(v * 8388607))
+		b[0] = byte(s)
+		b[1] = byte(s >> 8)
+		b[2] = byte(s >> 16)
+	case 32:
+		binary.LittleEndian.PutUint32(b, uint32(int32(math.Round(v*math.MaxInt32))))
+	}
+}
+
+// WriteTestAudioFile writes the described synthetic audio to the named file
+func WriteTestAudioFile(filename string, t TestAudio) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create WAV file: %w", err)
+	}
+	defer file.Close()
+
+	if err := WriteTestAudio(file, t); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// runGen implements the gen subcommand
+func runGen(args []string) error {
+	t := DefaultTestAudio()
+
+	fs := flag.NewFlagSet("gen", flag.ExitOnError)
+	fs.StringVar(&t.Waveform, "type", t.Waveform, "waveform: sine, square, noise or sweep")
+	fs.IntVar(&t.SampleRate, "rate", t.SampleRate, "sample rate in Hz")
+	fs.IntVar(&t.BitDepth, "bits", t.BitDepth, "bit depth: 8, 16, 24 or 32")
+	fs.IntVar(&t.Channels, "channels", t.Channels, "number of channels")
+	fs.DurationVar(&t.Duration, "duration", t.Duration, "duration, e.g. 5s or 1m30s")
+	fs.Float64Var(&t.Frequency, "freq", t.Frequency, "tone frequency (sweep start) in Hz")
+	fs.Float64Var(&t.EndFreq, "freq-end", t.EndFreq, "sweep end frequency in Hz")
+	fs.Float64Var(&t.Amplitude, "amplitude", t.Amplitude, "peak amplitude in (0, 1]")
+	fs.Int64Var(&t.Seed, "seed", t.Seed, "random seed for noise")
+	output := fs.String("o", "test.wav", "output WAV file")
+	fs.Parse(args)
+
+	if err := WriteTestAudioFile(*output, t); err != nil {
+		return err
+	}
+
+	fmt.Printf("Generated %s: %s, %d Hz, %d-bit, %d channels, %v\n",
+		*output, t.Waveform, t.SampleRate, t.BitDepth, t.Channels, t.Duration)
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeFixture writes synthetic audio into the test's temp directory and
+// returns its path
+func writeFixture(t *testing.T, audio TestAudio) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "fixture.wav")
+	if err := WriteTestAudioFile(file, audio); err != nil {
+		t.Fatalf("WriteTestAudioFile: %v", err)
+	}
+	return file
+}
+
+func TestWriteTestAudioSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*TestAudio)
+		frames int
+	}{
+		{"default", func(a *TestAudio) {}, 44100},
+		{"short", func(a *TestAudio) { a.Duration = 10 * time.Millisecond }, 441},
+		{"rate", func(a *TestAudio) { a.SampleRate = 8000 }, 8000},
+		{"empty", func(a *TestAudio) { a.Duration = 0 }, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audio := DefaultTestAudio()
+			tt.modify(&audio)
+
+			if got := audio.NumFrames(); got != tt.frames {
+				t.Fatalf("NumFrames = %d, want %d", got, tt.frames)
+			}
+
+			info, err := os.Stat(writeFixture(t, audio))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := int64(44 + tt.frames*audio.Channels*audio.BitDepth/8)
+			if info.Size() != want {
+				t.Errorf("file is %d bytes, want %d", info.Size(), want)
+			}
+		})
+	}
+}
+
+func TestWriteTestAudioInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*TestAudio)
+	}{
+		{"waveform", func(a *TestAudio) { a.Waveform = "triangle" }},
+		{"bit depth", func(a *TestAudio) { a.BitDepth = 12 }},
+		{"sample rate", func(a *TestAudio) { a.SampleRate = 0 }},
+		{"channels", func(a *TestAudio) { a.Channels = 0 }},
+		{"duration", func(a *TestAudio) { a.Duration = -time.Second }},
+		{"amplitude", func(a *TestAudio) { a.Amplitude = 1.5 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audio := DefaultTestAudio()
+			tt.modify(&audio)
+
+			err := WriteTestAudioFile(filepath.Join(t.TempDir(), "bad.wav"), audio)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultTierLength is the stretch of audio each zoom tier image covers
+const defaultTierLength = time.Hour
+
+// tierAnalyzer builds the peaks of the zoomed-in detail images of a long
+// file, one per length of audio, each with the width of the overview image
+// so it shows its stretch in that much more detail. Files no longer than
+// over get none.
+type tierAnalyzer struct {
+	over   time.Duration
+	length time.Duration
+	width  int
+	agg    *Aggregation
+
+	framesPerTier int
+	builders      []*PeakBuilder
+	pos           int // frames consumed so far
+}
+
+func newTierAnalyzer(opts Options) *tierAnalyzer {
+	return &tierAnalyzer{over: opts.TiersOver, length: opts.TierLength, width: opts.imageWidth(), agg: opts.Aggregation}
+}
+
+// Start implements Analyzer
+func (a *tierAnalyzer) Start(info StreamInfo) {
+	a.builders, a.pos = nil, 0
+	if info.SampleRate == 0 || framesToSeconds(info.NumFrames, info.SampleRate) <= a.over.Seconds() {
+		return
+	}
+
+	a.framesPerTier = max(1, int(a.length.Seconds()*float64(info.SampleRate)))
+	tiers := (info.NumFrames + a.framesPerTier - 1) / a.framesPerTier
+	for range tiers {
+		b := newFittedPeakBuilder(a.framesPerTier, a.width)
+		b.agg = a.agg
+		a.builders = append(a.builders, b)
+	}
+}
+
+// Add implements Analyzer, feeding the left channel to the tier each frame
+// falls in
+func (a *tierAnalyzer) Add(left, right []int16) {
+	for len(left) > 0 && len(a.builders) > 0 {
+		tier := a.pos / a.framesPerTier
+		if tier >= len(a.builders) {
+			return
+		}
+		n := min(len(left), (tier+1)*a.framesPerTier-a.pos)
+		a.builders[tier].AddInt16(left[:n])
+		left = left[n:]
+		a.pos += n
+	}
+}
+
+// Result implements Analyzer, returning the ChannelPeaks of every tier. A
+// last tier covering less than the full length is padded with silence, so
+// every tier shows the same time scale.
+func (a *tierAnalyzer) Result() any {
+	tiers := make([]ChannelPeaks, len(a.builders))
+	for i, b := range a.builders {
+		tiers[i] = b.Peaks()
+	}
+	return tiers
+}
+
+// memoryEstimate implements memoryUser: the buckets of every tier
+func (a *tierAnalyzer) memoryEstimate(info StreamInfo) int64 {
+	if info.SampleRate == 0 || framesToSeconds(info.NumFrames, info.SampleRate) <= a.over.Seconds() {
+		return 0
+	}
+	framesPerTier := max(1, int64(a.length.Seconds()*float64(info.SampleRate)))
+	tiers := (int64(info.NumFrames) + framesPerTier - 1) / framesPerTier
+	return tiers * int64(a.width) * 4
+}
+
+// tierFileName returns the name of the n-th zoom tier image, counted from 1
+func tierFileName(baseName string, n int) string {
+	return fmt.Sprintf("%s.zoom-%03d.png", baseName, n)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTierAnalyzer(t *testing.T) {
+	a := newTierAnalyzer(Options{Width: 10, TiersOver: 2 * time.Second, TierLength: time.Second})
+
+	a.Start(StreamInfo{SampleRate: 20, NumFrames: 40})
+	if tiers := a.Result().([]ChannelPeaks); len(tiers) != 0 {
+		t.Errorf("%d tiers of a file no longer than -tiers-over", len(tiers))
+	}
+
+	// 2.5 seconds of 20 frames each give two full tiers and half of a third
+	a.Start(StreamInfo{SampleRate: 20, NumFrames: 50})
+	left := make([]int16, 50)
+	for i := range left {
+		left[i] = int16(i + 1)
+	}
+	a.Add(left[:7], nil)
+	a.Add(left[7:], nil)
+
+	tiers := a.Result().([]ChannelPeaks)
+	if len(tiers) != 3 {
+		t.Fatalf("%d tiers, want 3", len(tiers))
+	}
+	if tiers[0].Max[0] != 2 || tiers[0].Max[9] != 20 || tiers[1].Min[0] != 21 || tiers[1].Max[9] != 40 {
+		t.Errorf("tiers 1 and 2 = %v, %v", tiers[0], tiers[1])
+	}
+	if tiers[2].Max[4] != 50 || tiers[2].Max[5] != 0 {
+		t.Errorf("tier 3 = %v, want its second half silent", tiers[2])
+	}
+	if got := a.memoryEstimate(StreamInfo{SampleRate: 20, NumFrames: 50}); got != 3*10*4 {
+		t.Errorf("memory estimate = %d", got)
+	}
+}
+
+func TestGenerateTiers(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "lecture.wav")
+	audio := DefaultTestAudio()
+	audio.Duration = 3 * time.Second
+	if err := WriteTestAudioFile(input, audio); err != nil {
+		t.Fatal(err)
+	}
+
+	output := t.TempDir()
+	opts := Options{Width: 100, Height: 50, TiersOver: 2 * time.Second, TierLength: time.Second}
+	result, err := GenerateStereoWaveforms(batchInput{Name: "lecture.wav", Path: input, Location: input}, output, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Outputs) != 4 {
+		t.Errorf("outputs = %q, want the overview and 3 tiers", result.Outputs)
+	}
+	for _, name := range []string{"lecture.png", "lecture.zoom-001.png", "lecture.zoom-003.png"} {
+		if _, err := os.Stat(filepath.Join(output, name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// transcriptStripHeight is the height of the transcript strip in pixels
+const transcriptStripHeight = 16
+
+// transcriptWordColor fills the words of the transcript strip, which are
+// separated by sentence boundaries in waveformColor
+var transcriptWordColor = color.RGBA{150, 150, 150, 255}
+
+// TranscriptSegment is a sentence or cue of a transcript, in seconds from
+// the start of the file, with the words in it when their times are known
+type TranscriptSegment struct {
+	Start, End float64
+	Words      []TranscriptWord
+}
+
+// TranscriptWord is a timed word of a transcript
+type TranscriptWord struct {
+	Start, End float64
+	Text       string
+}
+
+// loadInputTranscript returns the strip of -transcript for an input, or
+// nil when it has no transcript, as loadInputAnnotations does markers
+func loadInputTranscript(input batchInput, opts Options) *transcriptStrip {
+	filename := sidecarFile(opts.Transcript, input)
+	segments, err := loadTranscript(filename)
+	switch {
+	case missingSidecar(err, opts.Transcript, filename):
+		return nil
+	case err != nil:
+		fmt.Printf("Warning: failed to read transcript: %v  %v\n", input.Location, err)
+		return nil
+	}
+	return &transcriptStrip{segments: segments, offset: (opts.Start + input.Offset).Seconds()}
+}
+
+// loadTranscript reads a transcript by its extension: .vtt for WebVTT,
+// with word times from karaoke-style <00:00:01.500> tags, .srt for SubRip
+// and anything else as JSON
+func loadTranscript(filename string) ([]TranscriptSegment, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var segments []TranscriptSegment
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".vtt", ".srt":
+		segments, err = parseCues(file)
+	default:
+		segments, err = parseJSONTranscript(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return segments, nil
+}
+
+// parseJSONTranscript parses a JSON transcript: an object with "segments"
+// of "start", "end" and optionally "words", as speech recognizers such as
+// Whisper write them, or a list of words on their own. Words have a
+// "start", an "end" and their "word" or "text".
+func parseJSONTranscript(r io.Reader) ([]TranscriptSegment, error) {
+	type word struct {
+		Start float64 `json:"start"`
+		End   float64 `json:"end"`
+		Word  string  `json:"word"`
+		Text  string  `json:"text"`
+	}
+	type segment struct {
+		Start float64 `json:"start"`
+		End   float64 `json:"end"`
+		Words []word  `json:"words"`
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		Segments *[]segment `json:"segments"`
+	}
+	var segments []segment
+	if json.Unmarshal(data, &doc) == nil && doc.Segments != nil {
+		segments = *doc.Segments
+	} else {
+		// A list of words is one segment
+		var words []word
+		if err := json.Unmarshal(data, &words); err != nil {
+			return nil, fmt.Errorf("want an object of segments or a list of words: %w", err)
+		}
+		if len(words) > 0 {
+			segments = []segment{{Start: words[0].Start, End: words[len(words)-1].End, Words: words}}
+		}
+	}
+
+	transcript := make([]TranscriptSegment, 0, len(segments))
+	for _, s := range segments {
+		ts := TranscriptSegment{Start: s.Start, End: s.End}
+		for _, w := range s.Words {
+			text := w.Word
+			if text == "" {
+				text = w.Text
+			}
+			ts.Words = append(ts.Words, TranscriptWord{Start: w.Start, End: w.End, Text: strings.TrimSpace(text)})
+		}
+		transcript = append(transcript, ts)
+	}
+	return transcript, nil
+}
+
+// parseCues parses the cues of a WebVTT or SubRip file, each a segment.
+// Words are only known in cues with inline timestamps, each of which
+// starts a word running to the next one or the end of the cue.
+func parseCues(r io.Reader) ([]TranscriptSegment, error) {
+	var segments []TranscriptSegment
+	var cue *TranscriptSegment
+	var text []string
+	endCue := func() error {
+		if cue != nil {
+			words, err := cueWords(*cue, strings.Join(text, " "))
+			if err != nil {
+				return err
+			}
+			cue.Words = words
+			segments = append(segments, *cue)
+		}
+		cue, text = nil, nil
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			if err := endCue(); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+		case strings.Contains(line, "-->"):
+			if err := endCue(); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			start, rest, _ := strings.Cut(line, "-->")
+			// Cue settings follow the end time
+			end, _, _ := strings.Cut(strings.TrimSpace(rest), " ")
+			s, err := parseCueTime(start)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			e, err := parseCueTime(end)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			cue = &TranscriptSegment{Start: s, End: e}
+		case cue != nil:
+			text = append(text, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := endCue(); err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
+
+// cueWords returns the words of a cue's text timed by inline timestamps
+func cueWords(cue TranscriptSegment, text string) ([]TranscriptWord, error) {
+	var words []TranscriptWord
+	for {
+		open := strings.Index(text, "<")
+		if open < 0 {
+			break
+		}
+		end := strings.Index(text[open:], ">")
+		if end < 0 {
+			break
+		}
+		tag := text[open+1 : open+end]
+		text = text[open+end+1:]
+		// Other tags, such as <c> and <v Speaker>, style the text
+		if tag == "" || tag[0] < '0' || tag[0] > '9' {
+			continue
+		}
+		t, err := parseCueTime(tag)
+		if err != nil {
+			return nil, err
+		}
+		if len(words) > 0 {
+			words[len(words)-1].End = t
+		}
+		words = append(words, TranscriptWord{Start: t, End: cue.End})
+	}
+	return words, nil
+}
+
+// parseCueTime parses a WebVTT or SubRip timestamp, whose fractions of a
+// second follow a comma in SubRip
+func parseCueTime(s string) (float64, error) {
+	return parseTimestamp(strings.ReplaceAll(strings.TrimSpace(s), ",", "."))
+}
+
+// transcriptStrip draws a transcript in a strip below the waveform: its
+// words as blocks and the boundaries of its sentences as lines, so speech
+// lines up with the energy above it
+type transcriptStrip struct {
+	segments []TranscriptSegment
+
+	// offset and span are the seconds of the file the image starts at and
+	// covers, set once the peaks are loaded
+	offset, span float64
+}
+
+// stripHeight implements imageStrip
+func (s *transcriptStrip) stripHeight() int {
+	return transcriptStripHeight
+}
+
+// drawStrip implements imageStrip. Segments without timed words are drawn
+// as one block.
+func (s *transcriptStrip) drawStrip(img *image.RGBA, band image.Rectangle) {
+	if s.span <= 0 {
+		return
+	}
+	width := band.Dx()
+	column := func(t float64) int {
+		return int((t - s.offset) / s.span * float64(width))
+	}
+	fill := func(start, end float64, y0, y1 int, c color.RGBA) {
+		x0, x1 := column(start), column(end)
+		if x1 < 0 || x0 >= width {
+			return
+		}
+		// Short words still get a column
+		x0 = max(x0, 0)
+		x1 = min(max(x1, x0+1), width)
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				img.SetRGBA(band.Min.X+x, y, c)
+			}
+		}
+	}
+
+	wordTop, wordBottom := band.Min.Y+band.Dy()/4, band.Max.Y-band.Dy()/4
+	for _, segment := range s.segments {
+		if len(segment.Words) == 0 {
+			fill(segment.Start, segment.End, wordTop, wordBottom, transcriptWordColor)
+		}
+		for _, w := range segment.Words {
+			fill(w.Start, w.End, wordTop, wordBottom, transcriptWordColor)
+		}
+	}
+	// Boundaries go last so words don't cover them
+	for _, segment := range s.segments {
+		fill(segment.Start, segment.Start, band.Min.Y, band.Max.Y, waveformColor)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseTranscript(t *testing.T) {
+	tests := []struct {
+		file, content string
+		want          []TranscriptSegment
+	}{
+		{"talk.vtt", "WEBVTT\n\nNOTE made by hand\n\n1\n00:00:01.000 --> 00:00:03.000 align:start\n<v Host><00:00:01.000><c>Hello</c><00:00:01.500><c> there</c>\n\n00:01:00.000 --> 00:01:02.500\nNo word times\n",
+			[]TranscriptSegment{
+				{Start: 1, End: 3, Words: []TranscriptWord{{Start: 1, End: 1.5}, {Start: 1.5, End: 3}}},
+				{Start: 60, End: 62.5},
+			}},
+		{"talk.srt", "1\n00:00:00,500 --> 00:00:02,000\nHi\n",
+			[]TranscriptSegment{{Start: 0.5, End: 2}}},
+		{"talk.json", `{"segments": [{"start": 0, "end": 2, "text": "Hi you", "words": [{"start": 0, "end": 0.4, "word": " Hi"}, {"start": 0.5, "end": 2, "word": " you"}]}]}`,
+			[]TranscriptSegment{{Start: 0, End: 2, Words: []TranscriptWord{{Start: 0, End: 0.4, Text: "Hi"}, {Start: 0.5, End: 2, Text: "you"}}}}},
+		{"words.json", `[{"start": 1, "end": 1.2, "text": "a"}, {"start": 1.3, "end": 2, "text": "b"}]`,
+			[]TranscriptSegment{{Start: 1, End: 2, Words: []TranscriptWord{{Start: 1, End: 1.2, Text: "a"}, {Start: 1.3, End: 2, Text: "b"}}}}},
+	}
+	for _, tt := range tests {
+		file := filepath.Join(t.TempDir(), tt.file)
+		os.WriteFile(file, []byte(tt.content), 0o644)
+		got, err := loadTranscript(file)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, %v; want %+v", tt.file, got, err, tt.want)
+		}
+	}
+
+	for file, content := range map[string]string{
+		"bad.vtt":  "WEBVTT\n\n1.2.3 --> 00:02.000\nx\n",
+		"bad.json": `{"text": "no times"}`,
+	} {
+		path := filepath.Join(t.TempDir(), file)
+		os.WriteFile(path, []byte(content), 0o644)
+		if _, err := loadTranscript(path); err == nil {
+			t.Errorf("%s parsed", file)
+		}
+	}
+}
+
+func TestTranscriptStrip(t *testing.T) {
+	s := &transcriptStrip{
+		segments: []TranscriptSegment{
+			{Start: 2, End: 6, Words: []TranscriptWord{{Start: 2, End: 3}, {Start: 4, End: 6}}},
+			{Start: 8, End: 9},
+			{Start: -5, End: -1},
+		},
+		span: 10,
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 100, 40))
+	band := image.Rect(0, 20, 100, 20+s.stripHeight())
+	s.drawStrip(img, band)
+
+	mid := band.Min.Y + band.Dy()/2
+	if img.RGBAAt(20, band.Min.Y) != waveformColor || img.RGBAAt(80, band.Min.Y) != waveformColor {
+		t.Error("sentence boundaries not drawn full height")
+	}
+	if img.RGBAAt(25, mid) != transcriptWordColor || img.RGBAAt(50, mid) != transcriptWordColor || img.RGBAAt(85, mid) != transcriptWordColor {
+		t.Error("words not drawn")
+	}
+	if c := img.RGBAAt(35, mid); c.A != 0 {
+		t.Errorf("the gap between words is filled: %v", c)
+	}
+	if c := img.RGBAAt(0, mid); c.A != 0 {
+		t.Errorf("a segment before the image is drawn: %v", c)
+	}
+}
+
+func TestTranscriptRender(t *testing.T) {
+	input := writeFixture(t, DefaultTestAudio())
+	transcript := filepath.Join(t.TempDir(), "fixture.vtt")
+	os.WriteFile(transcript, []byte("WEBVTT\n\n00:00.250 --> 00:00.750\nHi\n"), 0o644)
+
+	opts := Options{Width: 100, Height: 20, Transcript: transcript}
+	in := batchInput{Name: "fixture.wav", Path: input, Location: input}
+	result, err := GenerateStereoWaveforms(in, t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(result.Outputs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	config, err := png.DecodeConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 20 + transcriptStripHeight; config.Height != want {
+		t.Errorf("height %d, want %d with the strip", config.Height, want)
+	}
+}
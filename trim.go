@@ -0,0 +1,94 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// markerColor draws marker lines over the waveform
+var markerColor = color.RGBA{0, 120, 255, 255}
+
+// TrimReport is the "trim" section of a file report. TrimIn and TrimOut are
+// the suggested edit points around the audible content, in seconds.
+type TrimReport struct {
+	ThresholdDB float64  `json:"threshold_db"`
+	TrimIn      *float64 `json:"trim_in"`
+	TrimOut     *float64 `json:"trim_out"`
+}
+
+// trimAnalyzer finds the first and last samples above the silence threshold
+type trimAnalyzer struct {
+	cfg       AnalysisConfig
+	threshold int32
+	info      StreamInfo
+
+	pos   int
+	first int // first loud frame, or -1
+	last  int // frame after the last loud frame
+}
+
+func newTrimAnalyzer(cfg AnalysisConfig) Analyzer {
+	return &trimAnalyzer{
+		cfg:       cfg,
+		threshold: int32(dbToAmplitude(cfg.SilenceThresholdDB)),
+		first:     -1,
+	}
+}
+
+// Start implements Analyzer
+func (a *trimAnalyzer) Start(info StreamInfo) {
+	a.info = info
+}
+
+// Add implements Analyzer
+func (a *trimAnalyzer) Add(left, right []int16) {
+	for i := range left {
+		loud := abs16(left[i]) >= a.threshold
+		if right != nil && abs16(right[i]) >= a.threshold {
+			loud = true
+		}
+
+		if loud {
+			if a.first < 0 {
+				a.first = a.pos + i
+			}
+			a.last = a.pos + i + 1
+		}
+	}
+	a.pos += len(left)
+}
+
+// Result implements Analyzer
+func (a *trimAnalyzer) Result() any {
+	report := TrimReport{ThresholdDB: a.cfg.SilenceThresholdDB}
+
+	// A file that never rises above the threshold has nothing to keep
+	if a.first >= 0 {
+		trimIn := framesToSeconds(a.first, a.info.SampleRate)
+		trimOut := framesToSeconds(a.last, a.info.SampleRate)
+		report.TrimIn, report.TrimOut = &trimIn, &trimOut
+	}
+
+	return report
+}
+
+// drawOverlay implements imageOverlay, marking the trim points
+func (a *trimAnalyzer) drawOverlay(img *image.RGBA) {
+	if a.first < 0 || a.pos == 0 {
+		return
+	}
+
+	width := img.Bounds().Dx()
+	for _, frame := range []int{a.first, a.last} {
+		x := min(frame*width/a.pos, width-1)
+		drawMarker(img, x)
+	}
+}
+
+// drawMarker draws a full height marker line at column x
+func drawMarker(img *image.RGBA, x int) {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		img.SetRGBA(bounds.Min.X+x, y, markerColor)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Exit codes of the validate subcommand
+const (
+	validateOK          = 0 // every file is consistent
+	validateDiscrepancy = 1 // at least one file has discrepancies
+	validateUnreadable  = 2 // at least one file couldn't be checked at all
+)
+
+// ValidationResult is the outcome of checking one file. Sizes are in bytes
+// and durations in seconds; Formatted has the file size and decoded
+// duration as the text output shows them.
+type ValidationResult struct {
+	File             string   `json:"file"`
+	Valid            bool     `json:"valid"`
+	Error            string   `json:"error,omitempty"`
+	FileSize         int64    `json:"file_size"`
+	DeclaredRIFFSize int64    `json:"declared_riff_size"`
+	DeclaredDataSize int64    `json:"declared_data_size"`
+	ActualDataSize   int64    `json:"actual_data_size"`
+	DeclaredDuration float64  `json:"declared_duration"`
+	DecodedDuration  float64  `json:"decoded_duration"`
+	Issues           []string `json:"issues"`
+
+	Formatted FormattedFields `json:"formatted"`
+}
+
+// validateWAV cross-checks the header of a WAV file against its size and
+// the frames that can actually be read. Problems with the file itself are
+// reported as issues; the error is only set when it can't be read.
+func validateWAV(filename string) ValidationResult {
+	result := ValidationResult{File: filename, Issues: []string{}}
+
+	fail := func(err error) ValidationResult {
+		result.Error = err.Error()
+		return result
+	}
+	issue := func(format string, args ...any) {
+		result.Issues = append(result.Issues, fmt.Sprintf(format, args...))
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return fail(fmt.Errorf("failed to open file: %w", err))
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fail(fmt.Errorf("failed to get file info: %w", err))
+	}
+	result.FileSize = info.Size()
+
+	header, layout, err := readWAVHeader(file)
+	if err != nil {
+		return fail(fmt.Errorf("failed to read WAV header: %w", err))
+	}
+	if string(header.ChunkID[:]) != "RIFF" || string(header.Format[:]) != "WAVE" {
+		return fail(fmt.Errorf("not a valid WAV file"))
+	}
+
+	headerSize := layout.dataOffset
+	result.DeclaredRIFFSize = int64(header.ChunkSize) + 8
+	result.DeclaredDataSize = int64(header.SubChunk2Size)
+
+	if result.DeclaredRIFFSize != result.FileSize {
+		issue("RIFF size says %d bytes but the file is %d bytes", result.DeclaredRIFFSize, result.FileSize)
+	}
+
+	blockAlign := int64(header.NumChannels) * int64(header.BitsPerSample/8)
+	if blockAlign == 0 || header.SampleRate == 0 {
+		issue("format declares %d channels of %d bits at %d Hz", header.NumChannels, header.BitsPerSample, header.SampleRate)
+		return result
+	}
+	if int64(header.BlockAlign) != blockAlign {
+		issue("BlockAlign is %d but %d channels of %d bits need %d", header.BlockAlign, header.NumChannels, header.BitsPerSample, blockAlign)
+	}
+	if byteRate := int64(header.SampleRate) * blockAlign; int64(header.ByteRate) != byteRate {
+		issue("ByteRate is %d but should be %d", header.ByteRate, byteRate)
+	}
+
+	// Read the data to find out how much of it is really there
+	actual, err := io.Copy(io.Discard, io.LimitReader(file, max(result.DeclaredDataSize, result.FileSize-headerSize)))
+	if err != nil {
+		return fail(fmt.Errorf("failed to read samples: %w", err))
+	}
+	result.ActualDataSize = actual
+
+	switch {
+	case result.DeclaredDataSize == 0:
+		issue("data size is 0 in the header but %d bytes follow it", actual)
+	case result.DeclaredDataSize > actual:
+		issue("data size says %d bytes but only %d are present (truncated)", result.DeclaredDataSize, actual)
+	case result.DeclaredDataSize < actual:
+		issue("%d bytes follow the declared %d bytes of data", actual-result.DeclaredDataSize, result.DeclaredDataSize)
+	}
+	if result.DeclaredDataSize%blockAlign != 0 {
+		issue("data size %d is not a whole number of %d-byte frames", result.DeclaredDataSize, blockAlign)
+	}
+
+	rate := header.SampleRate
+	result.DeclaredDuration = framesToSeconds(int(result.DeclaredDataSize/blockAlign), rate)
+	result.DecodedDuration = framesToSeconds(int(min(actual, result.DeclaredDataSize)/blockAlign), rate)
+	if result.DeclaredDataSize == 0 {
+		result.DecodedDuration = framesToSeconds(int(actual/blockAlign), rate)
+	}
+	if result.DeclaredDataSize != 0 && result.DecodedDuration != result.DeclaredDuration {
+		issue("header declares %.3f s but %.3f s can be decoded", result.DeclaredDuration, result.DecodedDuration)
+	}
+
+	result.Valid = len(result.Issues) == 0
+	result.Formatted = FormattedFields{Duration: formatSeconds(result.DecodedDuration), FileSize: formatSize(result.FileSize)}
+	return result
+}
+
+// validationInputs expands directories in args to the WAV files they hold
+func validationInputs(args []string) ([]string, error) {
+	var inputs []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			inputs = append(inputs, arg)
+			continue
+		}
+
+		entries, err := os.ReadDir(arg)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if !e.IsDir() && strings.HasSuffix(strings.ToLower(e.Name()), ".wav") {
+				inputs = append(inputs, filepath.Join(arg, e.Name()))
+			}
+		}
+	}
+	return inputs, nil
+}
+
+// runValidate implements the validate subcommand and returns its exit code
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print results as a JSON array")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: only_waveform validate [-json] file-or-dir...\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return validateUnreadable
+	}
+
+	inputs, err := validationInputs(fs.Args())
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return validateUnreadable
+	}
+
+	code := validateOK
+	results := make([]ValidationResult, 0, len(inputs))
+	for _, input := range inputs {
+		result := validateWAV(input)
+		results = append(results, result)
+
+		switch {
+		case result.Error != "":
+			code = validateUnreadable
+		case !result.Valid && code == validateOK:
+			code = validateDiscrepancy
+		}
+	}
+
+	if *asJSON {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return validateUnreadable
+		}
+		fmt.Println(string(data))
+		return code
+	}
+
+	for _, r := range results {
+		switch {
+		case r.Error != "":
+			fmt.Printf("ERROR %s: %s\n", r.File, r.Error)
+		case r.Valid:
+			fmt.Printf("ok    %s (%s, %s)\n", r.File, r.Formatted.Duration, r.Formatted.FileSize)
+		default:
+			fmt.Printf("FAIL  %s\n", r.File)
+			for _, issue := range r.Issues {
+				fmt.Printf("        %s\n", issue)
+			}
+		}
+	}
+
+	return code
+}
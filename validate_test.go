@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validationFixture writes a 100 ms fixture into dir under name, changed by
+// modify, and returns its path
+func validationFixture(t *testing.T, dir, name string, modify func(data []byte) []byte) string {
+	t.Helper()
+
+	file := filepath.Join(dir, name)
+	audio := DefaultTestAudio()
+	audio.Duration = 100 * time.Millisecond
+	if err := WriteTestAudioFile(file, audio); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, modify(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// runValidateJSON runs validate -json on files and returns its exit code
+// and the results it printed
+func runValidateJSON(t *testing.T, files ...string) (int, []map[string]any) {
+	t.Helper()
+
+	capture, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = capture
+	code := runValidate(append([]string{"-json"}, files...))
+	os.Stdout = stdout
+	capture.Close()
+
+	data, _ := os.ReadFile(capture.Name())
+	var results []map[string]any
+	if err := json.Unmarshal(data, &results); err != nil {
+		t.Fatalf("output is not a JSON array: %v\n%s", err, data)
+	}
+	return code, results
+}
+
+func TestValidateWAV(t *testing.T) {
+	dir := t.TempDir()
+	unchanged := func(data []byte) []byte { return data }
+	tests := []struct {
+		name   string
+		modify func(data []byte) []byte
+		issues []string
+		error  string
+	}{
+		{"valid", unchanged, nil, ""},
+		{"truncated data", func(data []byte) []byte { return data[:len(data)-400] }, []string{
+			"RIFF size says 17684 bytes but the file is 17284 bytes",
+			"data size says 17640 bytes but only 17240 are present (truncated)",
+			"header declares 0.100 s but 0.098 s can be decoded",
+		}, ""},
+		{"oversized RIFF size", func(data []byte) []byte {
+			binary.LittleEndian.PutUint32(data[4:], 1<<30)
+			return data
+		}, []string{"RIFF size says 1073741832 bytes but the file is 17684 bytes"}, ""},
+		{"unreadable", func(data []byte) []byte { return []byte("not audio") }, nil, "failed to read WAV header"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := validateWAV(validationFixture(t, dir, strings.ReplaceAll(tt.name, " ", "-")+".wav", tt.modify))
+			if !strings.HasPrefix(result.Error, tt.error) || (tt.error == "") != (result.Error == "") {
+				t.Errorf("error = %q, want %q", result.Error, tt.error)
+			}
+			if strings.Join(result.Issues, "\n") != strings.Join(tt.issues, "\n") {
+				t.Errorf("issues = %q, want %q", result.Issues, tt.issues)
+			}
+			if result.Valid != (tt.error == "" && tt.issues == nil) {
+				t.Errorf("valid = %v", result.Valid)
+			}
+		})
+	}
+}
+
+func TestRunValidate(t *testing.T) {
+	dir := t.TempDir()
+	valid := validationFixture(t, dir, "valid.wav", func(data []byte) []byte { return data })
+	truncated := validationFixture(t, dir, "truncated.wav", func(data []byte) []byte { return data[:len(data)-400] })
+	unreadable := filepath.Join(dir, "unreadable.wav")
+	if err := os.WriteFile(unreadable, []byte("not audio"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The worst file decides the exit code
+	for _, tt := range []struct {
+		files []string
+		code  int
+	}{
+		{[]string{valid}, validateOK},
+		{[]string{valid, truncated}, validateDiscrepancy},
+		{[]string{truncated, unreadable, valid}, validateUnreadable},
+	} {
+		code, results := runValidateJSON(t, tt.files...)
+		if code != tt.code {
+			t.Errorf("validate %v exited %d, want %d", tt.files, code, tt.code)
+		}
+		if len(results) != len(tt.files) {
+			t.Errorf("validate %v printed %d results", tt.files, len(results))
+		}
+	}
+
+	// Every result has the same fields, issues as an array even when empty
+	_, results := runValidateJSON(t, valid, unreadable)
+	for _, r := range results {
+		for _, key := range []string{"file", "valid", "file_size", "declared_riff_size", "declared_data_size", "actual_data_size", "declared_duration", "decoded_duration"} {
+			if _, ok := r[key]; !ok {
+				t.Errorf("result for %v has no %q", r["file"], key)
+			}
+		}
+		if issues, ok := r["issues"].([]any); !ok || len(issues) != 0 {
+			t.Errorf("issues of %v = %#v, want []", r["file"], r["issues"])
+		}
+	}
+	if results[0]["valid"] != true || results[0]["error"] != nil {
+		t.Errorf("valid file reported as %v", results[0])
+	}
+	if results[1]["valid"] != false || !strings.HasPrefix(results[1]["error"].(string), "failed to read WAV header") {
+		t.Errorf("unreadable file reported as %v", results[1])
+	}
+
+	// Nothing to check
+	code, results := runValidateJSON(t, t.TempDir())
+	if code != validateOK || len(results) != 0 {
+		t.Errorf("empty directory exited %d with %v", code, results)
+	}
+}
@@ -0,0 +1,127 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image/png"
+	"net/url"
+	"syscall/js"
+)
+
+func init() {
+	jsMain = serveJS
+}
+
+// serveJS exposes the renderer to JavaScript as globalThis.onlyWaveform and
+// keeps the program running for its calls. Both functions take the bytes of
+// a WAV file as a Uint8Array and an optional object of the query parameters
+// of the server's endpoints, and return a Promise:
+//
+//	onlyWaveform.render(data, {width: 800, style: "bars"})  // PNG as a Uint8Array
+//	onlyWaveform.peaks(data, {samples_per_pixel: 256})      // peaks.js JSON
+func serveJS() {
+	api := js.Global().Get("Object").New()
+	api.Set("render", jsFunc(jsRender))
+	api.Set("peaks", jsFunc(jsPeaks))
+	js.Global().Set("onlyWaveform", api)
+
+	select {}
+}
+
+// jsRender renders the waveform of the left channel as GET /waveform does
+func jsRender(data []byte, q url.Values) (any, error) {
+	defaults := DefaultRenderOptions()
+	defaults.Width, defaults.Height = 1920, 640
+	ro, err := parseRenderQuery(q, defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := openWAVData(data, "input.wav", true)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	peaks, _, err := decodeWAVPeaks(r, Options{Width: ro.Width, Height: ro.Height}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := drawPeaks(peaks.Channels[0], ro, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer putImage(img)
+
+	var buf bytes.Buffer
+	if err := encodePNG(&buf, img, png.DefaultCompression); err != nil {
+		return nil, err
+	}
+
+	out := js.Global().Get("Uint8Array").New(buf.Len())
+	js.CopyBytesToJS(out, buf.Bytes())
+	return out, nil
+}
+
+// jsPeaks returns the peaks of the left channel as GET /peaks does
+func jsPeaks(data []byte, q url.Values) (any, error) {
+	opts, format, err := parsePeaksQuery(q, 1920)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := openWAVData(data, "input.wav", true)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	analyzers := format.analyzers(opts)
+	peaks, _, err := decodeWAVPeaks(r, opts, analyzers)
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := json.Marshal(format.export(peaks, analyzers))
+	if err != nil {
+		return nil, err
+	}
+	return js.Global().Get("JSON").Call("parse", string(text)), nil
+}
+
+// jsFunc wraps a function of WAV data and options as a JavaScript function
+// returning a Promise, rejected with an Error when fn fails
+func jsFunc(fn func(data []byte, q url.Values) (any, error)) js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) any {
+		result, err := callJS(fn, args)
+		if err != nil {
+			return js.Global().Get("Promise").Call("reject", js.Global().Get("Error").New(err.Error()))
+		}
+		return js.Global().Get("Promise").Call("resolve", result)
+	})
+}
+
+func callJS(fn func(data []byte, q url.Values) (any, error), args []js.Value) (any, error) {
+	if len(args) == 0 || !args[0].InstanceOf(js.Global().Get("Uint8Array")) {
+		return nil, errors.New("WAV data must be a Uint8Array")
+	}
+	data := make([]byte, args[0].Length())
+	js.CopyBytesToGo(data, args[0])
+
+	// Options are read as the query parameters they stand for, so numbers
+	// and booleans are converted to their text
+	q := url.Values{}
+	if len(args) > 1 && args[1].Type() == js.TypeObject {
+		keys := js.Global().Get("Object").Call("keys", args[1])
+		for i := range keys.Length() {
+			key := keys.Index(i).String()
+			q.Set(key, js.Global().Get("String").Invoke(args[1].Get(key)).String())
+		}
+	}
+
+	return fn(data, q)
+}
@@ -0,0 +1,635 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// decodeBlockSize is the number of bytes read from the data chunk at a time
+const decodeBlockSize = 1 << 20
+
+// verbose prints the header details of every file as it is opened (-verbose)
+var verbose bool
+
+// debugf prints when -verbose is set
+func debugf(format string, args ...any) {
+	if verbose {
+		fmt.Printf(format, args...)
+	}
+}
+
+// WAVHeader represents the header of a WAV file
+type WAVHeader struct {
+	ChunkID       [4]byte
+	ChunkSize     uint32
+	Format        [4]byte
+	SubChunk1ID   [4]byte
+	SubChunk1Size uint32
+	AudioFormat   uint16
+	NumChannels   uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+	SubChunk2ID   [4]byte
+	SubChunk2Size uint32
+}
+
+// waveFormatExtensible is the format tag of WAVE_FORMAT_EXTENSIBLE files,
+// whose fmt chunk names the speaker each channel feeds
+const waveFormatExtensible = 0xFFFE
+
+// maxFmtChunk bounds the fmt chunks read; an extensible one is 40 bytes
+const maxFmtChunk = 1 << 10
+
+// canonicalHeaderSize is the size of a WAVHeader written as is: a 16 byte
+// fmt chunk followed directly by the data chunk
+var canonicalHeaderSize = int64(binary.Size(WAVHeader{}))
+
+// wavLayout is where the samples of a WAV file start and which speakers
+// its channels feed
+type wavLayout struct {
+	dataOffset int64
+
+	// channelMask is the dwChannelMask of extensible files, 0 for others
+	channelMask uint32
+}
+
+// readWAVHeader reads the header of WAV data up to the start of its
+// samples. The header is returned as a canonical one would be, whatever
+// chunks the file has before its data: a longer fmt chunk, as extensible
+// files have, and chunks such as fact, LIST or bext. The format of an
+// extensible file is that of its subformat. Data that isn't RIFF WAVE is
+// returned as read for the caller to reject.
+func readWAVHeader(r io.Reader) (WAVHeader, wavLayout, error) {
+	var header WAVHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return header, wavLayout{}, err
+	}
+	if string(header.ChunkID[:]) != "RIFF" || string(header.Format[:]) != "WAVE" ||
+		string(header.SubChunk1ID[:]) == "fmt " && header.SubChunk1Size == 16 && string(header.SubChunk2ID[:]) == "data" {
+		return header, wavLayout{dataOffset: canonicalHeaderSize}, nil
+	}
+
+	// Walk the chunks again from the first one
+	var read bytes.Buffer
+	binary.Write(&read, binary.LittleEndian, &header)
+	chunks := io.MultiReader(bytes.NewReader(read.Bytes()[12:]), r)
+	layout := wavLayout{dataOffset: 12}
+	hasFmt := false
+	for {
+		var chunk struct {
+			ID   [4]byte
+			Size uint32
+		}
+		if err := binary.Read(chunks, binary.LittleEndian, &chunk); err != nil {
+			return header, layout, fmt.Errorf("no data chunk: %w", err)
+		}
+		layout.dataOffset += 8
+
+		switch string(chunk.ID[:]) {
+		case "fmt ":
+			if chunk.Size < 16 || chunk.Size > maxFmtChunk {
+				return header, layout, fmt.Errorf("invalid fmt chunk of %d bytes", chunk.Size)
+			}
+			// Chunks are padded to an even size
+			data := make([]byte, chunk.Size+chunk.Size&1)
+			if _, err := io.ReadFull(chunks, data); err != nil {
+				return header, layout, fmt.Errorf("failed to read fmt chunk: %w", err)
+			}
+			header.SubChunk1ID, header.SubChunk1Size = chunk.ID, 16
+			header.AudioFormat = binary.LittleEndian.Uint16(data[0:])
+			header.NumChannels = binary.LittleEndian.Uint16(data[2:])
+			header.SampleRate = binary.LittleEndian.Uint32(data[4:])
+			header.ByteRate = binary.LittleEndian.Uint32(data[8:])
+			header.BlockAlign = binary.LittleEndian.Uint16(data[12:])
+			header.BitsPerSample = binary.LittleEndian.Uint16(data[14:])
+			if header.AudioFormat == waveFormatExtensible && chunk.Size >= 40 {
+				layout.channelMask = binary.LittleEndian.Uint32(data[20:])
+				header.AudioFormat = binary.LittleEndian.Uint16(data[24:])
+			}
+			hasFmt = true
+			layout.dataOffset += int64(len(data))
+
+		case "data":
+			if !hasFmt {
+				return header, layout, fmt.Errorf("data chunk before the fmt chunk")
+			}
+			header.SubChunk2ID, header.SubChunk2Size = chunk.ID, chunk.Size
+			return header, layout, nil
+
+		default:
+			skip := int64(chunk.Size) + int64(chunk.Size&1)
+			if _, err := io.CopyN(io.Discard, chunks, skip); err != nil {
+				return header, layout, fmt.Errorf("no data chunk: %w", err)
+			}
+			layout.dataOffset += skip
+		}
+	}
+}
+
+// AudioData holds separated channel data
+type AudioData struct {
+	LeftChannel  []float64
+	RightChannel []float64
+	SampleRate   uint32
+}
+
+// wavSource is what a wavReader decodes: an open file, or WAV data held
+// in memory
+type wavSource interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// memorySource is WAV data in memory, e.g. a file picked in a browser
+type memorySource struct {
+	*bytes.Reader
+}
+
+func (memorySource) Close() error {
+	return nil
+}
+
+// wavReader decodes a WAV file's data chunk block by block
+type wavReader struct {
+	file       wavSource
+	header     WAVHeader
+	layout     wavLayout
+	numFrames  int // frames in the data chunk, clamped to the file size
+	framesRead int
+	first      int // frame decoding started from, as window sets it
+	frameSize  int
+
+	// leftOnly skips decoding the right channel entirely
+	leftOnly bool
+
+	// selected, when set, are the channels mixed into the decoded one
+	selected []int
+
+	// mapped holds the whole file when it is memory-mapped; data is the
+	// sample data within it. Both are nil for buffered reads.
+	mapped []byte
+	data   []byte
+
+	buf      []byte
+	pcmLeft  []int16
+	pcmRight []int16
+	left     []float64
+	right    []float64
+}
+
+// openWAV opens a WAV file and validates its header, leaving the reader
+// positioned at the start of the sample data. With useMmap the file is
+// memory-mapped and samples are decoded straight from the OS page cache.
+// With leftOnly the right channel is never decoded.
+func openWAV(filename string, useMmap, leftOnly bool) (*wavReader, error) {
+	return openWAVChannels(filename, useMmap, leftOnly, nil)
+}
+
+// openWAVChannels opens a WAV file as openWAV does, decoding the channels
+// of sel mixed into one instead of the left and right channels. A nil sel
+// decodes stereo files only, as openWAV does.
+func openWAVChannels(filename string, useMmap, leftOnly bool, sel *ChannelSelection) (*wavReader, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	r, err := newWAVReader(file, fileInfo.Size(), filename, sel)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if leftOnly {
+		r.leftOnly = true
+		pcmPool.put(r.pcmRight)
+		r.pcmRight = nil
+	}
+
+	if useMmap {
+		if err := r.mmap(); err != nil {
+			// Buffered reads still work, just without the page cache benefit
+			fmt.Printf("Warning: falling back to buffered reads: %v  %v\n", filename, err)
+		}
+	}
+
+	return r, nil
+}
+
+// openWAVData validates the header of WAV data in memory, as openWAV does
+// for a file
+func openWAVData(data []byte, name string, leftOnly bool) (*wavReader, error) {
+	return openWAVSource(memorySource{bytes.NewReader(data)}, int64(len(data)), name, leftOnly, nil)
+}
+
+// openWAVSource validates the header of WAV data of size bytes read from
+// source, as openWAVChannels does for a file. The reader closes source.
+func openWAVSource(source wavSource, size int64, name string, leftOnly bool, sel *ChannelSelection) (*wavReader, error) {
+	r, err := newWAVReader(source, size, name, sel)
+	if err != nil {
+		source.Close()
+		return nil, err
+	}
+
+	if leftOnly {
+		r.leftOnly = true
+		pcmPool.put(r.pcmRight)
+		r.pcmRight = nil
+	}
+
+	return r, nil
+}
+
+// mmap maps the file into memory so readBlock decodes without copying
+func (r *wavReader) mmap() error {
+	file, ok := r.file.(*os.File)
+	if !ok {
+		return fmt.Errorf("not a file")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	mapped, err := mmapFile(file, info.Size())
+	if err != nil {
+		return fmt.Errorf("failed to map file: %w", err)
+	}
+
+	dataStart := r.layout.dataOffset
+	dataEnd := dataStart + int64(r.numFrames*r.frameSize)
+
+	r.mapped = mapped
+	r.data = mapped[dataStart:dataEnd]
+
+	blockPool.put(r.buf)
+	r.buf = nil
+
+	return nil
+}
+
+// newWAVReader reads and validates the header of an open WAV file of
+// fileSize bytes. Files of any number of channels are accepted when sel
+// picks the ones to decode, otherwise only stereo ones.
+func newWAVReader(file wavSource, fileSize int64, filename string, sel *ChannelSelection) (*wavReader, error) {
+	// Read WAV header
+	header, layout, err := readWAVHeader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WAV header: %w", err)
+	}
+
+	// Validate WAV format
+	if string(header.ChunkID[:]) != "RIFF" || string(header.Format[:]) != "WAVE" {
+		return nil, fmt.Errorf("not a valid WAV file")
+	}
+
+	var selected []int
+	if sel == nil {
+		if header.NumChannels != 2 {
+			return nil, fmt.Errorf("only stereo files are supported (found %d channels; pick some with -channels)", header.NumChannels)
+		}
+	} else {
+		if header.NumChannels < 1 || header.NumChannels > maxWAVChannels {
+			return nil, fmt.Errorf("only files of 1 to %d channels are supported (found %d channels)", maxWAVChannels, header.NumChannels)
+		}
+		if selected, err = sel.resolve(int(header.NumChannels), layout.channelMask); err != nil {
+			return nil, err
+		}
+	}
+
+	if header.BitsPerSample != 16 {
+		return nil, fmt.Errorf("only 16-bit samples are supported (found %d bits)", header.BitsPerSample)
+	}
+
+	debugf("File: %s\n", filename)
+	debugf("SampleRate: %d\n", header.SampleRate)
+	debugf("NumChannels: %d\n", header.NumChannels)
+	debugf("BitsPerSample: %d\n", header.BitsPerSample)
+	debugf("SubChunk2Size (header): %d bytes\n", header.SubChunk2Size)
+	debugf("BlockAlign: %d bytes\n", header.BlockAlign)
+	debugf("Channels: %s\n", strings.Join(speakerNames(layout.channelMask, int(header.NumChannels)), ","))
+	debugf("Data offset: %d bytes\n", layout.dataOffset)
+	debugf("File size: %d bytes\n", fileSize)
+
+	// Calculate actual audio data size
+	actualAudioDataSize := fileSize - layout.dataOffset
+
+	// Use the actual file size if header reports 0 or unrealistic size
+	audioDataSize := header.SubChunk2Size
+	if audioDataSize == 0 || int64(audioDataSize) > actualAudioDataSize {
+		fmt.Printf("Warning: Header reports SubChunk2Size=%d, but calculated actual size=%d. Using actual size.\n",
+			header.SubChunk2Size, actualAudioDataSize)
+		audioDataSize = uint32(actualAudioDataSize)
+	}
+
+	// Calculate number of samples
+	bytesPerSample := header.NumChannels * (header.BitsPerSample / 8)
+	numSamples := int(audioDataSize) / int(bytesPerSample)
+
+	debugf("Calculated audio data size: %d bytes\n", audioDataSize)
+	debugf("Bytes per sample: %d\n", bytesPerSample)
+	debugf("Number of samples: %d\n", numSamples)
+
+	frameSize := int(bytesPerSample)
+	blockFrames := decodeBlockSize / frameSize
+
+	return &wavReader{
+		file:      file,
+		header:    header,
+		layout:    layout,
+		numFrames: numSamples,
+		frameSize: frameSize,
+		selected:  selected,
+		buf:       blockPool.get(blockFrames * frameSize),
+		pcmLeft:   pcmPool.get(blockFrames),
+		pcmRight:  pcmPool.get(blockFrames),
+	}, nil
+}
+
+// probeWAV reads only the header of a WAV file and returns the stream it
+// describes, so the work on a file can be sized before decoding it
+func probeWAV(filename string) (StreamInfo, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return StreamInfo{}, err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return StreamInfo{}, fmt.Errorf("failed to get file info: %w", err)
+	}
+	return probeWAVSource(file, fileInfo.Size())
+}
+
+// probeWAVSource reads the header of WAV data of size bytes, as probeWAV
+// does for a file
+func probeWAVSource(file io.Reader, size int64) (StreamInfo, error) {
+	header, layout, err := readWAVHeader(file)
+	if err != nil {
+		return StreamInfo{}, fmt.Errorf("failed to read WAV header: %w", err)
+	}
+
+	frameSize := int64(header.NumChannels) * int64(header.BitsPerSample/8)
+	if frameSize == 0 {
+		return StreamInfo{}, fmt.Errorf("not a valid WAV file")
+	}
+
+	// Same sizing as newWAVReader: the header's data size unless it is
+	// missing or larger than the file
+	dataSize := size - layout.dataOffset
+	if size := int64(header.SubChunk2Size); size != 0 && size < dataSize {
+		dataSize = size
+	}
+
+	return StreamInfo{
+		SampleRate:  header.SampleRate,
+		NumFrames:   int(max(dataSize, 0) / frameSize),
+		NumChannels: int(header.NumChannels),
+		ChannelMask: layout.channelMask,
+	}, nil
+}
+
+// Close unmaps and closes the underlying file and returns its buffers to the
+// pools. Blocks returned by readBlock must not be used afterwards.
+func (r *wavReader) Close() error {
+	if r.mapped != nil {
+		munmapFile(r.mapped)
+		r.mapped, r.data = nil, nil
+	}
+
+	blockPool.put(r.buf)
+	pcmPool.put(r.pcmLeft)
+	pcmPool.put(r.pcmRight)
+	samplePool.put(r.left)
+	samplePool.put(r.right)
+	r.buf, r.pcmLeft, r.pcmRight, r.left, r.right = nil, nil, nil, nil, nil
+
+	return r.file.Close()
+}
+
+// seekFrame positions the reader at the given frame of the data chunk
+func (r *wavReader) seekFrame(frame int) error {
+	if frame > r.numFrames {
+		return fmt.Errorf("frame %d is past the end of the data (%d frames)", frame, r.numFrames)
+	}
+
+	if r.data == nil {
+		offset := r.layout.dataOffset + int64(frame)*int64(r.frameSize)
+		if _, err := r.file.Seek(offset, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek to frame %d: %w", frame, err)
+		}
+	}
+
+	r.framesRead = frame
+	return nil
+}
+
+// window limits decoding to frames start to end of the data chunk, or to
+// its end when end is 0, and positions the reader at start
+func (r *wavReader) window(start, end int) error {
+	if end > 0 && end < r.numFrames {
+		r.numFrames = end
+	}
+	if start >= r.numFrames && start > 0 {
+		return fmt.Errorf("-start is past the end of the file (%d frames)", r.numFrames)
+	}
+	if err := r.seekFrame(start); err != nil {
+		return err
+	}
+	r.first = start
+	return nil
+}
+
+// progress returns the fraction of frames decoded so far
+func (r *wavReader) progress() float64 {
+	if r.numFrames <= r.first {
+		return 1
+	}
+	return float64(r.framesRead-r.first) / float64(r.numFrames-r.first)
+}
+
+// readPCM decodes the next block of frames into raw left and right 16-bit
+// samples; right is nil when the reader is left-only. The returned slices are
+// reused by the next call. It returns io.EOF once the data chunk is exhausted.
+func (r *wavReader) readPCM() (left, right []int16, err error) {
+	if r.framesRead >= r.numFrames {
+		return nil, nil, io.EOF
+	}
+
+	want := min(decodeBlockSize/r.frameSize, r.numFrames-r.framesRead) * r.frameSize
+
+	var block []byte
+	var n int
+	if r.data != nil {
+		// The mapping already covers the data chunk; slice it without copying
+		offset := r.framesRead * r.frameSize
+		block = r.data[offset : offset+want]
+		n = want
+	} else {
+		// Read whole frames in one go rather than issuing a binary.Read per sample
+		n, err = io.ReadFull(r.file, r.buf[:want])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, nil, fmt.Errorf("failed to read samples at position %d: %w", r.framesRead, err)
+		}
+		block = r.buf
+	}
+
+	frames := n / r.frameSize
+	left = r.pcmLeft[:frames]
+	if !r.leftOnly {
+		right = r.pcmRight[:frames]
+	}
+
+	for i := 0; i < frames; i++ {
+		frame := block[i*r.frameSize:]
+
+		if r.selected != nil {
+			// Selected channels - mixed at equal level, so one channel
+			// comes through unchanged
+			sum := 0
+			for _, ch := range r.selected {
+				sum += int(int16(binary.LittleEndian.Uint16(frame[2*ch:])))
+			}
+			left[i] = int16(sum / len(r.selected))
+			if !r.leftOnly {
+				right[i] = left[i]
+			}
+		} else if r.header.NumChannels == 1 {
+			// Mono file - duplicate the sample into both channels
+			left[i] = int16(binary.LittleEndian.Uint16(frame))
+			if !r.leftOnly {
+				right[i] = left[i]
+			}
+		} else if r.leftOnly {
+			// Stereo file - the right sample is never touched
+			left[i] = int16(binary.LittleEndian.Uint16(frame))
+		} else {
+			// Stereo file - left and right samples are interleaved
+			left[i] = int16(binary.LittleEndian.Uint16(frame))
+			right[i] = int16(binary.LittleEndian.Uint16(frame[2:]))
+		}
+	}
+	r.framesRead += frames
+
+	if err != nil {
+		if n%r.frameSize != 0 {
+			fmt.Printf("Warning: EOF reached in the middle of frame %d\n", r.framesRead)
+		}
+		// Stop at the truncated end of the file
+		r.numFrames = r.framesRead
+	}
+
+	if frames == 0 {
+		return nil, nil, io.EOF
+	}
+
+	return left, right, nil
+}
+
+// normalize converts a block returned by readPCM into samples in [-1, 1],
+// using float buffers owned by the reader. The returned slices are reused by
+// the next call.
+func (r *wavReader) normalize(pcmLeft, pcmRight []int16) (left, right []float64) {
+	// Float buffers are only needed by callers wanting normalized samples
+	if r.left == nil {
+		r.left = samplePool.get(len(r.pcmLeft))
+		if !r.leftOnly {
+			r.right = samplePool.get(len(r.pcmRight))
+		}
+	}
+
+	// Convert to float64 and normalize to [-1, 1]
+	left = r.left[:len(pcmLeft)]
+	for i, v := range pcmLeft {
+		left[i] = float64(v) / 32767.0
+	}
+
+	if pcmRight != nil {
+		right = r.right[:len(pcmRight)]
+		for i, v := range pcmRight {
+			right[i] = float64(v) / 32767.0
+		}
+	}
+
+	return left, right
+}
+
+// ErrDecodedTooLarge is returned by parseWAVFile when holding every decoded
+// sample in memory would exceed the caller's cap; such files should be
+// streamed with a SampleIterator instead
+var ErrDecodedTooLarge = errors.New("decoded audio would exceed the size limit")
+
+// defaultMaxDecodedSize is the -max-decoded-size of commands that decode
+// whole files into memory; about 50 minutes of 44.1 kHz stereo
+const defaultMaxDecodedSize = "2GB"
+
+// decodedSize returns the bytes parseWAVFile needs for numFrames frames of both channels
+func decodedSize(numFrames int) int64 {
+	return int64(numFrames) * 2 * 8
+}
+
+// parseWAVFile reads a WAV file and extracts stereo audio data. The sample
+// slices are allocated once from the data chunk's frame count; files whose
+// decoded size would exceed maxDecodedSize bytes fail with ErrDecodedTooLarge
+// (maxDecodedSize <= 0 means no limit).
+func parseWAVFile(filename string, progress *Progress, maxDecodedSize int64) (*AudioData, error) {
+	it, err := NewSampleIterator(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	numFrames := it.NumFrames()
+	if size := decodedSize(numFrames); maxDecodedSize > 0 && size > maxDecodedSize {
+		return nil, fmt.Errorf("%w: %d frames need %d bytes, limit is %d", ErrDecodedTooLarge, numFrames, size, maxDecodedSize)
+	}
+
+	// Read audio data
+	audioData := &AudioData{
+		SampleRate:   it.SampleRate(),
+		LeftChannel:  make([]float64, numFrames),
+		RightChannel: make([]float64, numFrames),
+	}
+
+	n := 0
+	for it.Next() {
+		progress.decode(it.r.progress())
+
+		left, right := it.Frames()
+		copy(audioData.LeftChannel[n:], left)
+		copy(audioData.RightChannel[n:], right)
+		n += len(left)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	// A truncated file holds fewer frames than its data chunk declared
+	audioData.LeftChannel = audioData.LeftChannel[:n]
+	audioData.RightChannel = audioData.RightChannel[:n]
+
+	debugf("Actual samples read: %d\n", len(audioData.LeftChannel))
+	debugf("Actual duration: %.2f seconds\n", float64(len(audioData.LeftChannel))/float64(audioData.SampleRate))
+
+	if len(audioData.LeftChannel) == 0 {
+		return nil, fmt.Errorf("no audio data found in file")
+	}
+
+	progress.decode(1)
+
+	return audioData, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseWAVFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		modify    func(*TestAudio)
+		frames    int
+		amplitude float64
+		identical bool // both channels carry the same signal
+	}{
+		{"sine", func(a *TestAudio) {}, 44100, 0.8, true},
+		{"square", func(a *TestAudio) { a.Waveform = "square"; a.Amplitude = 0.5 }, 44100, 0.5, true},
+		{"low rate", func(a *TestAudio) { a.SampleRate = 8000; a.Duration = 250 * time.Millisecond }, 2000, 0.8, true},
+		{"noise", func(a *TestAudio) { a.Waveform = "noise"; a.Amplitude = 0.25 }, 44100, 0.25, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audio := DefaultTestAudio()
+			tt.modify(&audio)
+
+			data, err := parseWAVFile(writeFixture(t, audio), nil, 0)
+			if err != nil {
+				t.Fatalf("parseWAVFile: %v", err)
+			}
+
+			if data.SampleRate != uint32(audio.SampleRate) {
+				t.Errorf("SampleRate = %d, want %d", data.SampleRate, audio.SampleRate)
+			}
+			if len(data.LeftChannel) != tt.frames || len(data.RightChannel) != tt.frames {
+				t.Fatalf("decoded %d/%d frames, want %d", len(data.LeftChannel), len(data.RightChannel), tt.frames)
+			}
+
+			peak := 0.0
+			for i, v := range data.LeftChannel {
+				peak = max(peak, math.Abs(v))
+				if tt.identical && data.RightChannel[i] != v {
+					t.Fatalf("frame %d: left %v and right %v differ", i, v, data.RightChannel[i])
+				}
+			}
+			if math.Abs(peak-tt.amplitude) > 0.01 {
+				t.Errorf("peak = %.4f, want %.4f", peak, tt.amplitude)
+			}
+		})
+	}
+}
+
+func TestParseWAVFileRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*TestAudio)
+	}{
+		{"mono", func(a *TestAudio) { a.Channels = 1 }},
+		{"24-bit", func(a *TestAudio) { a.BitDepth = 24 }},
+		{"empty", func(a *TestAudio) { a.Duration = 0 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audio := DefaultTestAudio()
+			tt.modify(&audio)
+
+			if _, err := parseWAVFile(writeFixture(t, audio), nil, 0); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestParseWAVFileTruncated(t *testing.T) {
+	file := writeFixture(t, DefaultTestAudio())
+
+	// Cut the last frame in half, as an interrupted recording would
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(file, info.Size()-1002); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := parseWAVFile(file, nil, 0)
+	if err != nil {
+		t.Fatalf("parseWAVFile: %v", err)
+	}
+	if want := 44100 - 251; len(data.LeftChannel) != want {
+		t.Errorf("decoded %d frames, want %d", len(data.LeftChannel), want)
+	}
+}
+
+func TestParseWAVFileSizeLimit(t *testing.T) {
+	file := writeFixture(t, DefaultTestAudio())
+
+	if _, err := parseWAVFile(file, nil, decodedSize(44100)-1); !errors.Is(err, ErrDecodedTooLarge) {
+		t.Fatalf("err = %v, want ErrDecodedTooLarge", err)
+	}
+	if _, err := parseWAVFile(file, nil, decodedSize(44100)); err != nil {
+		t.Fatalf("parseWAVFile at the limit: %v", err)
+	}
+}
+
+func TestSampleIteratorMatchesParse(t *testing.T) {
+	audio := DefaultTestAudio()
+	audio.Waveform = "sweep"
+	audio.Duration = 3 * time.Second
+	file := writeFixture(t, audio)
+
+	data, err := parseWAVFile(file, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	it, err := NewSampleIterator(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	n := 0
+	for it.Next() {
+		left, _ := it.PCM()
+		for i, v := range left {
+			if want := toInt16(data.LeftChannel[n+i]); v != want {
+				t.Fatalf("frame %d = %d, want %d", n+i, v, want)
+			}
+		}
+		n += len(left)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data.LeftChannel) {
+		t.Errorf("iterated %d frames, want %d", n, len(data.LeftChannel))
+	}
+}
+
+// TestDecodeWAVDataMatchesFile checks that data in memory, as decoded in
+// the browser, gives the same peaks as the file
+func TestDecodeWAVDataMatchesFile(t *testing.T) {
+	audio := DefaultTestAudio()
+	audio.Waveform = "sweep"
+	file := writeFixture(t, audio)
+	opts := Options{Width: 300}
+
+	want, wantFrames, err := decodePeaks(file, opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := openWAVData(data, "fixture.wav", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	got, frames, err := decodeWAVPeaks(r, opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames != wantFrames || !reflect.DeepEqual(got, want) {
+		t.Errorf("peaks of %d frames differ from the file's %d", frames, wantFrames)
+	}
+
+	if _, err := openWAVData(data[:20], "short.wav", true); err == nil {
+		t.Error("truncated header accepted")
+	}
+}
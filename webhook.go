@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// webhookAttempts is how often a delivery is tried before giving up
+const webhookAttempts = 3
+
+// webhookRetryDelay is the wait before the first retry; it doubles after
+var webhookRetryDelay = time.Second
+
+// webhookSink POSTs events as JSON to a URL. With WEBHOOK_SECRET set, every
+// request carries X-Waveform-Signature: sha256=<HMAC-SHA256 of the body>
+// so the receiver can check where it came from.
+type webhookSink struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func newWebhookSink(location string) *webhookSink {
+	return &webhookSink{
+		url:    location,
+		secret: os.Getenv("WEBHOOK_SECRET"),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *webhookSink) Publish(ctx context.Context, event JobEvent) error {
+	return s.post(ctx, event)
+}
+
+// BatchEvent reports a whole batch
+type BatchEvent struct {
+	Input  string     `json:"input"`
+	Output string     `json:"output"`
+	Files  []JobEvent `json:"files"`
+	Failed int        `json:"failed"`
+	// Transient counts the failures that outlasted their storage retries
+	Transient int       `json:"transient_failed"`
+	Elapsed   float64   `json:"elapsed_seconds"`
+	Finished  time.Time `json:"time"`
+
+	Formatted FormattedFields `json:"formatted"`
+}
+
+// post delivers a payload, trying again after server errors and lost
+// connections. Other client errors are final.
+func (s *webhookSink) post(ctx context.Context, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for attempt := range webhookAttempts {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(webhookRetryDelay << (attempt - 1)):
+			}
+		}
+
+		retry, err := s.send(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retry {
+			return err
+		}
+		lastErr = err
+	}
+	return lastErr
+}
+
+// send makes one delivery attempt and says whether a failure is worth
+// trying again
+func (s *webhookSink) send(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.secret))
+		mac.Write(body)
+		req.Header.Set("X-Waveform-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("webhook %s: %s", s.url, resp.Status)
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookSink(t *testing.T) {
+	defer func(d time.Duration) { webhookRetryDelay = d }(webhookRetryDelay)
+	webhookRetryDelay = time.Millisecond
+	t.Setenv("WEBHOOK_SECRET", "s3cret")
+
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantErr      bool
+		wantRequests int32
+	}{
+		{"delivered", []int{http.StatusNoContent}, false, 1},
+		{"retried after a server error", []int{http.StatusBadGateway, http.StatusOK}, false, 2},
+		{"retried when throttled", []int{http.StatusTooManyRequests, http.StatusOK}, false, 2},
+		{"gives up", []int{500, 500, 500, 200}, true, webhookAttempts},
+		{"client error is final", []int{http.StatusBadRequest, http.StatusOK}, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			var got JobEvent
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := requests.Add(1)
+				body, _ := io.ReadAll(r.Body)
+
+				mac := hmac.New(sha256.New, []byte("s3cret"))
+				mac.Write(body)
+				if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); r.Header.Get("X-Waveform-Signature") != want {
+					t.Errorf("signature = %q, want %q", r.Header.Get("X-Waveform-Signature"), want)
+				}
+				if err := json.Unmarshal(body, &got); err != nil {
+					t.Errorf("body: %v", err)
+				}
+				w.WriteHeader(tt.statuses[n-1])
+			}))
+			defer server.Close()
+
+			event := newJobEvent("take.wav", FileResult{Input: "/audios/take.wav", Duration: 2.5}, "/waveforms", nil)
+			err := newWebhookSink(server.URL).Publish(context.Background(), event)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if requests.Load() != tt.wantRequests {
+				t.Errorf("%d requests, want %d", requests.Load(), tt.wantRequests)
+			}
+			if got.Input != "/audios/take.wav" || got.Duration != 2.5 || got.Output != "/waveforms" {
+				t.Errorf("delivered %+v", got)
+			}
+		})
+	}
+}
+
+func TestNewJobEvent(t *testing.T) {
+	event := newJobEvent("s3://media/take.wav", FileResult{}, "s3://media/out", io.ErrUnexpectedEOF)
+	if event.Input != "s3://media/take.wav" || event.Error != io.ErrUnexpectedEOF.Error() || event.Time.IsZero() {
+		t.Errorf("event = %+v", event)
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Job is a render job message. Input is one WAV file, as a local path or a
+// storage URL; Output is the directory or storage location its outputs go
+// to. Args are option flags for this job only, such as ["-width", "800"],
+// applied over the ones the worker was started with. Priority and Group
+// order the jobs a worker holds ahead of its free slots: higher priorities
+// run first, and groups, such as the user or project a job is for, take
+// turns within a priority.
+type Job struct {
+	Input    string   `json:"input"`
+	Output   string   `json:"output"`
+	Args     []string `json:"args,omitempty"`
+	Priority int      `json:"priority,omitempty"`
+	Group    string   `json:"group,omitempty"`
+	// SHA256, when set, is the checksum the input must match to be
+	// processed
+	SHA256 string `json:"sha256,omitempty"`
+	// Tenant names the application the job is for on workers run with
+	// -tenants
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// JobEvent reports the outcome of a job or batch file, naming its outputs
+// where they were stored
+type JobEvent struct {
+	FileResult
+	Output string `json:"output"`
+	Tenant string `json:"tenant,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Transient marks an error of storage that outlasted its retries, so
+	// the file is worth trying again, apart from permanent failures
+	Transient bool      `json:"transient,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// newJobEvent returns the event of a processed input
+func newJobEvent(input string, result FileResult, output string, err error) JobEvent {
+	event := JobEvent{FileResult: result, Output: output, Time: time.Now().UTC()}
+	if event.Input == "" {
+		event.Input = input
+	}
+	if err != nil {
+		event.Error = err.Error()
+		event.Transient = isTransientFailure(err)
+	}
+	return event
+}
+
+// EventSink is told about every finished job
+type EventSink interface {
+	Publish(ctx context.Context, event JobEvent) error
+}
+
+// openEventSink returns the sink for an -events URL: a Kafka topic, or a
+// webhook for http:// and https://
+func openEventSink(location string) (EventSink, error) {
+	switch {
+	case strings.HasPrefix(location, "kafka://"):
+		return newKafkaProducer(location)
+	case strings.HasPrefix(location, "http://"), strings.HasPrefix(location, "https://"):
+		return newWebhookSink(location), nil
+	}
+	return nil, fmt.Errorf("unknown event sink %q (want kafka://, http:// or https://)", location)
+}
+
+// workerOnlyFlags can't be set by jobs: a queue message must not be able to
+// run commands on the worker, write where it likes or pick the files it
+// reads keys and checksums from
+var workerOnlyFlags = []string{"pre-cmd", "post-cmd", "preview-cmd", "cache-dir", "decrypt-key", "checksums"}
+
+// worker processes jobs from a queue
+type worker struct {
+	queue JobQueue
+	// events is told about finished jobs; nil when not set
+	events EventSink
+	// sinks get a copy of the outputs of every job
+	sinks []OutputSink
+
+	// defaults holds the option flags the worker was started with
+	defaults *flag.FlagSet
+
+	storageConcurrency int
+	retry              RetryPolicy
+	memory             *MemoryBudget
+
+	// prefetch is how many jobs are taken ahead of the free slots, for the
+	// scheduler to choose from
+	prefetch int
+
+	// tenants requires every job to name its tenant, whose outputs go to
+	// <output>/<tenant> and whose peaks are cached apart; limit bounds the
+	// jobs of each tenant
+	tenants bool
+	limit   *RateLimiter
+}
+
+// runWorker consumes jobs from a queue until interrupted
+func runWorker(args []string) error {
+	fs := flag.NewFlagSet("worker", flag.ExitOnError)
+	queueURL := fs.String("queue", "", "job queue: redis://host:6379/0?list=waveform:jobs, sqs://sqs.<region>.amazonaws.com/<account>/<queue> or kafka://host:9092/topic?group=waveform")
+	eventsURL := fs.String("events", "", "publish an event for every finished job to kafka://host:9092/topic or POST it to an http(s):// webhook")
+	sinks := fs.String("sinks", "", "also copy every job's outputs to these comma-separated locations: directories, s3://, gs://, az://, ftp:// or sftp:// prefixes, or http(s):// URLs that are POSTed a JSON event holding them")
+	concurrency := fs.Int("concurrency", runtime.NumCPU(), "jobs processed at once")
+	prefetch := fs.Int("prefetch", 0, "jobs taken from the queue ahead of free slots, so job priorities and groups can reorder them")
+	storageConcurrency := fs.Int("storage-concurrency", 4, "concurrent downloads and uploads for remote storage")
+	retries, retryDelay := retryFlags(fs)
+	maxMemory := fs.String("max-memory", "", "limit on memory held across all jobs, e.g. 512MB (unlimited when empty)")
+	metricsAddr := fs.String("metrics-addr", "", "serve Prometheus metrics on this address, e.g. localhost:9090")
+	verboseFlag := fs.Bool("verbose", false, "print the header details of every file")
+	tenants, tenantRate, tenantBurst := tenantFlags(fs)
+	buildOptions := optionFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	verbose = *verboseFlag
+
+	if *queueURL == "" {
+		return fmt.Errorf("-queue is required")
+	}
+	// Jobs start from these options, so they have to be valid on their own
+	if _, err := buildOptions(); err != nil {
+		return err
+	}
+
+	w := &worker{defaults: fs, storageConcurrency: *storageConcurrency, prefetch: max(*prefetch, 0)}
+	w.retry = RetryPolicy{Retries: *retries, Delay: *retryDelay}
+	w.tenants = *tenants
+	var err error
+	if w.limit, err = tenantLimiter(*tenants, *tenantRate, *tenantBurst); err != nil {
+		return err
+	}
+	if *maxMemory != "" {
+		limit, err := parseByteSize(*maxMemory)
+		if err != nil {
+			return fmt.Errorf("failed to parse -max-memory: %w", err)
+		}
+		w.memory = NewMemoryBudget(limit)
+	}
+
+	w.queue, err = openQueue(*queueURL)
+	if err != nil {
+		return err
+	}
+	if *eventsURL != "" {
+		if w.events, err = openEventSink(*eventsURL); err != nil {
+			return err
+		}
+	}
+	if *sinks != "" && w.tenants {
+		return fmt.Errorf("-sinks can't be used with -tenants, which keeps the outputs of tenants apart")
+	}
+	if w.sinks, err = openOutputSinks(*sinks, w.retry); err != nil {
+		return err
+	}
+
+	if *metricsAddr != "" {
+		serveMetrics(*metricsAddr)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("Waiting for jobs on %s\n", *queueURL)
+	w.run(ctx, max(*concurrency, 1))
+	return nil
+}
+
+// run takes jobs until ctx is done, then finishes the ones it holds. A
+// slot is taken before receiving, so the worker never holds more jobs than
+// it can start plus the prefetched ones, which the scheduler picks from.
+func (w *worker) run(ctx context.Context, concurrency int) {
+	slots := make(chan struct{}, concurrency+w.prefetch)
+	scheduler := newJobScheduler()
+	var wg sync.WaitGroup
+
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := scheduler.next(); job != nil; job = scheduler.next() {
+				w.handle(job)
+				<-slots
+			}
+		}()
+	}
+
+	for ctx.Err() == nil {
+		slots <- struct{}{}
+
+		job, err := w.queue.Receive(ctx)
+		if err != nil || job == nil {
+			<-slots
+			if err != nil && ctx.Err() == nil {
+				fmt.Printf("failed to receive job: %v\n", err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Second):
+				}
+			}
+			continue
+		}
+		scheduler.push(job)
+	}
+
+	scheduler.close()
+	wg.Wait()
+}
+
+// handle processes one queued job, publishes its event and acknowledges or
+// fails it. This goes on after shutdown has begun, so that a job that was
+// taken is finished. The event goes out first: a crash in between repeats
+// an event rather than losing one.
+func (w *worker) handle(queued *QueuedJob) {
+	ctx := context.Background()
+
+	job, result, err := w.process(queued.Body)
+	if err != nil && job.Tenant != "" {
+		tenantErrors.inc(job.Tenant)
+	}
+	if w.events != nil {
+		event := newJobEvent(job.Input, result, job.Output, err)
+		event.Tenant = job.Tenant
+		if err := w.events.Publish(ctx, event); err != nil {
+			fmt.Printf("failed to publish job event: %v  %v\n", job.Input, err)
+		}
+	}
+
+	if err != nil {
+		fmt.Printf("job failed: %v  %s\n", err, queued.Body)
+		if err := w.queue.Fail(ctx, queued); err != nil {
+			fmt.Printf("failed to mark job as failed: %v\n", err)
+		}
+		return
+	}
+
+	if err := w.queue.Ack(ctx, queued); err != nil {
+		fmt.Printf("failed to acknowledge job: %v\n", err)
+	}
+}
+
+// process runs the job in a message
+func (w *worker) process(body []byte) (Job, FileResult, error) {
+	var job Job
+	if err := json.Unmarshal(body, &job); err != nil {
+		return job, FileResult{}, fmt.Errorf("bad job message: %w", err)
+	}
+	if job.Input == "" || job.Output == "" {
+		return job, FileResult{}, fmt.Errorf("bad job message: input and output are required")
+	}
+
+	if w.tenants {
+		if err := validateTenant(job.Tenant); err != nil {
+			return job, FileResult{}, fmt.Errorf("bad job message: %w", err)
+		}
+		tenantRequests.inc(job.Tenant)
+		w.limit.Wait(context.Background(), job.Tenant)
+		job.Output = tenantLocation(job.Output, job.Tenant)
+	} else {
+		job.Tenant = ""
+	}
+
+	opts, err := w.jobOptions(job.Args)
+	if err != nil {
+		return job, FileResult{}, err
+	}
+	if job.Tenant != "" && opts.CacheDir != "" {
+		opts.CacheDir = filepath.Join(opts.CacheDir, job.Tenant)
+	}
+
+	dir, name := splitLocation(job.Input)
+	if job.SHA256 != "" {
+		if opts.Checksums, err = singleChecksum(name, job.SHA256); err != nil {
+			return job, FileResult{}, fmt.Errorf("bad job message: %w", err)
+		}
+	}
+	batch, err := newStorageBatch(dir, job.Output, w.storageConcurrency)
+	if err != nil {
+		return job, FileResult{}, err
+	}
+	defer batch.Close()
+	batch.retry = w.retry
+	batch.sinks = w.sinks
+
+	queueDepth.add(1)
+	result, err := processBatchFile(ObjectInfo{Name: name}, job.Output, batch, opts)
+	return job, result, err
+}
+
+// jobOptions returns the options of a job: the worker's own with any the
+// job sets on top
+func (w *worker) jobOptions(args []string) (Options, error) {
+	fs := flag.NewFlagSet("job", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	buildOptions := optionFlags(fs)
+
+	w.defaults.Visit(func(f *flag.Flag) {
+		if fs.Lookup(f.Name) != nil {
+			fs.Set(f.Name, f.Value.String())
+		}
+	})
+
+	if err := fs.Parse(args); err != nil {
+		return Options{}, fmt.Errorf("bad job args: %w", err)
+	}
+	if fs.NArg() > 0 {
+		return Options{}, fmt.Errorf("bad job args: unexpected %q", fs.Arg(0))
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, name := range workerOnlyFlags {
+			if f.Name == name && !isFlagSet(w.defaults, name) {
+				err = fmt.Errorf("bad job args: -%s can only be set on the worker command line", name)
+			}
+		}
+	})
+	if err != nil {
+		return Options{}, err
+	}
+
+	opts, err := buildOptions()
+	if err != nil {
+		return Options{}, fmt.Errorf("bad job args: %w", err)
+	}
+	opts.Memory = w.memory
+	return opts, nil
+}
+
+// isFlagSet reports whether a flag was given on the command line
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// splitLocation splits the location of a file into that of its directory
+// and its name, for local paths and storage URLs alike
+func splitLocation(location string) (string, string) {
+	i := strings.LastIndex(location, "/")
+	if i < 0 {
+		return ".", location
+	}
+	return location[:i], location[i+1:]
+}
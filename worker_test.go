@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// memoryQueue is a JobQueue over a fixed set of messages
+type memoryQueue struct {
+	mu     sync.Mutex
+	jobs   [][]byte
+	acked  []string
+	failed []string
+	// done is closed once every job has been acked or failed
+	done chan struct{}
+}
+
+func newMemoryQueue(jobs ...[]byte) *memoryQueue {
+	return &memoryQueue{jobs: jobs, done: make(chan struct{})}
+}
+
+func (q *memoryQueue) Receive(ctx context.Context) (*QueuedJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.jobs) == 0 {
+		return nil, nil
+	}
+	job := &QueuedJob{Body: q.jobs[0]}
+	q.jobs = q.jobs[1:]
+	return job, nil
+}
+
+func (q *memoryQueue) Ack(ctx context.Context, job *QueuedJob) error {
+	q.finish(&q.acked, job)
+	return nil
+}
+
+func (q *memoryQueue) Fail(ctx context.Context, job *QueuedJob) error {
+	q.finish(&q.failed, job)
+	return nil
+}
+
+func (q *memoryQueue) finish(list *[]string, job *QueuedJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	*list = append(*list, string(job.Body))
+	if len(q.jobs) == 0 && len(q.acked)+len(q.failed) > 0 {
+		select {
+		case <-q.done:
+		default:
+			close(q.done)
+		}
+	}
+}
+
+// recordingSink keeps the events published to it
+type recordingSink struct {
+	mu     sync.Mutex
+	events []JobEvent
+}
+
+func (s *recordingSink) Publish(ctx context.Context, event JobEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+// newTestWorker returns a worker started with the given option flags
+func newTestWorker(t *testing.T, queue JobQueue, args ...string) *worker {
+	t.Helper()
+
+	fs := flag.NewFlagSet("worker", flag.ContinueOnError)
+	optionFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return &worker{queue: queue, defaults: fs, storageConcurrency: 1}
+}
+
+func TestJobOptions(t *testing.T) {
+	w := newTestWorker(t, nil, "-width", "640", "-height", "120", "-post-cmd", "true")
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantWidth  int
+		wantHeight int
+		wantErr    bool
+	}{
+		{"worker defaults", nil, 640, 120, false},
+		{"job override", []string{"-width", "800"}, 800, 120, false},
+		{"worker-only flag set by the worker", []string{"-post-cmd", "echo"}, 640, 120, false},
+		{"worker-only flag", []string{"-pre-cmd", "rm -rf /"}, 0, 0, true},
+		{"unknown flag", []string{"-nope"}, 0, 0, true},
+		{"stray argument", []string{"file.wav"}, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := w.jobOptions(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (opts.Width != tt.wantWidth || opts.Height != tt.wantHeight) {
+				t.Errorf("size = %dx%d, want %dx%d", opts.Width, opts.Height, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+func TestSplitLocation(t *testing.T) {
+	tests := []struct {
+		location, dir, name string
+	}{
+		{"take.wav", ".", "take.wav"},
+		{"/data/in/take.wav", "/data/in", "take.wav"},
+		{"s3://bucket/in/take.wav", "s3://bucket/in", "take.wav"},
+		{"s3://bucket/take.wav", "s3://bucket", "take.wav"},
+	}
+
+	for _, tt := range tests {
+		dir, name := splitLocation(tt.location)
+		if dir != tt.dir || name != tt.name {
+			t.Errorf("splitLocation(%q) = %q, %q, want %q, %q", tt.location, dir, name, tt.dir, tt.name)
+		}
+	}
+}
+
+func TestWorkerProcessesJobs(t *testing.T) {
+	input := writeFixture(t, DefaultTestAudio())
+	output := t.TempDir()
+
+	good, _ := json.Marshal(Job{Input: input, Output: output, Args: []string{"-width", "300"}})
+	missing, _ := json.Marshal(Job{Input: filepath.Join(t.TempDir(), "missing.wav"), Output: output})
+	queue := newMemoryQueue(good, missing, []byte("not json"))
+
+	w := newTestWorker(t, queue, "-height", "100")
+	events := &recordingSink{}
+	w.events = events
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-queue.done
+		cancel()
+	}()
+	w.run(ctx, 2)
+
+	if len(queue.acked) != 1 || queue.acked[0] != string(good) {
+		t.Errorf("acked %q, want only the good job", queue.acked)
+	}
+	if len(queue.failed) != 2 {
+		t.Errorf("failed %q, want the missing input and the bad message", queue.failed)
+	}
+	if _, err := os.Stat(filepath.Join(output, "fixture.png")); err != nil {
+		t.Errorf("no waveform written: %v", err)
+	}
+
+	succeeded := 0
+	for _, event := range events.events {
+		if event.Error != "" {
+			continue
+		}
+		succeeded++
+		if event.Input != input || event.Output != output || len(event.Outputs) != 1 || event.Outputs[0] != filepath.Join(output, "fixture.png") {
+			t.Errorf("event = %+v", event)
+		}
+	}
+	if len(events.events) != 3 || succeeded != 1 {
+		t.Errorf("events = %+v, want one per job with only the good one succeeding", events.events)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// minTierBuckets is the fewest buckets BuildPeakTiers halves a tier to
+const minTierBuckets = 256
+
+// PeakTiers are the peaks of one file at several resolutions, finest
+// first, so a viewer zooming in and out picks the tier that suits each
+// view and resamples it instead of decoding the audio again. Buckets are
+// timed by the SamplesPerPixel of their tier.
+type PeakTiers []*Peaks
+
+// ZoomView is the peaks of a stretch of a file resampled to an image width
+type ZoomView struct {
+	// Tier is the index of the tier the view was resampled from
+	Tier int
+
+	// Start and End are the stretch shown; views past the end of the
+	// file are padded with silence
+	Start, End time.Duration
+
+	// SamplesPerPixel is how many frames each bucket of the view covers,
+	// fractional as zooms rarely land on whole frames
+	SamplesPerPixel float64
+
+	// Channels has the width buckets of every channel
+	Channels []ChannelPeaks
+}
+
+// BuildPeakTiers derives coarser tiers from the finest peaks of a file,
+// halving the buckets each time down to minTierBuckets
+func BuildPeakTiers(finest *Peaks) PeakTiers {
+	tiers := PeakTiers{finest}
+	for p := finest; p.Len() > minTierBuckets; {
+		next := &Peaks{SampleRate: p.SampleRate, SamplesPerPixel: p.SamplesPerPixel * 2}
+		for _, ch := range p.Channels {
+			next.Channels = append(next.Channels, resampleBuckets(ch, 0, 2, (p.Len()+1)/2))
+		}
+		tiers = append(tiers, next)
+		p = next
+	}
+	return tiers
+}
+
+// LoadPeakTiers reads peak files of one file at different resolutions,
+// as -cache-dir keeps one per width or render-peaks is given, into tiers
+// ordered finest first
+func LoadPeakTiers(filenames ...string) (PeakTiers, error) {
+	var tiers PeakTiers
+	for _, filename := range filenames {
+		peaks, err := readPeaksAny(filename)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		if peaks.SamplesPerPixel == 0 || peaks.Len() == 0 {
+			return nil, fmt.Errorf("%s: no buckets of a known size", filename)
+		}
+		if len(tiers) > 0 && (peaks.SampleRate != tiers[0].SampleRate || len(peaks.Channels) != len(tiers[0].Channels)) {
+			return nil, fmt.Errorf("%s: %d Hz and %d channels, unlike %d Hz and %d channels of the other peaks",
+				filename, peaks.SampleRate, len(peaks.Channels), tiers[0].SampleRate, len(tiers[0].Channels))
+		}
+		tiers = append(tiers, peaks)
+	}
+	if len(tiers) == 0 {
+		return nil, fmt.Errorf("no peak files")
+	}
+	slices.SortStableFunc(tiers, func(a, b *Peaks) int {
+		return cmp.Compare(a.SamplesPerPixel, b.SamplesPerPixel)
+	})
+	return tiers, nil
+}
+
+// Duration returns how much audio the finest tier covers
+func (t PeakTiers) Duration() time.Duration {
+	seconds := framesToSeconds(t[0].Len()*int(t[0].SamplesPerPixel), t[0].SampleRate)
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// Zoom returns the stretch from start to end of the file in width buckets.
+// It resamples the coarsest tier that still has a bucket for every pixel,
+// merging the buckets each pixel covers; views finer than the finest tier
+// stretch its buckets over several pixels. An end of 0 is the end of the file.
+func (t PeakTiers) Zoom(start, end time.Duration, width int) (ZoomView, error) {
+	if len(t) == 0 {
+		return ZoomView{}, fmt.Errorf("no peaks to zoom")
+	}
+	if end == 0 {
+		end = t.Duration()
+	}
+	if width <= 0 {
+		return ZoomView{}, fmt.Errorf("zoom width must be positive")
+	}
+	if start < 0 || end <= start {
+		return ZoomView{}, fmt.Errorf("zoom from %v to %v is empty", start, end)
+	}
+	if start >= t.Duration() {
+		return ZoomView{}, fmt.Errorf("zoom from %v starts past the end of the file (%v)", start, t.Duration())
+	}
+
+	rate := float64(t[0].SampleRate)
+	perPixel := (end - start).Seconds() * rate / float64(width)
+	tier := 0
+	for i, p := range t {
+		if float64(p.SamplesPerPixel) <= perPixel {
+			tier = i
+		}
+	}
+
+	p := t[tier]
+	first := start.Seconds() * rate / float64(p.SamplesPerPixel)
+	bucketsPerPixel := perPixel / float64(p.SamplesPerPixel)
+	view := ZoomView{Tier: tier, Start: start, End: end, SamplesPerPixel: perPixel}
+	for _, ch := range p.Channels {
+		view.Channels = append(view.Channels, resampleBuckets(ch, first, bucketsPerPixel, width))
+	}
+	return view, nil
+}
+
+// resampleBuckets returns width buckets of which bucket x merges the
+// buckets of peaks from first+x*perPixel up to the next one, or repeats
+// the bucket it falls in when perPixel is below 1. Buckets past the end
+// are silent.
+func resampleBuckets(peaks ChannelPeaks, first, perPixel float64, width int) ChannelPeaks {
+	out := ChannelPeaks{Min: make([]int16, width), Max: make([]int16, width)}
+	numBuckets := len(peaks.Min)
+	for x := range width {
+		lo := int(math.Floor(first + float64(x)*perPixel))
+		hi := max(lo+1, int(math.Floor(first+float64(x+1)*perPixel)))
+		lo, hi = max(lo, 0), min(hi, numBuckets)
+		if lo >= hi {
+			continue
+		}
+		minPeak, maxPeak := peaks.Min[lo], peaks.Max[lo]
+		for i := lo + 1; i < hi; i++ {
+			minPeak = min(minPeak, peaks.Min[i])
+			maxPeak = max(maxPeak, peaks.Max[i])
+		}
+		out.Min[x], out.Max[x] = minPeak, maxPeak
+	}
+	return out
+}
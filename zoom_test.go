@@ -0,0 +1,90 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// spikePeaks returns n buckets of samplesPerPixel frames at 1 kHz, quiet
+// but for a spike in bucket spike
+func spikePeaks(n, samplesPerPixel, spike int) *Peaks {
+	ch := newChannelPeaks(n)
+	for i := range n {
+		ch.Min[i], ch.Max[i] = -100, 100
+	}
+	ch.Min[spike], ch.Max[spike] = -30000, 30000
+	return &Peaks{SampleRate: 1000, SamplesPerPixel: uint32(samplesPerPixel), Channels: []ChannelPeaks{ch}}
+}
+
+func TestBuildPeakTiers(t *testing.T) {
+	tiers := BuildPeakTiers(spikePeaks(1000, 10, 777))
+	if len(tiers) != 3 || tiers[1].Len() != 500 || tiers[2].Len() != 250 || tiers[2].SamplesPerPixel != 40 {
+		t.Fatalf("%d tiers", len(tiers))
+	}
+	if ch := tiers[2].Channels[0]; ch.Max[194] != 30000 || ch.Max[193] != 100 || ch.Min[194] != -30000 {
+		t.Errorf("spike of bucket 777 is at %v", ch.Max[192:196])
+	}
+}
+
+func TestZoom(t *testing.T) {
+	tiers := BuildPeakTiers(spikePeaks(1000, 10, 777))
+	if tiers.Duration() != 10*time.Second {
+		t.Fatalf("duration %v", tiers.Duration())
+	}
+
+	// The whole file in 250 pixels needs no finer tier than the coarsest
+	view, err := tiers.Zoom(0, 0, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.Tier != 2 || view.End != 10*time.Second || view.SamplesPerPixel != 40 || view.Channels[0].Max[194] != 30000 {
+		t.Errorf("whole file: tier %d to %v at %v spp", view.Tier, view.End, view.SamplesPerPixel)
+	}
+
+	// A second around the spike in 100 pixels takes the finest tier
+	view, err = tiers.Zoom(7*time.Second, 8*time.Second, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.Tier != 0 || view.Channels[0].Max[77] != 30000 || view.Channels[0].Max[76] != 100 {
+		t.Errorf("7s to 8s: tier %d, %v", view.Tier, view.Channels[0].Max[75:79])
+	}
+
+	// Zooming in further stretches the finest buckets, past the end is silent
+	view, err = tiers.Zoom(7770*time.Millisecond, 10100*time.Millisecond, 2330)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch := view.Channels[0]; ch.Max[0] != 30000 || ch.Max[9] != 30000 || ch.Max[10] != 100 || ch.Max[2329] != 0 {
+		t.Errorf("stretched %v ... %v", ch.Max[:12], ch.Max[2327:])
+	}
+
+	for _, bad := range [][2]time.Duration{{-time.Second, 0}, {2 * time.Second, time.Second}, {11 * time.Second, 0}} {
+		if _, err := tiers.Zoom(bad[0], bad[1], 100); err == nil {
+			t.Errorf("zoom from %v to %v accepted", bad[0], bad[1])
+		}
+	}
+}
+
+func TestLoadPeakTiers(t *testing.T) {
+	dir := t.TempDir()
+	fine, coarse := filepath.Join(dir, "fine.peaks"), filepath.Join(dir, "coarse.peaks")
+	WritePeaksFile(fine, spikePeaks(1000, 10, 777))
+	WritePeaksFile(coarse, spikePeaks(100, 100, 77))
+
+	tiers, err := LoadPeakTiers(coarse, fine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tiers) != 2 || tiers[0].SamplesPerPixel != 10 {
+		t.Errorf("tiers are not finest first")
+	}
+
+	other := spikePeaks(100, 100, 77)
+	other.SampleRate = 48000
+	WritePeaksFile(coarse, other)
+	if _, err := LoadPeakTiers(fine, coarse); err == nil {
+		t.Error("peaks of different sample rates loaded as tiers of one audio")
+	}
+}